@@ -0,0 +1,174 @@
+//! Time and heap of reading a generated verbose log: parsed in memory, and streamed through
+//! `execlog::Records` without keeping the spawns. Then of merging two copies of it as
+//! --merge-dedup does, whose index borrows the labels, mnemonics and outputs it is keyed by.
+//! Last, what `timing::CountingAllocator` costs a run that sets neither --debug-timing nor
+//! --memprofile: the binary installs it on every run, and it then only checks a flag.
+//!
+//! `cargo bench --bench parse` runs it; `BENCH_SPAWNS` sets how many spawns the log holds
+//! (default 20000, about 125 MB).
+
+use bzl_exec_log_parser::dedupe::merge_logs;
+use bzl_exec_log_parser::execlog::{self, Records};
+use bzl_exec_log_parser::format::{format_bytes, format_duration};
+use bzl_exec_log_parser::proto::{Digest, File, SpawnExec, SpawnMetrics};
+use bzl_exec_log_parser::timing::CountingAllocator;
+use prost::Message;
+use std::alloc::{GlobalAlloc, Layout, System};
+use std::hint::black_box;
+use std::io::Cursor;
+use std::sync::atomic::{AtomicI64, AtomicU64, Ordering};
+use std::time::{Duration, Instant};
+
+/// The system allocator, counting allocations, bytes allocated and the peak of those live.
+struct Counting;
+
+static ALLOCATIONS: AtomicU64 = AtomicU64::new(0);
+static ALLOCATED: AtomicU64 = AtomicU64::new(0);
+static LIVE: AtomicI64 = AtomicI64::new(0);
+static PEAK: AtomicI64 = AtomicI64::new(0);
+
+unsafe impl GlobalAlloc for Counting {
+    unsafe fn alloc(&self, layout: Layout) -> *mut u8 {
+        ALLOCATIONS.fetch_add(1, Ordering::Relaxed);
+        ALLOCATED.fetch_add(layout.size() as u64, Ordering::Relaxed);
+        let live = LIVE.fetch_add(layout.size() as i64, Ordering::Relaxed) + layout.size() as i64;
+        PEAK.fetch_max(live, Ordering::Relaxed);
+        unsafe { System.alloc(layout) }
+    }
+
+    unsafe fn dealloc(&self, block: *mut u8, layout: Layout) {
+        LIVE.fetch_sub(layout.size() as i64, Ordering::Relaxed);
+        unsafe { System.dealloc(block, layout) }
+    }
+}
+
+#[global_allocator]
+static ALLOCATOR: Counting = Counting;
+
+/// Runs `step` and prints its time, the bytes it allocated and its peak heap above what was
+/// live before it.
+fn measure<T>(name: &str, step: impl FnOnce() -> T) -> T {
+    let (allocated, live) = (ALLOCATED.load(Ordering::Relaxed), LIVE.load(Ordering::Relaxed));
+    PEAK.store(live, Ordering::Relaxed);
+    let started = Instant::now();
+    let result = black_box(step());
+    let elapsed = started.elapsed();
+    println!(
+        "{:<32} {:>10} {:>12} allocated {:>12} peak",
+        name,
+        format_duration(elapsed, 3),
+        format_bytes((ALLOCATED.load(Ordering::Relaxed) - allocated) as i64),
+        format_bytes(PEAK.load(Ordering::Relaxed) - live)
+    );
+    result
+}
+
+/// The time per allocation and free of 64 bytes through `allocator`, over `rounds` of them.
+fn allocation_time(allocator: &impl GlobalAlloc, rounds: u32) -> Duration {
+    let layout = Layout::from_size_align(64, 8).unwrap();
+    let started = Instant::now();
+    for _ in 0..rounds {
+        unsafe {
+            let block = black_box(allocator.alloc(layout));
+            allocator.dealloc(block, layout);
+        }
+    }
+    started.elapsed() / rounds
+}
+
+fn file(path: String) -> File {
+    File {
+        digest: Some(Digest {
+            hash: format!("{:064x}", path.len() * 7919),
+            size_bytes: 4_096,
+            hash_function_name: "SHA-256".to_string(),
+        }),
+        path,
+        ..Default::default()
+    }
+}
+
+/// A spawn of a Java-like build: a shared toolchain, the sources of its package and one output.
+fn spawn(index: usize) -> SpawnExec {
+    let package = format!("services/svc{}/lib{}", index % 97, index % 13);
+    let mnemonic = ["Javac", "CppCompile", "GoCompile", "TestRunner"][index % 4];
+    let time = |millis: u64| {
+        Some(prost_types::Duration {
+            seconds: (millis / 1_000) as i64,
+            nanos: (millis % 1_000 * 1_000_000) as i32,
+        })
+    };
+    let mut inputs: Vec<File> = (0..30)
+        .map(|i| file(format!("external/jdk/lib/modules/toolchain_{:02}.jar", i)))
+        .collect();
+    inputs.extend((0..20).map(|i| file(format!("{}/src/File{}_{}.java", package, index, i))));
+    SpawnExec {
+        command_args: vec![mnemonic.to_lowercase(), format!("--target={}", index)],
+        inputs,
+        listed_outputs: vec![format!("bazel-out/k8-fastbuild/bin/{}/out{}.jar", package, index)],
+        actual_outputs: vec![file(format!(
+            "bazel-out/k8-fastbuild/bin/{}/out{}.jar",
+            package, index
+        ))],
+        mnemonic: mnemonic.to_string(),
+        target_label: format!("//{}:target{}", package, index),
+        runner: ["linux-sandbox", "remote cache hit", "worker", "remote"][index % 4].to_string(),
+        cache_hit: index % 4 == 1,
+        cacheable: true,
+        remotable: true,
+        metrics: Some(SpawnMetrics {
+            total_time: time(50 + (index as u64 * 37) % 9_000),
+            execution_wall_time: time(40 + (index as u64 * 37) % 8_000),
+            ..Default::default()
+        }),
+        ..Default::default()
+    }
+}
+
+fn main() {
+    let spawns: usize = std::env::var("BENCH_SPAWNS")
+        .ok()
+        .and_then(|count| count.parse().ok())
+        .unwrap_or(20_000);
+    let log: Vec<u8> = (0..spawns)
+        .flat_map(|index| spawn(index).encode_length_delimited_to_vec())
+        .collect();
+    println!("Verbose log of {} spawns, {}", spawns, format_bytes(log.len() as i64));
+
+    let allocations = ALLOCATIONS.load(Ordering::Relaxed);
+    let parsed = measure("execlog::parse", || execlog::parse(&log, false).unwrap());
+    let allocations = ALLOCATIONS.load(Ordering::Relaxed) - allocations;
+    assert_eq!(parsed.spawns.len(), spawns);
+
+    let streamed = measure("Records (spawns not kept)", || {
+        Records::new(Cursor::new(&log), false)
+            .unwrap()
+            .map(|spawn| spawn.unwrap().inputs.len())
+            .sum::<usize>()
+    });
+    assert_eq!(streamed, spawns * 50);
+
+    // The heap figures cover the index and the merged list, not the logs it moves from.
+    let logs = vec![parsed.spawns.clone(), parsed.spawns];
+    let (merged, summary) = measure("dedupe::merge_logs (2 copies)", || merge_logs(logs));
+    assert_eq!(merged.len(), spawns);
+    assert_eq!(summary.dropped, spawns);
+
+    // CountingAllocator is not counting here: neither flag is set in this process. Both are timed
+    // in turn, five times, and the fastest of each kept, so that neither gets a cold start.
+    let rounds = 2_000_000;
+    let (mut system, mut counting) = (Duration::MAX, Duration::MAX);
+    for _ in 0..5 {
+        system = system.min(allocation_time(&System, rounds));
+        counting = counting.min(allocation_time(&CountingAllocator, rounds));
+    }
+    let overhead = counting.saturating_sub(system);
+    println!(
+        "{:<32} {:>10} per allocation, {:?} with System; {} over the {} of execlog::parse",
+        "CountingAllocator (not counting)",
+        format!("{:?}", counting),
+        system,
+        format_duration(overhead * allocations as u32, 3),
+        allocations
+    );
+}
@@ -3,9 +3,10 @@ use std::io::Result;
 fn main() -> Result<()> {
     // Configure prost to generate basic protobuf support
     let mut config = prost_build::Config::new();
-    config.compile_protos(&["spawn.proto"], &["."])?;
+    config.compile_protos(&["spawn.proto", "remote_execution.proto"], &["."])?;
     
     println!("cargo:rerun-if-changed=spawn.proto");
+    println!("cargo:rerun-if-changed=remote_execution.proto");
     
     Ok(())
 }
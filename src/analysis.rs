@@ -0,0 +1,324 @@
+//! Aggregates over parsed spawns, as the reports compute them, for programs using the crate as
+//! a library. None of them prints anything.
+
+use crate::classify::{is_cache_hit, split_configuration};
+use crate::metrics::{output_bytes, to_std_duration, total_time};
+use crate::proto::SpawnExec;
+use crate::reports::cache::downloaded_bytes;
+use std::collections::HashMap;
+use std::hash::Hash;
+use std::time::Duration;
+
+/// The headline numbers of a set of spawns, as the summary section and `--quiet` show them.
+#[derive(Default)]
+pub struct Summary {
+    pub actions: usize,
+    /// Spawns with `cache_hit` set.
+    pub cache_hits: usize,
+    /// Summed total time of every spawn.
+    pub total_time: Duration,
+}
+
+impl Summary {
+    /// Counts one more spawn, e.g. from [`crate::execlog::Records`].
+    pub fn add(&mut self, spawn: &SpawnExec) {
+        self.actions += 1;
+        self.cache_hits += usize::from(spawn.cache_hit);
+        self.total_time += total_time(spawn);
+    }
+
+    /// Adds the totals of another set of spawns, e.g. another log summarized on its own
+    /// thread. Counts and times add up the same in any order.
+    pub fn absorb(&mut self, other: &Summary) {
+        self.actions += other.actions;
+        self.cache_hits += other.cache_hits;
+        self.total_time += other.total_time;
+    }
+
+    /// Share of the spawns that hit the cache, in percent; 0 for no spawns.
+    pub fn hit_rate(&self) -> f64 {
+        if self.actions == 0 {
+            0.0
+        } else {
+            self.cache_hits as f64 / self.actions as f64 * 100.0
+        }
+    }
+}
+
+/// Summarizes `spawns`, e.g. a slice or those a [`crate::filter::Predicate`] selects from it;
+/// [`Summary::add`] does the same one spawn at a time.
+pub fn summarize<'a>(spawns: impl IntoIterator<Item = &'a SpawnExec>) -> Summary {
+    let mut summary = Summary::default();
+    for spawn in spawns {
+        summary.add(spawn);
+    }
+    summary
+}
+
+/// Headline numbers of one log, as `diff`, `compare`, `trend` and `check` compare them.
+#[derive(Default)]
+pub struct LogTotals {
+    pub actions: u64,
+    pub cache_hits: u64,
+    pub total_time: Duration,
+    pub downloaded_bytes: i64,
+}
+
+impl LogTotals {
+    pub fn from_spawns(spawns: &[SpawnExec]) -> Self {
+        let mut totals = LogTotals::default();
+        for spawn in spawns {
+            totals.add(spawn);
+        }
+        totals
+    }
+
+    pub fn add(&mut self, spawn: &SpawnExec) {
+        self.actions += 1;
+        self.cache_hits += is_cache_hit(spawn) as u64;
+        self.total_time += total_time(spawn);
+        self.downloaded_bytes += downloaded_bytes(std::slice::from_ref(spawn));
+    }
+
+    pub fn hit_rate(&self) -> f64 {
+        if self.actions == 0 {
+            0.0
+        } else {
+            self.cache_hits as f64 / self.actions as f64 * 100.0
+        }
+    }
+}
+
+/// Identifies an action across two logs by its label, mnemonic and primary (first) output,
+/// with the `bazel-out/<config>/` prefix removed so that renamed configurations still match.
+pub fn action_key(spawn: &SpawnExec) -> (&str, &str, &str) {
+    let output = spawn
+        .listed_outputs
+        .first()
+        .map(String::as_str)
+        .or_else(|| spawn.actual_outputs.first().map(|f| f.path.as_str()))
+        .unwrap_or("");
+    let output = split_configuration(output).map_or(output, |(_, rest)| rest);
+    (&spawn.target_label, &spawn.mnemonic, output)
+}
+
+/// Per-mnemonic totals behind the "Analysis by Mnemonic" table, and the totals of each row of
+/// the --group-by tables.
+#[derive(Default)]
+pub struct MnemonicMetrics {
+    pub count: u64,
+    pub cache_hits: u64,
+    pub total_duration: Duration,
+    /// Summed total time of the spawns that missed the cache.
+    pub miss_duration: Duration,
+    /// Recorded total times of the mnemonic's spawns, for percentile columns.
+    pub durations: Vec<Duration>,
+    /// Spawns that recorded both execution wall time and total time, with their sums.
+    pub exec_samples: u64,
+    pub exec_wall_time: Duration,
+    pub exec_total_time: Duration,
+    /// Summed size of the spawns' outputs.
+    pub output_bytes: i64,
+}
+
+impl MnemonicMetrics {
+    /// Share of the spawns that hit the cache, in percent.
+    pub fn hit_rate(&self) -> f64 {
+        self.cache_hits as f64 / self.count as f64 * 100.0
+    }
+
+    /// Mean recorded total time, leaving out spawns without one.
+    pub fn average(&self) -> Option<Duration> {
+        (!self.durations.is_empty()).then(|| self.total_duration / self.durations.len() as u32)
+    }
+
+    /// Execution wall time as a fraction of total time, over the spawns that recorded both;
+    /// `None` when fewer than half of the spawns did, as the ratio would misrepresent the
+    /// mnemonic.
+    pub fn exec_fraction(&self) -> Option<f64> {
+        (self.exec_samples * 2 >= self.count && !self.exec_total_time.is_zero()).then(|| {
+            self.exec_wall_time.as_secs_f64() / self.exec_total_time.as_secs_f64()
+        })
+    }
+
+    /// Counts one more spawn of the mnemonic.
+    pub fn add(&mut self, spawn: &SpawnExec) {
+        self.count += 1;
+        self.output_bytes += output_bytes(spawn);
+        if spawn.cache_hit {
+            self.cache_hits += 1;
+        }
+        if let Some(m) = spawn.metrics.as_ref().and_then(|m| m.total_time.as_ref()) {
+            let duration = to_std_duration(m);
+            self.total_duration += duration;
+            if !spawn.cache_hit {
+                self.miss_duration += duration;
+            }
+            self.durations.push(duration);
+            if let Some(wall) = spawn
+                .metrics
+                .as_ref()
+                .and_then(|m| m.execution_wall_time.as_ref())
+            {
+                self.exec_samples += 1;
+                self.exec_wall_time += to_std_duration(wall);
+                self.exec_total_time += duration;
+            }
+        }
+    }
+
+    /// Adds another mnemonic's totals, for the aggregated row of a truncated table, or the same
+    /// mnemonic's totals in another log.
+    pub fn absorb(&mut self, other: &MnemonicMetrics) {
+        self.count += other.count;
+        self.cache_hits += other.cache_hits;
+        self.total_duration += other.total_duration;
+        self.miss_duration += other.miss_duration;
+        self.durations.extend_from_slice(&other.durations);
+        self.exec_samples += other.exec_samples;
+        self.exec_wall_time += other.exec_wall_time;
+        self.exec_total_time += other.exec_total_time;
+        self.output_bytes += other.output_bytes;
+    }
+}
+
+/// Totals of `spawns` by mnemonic.
+pub fn mnemonic_metrics<'a>(
+    spawns: impl IntoIterator<Item = &'a SpawnExec>,
+) -> HashMap<String, MnemonicMetrics> {
+    group_metrics(spawns, |spawn| spawn.mnemonic.clone())
+}
+
+/// Totals of `spawns` by the key `key` reads off each of them, e.g. its runner.
+pub fn group_metrics<'a, K: Eq + Hash>(
+    spawns: impl IntoIterator<Item = &'a SpawnExec>,
+    mut key: impl FnMut(&'a SpawnExec) -> K,
+) -> HashMap<K, MnemonicMetrics> {
+    let mut groups: HashMap<K, MnemonicMetrics> = HashMap::new();
+    for spawn in spawns {
+        groups.entry(key(spawn)).or_default().add(spawn);
+    }
+    groups
+}
+
+/// Adds the per-mnemonic totals of another set of spawns, e.g. another log, to `into`. Like
+/// [`Summary::absorb`], the result does not depend on the order logs are merged in.
+pub fn merge_mnemonic_metrics(
+    into: &mut HashMap<String, MnemonicMetrics>,
+    other: HashMap<String, MnemonicMetrics>,
+) {
+    for (mnemonic, metrics) in other {
+        into.entry(mnemonic).or_default().absorb(&metrics);
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::testing::{duration, spawn};
+
+    /// Three logs sharing some mnemonics, one spawn with an execution wall time.
+    fn logs() -> Vec<Vec<SpawnExec>> {
+        let mut timed = spawn("Javac", "linux-sandbox", 2_000);
+        timed.metrics.as_mut().unwrap().execution_wall_time = duration(1_500);
+        let mut untimed = spawn("Genrule", "local", 0);
+        untimed.metrics = None;
+        vec![
+            vec![timed, spawn("Javac", "remote cache hit", 100), spawn("CppLink", "local", 900)],
+            vec![spawn("Javac", "worker", 700), untimed],
+            vec![spawn("CppLink", "disk cache hit", 50), spawn("GoCompile", "remote", 3_000)],
+        ]
+    }
+
+    /// The totals of a merged table, with each mnemonic's durations sorted, since the order
+    /// they are collected in does not matter to the percentiles.
+    fn totals(
+        metrics: &HashMap<String, MnemonicMetrics>,
+    ) -> Vec<(String, [u64; 7], Vec<Duration>)> {
+        let mut rows: Vec<_> = metrics
+            .iter()
+            .map(|(mnemonic, m)| {
+                let mut durations = m.durations.clone();
+                durations.sort();
+                let counts = [
+                    m.count,
+                    m.cache_hits,
+                    nanos(m.total_duration),
+                    nanos(m.miss_duration),
+                    m.exec_samples,
+                    nanos(m.exec_wall_time),
+                    nanos(m.exec_total_time),
+                ];
+                (mnemonic.clone(), counts, durations)
+            })
+            .collect();
+        rows.sort();
+        rows
+    }
+
+    fn nanos(duration: Duration) -> u64 {
+        duration.as_nanos() as u64
+    }
+
+    #[test]
+    fn merged_mnemonic_metrics_equal_those_of_all_spawns_in_any_order() {
+        let logs = logs();
+        let all: Vec<SpawnExec> = logs.concat();
+        let expected = totals(&mnemonic_metrics(&all));
+        for order in [[0, 1, 2], [2, 1, 0], [1, 2, 0]] {
+            let mut merged = HashMap::new();
+            for i in order {
+                merge_mnemonic_metrics(&mut merged, mnemonic_metrics(&logs[i]));
+            }
+            assert_eq!(totals(&merged), expected);
+        }
+        let javac = &mnemonic_metrics(&all)["Javac"];
+        assert_eq!((javac.count, javac.cache_hits), (3, 1));
+        assert_eq!(javac.miss_duration, Duration::from_millis(2_700));
+        assert_eq!(javac.exec_samples, 1);
+        assert_eq!(mnemonic_metrics(&all)["Genrule"].durations, []);
+    }
+
+    #[test]
+    fn absorbed_summaries_equal_the_summary_of_all_spawns() {
+        let logs = logs();
+        let expected = summarize(&logs.concat());
+        for order in [[0, 1, 2], [2, 0, 1]] {
+            let mut merged = Summary::default();
+            for i in order {
+                merged.absorb(&summarize(&logs[i]));
+            }
+            assert_eq!(
+                (merged.actions, merged.cache_hits, merged.total_time),
+                (expected.actions, expected.cache_hits, expected.total_time)
+            );
+        }
+        assert_eq!((expected.actions, expected.cache_hits), (7, 2));
+        assert_eq!(expected.total_time, Duration::from_millis(6_750));
+    }
+
+    #[test]
+    fn merging_an_empty_log_changes_nothing() {
+        let log = &logs()[0];
+        let mut merged = mnemonic_metrics(log);
+        merge_mnemonic_metrics(&mut merged, HashMap::new());
+        assert_eq!(totals(&merged), totals(&mnemonic_metrics(log)));
+        let mut summary = summarize(log);
+        summary.absorb(&Summary::default());
+        assert_eq!(summary.actions, 3);
+    }
+
+    #[test]
+    fn the_exec_fraction_needs_half_of_the_spawns_to_record_both_times() {
+        let mut timed = spawn("Javac", "worker", 2_000);
+        timed.metrics.as_mut().unwrap().execution_wall_time = duration(1_500);
+        let mut metrics = MnemonicMetrics::default();
+        metrics.add(&timed);
+        assert_eq!(metrics.exec_fraction(), Some(0.75));
+        metrics.add(&spawn("Javac", "worker", 1_000));
+        assert_eq!(metrics.exec_fraction(), Some(0.75));
+        metrics.add(&spawn("Javac", "worker", 1_000));
+        assert_eq!(metrics.exec_fraction(), None);
+        assert_eq!(MnemonicMetrics::default().exec_fraction(), None);
+    }
+}
@@ -0,0 +1,107 @@
+//! The other files Bazel writes that get passed for an execution log: the Build Event
+//! Protocol stream, as JSON or binary, the `--profile` trace and the JSON execution log. They are
+//! told apart by their first bytes, but only once a log failed to parse, so that a valid log is
+//! never mistaken for one of them.
+
+use prost::encoding::{decode_key, decode_varint, WireType};
+
+/// Bytes at the start of a log that [`detect`] looks at.
+pub const HEAD_BYTES: usize = 4096;
+
+const PRODUCE_LOG: &str =
+    "pass --execution_log_compact_file or --execution_log_binary_file to Bazel to write one";
+
+/// A file that is not an execution log but is often taken for one.
+#[derive(Clone, Copy, PartialEq, Eq, Debug)]
+pub enum Artifact {
+    /// `--build_event_json_file`
+    BuildEventJson,
+    /// `--build_event_binary_file`
+    BuildEventBinary,
+    /// `--profile`, a Chrome trace
+    Profile,
+    /// A gzip-compressed file, as `--profile` writes by default.
+    Gzip,
+    /// `--execution_log_json_file`
+    JsonExecutionLog,
+    /// Some other JSON document.
+    Json,
+}
+
+impl Artifact {
+    /// Why the file cannot be read, and what to pass Bazel instead.
+    pub fn message(self) -> String {
+        let what = match self {
+            Artifact::BuildEventJson => {
+                "a Build Event Protocol JSON file (--build_event_json_file)"
+            }
+            Artifact::BuildEventBinary => {
+                "a binary Build Event Protocol file (--build_event_binary_file)"
+            }
+            Artifact::Profile => "a Bazel --profile trace",
+            Artifact::Gzip => "a gzip-compressed file, such as a Bazel --profile trace",
+            Artifact::JsonExecutionLog => {
+                return format!(
+                    "this looks like a JSON execution log (--execution_log_json_file), which \
+                     this tool does not read; {}",
+                    PRODUCE_LOG
+                )
+            }
+            Artifact::Json => "a JSON file",
+        };
+        format!(
+            "this looks like {}, not an execution log; {}",
+            what, PRODUCE_LOG
+        )
+    }
+}
+
+/// What `head`, the first bytes of a file that did not parse as an execution log, looks like.
+pub fn detect(head: &[u8]) -> Option<Artifact> {
+    if head.starts_with(&[0x1f, 0x8b]) {
+        return Some(Artifact::Gzip);
+    }
+    let text = head.strip_prefix(b"\xef\xbb\xbf").unwrap_or(head);
+    let text = String::from_utf8_lossy(text);
+    let json = text.trim_start();
+    if json.starts_with('{') || json.starts_with('[') {
+        let has = |key: &str| json.contains(&format!("\"{}\"", key));
+        return Some(
+            if has("traceEvents") || (json.starts_with('[') && has("ph")) {
+                Artifact::Profile
+            } else if has("id") && (has("children") || has("lastMessage")) {
+                Artifact::BuildEventJson
+            } else if has("commandArgs") || has("listedOutputs") {
+                Artifact::JsonExecutionLog
+            } else {
+                Artifact::Json
+            },
+        );
+    }
+    is_build_event_stream(head).then_some(Artifact::BuildEventBinary)
+}
+
+/// Whether the first length-delimited record starts like a `BuildEvent`: with its `id`, a
+/// nested message, in field 1, where a `SpawnExec` has its first command-line argument.
+fn is_build_event_stream(head: &[u8]) -> bool {
+    let mut rest = head;
+    if decode_varint(&mut rest).is_err() {
+        return false;
+    }
+    let Ok((1, WireType::LengthDelimited)) = decode_key(&mut rest) else {
+        return false;
+    };
+    let Some(len) = decode_varint(&mut rest)
+        .ok()
+        .and_then(|len| usize::try_from(len).ok())
+        .filter(|&len| len > 0 && len <= rest.len())
+    else {
+        return false;
+    };
+    let id = &rest[..len];
+    let mut nested = id;
+    let is_message = matches!(decode_key(&mut nested), Ok((_, WireType::LengthDelimited)))
+        && decode_varint(&mut nested).is_ok_and(|len| len <= nested.len() as u64);
+    // An argument is text; a message starts with a key, which is a control character.
+    is_message && id.iter().any(|&b| b < 0x20 && !b"\t\n\r".contains(&b))
+}
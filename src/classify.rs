@@ -0,0 +1,130 @@
+//! Classification of spawns by outcome.
+
+use crate::proto::SpawnExec;
+
+/// Returns true if the spawn failed, i.e. it has an error status or a non-zero exit code.
+pub fn is_failed(spawn: &SpawnExec) -> bool {
+    !spawn.status.is_empty() || spawn.exit_code != 0
+}
+
+/// The statuses Bazel records for spawns that did not succeed, and the gRPC code some remote
+/// executors record for timeouts. Others come from a newer Bazel; they still count as failed.
+const KNOWN_STATUSES: [&str; 10] = [
+    "NON_ZERO_EXIT",
+    "TIMEOUT",
+    "OUT_OF_MEMORY",
+    "EXECUTION_FAILED",
+    "EXECUTION_DENIED",
+    "EXECUTION_FAILED_CATASTROPHICALLY",
+    "EXECUTION_DENIED_CATASTROPHICALLY",
+    "REMOTE_EXECUTOR_OVERLOADED",
+    "REMOTE_CACHE_FAILED",
+    "DEADLINE_EXCEEDED",
+];
+
+/// Returns true if the spawn recorded a status that this version does not know.
+pub fn has_unknown_status(spawn: &SpawnExec) -> bool {
+    !spawn.status.is_empty() && !KNOWN_STATUSES.contains(&spawn.status.as_str())
+}
+
+/// Returns true if the spawn was killed for exceeding its timeout.
+///
+/// Bazel records a `TIMEOUT` status for local and remote timeouts; some remote executors
+/// surface the gRPC `DEADLINE_EXCEEDED` code instead.
+pub fn is_timeout(spawn: &SpawnExec) -> bool {
+    let status = spawn.status.to_ascii_uppercase();
+    status.contains("TIMEOUT") || status.contains("DEADLINE_EXCEEDED")
+}
+
+/// Returns the output configuration of a spawn (e.g. `k8-fastbuild`), read from the
+/// `bazel-out/<config>/` segment of its first output, or `None` if it has no such output.
+pub fn spawn_configuration(spawn: &SpawnExec) -> Option<&str> {
+    let first = spawn
+        .actual_outputs
+        .first()
+        .map(|f| f.path.as_str())
+        .or_else(|| spawn.listed_outputs.first().map(|p| p.as_str()))?;
+    split_configuration(first).map(|(config, _)| config)
+}
+
+/// Splits an output path like `bazel-out/k8-fastbuild/bin/foo` into its configuration and
+/// the path below it (`bin/foo`), or returns `None` if it is not under `bazel-out/<config>/`.
+///
+/// The remainder identifies an output independently of the configuration, whose name
+/// changes with flags and Bazel versions.
+pub fn split_configuration(path: &str) -> Option<(&str, &str)> {
+    let rest = path.strip_prefix("bazel-out/")?;
+    rest.split_once('/').filter(|(config, _)| !config.is_empty())
+}
+
+/// Returns true for exec (tool) configurations such as `k8-opt-exec-2B5CBBC6`.
+pub fn is_exec_configuration(config: &str) -> bool {
+    config == "host" || config.contains("-exec-") || config.ends_with("-exec")
+}
+
+/// Returns true if the spawn was served from a disk or remote cache.
+///
+/// Older logs and some strategies leave `cache_hit` unset even though the runner
+/// reports a cache hit, so both signals are consulted.
+pub fn is_cache_hit(spawn: &SpawnExec) -> bool {
+    spawn.cache_hit || spawn.runner.ends_with("cache hit")
+}
+
+/// Returns the runner name for display, with empty runners labeled explicitly.
+pub fn runner_label(spawn: &SpawnExec) -> &str {
+    if spawn.runner.is_empty() {
+        "(unknown)"
+    } else {
+        &spawn.runner
+    }
+}
+
+/// Coarse classification of spawn runners.
+#[derive(Clone, Copy, PartialEq, Eq, Hash, PartialOrd, Ord, Debug)]
+pub enum RunnerKind {
+    RemoteCacheHit,
+    DiskCacheHit,
+    Remote,
+    Worker,
+    /// linux-sandbox, darwin-sandbox, processwrapper-sandbox, ...
+    Sandboxed,
+    /// Local execution without a sandbox.
+    Local,
+    Unknown,
+}
+
+impl RunnerKind {
+    pub fn name(self) -> &'static str {
+        match self {
+            RunnerKind::RemoteCacheHit => "remote cache hit",
+            RunnerKind::DiskCacheHit => "disk cache hit",
+            RunnerKind::Remote => "remote",
+            RunnerKind::Worker => "worker",
+            RunnerKind::Sandboxed => "sandboxed",
+            RunnerKind::Local => "local",
+            RunnerKind::Unknown => "(unknown)",
+        }
+    }
+}
+
+/// Classifies a runner string as recorded in the execution log.
+pub fn classify_runner(runner: &str) -> RunnerKind {
+    let runner = runner.to_ascii_lowercase();
+    if runner.ends_with("cache hit") {
+        if runner.contains("disk") {
+            RunnerKind::DiskCacheHit
+        } else {
+            RunnerKind::RemoteCacheHit
+        }
+    } else if runner.contains("remote") {
+        RunnerKind::Remote
+    } else if runner.contains("worker") {
+        RunnerKind::Worker
+    } else if runner.contains("sandbox") {
+        RunnerKind::Sandboxed
+    } else if runner == "standalone" || runner.contains("local") {
+        RunnerKind::Local
+    } else {
+        RunnerKind::Unknown
+    }
+}
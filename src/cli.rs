@@ -1,23 +1,293 @@
-use clap::Parser;
+use crate::config;
+use crate::format::{ByteUnits, ChartOptions};
+use crate::parallel;
+use crate::report::{self, DEFAULT_IGNORED_MNEMONICS};
+use crate::reports::grouping::{self, Dimension};
+use clap::{ArgAction, CommandFactory, Parser, Subcommand, ValueEnum};
+use std::collections::HashSet;
+use std::ffi::OsString;
 use std::path::PathBuf;
+use std::time::Duration;
 
 #[derive(Parser)]
 #[command(name = "bzl-exec-log-analyzer")]
 #[command(about = "Analyzes Bazel execution logs to extract performance metrics")]
 #[command(version)]
+#[command(subcommand_negates_reqs = true, args_conflicts_with_subcommands = true)]
 pub struct Cli {
-    /// Path to the Bazel execution log file (auto-detects format)
-    #[arg(help = "Path to the Bazel execution log file")]
-    pub file: PathBuf,
+    /// Paths to Bazel execution log files (auto-detects format); several logs are analyzed
+    /// as one build
+    #[arg(
+        help = "Path to the Bazel execution log file; several logs are analyzed together",
+        required = true
+    )]
+    pub files: Vec<PathBuf>,
 
-    /// Number of slowest actions to display in the report
-    #[arg(short, long, default_value_t = 10)]
+    #[command(subcommand)]
+    pub command: Option<Command>,
+
+    /// The report's own flags, which `analyze` takes too.
+    #[command(flatten)]
+    pub options: AnalyzeOptions,
+
+    /// Only analyze spawns that Bazel marked as cacheable
+    #[arg(long, global = true, help_heading = "Filters", conflicts_with = "uncacheable_only")]
+    pub cacheable_only: bool,
+
+    /// Only analyze spawns that Bazel marked as not cacheable
+    #[arg(long, global = true, help_heading = "Filters")]
+    pub uncacheable_only: bool,
+
+    /// Only analyze spawns that Bazel allowed to run remotely
+    #[arg(long, global = true, help_heading = "Filters", conflicts_with = "unremotable_only")]
+    pub remotable_only: bool,
+
+    /// Only analyze spawns that Bazel did not allow to run remotely
+    #[arg(long, global = true, help_heading = "Filters")]
+    pub unremotable_only: bool,
+
+    /// Leave failed spawns (non-zero exit code or error status) out of the analysis
+    #[arg(long, global = true, help_heading = "Filters")]
+    pub exclude_failed: bool,
+
+    /// Print warnings, such as a truncated final record, as text or as one JSON object per line on stderr
+    #[arg(long, global = true, help_heading = "Warnings", value_enum, default_value_t = WarningsFormat::Text)]
+    pub warnings_format: WarningsFormat,
+
+    /// Print at most this many warnings, then only how many more there were
+    #[arg(long, global = true, help_heading = "Warnings", default_value_t = 100)]
+    pub max_warnings: usize,
+
+    /// Unit durations are printed in: seconds, milliseconds, or `human` (e.g. 1h20m3s, 4.2s, 812ms)
+    #[arg(long, global = true, help_heading = "Output", value_enum, default_value_t = DurationFormat::Seconds)]
+    pub duration_format: DurationFormat,
+
+    /// Print byte counts and rates in decimal units (KB, MB/s: multiples of 1000)
+    #[arg(long, global = true, help_heading = "Output", overrides_with = "iec")]
+    pub si: bool,
+
+    /// Print byte counts and rates in binary units (KiB, MiB/s: multiples of 1024), the default
+    #[arg(long, global = true, help_heading = "Output", overrides_with = "si")]
+    pub iec: bool,
+
+    /// Color the report: auto colors only when printing to a terminal and NO_COLOR is not set
+    #[arg(long, global = true, help_heading = "Output", value_enum, value_name = "WHEN", default_value_t = ColorChoice::Auto, overrides_with = "no_color")]
+    pub color: ColorChoice,
+
+    /// Do not color the report; the same as --color never
+    #[arg(long, global = true, help_heading = "Output", overrides_with = "color")]
+    pub no_color: bool,
+
+    /// Do not show the progress line that stderr gets while a large log is parsed
+    #[arg(long, global = true, help_heading = "Output")]
+    pub no_progress: bool,
+
+    /// Print the paths of command lines and file lists relative to the execroot, without the
+    /// output base, execroot and sandbox directories of the machine that ran the build; JSON,
+    /// CSV and action digests keep the recorded paths
+    #[arg(long, global = true, help_heading = "Output", value_name = "BOOL", default_value_t = true, action = ArgAction::Set)]
+    pub relative_paths: bool,
+
+    /// Also strip this path prefix with --relative-paths, e.g. a remote executor's /b/f/w (repeatable)
+    #[arg(long, global = true, help_heading = "Output", value_name = "PREFIX")]
+    pub strip_path_prefix: Vec<String>,
+
+    /// Parse up to this many logs at once when given several [default: one per CPU]
+    #[arg(long, global = true, help_heading = "Input", value_name = "N", value_parser = parse_worker_count)]
+    pub parallel_files: Option<usize>,
+
+    /// Keep only what the report needs of each spawn when the logs would take more memory than
+    /// this, e.g. 2GiB; reports on inputs, command lines or environments then cannot run
+    #[arg(long, global = true, help_heading = "Input", value_name = "SIZE", value_parser = parse_byte_size)]
+    pub max_memory: Option<u64>,
+
+    /// Leave out a log that cannot be read or parsed, with a warning, instead of stopping
+    #[arg(long, global = true, help_heading = "Input")]
+    pub skip_errors: bool,
+
+    /// Warn about each record field that spawn.proto does not define, as a newer Bazel writes them
+    #[arg(long, global = true, help_heading = "Input")]
+    pub print_unknown_fields: bool,
+
+    /// Print to stderr where the time of parsing went (reading, decompression, protobuf decoding), with throughput and peak memory
+    #[arg(long, global = true, help_heading = "Input")]
+    pub parse_stats: bool,
+
+    /// Print to stderr the wall time of each stage of the run (read, decompress, decode, aggregate, render) and the heap used, for performance bug reports
+    #[arg(long, global = true, help_heading = "Input")]
+    pub debug_timing: bool,
+
+    /// Write a pprof profile of the CPU time of the run to this file, sampled 100 times a second, for `go tool pprof`
+    #[arg(long, global = true, help_heading = "Input", value_name = "FILE")]
+    pub cpuprofile: Option<PathBuf>,
+
+    /// Write a pprof profile of where the run allocated heap to this file, sampled once per 512 KiB, for `go tool pprof`
+    #[arg(long, global = true, help_heading = "Input", value_name = "FILE")]
+    pub memprofile: Option<PathBuf>,
+
+    /// Read default flags from this TOML file instead of ./.bzl-exec-log-parser.toml
+    #[arg(long, global = true, help_heading = "Config", value_name = "PATH", conflicts_with = "no_config")]
+    pub config: Option<PathBuf>,
+
+    /// Ignore ./.bzl-exec-log-parser.toml
+    #[arg(long, global = true, help_heading = "Config")]
+    pub no_config: bool,
+
+    /// Print which config file was read and the flags it supplied, on stderr
+    #[arg(short, long, global = true)]
+    pub verbose: bool,
+}
+
+/// The flags of the report printed without a subcommand or by `analyze`, which the other
+/// subcommands do not take. The flags they share are global flags of [`Cli`].
+#[derive(clap::Args)]
+pub struct AnalyzeOptions {
+    /// Number of slowest actions to display in the report, and of rows in the other top-N tables
+    /// (0 or `all` for no limit)
+    #[arg(short, long, default_value = "10", value_parser = parse_limit, allow_negative_numbers = true)]
     pub top_n: usize,
 
+    /// Metric the top actions table is ranked by (descending), shown as its first column
+    #[arg(long, value_enum, default_value_t = ActionSort::Duration)]
+    pub sort_by: ActionSort,
+
+    /// Print the N slowest actions of each of the top mnemonics by time
+    #[arg(long)]
+    pub top_per_mnemonic: Option<usize>,
+
+    /// Print the N slowest actions of each runner class with the phase that dominates them
+    #[arg(long)]
+    pub top_per_runner: Option<usize>,
+
+    /// List every spawn, one line each, in --sort-by order after the filter flags, instead of
+    /// the report
+    #[arg(long, conflicts_with_all = ["quiet", "watch", "serve", "tui", "verify_outputs"])]
+    pub all: bool,
+
+    /// With --all, print the report as well
+    #[arg(long, requires = "all")]
+    pub summary: bool,
+
+    /// Write the --all listing to this file instead of stdout
+    #[arg(long, value_name = "PATH", requires = "all")]
+    pub output: Option<PathBuf>,
+
+    /// Print the report in another format: `json` prints a document of the summary and
+    /// mnemonics instead of the text report, --quiet or the --all listing, with status messages
+    /// on stderr. `help` lists every format, including those a program using the library added
+    #[arg(
+        long,
+        value_name = "FORMAT",
+        default_value = "text",
+        value_parser = parse_output_format
+    )]
+    pub output_format: String,
+
+    /// With --output-format json, add the top N spawns by --sort-by, or `all` of them, as records
+    /// of --spawn-columns (`--include-spawns` alone adds 100)
+    #[arg(
+        long,
+        value_name = "N",
+        num_args = 0..=1,
+        require_equals = true,
+        default_missing_value = "100",
+        value_parser = parse_spawn_count
+    )]
+    pub include_spawns: Option<usize>,
+
+    /// Write a report for each mnemonic to --output-dir instead of printing one, with an index
+    /// of the overall summary
+    #[arg(long, requires = "output_dir", conflicts_with_all = ["all", "quiet", "watch", "serve", "tui", "verify_outputs"])]
+    pub split_by_mnemonic: bool,
+
+    /// Directory --split-by-mnemonic writes its reports to (created if missing)
+    #[arg(long, value_name = "DIR", requires = "split_by_mnemonic")]
+    pub output_dir: Option<PathBuf>,
+
+    /// Mnemonics taking less total time than this share one `misc.txt` report under
+    /// --split-by-mnemonic
+    #[arg(long, value_parser = parse_duration, default_value = "1s")]
+    pub split_min_time: Duration,
+
+    /// Comma-separated columns of the --all listing, in order
+    #[arg(long, value_enum, value_delimiter = ',', default_value = "duration,mnemonic,cache_hit,runner,exit_code,label")]
+    pub spawn_columns: Vec<SpawnColumn>,
+
+    /// Print the --all listing as an aligned table, CSV, TSV or one JSON object per line
+    #[arg(long, value_enum, default_value_t = ListingFormat::Text)]
+    pub listing_format: ListingFormat,
+
+    /// Print the command line beneath each of the slowest actions
+    #[arg(long)]
+    pub show_args: bool,
+
+    /// Maximum number of arguments printed per command line with --show-args (0 for all)
+    #[arg(long, default_value_t = 12)]
+    pub args_limit: usize,
+
+    /// Width the top actions table is fitted to by shortening target labels (defaults to the
+    /// terminal's width; unlimited when not printing to a terminal)
+    #[arg(long, value_name = "COLUMNS")]
+    pub max_width: Option<usize>,
+
+    /// Make the report the same on every machine and run, for snapshot tests: log files by base name, no timestamps, colors or terminal widths, and in JSON no parse diagnostics
+    #[arg(long)]
+    pub deterministic: bool,
+
+    /// Print target labels in full, even if they make the top actions table wider than the terminal
+    #[arg(long)]
+    pub full_labels: bool,
+
+    /// Comma-separated columns to show in the mnemonic table
+    #[arg(long, value_enum, value_delimiter = ',', default_value = "count,hits,total,miss,share,cumulative,avg,min,median,max")]
+    pub columns: Vec<MnemonicColumn>,
+
+    /// Column the mnemonic table is sorted by (descending unless --asc; ties by name)
+    #[arg(long, value_enum, default_value_t = MnemonicSort::Total)]
+    pub mnemonic_sort: MnemonicSort,
+
+    /// Sort the mnemonic table ascending, e.g. with --mnemonic-sort hit-rate for the worst-cached first
+    #[arg(long)]
+    pub asc: bool,
+
+    /// Show only the first N mnemonics of the mnemonic table, folding the rest into one "other" row
+    /// (0 or `all` for no limit, the default)
+    #[arg(long, value_name = "N", value_parser = parse_limit, allow_negative_numbers = true)]
+    pub top_mnemonics: Option<usize>,
+
+    /// Show Symlink, SourceSymlinkManifest, FileWrite and TemplateExpand in the mnemonic table
+    /// rather than folding them into one "infrastructure actions" row
+    #[arg(long)]
+    pub no_default_ignores: bool,
+
+    /// Comma-separated mnemonics to fold into the "infrastructure actions" row as well
+    #[arg(long, value_name = "MNEMONIC", value_delimiter = ',')]
+    pub ignore_mnemonic: Vec<String>,
+
+    /// Comma-separated mnemonics to give their own row of the mnemonic table after all, of the built-in ignore list or --ignore-mnemonic
+    #[arg(long, value_name = "MNEMONIC", value_delimiter = ',')]
+    pub unignore_mnemonic: Vec<String>,
+
+    /// Add p50/p90/p99 duration columns to the mnemonic table
+    #[arg(long)]
+    pub percentiles: bool,
+
     /// Calculate and display remote cache performance metrics
     #[arg(long, default_value_t = true)]
     pub cache_metrics: bool,
 
+    /// Warn when cache lookups take more than this fraction of total spawn time
+    #[arg(long, default_value_t = 0.1)]
+    pub lookup_warn_fraction: f64,
+
+    /// Cache fetches with a download rate below this percentile are flagged as slow
+    #[arg(long, default_value_t = 5.0)]
+    pub slow_fetch_percentile: f64,
+
+    /// Minimum bytes a cache fetch must move to be flagged as slow
+    #[arg(long, default_value_t = 1_000_000)]
+    pub slow_fetch_min_bytes: u64,
+
     /// Display a detailed breakdown of action phase timings for slowest actions
     #[arg(long)]
     pub phase_timings: bool,
@@ -26,6 +296,18 @@ pub struct Cli {
     #[arg(long)]
     pub input_analysis: bool,
 
+    /// Display the mnemonics and targets of spawns that are not cacheable
+    #[arg(long)]
+    pub uncacheable: bool,
+
+    /// Exit with an error if non-cacheable spawns take longer than this in total (e.g. 10m)
+    #[arg(long, value_parser = parse_duration)]
+    pub fail_if_uncacheable_time: Option<Duration>,
+
+    /// Display the spawns that cannot run remotely by mnemonic and package, with the remotable share of time
+    #[arg(long)]
+    pub remotability: bool,
+
     /// Display a report on actions that failed or were retried
     #[arg(long)]
     pub retries: bool,
@@ -38,6 +320,10 @@ pub struct Cli {
     #[arg(long)]
     pub output_analysis: bool,
 
+    /// Display the actions with the most output bytes, with their output count and cache status
+    #[arg(long)]
+    pub size_report: bool,
+
     /// Display a report on actions with the highest memory usage relative to their limit
     #[arg(long)]
     pub memory_analysis: bool,
@@ -46,7 +332,1295 @@ pub struct Cli {
     #[arg(long)]
     pub execution_comparison: bool,
 
+    /// Maximum number of failed actions listed in the failed actions section
+    #[arg(long, default_value_t = 20)]
+    pub max_failed: usize,
+
+    /// Always display timeouts and how close spawns get to them (shown anyway when spawns timed out)
+    #[arg(long)]
+    pub timeouts: bool,
+
     /// Display a report on actions with the longest queue times
     #[arg(long)]
     pub queue_analysis: bool,
-}
\ No newline at end of file
+
+    /// Print a count / cache hit / time table keyed by a dimension (mnemonic, runner, target, package, repository, config, platform, pool, tool, extension), or by two joined with a comma, e.g. mnemonic,runner (repeatable)
+    #[arg(long, value_name = "DIMENSION", value_parser = parse_group_key)]
+    pub group_by: Vec<GroupKey>,
+
+    /// Directories below the repository root kept when grouping by package (e.g. 2 for //third_party/foo)
+    #[arg(long)]
+    pub package_depth: Option<usize>,
+
+    /// Roll spawns up by Bazel target pattern (e.g. //foo/..., //foo:all, @...), one row each in the order given; a spawn counts under the first pattern it matches (repeatable)
+    #[arg(long, value_name = "PATTERN", value_parser = parse_label_pattern)]
+    pub group_prefix: Vec<LabelPattern>,
+
+    /// Display spawn counts, time and cache hits for exec- and target-configuration spawns
+    #[arg(long)]
+    pub config_split: bool,
+
+    /// Display spawn counts, time and cache hits for the main repository vs. external repositories
+    #[arg(long)]
+    pub external_repos: bool,
+
+    /// Compare sandboxed and non-sandboxed local execution times by mnemonic
+    #[arg(long)]
+    pub sandbox_overhead: bool,
+
+    /// Compare persistent worker and non-worker local execution times by mnemonic
+    #[arg(long)]
+    pub worker_analysis: bool,
+
+    /// Suggest mnemonics that are likely to benefit from persistent workers (heuristic)
+    #[arg(long)]
+    pub suggest: bool,
+
+    /// Minimum non-worker local executions for a mnemonic to be suggested as a worker candidate
+    #[arg(long, default_value_t = 500)]
+    pub suggest_min_count: u64,
+
+    /// Maximum average duration for a mnemonic to be suggested as a worker candidate
+    #[arg(long, value_parser = parse_duration, default_value = "3s")]
+    pub suggest_max_avg: Duration,
+
+    /// Minimum number of spawns on each side for a runner comparison to be shown
+    #[arg(long, default_value_t = 5)]
+    pub min_samples: u64,
+
+    /// Estimate the critical path of the build
+    #[arg(long, value_enum, num_args = 0..=1, require_equals = true, default_missing_value = "time")]
+    pub critical_path: Option<CriticalPathMode>,
+
+    /// Estimate how many spawns ran concurrently over the build, from start timestamps
+    #[arg(long)]
+    pub concurrency: bool,
+
+    /// Width of the time buckets sampled by --concurrency
+    #[arg(long, value_parser = parse_duration, default_value = "1s")]
+    pub concurrency_bucket: Duration,
+
+    /// Report the share of wall time with fewer than this many spawns running
+    #[arg(long, default_value_t = 4)]
+    pub concurrency_below: usize,
+
+    /// Add a sparkline of the concurrency over time to --concurrency
+    #[arg(long)]
+    pub sparkline: bool,
+
+    /// List the stretches of wall-clock time in which no spawn was running, with the spawns on either side, from start timestamps
+    #[arg(long)]
+    pub idle_gaps: bool,
+
+    /// Shortest stretch without a running spawn that --idle-gaps lists
+    #[arg(long, value_parser = parse_duration, default_value = "2s")]
+    pub idle_gap_min: Duration,
+
+    /// Compare the summed spawn time to the build's wall time (effective parallelism)
+    #[arg(long)]
+    pub parallelism: bool,
+
+    /// Duration of the whole build (e.g. 22m), to compute how much of it ran no spawn; implies --parallelism
+    #[arg(long, value_parser = parse_duration)]
+    pub wall_time: Option<Duration>,
+
+    /// Display environment variables whose values differ between spawns of the same mnemonic
+    #[arg(long)]
+    pub env_variance: bool,
+
+    /// Comma-separated environment variables expected to vary, left out of --env-variance
+    #[arg(long, value_delimiter = ',')]
+    pub env_variance_ignore: Vec<String>,
+
+    /// Display the average and largest environment size per mnemonic and the variables taking the most bytes
+    #[arg(long)]
+    pub env_size: bool,
+
+    /// Flag mnemonics whose spawns carry more environment than this on average in --env-size, e.g. 64KiB
+    #[arg(long, value_name = "SIZE", value_parser = parse_byte_size, default_value = "32KiB")]
+    pub env_size_threshold: u64,
+
+    /// Explain the most expensive cache misses by diffing them against a comparable cache hit
+    #[arg(long)]
+    pub why_miss: bool,
+
+    /// Flag home directory paths, absolute workspace paths, varying PATH values and host variables in spawns
+    #[arg(long)]
+    pub hermeticity: bool,
+
+    /// Comma-separated substrings of values that --hermeticity should accept as benign
+    #[arg(long, value_delimiter = ',')]
+    pub hermeticity_allow: Vec<String>,
+
+    /// Display count and time per tool binary (the first command-line argument) with the mnemonics using it
+    #[arg(long)]
+    pub by_tool: bool,
+
+    /// Keep the full path of each tool in --by-tool instead of only its file name
+    #[arg(long)]
+    pub tool_full_path: bool,
+
+    /// Display spawn counts, time and cache hits per distinct set of execution platform properties
+    #[arg(long)]
+    pub platform_summary: bool,
+
+    /// Group --platform-summary by the value of this platform property (e.g. container-image); implies --platform-summary
+    #[arg(long)]
+    pub platform_key: Option<String>,
+
+    /// Break remote executions down by the value of this platform property (e.g. Pool), with queue and execution time
+    #[arg(long)]
+    pub pool_key: Option<String>,
+
+    /// Print heuristic findings (poorly cached mnemonics, slow fetches, queueing, ...) with their numbers
+    #[arg(long)]
+    pub insights: bool,
+
+    /// Write a standalone HTML timeline of spawns (requires start timestamps) to this file
+    #[arg(long)]
+    pub export_timeline: Option<PathBuf>,
+
+    /// What each row of the --export-timeline chart shows
+    #[arg(long, value_enum, default_value = "mnemonic")]
+    pub timeline_rows: TimelineRows,
+
+    /// Maximum number of spawns drawn by --export-timeline; shorter ones are summed per row
+    #[arg(long, default_value_t = 5000)]
+    pub timeline_max_bars: usize,
+
+    /// Draw the per-mnemonic (and --group-by) total times as a bar chart below each table
+    #[arg(long)]
+    pub chart: bool,
+
+    /// Line width of --chart (defaults to $COLUMNS, or 100)
+    #[arg(long)]
+    pub chart_width: Option<usize>,
+
+    /// Rows drawn by --chart before the rest is collapsed into "other"
+    #[arg(long, default_value_t = 15)]
+    pub chart_rows: usize,
+
+    /// Scale --chart bars logarithmically, for when one row dwarfs the others
+    #[arg(long)]
+    pub chart_log: bool,
+
+    /// Display a histogram of action durations
+    #[arg(long)]
+    pub histogram: bool,
+
+    /// Comma-separated upper bounds of the histogram buckets (e.g. 10ms,1s,1m)
+    #[arg(long, value_delimiter = ',', value_parser = parse_duration, default_value = "10ms,100ms,1s,10s,60s")]
+    pub histogram_buckets: Vec<Duration>,
+
+    /// Display a duration histogram with automatic log-scaled buckets for this mnemonic (repeatable)
+    #[arg(long, value_name = "MNEMONIC")]
+    pub mnemonic_histogram: Vec<String>,
+
+    /// Display per-phase time sums and averages for the mnemonics with the most total time
+    #[arg(long)]
+    pub phases: bool,
+
+    /// Number of mnemonics shown by --phases
+    #[arg(long, default_value_t = 5)]
+    pub phases_top: usize,
+
+    /// Display the mnemonics and runners whose spawns are missing timing data
+    #[arg(long)]
+    pub metrics_coverage: bool,
+
+    /// Display how many spawns recorded each field, with the Bazel flags behind missing ones (shown anyway for key fields in under 5% of spawns)
+    #[arg(long)]
+    pub field_coverage: bool,
+
+    /// Compare network overhead to execution time for remotely executed mnemonics
+    #[arg(long)]
+    pub remote_overhead: bool,
+
+    /// Column used to sort the --remote-overhead table
+    #[arg(long, value_enum, default_value_t = OverheadSort::Ratio)]
+    pub remote_overhead_sort: OverheadSort,
+
+    /// Display evidence of remote executions falling back to local execution
+    #[arg(long)]
+    pub remote_fallbacks: bool,
+
+    /// Display the actions with the most input files (reconstructs inputs of compact logs)
+    #[arg(long)]
+    pub input_counts: bool,
+
+    /// Display a summary of the input and output bytes the build moves
+    #[arg(long)]
+    pub data_volume: bool,
+
+    /// Display the largest individual output files across the log
+    #[arg(long)]
+    pub largest_outputs: bool,
+
+    /// Estimate the remote cache storage implied by this build's unique digests
+    #[arg(long)]
+    pub cas_footprint: bool,
+
+    /// Estimate, as an upper bound, the input bytes remote executions had to upload, crediting digests seen earlier in the log
+    #[arg(long)]
+    pub input_upload_estimate: bool,
+
+    /// Estimate the cache storage this build adds and project its daily growth
+    #[arg(long)]
+    pub cache_cost: bool,
+
+    /// Builds per day assumed by --cache-cost projections
+    #[arg(long, default_value_t = 10.0)]
+    pub builds_per_day: f64,
+
+    /// Fraction of output bytes assumed new per build when no --previous-log is given
+    #[arg(long, default_value_t = 0.1)]
+    pub change_rate: f64,
+
+    /// Execution log of an earlier build, used by --cache-cost to measure new blobs
+    #[arg(long)]
+    pub previous_log: Option<PathBuf>,
+
+    /// Estimate what a remote cache would save for cacheable spawns that executed locally
+    #[arg(long)]
+    pub remote_cache_benefit: bool,
+
+    /// Share of eligible spawns assumed to hit the cache in --remote-cache-benefit (e.g. 0.8)
+    #[arg(long)]
+    pub assumed_hit_rate: Option<f64>,
+
+    /// Download rate in MB/s assumed by --remote-cache-benefit
+    #[arg(long, default_value_t = 50.0)]
+    pub assumed_download_rate: f64,
+
+    /// Compare, per mnemonic, the fetch time of cache hits with the execution time of misses
+    #[arg(long)]
+    pub cache_break_even: bool,
+
+    /// Fetch-to-rebuild ratio above which --cache-break-even suggests disabling cache reads
+    #[arg(long, default_value_t = 0.7, value_name = "RATIO")]
+    pub break_even_ratio: f64,
+
+    /// Display input bytes aggregated by source directory
+    #[arg(long)]
+    pub input_dirs: bool,
+
+    /// Number of leading path components used to group directories in --input-dirs
+    #[arg(long, default_value_t = 2)]
+    pub depth: usize,
+
+    /// Display the input files consumed by the most spawns
+    #[arg(long)]
+    pub hot_inputs: bool,
+
+    /// Display the input files by the summed time of the spawns consuming them, what a change to each costs to rebuild (=misses counts cache misses only)
+    #[arg(long, value_enum, num_args = 0..=1, require_equals = true, default_missing_value = "all")]
+    pub hot_inputs_weighted: Option<WeightedConsumers>,
+
+    /// Comma-separated path prefixes ignored by --hot-inputs and --hot-inputs-weighted (e.g. tool launchers)
+    #[arg(long, value_delimiter = ',')]
+    pub ignore_input_prefix: Vec<String>,
+
+    /// Display pairwise input overlap between the mnemonics with the most input bytes (expensive)
+    #[arg(long)]
+    pub input_overlap: bool,
+
+    /// Number of mnemonics compared by --input-overlap
+    #[arg(long, default_value_t = 6)]
+    pub overlap_mnemonics: usize,
+
+    /// Display directory (tree artifact) outputs per mnemonic
+    #[arg(long)]
+    pub tree_artifacts: bool,
+
+    /// Display the actions producing the most unresolved symlink outputs
+    #[arg(long)]
+    pub symlinks: bool,
+
+    /// Always display spawns that recorded no outputs (shown anyway above 5% of the log)
+    #[arg(long)]
+    pub zero_outputs: bool,
+
+    /// Always display the digest functions seen (shown anyway when mixed or malformed)
+    #[arg(long)]
+    pub digest_functions: bool,
+
+    /// Re-hash the recorded outputs under --workspace and compare them with the log instead of reporting
+    #[arg(long, requires = "workspace")]
+    pub verify_outputs: bool,
+
+    /// Execution root that recorded output paths are relative to, for --verify-outputs (and the path --hermeticity looks for)
+    #[arg(long)]
+    pub workspace: Option<PathBuf>,
+
+    /// Recompute and print the Remote Execution API action digest of every spawn
+    #[arg(long)]
+    pub compute_action_digests: bool,
+
+    /// Find the spawn whose recomputed action digest matches (hash or hash/size)
+    #[arg(long)]
+    pub find_action_digest: Option<String>,
+
+    /// Drill down into one target: its spawns in the order they ran, with runner, cache status, phases, inputs and output bytes (a pattern such as //foo/... covers several)
+    #[arg(long, value_name = "LABEL", value_parser = parse_label_pattern)]
+    pub explain: Option<LabelPattern>,
+
+    /// Also list the target's TestRunner spawns, one per test shard and run, in --explain
+    #[arg(long, requires = "explain")]
+    pub explain_shards: bool,
+
+    /// List actions executed more than once (same label, mnemonic and outputs) and the time repeats cost
+    #[arg(long)]
+    pub duplicates: bool,
+
+    /// Exit with status 3 after the report when the cache hit rate is below this percentage (e.g. 85%)
+    #[arg(long, value_parser = parse_percent)]
+    pub min_hit_rate: Option<f64>,
+
+    /// Like --min-hit-rate, but weighing each hit by the mean time a miss of its mnemonic takes
+    #[arg(long, value_parser = parse_percent)]
+    pub min_hit_rate_weighted: Option<f64>,
+
+    /// List the executed spawns that took longer than this (e.g. 5m) and exit with status 4 if there are any
+    #[arg(long, value_parser = parse_duration)]
+    pub max_action_duration: Option<Duration>,
+
+    /// Mnemonic pattern (`*` wildcards, repeatable) that --max-action-duration does not apply to, e.g. 'Test*'
+    #[arg(long)]
+    pub budget_exclude: Vec<String>,
+
+    /// Follow a log Bazel is still writing, refreshing a summary until Ctrl-C prints the full report
+    #[arg(long, conflicts_with_all = ["merge_dedup", "verify_outputs"])]
+    pub watch: bool,
+
+    /// How often --watch reads what was appended to the log and refreshes the summary
+    #[arg(long, value_parser = parse_duration, default_value = "5s")]
+    pub interval: Duration,
+
+    /// Serve an interactive report and JSON endpoints on this address (`:8080` binds to localhost) instead of printing
+    #[arg(long, value_name = "ADDR", conflicts_with = "watch")]
+    pub serve: Option<String>,
+
+    /// Browse the summary, mnemonics, slowest actions and cache report interactively in the terminal
+    #[arg(long, conflicts_with_all = ["watch", "serve"])]
+    pub tui: bool,
+
+    /// Print a last stdout line `BZL_EXECLOG_SUMMARY {...}` with headline numbers as JSON, for CI log scraping
+    #[arg(long)]
+    pub ci_summary: bool,
+
+    /// Append a CSV row of this run's headline numbers to this file, writing the header if the file does not exist yet
+    #[arg(long, value_name = "PATH")]
+    pub append_summary: Option<PathBuf>,
+
+    /// An identifying column of the --append-summary row, e.g. sha=$GIT_COMMIT (repeatable, in column order)
+    #[arg(
+        long,
+        value_name = "KEY=VALUE",
+        value_parser = parse_summary_label,
+        requires = "append_summary"
+    )]
+    pub summary_label: Vec<SummaryLabel>,
+
+    /// Print only the actions, cache hits and spawn time of each log under its file name, leaving
+    /// out every table; the --min-*/--fail-* gates and --ci-summary still apply
+    #[arg(
+        short,
+        long,
+        conflicts_with_all = ["watch", "serve", "tui", "merge_dedup", "verify_outputs", "hermeticity"]
+    )]
+    pub quiet: bool,
+
+    /// Exit with status 5 after the report when any spawn failed (non-zero exit code or error status)
+    #[arg(long)]
+    pub fail_on_action_failure: bool,
+
+    /// Failures --fail-on-action-failure tolerates, as mnemonic=PATTERN or label=PATTERN with `*` wildcards (repeatable)
+    #[arg(long, value_parser = parse_failure_allowance)]
+    pub allow_failures: Vec<FailureAllowance>,
+
+    /// Count each repeatedly executed action once in all reports, keeping its first or last execution
+    #[arg(long, value_enum, default_value = "none")]
+    pub dedupe: DedupeMode,
+
+    /// With several logs of one sharded build, count actions recorded by more than one log once
+    #[arg(long)]
+    pub merge_dedup: bool,
+
+    /// Mnemonics (`*` wildcards) whose declared and actual outputs are not compared; tests declare optional outputs (repeatable)
+    #[arg(long, value_name = "MNEMONIC", default_value = "TestRunner")]
+    pub output_mismatch_exempt: Vec<String>,
+
+    /// Exit with an error when validation finds problems, such as an empty log, outputs written by several spawns or other than declared, or --hermeticity findings
+    #[arg(long)]
+    pub strict: bool,
+}
+
+impl std::ops::Deref for Cli {
+    type Target = AnalyzeOptions;
+
+    fn deref(&self) -> &AnalyzeOptions {
+        &self.options
+    }
+}
+
+/// Width of --chart when neither --chart-width nor `COLUMNS` is set.
+const DEFAULT_CHART_WIDTH: usize = 100;
+
+impl Cli {
+    /// Parses the process arguments. `analyze` takes the report's flags as a subcommand, and
+    /// is then folded into the bare invocation it names, which every other part then sees.
+    pub fn parse_args() -> Self {
+        let mut args: Vec<OsString> = std::env::args_os().collect();
+        let loaded = config::apply_config(&mut args);
+        let mut cli = Cli::parse_from(args);
+        if let Some(Command::Analyze(analyze)) =
+            cli.command.take_if(|command| matches!(command, Command::Analyze(_)))
+        {
+            cli.files = analyze.logs;
+            cli.options = analyze.options;
+        }
+        if let (true, Some(loaded)) = (cli.verbose, loaded) {
+            let flags: Vec<String> = loaded
+                .args
+                .iter()
+                .map(|arg| arg.to_string_lossy().into_owned())
+                .collect();
+            eprintln!(
+                "Read default flags from {}: {}",
+                loaded.path.display(),
+                if flags.is_empty() {
+                    "none apply".to_string()
+                } else {
+                    flags.join(" ")
+                }
+            );
+        }
+        cli
+    }
+
+    /// Chart layout when --chart is set; the width falls back to the terminal's `COLUMNS`,
+    /// unless --deterministic is set.
+    pub fn chart_options(&self) -> Option<ChartOptions> {
+        if !self.chart {
+            return None;
+        }
+        let width = self.chart_width.or_else(|| {
+            std::env::var("COLUMNS")
+                .ok()
+                .filter(|_| !self.deterministic)
+                .and_then(|columns| columns.trim().parse().ok())
+        });
+        Some(ChartOptions {
+            width: width.unwrap_or(DEFAULT_CHART_WIDTH),
+            max_rows: self.chart_rows,
+            log_scale: self.chart_log,
+        })
+    }
+
+    /// The logs analyzed without a subcommand, of which clap then requires at least one.
+    pub fn log_files(&self) -> &[PathBuf] {
+        &self.files
+    }
+
+    /// Threads for parsing `files` logs: --parallel-files, or one per CPU up to one per log.
+    pub fn file_workers(&self, files: usize) -> usize {
+        self.parallel_files
+            .unwrap_or_else(|| parallel::default_workers(files))
+    }
+
+    /// The multiples byte counts are printed in: binary unless --si is given.
+    pub fn byte_units(&self) -> ByteUnits {
+        if self.si {
+            ByteUnits::Si
+        } else {
+            ByteUnits::Iec
+        }
+    }
+
+    /// --color, with --no-color and --deterministic as shorthands for never.
+    pub fn color_choice(&self) -> ColorChoice {
+        if self.no_color || self.deterministic {
+            ColorChoice::Never
+        } else {
+            self.color
+        }
+    }
+
+    /// Whether any requested report reads spawn inputs or individual files, which compact
+    /// logs only reconstruct on request.
+    pub fn needs_full_decode(&self) -> bool {
+        !self.full_decode_flags().is_empty()
+    }
+
+    /// The requested flags that make [`Cli::needs_full_decode`] true.
+    fn full_decode_flags(&self) -> Vec<&'static str> {
+        let listed_inputs = (self.all || self.include_spawns.is_some())
+            && self.spawn_columns.contains(&SpawnColumn::InputCount);
+        [
+            (self.input_counts, "--input-counts"),
+            (self.data_volume, "--data-volume"),
+            (self.largest_outputs, "--largest-outputs"),
+            (self.cas_footprint, "--cas-footprint"),
+            (self.input_upload_estimate, "--input-upload-estimate"),
+            (self.cache_cost, "--cache-cost"),
+            (self.input_dirs, "--input-dirs"),
+            (self.hot_inputs, "--hot-inputs"),
+            (self.hot_inputs_weighted.is_some(), "--hot-inputs-weighted"),
+            (self.input_overlap, "--input-overlap"),
+            (self.digest_functions, "--digest-functions"),
+            (self.compute_action_digests, "--compute-action-digests"),
+            (self.find_action_digest.is_some(), "--find-action-digest"),
+            (self.explain.is_some(), "--explain"),
+            (
+                self.critical_path == Some(CriticalPathMode::Deps),
+                "--critical-path deps",
+            ),
+            (
+                matches!(self.sort_by, ActionSort::OutputBytes | ActionSort::Inputs),
+                "--sort-by",
+            ),
+            (listed_inputs, "--spawn-columns input-count"),
+        ]
+        .into_iter()
+        .filter_map(|(requested, flag)| requested.then_some(flag))
+        .collect()
+    }
+
+    /// The mnemonics the mnemonic table folds into its "infrastructure actions" row:
+    /// [`DEFAULT_IGNORED_MNEMONICS`] unless --no-default-ignores is set, with --ignore-mnemonic
+    /// added and --unignore-mnemonic taken out.
+    pub fn ignored_mnemonics(&self) -> HashSet<&str> {
+        let defaults = if self.no_default_ignores {
+            &[][..]
+        } else {
+            &DEFAULT_IGNORED_MNEMONICS[..]
+        };
+        defaults
+            .iter()
+            .copied()
+            .chain(self.ignore_mnemonic.iter().map(String::as_str))
+            .filter(|mnemonic| !self.unignore_mnemonic.iter().any(|m| m == mnemonic))
+            .collect()
+    }
+
+    /// The first requested flag whose report reads what [`crate::execlog::Detail::Slim`] leaves out of each
+    /// spawn, so that --max-memory cannot apply.
+    pub fn needs_spawn_details(&self) -> Option<&'static str> {
+        let details = [
+            (self.show_args, "--show-args"),
+            (self.by_tool, "--by-tool"),
+            (self.env_variance, "--env-variance"),
+            (self.env_size, "--env-size"),
+            (self.why_miss, "--why-miss"),
+            (self.hermeticity, "--hermeticity"),
+        ];
+        self.full_decode_flags().into_iter().next().or_else(|| {
+            details
+                .into_iter()
+                .find_map(|(requested, flag)| requested.then_some(flag))
+        })
+    }
+}
+
+#[derive(Subcommand)]
+pub enum Command {
+    /// Analyze the logs, as when no subcommand is given
+    Analyze(AnalyzeArgs),
+    /// Compare two execution logs (e.g. yesterday's and today's build); filter flags apply to both
+    Diff(DiffArgs),
+    /// Fail when a log regresses against a baseline by more than the given thresholds
+    Check(CheckArgs),
+    /// Fail when the spawns a policy file's rules select break the rules' constraints
+    Policy(PolicyArgs),
+    /// Tabulate the headline numbers of a series of logs, e.g. successive nightly builds
+    Trend(TrendArgs),
+    /// Compare the cache hit rate of each mnemonic across logs, e.g. from several machines
+    Compare(CompareArgs),
+}
+
+#[derive(clap::Args)]
+pub struct AnalyzeArgs {
+    /// Path to the Bazel execution log file; several logs are analyzed together
+    #[arg(required = true)]
+    pub logs: Vec<PathBuf>,
+
+    #[command(flatten)]
+    pub options: AnalyzeOptions,
+}
+
+#[derive(clap::Args)]
+pub struct DiffArgs {
+    /// The baseline log
+    pub old: PathBuf,
+
+    /// The log compared against the baseline
+    pub new: PathBuf,
+
+    /// Mark mnemonics whose total time grew by more than this percentage
+    #[arg(long, default_value_t = 10.0)]
+    pub time_regression: f64,
+
+    /// Mark mnemonics whose cache hit rate dropped by more than this many percentage points
+    #[arg(long, default_value_t = 5.0)]
+    pub hit_rate_drop: f64,
+
+    /// Write every action found in only one of the logs to this file (tab-separated)
+    #[arg(long)]
+    pub diff_details: Option<PathBuf>,
+
+    /// Only count cache transitions of actions whose command line is identical in both logs
+    #[arg(long)]
+    pub same_command: bool,
+
+    /// Diff the inputs of the N slowest hit-to-miss transitions and rank the changed inputs they share
+    #[arg(long)]
+    pub explain_misses: Option<usize>,
+
+    /// Print the comparison as text tables or as one JSON document
+    #[arg(long, value_enum, default_value_t = OutputFormat::Text)]
+    pub output_format: OutputFormat,
+}
+
+#[derive(clap::Args)]
+#[command(group(
+    clap::ArgGroup::new("thresholds")
+        .required(true)
+        .multiple(true)
+        .args(["max_hit_rate_drop", "max_time_increase", "max_new_uncacheable", "write_baseline"])
+))]
+pub struct CheckArgs {
+    /// A baseline log, or a summary written by --write-baseline
+    pub baseline: PathBuf,
+
+    /// The log checked against the baseline
+    pub current: PathBuf,
+
+    /// Fail when the cache hit rate dropped by more than this many percentage points
+    #[arg(long)]
+    pub max_hit_rate_drop: Option<f64>,
+
+    /// Fail when the total spawn time grew by more than this percentage (e.g. 10%)
+    #[arg(long, value_parser = parse_percent)]
+    pub max_time_increase: Option<f64>,
+
+    /// Fail when more than this many actions are not cacheable that were not in the baseline
+    #[arg(long)]
+    pub max_new_uncacheable: Option<usize>,
+
+    /// Write a summary of the current log to this file, for use as a later baseline
+    #[arg(long)]
+    pub write_baseline: Option<PathBuf>,
+}
+
+#[derive(clap::Args)]
+pub struct PolicyArgs {
+    /// A TOML file of [[rule]] tables, each selecting spawns by mnemonic or label and constraining them
+    pub policy: PathBuf,
+
+    /// The logs checked against the policy; several logs are checked together
+    #[arg(required = true)]
+    pub logs: Vec<PathBuf>,
+}
+
+#[derive(clap::Args)]
+pub struct TrendArgs {
+    /// The logs of the series; quoted wildcards in file names (e.g. 'nightly/*.log') are expanded
+    #[arg(required = true)]
+    pub logs: Vec<PathBuf>,
+
+    /// Comma-separated names for the logs, in command-line order (default: their file names)
+    #[arg(long, value_delimiter = ',')]
+    pub label: Vec<String>,
+
+    /// Print the series as a text table, JSON or CSV
+    #[arg(long, value_enum, default_value_t = TrendFormat::Text)]
+    pub output_format: TrendFormat,
+}
+
+#[derive(clap::Args)]
+pub struct CompareArgs {
+    /// The logs to compare; quoted wildcards in file names are expanded
+    #[arg(required = true)]
+    pub logs: Vec<PathBuf>,
+
+    /// Comma-separated column names for the logs, in command-line order (default: their file names)
+    #[arg(long, value_delimiter = ',')]
+    pub label: Vec<String>,
+
+    /// Number of mnemonics by total time to compare
+    #[arg(short, long, default_value_t = 10)]
+    pub top_n: usize,
+
+    /// Mark hit rates that differ from their row's median by more than this many percentage points
+    #[arg(long, default_value_t = 10.0)]
+    pub deviation: f64,
+
+    /// Print the matrix as a text table or as one JSON document
+    #[arg(long, value_enum, default_value_t = OutputFormat::Text)]
+    pub output_format: OutputFormat,
+}
+
+/// Formats the trend series can be printed in.
+#[derive(Clone, Copy, PartialEq, Eq, ValueEnum)]
+pub enum TrendFormat {
+    /// A table with the largest changes between consecutive logs
+    Text,
+    /// A JSON document on stdout, with status messages on stderr
+    Json,
+    /// One line per log with the same fields as the JSON document
+    Csv,
+}
+
+/// Units durations can be printed in.
+#[derive(Clone, Copy, PartialEq, Eq, Debug, ValueEnum)]
+pub enum DurationFormat {
+    /// Seconds with a fixed number of decimals, e.g. `4.213s`
+    #[value(name = "s")]
+    Seconds,
+    /// Whole milliseconds, e.g. `4213ms`
+    #[value(name = "ms")]
+    Millis,
+    /// The largest unit that fits: `1h20m3s`, `4.2s`, `812ms`
+    Human,
+}
+
+/// When to color the report.
+#[derive(Clone, Copy, PartialEq, Eq, Debug, ValueEnum)]
+pub enum ColorChoice {
+    /// When stdout is a terminal and NO_COLOR is not set
+    Auto,
+    Always,
+    Never,
+}
+
+/// Formats warnings can be printed in.
+#[derive(Clone, Copy, PartialEq, Eq, Debug, ValueEnum)]
+pub enum WarningsFormat {
+    /// `Warning: ...` lines
+    Text,
+    /// One object per line with a stable `code`, a `message` and context such as `offset`
+    Json,
+}
+
+/// Formats a comparison can be printed in.
+#[derive(Clone, Copy, PartialEq, Eq, ValueEnum)]
+pub enum OutputFormat {
+    /// Tables for reading in a terminal
+    Text,
+    /// A JSON document on stdout, with status messages on stderr
+    Json,
+}
+
+/// Optional columns of the mnemonic table.
+#[derive(Clone, Copy, PartialEq, Eq, ValueEnum)]
+pub enum MnemonicColumn {
+    Count,
+    Hits,
+    Total,
+    /// Summed time of the spawns that missed the cache
+    Miss,
+    /// Percentage of the summed spawn time
+    Share,
+    /// Running total of the share column in table order
+    Cumulative,
+    Avg,
+    Min,
+    Median,
+    Max,
+    /// Execution wall time as a share of total time
+    Exec,
+}
+
+/// Columns of the --all listing. CSV, TSV and JSON Lines name them as here and keep raw
+/// numbers: times in nanoseconds, sizes in bytes.
+#[derive(Clone, Copy, PartialEq, Eq, ValueEnum)]
+pub enum SpawnColumn {
+    Label,
+    Mnemonic,
+    Runner,
+    /// Whether the spawn hit a cache
+    #[value(name = "cache_hit", alias = "cache-hit")]
+    CacheHit,
+    /// Whether the spawn ran remotely or hit the remote cache
+    Remote,
+    #[value(name = "exit_code", alias = "exit-code")]
+    ExitCode,
+    /// Total time
+    Duration,
+    /// Time waiting for a slot in the executor
+    Queue,
+    /// Time fetching outputs from the remote cache
+    Fetch,
+    /// Time setting up the sandbox or runner
+    Setup,
+    /// Number of input files (decodes compact logs fully)
+    #[value(name = "input_count", alias = "input-count")]
+    InputCount,
+    /// Summed size of the outputs
+    #[value(name = "output_bytes", alias = "output-bytes")]
+    OutputBytes,
+    /// The spawn's digest as hash/size
+    Digest,
+}
+
+#[derive(Clone, Copy, PartialEq, Eq, ValueEnum)]
+pub enum ListingFormat {
+    Text,
+    Csv,
+    Tsv,
+    /// One JSON object per spawn and line
+    Jsonl,
+}
+
+/// Metrics the top actions table can be ranked by (all descending).
+#[derive(Clone, Copy, PartialEq, Eq, ValueEnum)]
+pub enum ActionSort {
+    /// Total time
+    Duration,
+    /// Time fetching outputs from the remote cache
+    Fetch,
+    /// Time waiting for a slot in the executor
+    Queue,
+    /// Summed size of the outputs
+    OutputBytes,
+    /// Number of input files
+    Inputs,
+}
+
+impl ActionSort {
+    /// The top actions table's title, after "Top N".
+    pub fn title(self) -> &'static str {
+        match self {
+            ActionSort::Duration => "Slowest Actions",
+            other => other.ranking(),
+        }
+    }
+
+    /// The title when every action is listed, after "All N".
+    pub fn ranking(self) -> &'static str {
+        match self {
+            ActionSort::Duration => "Actions by Duration",
+            ActionSort::Fetch => "Actions by Fetch Time",
+            ActionSort::Queue => "Actions by Queue Time",
+            ActionSort::OutputBytes => "Actions by Output Size",
+            ActionSort::Inputs => "Actions by Input Count",
+        }
+    }
+}
+
+/// Sort orders for the mnemonic table (descending unless --asc).
+#[derive(Clone, Copy, PartialEq, Eq, ValueEnum)]
+pub enum MnemonicSort {
+    Total,
+    /// Time spent on cache misses
+    #[value(alias = "miss")]
+    MissTime,
+    Count,
+    /// Share of spawns that hit the cache
+    HitRate,
+    /// Mean recorded total time
+    Avg,
+}
+
+/// Sort orders for the --remote-overhead table (all descending).
+#[derive(Clone, Copy, PartialEq, Eq, ValueEnum)]
+pub enum OverheadSort {
+    Ratio,
+    Overhead,
+    Execution,
+    Count,
+}
+
+/// Ways --critical-path can reconstruct the path.
+#[derive(Clone, Copy, PartialEq, Eq, ValueEnum)]
+pub enum CriticalPathMode {
+    /// The chain of non-overlapping spawns spanning the build, from start timestamps
+    Time,
+    /// The longest chain of spawns linked by outputs consumed as inputs (reconstructs inputs)
+    Deps,
+}
+
+/// The consuming spawns --hot-inputs-weighted sums the time of.
+#[derive(Clone, Copy, PartialEq, Eq, ValueEnum)]
+pub enum WeightedConsumers {
+    /// Every spawn reading the input
+    All,
+    /// Only cache misses, as cache hits would not re-execute after a change either
+    Misses,
+}
+
+/// Which execution --dedupe keeps for an action that ran several times.
+#[derive(Clone, Copy, PartialEq, Eq, ValueEnum)]
+pub enum DedupeMode {
+    /// The final attempt, usually the one whose result was used
+    Last,
+    /// The first attempt
+    First,
+    /// Keep every execution
+    None,
+}
+
+/// Rows of the --export-timeline chart.
+#[derive(Clone, Copy, PartialEq, Eq, ValueEnum)]
+pub enum TimelineRows {
+    /// One row per mnemonic
+    Mnemonic,
+    /// One row per concurrently running slot, assigned in start order
+    Slot,
+}
+
+/// One --group-by table: a dimension, or two whose values are combined into one key.
+#[derive(Clone)]
+pub struct GroupKey {
+    pub dimensions: Vec<&'static Dimension>,
+}
+
+/// Parses a --group-by value: a dimension of [`grouping::DIMENSIONS`], or two joined with a
+/// comma.
+pub fn parse_group_key(value: &str) -> Result<GroupKey, String> {
+    let dimensions = value
+        .split(',')
+        .map(|name| {
+            grouping::dimension(name.trim()).ok_or_else(|| {
+                let names: Vec<&str> = grouping::DIMENSIONS.iter().map(|d| d.name).collect();
+                format!(
+                    "'{}' is not a dimension; use one of {}",
+                    name.trim(),
+                    names.join(", ")
+                )
+            })
+        })
+        .collect::<Result<Vec<_>, _>>()?;
+    match dimensions.as_slice() {
+        [first, second] if first.name == second.name => Err(format!(
+            "'{}' names the same dimension twice; use '{}'",
+            value, first.name
+        )),
+        [_] | [_, _] => Ok(GroupKey { dimensions }),
+        _ => Err(format!(
+            "'{}' combines {} dimensions; use one, or two joined with a comma",
+            value,
+            dimensions.len()
+        )),
+    }
+}
+
+/// The repositories a --group-prefix pattern covers.
+#[derive(Clone, PartialEq, Eq, Debug)]
+pub enum PatternRepository {
+    /// `//...`, or `@//...` and `@@//...`
+    Main,
+    /// `@...`
+    External,
+    /// `@repo//...` or `@@repo//...`
+    Named(String),
+}
+
+/// The targets of the packages a --group-prefix pattern covers.
+#[derive(Clone, PartialEq, Eq, Debug)]
+pub enum PatternScope {
+    /// `//foo/...`: the package and every package below it
+    Recursive,
+    /// `//foo:all` or `//foo:*`: every target of the package
+    Package,
+    /// `//foo:bar`, or `//foo` for `//foo:foo`
+    Target(String),
+}
+
+/// One --group-prefix pattern, e.g. `//services/payments/...`.
+#[derive(Clone, Debug)]
+pub struct LabelPattern {
+    /// The pattern as given, which names its row.
+    pub text: String,
+    pub repository: PatternRepository,
+    /// The package path below `//`, without slashes around it.
+    pub package: String,
+    pub scope: PatternScope,
+}
+
+/// Parses a Bazel target pattern: `//foo/...`, `//foo:all`, `//foo:bar`, each optionally in
+/// `@repo`, or `@...` for every external repository. `...` is only allowed at the end.
+pub fn parse_label_pattern(value: &str) -> Result<LabelPattern, String> {
+    let invalid = |reason: &str| {
+        Err(format!(
+            "'{}' is not a target pattern ({}); use e.g. //foo/..., //foo:all or @repo//...",
+            value, reason
+        ))
+    };
+    if value == "@..." || value == "@@..." {
+        return Ok(LabelPattern {
+            text: value.to_string(),
+            repository: PatternRepository::External,
+            package: String::new(),
+            scope: PatternScope::Recursive,
+        });
+    }
+    let (repository, rest) = match value.strip_prefix("@@").or_else(|| value.strip_prefix('@')) {
+        Some(rest) => match rest.split_once("//") {
+            Some(("", path)) => (PatternRepository::Main, path),
+            Some((name, path)) => (PatternRepository::Named(name.to_string()), path),
+            None => return invalid("no // after the repository"),
+        },
+        None => match value.strip_prefix("//") {
+            Some(path) => (PatternRepository::Main, path),
+            None => return invalid("it does not start with // or @"),
+        },
+    };
+    let (path, target) = match rest.split_once(':') {
+        Some((path, target)) => (path, Some(target)),
+        None => (rest, None),
+    };
+    let (package, scope) = if path == "..." || path.ends_with("/...") {
+        if target.is_some_and(|t| !matches!(t, "all" | "*" | "all-targets")) {
+            return invalid("a recursive pattern names no single target");
+        }
+        (path.trim_end_matches("...").trim_end_matches('/'), PatternScope::Recursive)
+    } else {
+        match target {
+            Some("all" | "*" | "all-targets") => (path, PatternScope::Package),
+            Some("") => return invalid("empty target name"),
+            Some(name) => (path, PatternScope::Target(name.to_string())),
+            None => match path.rsplit('/').next().filter(|name| !name.is_empty()) {
+                Some(name) => (path, PatternScope::Target(name.to_string())),
+                None => return invalid("empty package"),
+            },
+        }
+    };
+    if package.contains("...") {
+        return invalid("`...` is only allowed at the end");
+    }
+    Ok(LabelPattern {
+        text: value.to_string(),
+        repository,
+        package: package.trim_matches('/').to_string(),
+        scope,
+    })
+}
+
+/// The spawn field an --allow-failures pattern is matched against.
+#[derive(Clone, Copy, PartialEq, Eq, Debug)]
+pub enum AllowanceField {
+    Mnemonic,
+    Label,
+}
+
+/// One --allow-failures entry, e.g. `mnemonic=TestRunner`.
+#[derive(Clone, Debug)]
+pub struct FailureAllowance {
+    pub field: AllowanceField,
+    pub pattern: String,
+}
+
+/// Parses `mnemonic=PATTERN` or `label=PATTERN`.
+pub fn parse_failure_allowance(value: &str) -> Result<FailureAllowance, String> {
+    let (field, pattern) = value
+        .split_once('=')
+        .ok_or_else(|| format!("expected mnemonic=PATTERN or label=PATTERN, got '{}'", value))?;
+    let field = match field.trim() {
+        "mnemonic" => AllowanceField::Mnemonic,
+        "label" => AllowanceField::Label,
+        other => return Err(format!("unknown field '{}', expected mnemonic or label", other)),
+    };
+    if pattern.is_empty() {
+        return Err(format!("empty pattern in '{}'", value));
+    }
+    Ok(FailureAllowance {
+        field,
+        pattern: pattern.to_string(),
+    })
+}
+
+/// One --summary-label entry, e.g. `sha=4f2c1e0`.
+#[derive(Clone, Debug)]
+pub struct SummaryLabel {
+    /// The column name.
+    pub key: String,
+    pub value: String,
+}
+
+/// Parses `KEY=VALUE`, where the key is a column name of letters, digits, `_`, `-` and `.`.
+pub fn parse_summary_label(value: &str) -> Result<SummaryLabel, String> {
+    let (key, label) = value
+        .split_once('=')
+        .ok_or_else(|| format!("expected KEY=VALUE, got '{}'", value))?;
+    let key = key.trim();
+    if key.is_empty()
+        || !key
+            .chars()
+            .all(|c| c.is_ascii_alphanumeric() || matches!(c, '_' | '-' | '.'))
+    {
+        return Err(format!(
+            "'{}' is not a column name; use letters, digits, '_', '-' and '.'",
+            key
+        ));
+    }
+    Ok(SummaryLabel {
+        key: key.to_string(),
+        value: label.to_string(),
+    })
+}
+
+/// Parses a row limit, where `0` and `all` stand for no limit (`usize::MAX`).
+pub fn parse_limit(value: &str) -> Result<usize, String> {
+    if value.eq_ignore_ascii_case("all") {
+        return Ok(usize::MAX);
+    }
+    match value.parse::<usize>() {
+        Ok(0) => Ok(usize::MAX),
+        Ok(limit) => Ok(limit),
+        Err(_) => Err(format!(
+            "'{}' is not a row limit; use a positive number, or 0 or `all` for every row",
+            value
+        )),
+    }
+}
+
+/// Parses the --include-spawns count, which unlike a row limit takes `all` only when spelled
+/// out, as every spawn of a large log makes a very large document.
+pub fn parse_spawn_count(value: &str) -> Result<usize, String> {
+    if value.eq_ignore_ascii_case("all") {
+        return Ok(usize::MAX);
+    }
+    match value.parse::<usize>() {
+        Ok(count) if count > 0 => Ok(count),
+        _ => Err(format!(
+            "'{}' is not a spawn count; use a positive number, or `all` for every spawn",
+            value
+        )),
+    }
+}
+
+/// Parses the --parallel-files thread count.
+pub fn parse_worker_count(value: &str) -> Result<usize, String> {
+    match value.parse::<usize>() {
+        Ok(count) if count > 0 => Ok(count),
+        _ => Err(format!(
+            "'{}' is not a thread count; use a positive number",
+            value
+        )),
+    }
+}
+
+/// Checks an --output-format name against the registered formats. `help` never gets here; it
+/// is looked for by [`asks_for_output_formats`] before the arguments are parsed.
+pub fn parse_output_format(value: &str) -> Result<String, String> {
+    if report::output_writer(value).is_some() {
+        return Ok(value.to_string());
+    }
+    let names: Vec<&str> = report::output_writers().iter().map(|w| w.name).collect();
+    Err(format!(
+        "'{}' is not an output format; use one of {}, or `help`",
+        value,
+        names.join(", ")
+    ))
+}
+
+/// Whether `args` (the program name first) ask for `--output-format help`, which lists the
+/// formats and, like --help, needs no log files. The subcommands have output formats of their
+/// own, so it is only looked for before one is named.
+pub fn asks_for_output_formats(args: &[OsString]) -> bool {
+    let command = Cli::command();
+    let mut previous = None;
+    for arg in args.iter().skip(1).filter_map(|arg| arg.to_str()) {
+        if command.find_subcommand(arg).is_some() {
+            return false;
+        }
+        if arg == "--output-format=help" || (previous == Some("--output-format") && arg == "help") {
+            return true;
+        }
+        previous = Some(arg);
+    }
+    false
+}
+
+/// Parses a byte count such as `2GiB`, `512MB` or `1000000`: K, M, G and T with `B` are
+/// multiples of 1000, with `iB` multiples of 1024.
+pub fn parse_byte_size(value: &str) -> Result<u64, String> {
+    let trimmed = value.trim();
+    let number_len = trimmed
+        .find(|c: char| !(c.is_ascii_digit() || c == '.'))
+        .unwrap_or(trimmed.len());
+    let (number, unit) = trimmed.split_at(number_len);
+    let multiplier: f64 = match unit.trim().to_ascii_lowercase().as_str() {
+        "" | "b" => 1.0,
+        "kb" => 1e3,
+        "mb" => 1e6,
+        "gb" => 1e9,
+        "tb" => 1e12,
+        "kib" => 1024.0,
+        "mib" => 1024.0 * 1024.0,
+        "gib" => 1024.0 * 1024.0 * 1024.0,
+        "tib" => 1024.0 * 1024.0 * 1024.0 * 1024.0,
+        _ => {
+            return Err(format!(
+                "'{}' is not a size; use a number of bytes, optionally followed by KB, MB, GB, KiB, MiB or GiB",
+                value
+            ))
+        }
+    };
+    match number.parse::<f64>() {
+        Ok(number) if number.is_finite() => Ok((number * multiplier) as u64),
+        _ => Err(format!("'{}' is not a size; use e.g. 2GiB or 512MB", value)),
+    }
+}
+
+/// Parses a percentage such as `10%` or `2.5`.
+pub fn parse_percent(value: &str) -> Result<f64, String> {
+    let number = value.trim().trim_end_matches('%').trim();
+    match number.parse::<f64>() {
+        Ok(percent) if percent.is_finite() && percent >= 0.0 => Ok(percent),
+        _ => Err(format!("invalid percentage '{}'", value)),
+    }
+}
+
+/// Parses a duration such as `250ms`, `1.5s`, `5m` or `1h30m`.
+pub fn parse_duration(value: &str) -> Result<Duration, String> {
+    let value = value.trim();
+    if value.is_empty() {
+        return Err("empty duration".to_string());
+    }
+    let mut total = 0.0_f64;
+    let mut rest = value;
+    while !rest.is_empty() {
+        let number_len = rest
+            .find(|c: char| !(c.is_ascii_digit() || c == '.'))
+            .unwrap_or(rest.len());
+        let number: f64 = rest[..number_len]
+            .parse()
+            .map_err(|_| format!("invalid duration '{}'", value))?;
+        rest = &rest[number_len..];
+        let unit_len = rest
+            .find(|c: char| c.is_ascii_digit() || c == '.')
+            .unwrap_or(rest.len());
+        let multiplier = match &rest[..unit_len] {
+            "ns" => 1e-9,
+            "us" | "µs" => 1e-6,
+            "ms" => 1e-3,
+            "s" | "" => 1.0,
+            "m" => 60.0,
+            "h" => 3600.0,
+            unit => return Err(format!("unknown duration unit '{}' in '{}'", unit, value)),
+        };
+        rest = &rest[unit_len..];
+        total += number * multiplier;
+    }
+    Duration::try_from_secs_f64(total).map_err(|e| format!("invalid duration '{}': {}", value, e))
+}
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn parse_duration_sums_units() {
+        assert_eq!(parse_duration("1h30m").unwrap(), Duration::from_secs(5400));
+        assert_eq!(parse_duration("250ms").unwrap(), Duration::from_millis(250));
+        assert_eq!(parse_duration("2.5").unwrap(), Duration::from_millis(2500));
+        assert!(parse_duration("5 parsecs").is_err());
+        assert!(parse_duration("").is_err());
+    }
+
+    #[test]
+    fn parse_duration_rejects_overflow() {
+        assert!(parse_duration("99999999999999999999h").is_err());
+    }
+}
@@ -1,28 +1,29 @@
-use crate::cli::Cli;
-use crate::proto::exec_log_entry::{self as compact, Type as CompactEntryType};
-use crate::proto::{ExecLogEntry, SpawnExec};
-use crate::{AppError, AppResult};
-use prost::Message;
+use crate::analysis::{action_key, summarize};
+use crate::cli::{Cli, CriticalPathMode, DedupeMode, WeightedConsumers};
+use crate::filter::{FilterSummary, SpawnFilter};
+use crate::format::{
+    bar, format_bytes, format_duration, format_duration_short, format_seconds, mark_filtered,
+    section, top_label,
+};
+use crate::metrics::{recorded_total_time, to_std_duration};
+use crate::stats::{histogram, log_bucket_bounds};
+use crate::proto::SpawnExec;
+use crate::commands::{split, verify};
+use crate::dedupe;
+use crate::execlog::{estimated_memory, keep_parsed, parse_log_file, parse_log_files, Detail};
+use crate::report::{highest_first, output_writer, sorted_actions, Report};
+use crate::reports;
+use crate::reports::grouping::KeyOptions;
+use crate::reports::listing::write_spawn_listing;
+use crate::reports::text::{low_hit_rate, write_filter_summary};
+use crate::style::{paint, Style};
+use crate::timing::{self, Stage};
+use crate::{AppError, AppResult, Gate};
 use std::collections::HashMap;
 use std::fs;
-use std::path::Path;
+use std::io::{self, Write};
+use std::path::PathBuf;
 use std::time::Duration;
-use zstd::stream::decode_all;
-
-/// Helper to convert prost's Duration to std's Duration
-fn to_std_duration(prost_duration: &prost_types::Duration) -> Duration {
-    Duration::new(
-        prost_duration.seconds.try_into().unwrap_or(0),
-        prost_duration.nanos.try_into().unwrap_or(0),
-    )
-}
-
-#[derive(Default)]
-struct MnemonicMetrics {
-    count: u64,
-    cache_hits: u64,
-    total_duration: Duration,
-}
 
 #[derive(Default)]
 struct ExecutionTimings {
@@ -36,30 +37,306 @@ struct MnemonicExecutionStats {
     local: ExecutionTimings,
 }
 
-/// An enum to hold different types of compact log entries for reconstruction.
-enum StoredEntry {
-    File(compact::File),
-    Directory(compact::Directory),
+/// Cross-log mismatches listed by the --merge-dedup summary.
+const MAX_MISMATCHES_SHOWN: usize = 10;
+
+fn print_merge_summary(summary: &dedupe::MergeSummary) {
+    if summary.overlapping == 0 {
+        println!(
+            "Merged {} logs: no action appears in more than one log.",
+            summary.logs
+        );
+        return;
+    }
+    let compared = summary.overlapping - summary.uncompared;
+    println!(
+        "Merged {} logs: {} actions appeared in more than one log{}; {} repeated spawns are counted once (--merge-dedup).",
+        summary.logs,
+        summary.overlapping,
+        if compared == 0 {
+            String::new()
+        } else {
+            format!(
+                ", {:.1}% with identical outputs",
+                summary.identical_outputs as f64 / compared as f64 * 100.0
+            )
+        },
+        summary.dropped
+    );
+    if summary.uncompared > 0 {
+        println!(
+            "{} of them recorded no output digests in some log to compare.",
+            summary.uncompared
+        );
+    }
+    if summary.mismatches.is_empty() {
+        return;
+    }
+    println!(
+        "Warning: {} actions produced different outputs in different logs, which points to non-determinism:",
+        summary.mismatches.len()
+    );
+    for mismatch in summary.mismatches.iter().take(MAX_MISMATCHES_SHOWN) {
+        println!(
+            "  {} {}: {} differs between logs {} and {}",
+            mismatch.mnemonic,
+            if mismatch.label.is_empty() {
+                reports::grouping::NO_LABEL
+            } else {
+                &mismatch.label
+            },
+            mismatch.path,
+            mismatch.logs.0,
+            mismatch.logs.1
+        );
+    }
+    if summary.mismatches.len() > MAX_MISMATCHES_SHOWN {
+        println!(
+            "... (+{} more actions)",
+            summary.mismatches.len() - MAX_MISMATCHES_SHOWN
+        );
+    }
+}
+
+/// Parses the logs, merges them under --merge-dedup and applies the filter flags, for modes
+/// that work on the spawns without printing the report.
+pub fn load_spawns(args: &Cli) -> AppResult<Vec<SpawnExec>> {
+    let (_, logs) = parse_logs(args, Detail::Standard)?;
+    let spawns = if args.merge_dedup && logs.len() > 1 {
+        dedupe::merge_logs(logs).0
+    } else {
+        logs.into_iter().flatten().collect()
+    };
+    let filter = SpawnFilter::from_cli(args);
+    Ok(if filter.is_active() {
+        filter.apply(spawns).0
+    } else {
+        spawns
+    })
+}
+
+/// Parses the logs on --parallel-files threads: the paths of those that were parsed, which
+/// under --skip-errors may be fewer than were given, and one list of spawns for each.
+fn parse_logs(args: &Cli, detail: Detail) -> AppResult<(Vec<PathBuf>, Vec<Vec<SpawnExec>>)> {
+    let paths = args.log_files();
+    let results = parse_log_files(paths, detail, args.file_workers(paths.len()));
+    let parsed = keep_parsed(paths.iter().map(PathBuf::as_path).zip(results), args.skip_errors)?;
+    Ok(parsed
+        .into_iter()
+        .map(|(path, spawns)| (path.to_path_buf(), spawns))
+        .unzip())
+}
+
+/// How much of each spawn --max-memory leaves room for: `detail` while the logs fit, else
+/// [`Detail::Slim`], unless a requested report needs what that leaves out.
+fn memory_detail(args: &Cli, max_memory: u64, detail: Detail) -> AppResult<Detail> {
+    // A log that cannot be read fails, with its name, once it is parsed.
+    let estimate = args
+        .log_files()
+        .iter()
+        .map(|path| estimated_memory(path).unwrap_or(0))
+        .fold(0, u64::saturating_add);
+    if estimate <= max_memory {
+        return Ok(detail);
+    }
+    let (estimate, max_memory) = (format_bytes(estimate as i64), format_bytes(max_memory as i64));
+    if let Some(flag) = args.needs_spawn_details() {
+        return Err(AppError::Analysis(format!(
+            "{} needs every spawn in full, which would take about {} of memory for these logs, more than --max-memory {}; leave it out or raise --max-memory",
+            flag, estimate, max_memory
+        )));
+    }
+    eprintln!(
+        "The logs would take about {} of memory, more than --max-memory {}: keeping only what the report reads of each spawn.",
+        estimate, max_memory
+    );
+    Ok(Detail::Slim)
+}
+
+pub fn run_analyze(mut args: Cli) -> AppResult<()> {
+    let mut detail = if args.needs_full_decode() {
+        Detail::Full
+    } else {
+        Detail::Standard
+    };
+    if let Some(max_memory) = args.max_memory {
+        detail = memory_detail(&args, max_memory, detail)?;
+    }
+    let (parsed, logs) = parse_logs(&args, detail)?;
+    // The report and the --quiet summaries name only the logs they cover.
+    args.files = parsed;
+    analyze_logs(args, logs, detail)
 }
 
-pub fn run_analyze(args: Cli) -> AppResult<()> {
-    let spawns = parse_log_file(&args.file)?;
+/// Prints the report for logs parsed already, one list of spawns per log, decoded to `detail`.
+pub fn analyze_logs(args: Cli, logs: Vec<Vec<SpawnExec>>, detail: Detail) -> AppResult<()> {
+    // Formats other than text print one document in place of the text output.
+    let document = args.output_format != "text";
+    if args.quiet && !document {
+        return print_quiet_summaries(&args, logs);
+    }
+    // Keeps documents and the --all listing free of report lines, unless --summary asks for
+    // them.
+    let report = !document && (!args.all || args.summary);
+    let log_count = logs.len();
+    let (spawns, merge_summary) = if args.merge_dedup && log_count > 1 {
+        let (spawns, summary) = dedupe::merge_logs(logs);
+        (spawns, Some(summary))
+    } else {
+        (logs.into_iter().flatten().collect::<Vec<_>>(), None)
+    };
 
     if spawns.is_empty() {
+        if args.strict {
+            return Err(AppError::EmptyLog(
+                "the execution log contains no spawn actions (--strict)".to_string(),
+            ));
+        }
+        if document {
+            return finish_without_report(&spawns, &args, None);
+        }
         println!("Execution log is empty or contains no spawn actions. No metrics to report.");
+        if args.ci_summary {
+            reports::ci_summary::print_ci_summary(&spawns)?;
+        }
+        if let Some(path) = &args.append_summary {
+            reports::ci_summary::append_summary(path, &args.summary_label, &spawns)?;
+        }
         return Ok(());
     }
-    println!(
-        "Successfully parsed and reconstructed {} spawn entries from the log.",
-        spawns.len()
-    );
+    if report {
+        println!(
+            "Successfully parsed and reconstructed {} spawn entries from {}.",
+            spawns.len(),
+            if log_count == 1 {
+                "the log".to_string()
+            } else {
+                format!("{} logs", log_count)
+            }
+        );
+        if let Some(summary) = &merge_summary {
+            print_merge_summary(summary);
+        }
+    }
+
+    let filter = SpawnFilter::from_cli(&args);
+    let (spawns, filter_summary) = if filter.is_active() {
+        let (matching, summary) = filter.apply(spawns);
+        mark_filtered(true);
+        (matching, Some(summary))
+    } else {
+        (spawns, None)
+    };
+
+    if spawns.is_empty() {
+        if document {
+            return finish_without_report(&spawns, &args, filter_summary.as_ref());
+        }
+        if let Some(summary) = &filter_summary {
+            write_filter_summary(&mut io::stdout().lock(), summary, &spawns)?;
+        }
+        println!("No spawn actions match the active filters. No metrics to report.");
+        if args.ci_summary {
+            reports::ci_summary::print_ci_summary(&spawns)?;
+        }
+        if let Some(path) = &args.append_summary {
+            reports::ci_summary::append_summary(path, &args.summary_label, &spawns)?;
+        }
+        return Ok(());
+    }
+
+    // Detected before --dedupe collapses the repeats.
+    let duplicates = args.duplicates.then(|| dedupe::find_duplicates(&spawns));
+    let mut spawns = spawns;
+    if args.dedupe != DedupeMode::None {
+        let dropped = dedupe::dedupe(&mut spawns, args.dedupe);
+        if dropped > 0 && report {
+            println!(
+                "Counting repeatedly executed actions once: dropped {} repeated executions (--dedupe).",
+                dropped
+            );
+        }
+    }
+
+    if args.verify_outputs {
+        return verify::run_verify(&spawns, &args);
+    }
+    // Checked before anything is printed, as the JSON document lists the gaps too.
+    if args.idle_gaps && reports::concurrency::idle_gaps(&spawns, args.idle_gap_min).is_none() {
+        return Err(AppError::Analysis(
+            "no spawn start times found in the log, so --idle-gaps cannot find idle time; start times are part of the spawn metrics, which Bazel 7 and later record".to_string(),
+        ));
+    }
+    // Likewise the targets --explain drills into.
+    if let Some(pattern) = &args.explain {
+        let explanation = reports::explain::explain(&spawns, pattern, args.explain_shards);
+        if explanation.targets.is_empty() {
+            let example = spawns.iter().map(|s| s.target_label.as_str()).find(|l| !l.is_empty());
+            return Err(AppError::Analysis(match example {
+                Some(example) => format!(
+                    "no spawn in the log has a label that --explain {} matches; labels look like {}",
+                    pattern.text, example
+                ),
+                None => "no spawn in the log has a target label, so --explain has nothing to drill down into".to_string(),
+            }));
+        }
+        if explanation.is_many() {
+            eprintln!(
+                "Warning: --explain {} matches {} targets; their spawns are listed together, give one label to drill down into a single target.",
+                pattern.text,
+                explanation.targets.len()
+            );
+        }
+    }
+
+    if document {
+        return finish_without_report(&spawns, &args, filter_summary.as_ref());
+    }
+    if let Some(dir) = &args.output_dir {
+        split::run_split(&spawns, &args, dir)?;
+        return finish_without_report(&spawns, &args, filter_summary.as_ref());
+    }
+
+    if args.all {
+        write_listing(&spawns, &args)?;
+        if !report {
+            return finish_without_report(&spawns, &args, filter_summary.as_ref());
+        }
+    }
 
     // --- Print Main Report ---
-    print_main_report(&spawns, &args);
+    render_report(&spawns, &args, filter_summary.as_ref())?;
+    reports::phases::print_time_by_phase_report(&spawns);
+    if let Some(per_mnemonic) = args.top_per_mnemonic {
+        reports::slowest::print_top_per_mnemonic_report(&spawns, per_mnemonic, args.top_n);
+    }
+    if let Some(per_runner) = args.top_per_runner {
+        reports::slowest::print_top_per_runner_report(&spawns, per_runner);
+    }
+    reports::failures::print_exit_code_report(&spawns);
+    reports::failures::print_timeout_report(&spawns, args.timeouts);
+    reports::failures::print_failed_actions_report(&spawns, args.max_failed);
+    if let Some(groups) = &duplicates {
+        reports::failures::print_duplicates_report(groups, args.top_n);
+    }
+
+    let key_options = KeyOptions::from_cli(&args);
+    for key in &args.group_by {
+        reports::grouping::print_group_by_report(
+            &spawns,
+            key,
+            &key_options,
+            args.top_n,
+            args.chart_options().as_ref(),
+        );
+    }
+    if !args.group_prefix.is_empty() {
+        reports::grouping::print_label_pattern_report(&spawns, &args.group_prefix);
+    }
 
     // --- Optional Reports ---
     if args.cache_metrics {
-        print_cache_performance_report(&spawns);
+        reports::cache::print_cache_performance_report(&spawns, &args);
     }
     if args.phase_timings {
         print_phase_timings_report(&spawns, args.top_n);
@@ -67,6 +344,12 @@ pub fn run_analyze(args: Cli) -> AppResult<()> {
     if args.input_analysis {
         print_input_analysis_report(&spawns, args.top_n);
     }
+    if args.uncacheable || args.fail_if_uncacheable_time.is_some() {
+        reports::restrictions::print_uncacheable_report(&spawns, args.top_n);
+    }
+    if args.remotability {
+        reports::restrictions::print_remotability_report(&spawns, args.top_n);
+    }
     if args.retries {
         print_retries_and_failures_report(&spawns);
     }
@@ -78,6 +361,9 @@ pub fn run_analyze(args: Cli) -> AppResult<()> {
     if args.output_analysis {
         print_output_analysis_report(&spawns, args.top_n);
     }
+    if args.size_report {
+        reports::cache::print_output_size_report(&spawns, args.top_n);
+    }
     if args.memory_analysis {
         print_memory_analysis_report(&spawns, args.top_n);
     }
@@ -87,342 +373,416 @@ pub fn run_analyze(args: Cli) -> AppResult<()> {
     if args.queue_analysis {
         print_queue_analysis_report(&spawns, args.top_n);
     }
+    if let Some(mode) = args.critical_path {
+        match mode {
+            CriticalPathMode::Time => reports::critical_path::print_critical_path_report(&spawns),
+            CriticalPathMode::Deps => {
+                reports::critical_path::print_dependency_critical_path_report(&spawns)
+            }
+        }
+    }
+    if args.concurrency {
+        reports::concurrency::print_concurrency_report(
+            &spawns,
+            args.concurrency_bucket,
+            args.concurrency_below,
+            args.sparkline,
+        );
+    }
+    if args.parallelism || args.wall_time.is_some() {
+        reports::concurrency::print_parallelism_report(&spawns, args.wall_time);
+    }
+    if args.idle_gaps {
+        reports::concurrency::print_idle_gaps_report(&spawns, args.idle_gap_min, args.top_n);
+    }
+    if args.histogram {
+        print_histogram_report(&spawns, &args.histogram_buckets);
+    }
+    for mnemonic in &args.mnemonic_histogram {
+        print_mnemonic_histogram_report(&spawns, mnemonic);
+    }
+    if args.config_split {
+        reports::grouping::print_configuration_report(&spawns);
+    }
+    if args.external_repos {
+        reports::grouping::print_repository_report(&spawns, args.top_n);
+    }
+    if args.sandbox_overhead {
+        reports::runners::print_sandbox_overhead_report(&spawns, args.min_samples);
+    }
+    if args.worker_analysis {
+        reports::runners::print_worker_analysis_report(&spawns);
+    }
+    if args.phases {
+        reports::phases::print_mnemonic_phases_report(&spawns, args.phases_top);
+    }
+    if args.remote_overhead {
+        reports::remote::print_remote_overhead_report(&spawns, args.remote_overhead_sort);
+    }
+    if args.remote_fallbacks {
+        reports::remote::print_remote_fallback_report(&spawns);
+    }
+    if args.input_counts {
+        reports::inputs::print_input_count_report(&spawns, args.top_n);
+    }
+    if args.data_volume {
+        reports::inputs::print_data_volume_report(&spawns);
+    }
+    if args.largest_outputs {
+        reports::outputs::print_largest_outputs_report(&spawns, args.top_n);
+    }
+    if args.cas_footprint {
+        reports::cache::print_cas_footprint_report(&spawns, args.top_n);
+    }
+    if args.input_upload_estimate {
+        reports::cache::print_input_upload_report(&spawns, args.top_n);
+    }
+    if args.cache_cost {
+        let previous = match &args.previous_log {
+            Some(path) => Some(parse_log_file(path, args.needs_full_decode())?),
+            None => None,
+        };
+        let options = reports::cache::CacheCostOptions {
+            builds_per_day: args.builds_per_day,
+            change_rate: args.change_rate,
+        };
+        reports::cache::print_cache_cost_report(&spawns, previous.as_deref(), &options);
+    }
+    if args.remote_cache_benefit {
+        let options = reports::cache::RemoteCacheBenefitOptions {
+            hit_rate: args.assumed_hit_rate,
+            download_rate: args.assumed_download_rate * 1_000_000.0,
+        };
+        reports::cache::print_remote_cache_benefit_report(&spawns, &options);
+    }
+    if args.cache_break_even {
+        reports::cache::print_cache_break_even_report(&spawns, args.break_even_ratio);
+    }
+    if args.input_dirs {
+        reports::inputs::print_input_dirs_report(&spawns, args.depth, args.top_n);
+    }
+    if args.hot_inputs {
+        reports::inputs::print_hot_inputs_report(&spawns, &args.ignore_input_prefix, args.top_n);
+    }
+    if let Some(consumers) = args.hot_inputs_weighted {
+        reports::inputs::print_weighted_hot_inputs_report(
+            &spawns,
+            &args.ignore_input_prefix,
+            consumers == WeightedConsumers::Misses,
+            args.top_n,
+        );
+    }
+    if args.input_overlap {
+        reports::inputs::print_input_overlap_report(&spawns, args.overlap_mnemonics);
+    }
+    if args.metrics_coverage {
+        reports::phases::print_metrics_coverage_report(&spawns, args.top_n);
+    }
+    if args.field_coverage {
+        reports::fields::print_field_coverage_report(&spawns, detail);
+    } else {
+        // Shown when a key field is hardly ever recorded, as the reports on it are all zeros.
+        reports::fields::print_sparse_fields_note(&spawns, detail);
+    }
+    if args.tree_artifacts {
+        reports::outputs::print_tree_artifacts_report(&spawns);
+    }
+    if args.symlinks {
+        reports::outputs::print_symlinks_report(&spawns, args.top_n);
+    }
+    // Usually only printed when many spawns are affected, as they skew the output statistics.
+    reports::outputs::print_zero_outputs_report(&spawns, args.top_n, args.zero_outputs);
+    if args.suggest {
+        reports::runners::print_worker_suggestions_report(
+            &spawns,
+            args.suggest_min_count,
+            args.suggest_max_avg,
+        );
+    }
+    if args.compute_action_digests {
+        reports::action_digests::print_action_digests_report(&spawns);
+    }
+    if let Some(query) = &args.find_action_digest {
+        reports::action_digests::print_action_digest_lookup(&spawns, query);
+    }
+    if let Some(pattern) = &args.explain {
+        reports::explain::print_explain_report(&spawns, pattern, args.explain_shards);
+    }
+    if args.by_tool {
+        reports::tools::print_tool_report(&spawns, args.tool_full_path, args.top_n);
+    }
+    if args.platform_summary || args.platform_key.is_some() {
+        reports::grouping::print_platform_report(&spawns, args.platform_key.as_deref());
+    }
+    if let Some(key) = &args.pool_key {
+        reports::remote::print_pool_report(&spawns, key);
+    }
+    if args.env_variance {
+        reports::environment::print_env_variance_report(
+            &spawns,
+            &args.env_variance_ignore,
+            args.top_n,
+        );
+    }
+    if args.env_size {
+        reports::environment::print_env_size_report(
+            &spawns,
+            args.env_size_threshold,
+            args.top_n,
+        );
+    }
+    let non_hermetic = if args.hermeticity {
+        let workspace = args.workspace.as_ref().map(|path| path.to_string_lossy());
+        reports::hermeticity::print_hermeticity_report(
+            &spawns,
+            workspace.as_deref(),
+            &args.hermeticity_allow,
+        )
+    } else {
+        0
+    };
+    if args.why_miss {
+        reports::why_miss::print_why_miss_report(&spawns, args.top_n);
+    }
+    if args.insights {
+        reports::insights::print_insights_report(&spawns);
+    }
+    let conflicts = reports::outputs::print_output_conflicts_report(&spawns);
+    let mismatches =
+        reports::outputs::print_output_mismatches_report(&spawns, &args.output_mismatch_exempt);
+    // Mixed or malformed digests are shown even when not requested, as they break caching.
+    reports::hashing::print_digest_functions_report(&spawns, args.digest_functions);
+
+    if let Some(path) = &args.export_timeline {
+        let bars = reports::timeline::write_timeline(
+            &spawns,
+            path,
+            args.timeline_rows,
+            args.timeline_max_bars,
+        )?;
+        println!("Wrote a timeline of {} spawns to {}", bars, path.display());
+    }
 
-    Ok(())
+    let gates = check_gates(&spawns, &args, conflicts, mismatches, non_hermetic);
+    if args.ci_summary {
+        reports::ci_summary::print_ci_summary(&spawns)?;
+    }
+    if let Some(path) = &args.append_summary {
+        reports::ci_summary::append_summary(path, &args.summary_label, &spawns)?;
+    }
+    gates
 }
 
-/// Parses the log file, auto-detecting the format (compact or verbose).
-fn parse_log_file(path: &Path) -> AppResult<Vec<SpawnExec>> {
-    let raw_bytes = fs::read(path)?;
-
-    // 1. Try parsing as a zstd-compressed compact log first.
-    if let Ok(decompressed) = decode_all(raw_bytes.as_slice()) {
-        if let Ok(spawns) = parse_compact_log(&decompressed) {
-            println!("Detected zstd-compressed compact log format.");
-            return Ok(spawns);
+/// --quiet: the overall summary of each log under its file name, then the gates over all logs
+/// together, as in the full report.
+fn print_quiet_summaries(args: &Cli, logs: Vec<Vec<SpawnExec>>) -> AppResult<()> {
+    let filter = SpawnFilter::from_cli(args);
+    let mut all = Vec::new();
+    for (path, spawns) in args.log_files().iter().zip(logs) {
+        let mut spawns = if filter.is_active() {
+            filter.apply(spawns).0
+        } else {
+            spawns
+        };
+        if args.dedupe != DedupeMode::None {
+            dedupe::dedupe(&mut spawns, args.dedupe);
         }
+        let summary = summarize(&spawns);
+        let hit_rate = summary.hit_rate();
+        println!("{}", path.display());
+        println!("  Total Actions: {}", summary.actions);
+        println!(
+            "  Cache Hits: {} ({})",
+            summary.cache_hits,
+            paint(
+                (hit_rate < low_hit_rate(args)).then_some(Style::Bad),
+                format!("{:.2}%", hit_rate)
+            )
+        );
+        println!(
+            "  Total Spawn Time: {}",
+            format_duration(summary.total_time, 2)
+        );
+        all.extend(spawns);
     }
 
-    // 2. Fallback to parsing as an uncompressed verbose log.
-    println!("Could not parse as compact log. Falling back to verbose log format.");
-    parse_verbose_log(&raw_bytes)
+    if all.is_empty() && args.strict {
+        return Err(AppError::EmptyLog(
+            "the execution log contains no spawn actions (--strict)".to_string(),
+        ));
+    }
+    finish_without_report(&all, args, None)
 }
 
-/// Parses the verbose execution log format (length-delimited SpawnExec protos).
-fn parse_verbose_log(content: &[u8]) -> AppResult<Vec<SpawnExec>> {
-    let mut decoded_spawns = Vec::new();
-    let mut cursor = content;
+/// Renders the main report in --output-format to stdout.
+fn render_report(
+    spawns: &[SpawnExec],
+    args: &Cli,
+    filter_summary: Option<&FilterSummary>,
+) -> AppResult<()> {
+    let writer =
+        output_writer(&args.output_format).expect("--output-format is checked when parsed");
+    let report = timing::timed(Stage::Aggregate, || Report::new(spawns, args, filter_summary));
+    // Buffered, since --top-n all can list every action in the log.
+    let mut out = io::BufWriter::new(io::stdout().lock());
+    timing::timed(Stage::Render, || {
+        (writer.renderer)(args).render(&report, &mut out)?;
+        out.flush()
+    })?;
+    Ok(())
+}
 
-    while !cursor.is_empty() {
-        match SpawnExec::decode_length_delimited(&mut cursor) {
-            Ok(spawn) => decoded_spawns.push(spawn),
-            Err(e) => {
-                return Err(AppError::LogParsing(format!("Failed to parse verbose protobuf message: {}. The log file might be corrupt or in the wrong format.", e)));
-            }
-        }
+/// The gates, and the --output-format document or --ci-summary line, for the modes that print
+/// no report sections.
+fn finish_without_report(
+    spawns: &[SpawnExec],
+    args: &Cli,
+    filter_summary: Option<&FilterSummary>,
+) -> AppResult<()> {
+    let conflicts = reports::outputs::output_conflicts(spawns).len();
+    let mismatches =
+        reports::outputs::output_mismatches(spawns, &args.output_mismatch_exempt).len();
+    let gates = check_gates(spawns, args, conflicts, mismatches, 0);
+    if args.output_format != "text" {
+        // The JSON document holds the --ci-summary fields, so the line is left out.
+        render_report(spawns, args, filter_summary)?;
+    } else if args.ci_summary {
+        reports::ci_summary::print_ci_summary(spawns)?;
     }
-    Ok(decoded_spawns)
+    if let Some(path) = &args.append_summary {
+        reports::ci_summary::append_summary(path, &args.summary_label, spawns)?;
+    }
+    gates
 }
 
-/// Parses the compact execution log format and reconstructs SpawnExec messages.
-fn parse_compact_log(content: &[u8]) -> AppResult<Vec<SpawnExec>> {
-    let mut cursor = content;
-    let mut stored_entries: HashMap<u32, StoredEntry> = HashMap::new();
-    let mut reconstructed_spawns = Vec::new();
-
-    while !cursor.is_empty() {
-        let entry = ExecLogEntry::decode_length_delimited(&mut cursor)?;
-        let id = entry.id;
-
-        match entry.r#type {
-            Some(CompactEntryType::Spawn(s)) => {
-                let spawn_exec = reconstruct_spawn_exec(s, &stored_entries);
-                reconstructed_spawns.push(spawn_exec);
-            }
-            Some(CompactEntryType::File(f)) if id != 0 => {
-                stored_entries.insert(id, StoredEntry::File(f));
-            }
-            Some(CompactEntryType::Directory(d)) if id != 0 => {
-                stored_entries.insert(id, StoredEntry::Directory(d));
-            }
-            // Ignore other entry types for now as they are not needed for the analysis.
-            _ => {}
+/// Writes the --all listing to stdout or the --output file.
+fn write_listing(spawns: &[SpawnExec], args: &Cli) -> AppResult<()> {
+    let sorted = sorted_actions(spawns, args.sort_by);
+    match &args.output {
+        Some(path) => {
+            let mut out = io::BufWriter::new(fs::File::create(path)?);
+            write_spawn_listing(&mut out, &sorted, &args.spawn_columns, args.listing_format)?;
+            println!("Wrote {} spawns to {}", sorted.len(), path.display());
+        }
+        None => {
+            let mut out = io::BufWriter::new(io::stdout().lock());
+            write_spawn_listing(&mut out, &sorted, &args.spawn_columns, args.listing_format)?;
         }
     }
-    Ok(reconstructed_spawns)
+    Ok(())
 }
 
-/// Converts a compact `Spawn` entry into a verbose `SpawnExec` using stored file/dir info.
-fn reconstruct_spawn_exec(
-    spawn: compact::Spawn,
-    stored_entries: &HashMap<u32, StoredEntry>,
-) -> SpawnExec {
-    let mut actual_outputs = Vec::new();
-    for output in spawn.outputs {
-        if let Some(compact::output::Type::OutputId(id)) = output.r#type {
-            if let Some(entry) = stored_entries.get(&id) {
-                match entry {
-                    StoredEntry::File(f) => {
-                        actual_outputs.push(crate::proto::File {
-                            path: f.path.clone(),
-                            digest: f.digest.clone(),
-                            symlink_target_path: String::new(),
-                            is_tool: false,
-                        });
-                    }
-                    StoredEntry::Directory(d) => {
-                        // The verbose format represents directories as a single File entry with a path.
-                        // We will omit the digest as it's not directly available/needed for metrics.
-                        actual_outputs.push(crate::proto::File {
-                            path: d.path.clone(),
-                            digest: None,
-                            symlink_target_path: String::new(),
-                            is_tool: false,
-                        });
-                    }
-                }
-            }
+/// Fails the run when a --min-*, --max-*, --fail-* or --strict condition holds, after the
+/// report was printed in full.
+fn check_gates(
+    spawns: &[SpawnExec],
+    args: &Cli,
+    conflicts: usize,
+    mismatches: usize,
+    non_hermetic: usize,
+) -> AppResult<()> {
+    if let Some(limit) = args.fail_if_uncacheable_time {
+        let time = reports::restrictions::uncacheable_time(spawns);
+        if time > limit {
+            return Err(AppError::Gate(
+                Gate::Threshold,
+                format!(
+                    "non-cacheable spawns take {}, more than the allowed {}",
+                    format_duration(time, 2),
+                    format_duration(limit, 2)),
+            ));
         }
     }
-
-    SpawnExec {
-        command_args: spawn.args,
-        environment_variables: spawn.env_vars,
-        platform: spawn.platform,
-        inputs: vec![],         // Not reconstructed as it's not used in analysis
-        listed_outputs: vec![], // Not reconstructed as it's not used in analysis
-        remotable: spawn.remotable,
-        cacheable: spawn.cacheable,
-        timeout_millis: spawn.timeout_millis,
-        mnemonic: spawn.mnemonic,
-        actual_outputs,
-        runner: spawn.runner,
-        cache_hit: spawn.cache_hit,
-        status: spawn.status,
-        exit_code: spawn.exit_code,
-        remote_cacheable: spawn.remote_cacheable,
-        target_label: spawn.target_label,
-        digest: spawn.digest,
-        metrics: spawn.metrics,
+    if let Some(budget) = args.max_action_duration {
+        let over = reports::slowest::print_duration_budget_report(
+            spawns,
+            budget,
+            &args.budget_exclude,
+        );
+        if over > 0 {
+            return Err(AppError::Gate(
+                Gate::ActionDuration,
+                format!(
+                    "{} actions took longer than {} (--max-action-duration)",
+                    over,
+                    format_duration_short(budget)
+                ),
+            ));
+        }
     }
-}
-
-// --- ANALYSIS AND REPORTING FUNCTIONS ---
-
-fn print_main_report(spawns: &[SpawnExec], args: &Cli) {
-    let total_actions = spawns.len();
-    let cache_hits = spawns.iter().filter(|s| s.cache_hit).count();
-
-    let mut slowest_actions: Vec<&SpawnExec> = spawns.iter().collect();
-    slowest_actions.sort_by_key(|s| {
-        s.metrics
-            .as_ref()
-            .and_then(|m| m.total_time.as_ref())
-            .map(to_std_duration)
-            .unwrap_or_default()
-    });
-    slowest_actions.reverse();
-
-    let mut mnemonic_metrics: HashMap<String, MnemonicMetrics> = HashMap::new();
-    for spawn in spawns {
-        let metrics = mnemonic_metrics.entry(spawn.mnemonic.clone()).or_default();
-        metrics.count += 1;
-        if spawn.cache_hit {
-            metrics.cache_hits += 1;
+    if args.fail_on_action_failure {
+        let failed = reports::failures::unexpected_failures(spawns, &args.allow_failures);
+        if failed > 0 {
+            return Err(AppError::Gate(
+                Gate::ActionFailure,
+                format!(
+                    "{} spawns failed (--fail-on-action-failure); see Failed Actions above",
+                    failed
+                ),
+            ));
         }
-        if let Some(m) = spawn.metrics.as_ref().and_then(|m| m.total_time.as_ref()) {
-            metrics.total_duration += to_std_duration(m);
+    }
+    if let Some(minimum) = args.min_hit_rate {
+        let rate = reports::cache::hit_rate(spawns);
+        if rate < minimum {
+            return Err(AppError::Gate(
+                Gate::HitRate,
+                format!(
+                    "cache hit rate {:.2}% is below the required {:.2}% (--min-hit-rate)",
+                    rate, minimum
+                ),
+            ));
         }
     }
-
-    println!("========================================");
-    println!(" Bazel Execution Log Analysis Report");
-    println!("========================================");
-    println!("Log file: {}\n", args.file.display());
-    println!("--- Overall Summary ---");
-    println!("Total Actions: {}", total_actions);
-    println!(
-        "Cache Hits: {} ({:.2}%)",
-        cache_hits,
-        (cache_hits as f64 / total_actions as f64) * 100.0
-    );
-    println!();
-    println!("--- Top {} Slowest Actions ---", args.top_n);
-    println!("{:<10} | {:<25} | {}", "Time", "Mnemonic", "Target");
-    println!("---------------------------------------------------------------------------------");
-    for spawn in slowest_actions.iter().take(args.top_n) {
-        let duration = spawn
-            .metrics
-            .as_ref()
-            .and_then(|m| m.total_time.as_ref())
-            .map(to_std_duration)
-            .unwrap_or_default();
-
-        println!(
-            "{:<10.3}s | {:<25} | {}",
-            duration.as_secs_f64(),
-            spawn.mnemonic,
-            spawn.target_label
-        );
+    if let Some(minimum) = args.min_hit_rate_weighted {
+        let rate = reports::cache::time_weighted_hit_rate(spawns).unwrap_or(0.0);
+        if rate < minimum {
+            return Err(AppError::Gate(
+                Gate::HitRate,
+                format!(
+                    "time-weighted cache hit rate {:.2}% is below the required {:.2}% (--min-hit-rate-weighted)",
+                    rate, minimum
+                ),
+            ));
+        }
     }
-    println!();
-    println!("--- Analysis by Mnemonic ---");
-
-    // Calculate column widths based on actual data
-    let mut sorted_mnemonics: Vec<_> = mnemonic_metrics.iter().collect();
-    sorted_mnemonics.sort_by_key(|(_, metrics)| metrics.total_duration);
-    sorted_mnemonics.reverse();
-
-    let mnemonic_width = sorted_mnemonics
-        .iter()
-        .map(|(name, _)| name.len())
-        .max()
-        .unwrap_or(8)
-        .max(8); // "Mnemonic" header
-
-    let count_width = sorted_mnemonics
-        .iter()
-        .map(|(_, metrics)| metrics.count.to_string().len())
-        .max()
-        .unwrap_or(5)
-        .max(5); // "Count" header
-
-    let cache_hits_width = sorted_mnemonics
-        .iter()
-        .map(|(_, metrics)| {
+    if args.strict && conflicts > 0 {
+        return Err(AppError::Gate(
+            Gate::Threshold,
             format!(
-                "{:.1}%",
-                (metrics.cache_hits as f64 / metrics.count as f64) * 100.0
-            )
-            .len()
-        })
-        .max()
-        .unwrap_or(10)
-        .max(10); // "Cache Hits" header
-
-    let total_time_width = sorted_mnemonics
-        .iter()
-        .map(|(_, metrics)| format!("{:.2}s", metrics.total_duration.as_secs_f64()).len())
-        .max()
-        .unwrap_or(10)
-        .max(10); // "Total Time" header
-
-    let avg_time_width = sorted_mnemonics
-        .iter()
-        .map(|(_, metrics)| {
-            let avg_time = if metrics.count > 0 {
-                metrics.total_duration.as_secs_f64() / metrics.count as f64
-            } else {
-                0.0
-            };
-            format!("{:.3}s", avg_time).len()
-        })
-        .max()
-        .unwrap_or(8)
-        .max(8); // "Avg Time" header
-
-    // Print header
-    println!(
-        "{:<width1$} | {:>width2$} | {:>width3$} | {:>width4$} | {:>width5$}",
-        "Mnemonic",
-        "Count",
-        "Cache Hits",
-        "Total Time",
-        "Avg Time",
-        width1 = mnemonic_width,
-        width2 = count_width,
-        width3 = cache_hits_width,
-        width4 = total_time_width,
-        width5 = avg_time_width
-    );
-
-    // Print separator line
-    let separator_width =
-        mnemonic_width + count_width + cache_hits_width + total_time_width + avg_time_width + 12; // 12 for " | " separators
-    println!("{}", "-".repeat(separator_width));
-
-    // Print data rows
-    for (mnemonic, metrics) in sorted_mnemonics {
-        let avg_time = if metrics.count > 0 {
-            metrics.total_duration.as_secs_f64() / metrics.count as f64
-        } else {
-            0.0
-        };
-        println!(
-            "{:<width1$} | {:>width2$} | {:>width3$.1}% | {:>width4$.2}s | {:>width5$.3}s",
-            mnemonic,
-            metrics.count,
-            (metrics.cache_hits as f64 / metrics.count as f64) * 100.0,
-            metrics.total_duration.as_secs_f64(),
-            avg_time,
-            width1 = mnemonic_width,
-            width2 = count_width,
-            width3 = cache_hits_width - 1, // -1 for the % symbol
-            width4 = total_time_width - 1, // -1 for the s suffix
-            width5 = avg_time_width - 1    // -1 for the s suffix
-        );
-    }
-    println!();
-}
-
-fn print_cache_performance_report(spawns: &[SpawnExec]) {
-    let mut total_bytes_downloaded: i64 = 0;
-    let mut total_fetch_time = Duration::ZERO;
-    let mut remote_cache_hit_count = 0;
-
-    for spawn in spawns {
-        if spawn.runner == "remote cache hit" {
-            remote_cache_hit_count += 1;
-            let bytes_for_spawn: i64 = spawn
-                .actual_outputs
-                .iter()
-                .filter_map(|file| file.digest.as_ref())
-                .map(|digest| digest.size_bytes)
-                .sum();
-            total_bytes_downloaded += bytes_for_spawn;
-            if let Some(fetch_duration) = spawn.metrics.as_ref().and_then(|m| m.fetch_time.as_ref())
-            {
-                total_fetch_time += to_std_duration(fetch_duration);
-            }
-        }
+                "{} output paths are written by more than one spawn",
+                conflicts
+            ),
+        ));
     }
-
-    println!("--- Remote Cache Performance ---");
-    if remote_cache_hit_count == 0 {
-        println!("No remote cache hits found in the log.");
-        println!();
-        return;
+    if args.strict && mismatches > 0 {
+        return Err(AppError::Gate(
+            Gate::Threshold,
+            format!(
+                "{} spawns did not produce exactly the outputs they declared",
+                mismatches
+            ),
+        ));
     }
-    let total_mb_downloaded = total_bytes_downloaded as f64 / 1_000_000.0;
-    let total_fetch_seconds = total_fetch_time.as_secs_f64();
-    println!("Remote Cache Hits Count: {}", remote_cache_hit_count);
-    println!("Total Data Downloaded: {:.2} MB", total_mb_downloaded);
-    println!(
-        "Total Time Fetching from Cache: {:.2}s",
-        total_fetch_seconds
-    );
-    if total_fetch_seconds > 0.001 {
-        let download_rate_mbps = total_mb_downloaded / total_fetch_seconds;
-        println!("Average Download Rate: {:.2} MB/s", download_rate_mbps);
-    } else {
-        println!("Average Download Rate: N/A (total fetch time is negligible)");
+    if args.strict && non_hermetic > 0 {
+        return Err(AppError::Gate(
+            Gate::Threshold,
+            format!("{} spawns failed the hermeticity checks", non_hermetic),
+        ));
     }
-    println!();
+    Ok(())
 }
 
+// --- ANALYSIS AND REPORTING FUNCTIONS ---
+
 fn print_phase_timings_report(spawns: &[SpawnExec], top_n: usize) {
-    println!("--- Top {} Slowest Actions (Phase Timings) ---", top_n);
+    println!("{}", section(format_args!("{} Slowest Actions (Phase Timings)", top_label(top_n))));
     println!("Note: This report excludes cache hits as phase timings are most relevant for executed actions.");
 
     let mut non_cache_hits: Vec<&SpawnExec> = spawns.iter().filter(|s| !s.cache_hit).collect();
-    non_cache_hits.sort_by_key(|s| {
-        s.metrics
-            .as_ref()
-            .and_then(|m| m.total_time.as_ref())
-            .map(to_std_duration)
-            .unwrap_or_default()
+    non_cache_hits.sort_by(|a, b| {
+        highest_first(a, b, |s| recorded_total_time(s).unwrap_or_default())
     });
-    non_cache_hits.reverse();
 
     if non_cache_hits.is_empty() {
         println!("No executed actions found (all were cache hits).");
@@ -439,7 +799,7 @@ fn print_phase_timings_report(spawns: &[SpawnExec], top_n: usize) {
                 .and_then(|m| m.total_time.as_ref())
                 .map(to_std_duration)
                 .unwrap_or_default();
-            format!("{:.2}s", total.as_secs_f64()).len()
+            format_duration(total, 2).len()
         })
         .max()
         .unwrap_or(5)
@@ -451,7 +811,7 @@ fn print_phase_timings_report(spawns: &[SpawnExec], top_n: usize) {
                 .and_then(|m| m.queue_time.as_ref())
                 .map(to_std_duration)
                 .unwrap_or_default();
-            format!("{:.2}s", queue.as_secs_f64()).len()
+            format_duration(queue, 2).len()
         })
         .max()
         .unwrap_or(5)
@@ -463,7 +823,7 @@ fn print_phase_timings_report(spawns: &[SpawnExec], top_n: usize) {
                 .and_then(|m| m.setup_time.as_ref())
                 .map(to_std_duration)
                 .unwrap_or_default();
-            format!("{:.2}s", setup.as_secs_f64()).len()
+            format_duration(setup, 2).len()
         })
         .max()
         .unwrap_or(5)
@@ -475,7 +835,7 @@ fn print_phase_timings_report(spawns: &[SpawnExec], top_n: usize) {
                 .and_then(|m| m.upload_time.as_ref())
                 .map(to_std_duration)
                 .unwrap_or_default();
-            format!("{:.2}s", upload.as_secs_f64()).len()
+            format_duration(upload, 2).len()
         })
         .max()
         .unwrap_or(6)
@@ -487,7 +847,7 @@ fn print_phase_timings_report(spawns: &[SpawnExec], top_n: usize) {
                 .and_then(|m| m.execution_wall_time.as_ref())
                 .map(to_std_duration)
                 .unwrap_or_default();
-            format!("{:.2}s", execution.as_secs_f64()).len()
+            format_duration(execution, 2).len()
         })
         .max()
         .unwrap_or(7)
@@ -499,7 +859,7 @@ fn print_phase_timings_report(spawns: &[SpawnExec], top_n: usize) {
                 .and_then(|m| m.fetch_time.as_ref())
                 .map(to_std_duration)
                 .unwrap_or_default();
-            format!("{:.2}s", fetch.as_secs_f64()).len()
+            format_duration(fetch, 2).len()
         })
         .max()
         .unwrap_or(5)
@@ -538,20 +898,20 @@ fn print_phase_timings_report(spawns: &[SpawnExec], top_n: usize) {
             };
 
             println!(
-                "{:>width1$.2}s | {:>width2$.2}s | {:>width3$.2}s | {:>width4$.2}s | {:>width5$.2}s | {:>width6$.2}s | {}",
-                total.as_secs_f64(),
-                queue.as_secs_f64(),
-                setup.as_secs_f64(),
-                upload.as_secs_f64(),
-                execution.as_secs_f64(),
-                fetch.as_secs_f64(),
+                "{:>width1$} | {:>width2$} | {:>width3$} | {:>width4$} | {:>width5$} | {:>width6$} | {}",
+                format_duration(total, 2),
+                format_duration(queue, 2),
+                format_duration(setup, 2),
+                format_duration(upload, 2),
+                format_duration(execution, 2),
+                format_duration(fetch, 2),
                 spawn.target_label,
-                width1 = total_width - 1, // -1 for 's' suffix
-                width2 = queue_width - 1,
-                width3 = setup_width - 1,
-                width4 = upload_width - 1,
-                width5 = execute_width - 1,
-                width6 = fetch_width - 1
+                width1 = total_width,
+                width2 = queue_width,
+                width3 = setup_width,
+                width4 = upload_width,
+                width5 = execute_width,
+                width6 = fetch_width
             );
             println!("  └ Overhead: {:.1}%", overhead_pct);
         }
@@ -560,11 +920,12 @@ fn print_phase_timings_report(spawns: &[SpawnExec], top_n: usize) {
 }
 
 fn print_input_analysis_report(spawns: &[SpawnExec], top_n: usize) {
-    println!("--- Top {} Actions by Input Size ---", top_n);
+    println!("{}", section(format_args!("{} Actions by Input Size", top_label(top_n))));
 
-    let mut sorted_by_size = spawns.to_vec();
-    sorted_by_size.sort_by_key(|s| s.metrics.as_ref().map_or(0, |m| m.input_bytes));
-    sorted_by_size.reverse();
+    let mut sorted_by_size: Vec<&SpawnExec> = spawns.iter().collect();
+    sorted_by_size.sort_by(|a, b| {
+        highest_first(a, b, |s| s.metrics.as_ref().map_or(0, |m| m.input_bytes))
+    });
 
     // Filter out actions with no input data
     let actions_with_inputs: Vec<_> = sorted_by_size
@@ -583,8 +944,7 @@ fn print_input_analysis_report(spawns: &[SpawnExec], top_n: usize) {
     
     let size_width = actions_to_display.clone()
         .map(|s| {
-            let size_mb = s.metrics.as_ref().unwrap().input_bytes as f64 / 1_048_576.0;
-            format!("{:.2}MB", size_mb).len()
+            format_bytes(s.metrics.as_ref().unwrap().input_bytes).len()
         })
         .max()
         .unwrap_or(10)
@@ -611,11 +971,11 @@ fn print_input_analysis_report(spawns: &[SpawnExec], top_n: usize) {
     for spawn in actions_with_inputs.iter().take(top_n) {
         if let Some(metrics) = spawn.metrics.as_ref() {
             println!(
-                "{:>width1$.2}MB | {:>width2$} | {}",
-                metrics.input_bytes as f64 / 1_048_576.0,
+                "{:>width1$} | {:>width2$} | {}",
+                format_bytes(metrics.input_bytes),
                 metrics.input_files,
                 spawn.target_label,
-                width1 = size_width - 2, // -2 for "MB" suffix
+                width1 = size_width,
                 width2 = files_width
             );
         }
@@ -624,7 +984,7 @@ fn print_input_analysis_report(spawns: &[SpawnExec], top_n: usize) {
 }
 
 fn print_retries_and_failures_report(spawns: &[SpawnExec]) {
-    println!("--- Actions with Failures or Retries ---");
+    println!("{}", section("Actions with Failures or Retries"));
 
     let problematic_spawns: Vec<_> = spawns
         .iter()
@@ -651,7 +1011,7 @@ fn print_retries_and_failures_report(spawns: &[SpawnExec]) {
                 println!("  └ Status: {} (Exit Code: {})", spawn.status, spawn.exit_code);
             }
             if !retry_duration.is_zero() {
-                println!("  └ Time in Retries: {:.3}s", retry_duration.as_secs_f64());
+                println!("  └ Time in Retries: {}", format_duration(retry_duration, 3));
             }
         }
     }
@@ -659,7 +1019,7 @@ fn print_retries_and_failures_report(spawns: &[SpawnExec]) {
 }
 
 fn print_aggregate_phases_report(spawns: &[SpawnExec]) {
-    println!("--- Aggregate Phase Timings (Executed Actions) ---");
+    println!("{}", section("Aggregate Phase Timings (Executed Actions)"));
     
     let mut total_time = Duration::ZERO;
     let mut total_queue = Duration::ZERO;
@@ -709,7 +1069,7 @@ fn print_aggregate_phases_report(spawns: &[SpawnExec]) {
     let total_seconds = total_time.as_secs_f64();
     
     println!("Executed Actions: {}", executed_count);
-    println!("Total Execution Time: {:.2}s", total_seconds);
+    println!("Total Execution Time: {}", format_seconds(total_seconds, 2));
     println!();
     
     println!("{:<15} | {:>10} | {:>8}", "Phase", "Time", "% of Total");
@@ -731,13 +1091,13 @@ fn print_aggregate_phases_report(spawns: &[SpawnExec]) {
         } else {
             0.0
         };
-        println!("{:<15} | {:>10.2}s | {:>7.1}%", name, seconds, percentage);
+        println!("{:<15} | {:>11} | {:>7.1}%", name, format_seconds(seconds, 2), percentage);
     }
     println!();
 }
 
 fn print_output_analysis_report(spawns: &[SpawnExec], top_n: usize) {
-    println!("--- Top {} Actions by Output Size ---", top_n);
+    println!("{}", section(format_args!("{} Actions by Output Size", top_label(top_n))));
     
     let mut size_data: Vec<(i64, &SpawnExec)> = Vec::new();
     
@@ -760,16 +1120,14 @@ fn print_output_analysis_report(spawns: &[SpawnExec], top_n: usize) {
         return;
     }
     
-    size_data.sort_by_key(|(size, _)| *size);
-    size_data.reverse();
+    size_data.sort_by(|a, b| b.0.cmp(&a.0).then_with(|| action_key(a.1).cmp(&action_key(b.1))));
     
     // Calculate column widths based on actual data
     let actions_to_display = size_data.iter().take(top_n);
     
     let size_width = actions_to_display.clone()
         .map(|(size, _)| {
-            let size_mb = *size as f64 / 1_048_576.0;
-            format!("{:.2}MB", size_mb).len()
+            format_bytes(*size).len()
         })
         .max()
         .unwrap_or(11)
@@ -795,11 +1153,11 @@ fn print_output_analysis_report(spawns: &[SpawnExec], top_n: usize) {
     
     for (size, spawn) in size_data.iter().take(top_n) {
         println!(
-            "{:>width1$.2}MB | {:>width2$} | {}",
-            *size as f64 / 1_048_576.0,
+            "{:>width1$} | {:>width2$} | {}",
+            format_bytes(*size),
             spawn.actual_outputs.len(),
             spawn.target_label,
-            width1 = size_width - 2, // -2 for "MB" suffix
+            width1 = size_width,
             width2 = files_width
         );
     }
@@ -807,7 +1165,7 @@ fn print_output_analysis_report(spawns: &[SpawnExec], top_n: usize) {
 }
 
 fn print_memory_analysis_report(spawns: &[SpawnExec], top_n: usize) {
-    println!("--- Top {} Actions by Memory Usage vs. Limit ---", top_n);
+    println!("{}", section(format_args!("{} Actions by Memory Usage vs. Limit", top_label(top_n))));
     
     let mut memory_data: Vec<(f64, &SpawnExec)> = Vec::new();
     
@@ -826,15 +1184,17 @@ fn print_memory_analysis_report(spawns: &[SpawnExec], top_n: usize) {
         return;
     }
     
-    memory_data.sort_by(|(a, _), (b, _)| b.partial_cmp(a).unwrap_or(std::cmp::Ordering::Equal));
+    memory_data.sort_by(|a, b| {
+        b.0.total_cmp(&a.0)
+            .then_with(|| action_key(a.1).cmp(&action_key(b.1)))
+    });
     
     // Calculate column widths based on actual data
     let actions_to_display = memory_data.iter().take(top_n);
     
     let estimate_width = actions_to_display.clone()
         .map(|(_, spawn)| {
-            let estimate_mb = spawn.metrics.as_ref().unwrap().memory_estimate_bytes as f64 / 1_048_576.0;
-            format!("{:.1}MB", estimate_mb).len()
+            format_bytes(spawn.metrics.as_ref().unwrap().memory_estimate_bytes).len()
         })
         .max()
         .unwrap_or(12)
@@ -842,8 +1202,7 @@ fn print_memory_analysis_report(spawns: &[SpawnExec], top_n: usize) {
     
     let limit_width = actions_to_display.clone()
         .map(|(_, spawn)| {
-            let limit_mb = spawn.metrics.as_ref().unwrap().memory_bytes_limit as f64 / 1_048_576.0;
-            format!("{:.1}MB", limit_mb).len()
+            format_bytes(spawn.metrics.as_ref().unwrap().memory_bytes_limit).len()
         })
         .max()
         .unwrap_or(13)
@@ -866,18 +1225,16 @@ fn print_memory_analysis_report(spawns: &[SpawnExec], top_n: usize) {
     
     for (ratio, spawn) in memory_data.iter().take(top_n) {
         let metrics = spawn.metrics.as_ref().unwrap();
-        let estimate_mb = metrics.memory_estimate_bytes as f64 / 1_048_576.0;
-        let limit_mb = metrics.memory_bytes_limit as f64 / 1_048_576.0;
         let usage_pct = ratio * 100.0;
         
         println!(
-            "{:>width1$.1}MB | {:>width2$.1}MB | {:>width3$.1}% | {}",
-            estimate_mb,
-            limit_mb,
+            "{:>width1$} | {:>width2$} | {:>width3$.1}% | {}",
+            format_bytes(metrics.memory_estimate_bytes),
+            format_bytes(metrics.memory_bytes_limit),
             usage_pct,
             spawn.target_label,
-            width1 = estimate_width - 2, // -2 for "MB" suffix
-            width2 = limit_width - 2,    // -2 for "MB" suffix
+            width1 = estimate_width,
+            width2 = limit_width,
             width3 = usage_width - 1     // -1 for "%" suffix
         );
     }
@@ -885,7 +1242,7 @@ fn print_memory_analysis_report(spawns: &[SpawnExec], top_n: usize) {
 }
 
 fn print_execution_comparison_report(spawns: &[SpawnExec]) {
-    println!("--- Remote vs. Local Execution Time Comparison ---");
+    println!("{}", section("Remote vs. Local Execution Time Comparison"));
     
     let mut mnemonic_stats: HashMap<String, MnemonicExecutionStats> = HashMap::new();
     
@@ -975,23 +1332,22 @@ fn print_execution_comparison_report(spawns: &[SpawnExec]) {
         };
         
         println!(
-            "{:<width1$} | {:>width2$} | {:>width3$.3}s | {:>width2$} | {:>width3$.3}s | {:>12}",
+            "{:<width1$} | {:>width2$} | {:>width3$} | {:>width2$} | {:>width3$} | {:>12}",
             mnemonic,
             stats.remote.count,
-            remote_avg,
+            format_seconds(remote_avg, 3),
             stats.local.count,
-            local_avg,
+            format_seconds(local_avg, 3),
             difference_text,
             width1 = mnemonic_width,
             width2 = count_width,
-            width3 = time_width - 1 // -1 for 's' suffix
+            width3 = time_width
         );
     }
-    println!();
 }
 
 fn print_queue_analysis_report(spawns: &[SpawnExec], top_n: usize) {
-    println!("--- Top {} Actions by Queue Time ---", top_n);
+    println!("{}", section(format_args!("{} Actions by Queue Time", top_label(top_n))));
     
     let mut non_cache_hits: Vec<&SpawnExec> = spawns.iter().filter(|s| !s.cache_hit).collect();
     
@@ -1001,14 +1357,15 @@ fn print_queue_analysis_report(spawns: &[SpawnExec], top_n: usize) {
         return;
     }
     
-    non_cache_hits.sort_by_key(|s| {
-        s.metrics
-            .as_ref()
-            .and_then(|m| m.queue_time.as_ref())
-            .map(to_std_duration)
-            .unwrap_or_default()
+    non_cache_hits.sort_by(|a, b| {
+        highest_first(a, b, |s| {
+            s.metrics
+                .as_ref()
+                .and_then(|m| m.queue_time.as_ref())
+                .map(to_std_duration)
+                .unwrap_or_default()
+        })
     });
-    non_cache_hits.reverse();
     
     // Calculate column widths based on actual data
     let actions_to_display = non_cache_hits.iter().take(top_n);
@@ -1019,7 +1376,7 @@ fn print_queue_analysis_report(spawns: &[SpawnExec], top_n: usize) {
                 .and_then(|m| m.queue_time.as_ref())
                 .map(to_std_duration)
                 .unwrap_or_default();
-            format!("{:.2}s", queue_time.as_secs_f64()).len()
+            format_duration(queue_time, 2).len()
         })
         .max()
         .unwrap_or(10)
@@ -1031,7 +1388,7 @@ fn print_queue_analysis_report(spawns: &[SpawnExec], top_n: usize) {
                 .and_then(|m| m.total_time.as_ref())
                 .map(to_std_duration)
                 .unwrap_or_default();
-            format!("{:.2}s", total_time.as_secs_f64()).len()
+            format_duration(total_time, 2).len()
         })
         .max()
         .unwrap_or(10)
@@ -1055,14 +1412,131 @@ fn print_queue_analysis_report(spawns: &[SpawnExec], top_n: usize) {
             let total_time = metrics.total_time.as_ref().map(to_std_duration).unwrap_or_default();
             
             println!(
-                "{:>width1$.2}s | {:>width2$.2}s | {}",
-                queue_time.as_secs_f64(),
-                total_time.as_secs_f64(),
+                "{:>width1$} | {:>width2$} | {}",
+                format_duration(queue_time, 2),
+                format_duration(total_time, 2),
                 spawn.target_label,
-                width1 = queue_width - 1, // -1 for 's' suffix
-                width2 = total_width - 1  // -1 for 's' suffix
+                width1 = queue_width,
+                width2 = total_width
             );
         }
     }
     println!();
-}
\ No newline at end of file
+}
+fn print_histogram_report(spawns: &[SpawnExec], bucket_bounds: &[Duration]) {
+    println!("{}", section("Action Duration Histogram"));
+
+    let durations: Vec<Duration> = spawns
+        .iter()
+        .filter_map(|s| s.metrics.as_ref().and_then(|m| m.total_time.as_ref()))
+        .map(to_std_duration)
+        .collect();
+
+    if durations.is_empty() {
+        println!("No actions with timing data found in the log.");
+        println!();
+        return;
+    }
+
+    let mut bounds = bucket_bounds.to_vec();
+    bounds.sort();
+    bounds.dedup();
+    print_histogram_table(&durations, &bounds, false);
+    println!();
+}
+
+/// Prints a duration histogram of one mnemonic's spawns, with log-scaled buckets chosen
+/// from the range of its durations.
+fn print_mnemonic_histogram_report(spawns: &[SpawnExec], mnemonic: &str) {
+    println!("{}", section(format_args!("Duration Histogram: {}", mnemonic)));
+
+    let durations: Vec<Duration> = spawns
+        .iter()
+        .filter(|s| s.mnemonic == mnemonic)
+        .filter_map(recorded_total_time)
+        .collect();
+
+    if durations.is_empty() {
+        println!("No {} actions with timing data found in the log.", mnemonic);
+        println!();
+        return;
+    }
+
+    print_histogram_table(&durations, &log_bucket_bounds(&durations), true);
+    println!();
+}
+
+/// Prints the bucket table shared by the histogram reports. With `trim_empty`, empty
+/// buckets before the first and after the last populated one are left out.
+fn print_histogram_table(durations: &[Duration], bounds: &[Duration], trim_empty: bool) {
+    let mut buckets = histogram(durations, bounds);
+    if trim_empty {
+        let last = buckets.iter().rposition(|b| b.count > 0).unwrap_or(0);
+        buckets.truncate(last + 1);
+        let first = buckets.iter().position(|b| b.count > 0).unwrap_or(0);
+        buckets.drain(..first);
+    }
+
+    let total_count = durations.len() as f64;
+    let total_seconds: f64 = durations.iter().map(|d| d.as_secs_f64()).sum();
+    let max_count = buckets.iter().map(|b| b.count).max().unwrap_or(0) as f64;
+
+    let labels: Vec<String> = buckets
+        .iter()
+        .map(|b| match b.upper_bound {
+            Some(upper) if b.lower_bound.is_zero() => format!("<= {}", format_duration_short(upper)),
+            Some(upper) => format!(
+                "{} - {}",
+                format_duration_short(b.lower_bound),
+                format_duration_short(upper)
+            ),
+            None => format!("> {}", format_duration_short(b.lower_bound)),
+        })
+        .collect();
+
+    let bucket_width = labels.iter().map(|l| l.len()).max().unwrap_or(6).max(6); // "Bucket" header
+    let count_width = buckets
+        .iter()
+        .map(|b| b.count.to_string().len())
+        .max()
+        .unwrap_or(5)
+        .max(5); // "Count" header
+    let time_width = buckets
+        .iter()
+        .map(|b| format_duration(b.total, 2).len())
+        .max()
+        .unwrap_or(4)
+        .max(4); // "Time" header
+
+    println!(
+        "{:<width1$} | {:>width2$} | {:>9} | {:>width3$} | {:>8} | {}",
+        "Bucket", "Count", "% Actions", "Time", "% Time", "Distribution",
+        width1 = bucket_width,
+        width2 = count_width,
+        width3 = time_width
+    );
+    let separator_width = bucket_width + count_width + 9 + time_width + 8 + 15 + 12; // separators + "Distribution"
+    println!("{}", "-".repeat(separator_width));
+
+    for (bucket, label) in buckets.iter().zip(labels) {
+        let seconds = bucket.total.as_secs_f64();
+        let time_pct = if total_seconds > 0.0 {
+            (seconds / total_seconds) * 100.0
+        } else {
+            0.0
+        };
+        println!(
+            "{:<width1$} | {:>width2$} | {:>8.1}% | {:>width3$} | {:>7.1}% | {}",
+            label,
+            bucket.count,
+            (bucket.count as f64 / total_count) * 100.0,
+            format_seconds(seconds, 2),
+            time_pct,
+            bar(bucket.count as f64, max_count, 40),
+            width1 = bucket_width,
+            width2 = count_width,
+            width3 = time_width
+        );
+    }
+    println!();
+}
@@ -0,0 +1,408 @@
+//! Evaluates a log against a baseline and fails when any threshold is exceeded.
+//!
+//! The baseline is either another log or a summary written earlier with --write-baseline,
+//! which keeps only what the checks need, so CI can store it instead of a multi-GB log.
+
+use crate::analysis::{action_key, LogTotals};
+use crate::cli::{CheckArgs, Cli};
+use crate::commands::diff::{load, relative_change};
+use crate::filter::SpawnFilter;
+use crate::format::{format_duration, Align, Table};
+use crate::proto::SpawnExec;
+use crate::reports::grouping::NO_LABEL;
+use crate::{AppError, AppResult, Gate};
+use serde::{Deserialize, Serialize};
+use std::collections::HashSet;
+use std::fs::File;
+use std::io::{BufReader, BufWriter, Write};
+use std::path::Path;
+use std::time::Duration;
+
+/// Version of the --write-baseline summary, raised whenever a field changes meaning or is
+/// removed.
+const SUMMARY_SCHEMA_VERSION: u32 = 1;
+
+/// New uncacheable actions listed below a failed check.
+const MAX_ACTIONS_SHOWN: usize = 10;
+
+/// An action as matched across logs, see [`action_key`].
+#[derive(Clone, PartialEq, Eq, Hash, PartialOrd, Ord, Serialize, Deserialize)]
+pub struct ActionKey {
+    pub label: String,
+    pub mnemonic: String,
+    pub output: String,
+}
+
+/// What the checks need to know about one build.
+#[derive(Serialize, Deserialize)]
+pub struct Summary {
+    pub schema_version: u32,
+    pub actions: u64,
+    pub cache_hits: u64,
+    pub total_time_nanos: u64,
+    pub downloaded_bytes: i64,
+    /// The actions marked not cacheable, sorted; empty if the log does not record
+    /// cacheability.
+    pub uncacheable: Vec<ActionKey>,
+}
+
+impl Summary {
+    pub fn from_spawns(spawns: &[SpawnExec]) -> Self {
+        let totals = LogTotals::from_spawns(spawns);
+        // Proto3 cannot tell an unset field from false, so a log where nothing is cacheable
+        // does not record cacheability.
+        let recorded = spawns.iter().any(|s| s.cacheable);
+        let mut uncacheable: Vec<ActionKey> = spawns
+            .iter()
+            .filter(|s| recorded && !s.cacheable)
+            .map(|s| {
+                let (label, mnemonic, output) = action_key(s);
+                ActionKey {
+                    label: label.to_string(),
+                    mnemonic: mnemonic.to_string(),
+                    output: output.to_string(),
+                }
+            })
+            .collect();
+        uncacheable.sort();
+        uncacheable.dedup();
+        Summary {
+            schema_version: SUMMARY_SCHEMA_VERSION,
+            actions: totals.actions,
+            cache_hits: totals.cache_hits,
+            total_time_nanos: totals.total_time.as_nanos() as u64,
+            downloaded_bytes: totals.downloaded_bytes,
+            uncacheable,
+        }
+    }
+
+    fn hit_rate(&self) -> f64 {
+        if self.actions == 0 {
+            0.0
+        } else {
+            self.cache_hits as f64 / self.actions as f64 * 100.0
+        }
+    }
+
+    fn total_time(&self) -> Duration {
+        Duration::from_nanos(self.total_time_nanos)
+    }
+}
+
+/// Outcome of one check, with the compared values formatted for the table.
+struct Evaluation {
+    baseline: String,
+    current: String,
+    change: String,
+    passed: bool,
+}
+
+/// One threshold the current log must stay within relative to the baseline.
+trait Check {
+    fn name(&self) -> &'static str;
+
+    fn limit(&self) -> String;
+
+    fn evaluate(&self, baseline: &Summary, current: &Summary) -> Evaluation;
+
+    /// Lines printed below the table when the check failed.
+    fn details(&self, _baseline: &Summary, _current: &Summary) -> Vec<String> {
+        Vec::new()
+    }
+}
+
+/// --max-hit-rate-drop, in percentage points.
+struct HitRateDrop(f64);
+
+impl Check for HitRateDrop {
+    fn name(&self) -> &'static str {
+        "Cache hit rate"
+    }
+
+    fn limit(&self) -> String {
+        format!("-{:.2} pp", self.0)
+    }
+
+    fn evaluate(&self, baseline: &Summary, current: &Summary) -> Evaluation {
+        let change = current.hit_rate() - baseline.hit_rate();
+        Evaluation {
+            baseline: format!("{:.2}%", baseline.hit_rate()),
+            current: format!("{:.2}%", current.hit_rate()),
+            change: format!("{:+.2} pp", change),
+            passed: -change <= self.0,
+        }
+    }
+}
+
+/// --max-time-increase, in percent of the baseline's total spawn time.
+struct TimeIncrease(f64);
+
+impl Check for TimeIncrease {
+    fn name(&self) -> &'static str {
+        "Total spawn time"
+    }
+
+    fn limit(&self) -> String {
+        format!("+{:.1}%", self.0)
+    }
+
+    fn evaluate(&self, baseline: &Summary, current: &Summary) -> Evaluation {
+        let (old, new) = (baseline.total_time(), current.total_time());
+        // Any time against a baseline without any is an unbounded increase.
+        let change = relative_change(old.as_secs_f64(), new.as_secs_f64());
+        Evaluation {
+            baseline: format_duration(old, 2),
+            current: format_duration(new, 2),
+            change: change.map_or_else(|| "n/a".to_string(), |c| format!("{:+.1}%", c)),
+            passed: change.is_some_and(|c| c <= self.0),
+        }
+    }
+}
+
+/// --max-new-uncacheable, in actions.
+struct NewUncacheable(usize);
+
+impl NewUncacheable {
+    fn new_actions<'a>(baseline: &Summary, current: &'a Summary) -> Vec<&'a ActionKey> {
+        let known: HashSet<&ActionKey> = baseline.uncacheable.iter().collect();
+        current
+            .uncacheable
+            .iter()
+            .filter(|key| !known.contains(key))
+            .collect()
+    }
+}
+
+impl Check for NewUncacheable {
+    fn name(&self) -> &'static str {
+        "Uncacheable actions"
+    }
+
+    fn limit(&self) -> String {
+        format!("{} new", self.0)
+    }
+
+    fn evaluate(&self, baseline: &Summary, current: &Summary) -> Evaluation {
+        let new = NewUncacheable::new_actions(baseline, current).len();
+        Evaluation {
+            baseline: baseline.uncacheable.len().to_string(),
+            current: current.uncacheable.len().to_string(),
+            change: format!("{} new", new),
+            passed: new <= self.0,
+        }
+    }
+
+    fn details(&self, baseline: &Summary, current: &Summary) -> Vec<String> {
+        let new = NewUncacheable::new_actions(baseline, current);
+        let mut lines = vec!["New uncacheable actions:".to_string()];
+        for key in new.iter().take(MAX_ACTIONS_SHOWN) {
+            let label = if key.label.is_empty() {
+                NO_LABEL
+            } else {
+                &key.label
+            };
+            lines.push(format!("  {} | {} | {}", key.mnemonic, label, key.output));
+        }
+        if new.len() > MAX_ACTIONS_SHOWN {
+            lines.push(format!(
+                "... (+{} more actions)",
+                new.len() - MAX_ACTIONS_SHOWN
+            ));
+        }
+        lines
+    }
+}
+
+/// The checks requested on the command line, in table order.
+fn requested_checks(args: &CheckArgs) -> Vec<Box<dyn Check>> {
+    let mut checks: Vec<Box<dyn Check>> = Vec::new();
+    if let Some(points) = args.max_hit_rate_drop {
+        checks.push(Box::new(HitRateDrop(points)));
+    }
+    if let Some(percent) = args.max_time_increase {
+        checks.push(Box::new(TimeIncrease(percent)));
+    }
+    if let Some(actions) = args.max_new_uncacheable {
+        checks.push(Box::new(NewUncacheable(actions)));
+    }
+    checks
+}
+
+/// Reads a baseline: a `.json` file is a summary, anything else is parsed as a log.
+fn load_baseline(path: &Path, filter: &SpawnFilter) -> AppResult<Summary> {
+    if path.extension().is_none_or(|extension| extension != "json") {
+        return Ok(Summary::from_spawns(&load(path, filter)?));
+    }
+    let summary: Summary = serde_json::from_reader(BufReader::new(File::open(path)?))?;
+    if summary.schema_version != SUMMARY_SCHEMA_VERSION {
+        return Err(AppError::LogParsing(format!(
+            "{} is a version {} summary, but this build reads version {}",
+            path.display(),
+            summary.schema_version,
+            SUMMARY_SCHEMA_VERSION
+        )));
+    }
+    if filter.is_active() {
+        eprintln!("Note: The filter flags do not apply to a baseline summary; it keeps the filters it was written with.");
+    }
+    Ok(summary)
+}
+
+fn write_summary(path: &Path, summary: &Summary) -> AppResult<()> {
+    let mut out = BufWriter::new(File::create(path)?);
+    serde_json::to_writer_pretty(&mut out, summary)?;
+    writeln!(out)?;
+    out.flush()?;
+    Ok(())
+}
+
+pub fn run_check(args: &Cli, check: &CheckArgs) -> AppResult<()> {
+    let filter = SpawnFilter::from_cli(args);
+    let baseline = load_baseline(&check.baseline, &filter)?;
+    let current = Summary::from_spawns(&load(&check.current, &filter)?);
+    if let Some(path) = &check.write_baseline {
+        write_summary(path, &current)?;
+        println!(
+            "Wrote a baseline summary of {} to {}",
+            check.current.display(),
+            path.display()
+        );
+    }
+    let checks = requested_checks(check);
+    if checks.is_empty() {
+        return Ok(());
+    }
+
+    println!("--- Regression Checks ---");
+    println!("Baseline: {}", check.baseline.display());
+    println!("Current: {}", check.current.display());
+    let mut table = Table::new(vec![
+        ("Check".to_string(), Align::Left),
+        ("Baseline".to_string(), Align::Right),
+        ("Current".to_string(), Align::Right),
+        ("Change".to_string(), Align::Right),
+        ("Limit".to_string(), Align::Right),
+        ("Result".to_string(), Align::Left),
+    ]);
+    let mut failed: Vec<&dyn Check> = Vec::new();
+    for check in &checks {
+        let evaluation = check.evaluate(&baseline, &current);
+        if !evaluation.passed {
+            failed.push(check.as_ref());
+        }
+        table.add_row(vec![
+            check.name().to_string(),
+            evaluation.baseline,
+            evaluation.current,
+            evaluation.change,
+            check.limit(),
+            if evaluation.passed { "PASS" } else { "FAIL" }.to_string(),
+        ]);
+    }
+    table.print();
+    for check in &failed {
+        for line in check.details(&baseline, &current) {
+            println!("{}", line);
+        }
+    }
+    println!("{} of {} checks failed.", failed.len(), checks.len());
+    println!();
+    if !failed.is_empty() {
+        return Err(AppError::Gate(
+            Gate::Threshold,
+            format!(
+                "{} of {} regression checks failed",
+                failed.len(),
+                checks.len()
+            ),
+        ));
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::testing::spawn;
+
+    fn summary(hits: u64, misses: u64, millis: u64, uncacheable: &[&str]) -> Summary {
+        let mut spawns: Vec<SpawnExec> = (0..hits)
+            .map(|_| spawn("Javac", "remote cache hit", millis))
+            .chain((0..misses).map(|_| spawn("Javac", "remote", millis)))
+            .map(|spawn| SpawnExec {
+                cacheable: true,
+                ..spawn
+            })
+            .collect();
+        spawns.extend(uncacheable.iter().map(|label| SpawnExec {
+            target_label: label.to_string(),
+            ..spawn("Genrule", "local", 0)
+        }));
+        Summary::from_spawns(&spawns)
+    }
+
+    #[test]
+    fn hit_rate_drop_is_measured_in_points() {
+        let (baseline, current) = (summary(90, 10, 0, &[]), summary(87, 13, 0, &[]));
+        let evaluation = HitRateDrop(3.0).evaluate(&baseline, &current);
+        assert_eq!(
+            (evaluation.baseline.as_str(), evaluation.current.as_str()),
+            ("90.00%", "87.00%")
+        );
+        assert_eq!(evaluation.change, "-3.00 pp");
+        assert!(evaluation.passed);
+        assert!(!HitRateDrop(2.9).evaluate(&baseline, &current).passed);
+        assert!(HitRateDrop(0.0).evaluate(&current, &baseline).passed);
+    }
+
+    #[test]
+    fn time_increase_is_relative_to_the_baseline() {
+        let (baseline, current) = (summary(0, 10, 1_000, &[]), summary(0, 10, 1_100, &[]));
+        let evaluation = TimeIncrease(10.0).evaluate(&baseline, &current);
+        assert_eq!(evaluation.change, "+10.0%");
+        assert!(evaluation.passed);
+        assert!(!TimeIncrease(9.9).evaluate(&baseline, &current).passed);
+    }
+
+    #[test]
+    fn time_against_an_empty_baseline_fails() {
+        let (baseline, current) = (summary(0, 0, 0, &[]), summary(0, 1, 1_000, &[]));
+        let evaluation = TimeIncrease(1_000.0).evaluate(&baseline, &current);
+        assert_eq!(evaluation.change, "n/a");
+        assert!(!evaluation.passed);
+    }
+
+    #[test]
+    fn only_new_uncacheable_actions_count() {
+        let baseline = summary(1, 0, 0, &["//a:gen", "//b:gen"]);
+        let current = summary(1, 0, 0, &["//b:gen", "//c:gen", "//d:gen"]);
+        let evaluation = NewUncacheable(1).evaluate(&baseline, &current);
+        assert_eq!(evaluation.change, "2 new");
+        assert!(!evaluation.passed);
+        assert!(NewUncacheable(2).evaluate(&baseline, &current).passed);
+        assert_eq!(
+            NewUncacheable(1).details(&baseline, &current),
+            [
+                "New uncacheable actions:",
+                "  Genrule | //c:gen | ",
+                "  Genrule | //d:gen | "
+            ]
+        );
+    }
+
+    #[test]
+    fn a_log_without_cacheability_records_no_uncacheable_actions() {
+        let spawns = [spawn("Genrule", "local", 0), spawn("Genrule", "local", 0)];
+        assert!(Summary::from_spawns(&spawns).uncacheable.is_empty());
+    }
+
+    #[test]
+    fn a_summary_survives_the_baseline_file() {
+        let summary = summary(3, 1, 500, &["//a:gen"]);
+        let read: Summary =
+            serde_json::from_str(&serde_json::to_string(&summary).unwrap()).unwrap();
+        assert_eq!(read.actions, 5);
+        assert_eq!(read.total_time(), Duration::from_secs(2));
+        assert!(read.uncacheable == summary.uncacheable);
+    }
+}
@@ -0,0 +1,267 @@
+//! Cache hit rates of the top mnemonics side by side across logs, e.g. from several developer
+//! machines, to find the one whose cache configuration is broken.
+
+use crate::analysis::LogTotals;
+use crate::cli::{Cli, CompareArgs, OutputFormat};
+use crate::commands::diff::load;
+use crate::commands::trend::{labeled_logs, summarize_logs};
+use crate::filter::SpawnFilter;
+use crate::format::{format_duration, Align, Table};
+use crate::reports::json::TotalsJson;
+use crate::schema::SCHEMA_VERSION;
+use crate::style::{paint, Style};
+use crate::AppResult;
+use serde::Serialize;
+use std::collections::HashMap;
+use std::path::{Path, PathBuf};
+use std::time::Duration;
+
+/// The totals of one log, overall and per mnemonic.
+struct LogMnemonics {
+    label: String,
+    path: PathBuf,
+    overall: LogTotals,
+    by_mnemonic: HashMap<String, LogTotals>,
+}
+
+fn summarize(path: &Path, label: &str, filter: &SpawnFilter) -> AppResult<LogMnemonics> {
+    let spawns = load(path, filter)?;
+    let mut by_mnemonic: HashMap<String, LogTotals> = HashMap::new();
+    for spawn in &spawns {
+        by_mnemonic
+            .entry(spawn.mnemonic.clone())
+            .or_default()
+            .add(spawn);
+    }
+    Ok(LogMnemonics {
+        label: label.to_string(),
+        path: path.to_path_buf(),
+        overall: LogTotals::from_spawns(&spawns),
+        by_mnemonic,
+    })
+}
+
+/// One row of the matrix: a mnemonic, or all actions when `mnemonic` is `None`.
+struct Row<'a> {
+    mnemonic: Option<&'a str>,
+    total_time: Duration,
+    /// The totals in each log, `None` where the mnemonic did not run.
+    cells: Vec<Option<&'a LogTotals>>,
+    /// Median hit rate of the logs that ran the mnemonic.
+    median: Option<f64>,
+}
+
+impl<'a> Row<'a> {
+    fn new(mnemonic: Option<&'a str>, cells: Vec<Option<&'a LogTotals>>) -> Self {
+        let mut rates: Vec<f64> = cells
+            .iter()
+            .flatten()
+            .filter(|t| t.actions > 0)
+            .map(|t| t.hit_rate())
+            .collect();
+        rates.sort_by(f64::total_cmp);
+        let median = match rates.len() {
+            0 => None,
+            n if n % 2 == 1 => Some(rates[n / 2]),
+            n => Some((rates[n / 2 - 1] + rates[n / 2]) / 2.0),
+        };
+        Row {
+            mnemonic,
+            total_time: cells.iter().flatten().map(|t| t.total_time).sum(),
+            cells,
+            median,
+        }
+    }
+
+    fn deviates(&self, totals: &LogTotals, deviation: f64) -> bool {
+        totals.actions > 0
+            && self
+                .median
+                .is_some_and(|median| (totals.hit_rate() - median).abs() > deviation)
+    }
+}
+
+/// The row of all actions followed by every mnemonic, by total time across the logs.
+fn matrix(logs: &[LogMnemonics]) -> (Row<'_>, Vec<Row<'_>>) {
+    let overall = Row::new(None, logs.iter().map(|log| Some(&log.overall)).collect());
+    let mut mnemonics: Vec<&str> = logs
+        .iter()
+        .flat_map(|log| log.by_mnemonic.keys().map(String::as_str))
+        .collect();
+    mnemonics.sort_unstable();
+    mnemonics.dedup();
+    let mut rows: Vec<Row> = mnemonics
+        .into_iter()
+        .map(|mnemonic| {
+            let cells = logs
+                .iter()
+                .map(|log| log.by_mnemonic.get(mnemonic))
+                .collect();
+            Row::new(Some(mnemonic), cells)
+        })
+        .collect();
+    rows.sort_by(|a, b| {
+        b.total_time
+            .cmp(&a.total_time)
+            .then(a.mnemonic.cmp(&b.mnemonic))
+    });
+    (overall, rows)
+}
+
+fn print_matrix(logs: &[LogMnemonics], compare: &CompareArgs) {
+    println!("{}", paint(Style::Dim, "========================================"));
+    println!(" Bazel Execution Log Cache Comparison");
+    println!("{}", paint(Style::Dim, "========================================"));
+    for log in logs {
+        println!("{}: {}", log.label, log.path.display());
+    }
+    println!();
+
+    println!("--- Cache Hit Rate by Mnemonic (Top {}) ---", compare.top_n);
+    let (overall, rows) = matrix(logs);
+    let mut columns = vec![
+        ("Mnemonic".to_string(), Align::Left),
+        ("Total Time".to_string(), Align::Right),
+    ];
+    columns.extend(logs.iter().map(|log| (log.label.clone(), Align::Right)));
+    columns.push(("Median".to_string(), Align::Right));
+    let mut table = Table::new(columns);
+    let mut deviating = vec![0; logs.len()];
+    for (index, row) in std::iter::once(&overall)
+        .chain(rows.iter().take(compare.top_n))
+        .enumerate()
+    {
+        let mut cells = vec![
+            row.mnemonic.unwrap_or("(all actions)").to_string(),
+            format_duration(row.total_time, 2),
+        ];
+        for (log, totals) in row.cells.iter().enumerate() {
+            cells.push(match totals {
+                Some(totals) if row.deviates(totals, compare.deviation) => {
+                    // The overall row summarizes the others, so it does not count towards a log.
+                    deviating[log] += (index > 0) as usize;
+                    format!("{:.1}% *", totals.hit_rate())
+                }
+                Some(totals) => format!("{:.1}%  ", totals.hit_rate()),
+                None => "-  ".to_string(),
+            });
+        }
+        cells.push(
+            row.median
+                .map_or_else(|| "-".to_string(), |m| format!("{:.1}%", m)),
+        );
+        table.add_row(cells);
+    }
+    table.print();
+    if rows.len() > compare.top_n {
+        println!("... (+{} more mnemonics)", rows.len() - compare.top_n);
+    }
+    println!(
+        "* differs from the row median by more than {} percentage points (see --deviation).",
+        compare.deviation
+    );
+    if let Some((worst, &count)) = deviating
+        .iter()
+        .enumerate()
+        .filter(|(_, count)| **count > 0)
+        .max_by(|a, b| a.1.cmp(b.1).then(b.0.cmp(&a.0)))
+    {
+        println!(
+            "Most deviating log: {} ({} of {} mnemonics).",
+            logs[worst].label,
+            count,
+            rows.len().min(compare.top_n)
+        );
+    }
+    println!("Note: A mnemonic with few spawns in a log can deviate by chance; the JSON output has the counts.");
+    println!();
+}
+
+#[derive(Serialize)]
+pub struct LogJson<'a> {
+    pub label: &'a str,
+    pub path: String,
+    #[serde(flatten)]
+    pub totals: TotalsJson,
+}
+
+#[derive(Serialize)]
+pub struct CellJson {
+    #[serde(flatten)]
+    pub totals: TotalsJson,
+    pub deviates: bool,
+}
+
+#[derive(Serialize)]
+pub struct RowJson<'a> {
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub mnemonic: Option<&'a str>,
+    pub total_time_nanos: u64,
+    pub median_hit_rate_percent: Option<f64>,
+    /// One entry per log, `null` where the mnemonic did not run.
+    pub cells: Vec<Option<CellJson>>,
+}
+
+impl<'a> RowJson<'a> {
+    fn new(row: &Row<'a>, deviation: f64) -> Self {
+        RowJson {
+            mnemonic: row.mnemonic,
+            total_time_nanos: row.total_time.as_nanos() as u64,
+            median_hit_rate_percent: row.median,
+            cells: row
+                .cells
+                .iter()
+                .map(|totals| {
+                    totals.map(|totals| CellJson {
+                        totals: TotalsJson::from(totals),
+                        deviates: row.deviates(totals, deviation),
+                    })
+                })
+                .collect(),
+        }
+    }
+}
+
+#[derive(Serialize)]
+pub struct CompareJson<'a> {
+    pub schema_version: u32,
+    pub deviation_points: f64,
+    pub logs: Vec<LogJson<'a>>,
+    pub overall: RowJson<'a>,
+    pub mnemonics: Vec<RowJson<'a>>,
+}
+
+fn print_json(logs: &[LogMnemonics], compare: &CompareArgs) -> AppResult<()> {
+    let (overall, rows) = matrix(logs);
+    let document = CompareJson {
+        schema_version: SCHEMA_VERSION,
+        deviation_points: compare.deviation,
+        logs: logs
+            .iter()
+            .map(|log| LogJson {
+                label: &log.label,
+                path: log.path.display().to_string(),
+                totals: TotalsJson::from(&log.overall),
+            })
+            .collect(),
+        overall: RowJson::new(&overall, compare.deviation),
+        mnemonics: rows
+            .iter()
+            .take(compare.top_n)
+            .map(|row| RowJson::new(row, compare.deviation))
+            .collect(),
+    };
+    println!("{}", serde_json::to_string_pretty(&document)?);
+    Ok(())
+}
+
+pub fn run_compare(args: &Cli, compare: &CompareArgs) -> AppResult<()> {
+    let logs = labeled_logs(&compare.logs, &compare.label)?;
+    let filter = SpawnFilter::from_cli(args);
+    let logs = summarize_logs(args, &logs, |path, label| summarize(path, label, &filter))?;
+    match compare.output_format {
+        OutputFormat::Text => print_matrix(&logs, compare),
+        OutputFormat::Json => print_json(&logs, compare)?,
+    }
+    Ok(())
+}
@@ -0,0 +1,931 @@
+//! Compares two execution logs, e.g. yesterday's build with today's.
+
+use crate::analysis::{action_key, LogTotals};
+use crate::classify::is_cache_hit;
+use crate::cli::{Cli, DiffArgs, OutputFormat};
+use crate::execlog::{parse_log_file, parse_log_inputs};
+use crate::filter::SpawnFilter;
+use crate::format::{
+    format_bytes, format_duration, format_seconds, format_seconds_change, Align, Table,
+};
+use crate::metrics::{nanos, total_time};
+use crate::proto::SpawnExec;
+use crate::report::highest_first;
+use crate::reports::grouping::{label_package, NO_LABEL};
+use crate::reports::json::TotalsJson;
+use crate::schema::SCHEMA_VERSION;
+use crate::style::{paint, Style};
+use crate::AppResult;
+use serde::Serialize;
+use std::collections::{HashMap, HashSet};
+use std::fs::File;
+use std::io::{BufWriter, Write};
+use std::path::Path;
+use std::time::Duration;
+
+/// Relative change from `old` to `new` in percent, or `None` when there is nothing to compare
+/// with.
+pub fn relative_change(old: f64, new: f64) -> Option<f64> {
+    if old == 0.0 {
+        (new == 0.0).then_some(0.0)
+    } else {
+        Some((new - old) / old * 100.0)
+    }
+}
+
+fn percent_change(old: f64, new: f64) -> String {
+    relative_change(old, new).map_or_else(|| "n/a".to_string(), |c| format!("{:+.1}%", c))
+}
+
+/// Change of the cache hit rate in percentage points, if both sides ran any actions.
+fn hit_rate_change(old: &LogTotals, new: &LogTotals) -> Option<f64> {
+    (old.actions > 0 && new.actions > 0).then(|| new.hit_rate() - old.hit_rate())
+}
+
+/// Whether a mnemonic's time grew past --time-regression, and whether its hit rate dropped
+/// past --hit-rate-drop.
+fn regression_marks(old: &LogTotals, new: &LogTotals, args: &DiffArgs) -> (bool, bool) {
+    let time = old.total_time > Duration::ZERO
+        && relative_change(old.total_time.as_secs_f64(), new.total_time.as_secs_f64())
+            .is_some_and(|change| change > args.time_regression);
+    let hit_rate = hit_rate_change(old, new).is_some_and(|change| -change > args.hit_rate_drop);
+    (time, hit_rate)
+}
+
+/// Red for a regression, green for an improvement, and plain otherwise.
+fn change_style(regressed: bool, improved: bool) -> Option<Style> {
+    if regressed {
+        Some(Style::Bad)
+    } else if improved {
+        Some(Style::Good)
+    } else {
+        None
+    }
+}
+
+fn signed_seconds(old: Duration, new: Duration) -> String {
+    format_seconds_change(new.as_secs_f64() - old.as_secs_f64(), 2)
+}
+
+fn signed_bytes(old: i64, new: i64) -> String {
+    let delta = new - old;
+    match delta.signum() {
+        1 => format!("+{}", format_bytes(delta)),
+        -1 => format!("-{}", format_bytes(-delta)),
+        _ => format_bytes(0),
+    }
+}
+
+/// Totals of each mnemonic in the old and new log; a side is `None` if the mnemonic only
+/// appears in the other log.
+fn mnemonic_totals<'a>(
+    old: &'a [SpawnExec],
+    new: &'a [SpawnExec],
+) -> Vec<(&'a str, Option<LogTotals>, Option<LogTotals>)> {
+    let mut by_mnemonic: HashMap<&str, (Option<LogTotals>, Option<LogTotals>)> = HashMap::new();
+    for spawn in old {
+        let (totals, _) = by_mnemonic.entry(spawn.mnemonic.as_str()).or_default();
+        totals.get_or_insert_with(LogTotals::default).add(spawn);
+    }
+    for spawn in new {
+        let (_, totals) = by_mnemonic.entry(spawn.mnemonic.as_str()).or_default();
+        totals.get_or_insert_with(LogTotals::default).add(spawn);
+    }
+    let time =
+        |totals: &Option<LogTotals>| totals.as_ref().map_or(0.0, |t| t.total_time.as_secs_f64());
+    let mut rows: Vec<_> = by_mnemonic
+        .into_iter()
+        .map(|(mnemonic, (old, new))| (mnemonic, old, new))
+        .collect();
+    rows.sort_by(|a, b| {
+        let growth =
+            |row: &(&str, Option<LogTotals>, Option<LogTotals>)| time(&row.2) - time(&row.1);
+        growth(b).total_cmp(&growth(a)).then(a.0.cmp(b.0))
+    });
+    rows
+}
+
+fn print_mnemonic_changes(old: &[SpawnExec], new: &[SpawnExec], args: &DiffArgs) {
+    println!("--- Changes by Mnemonic ---");
+    let mut table = Table::new(vec![
+        ("Mnemonic".to_string(), Align::Left),
+        ("Old Count".to_string(), Align::Right),
+        ("New Count".to_string(), Align::Right),
+        ("Old Time".to_string(), Align::Right),
+        ("New Time".to_string(), Align::Right),
+        ("Change".to_string(), Align::Right),
+        ("Old Hits".to_string(), Align::Right),
+        ("New Hits".to_string(), Align::Right),
+        ("Hits Change".to_string(), Align::Right),
+        ("".to_string(), Align::Left),
+    ]);
+    let mut regressions = 0;
+    for (mnemonic, old, new) in mnemonic_totals(old, new) {
+        let name = match (&old, &new) {
+            (None, _) => format!("{} (new)", mnemonic),
+            (_, None) => format!("{} (gone)", mnemonic),
+            _ => mnemonic.to_string(),
+        };
+        let (old, new) = (old.unwrap_or_default(), new.unwrap_or_default());
+        let (old_time, new_time) = (old.total_time.as_secs_f64(), new.total_time.as_secs_f64());
+        let hit_rate = |totals: &LogTotals| {
+            if totals.actions == 0 {
+                "-".to_string()
+            } else {
+                format!("{:.1}%", totals.hit_rate())
+            }
+        };
+        let hit_change = hit_rate_change(&old, &new);
+
+        let (time_regressed, hit_rate_dropped) = regression_marks(&old, &new, args);
+        let mut marks = Vec::new();
+        if time_regressed {
+            marks.push("TIME");
+        }
+        if hit_rate_dropped {
+            marks.push("HIT RATE");
+        }
+        regressions += !marks.is_empty() as usize;
+        table.add_row(vec![
+            name,
+            old.actions.to_string(),
+            new.actions.to_string(),
+            format_seconds(old_time, 2),
+            format_seconds(new_time, 2),
+            paint(
+                change_style(time_regressed, new_time < old_time),
+                format!(
+                    "{} ({})",
+                    signed_seconds(old.total_time, new.total_time),
+                    percent_change(old_time, new_time)
+                ),
+            ),
+            hit_rate(&old),
+            hit_rate(&new),
+            hit_change.map_or_else(String::new, |change| {
+                paint(
+                    change_style(hit_rate_dropped, change > 0.0),
+                    format!("{:+.1} pp", change),
+                )
+            }),
+            if marks.is_empty() {
+                String::new()
+            } else {
+                paint(Style::Bad, format!("<< {}", marks.join(", ")))
+            },
+        ]);
+    }
+    table.print();
+    println!(
+        "{} mnemonics regressed (time up more than {}% or hit rate down more than {} points, see --time-regression and --hit-rate-drop).",
+        regressions, args.time_regression, args.hit_rate_drop
+    );
+    println!();
+}
+
+/// Groups of unmatched actions printed per log; all of them go to --diff-details.
+const MAX_GROUPS_SHOWN: usize = 15;
+
+/// The spawns whose action does not occur in `other`.
+fn unmatched<'a>(spawns: &'a [SpawnExec], other: &[SpawnExec]) -> Vec<&'a SpawnExec> {
+    let keys: HashSet<(&str, &str, &str)> = other.iter().map(action_key).collect();
+    spawns
+        .iter()
+        .filter(|s| !keys.contains(&action_key(s)))
+        .collect()
+}
+
+fn print_unmatched_groups(title: &str, spawns: &[&SpawnExec]) {
+    let total: Duration = spawns.iter().map(|s| total_time(s)).sum();
+    println!(
+        "{}: {} actions, {}",
+        title,
+        spawns.len(),
+        format_duration(total, 2));
+    if spawns.is_empty() {
+        return;
+    }
+    let mut groups: HashMap<(&str, String), (u64, Duration)> = HashMap::new();
+    for spawn in spawns {
+        let group = groups
+            .entry((
+                spawn.mnemonic.as_str(),
+                label_package(&spawn.target_label, None),
+            ))
+            .or_default();
+        group.0 += 1;
+        group.1 += total_time(spawn);
+    }
+    let mut sorted: Vec<_> = groups.into_iter().collect();
+    sorted.sort_by(|a, b| b.1 .1.cmp(&a.1 .1).then(a.0.cmp(&b.0)));
+    let mut table = Table::new(vec![
+        ("Mnemonic".to_string(), Align::Left),
+        ("Package".to_string(), Align::Left),
+        ("Actions".to_string(), Align::Right),
+        ("Total Time".to_string(), Align::Right),
+    ]);
+    for ((mnemonic, package), (count, time)) in sorted.iter().take(MAX_GROUPS_SHOWN) {
+        table.add_row(vec![
+            mnemonic.to_string(),
+            package.clone(),
+            count.to_string(),
+            format_duration(*time, 2),
+        ]);
+    }
+    table.print();
+    if sorted.len() > MAX_GROUPS_SHOWN {
+        println!("... (+{} more groups)", sorted.len() - MAX_GROUPS_SHOWN);
+    }
+}
+
+/// Writes one tab-separated line per unmatched action.
+fn write_details(path: &Path, only_old: &[&SpawnExec], only_new: &[&SpawnExec]) -> AppResult<()> {
+    let mut out = BufWriter::new(File::create(path)?);
+    writeln!(out, "log\tmnemonic\tlabel\toutput\ttotal_seconds")?;
+    for (side, spawns) in [("old", only_old), ("new", only_new)] {
+        for spawn in spawns {
+            let (label, mnemonic, output) = action_key(spawn);
+            writeln!(
+                out,
+                "{}\t{}\t{}\t{}\t{:.3}",
+                side,
+                mnemonic,
+                label,
+                output,
+                total_time(spawn).as_secs_f64()
+            )?;
+        }
+    }
+    out.flush()?;
+    Ok(())
+}
+
+fn print_unmatched_actions(
+    old: &[SpawnExec],
+    new: &[SpawnExec],
+    details: Option<&Path>,
+) -> AppResult<()> {
+    println!("--- Actions Present in Only One Log ---");
+    let only_old = unmatched(old, new);
+    let only_new = unmatched(new, old);
+    if only_old.is_empty() && only_new.is_empty() {
+        println!("Every action of each log matches an action in the other.");
+    } else {
+        print_unmatched_groups("Only in the new log", &only_new);
+        println!();
+        print_unmatched_groups("Only in the old log", &only_old);
+    }
+    if let Some(path) = details {
+        write_details(path, &only_old, &only_new)?;
+        println!(
+            "Wrote {} unmatched actions to {}.",
+            only_old.len() + only_new.len(),
+            path.display()
+        );
+    }
+    println!("Note: Actions are matched by label, mnemonic and primary output path, ignoring its bazel-out/<config>/ segment.");
+    println!();
+    Ok(())
+}
+
+/// Indexes spawns by their action; of repeated executions, the last one is kept.
+fn by_action(spawns: &[SpawnExec]) -> HashMap<(&str, &str, &str), &SpawnExec> {
+    spawns.iter().map(|s| (action_key(s), s)).collect()
+}
+
+/// Pairs each spawn of the new log with the spawn of the same action in the old log. When an
+/// action ran several times in a log, its last execution is used.
+fn matched_actions<'a>(
+    old: &'a [SpawnExec],
+    new: &'a [SpawnExec],
+) -> Vec<(&'a SpawnExec, &'a SpawnExec)> {
+    let old_actions = by_action(old);
+    let mut pairs: Vec<_> = by_action(new)
+        .into_iter()
+        .filter_map(|(key, new)| old_actions.get(&key).map(|old| (*old, new)))
+        .collect();
+    pairs.sort_by(|a, b| action_key(a.1).cmp(&action_key(b.1)));
+    pairs
+}
+
+/// Transitions listed individually and groups shown per table.
+const MAX_TRANSITIONS_SHOWN: usize = 10;
+
+fn print_transition_groups(
+    header: &str,
+    transitions: &[(&SpawnExec, &SpawnExec)],
+    key: impl Fn(&SpawnExec) -> String,
+) {
+    let mut groups: HashMap<String, (u64, Duration)> = HashMap::new();
+    for (_, new) in transitions {
+        let group = groups.entry(key(new)).or_default();
+        group.0 += 1;
+        group.1 += total_time(new);
+    }
+    let mut sorted: Vec<_> = groups.into_iter().collect();
+    sorted.sort_by(|a, b| b.1 .1.cmp(&a.1 .1).then(a.0.cmp(&b.0)));
+    let mut table = Table::new(vec![
+        (header.to_string(), Align::Left),
+        ("Actions".to_string(), Align::Right),
+        ("New Time".to_string(), Align::Right),
+    ]);
+    for (name, (count, time)) in sorted.iter().take(MAX_TRANSITIONS_SHOWN) {
+        table.add_row(vec![
+            name.clone(),
+            count.to_string(),
+            format_duration(*time, 2),
+        ]);
+    }
+    table.print();
+    if sorted.len() > MAX_TRANSITIONS_SHOWN {
+        println!("... (+{} more)", sorted.len() - MAX_TRANSITIONS_SHOWN);
+    }
+}
+
+/// Matched actions whose cache result differs between the logs, each list slowest first by
+/// its time in the new log.
+struct Transitions<'a> {
+    matched: usize,
+    new_misses: Vec<(&'a SpawnExec, &'a SpawnExec)>,
+    new_hits: Vec<(&'a SpawnExec, &'a SpawnExec)>,
+}
+
+fn cache_transitions<'a>(
+    old: &'a [SpawnExec],
+    new: &'a [SpawnExec],
+    same_command: bool,
+) -> Transitions<'a> {
+    let pairs: Vec<_> = matched_actions(old, new)
+        .into_iter()
+        .filter(|(old, new)| !same_command || old.command_args == new.command_args)
+        .collect();
+    let mut new_misses: Vec<_> = pairs
+        .iter()
+        .copied()
+        .filter(|(old, new)| is_cache_hit(old) && !is_cache_hit(new))
+        .collect();
+    let mut new_hits: Vec<_> = pairs
+        .iter()
+        .copied()
+        .filter(|(old, new)| !is_cache_hit(old) && is_cache_hit(new))
+        .collect();
+    new_misses.sort_by(|a, b| highest_first(a.1, b.1, total_time));
+    new_hits.sort_by(|a, b| highest_first(a.1, b.1, total_time));
+    Transitions {
+        matched: pairs.len(),
+        new_misses,
+        new_hits,
+    }
+}
+
+fn print_cache_transitions(transitions: &Transitions, same_command: bool) {
+    println!("--- Cache Hit/Miss Transitions ---");
+    let new_misses = &transitions.new_misses;
+    let miss_time: Duration = new_misses.iter().map(|(_, new)| total_time(new)).sum();
+    println!(
+        "{} matched actions{}: {} went from hit to miss ({} in the new log), {} from miss to hit.",
+        transitions.matched,
+        if same_command { " with identical command lines" } else { "" },
+        new_misses.len(),
+        format_duration(miss_time, 2),
+        transitions.new_hits.len()
+    );
+    if new_misses.is_empty() {
+        println!();
+        return;
+    }
+
+    println!();
+    println!("Slowest New Misses:");
+    for (index, (_, new)) in new_misses.iter().take(MAX_TRANSITIONS_SHOWN).enumerate() {
+        println!(
+            "  {:>2}. {} | {} | {}",
+            index + 1,
+            format_duration(total_time(new), 3),
+            new.mnemonic,
+            if new.target_label.is_empty() {
+                NO_LABEL
+            } else {
+                &new.target_label
+            }
+        );
+    }
+    println!();
+    println!("New Misses by Mnemonic:");
+    print_transition_groups("Mnemonic", new_misses, |s| s.mnemonic.clone());
+    println!();
+    println!("New Misses by Package:");
+    print_transition_groups("Package", new_misses, |s| {
+        label_package(&s.target_label, None)
+    });
+    if !same_command {
+        println!("Note: --same-command keeps only actions with identical command lines, leaving misses caused by inputs or the environment.");
+    }
+    println!();
+}
+
+#[derive(Clone, Copy, PartialEq, Eq, Hash, PartialOrd, Ord, Serialize)]
+#[serde(rename_all = "lowercase")]
+pub enum InputChange {
+    Changed,
+    Added,
+    Removed,
+}
+
+impl InputChange {
+    fn name(self) -> &'static str {
+        match self {
+            InputChange::Changed => "changed",
+            InputChange::Added => "added",
+            InputChange::Removed => "removed",
+        }
+    }
+}
+
+/// Causes printed for --explain-misses.
+const MAX_CAUSES_SHOWN: usize = 15;
+
+/// The input paths whose digest differs between two executions of an action, or that only
+/// one of them has.
+fn input_changes<'a>(old: &'a SpawnExec, new: &'a SpawnExec) -> Vec<(InputChange, &'a str)> {
+    let digests = |spawn: &'a SpawnExec| -> HashMap<&'a str, &'a str> {
+        spawn
+            .inputs
+            .iter()
+            .map(|f| {
+                (
+                    f.path.as_str(),
+                    f.digest.as_ref().map_or("", |d| d.hash.as_str()),
+                )
+            })
+            .collect()
+    };
+    let (old_digests, new_digests) = (digests(old), digests(new));
+    let mut changes: Vec<(InputChange, &str)> = Vec::new();
+    for (path, digest) in &new_digests {
+        match old_digests.get(path) {
+            None => changes.push((InputChange::Added, path)),
+            Some(old_digest) if old_digest != digest => changes.push((InputChange::Changed, path)),
+            Some(_) => {}
+        }
+    }
+    for path in old_digests.keys().filter(|p| !new_digests.contains_key(*p)) {
+        changes.push((InputChange::Removed, path));
+    }
+    changes
+}
+
+/// An input change shared by new misses, with the number of misses and their time.
+struct MissCause {
+    change: InputChange,
+    path: String,
+    misses: u64,
+    time: Duration,
+}
+
+/// The input changes of the explained misses, ranked by the miss time they explain.
+struct MissCauses {
+    compared: usize,
+    /// Explained misses whose inputs are identical in both logs.
+    unchanged: usize,
+    causes: Vec<MissCause>,
+}
+
+/// Diffs the inputs of the `limit` slowest hit-to-miss transitions.
+///
+/// Inputs are decoded in a second pass over both logs, for the explained actions only.
+fn miss_causes(
+    diff: &DiffArgs,
+    misses: &[(&SpawnExec, &SpawnExec)],
+    limit: usize,
+) -> AppResult<MissCauses> {
+    let explained = &misses[..misses.len().min(limit)];
+    if explained.is_empty() {
+        return Ok(MissCauses {
+            compared: 0,
+            unchanged: 0,
+            causes: Vec::new(),
+        });
+    }
+    let wanted: HashSet<(&str, &str, &str)> =
+        explained.iter().map(|(_, new)| action_key(new)).collect();
+    let select = |s: &SpawnExec| wanted.contains(&action_key(s));
+    let old_spawns = parse_log_inputs(&diff.old, &select)?;
+    let new_spawns = parse_log_inputs(&diff.new, &select)?;
+    let (old_actions, new_actions) = (by_action(&old_spawns), by_action(&new_spawns));
+
+    let mut causes: HashMap<(InputChange, &str), (u64, Duration)> = HashMap::new();
+    let mut unchanged = 0;
+    for key in explained.iter().map(|(_, new)| action_key(new)) {
+        let (Some(old), Some(new)) = (old_actions.get(&key), new_actions.get(&key)) else {
+            continue;
+        };
+        let changes = input_changes(old, new);
+        unchanged += changes.is_empty() as usize;
+        for change in changes {
+            let cause = causes.entry(change).or_default();
+            cause.0 += 1;
+            cause.1 += total_time(new);
+        }
+    }
+
+    let mut sorted: Vec<_> = causes.into_iter().collect();
+    sorted.sort_by(|a, b| {
+        b.1 .1
+            .cmp(&a.1 .1)
+            .then(b.1 .0.cmp(&a.1 .0))
+            .then(a.0.cmp(&b.0))
+    });
+    Ok(MissCauses {
+        compared: explained.len(),
+        unchanged,
+        causes: sorted
+            .into_iter()
+            .map(|((change, path), (misses, time))| MissCause {
+                change,
+                path: path.to_string(),
+                misses,
+                time,
+            })
+            .collect(),
+    })
+}
+
+/// Prints the input changes shared by the `limit` slowest hit-to-miss transitions.
+fn print_miss_causes(
+    diff: &DiffArgs,
+    misses: &[(&SpawnExec, &SpawnExec)],
+    limit: usize,
+) -> AppResult<()> {
+    println!("--- Changed Inputs Behind New Misses ---");
+    let explained = miss_causes(diff, misses, limit)?;
+    if explained.compared == 0 {
+        println!("No actions went from cache hit to miss.");
+        println!();
+        return Ok(());
+    }
+    println!(
+        "Compared the inputs of the {} slowest of {} new misses.",
+        explained.compared,
+        misses.len()
+    );
+    for (index, cause) in explained.causes.iter().take(MAX_CAUSES_SHOWN).enumerate() {
+        println!(
+            "  {:>2}. {} {} ({}) {} {} input {}",
+            index + 1,
+            cause.misses,
+            if cause.misses == 1 { "miss" } else { "misses" },
+            format_duration(cause.time, 2),
+            if cause.misses == 1 { "has" } else { "share" },
+            cause.change.name(),
+            cause.path
+        );
+    }
+    if explained.causes.len() > MAX_CAUSES_SHOWN {
+        println!(
+            "... (+{} more changed inputs)",
+            explained.causes.len() - MAX_CAUSES_SHOWN
+        );
+    }
+    if explained.unchanged > 0 {
+        println!(
+            "{} misses have identical inputs in both logs; their command line, environment or platform changed.",
+            explained.unchanged
+        );
+    }
+    println!();
+    Ok(())
+}
+
+/// Parses a log and applies the filter flags, as the single-log analysis does.
+pub fn load(path: &Path, filter: &SpawnFilter) -> AppResult<Vec<SpawnExec>> {
+    let spawns = parse_log_file(path, false)?;
+    if !filter.is_active() {
+        return Ok(spawns);
+    }
+    let total = spawns.len();
+    let (matching, summary) = filter.apply(spawns);
+    eprintln!(
+        "{}: {} of {} spawns match ({}).",
+        path.display(),
+        matching.len(),
+        total,
+        summary.description
+    );
+    Ok(matching)
+}
+
+/// Changes from the old to the new totals; relative changes are `null` when the old value is
+/// zero.
+#[derive(Serialize)]
+pub struct ChangeJson {
+    pub actions: i64,
+    pub actions_percent: Option<f64>,
+    pub cache_hit_rate_points: Option<f64>,
+    pub total_time_nanos: i64,
+    pub total_time_percent: Option<f64>,
+    pub downloaded_bytes: i64,
+    pub downloaded_bytes_percent: Option<f64>,
+}
+
+impl ChangeJson {
+    fn between(old: &LogTotals, new: &LogTotals) -> Self {
+        ChangeJson {
+            actions: new.actions as i64 - old.actions as i64,
+            actions_percent: relative_change(old.actions as f64, new.actions as f64),
+            cache_hit_rate_points: hit_rate_change(old, new),
+            total_time_nanos: nanos(new.total_time) as i64 - nanos(old.total_time) as i64,
+            total_time_percent: relative_change(
+                old.total_time.as_secs_f64(),
+                new.total_time.as_secs_f64(),
+            ),
+            downloaded_bytes: new.downloaded_bytes - old.downloaded_bytes,
+            downloaded_bytes_percent: relative_change(
+                old.downloaded_bytes as f64,
+                new.downloaded_bytes as f64,
+            ),
+        }
+    }
+}
+
+#[derive(Serialize)]
+pub struct OverallJson {
+    pub old: TotalsJson,
+    pub new: TotalsJson,
+    pub change: ChangeJson,
+}
+
+#[derive(Serialize)]
+#[serde(rename_all = "lowercase")]
+pub enum Presence {
+    Both,
+    New,
+    Gone,
+}
+
+#[derive(Serialize)]
+pub struct MnemonicJson<'a> {
+    pub mnemonic: &'a str,
+    pub presence: Presence,
+    pub old: Option<TotalsJson>,
+    pub new: Option<TotalsJson>,
+    pub change: ChangeJson,
+    pub time_regressed: bool,
+    pub hit_rate_dropped: bool,
+}
+
+#[derive(Serialize)]
+pub struct ActionJson<'a> {
+    pub label: &'a str,
+    pub mnemonic: &'a str,
+    pub output: &'a str,
+    pub total_time_nanos: u64,
+}
+
+#[derive(Serialize)]
+pub struct ActionsJson<'a> {
+    pub count: usize,
+    pub total_time_nanos: u64,
+    pub actions: Vec<ActionJson<'a>>,
+}
+
+impl<'a> ActionsJson<'a> {
+    fn new(spawns: impl Iterator<Item = &'a SpawnExec>) -> Self {
+        let actions: Vec<ActionJson> = spawns
+            .map(|spawn| {
+                let (label, mnemonic, output) = action_key(spawn);
+                ActionJson {
+                    label,
+                    mnemonic,
+                    output,
+                    total_time_nanos: nanos(total_time(spawn)),
+                }
+            })
+            .collect();
+        ActionsJson {
+            count: actions.len(),
+            total_time_nanos: actions.iter().map(|a| a.total_time_nanos).sum(),
+            actions,
+        }
+    }
+}
+
+#[derive(Serialize)]
+pub struct PresenceJson<'a> {
+    pub only_old: ActionsJson<'a>,
+    pub only_new: ActionsJson<'a>,
+}
+
+#[derive(Serialize)]
+pub struct TransitionsJson<'a> {
+    pub matched_actions: usize,
+    pub same_command: bool,
+    pub hit_to_miss: ActionsJson<'a>,
+    pub miss_to_hit: ActionsJson<'a>,
+}
+
+#[derive(Serialize)]
+pub struct MissCauseJson<'a> {
+    pub change: InputChange,
+    pub path: &'a str,
+    pub misses: u64,
+    pub miss_time_nanos: u64,
+}
+
+#[derive(Serialize)]
+pub struct MissCausesJson<'a> {
+    pub compared_misses: usize,
+    pub identical_inputs: usize,
+    pub causes: Vec<MissCauseJson<'a>>,
+}
+
+#[derive(Serialize)]
+pub struct ThresholdsJson {
+    pub time_regression_percent: f64,
+    pub hit_rate_drop_points: f64,
+}
+
+#[derive(Serialize)]
+pub struct DiffJson<'a> {
+    pub schema_version: u32,
+    pub old_log: String,
+    pub new_log: String,
+    pub thresholds: ThresholdsJson,
+    pub overall: OverallJson,
+    pub mnemonics: Vec<MnemonicJson<'a>>,
+    pub presence: PresenceJson<'a>,
+    pub transitions: TransitionsJson<'a>,
+    /// Only present with --explain-misses.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub miss_causes: Option<MissCausesJson<'a>>,
+}
+
+/// Prints the whole comparison as one JSON document, with times in nanoseconds and
+/// percentages as unrounded numbers.
+fn print_json(
+    diff: &DiffArgs,
+    old_spawns: &[SpawnExec],
+    new_spawns: &[SpawnExec],
+    transitions: &Transitions,
+) -> AppResult<()> {
+    let (old, new) = (
+        LogTotals::from_spawns(old_spawns),
+        LogTotals::from_spawns(new_spawns),
+    );
+    let mnemonics = mnemonic_totals(old_spawns, new_spawns)
+        .into_iter()
+        .map(|(mnemonic, old, new)| {
+            let presence = match (&old, &new) {
+                (None, _) => Presence::New,
+                (_, None) => Presence::Gone,
+                _ => Presence::Both,
+            };
+            let (old_totals, new_totals) = (
+                old.as_ref().map(TotalsJson::from),
+                new.as_ref().map(TotalsJson::from),
+            );
+            let (old, new) = (old.unwrap_or_default(), new.unwrap_or_default());
+            let (time_regressed, hit_rate_dropped) = regression_marks(&old, &new, diff);
+            MnemonicJson {
+                mnemonic,
+                presence,
+                old: old_totals,
+                new: new_totals,
+                change: ChangeJson::between(&old, &new),
+                time_regressed,
+                hit_rate_dropped,
+            }
+        })
+        .collect();
+
+    let only_old = unmatched(old_spawns, new_spawns);
+    let only_new = unmatched(new_spawns, old_spawns);
+    if let Some(path) = diff.diff_details.as_deref() {
+        write_details(path, &only_old, &only_new)?;
+        eprintln!(
+            "Wrote {} unmatched actions to {}.",
+            only_old.len() + only_new.len(),
+            path.display()
+        );
+    }
+    let causes = match diff.explain_misses {
+        Some(limit) => Some(miss_causes(diff, &transitions.new_misses, limit)?),
+        None => None,
+    };
+
+    let document = DiffJson {
+        schema_version: SCHEMA_VERSION,
+        old_log: diff.old.display().to_string(),
+        new_log: diff.new.display().to_string(),
+        thresholds: ThresholdsJson {
+            time_regression_percent: diff.time_regression,
+            hit_rate_drop_points: diff.hit_rate_drop,
+        },
+        overall: OverallJson {
+            old: TotalsJson::from(&old),
+            new: TotalsJson::from(&new),
+            change: ChangeJson::between(&old, &new),
+        },
+        mnemonics,
+        presence: PresenceJson {
+            only_old: ActionsJson::new(only_old.into_iter()),
+            only_new: ActionsJson::new(only_new.into_iter()),
+        },
+        transitions: TransitionsJson {
+            matched_actions: transitions.matched,
+            same_command: diff.same_command,
+            hit_to_miss: ActionsJson::new(transitions.new_misses.iter().map(|(_, new)| *new)),
+            miss_to_hit: ActionsJson::new(transitions.new_hits.iter().map(|(_, new)| *new)),
+        },
+        miss_causes: causes.as_ref().map(|causes| MissCausesJson {
+            compared_misses: causes.compared,
+            identical_inputs: causes.unchanged,
+            causes: causes
+                .causes
+                .iter()
+                .map(|cause| MissCauseJson {
+                    change: cause.change,
+                    path: &cause.path,
+                    misses: cause.misses,
+                    miss_time_nanos: nanos(cause.time),
+                })
+                .collect(),
+        }),
+    };
+    println!("{}", serde_json::to_string_pretty(&document)?);
+    Ok(())
+}
+
+pub fn run_diff(args: &Cli, diff: &DiffArgs) -> AppResult<()> {
+    let (old_path, new_path) = (diff.old.as_path(), diff.new.as_path());
+    let filter = SpawnFilter::from_cli(args);
+    let old_spawns = load(old_path, &filter)?;
+    let new_spawns = load(new_path, &filter)?;
+    let transitions = cache_transitions(&old_spawns, &new_spawns, diff.same_command);
+    if diff.output_format == OutputFormat::Json {
+        return print_json(diff, &old_spawns, &new_spawns, &transitions);
+    }
+    let old = LogTotals::from_spawns(&old_spawns);
+    let new = LogTotals::from_spawns(&new_spawns);
+
+    println!("{}", paint(Style::Dim, "========================================"));
+    println!(" Bazel Execution Log Comparison");
+    println!("{}", paint(Style::Dim, "========================================"));
+    println!("Old log: {}", old_path.display());
+    println!("New log: {}\n", new_path.display());
+
+    println!("--- Overall Changes ---");
+    let mut table = Table::new(vec![
+        ("Metric".to_string(), Align::Left),
+        ("Old".to_string(), Align::Right),
+        ("New".to_string(), Align::Right),
+        ("Change".to_string(), Align::Right),
+        ("Change %".to_string(), Align::Right),
+    ]);
+    table.add_row(vec![
+        "Total Actions".to_string(),
+        old.actions.to_string(),
+        new.actions.to_string(),
+        format!("{:+}", new.actions as i64 - old.actions as i64),
+        percent_change(old.actions as f64, new.actions as f64),
+    ]);
+    // A relative change of a rate is easy to misread, so only the difference in points is shown.
+    table.add_row(vec![
+        "Cache Hit Rate".to_string(),
+        format!("{:.2}%", old.hit_rate()),
+        format!("{:.2}%", new.hit_rate()),
+        paint(
+            change_style(new.hit_rate() < old.hit_rate(), new.hit_rate() > old.hit_rate()),
+            format!("{:+.2} pp", new.hit_rate() - old.hit_rate()),
+        ),
+        String::new(),
+    ]);
+    table.add_row(vec![
+        "Total Spawn Time".to_string(),
+        format_duration(old.total_time, 2),
+        format_duration(new.total_time, 2),
+        paint(
+            change_style(new.total_time > old.total_time, new.total_time < old.total_time),
+            signed_seconds(old.total_time, new.total_time),
+        ),
+        percent_change(old.total_time.as_secs_f64(), new.total_time.as_secs_f64()),
+    ]);
+    table.add_row(vec![
+        "Data Downloaded".to_string(),
+        format_bytes(old.downloaded_bytes),
+        format_bytes(new.downloaded_bytes),
+        signed_bytes(old.downloaded_bytes, new.downloaded_bytes),
+        percent_change(old.downloaded_bytes as f64, new.downloaded_bytes as f64),
+    ]);
+    table.print();
+    println!("Note: Data downloaded counts the outputs of remote cache hits.");
+    println!();
+
+    print_mnemonic_changes(&old_spawns, &new_spawns, diff);
+    print_unmatched_actions(&old_spawns, &new_spawns, diff.diff_details.as_deref())?;
+    print_cache_transitions(&transitions, diff.same_command);
+    if let Some(limit) = diff.explain_misses {
+        print_miss_causes(diff, &transitions.new_misses, limit)?;
+    }
+    Ok(())
+}
@@ -1 +1,11 @@
-pub mod analyze;
\ No newline at end of file
+pub mod analyze;
+pub mod check;
+pub mod compare;
+pub mod diff;
+pub mod policy;
+pub mod serve;
+pub mod split;
+pub mod trend;
+pub mod tui;
+pub mod verify;
+pub mod watch;
@@ -0,0 +1,590 @@
+//! Evaluates a log against a policy file: rules that select spawns by mnemonic or label and
+//! constrain their cache hit rate, cacheability, remotability or average duration.
+//!
+//! The policy is TOML, one `[[rule]]` table per rule:
+//!
+//! ```toml
+//! [[rule]]
+//! name = "Go compiles are cached"
+//! mnemonic = "GoCompile*"
+//! label = "//services/..."
+//! min-hit-rate = "90%"
+//! must-be-cacheable = true
+//! must-be-remotable = true
+//! max-average-duration = "2s"
+//! ```
+//!
+//! A rule that matches no spawn is reported as not exercised instead of passing, since that
+//! usually means a mnemonic or target was renamed and the rule no longer guards anything.
+
+use crate::analysis::action_key;
+use crate::cli::{
+    parse_duration, parse_label_pattern, parse_percent, Cli, LabelPattern, PolicyArgs,
+};
+use crate::classify::is_cache_hit;
+use crate::commands::diff::load;
+use crate::filter::{wildcard_match, SpawnFilter};
+use crate::format::{format_duration, Align, Table};
+use crate::metrics::recorded_total_time;
+use crate::proto::SpawnExec;
+use crate::reports::grouping::{label_pattern_matches, NO_LABEL};
+use crate::{AppError, AppResult, Gate};
+use serde::Deserialize;
+use std::fs;
+use std::path::Path;
+use std::time::Duration;
+
+/// Offending actions listed below a failed cacheability or remotability check.
+const MAX_ACTIONS_SHOWN: usize = 10;
+
+/// The policy file as written, before validation.
+#[derive(Deserialize)]
+#[serde(deny_unknown_fields)]
+struct PolicyFile {
+    #[serde(default)]
+    rule: Vec<toml::Spanned<RuleFile>>,
+}
+
+#[derive(Deserialize)]
+#[serde(rename_all = "kebab-case", deny_unknown_fields)]
+struct RuleFile {
+    name: Option<String>,
+    mnemonic: Option<String>,
+    label: Option<String>,
+    /// A number (`90`) or text (`"90%"`).
+    min_hit_rate: Option<toml::Value>,
+    must_be_cacheable: Option<bool>,
+    must_be_remotable: Option<bool>,
+    max_average_duration: Option<toml::Value>,
+}
+
+/// What a rule requires of the spawns it matches.
+#[derive(Clone, Debug, PartialEq)]
+pub enum Constraint {
+    /// The share of cache hits, in percent.
+    MinHitRate(f64),
+    MustBeCacheable,
+    MustBeRemotable,
+    /// The mean of the recorded total times.
+    MaxAverageDuration(Duration),
+}
+
+/// One rule of a policy: the spawns it selects and what they must satisfy.
+#[derive(Clone, Debug)]
+pub struct Rule {
+    /// The rule's `name`, or else its patterns.
+    pub name: String,
+    /// A mnemonic with `*` and `?` wildcards.
+    pub mnemonic: Option<String>,
+    pub label: Option<LabelPattern>,
+    /// In the order of the table columns, at least one.
+    pub constraints: Vec<Constraint>,
+}
+
+impl Rule {
+    pub fn matches(&self, spawn: &SpawnExec) -> bool {
+        self.mnemonic
+            .as_ref()
+            .is_none_or(|pattern| wildcard_match(pattern, &spawn.mnemonic))
+            && self
+                .label
+                .as_ref()
+                .is_none_or(|pattern| label_pattern_matches(pattern, &spawn.target_label))
+    }
+}
+
+/// The 1-based line of byte `offset` of `content`.
+fn line_of(content: &str, offset: usize) -> usize {
+    content[..offset.min(content.len())].matches('\n').count() + 1
+}
+
+/// Reads and validates a policy file, naming the file and the line of the rule in errors.
+pub fn load_policy(path: &Path) -> AppResult<Vec<Rule>> {
+    let content = fs::read_to_string(path)
+        .map_err(|err| AppError::InLog(path.to_path_buf(), Box::new(err.into())))?;
+    let invalid = |line: Option<usize>, message: String| match line {
+        Some(line) => AppError::Analysis(format!("{}:{}: {}", path.display(), line, message)),
+        None => AppError::Analysis(format!("{}: {}", path.display(), message)),
+    };
+    let file: PolicyFile = toml::from_str(&content).map_err(|err| {
+        let line = err.span().map(|span| line_of(&content, span.start));
+        invalid(line, err.message().to_string())
+    })?;
+    if file.rule.is_empty() {
+        return Err(invalid(
+            None,
+            "the policy has no rules; add a [[rule]] table for each".to_string(),
+        ));
+    }
+    let mut rules = Vec::new();
+    for rule in &file.rule {
+        let line = line_of(&content, rule.span().start);
+        let rule = validate_rule(rule.get_ref()).map_err(|message| {
+            invalid(Some(line), format!("invalid rule: {}", message))
+        })?;
+        rules.push(rule);
+    }
+    Ok(rules)
+}
+
+fn validate_rule(rule: &RuleFile) -> Result<Rule, String> {
+    let mnemonic = match rule.mnemonic.as_deref().map(str::trim) {
+        Some("") => return Err("the mnemonic pattern is empty".to_string()),
+        mnemonic => mnemonic.map(str::to_string),
+    };
+    let label = rule
+        .label
+        .as_deref()
+        .map(|label| parse_label_pattern(label.trim()))
+        .transpose()?;
+    if mnemonic.is_none() && label.is_none() {
+        return Err(
+            "it selects no spawns; give a mnemonic pattern, a label pattern or both".to_string(),
+        );
+    }
+
+    let mut constraints = Vec::new();
+    if let Some(rate) = &rule.min_hit_rate {
+        let text = match rate {
+            toml::Value::Integer(number) => number.to_string(),
+            toml::Value::Float(number) => number.to_string(),
+            toml::Value::String(text) => text.clone(),
+            _ => return Err("min-hit-rate takes a percentage, e.g. 90 or \"90%\"".to_string()),
+        };
+        let percent = parse_percent(&text).map_err(|err| format!("min-hit-rate: {}", err))?;
+        if percent > 100.0 {
+            return Err(format!(
+                "min-hit-rate must be between 0 and 100%, not {}",
+                text
+            ));
+        }
+        constraints.push(Constraint::MinHitRate(percent));
+    }
+    for (key, value, constraint) in [
+        (
+            "must-be-cacheable",
+            rule.must_be_cacheable,
+            Constraint::MustBeCacheable,
+        ),
+        (
+            "must-be-remotable",
+            rule.must_be_remotable,
+            Constraint::MustBeRemotable,
+        ),
+    ] {
+        match value {
+            Some(true) => constraints.push(constraint),
+            Some(false) => {
+                return Err(format!(
+                    "{} = false requires nothing; leave the key out instead",
+                    key
+                ))
+            }
+            None => {}
+        }
+    }
+    if let Some(duration) = &rule.max_average_duration {
+        let toml::Value::String(duration) = duration else {
+            return Err(
+                "max-average-duration takes a duration with its unit, e.g. \"2s\" or \"500ms\""
+                    .to_string(),
+            );
+        };
+        let duration =
+            parse_duration(duration).map_err(|err| format!("max-average-duration: {}", err))?;
+        constraints.push(Constraint::MaxAverageDuration(duration));
+    }
+    if constraints.is_empty() {
+        return Err("it has no constraint; give min-hit-rate, must-be-cacheable, \
+                    must-be-remotable or max-average-duration"
+            .to_string());
+    }
+
+    let name = match rule.name.as_deref().map(str::trim) {
+        Some(name) if !name.is_empty() => name.to_string(),
+        _ => [mnemonic.as_deref(), label.as_ref().map(|label| label.text.as_str())]
+            .into_iter()
+            .flatten()
+            .collect::<Vec<_>>()
+            .join(" "),
+    };
+    Ok(Rule {
+        name,
+        mnemonic,
+        label,
+        constraints,
+    })
+}
+
+/// How a constraint fared.
+#[derive(Clone, Copy, PartialEq, Eq)]
+enum Outcome {
+    Pass,
+    Fail,
+    /// The rule matched no spawns.
+    NotExercised,
+    /// The log does not record what the constraint measures.
+    NotRecorded,
+}
+
+impl Outcome {
+    fn as_str(self) -> &'static str {
+        match self {
+            Outcome::Pass => "PASS",
+            Outcome::Fail => "FAIL",
+            Outcome::NotExercised => "NOT EXERCISED",
+            Outcome::NotRecorded => "NOT RECORDED",
+        }
+    }
+}
+
+/// Whether anything in the log is cacheable and remotable: proto3 cannot tell an unset field
+/// from false, so a log where nothing is does not record the field at all.
+struct Recorded {
+    cacheable: bool,
+    remotable: bool,
+}
+
+fn limit(constraint: &Constraint) -> (&'static str, String) {
+    match constraint {
+        Constraint::MinHitRate(percent) => ("Cache hit rate", format!(">= {:.2}%", percent)),
+        Constraint::MustBeCacheable => ("Cacheable", "all".to_string()),
+        Constraint::MustBeRemotable => ("Remotable", "all".to_string()),
+        Constraint::MaxAverageDuration(duration) => {
+            ("Average duration", format!("<= {}", format_duration(*duration, 2)))
+        }
+    }
+}
+
+/// The measured value and the outcome of `constraint` over the spawns a rule matched, which
+/// are not empty.
+fn evaluate(
+    constraint: &Constraint,
+    spawns: &[&SpawnExec],
+    recorded: &Recorded,
+) -> (String, Outcome) {
+    let share = |count: usize| format!("{} of {}", count, spawns.len());
+    let outcome = |passed: bool| if passed { Outcome::Pass } else { Outcome::Fail };
+    match constraint {
+        Constraint::MinHitRate(min) => {
+            let hits = spawns.iter().filter(|s| is_cache_hit(s)).count();
+            let rate = hits as f64 / spawns.len() as f64 * 100.0;
+            (format!("{:.2}%", rate), outcome(rate >= *min))
+        }
+        Constraint::MustBeCacheable | Constraint::MustBeRemotable => {
+            let (recorded, flag): (bool, fn(&SpawnExec) -> bool) =
+                if *constraint == Constraint::MustBeCacheable {
+                    (recorded.cacheable, |s| s.cacheable)
+                } else {
+                    (recorded.remotable, |s| s.remotable)
+                };
+            if !recorded {
+                return ("n/a".to_string(), Outcome::NotRecorded);
+            }
+            let count = spawns.iter().filter(|s| flag(s)).count();
+            (share(count), outcome(count == spawns.len()))
+        }
+        Constraint::MaxAverageDuration(max) => {
+            let times: Vec<Duration> =
+                spawns.iter().filter_map(|s| recorded_total_time(s)).collect();
+            if times.is_empty() {
+                return ("n/a".to_string(), Outcome::NotRecorded);
+            }
+            let average = times.iter().sum::<Duration>() / times.len() as u32;
+            (format_duration(average, 2), outcome(average <= *max))
+        }
+    }
+}
+
+/// The matched actions that break a failed cacheability or remotability constraint.
+fn details(rule: &Rule, constraint: &Constraint, spawns: &[&SpawnExec]) -> Vec<String> {
+    let (what, flag): (&str, fn(&SpawnExec) -> bool) = match constraint {
+        Constraint::MustBeCacheable => ("not cacheable", |s| s.cacheable),
+        Constraint::MustBeRemotable => ("not remotable", |s| s.remotable),
+        _ => return Vec::new(),
+    };
+    let offending: Vec<&&SpawnExec> = spawns.iter().filter(|s| !flag(s)).collect();
+    let mut lines = vec![format!("Actions of '{}' {}:", rule.name, what)];
+    for spawn in offending.iter().take(MAX_ACTIONS_SHOWN) {
+        let (label, mnemonic, output) = action_key(spawn);
+        let label = if label.is_empty() { NO_LABEL } else { label };
+        lines.push(format!("  {} | {} | {}", mnemonic, label, output));
+    }
+    if offending.len() > MAX_ACTIONS_SHOWN {
+        lines.push(format!(
+            "... (+{} more actions)",
+            offending.len() - MAX_ACTIONS_SHOWN
+        ));
+    }
+    lines
+}
+
+pub fn run_policy(args: &Cli, policy: &PolicyArgs) -> AppResult<()> {
+    let rules = load_policy(&policy.policy)?;
+    let filter = SpawnFilter::from_cli(args);
+    let mut spawns = Vec::new();
+    for path in &policy.logs {
+        spawns.extend(load(path, &filter)?);
+    }
+    let recorded = Recorded {
+        cacheable: spawns.iter().any(|s| s.cacheable),
+        remotable: spawns.iter().any(|s| s.remotable),
+    };
+
+    println!("--- Policy Checks ---");
+    println!("Policy: {}", policy.policy.display());
+    for path in &policy.logs {
+        println!("Log: {}", path.display());
+    }
+    let mut table = Table::new(vec![
+        ("Rule".to_string(), Align::Left),
+        ("Spawns".to_string(), Align::Right),
+        ("Constraint".to_string(), Align::Left),
+        ("Measured".to_string(), Align::Right),
+        ("Limit".to_string(), Align::Right),
+        ("Result".to_string(), Align::Left),
+    ]);
+    let (mut checks, mut failed, mut not_exercised, mut not_recorded) = (0, 0, 0, 0);
+    let mut failure_details = Vec::new();
+    for rule in &rules {
+        let matched: Vec<&SpawnExec> = spawns.iter().filter(|s| rule.matches(s)).collect();
+        if matched.is_empty() {
+            not_exercised += 1;
+        }
+        for (index, constraint) in rule.constraints.iter().enumerate() {
+            checks += 1;
+            let (name, limit) = limit(constraint);
+            let (measured, outcome) = if matched.is_empty() {
+                ("-".to_string(), Outcome::NotExercised)
+            } else {
+                evaluate(constraint, &matched, &recorded)
+            };
+            match outcome {
+                Outcome::Fail => {
+                    failed += 1;
+                    failure_details.extend(details(rule, constraint, &matched));
+                }
+                Outcome::NotRecorded => not_recorded += 1,
+                Outcome::Pass | Outcome::NotExercised => {}
+            }
+            let (rule_name, count) = if index == 0 {
+                (rule.name.clone(), matched.len().to_string())
+            } else {
+                (String::new(), String::new())
+            };
+            table.add_row(vec![
+                rule_name,
+                count,
+                name.to_string(),
+                measured,
+                limit,
+                outcome.as_str().to_string(),
+            ]);
+        }
+    }
+    table.print();
+    for line in &failure_details {
+        println!("{}", line);
+    }
+    print!("{} of {} checks failed", failed, checks);
+    if not_exercised > 0 {
+        print!(
+            "; {} of {} rules matched no spawns",
+            not_exercised,
+            rules.len()
+        );
+    }
+    println!(".");
+    if not_recorded > 0 {
+        println!(
+            "Note: {} measure{} what the log does not record (cacheable or remotable on no spawn, or no spawn metrics), so {} not evaluated.",
+            if not_recorded == 1 {
+                "1 check".to_string()
+            } else {
+                format!("{} checks", not_recorded)
+            },
+            if not_recorded == 1 { "s" } else { "" },
+            if not_recorded == 1 { "it was" } else { "they were" }
+        );
+    }
+    println!();
+    if failed > 0 {
+        return Err(AppError::Gate(
+            Gate::Threshold,
+            format!("{} of {} policy checks failed", failed, checks),
+        ));
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::testing::spawn;
+
+    /// The rule of a `[[rule]]` table with `keys`, validated.
+    fn rule(keys: &str) -> Result<Rule, String> {
+        let file: PolicyFile = toml::from_str(&format!("[[rule]]\n{}", keys)).unwrap();
+        validate_rule(file.rule[0].get_ref())
+    }
+
+    fn evaluated(keys: &str, spawns: &[SpawnExec]) -> Vec<(String, &'static str)> {
+        let rule = rule(keys).unwrap();
+        let matched: Vec<&SpawnExec> = spawns.iter().filter(|s| rule.matches(s)).collect();
+        let recorded = Recorded {
+            cacheable: spawns.iter().any(|s| s.cacheable),
+            remotable: spawns.iter().any(|s| s.remotable),
+        };
+        rule.constraints
+            .iter()
+            .map(|constraint| {
+                let (measured, outcome) = evaluate(constraint, &matched, &recorded);
+                (measured, outcome.as_str())
+            })
+            .collect()
+    }
+
+    /// A Javac spawn of `target` that is cacheable and remotable unless it is a cache hit.
+    fn javac(target: &str, runner: &str, millis: u64) -> SpawnExec {
+        let miss = !runner.ends_with("cache hit");
+        SpawnExec {
+            target_label: target.to_string(),
+            cacheable: miss,
+            remotable: miss,
+            ..spawn("Javac", runner, millis)
+        }
+    }
+
+    fn build() -> Vec<SpawnExec> {
+        vec![
+            javac("//app:a", "remote cache hit", 100),
+            javac("//app:b", "remote cache hit", 100),
+            javac("//app:c", "remote cache hit", 100),
+            javac("//release:d", "linux-sandbox", 2_900),
+            spawn("GoCompilePkg", "remote", 1_000),
+        ]
+    }
+
+    #[test]
+    fn min_hit_rate_compares_the_share_of_cache_hits() {
+        let spawns = build();
+        assert_eq!(
+            evaluated("mnemonic = 'Javac'\nmin-hit-rate = 75", &spawns),
+            [("75.00%".to_string(), "PASS")]
+        );
+        assert_eq!(
+            evaluated("mnemonic = 'Javac'\nmin-hit-rate = '75.5%'", &spawns),
+            [("75.00%".to_string(), "FAIL")]
+        );
+        assert_eq!(
+            rule("mnemonic = 'Javac'\nmin-hit-rate = 0.5")
+                .unwrap()
+                .constraints,
+            [Constraint::MinHitRate(0.5)]
+        );
+        for (value, error) in [
+            ("101", "min-hit-rate must be between 0 and 100%, not 101"),
+            ("true", "min-hit-rate takes a percentage"),
+            ("'most'", "min-hit-rate: "),
+        ] {
+            let message =
+                rule(&format!("mnemonic = 'Javac'\nmin-hit-rate = {}", value)).unwrap_err();
+            assert!(message.starts_with(error), "{}: {}", value, message);
+        }
+    }
+
+    #[test]
+    fn must_be_cacheable_needs_every_matched_spawn_cacheable() {
+        let spawns = build();
+        assert_eq!(
+            evaluated("label = '//release/...'\nmust-be-cacheable = true", &spawns),
+            [("1 of 1".to_string(), "PASS")]
+        );
+        assert_eq!(
+            evaluated("label = '//app/...'\nmust-be-cacheable = true", &spawns),
+            [("0 of 3".to_string(), "FAIL")]
+        );
+        let error = rule("label = '//app/...'\nmust-be-cacheable = false").unwrap_err();
+        assert_eq!(
+            error,
+            "must-be-cacheable = false requires nothing; leave the key out instead"
+        );
+    }
+
+    #[test]
+    fn must_be_remotable_needs_every_matched_spawn_remotable() {
+        let mut spawns = build();
+        assert_eq!(
+            evaluated("mnemonic = 'Javac'\nmust-be-remotable = true", &spawns),
+            [("1 of 4".to_string(), "FAIL")]
+        );
+        // Without a remotable spawn in the log the field was not recorded.
+        spawns.iter_mut().for_each(|spawn| spawn.remotable = false);
+        assert_eq!(
+            evaluated("mnemonic = 'Javac'\nmust-be-remotable = true", &spawns),
+            [("n/a".to_string(), "NOT RECORDED")]
+        );
+    }
+
+    #[test]
+    fn max_average_duration_compares_the_mean_total_time() {
+        let mut spawns = build();
+        assert_eq!(
+            evaluated("mnemonic = 'Jav?c'\nmax-average-duration = '0.8s'", &spawns),
+            [("0.80s".to_string(), "PASS")]
+        );
+        assert_eq!(
+            evaluated("mnemonic = '*'\nmax-average-duration = '800ms'", &spawns),
+            [("0.84s".to_string(), "FAIL")]
+        );
+        spawns.iter_mut().for_each(|spawn| spawn.metrics = None);
+        assert_eq!(
+            evaluated("mnemonic = '*'\nmax-average-duration = '800ms'", &spawns),
+            [("n/a".to_string(), "NOT RECORDED")]
+        );
+        let error = rule("mnemonic = '*'\nmax-average-duration = 2").unwrap_err();
+        assert!(error.starts_with("max-average-duration takes a duration with its unit"));
+        let error = rule("mnemonic = '*'\nmax-average-duration = 'a while'").unwrap_err();
+        assert!(error.starts_with("max-average-duration: "), "{}", error);
+    }
+
+    #[test]
+    fn rules_need_a_selector_and_a_constraint() {
+        let both = rule(
+            "mnemonic = 'Javac'
+            label = '//app/...'
+            must-be-cacheable = true
+            min-hit-rate = 90
+            max-average-duration = '1s'",
+        )
+        .unwrap();
+        assert_eq!(both.name, "Javac //app/...");
+        assert_eq!(
+            both.constraints,
+            [
+                Constraint::MinHitRate(90.0),
+                Constraint::MustBeCacheable,
+                Constraint::MaxAverageDuration(Duration::from_secs(1)),
+            ]
+        );
+        assert_eq!(
+            rule("name = ' Go '\nmnemonic = 'Go*'\nmin-hit-rate = 1")
+                .unwrap()
+                .name,
+            "Go"
+        );
+
+        let error = rule("min-hit-rate = 90").unwrap_err();
+        assert!(error.starts_with("it selects no spawns"), "{}", error);
+        let error = rule("mnemonic = ' '\nmin-hit-rate = 90").unwrap_err();
+        assert_eq!(error, "the mnemonic pattern is empty");
+        let error = rule("label = 'app'\nmin-hit-rate = 90").unwrap_err();
+        assert!(
+            error.starts_with("'app' is not a target pattern"),
+            "{}",
+            error
+        );
+        let error = rule("mnemonic = 'Javac'").unwrap_err();
+        assert!(error.starts_with("it has no constraint"), "{}", error);
+    }
+}
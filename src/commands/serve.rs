@@ -0,0 +1,555 @@
+//! Serves an interactive report and its data over HTTP from the logs parsed once at startup.
+//!
+//! The server is deliberately small: one request per connection, each on its own thread, GET
+//! only. It binds to localhost unless another host is given, as the logs name internal targets
+//! and command lines, and answers only requests addressed to that host, so that a web page
+//! cannot reach it through a DNS name pointed at 127.0.0.1 (DNS rebinding).
+
+use crate::analysis::{action_key, LogTotals};
+use crate::classify::{is_cache_hit, is_failed};
+use crate::cli::Cli;
+use crate::commands::analyze::load_spawns;
+use crate::filter::SpawnFilter;
+use crate::metrics::total_time;
+use crate::proto::SpawnExec;
+use crate::report::highest_first;
+use crate::reports::ci_summary::CiSummary;
+use crate::reports::json::TotalsJson;
+use crate::schema::SCHEMA_VERSION;
+use crate::{AppError, AppResult};
+use serde::Serialize;
+use signal_hook::consts::SIGINT;
+use std::collections::HashMap;
+use std::io::{self, Read, Write};
+use std::net::{IpAddr, SocketAddr, TcpListener, TcpStream};
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::Arc;
+use std::thread;
+use std::time::Duration;
+
+/// Spawns per /api/spawns page unless `limit` says otherwise, and the most it may ask for.
+const DEFAULT_PAGE: usize = 100;
+const MAX_PAGE: usize = 1000;
+
+/// Requests with longer headers are rejected.
+const MAX_REQUEST_BYTES: usize = 16 * 1024;
+
+/// How long a client may take to send its request.
+const READ_TIMEOUT: Duration = Duration::from_secs(5);
+
+/// How often the accept loop checks for Ctrl-C.
+const INTERRUPT_POLL: Duration = Duration::from_millis(100);
+
+/// The page fetches everything it shows from the /api endpoints.
+const PAGE: &str = r#"<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Bazel execution log</title>
+<style>
+body { font-family: sans-serif; margin: 1.5em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { padding: 2px 8px; border-bottom: 1px solid #ddd; text-align: left; }
+td.n { text-align: right; }
+</style>
+</head>
+<body>
+<h3>Bazel execution log</h3>
+<p id="summary">Loading...</p>
+<h4>Mnemonics by total time</h4>
+<table id="mnemonics"></table>
+<h4>Spawns by total time</h4>
+<p>
+<select id="mnemonic"><option value="">all mnemonics</option></select>
+<select id="cacheable"><option value="">cacheable or not</option><option value="cacheable_only">cacheable only</option><option value="uncacheable_only">uncacheable only</option></select>
+<select id="remotable"><option value="">remotable or not</option><option value="remotable_only">remotable only</option><option value="unremotable_only">unremotable only</option></select>
+<label><input type="checkbox" id="exclude_failed"> exclude failed</label>
+<button id="prev">&lt; previous</button> <span id="page"></span> <button id="next">next &gt;</button>
+</p>
+<table id="spawns"></table>
+<script>
+const limit = 100;
+let offset = 0;
+const seconds = nanos => (nanos / 1e9).toFixed(2) + "s";
+const cell = (text, numeric) => {
+  const td = document.createElement("td");
+  td.textContent = text;
+  if (numeric) td.className = "n";
+  return td;
+};
+function fill(table, header, rows) {
+  table.replaceChildren();
+  const head = table.insertRow();
+  header.forEach(name => { const th = document.createElement("th"); th.textContent = name; head.appendChild(th); });
+  rows.forEach(row => { const tr = table.insertRow(); row.forEach(([text, numeric]) => tr.appendChild(cell(text, numeric))); });
+}
+async function get(path) {
+  const response = await fetch(path);
+  const body = await response.json();
+  if (!response.ok) throw new Error(body.error);
+  return body;
+}
+async function loadSpawns() {
+  const params = new URLSearchParams({ limit, offset });
+  const mnemonic = document.getElementById("mnemonic").value;
+  if (mnemonic) params.set("mnemonic", mnemonic);
+  ["cacheable", "remotable"].forEach(id => { const flag = document.getElementById(id).value; if (flag) params.set(flag, "true"); });
+  if (document.getElementById("exclude_failed").checked) params.set("exclude_failed", "true");
+  const page = await get("/api/spawns?" + params);
+  document.getElementById("page").textContent = page.total ? `${offset + 1}-${offset + page.spawns.length} of ${page.total}` : "no spawns";
+  fill(document.getElementById("spawns"), ["Time", "Mnemonic", "Label", "Runner", "Exit", "Output"],
+    page.spawns.map(s => [[seconds(s.total_time_nanos), true], [s.mnemonic], [s.label], [s.runner], [s.exit_code, true], [s.output]]));
+  document.getElementById("prev").disabled = offset === 0;
+  document.getElementById("next").disabled = offset + limit >= page.total;
+}
+async function load() {
+  const summary = await get("/api/summary");
+  document.getElementById("summary").textContent =
+    `${summary.actions} actions, ${(summary.hit_rate * 100).toFixed(2)}% cache hits, ${summary.spawn_seconds.toFixed(2)}s spawn time, ${summary.failed} failed (${summary.logs.join(", ")})`;
+  const mnemonics = (await get("/api/mnemonics")).mnemonics;
+  fill(document.getElementById("mnemonics"), ["Mnemonic", "Actions", "Hit Rate", "Total Time"],
+    mnemonics.map(m => [[m.mnemonic], [m.actions, true], [m.cache_hit_rate_percent.toFixed(1) + "%", true], [seconds(m.total_time_nanos), true]]));
+  const select = document.getElementById("mnemonic");
+  mnemonics.forEach(m => select.add(new Option(m.mnemonic, m.mnemonic)));
+  ["mnemonic", "cacheable", "remotable", "exclude_failed"].forEach(id =>
+    document.getElementById(id).addEventListener("change", () => { offset = 0; loadSpawns(); }));
+  document.getElementById("prev").onclick = () => { offset = Math.max(0, offset - limit); loadSpawns(); };
+  document.getElementById("next").onclick = () => { offset += limit; loadSpawns(); };
+  await loadSpawns();
+}
+load().catch(err => { document.getElementById("summary").textContent = "Error: " + err.message; });
+</script>
+</body>
+</html>
+"#;
+
+/// The parsed logs everything is served from.
+struct Report {
+    logs: Vec<String>,
+    spawns: Vec<SpawnExec>,
+    /// Mnemonics by total time, as /api/mnemonics lists them.
+    mnemonics: Vec<(String, LogTotals)>,
+}
+
+impl Report {
+    fn new(logs: Vec<String>, spawns: Vec<SpawnExec>) -> Self {
+        let mut by_mnemonic: HashMap<String, LogTotals> = HashMap::new();
+        for spawn in &spawns {
+            by_mnemonic
+                .entry(spawn.mnemonic.clone())
+                .or_default()
+                .add(spawn);
+        }
+        let mut mnemonics: Vec<_> = by_mnemonic.into_iter().collect();
+        mnemonics.sort_by(|(a_name, a), (b_name, b)| {
+            b.total_time
+                .cmp(&a.total_time)
+                .then_with(|| a_name.cmp(b_name))
+        });
+        Report {
+            logs,
+            spawns,
+            mnemonics,
+        }
+    }
+}
+
+#[derive(Serialize)]
+pub struct SummaryJson<'a> {
+    pub schema_version: u32,
+    pub logs: &'a [String],
+    #[serde(flatten)]
+    pub summary: CiSummary,
+}
+
+#[derive(Serialize)]
+pub struct MnemonicJson<'a> {
+    pub mnemonic: &'a str,
+    #[serde(flatten)]
+    pub totals: TotalsJson,
+}
+
+#[derive(Serialize)]
+pub struct MnemonicsJson<'a> {
+    pub schema_version: u32,
+    pub mnemonics: Vec<MnemonicJson<'a>>,
+}
+
+#[derive(Serialize)]
+pub struct SpawnJson<'a> {
+    pub label: &'a str,
+    pub mnemonic: &'a str,
+    pub output: &'a str,
+    pub runner: &'a str,
+    pub cache_hit: bool,
+    pub failed: bool,
+    pub exit_code: i32,
+    pub cacheable: bool,
+    pub remotable: bool,
+    pub total_time_nanos: u64,
+}
+
+#[derive(Serialize)]
+pub struct SpawnsJson<'a> {
+    pub schema_version: u32,
+    /// Spawns matching the query, of which this page lists `limit` from `offset`.
+    pub total: usize,
+    pub offset: usize,
+    pub limit: usize,
+    pub spawns: Vec<SpawnJson<'a>>,
+}
+
+#[derive(Serialize)]
+struct ErrorJson {
+    error: String,
+}
+
+/// Decodes `%XX` escapes and `+` in a query string component.
+fn percent_decode(value: &str) -> String {
+    let bytes = value.as_bytes();
+    let mut decoded = Vec::with_capacity(bytes.len());
+    let mut i = 0;
+    while i < bytes.len() {
+        match bytes[i] {
+            b'+' => decoded.push(b' '),
+            b'%' if i + 2 < bytes.len() => {
+                let hex = std::str::from_utf8(&bytes[i + 1..i + 3]).ok();
+                match hex.and_then(|hex| u8::from_str_radix(hex, 16).ok()) {
+                    Some(byte) => {
+                        decoded.push(byte);
+                        i += 2;
+                    }
+                    None => decoded.push(b'%'),
+                }
+            }
+            byte => decoded.push(byte),
+        }
+        i += 1;
+    }
+    String::from_utf8_lossy(&decoded).into_owned()
+}
+
+fn query_params(query: &str) -> HashMap<String, String> {
+    query
+        .split('&')
+        .filter(|pair| !pair.is_empty())
+        .map(|pair| {
+            let (key, value) = pair.split_once('=').unwrap_or((pair, ""));
+            (percent_decode(key), percent_decode(value))
+        })
+        .collect()
+}
+
+/// A boolean parameter: absent is false, present without a value or as true/1 is true.
+fn flag(params: &HashMap<String, String>, name: &str) -> Result<bool, String> {
+    match params.get(name).map(String::as_str) {
+        None | Some("false") | Some("0") => Ok(false),
+        Some("") | Some("true") | Some("1") => Ok(true),
+        Some(other) => Err(format!("invalid value '{}' for {}", other, name)),
+    }
+}
+
+fn number(params: &HashMap<String, String>, name: &str, default: usize) -> Result<usize, String> {
+    match params.get(name) {
+        None => Ok(default),
+        Some(value) => value
+            .parse()
+            .map_err(|_| format!("invalid value '{}' for {}", value, name)),
+    }
+}
+
+/// /api/spawns: the matching spawns by total time, a page at a time.
+fn spawns_page(report: &Report, query: &str) -> Result<String, String> {
+    let params = query_params(query);
+    let cacheable = (
+        flag(&params, "cacheable_only")?,
+        flag(&params, "uncacheable_only")?,
+    );
+    let remotable = (
+        flag(&params, "remotable_only")?,
+        flag(&params, "unremotable_only")?,
+    );
+    if cacheable == (true, true) || remotable == (true, true) {
+        return Err("cacheable_only and remotable_only exclude their opposites".to_string());
+    }
+    let filter = SpawnFilter::from_flags(cacheable, remotable, flag(&params, "exclude_failed")?);
+    let limit = number(&params, "limit", DEFAULT_PAGE)?.min(MAX_PAGE);
+    let offset = number(&params, "offset", 0)?;
+
+    let mut spawns = filter.select(&report.spawns);
+    if let Some(mnemonic) = params.get("mnemonic") {
+        spawns.retain(|s| &s.mnemonic == mnemonic);
+    }
+    spawns.sort_by(|a, b| highest_first(a, b, total_time));
+    let page = SpawnsJson {
+        schema_version: SCHEMA_VERSION,
+        total: spawns.len(),
+        offset,
+        limit,
+        spawns: spawns
+            .iter()
+            .skip(offset)
+            .take(limit)
+            .map(|spawn| {
+                let (label, mnemonic, output) = action_key(spawn);
+                SpawnJson {
+                    label,
+                    mnemonic,
+                    output,
+                    runner: &spawn.runner,
+                    cache_hit: is_cache_hit(spawn),
+                    failed: is_failed(spawn),
+                    exit_code: spawn.exit_code,
+                    cacheable: spawn.cacheable,
+                    remotable: spawn.remotable,
+                    total_time_nanos: total_time(spawn).as_nanos() as u64,
+                }
+            })
+            .collect(),
+    };
+    serde_json::to_string(&page).map_err(|err| err.to_string())
+}
+
+/// Answers a GET of `target` with a status, content type and body.
+fn respond(report: &Report, target: &str) -> (u16, &'static str, String) {
+    const JSON: &str = "application/json";
+    let (path, query) = target.split_once('?').unwrap_or((target, ""));
+    let json = |document: Result<String, String>| match document {
+        Ok(body) => (200, JSON, body),
+        Err(error) => (
+            400,
+            JSON,
+            serde_json::to_string(&ErrorJson { error }).unwrap_or_default(),
+        ),
+    };
+    match path {
+        "/" => (200, "text/html; charset=utf-8", PAGE.to_string()),
+        "/api/summary" => json(
+            serde_json::to_string(&SummaryJson {
+                schema_version: SCHEMA_VERSION,
+                logs: &report.logs,
+                summary: CiSummary::from_spawns(&report.spawns),
+            })
+            .map_err(|err| err.to_string()),
+        ),
+        "/api/mnemonics" => json(
+            serde_json::to_string(&MnemonicsJson {
+                schema_version: SCHEMA_VERSION,
+                mnemonics: report
+                    .mnemonics
+                    .iter()
+                    .map(|(mnemonic, totals)| MnemonicJson {
+                        mnemonic,
+                        totals: TotalsJson::from(totals),
+                    })
+                    .collect(),
+            })
+            .map_err(|err| err.to_string()),
+        ),
+        "/api/spawns" => json(spawns_page(report, query)),
+        _ => (
+            404,
+            JSON,
+            serde_json::to_string(&ErrorJson {
+                error: format!("no such page: {}", path),
+            })
+            .unwrap_or_default(),
+        ),
+    }
+}
+
+fn reason(status: u16) -> &'static str {
+    match status {
+        200 => "OK",
+        400 => "Bad Request",
+        403 => "Forbidden",
+        404 => "Not Found",
+        405 => "Method Not Allowed",
+        _ => "Error",
+    }
+}
+
+/// Reads the request head, up to the blank line that ends it.
+fn read_request(stream: &mut TcpStream) -> io::Result<String> {
+    let mut request = Vec::new();
+    let mut chunk = [0; 1024];
+    while !request.windows(4).any(|w| w == b"\r\n\r\n") {
+        let read = stream.read(&mut chunk)?;
+        if read == 0 || request.len() > MAX_REQUEST_BYTES {
+            break;
+        }
+        request.extend_from_slice(&chunk[..read]);
+    }
+    Ok(String::from_utf8_lossy(&request).into_owned())
+}
+
+/// The value of the request's `Host` header, if it has one.
+fn host_header(request: &str) -> Option<&str> {
+    request.lines().skip(1).find_map(|line| {
+        let (name, value) = line.split_once(':')?;
+        name.trim().eq_ignore_ascii_case("host").then(|| value.trim())
+    })
+}
+
+/// The host names requests must be addressed to.
+struct AllowedHosts {
+    /// `localhost`, and the host of --serve when it names one.
+    names: Vec<String>,
+    /// The address bound to, and any loopback address while bound to one.
+    address: IpAddr,
+}
+
+impl AllowedHosts {
+    fn new(serve: &str, bound: SocketAddr) -> Self {
+        let mut names = vec!["localhost".to_string()];
+        let host = serve.rsplit_once(':').map_or(serve, |(host, _)| host);
+        let host = host.trim_start_matches('[').trim_end_matches(']');
+        if !host.is_empty() && host.parse::<IpAddr>().is_err() {
+            names.push(host.to_ascii_lowercase());
+        }
+        AllowedHosts {
+            names,
+            address: bound.ip(),
+        }
+    }
+
+    /// Whether `host`, a `Host` header with or without its port, names this server. A server
+    /// bound to all interfaces takes any IP address, which no DNS name can stand for.
+    fn allows(&self, host: &str) -> bool {
+        let name = match host.strip_prefix('[') {
+            Some(bracketed) => bracketed.split(']').next().unwrap_or(""),
+            None => host.rsplit_once(':').map_or(host, |(name, _)| name),
+        };
+        match name.parse::<IpAddr>() {
+            Ok(ip) => {
+                ip == self.address
+                    || self.address.is_unspecified()
+                    || (self.address.is_loopback() && ip.is_loopback())
+            }
+            Err(_) => self.names.iter().any(|allowed| allowed.eq_ignore_ascii_case(name)),
+        }
+    }
+}
+
+fn handle(report: &Report, hosts: &AllowedHosts, mut stream: TcpStream) -> io::Result<()> {
+    stream.set_nonblocking(false)?;
+    stream.set_read_timeout(Some(READ_TIMEOUT))?;
+    let request = read_request(&mut stream)?;
+    let mut words = request.lines().next().unwrap_or("").split_whitespace();
+    let (status, content_type, body) = match (words.next(), words.next()) {
+        _ if !host_header(&request).is_some_and(|host| hosts.allows(host)) => (
+            403,
+            "text/plain",
+            "The Host header does not name this server.\n".to_string(),
+        ),
+        (Some("GET"), Some(target)) => respond(report, target),
+        _ => (
+            405,
+            "text/plain",
+            "Only GET requests are served.\n".to_string(),
+        ),
+    };
+    write!(
+        stream,
+        "HTTP/1.1 {} {}\r\nContent-Type: {}\r\nContent-Length: {}\r\nConnection: close\r\n\r\n",
+        status,
+        reason(status),
+        content_type,
+        body.len()
+    )?;
+    stream.write_all(body.as_bytes())?;
+    stream.flush()
+}
+
+/// Expands `:8080` to a localhost address; anything else is bound as given.
+fn listen_address(value: &str) -> String {
+    match value.strip_prefix(':') {
+        Some(port) => format!("127.0.0.1:{}", port),
+        None => value.to_string(),
+    }
+}
+
+pub fn run_serve(args: &Cli, address: &str) -> AppResult<()> {
+    let spawns = load_spawns(args)?;
+    let names = args
+        .log_files()
+        .iter()
+        .map(|p| p.display().to_string())
+        .collect();
+    let report = Report::new(names, spawns);
+
+    let listener = TcpListener::bind(listen_address(address))
+        .map_err(|err| AppError::Analysis(format!("cannot listen on {}: {}", address, err)))?;
+    listener.set_nonblocking(true)?;
+    let hosts = AllowedHosts::new(address, listener.local_addr()?);
+    let interrupted = Arc::new(AtomicBool::new(false));
+    signal_hook::flag::register(SIGINT, Arc::clone(&interrupted))?;
+    println!(
+        "Serving {} spawns on http://{}/ (Ctrl-C to stop)",
+        report.spawns.len(),
+        listener.local_addr()?
+    );
+
+    // A slow or idle client holds up only its own thread. The requests in flight at Ctrl-C
+    // are answered before the scope returns.
+    thread::scope(|scope| {
+        while !interrupted.load(Ordering::Relaxed) {
+            match listener.accept() {
+                Ok((stream, _)) => {
+                    let (report, hosts) = (&report, &hosts);
+                    scope.spawn(move || {
+                        if let Err(err) = handle(report, hosts, stream) {
+                            eprintln!("Request failed: {}", err);
+                        }
+                    });
+                }
+                Err(err) if err.kind() == io::ErrorKind::WouldBlock => {
+                    thread::sleep(INTERRUPT_POLL)
+                }
+                Err(err) => return Err(err.into()),
+            }
+        }
+        println!("Stopped serving.");
+        Ok(())
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn hosts(serve: &str, bound: &str) -> AllowedHosts {
+        AllowedHosts::new(serve, bound.parse().unwrap())
+    }
+
+    #[test]
+    fn the_host_header_is_found_in_any_case() {
+        let request = "GET / HTTP/1.1\r\nAccept: */*\r\nhOsT: localhost:8080\r\n\r\n";
+        assert_eq!(host_header(request), Some("localhost:8080"));
+        assert_eq!(host_header("GET / HTTP/1.0\r\n\r\n"), None);
+    }
+
+    #[test]
+    fn a_localhost_server_answers_only_local_names() {
+        let hosts = hosts(":8080", "127.0.0.1:8080");
+        for host in ["localhost:8080", "LOCALHOST", "127.0.0.1:8080", "[::1]:8080"] {
+            assert!(hosts.allows(host), "{}", host);
+        }
+        for host in ["attacker.example:8080", "localhost.attacker.example", "10.0.0.7:8080", ""] {
+            assert!(!hosts.allows(host), "{}", host);
+        }
+    }
+
+    #[test]
+    fn a_named_or_public_address_is_answered_by_that_name() {
+        let named = hosts("buildbox:8080", "10.0.0.7:8080");
+        assert!(named.allows("buildbox:8080"));
+        assert!(named.allows("10.0.0.7:8080"));
+        assert!(!named.allows("127.0.0.1:8080"));
+        assert!(!named.allows("attacker.example"));
+
+        let everywhere = hosts("0.0.0.0:8080", "0.0.0.0:8080");
+        assert!(everywhere.allows("192.168.1.20:8080"));
+        assert!(!everywhere.allows("attacker.example:8080"));
+    }
+}
@@ -0,0 +1,282 @@
+//! --split-by-mnemonic: one report file per mnemonic, so each team can be sent just the actions
+//! it owns, plus an index with the overall summary.
+
+use crate::classify::{is_cache_hit, runner_label};
+use crate::cli::Cli;
+use crate::format::{format_bytes, format_duration, Align, Table};
+use crate::metrics::{recorded_total_time, total_time};
+use crate::proto::SpawnExec;
+use crate::report::sorted_actions;
+use crate::reports::cache::{downloaded_bytes, time_weighted_hit_rate};
+use crate::stats::DurationPercentiles;
+use crate::style;
+use crate::AppResult;
+use std::collections::{HashMap, HashSet};
+use std::fmt::Write as _;
+use std::fs;
+use std::path::Path;
+use std::time::Duration;
+
+/// File the mnemonics below --split-min-time share.
+const MISC: &str = "misc";
+const INDEX_FILE: &str = "index.txt";
+
+/// One report file: a mnemonic, or the small ones grouped together.
+struct Part<'a> {
+    title: String,
+    file: String,
+    spawns: Vec<&'a SpawnExec>,
+}
+
+/// A mnemonic as a file name: letters, digits, `-`, `_` and `.` are kept, anything else becomes
+/// `_`. Names taken already, e.g. by `Foo/Bar` and `Foo:Bar`, get a number appended.
+fn file_name(name: &str, taken: &mut HashSet<String>) -> String {
+    let mut stem: String = name
+        .chars()
+        .map(|c| {
+            if c.is_ascii_alphanumeric() || matches!(c, '-' | '_' | '.') {
+                c
+            } else {
+                '_'
+            }
+        })
+        .collect();
+    if stem.is_empty() || stem.starts_with('.') {
+        stem.insert(0, '_');
+    }
+    let mut file = format!("{}.txt", stem);
+    let mut suffix = 2;
+    while file == INDEX_FILE || !taken.insert(file.to_ascii_lowercase()) {
+        file = format!("{}-{}.txt", stem, suffix);
+        suffix += 1;
+    }
+    file
+}
+
+fn total(spawns: &[&SpawnExec]) -> Duration {
+    spawns.iter().map(|s| total_time(s)).sum()
+}
+
+/// The summary lines shared by the index and each part.
+fn write_summary(report: &mut String, spawns: &[&SpawnExec]) {
+    let hits = spawns.iter().filter(|s| is_cache_hit(s)).count();
+    let _ = writeln!(report, "Total Actions: {}", spawns.len());
+    let _ = writeln!(
+        report,
+        "Cache Hits: {} ({:.2}%)",
+        hits,
+        hits as f64 / spawns.len().max(1) as f64 * 100.0
+    );
+    if let Some(rate) = time_weighted_hit_rate(spawns.iter().copied()) {
+        let _ = writeln!(report, "Time-Weighted Hit Rate: {:.2}%", rate);
+    }
+    let _ = writeln!(
+        report,
+        "Total Spawn Time: {}",
+        format_duration(total(spawns), 2)
+    );
+    let mut durations: Vec<Duration> = spawns
+        .iter()
+        .filter_map(|s| recorded_total_time(s))
+        .collect();
+    if let Some(p) = DurationPercentiles::compute(&mut durations) {
+        let _ = writeln!(
+            report,
+            "Action Durations: p50 {} | p90 {} | p99 {} | max {}",
+            format_duration(p.p50, 3),
+            format_duration(p.p90, 3),
+            format_duration(p.p99, 3),
+            format_duration(p.max, 3)
+        );
+    }
+    let _ = writeln!(
+        report,
+        "Data Downloaded: {} (outputs of remote cache hits)",
+        format_bytes(downloaded_bytes(spawns.iter().copied()))
+    );
+}
+
+fn write_banner(report: &mut String, title: &str, args: &Cli) {
+    let names: Vec<String> = args
+        .log_files()
+        .iter()
+        .map(|f| f.display().to_string())
+        .collect();
+    let _ = writeln!(report, "========================================");
+    let _ = writeln!(report, " {}", title);
+    let _ = writeln!(report, "========================================");
+    let _ = writeln!(report, "Log files: {}\n", names.join(", "));
+}
+
+fn part_report(part: &Part, args: &Cli) -> String {
+    let mut report = String::new();
+    write_banner(
+        &mut report,
+        &format!("Bazel Execution Log Report: {}", part.title),
+        args,
+    );
+    let _ = writeln!(report, "--- Summary ---");
+    write_summary(&mut report, &part.spawns);
+
+    let _ = writeln!(report, "\n--- Cache Results by Runner ---");
+    let mut runners: HashMap<&str, (usize, Duration)> = HashMap::new();
+    for spawn in &part.spawns {
+        let entry = runners.entry(runner_label(spawn)).or_default();
+        entry.0 += 1;
+        entry.1 += total_time(spawn);
+    }
+    let mut runners: Vec<_> = runners.into_iter().collect();
+    runners.sort_by(|(a, (a_count, _)), (b, (b_count, _))| b_count.cmp(a_count).then(a.cmp(b)));
+    let mut table = Table::new(vec![
+        ("Runner".to_string(), Align::Left),
+        ("Actions".to_string(), Align::Right),
+        ("Share".to_string(), Align::Right),
+        ("Total Time".to_string(), Align::Right),
+    ]);
+    for (runner, (count, time)) in runners {
+        table.add_row(vec![
+            runner.to_string(),
+            count.to_string(),
+            format!("{:.1}%", count as f64 / part.spawns.len() as f64 * 100.0),
+            format_duration(time, 2),
+        ]);
+    }
+    for line in table.lines() {
+        let _ = writeln!(report, "{}", line);
+    }
+
+    let slowest = sorted_actions(part.spawns.iter().copied(), args.sort_by);
+    if args.top_n == usize::MAX {
+        let _ = writeln!(
+            report,
+            "\n--- All {} {} ---",
+            slowest.len(),
+            args.sort_by.ranking()
+        );
+    } else {
+        let _ = writeln!(
+            report,
+            "\n--- Top {} {} ---",
+            args.top_n,
+            args.sort_by.title()
+        );
+    }
+    let mut table = Table::new(vec![
+        ("Time".to_string(), Align::Right),
+        ("Mnemonic".to_string(), Align::Left),
+        ("Target".to_string(), Align::Left),
+    ]);
+    for spawn in slowest.iter().take(args.top_n) {
+        table.add_row(vec![
+            format_duration(total_time(spawn), 3),
+            spawn.mnemonic.clone(),
+            spawn.target_label.clone(),
+        ]);
+    }
+    for line in table.lines() {
+        let _ = writeln!(report, "{}", line);
+    }
+    report
+}
+
+fn index_report(spawns: &[SpawnExec], parts: &[Part], args: &Cli) -> String {
+    let mut report = String::new();
+    write_banner(&mut report, "Bazel Execution Log Analysis Report", args);
+    let _ = writeln!(report, "--- Overall Summary ---");
+    write_summary(&mut report, &spawns.iter().collect::<Vec<_>>());
+
+    let _ = writeln!(report, "\n--- Reports by Mnemonic ---");
+    let mut table = Table::new(vec![
+        ("Mnemonic".to_string(), Align::Left),
+        ("Actions".to_string(), Align::Right),
+        ("Hits".to_string(), Align::Right),
+        ("Total Time".to_string(), Align::Right),
+        ("File".to_string(), Align::Left),
+    ]);
+    for part in parts {
+        let hits = part.spawns.iter().filter(|s| is_cache_hit(s)).count();
+        table.add_row(vec![
+            part.title.clone(),
+            part.spawns.len().to_string(),
+            format!("{:.1}%", hits as f64 / part.spawns.len() as f64 * 100.0),
+            format_duration(total(&part.spawns), 2),
+            part.file.clone(),
+        ]);
+    }
+    for line in table.lines() {
+        let _ = writeln!(report, "{}", line);
+    }
+    report
+}
+
+/// Writes a report for each mnemonic taking at least --split-min-time, one for the rest, and
+/// the index, to --output-dir.
+pub fn run_split(spawns: &[SpawnExec], args: &Cli, dir: &Path) -> AppResult<()> {
+    // Files are read later, not on this terminal.
+    style::disable();
+
+    let mut by_mnemonic: HashMap<&str, Vec<&SpawnExec>> = HashMap::new();
+    for spawn in spawns {
+        by_mnemonic
+            .entry(spawn.mnemonic.as_str())
+            .or_default()
+            .push(spawn);
+    }
+    let mut mnemonics: Vec<(&str, Vec<&SpawnExec>)> = by_mnemonic.into_iter().collect();
+    mnemonics.sort_by(|a, b| total(&b.1).cmp(&total(&a.1)).then(a.0.cmp(b.0)));
+
+    let mut taken = HashSet::new();
+    let mut parts = Vec::new();
+    let mut small: Vec<(&str, Vec<&SpawnExec>)> = Vec::new();
+    for (mnemonic, group) in mnemonics {
+        if total(&group) < args.split_min_time {
+            small.push((mnemonic, group));
+            continue;
+        }
+        parts.push(Part {
+            title: mnemonic.to_string(),
+            file: file_name(mnemonic, &mut taken),
+            spawns: group,
+        });
+    }
+    // A lone small mnemonic keeps a file of its own.
+    if small.len() == 1 {
+        let (mnemonic, group) = small.pop().unwrap();
+        parts.push(Part {
+            title: mnemonic.to_string(),
+            file: file_name(mnemonic, &mut taken),
+            spawns: group,
+        });
+    }
+    let grouped = small.len();
+    let misc = (grouped > 0).then(|| file_name(MISC, &mut taken));
+    if let Some(file) = &misc {
+        parts.push(Part {
+            title: format!(
+                "{} ({} mnemonics below {})",
+                MISC,
+                grouped,
+                format_duration(args.split_min_time, 2)
+            ),
+            file: file.clone(),
+            spawns: small.into_iter().flat_map(|(_, group)| group).collect(),
+        });
+    }
+
+    fs::create_dir_all(dir)?;
+    for part in &parts {
+        fs::write(dir.join(&part.file), part_report(part, args))?;
+    }
+    fs::write(dir.join(INDEX_FILE), index_report(spawns, &parts, args))?;
+    print!(
+        "Wrote {} reports and {} to {}",
+        parts.len(),
+        INDEX_FILE,
+        dir.display()
+    );
+    match &misc {
+        Some(file) => println!(" ({} mnemonics grouped into {})", grouped, file),
+        None => println!(),
+    }
+    Ok(())
+}
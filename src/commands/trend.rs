@@ -0,0 +1,322 @@
+//! Headline numbers of a series of logs, e.g. successive nightly builds, one row per log.
+
+use crate::analysis::LogTotals;
+use crate::cli::{Cli, TrendArgs, TrendFormat};
+use crate::commands::diff::{load, relative_change};
+use crate::execlog::keep_parsed;
+use crate::filter::{wildcard_match, SpawnFilter};
+use crate::format::{
+    csv_field, format_bytes, format_duration, format_seconds, format_timestamp, Align, Table,
+};
+use crate::metrics::{recorded_total_time, wall_clock_span};
+use crate::parallel::map_in_order;
+use crate::reports::json::TotalsJson;
+use crate::schema::SCHEMA_VERSION;
+use crate::stats::DurationPercentiles;
+use crate::style::{paint, Style};
+use crate::{AppError, AppResult};
+use serde::Serialize;
+use std::fs;
+use std::path::{Path, PathBuf};
+use std::time::{Duration, UNIX_EPOCH};
+
+/// One log of the series.
+struct TrendPoint {
+    label: String,
+    path: PathBuf,
+    /// Earliest spawn start, or the file's modification time for logs without timestamps.
+    started: Option<Duration>,
+    totals: LogTotals,
+    p95: Option<Duration>,
+}
+
+/// Expands wildcards in the file name of `path`, sorted by name. Shells usually expand them
+/// already; this covers quoted patterns and shells that do not.
+fn expand(path: &Path) -> AppResult<Vec<PathBuf>> {
+    let pattern = match path.file_name().and_then(|name| name.to_str()) {
+        Some(name) if name.contains(['*', '?']) => name,
+        _ => return Ok(vec![path.to_path_buf()]),
+    };
+    let dir = path
+        .parent()
+        .filter(|dir| !dir.as_os_str().is_empty())
+        .unwrap_or(Path::new("."));
+    let mut matches = Vec::new();
+    for entry in fs::read_dir(dir)? {
+        let name = entry?.file_name();
+        let Some(name) = name.to_str() else {
+            continue;
+        };
+        if wildcard_match(pattern, name) {
+            matches.push(path.with_file_name(name));
+        }
+    }
+    if matches.is_empty() {
+        return Err(AppError::Analysis(format!(
+            "no logs match {}",
+            path.display()
+        )));
+    }
+    matches.sort();
+    Ok(matches)
+}
+
+/// The file name up to its first dot, e.g. `nightly-0312` for `nightly-0312.log.zst`.
+fn default_label(path: &Path) -> String {
+    let name = path.file_name().map_or_else(
+        || path.display().to_string(),
+        |n| n.to_string_lossy().into_owned(),
+    );
+    match name.split_once('.') {
+        Some((stem, _)) if !stem.is_empty() => stem.to_string(),
+        _ => name,
+    }
+}
+
+fn trend_point(path: &Path, label: &str, filter: &SpawnFilter) -> AppResult<TrendPoint> {
+    let spawns = load(path, filter)?;
+    let started = match wall_clock_span(&spawns) {
+        Some(span) => Some(span.first_start),
+        None => fs::metadata(path)?
+            .modified()
+            .ok()
+            .and_then(|time| time.duration_since(UNIX_EPOCH).ok()),
+    };
+    let mut durations: Vec<Duration> = spawns.iter().filter_map(recorded_total_time).collect();
+    Ok(TrendPoint {
+        label: label.to_string(),
+        path: path.to_path_buf(),
+        started,
+        totals: LogTotals::from_spawns(&spawns),
+        p95: DurationPercentiles::compute(&mut durations).map(|p| p.p95),
+    })
+}
+
+/// Expands the given paths and pairs each log with its `--label` value or default label.
+pub fn labeled_logs(paths: &[PathBuf], labels: &[String]) -> AppResult<Vec<(PathBuf, String)>> {
+    let mut logs = Vec::new();
+    for path in paths {
+        logs.extend(expand(path)?);
+    }
+    if !labels.is_empty() && labels.len() != logs.len() {
+        return Err(AppError::Analysis(format!(
+            "--label names {} logs, but {} were given",
+            labels.len(),
+            logs.len()
+        )));
+    }
+    Ok(logs
+        .into_iter()
+        .enumerate()
+        .map(|(i, path)| {
+            let label = labels
+                .get(i)
+                .cloned()
+                .unwrap_or_else(|| default_label(&path));
+            (path, label)
+        })
+        .collect())
+}
+
+/// Summarizes each log with `summarize` on --parallel-files worker threads, in the given order.
+/// Only the summaries are kept, so memory holds no more parsed logs than there are workers.
+/// Under --skip-errors a log that cannot be summarized is left out with a warning.
+pub fn summarize_logs<T: Send>(
+    args: &Cli,
+    logs: &[(PathBuf, String)],
+    summarize: impl Fn(&Path, &str) -> AppResult<T> + Sync,
+) -> AppResult<Vec<T>> {
+    let results = map_in_order(logs, args.file_workers(logs.len()), |(path, label)| {
+        summarize(path, label)
+    });
+    let paths = logs.iter().map(|(path, _)| path.as_path());
+    Ok(keep_parsed(paths.zip(results), args.skip_errors)?
+        .into_iter()
+        .map(|(_, summary)| summary)
+        .collect())
+}
+
+/// A column whose largest change between consecutive logs is reported.
+struct Metric {
+    name: &'static str,
+    value: fn(&TrendPoint) -> Option<f64>,
+    display: fn(f64) -> String,
+    /// Reports the change in percentage points rather than relative to the earlier log.
+    in_points: bool,
+}
+
+const METRICS: [Metric; 5] = [
+    Metric {
+        name: "Total Actions",
+        value: |p| Some(p.totals.actions as f64),
+        display: |v| format!("{:.0}", v),
+        in_points: false,
+    },
+    Metric {
+        name: "Cache Hit Rate",
+        value: |p| (p.totals.actions > 0).then(|| p.totals.hit_rate()),
+        display: |v| format!("{:.2}%", v),
+        in_points: true,
+    },
+    Metric {
+        name: "Total Spawn Time",
+        value: |p| Some(p.totals.total_time.as_secs_f64()),
+        display: |v| format_seconds(v, 2),
+        in_points: false,
+    },
+    Metric {
+        name: "Data Downloaded",
+        value: |p| Some(p.totals.downloaded_bytes as f64),
+        display: |v| format_bytes(v as i64),
+        in_points: false,
+    },
+    Metric {
+        name: "p95 Action Time",
+        value: |p| p.p95.map(|d| d.as_secs_f64()),
+        display: |v| format_seconds(v, 3),
+        in_points: false,
+    },
+];
+
+fn started_label(point: &TrendPoint) -> String {
+    point.started.map_or_else(
+        || "-".to_string(),
+        |started| format_timestamp(started)[..16].replace('T', " "),
+    )
+}
+
+fn print_largest_changes(points: &[TrendPoint]) {
+    println!("--- Largest Changes Between Consecutive Logs ---");
+    for metric in &METRICS {
+        let largest = points
+            .windows(2)
+            .filter_map(|pair| {
+                let (old, new) = ((metric.value)(&pair[0])?, (metric.value)(&pair[1])?);
+                let change = if metric.in_points {
+                    new - old
+                } else {
+                    relative_change(old, new)?
+                };
+                Some((change, old, new, pair))
+            })
+            .max_by(|a, b| a.0.abs().total_cmp(&b.0.abs()));
+        match largest {
+            Some((change, old, new, pair)) if change != 0.0 => println!(
+                "{}: {} ({} -> {}) from {} to {}",
+                metric.name,
+                if metric.in_points {
+                    format!("{:+.2} pp", change)
+                } else {
+                    format!("{:+.1}%", change)
+                },
+                (metric.display)(old),
+                (metric.display)(new),
+                pair[0].label,
+                pair[1].label
+            ),
+            _ => println!("{}: unchanged", metric.name),
+        }
+    }
+    println!();
+}
+
+fn print_table(points: &[TrendPoint]) {
+    println!("{}", paint(Style::Dim, "========================================"));
+    println!(" Bazel Execution Log Trend");
+    println!("{}", paint(Style::Dim, "========================================"));
+    println!("--- Builds in Chronological Order ---");
+    let mut table = Table::new(vec![
+        ("Log".to_string(), Align::Left),
+        ("Started (UTC)".to_string(), Align::Left),
+        ("Actions".to_string(), Align::Right),
+        ("Hit Rate".to_string(), Align::Right),
+        ("Spawn Time".to_string(), Align::Right),
+        ("Downloaded".to_string(), Align::Right),
+        ("p95 Action".to_string(), Align::Right),
+    ]);
+    for point in points {
+        table.add_row(vec![
+            point.label.clone(),
+            started_label(point),
+            point.totals.actions.to_string(),
+            format!("{:.2}%", point.totals.hit_rate()),
+            format_duration(point.totals.total_time, 2),
+            format_bytes(point.totals.downloaded_bytes),
+            point
+                .p95
+                .map_or_else(|| "N/A".to_string(), |d| format_duration(d, 3)),
+        ]);
+    }
+    table.print();
+    println!("Note: Logs are ordered by their earliest spawn start, or by file modification time when no start is recorded.");
+    println!();
+    if points.len() > 1 {
+        print_largest_changes(points);
+    }
+}
+
+#[derive(Serialize)]
+pub struct PointJson<'a> {
+    pub label: &'a str,
+    pub path: String,
+    pub started_nanos: Option<u64>,
+    #[serde(flatten)]
+    pub totals: TotalsJson,
+    pub p95_action_time_nanos: Option<u64>,
+}
+
+impl<'a> From<&'a TrendPoint> for PointJson<'a> {
+    fn from(point: &'a TrendPoint) -> Self {
+        PointJson {
+            label: &point.label,
+            path: point.path.display().to_string(),
+            started_nanos: point.started.map(|d| d.as_nanos() as u64),
+            totals: TotalsJson::from(&point.totals),
+            p95_action_time_nanos: point.p95.map(|d| d.as_nanos() as u64),
+        }
+    }
+}
+
+#[derive(Serialize)]
+pub struct TrendJson<'a> {
+    pub schema_version: u32,
+    pub logs: Vec<PointJson<'a>>,
+}
+
+fn print_csv(points: &[TrendPoint]) {
+    println!("label,path,started_nanos,actions,cache_hits,cache_hit_rate_percent,total_time_nanos,downloaded_bytes,p95_action_time_nanos");
+    let optional = |value: Option<u64>| value.map_or_else(String::new, |v| v.to_string());
+    for point in points.iter().map(PointJson::from) {
+        println!(
+            "{},{},{},{},{},{},{},{},{}",
+            csv_field(point.label),
+            csv_field(&point.path),
+            optional(point.started_nanos),
+            point.totals.actions,
+            point.totals.cache_hits,
+            point.totals.cache_hit_rate_percent,
+            point.totals.total_time_nanos,
+            point.totals.downloaded_bytes,
+            optional(point.p95_action_time_nanos)
+        );
+    }
+}
+
+pub fn run_trend(args: &Cli, trend: &TrendArgs) -> AppResult<()> {
+    let logs = labeled_logs(&trend.logs, &trend.label)?;
+    let filter = SpawnFilter::from_cli(args);
+    let mut points = summarize_logs(args, &logs, |path, label| trend_point(path, label, &filter))?;
+    points.sort_by_key(|point| (point.started.is_none(), point.started));
+    match trend.output_format {
+        TrendFormat::Text => print_table(&points),
+        TrendFormat::Json => {
+            let document = TrendJson {
+                schema_version: SCHEMA_VERSION,
+                logs: points.iter().map(PointJson::from).collect(),
+            };
+            println!("{}", serde_json::to_string_pretty(&document)?);
+        }
+        TrendFormat::Csv => print_csv(&points),
+    }
+    Ok(())
+}
@@ -0,0 +1,806 @@
+//! Browses the logs parsed once in the terminal: the summary, the mnemonic table, the slowest
+//! actions and the cache report, with a mnemonic's spawns and a single spawn's details a key
+//! press away.
+//!
+//! Views are built from the same aggregates as the batch report (`mnemonic_metrics`,
+//! `CiSummary`, `Table`) and drawn as plain lines cut to the terminal's size.
+
+use crate::analysis::{action_key, mnemonic_metrics, MnemonicMetrics};
+use crate::cli::Cli;
+use crate::commands::analyze::load_spawns;
+use crate::format::{
+    format_bytes, format_command_line, format_duration, format_seconds, format_timestamp, Align,
+    Table,
+};
+use crate::metrics::{phase_duration, recorded_total_time, total_time, wall_clock_span, Phase};
+use crate::paths;
+use crate::proto::SpawnExec;
+use crate::reports::ci_summary::CiSummary;
+use crate::reports::grouping::NO_LABEL;
+use crate::reports::phases::CoverageCounts;
+use crate::stats::DurationPercentiles;
+use crate::style;
+use crate::{AppError, AppResult};
+use crossterm::event::{self, Event, KeyCode, KeyEvent, KeyEventKind, KeyModifiers};
+use crossterm::style::{Attribute, Print, SetAttribute};
+use crossterm::terminal::{self, ClearType, EnterAlternateScreen, LeaveAlternateScreen};
+use crossterm::{cursor, execute, queue};
+use std::cmp::Ordering;
+use std::io::{self, IsTerminal, Write};
+use std::time::Duration;
+
+const HELP: &str =
+    "1-4/Tab views  Up/Down move  Enter open  Esc back  Left/Right sort  r reverse  / filter  q quit";
+
+#[derive(Clone, Copy, PartialEq, Eq)]
+enum Tab {
+    Summary,
+    Mnemonics,
+    Slowest,
+    Cache,
+}
+
+const TABS: [(Tab, &str); 4] = [
+    (Tab::Summary, "Summary"),
+    (Tab::Mnemonics, "Mnemonics"),
+    (Tab::Slowest, "Slowest Actions"),
+    (Tab::Cache, "Cache"),
+];
+
+/// What a screen shows; Enter pushes a view and Esc returns to the one below.
+#[derive(Clone)]
+enum View {
+    Tab(Tab),
+    /// The spawns of one mnemonic.
+    Mnemonic(String),
+    /// One spawn, by its index among all spawns.
+    Spawn(usize),
+}
+
+/// Where a view was left: the selected (or, for text, first) row and the sort order.
+#[derive(Clone, Copy)]
+struct Position {
+    selected: usize,
+    scroll: usize,
+    sort: usize,
+    descending: bool,
+}
+
+impl Position {
+    fn sorted_by(sort: usize) -> Self {
+        Position {
+            selected: 0,
+            scroll: 0,
+            sort,
+            descending: true,
+        }
+    }
+}
+
+/// A sortable table column over rows of type `T`.
+struct Column<T> {
+    header: &'static str,
+    align: Align,
+    cell: fn(&T) -> String,
+    compare: fn(&T, &T) -> Ordering,
+}
+
+type MnemonicRow = (String, MnemonicMetrics);
+
+fn seconds(duration: Duration) -> String {
+    format_duration(duration, 2)
+}
+
+fn mnemonic_columns() -> Vec<Column<MnemonicRow>> {
+    vec![
+        Column {
+            header: "Mnemonic",
+            align: Align::Left,
+            cell: |(name, _)| name.clone(),
+            compare: |(a, _), (b, _)| a.cmp(b),
+        },
+        Column {
+            header: "Count",
+            align: Align::Right,
+            cell: |(_, m)| m.count.to_string(),
+            compare: |(_, a), (_, b)| a.count.cmp(&b.count),
+        },
+        Column {
+            header: "Hits",
+            align: Align::Right,
+            cell: |(_, m)| format!("{:.1}%", m.hit_rate()),
+            compare: |(_, a), (_, b)| a.hit_rate().total_cmp(&b.hit_rate()),
+        },
+        Column {
+            header: "Total",
+            align: Align::Right,
+            cell: |(_, m)| seconds(m.total_duration),
+            compare: |(_, a), (_, b)| a.total_duration.cmp(&b.total_duration),
+        },
+        Column {
+            header: "Miss Time",
+            align: Align::Right,
+            cell: |(_, m)| seconds(m.miss_duration),
+            compare: |(_, a), (_, b)| a.miss_duration.cmp(&b.miss_duration),
+        },
+        Column {
+            header: "Avg",
+            align: Align::Right,
+            cell: |(_, m)| {
+                m.average()
+                    .map_or_else(|| "N/A".to_string(), |d| format_duration(d, 3))
+            },
+            compare: |(_, a), (_, b)| a.average().cmp(&b.average()),
+        },
+        Column {
+            header: "Max",
+            align: Align::Right,
+            cell: |(_, m)| {
+                m.durations
+                    .iter()
+                    .max()
+                    .map_or_else(|| "N/A".to_string(), |d| format_duration(*d, 3))
+            },
+            compare: |(_, a), (_, b)| a.durations.iter().max().cmp(&b.durations.iter().max()),
+        },
+    ]
+}
+
+fn cache_columns() -> Vec<Column<MnemonicRow>> {
+    vec![
+        Column {
+            header: "Mnemonic",
+            align: Align::Left,
+            cell: |(name, _)| name.clone(),
+            compare: |(a, _), (b, _)| a.cmp(b),
+        },
+        Column {
+            header: "Hits",
+            align: Align::Right,
+            cell: |(_, m)| m.cache_hits.to_string(),
+            compare: |(_, a), (_, b)| a.cache_hits.cmp(&b.cache_hits),
+        },
+        Column {
+            header: "Misses",
+            align: Align::Right,
+            cell: |(_, m)| (m.count - m.cache_hits).to_string(),
+            compare: |(_, a), (_, b)| (a.count - a.cache_hits).cmp(&(b.count - b.cache_hits)),
+        },
+        Column {
+            header: "Hit Rate",
+            align: Align::Right,
+            cell: |(_, m)| format!("{:.1}%", m.hit_rate()),
+            compare: |(_, a), (_, b)| a.hit_rate().total_cmp(&b.hit_rate()),
+        },
+        Column {
+            header: "Miss Time",
+            align: Align::Right,
+            cell: |(_, m)| seconds(m.miss_duration),
+            compare: |(_, a), (_, b)| a.miss_duration.cmp(&b.miss_duration),
+        },
+    ]
+}
+
+fn spawn_columns() -> Vec<Column<SpawnExec>> {
+    vec![
+        Column {
+            header: "Time",
+            align: Align::Right,
+            cell: |s| format_duration(total_time(s), 3),
+            compare: |a, b| total_time(a).cmp(&total_time(b)),
+        },
+        Column {
+            header: "Mnemonic",
+            align: Align::Left,
+            cell: |s| s.mnemonic.clone(),
+            compare: |a, b| a.mnemonic.cmp(&b.mnemonic),
+        },
+        Column {
+            header: "Label",
+            align: Align::Left,
+            cell: |s| label(s).to_string(),
+            compare: |a, b| label(a).cmp(label(b)),
+        },
+        Column {
+            header: "Runner",
+            align: Align::Left,
+            cell: |s| s.runner.clone(),
+            compare: |a, b| a.runner.cmp(&b.runner),
+        },
+        Column {
+            header: "Exit",
+            align: Align::Right,
+            cell: |s| s.exit_code.to_string(),
+            compare: |a, b| a.exit_code.cmp(&b.exit_code),
+        },
+    ]
+}
+
+fn label(spawn: &SpawnExec) -> &str {
+    if spawn.target_label.is_empty() {
+        NO_LABEL
+    } else {
+        &spawn.target_label
+    }
+}
+
+/// One screen's worth of lines before it is cut to the terminal.
+struct Page {
+    title: String,
+    /// Lines above the rows that stay in place, such as totals and the table header.
+    fixed: Vec<String>,
+    rows: Vec<String>,
+    /// What Enter opens for each row; empty when the rows are plain text.
+    opens: Vec<View>,
+    /// For each column the rows can be sorted by, whether it sorts descending first: numbers
+    /// largest first, text from A to Z. Empty for text.
+    sort_orders: Vec<bool>,
+}
+
+impl Page {
+    fn text(title: String, lines: Vec<String>) -> Self {
+        Page {
+            title,
+            fixed: Vec::new(),
+            rows: lines,
+            opens: Vec::new(),
+            sort_orders: Vec::new(),
+        }
+    }
+}
+
+/// Sorts the rows of `items` picked by `indices` as `position` says and lays them out.
+fn table_page<T>(
+    title: String,
+    mut fixed: Vec<String>,
+    items: &[T],
+    mut indices: Vec<usize>,
+    columns: &[Column<T>],
+    position: &Position,
+    open: impl Fn(usize, &T) -> View,
+) -> Page {
+    let sort = &columns[position.sort];
+    indices.sort_by(|&a, &b| (sort.compare)(&items[a], &items[b]));
+    if position.descending {
+        indices.reverse();
+    }
+    let mut table = Table::new(
+        columns
+            .iter()
+            .enumerate()
+            .map(|(i, column)| {
+                let header = match (i == position.sort, position.descending) {
+                    (false, _) => column.header.to_string(),
+                    (true, true) => format!("{} v", column.header),
+                    (true, false) => format!("{} ^", column.header),
+                };
+                (header, column.align)
+            })
+            .collect(),
+    );
+    for &i in &indices {
+        table.add_row(
+            columns
+                .iter()
+                .map(|column| (column.cell)(&items[i]))
+                .collect(),
+        );
+    }
+    let mut lines = table.lines();
+    let rows = lines.split_off(2);
+    fixed.extend(lines);
+    Page {
+        title,
+        fixed,
+        rows,
+        opens: indices.iter().map(|&i| open(i, &items[i])).collect(),
+        sort_orders: columns
+            .iter()
+            .map(|c| matches!(c.align, Align::Right))
+            .collect(),
+    }
+}
+
+struct Browser {
+    logs: Vec<String>,
+    spawns: Vec<SpawnExec>,
+    mnemonics: Vec<MnemonicRow>,
+    stack: Vec<(View, Position)>,
+    /// Rows of list views must contain this, ignoring case.
+    filter: String,
+    /// The filter being typed after `/`.
+    prompt: Option<String>,
+    /// Rows that fit on the screen, as of the last draw.
+    visible_rows: usize,
+}
+
+impl Browser {
+    fn new(logs: Vec<String>, spawns: Vec<SpawnExec>) -> Self {
+        let mut mnemonics: Vec<MnemonicRow> = mnemonic_metrics(&spawns).into_iter().collect();
+        mnemonics.sort_by(|a, b| a.0.cmp(&b.0));
+        Browser {
+            logs,
+            spawns,
+            mnemonics,
+            stack: vec![Browser::entry(View::Tab(Tab::Summary))],
+            filter: String::new(),
+            prompt: None,
+            visible_rows: 1,
+        }
+    }
+
+    /// A view with its default sort: by time, slowest first.
+    fn entry(view: View) -> (View, Position) {
+        let sort = match view {
+            View::Tab(Tab::Mnemonics) => 3,
+            View::Tab(Tab::Cache) => 4,
+            _ => 0,
+        };
+        (view, Position::sorted_by(sort))
+    }
+
+    fn current(&self) -> &(View, Position) {
+        self.stack.last().expect("the stack always holds a tab")
+    }
+
+    fn position(&mut self) -> &mut Position {
+        &mut self
+            .stack
+            .last_mut()
+            .expect("the stack always holds a tab")
+            .1
+    }
+
+    fn tab(&self) -> Tab {
+        match self.stack[0].0 {
+            View::Tab(tab) => tab,
+            _ => Tab::Summary,
+        }
+    }
+
+    fn matches(&self, text: &[&str]) -> bool {
+        let filter = self.filter.to_lowercase();
+        text.iter().any(|t| t.to_lowercase().contains(&filter))
+    }
+
+    fn mnemonic_indices(&self) -> Vec<usize> {
+        (0..self.mnemonics.len())
+            .filter(|&i| self.matches(&[&self.mnemonics[i].0]))
+            .collect()
+    }
+
+    fn spawn_indices(&self, mnemonic: Option<&str>) -> Vec<usize> {
+        (0..self.spawns.len())
+            .filter(|&i| {
+                let spawn = &self.spawns[i];
+                let (label, mnemonic_name, output) = action_key(spawn);
+                mnemonic.is_none_or(|m| m == mnemonic_name)
+                    && self.matches(&[label, mnemonic_name, output])
+            })
+            .collect()
+    }
+
+    fn page(&self) -> Page {
+        let (view, position) = self.current();
+        match view {
+            View::Tab(Tab::Summary) => Page::text("Summary".to_string(), self.summary_lines()),
+            View::Tab(Tab::Mnemonics) => table_page(
+                "Analysis by Mnemonic".to_string(),
+                Vec::new(),
+                &self.mnemonics,
+                self.mnemonic_indices(),
+                &mnemonic_columns(),
+                position,
+                |_, (name, _)| View::Mnemonic(name.clone()),
+            ),
+            View::Tab(Tab::Slowest) => table_page(
+                "Slowest Actions".to_string(),
+                Vec::new(),
+                &self.spawns,
+                self.spawn_indices(None),
+                &spawn_columns(),
+                position,
+                |i, _| View::Spawn(i),
+            ),
+            View::Tab(Tab::Cache) => table_page(
+                "Cache Performance".to_string(),
+                self.cache_lines(),
+                &self.mnemonics,
+                self.mnemonic_indices(),
+                &cache_columns(),
+                position,
+                |_, (name, _)| View::Mnemonic(name.clone()),
+            ),
+            View::Mnemonic(mnemonic) => table_page(
+                format!("Spawns of {}", mnemonic),
+                self.mnemonic_lines(mnemonic),
+                &self.spawns,
+                self.spawn_indices(Some(mnemonic)),
+                &spawn_columns(),
+                position,
+                |i, _| View::Spawn(i),
+            ),
+            View::Spawn(i) => {
+                let spawn = &self.spawns[*i];
+                Page::text(
+                    format!("{} {}", spawn.mnemonic, label(spawn)),
+                    spawn_lines(spawn),
+                )
+            }
+        }
+    }
+
+    fn summary_lines(&self) -> Vec<String> {
+        let summary = CiSummary::from_spawns(&self.spawns);
+        let mut lines = vec![
+            format!("Log files: {}", self.logs.join(", ")),
+            String::new(),
+            format!(
+                "Total Actions: {} ({} failed)",
+                summary.actions, summary.failed
+            ),
+            format!("Cache Hit Rate: {:.2}%", summary.hit_rate * 100.0),
+        ];
+        if let Some(weighted) = summary.hit_rate_weighted {
+            lines.push(format!("Time-Weighted Hit Rate: {:.2}%", weighted * 100.0));
+        }
+        lines.push(format!("Total Spawn Time: {}", format_seconds(summary.spawn_seconds, 2)));
+        lines.push(format!(
+            "Data Downloaded: {}",
+            format_bytes(summary.downloaded_bytes)
+        ));
+        let mut durations: Vec<Duration> =
+            self.spawns.iter().filter_map(recorded_total_time).collect();
+        lines.push(match DurationPercentiles::compute(&mut durations) {
+            Some(p) => format!(
+                "Action Durations: p50 {} | p90 {} | p95 {} | p99 {} | max {}",
+                format_duration(p.p50, 3),
+                format_duration(p.p90, 3),
+                format_duration(p.p95, 3),
+                format_duration(p.p99, 3),
+                format_duration(p.max, 3)),
+            None => "Action Durations: N/A (no timing data recorded)".to_string(),
+        });
+        if let Some(span) = wall_clock_span(&self.spawns) {
+            lines.push(format!(
+                "Wall Clock: {} from {} UTC",
+                format_duration(span.span(), 2),
+                format_timestamp(span.first_start)
+            ));
+        }
+        let mut coverage = CoverageCounts::default();
+        for spawn in &self.spawns {
+            coverage.add(spawn);
+        }
+        lines.push(format!(
+            "Timing data present for {:.1}% of actions",
+            coverage.timed_percent()
+        ));
+        lines
+    }
+
+    fn cache_lines(&self) -> Vec<String> {
+        let summary = CiSummary::from_spawns(&self.spawns);
+        let remote_hits = self
+            .spawns
+            .iter()
+            .filter(|s| s.runner == "remote cache hit")
+            .count();
+        let miss_total: Duration = self.mnemonics.iter().map(|(_, m)| m.miss_duration).sum();
+        vec![
+            format!(
+                "Cache Hit Rate: {:.2}%, time-weighted {}",
+                summary.hit_rate * 100.0,
+                summary
+                    .hit_rate_weighted
+                    .map_or_else(|| "N/A".to_string(), |w| format!("{:.2}%", w * 100.0))
+            ),
+            format!(
+                "Remote Cache Hits: {} ({} downloaded)",
+                remote_hits,
+                format_bytes(summary.downloaded_bytes)
+            ),
+            format!("Cache misses account for {}", seconds(miss_total)),
+            String::new(),
+        ]
+    }
+
+    fn mnemonic_lines(&self, mnemonic: &str) -> Vec<String> {
+        let Some((_, metrics)) = self.mnemonics.iter().find(|(name, _)| name == mnemonic) else {
+            return Vec::new();
+        };
+        vec![
+            format!(
+                "{} spawns, {:.1}% cache hits, {} total, {} missing the cache",
+                metrics.count,
+                metrics.hit_rate(),
+                seconds(metrics.total_duration),
+                seconds(metrics.miss_duration)
+            ),
+            String::new(),
+        ]
+    }
+
+    /// Handles a key press; false quits.
+    fn handle(&mut self, key: KeyEvent, page: &Page) -> bool {
+        if let Some(prompt) = self.prompt.as_mut() {
+            match key.code {
+                KeyCode::Enter => {
+                    self.filter = self.prompt.take().unwrap_or_default();
+                    self.position().selected = 0;
+                }
+                KeyCode::Esc => self.prompt = None,
+                KeyCode::Backspace => {
+                    prompt.pop();
+                }
+                KeyCode::Char(c) => prompt.push(c),
+                _ => {}
+            }
+            return true;
+        }
+
+        let last = page.rows.len().saturating_sub(1);
+        let visible = self.visible_rows.max(1);
+        let columns = page.sort_orders.len();
+        let position = self.position();
+        match key.code {
+            KeyCode::Char('q') => return false,
+            KeyCode::Char('c') if key.modifiers.contains(KeyModifiers::CONTROL) => return false,
+            KeyCode::Up | KeyCode::Char('k') => {
+                position.selected = position.selected.saturating_sub(1)
+            }
+            KeyCode::Down | KeyCode::Char('j') => {
+                position.selected = (position.selected + 1).min(last)
+            }
+            KeyCode::PageUp => position.selected = position.selected.saturating_sub(visible),
+            KeyCode::PageDown => position.selected = (position.selected + visible).min(last),
+            KeyCode::Home | KeyCode::Char('g') => position.selected = 0,
+            KeyCode::End | KeyCode::Char('G') => position.selected = last,
+            KeyCode::Left | KeyCode::Right if columns > 0 => {
+                position.sort = if key.code == KeyCode::Left {
+                    (position.sort + columns - 1) % columns
+                } else {
+                    (position.sort + 1) % columns
+                };
+                position.descending = page.sort_orders[position.sort];
+                position.selected = 0;
+            }
+            KeyCode::Char('r') if columns > 0 => {
+                position.descending = !position.descending;
+                position.selected = 0;
+            }
+            KeyCode::Enter => {
+                if let Some(view) = page.opens.get(position.selected) {
+                    self.stack.push(Browser::entry(view.clone()));
+                }
+            }
+            KeyCode::Esc | KeyCode::Backspace if self.stack.len() > 1 => {
+                self.stack.pop();
+            }
+            KeyCode::Char('/') => self.prompt = Some(self.filter.clone()),
+            KeyCode::Tab | KeyCode::BackTab => {
+                let current = TABS
+                    .iter()
+                    .position(|(tab, _)| *tab == self.tab())
+                    .unwrap_or(0);
+                let next = if key.code == KeyCode::Tab {
+                    (current + 1) % TABS.len()
+                } else {
+                    (current + TABS.len() - 1) % TABS.len()
+                };
+                self.stack = vec![Browser::entry(View::Tab(TABS[next].0))];
+            }
+            KeyCode::Char(c @ '1'..='4') => {
+                let tab = TABS[c as usize - '1' as usize].0;
+                self.stack = vec![Browser::entry(View::Tab(tab))];
+            }
+            _ => {}
+        }
+        true
+    }
+}
+
+fn spawn_lines(spawn: &SpawnExec) -> Vec<String> {
+    let (_, _, output) = action_key(spawn);
+    let yes_no = |value: bool| if value { "yes" } else { "no" };
+    let mut lines = vec![
+        format!("Mnemonic: {}", spawn.mnemonic),
+        format!("Label: {}", label(spawn)),
+        format!("Primary Output: {}", output),
+        format!("Runner: {}", spawn.runner),
+        format!(
+            "Cache Hit: {} | Cacheable: {} | Remotable: {}",
+            yes_no(spawn.cache_hit),
+            yes_no(spawn.cacheable),
+            yes_no(spawn.remotable)
+        ),
+        format!(
+            "Exit Code: {}{}",
+            spawn.exit_code,
+            if spawn.status.is_empty() {
+                String::new()
+            } else {
+                format!(" ({})", spawn.status)
+            }
+        ),
+        format!("Total Time: {}", format_duration(total_time(spawn), 3)),
+        String::new(),
+        "Phases:".to_string(),
+    ];
+    match &spawn.metrics {
+        Some(metrics) => {
+            let mut table = Table::new(vec![
+                ("Phase".to_string(), Align::Left),
+                ("Time".to_string(), Align::Right),
+            ]);
+            for phase in Phase::ALL {
+                if let Some(duration) = phase_duration(metrics, phase) {
+                    table.add_row(vec![
+                        phase.name().to_string(),
+                        format_duration(duration, 3),
+                    ]);
+                }
+            }
+            if table.is_empty() {
+                lines.push("  No phases recorded.".to_string());
+            } else {
+                lines.extend(table.lines().into_iter().map(|line| format!("  {}", line)));
+            }
+        }
+        None => lines.push("  No metrics recorded.".to_string()),
+    }
+    lines.push(String::new());
+    lines.push(format!(
+        "Command Line ({} arguments):",
+        spawn.command_args.len()
+    ));
+    if !spawn.command_args.is_empty() {
+        lines.push(format!(
+            "  {}",
+            format_command_line(&spawn.command_args, None)
+        ));
+    }
+    lines.push(String::new());
+    lines.push(format!(
+        "Environment ({} variables):",
+        spawn.environment_variables.len()
+    ));
+    for var in &spawn.environment_variables {
+        lines.push(format!("  {}={}", var.name, var.value));
+    }
+    lines.push(String::new());
+    lines.push(format!("Outputs ({}):", spawn.actual_outputs.len()));
+    for file in &spawn.actual_outputs {
+        let size = file.digest.as_ref().map_or(0, |d| d.size_bytes);
+        lines.push(format!("  {} ({})", paths::relative(&file.path), format_bytes(size)));
+    }
+    lines
+}
+
+/// Cuts `line` to `width` characters.
+fn fit(line: &str, width: usize) -> String {
+    line.chars().take(width).collect()
+}
+
+fn draw(out: &mut impl Write, browser: &mut Browser, page: &Page) -> io::Result<()> {
+    let (width, height) = terminal::size()?;
+    let (width, height) = (width as usize, height as usize);
+    // Tab bar, title, fixed lines, rows, and the help or filter line.
+    let mut lines: Vec<(String, bool)> = Vec::new();
+    let active = browser.tab();
+    let tabs: Vec<String> = TABS
+        .iter()
+        .enumerate()
+        .map(|(i, (tab, name))| {
+            if *tab == active {
+                format!("[{} {}]", i + 1, name)
+            } else {
+                format!(" {} {} ", i + 1, name)
+            }
+        })
+        .collect();
+    lines.push((tabs.join(" "), false));
+    let mut title = format!("--- {} ---", page.title);
+    if !browser.filter.is_empty() {
+        title.push_str(&format!("  (filter: {})", browser.filter));
+    }
+    lines.push((title, false));
+    lines.extend(page.fixed.iter().map(|line| (line.clone(), false)));
+
+    let visible = height.saturating_sub(lines.len() + 1).max(1);
+    browser.visible_rows = visible;
+    let selectable = !page.opens.is_empty();
+    let position = browser.position();
+    position.selected = position.selected.min(page.rows.len().saturating_sub(1));
+    if selectable {
+        if position.selected < position.scroll {
+            position.scroll = position.selected;
+        } else if position.selected >= position.scroll + visible {
+            position.scroll = position.selected + 1 - visible;
+        }
+    } else {
+        position.selected = position
+            .selected
+            .min(page.rows.len().saturating_sub(visible));
+        position.scroll = position.selected;
+    }
+    let (selected, scroll) = (position.selected, position.scroll);
+    if page.rows.is_empty() {
+        lines.push(("No rows match.".to_string(), false));
+    }
+    for (i, row) in page.rows.iter().enumerate().skip(scroll).take(visible) {
+        lines.push((row.clone(), selectable && i == selected));
+    }
+    lines.truncate(height.saturating_sub(1));
+    lines.resize(height.saturating_sub(1), (String::new(), false));
+    lines.push(match &browser.prompt {
+        Some(prompt) => (format!("Filter: {}_", prompt), false),
+        None => (HELP.to_string(), true),
+    });
+
+    for (row, (line, highlight)) in lines.iter().enumerate() {
+        queue!(out, cursor::MoveTo(0, row as u16))?;
+        if *highlight {
+            queue!(out, SetAttribute(Attribute::Reverse))?;
+        }
+        queue!(
+            out,
+            Print(fit(line, width)),
+            SetAttribute(Attribute::Reset),
+            terminal::Clear(ClearType::UntilNewLine)
+        )?;
+    }
+    out.flush()
+}
+
+/// Raw mode on the alternate screen for as long as it lives, so the shell gets its terminal
+/// back however the browser ends.
+struct RawTerminal;
+
+impl RawTerminal {
+    fn enter() -> io::Result<Self> {
+        terminal::enable_raw_mode()?;
+        let terminal = RawTerminal;
+        execute!(io::stdout(), EnterAlternateScreen, cursor::Hide)?;
+        Ok(terminal)
+    }
+}
+
+impl Drop for RawTerminal {
+    fn drop(&mut self) {
+        let _ = execute!(io::stdout(), cursor::Show, LeaveAlternateScreen);
+        let _ = terminal::disable_raw_mode();
+    }
+}
+
+pub fn run_tui(args: &Cli) -> AppResult<()> {
+    if !io::stdout().is_terminal() || !io::stdin().is_terminal() {
+        return Err(AppError::Analysis(
+            "--tui needs an interactive terminal on stdin and stdout; drop --tui to print the report, or use --serve to browse it elsewhere".to_string(),
+        ));
+    }
+    // Cells are cut to the screen by characters, which escape codes would throw off.
+    style::disable();
+    let spawns = load_spawns(args)?;
+    let logs = args
+        .log_files()
+        .iter()
+        .map(|p| p.display().to_string())
+        .collect();
+    let mut browser = Browser::new(logs, spawns);
+
+    let _terminal = RawTerminal::enter()?;
+    let mut out = io::stdout();
+    loop {
+        let page = browser.page();
+        draw(&mut out, &mut browser, &page)?;
+        match event::read()? {
+            Event::Key(key) if key.kind == KeyEventKind::Press => {
+                if !browser.handle(key, &page) {
+                    return Ok(());
+                }
+            }
+            _ => {}
+        }
+    }
+}
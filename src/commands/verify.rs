@@ -0,0 +1,182 @@
+//! Re-hashes recorded outputs on disk and compares them with the log.
+
+use crate::cli::Cli;
+use crate::format::section;
+use crate::metrics::is_symlink;
+use crate::proto::{Digest, SpawnExec};
+use crate::{AppError, AppResult, Gate};
+use sha2::{Digest as _, Sha256};
+use std::collections::HashSet;
+use std::fs::File;
+use std::io::{self, Read, Write};
+use std::path::{Path, PathBuf};
+use std::sync::atomic::{AtomicUsize, Ordering};
+use std::sync::mpsc;
+use std::thread;
+
+/// Outcome of checking one recorded output against the file on disk.
+enum Outcome {
+    Match,
+    Mismatch { actual_hash: String, actual_size: u64 },
+    Missing,
+    /// The file exists but could not be read, or its hash function is not supported.
+    Skipped(String),
+}
+
+struct Check<'a> {
+    path: &'a str,
+    digest: &'a Digest,
+}
+
+fn sha256_file(path: &Path) -> io::Result<(String, u64)> {
+    let mut file = File::open(path)?;
+    let mut hasher = Sha256::new();
+    let mut buffer = vec![0u8; 1 << 16];
+    let mut size = 0u64;
+    loop {
+        let read = file.read(&mut buffer)?;
+        if read == 0 {
+            break;
+        }
+        hasher.update(&buffer[..read]);
+        size += read as u64;
+    }
+    let hash = hasher
+        .finalize()
+        .iter()
+        .map(|b| format!("{:02x}", b))
+        .collect();
+    Ok((hash, size))
+}
+
+fn verify(check: &Check, workspace: &Path) -> Outcome {
+    let function = check.digest.hash_function_name.to_ascii_uppercase().replace('-', "");
+    if !function.is_empty() && function != "SHA256" {
+        return Outcome::Skipped(format!(
+            "unsupported hash function {}",
+            check.digest.hash_function_name
+        ));
+    }
+    let path: PathBuf = workspace.join(check.path);
+    match sha256_file(&path) {
+        Ok((hash, size)) => {
+            if hash.eq_ignore_ascii_case(&check.digest.hash)
+                && size as i64 == check.digest.size_bytes
+            {
+                Outcome::Match
+            } else {
+                Outcome::Mismatch {
+                    actual_hash: hash,
+                    actual_size: size,
+                }
+            }
+        }
+        Err(e) if e.kind() == io::ErrorKind::NotFound => Outcome::Missing,
+        Err(e) => Outcome::Skipped(e.to_string()),
+    }
+}
+
+/// Verifies the recorded outputs of `spawns` against the files under `--workspace`.
+///
+/// Files are hashed on a pool of worker threads. Returns an error if any output differs
+/// from the log, so the command can gate CI on hermeticity.
+pub fn run_verify(spawns: &[SpawnExec], args: &Cli) -> AppResult<()> {
+    let Some(workspace) = args.workspace.as_deref() else {
+        return Err(AppError::Analysis(
+            "--verify-outputs requires --workspace".to_string(),
+        ));
+    };
+
+    let mut seen = HashSet::new();
+    let checks: Vec<Check> = spawns
+        .iter()
+        .flat_map(|s| &s.actual_outputs)
+        .filter(|f| !is_symlink(f))
+        .filter_map(|f| f.digest.as_ref().map(|digest| Check { path: &f.path, digest }))
+        .filter(|c| seen.insert((c.path, &c.digest.hash)))
+        .collect();
+
+    println!("{}", section("Output Verification"));
+    println!("Workspace: {}", workspace.display());
+    if checks.is_empty() {
+        println!("No outputs with recorded digests to verify.");
+        println!();
+        return Ok(());
+    }
+
+    let workers = thread::available_parallelism().map_or(4, |n| n.get()).min(checks.len());
+    let next = AtomicUsize::new(0);
+    let (sender, receiver) = mpsc::channel();
+    let mut outcomes: Vec<Option<Outcome>> = (0..checks.len()).map(|_| None).collect();
+    thread::scope(|scope| {
+        for _ in 0..workers {
+            let sender = sender.clone();
+            let (next, checks) = (&next, &checks);
+            scope.spawn(move || {
+                loop {
+                    let i = next.fetch_add(1, Ordering::Relaxed);
+                    let Some(check) = checks.get(i) else {
+                        break;
+                    };
+                    if sender.send((i, verify(check, workspace))).is_err() {
+                        break;
+                    }
+                }
+            });
+        }
+        drop(sender);
+        for (done, (i, outcome)) in receiver.iter().enumerate() {
+            outcomes[i] = Some(outcome);
+            if (done + 1) % 100 == 0 || done + 1 == checks.len() {
+                eprint!("\rVerifying outputs: {}/{}", done + 1, checks.len());
+                let _ = io::stderr().flush();
+            }
+        }
+        eprintln!();
+    });
+
+    let (mut matched, mut mismatched, mut missing, mut skipped) = (0, 0, 0, 0);
+    for (check, outcome) in checks.iter().zip(&outcomes) {
+        match outcome {
+            Some(Outcome::Match) => matched += 1,
+            Some(Outcome::Mismatch {
+                actual_hash,
+                actual_size,
+            }) => {
+                mismatched += 1;
+                println!("MISMATCH {}", check.path);
+                println!(
+                    "    └ log:  {}/{}",
+                    check.digest.hash, check.digest.size_bytes
+                );
+                println!("    └ disk: {}/{}", actual_hash, actual_size);
+            }
+            Some(Outcome::Missing) => {
+                missing += 1;
+                println!("MISSING  {}", check.path);
+            }
+            Some(Outcome::Skipped(reason)) => {
+                skipped += 1;
+                println!("SKIPPED  {} ({})", check.path, reason);
+            }
+            None => {}
+        }
+    }
+    println!(
+        "Verified {} outputs: {} match, {} mismatch, {} missing, {} skipped",
+        checks.len(),
+        matched,
+        mismatched,
+        missing,
+        skipped
+    );
+    println!();
+
+    if mismatched > 0 {
+        return Err(AppError::Gate(
+            Gate::Threshold,
+            format!("{} outputs do not match their recorded digests", mismatched),
+        ));
+    }
+    Ok(())
+}
@@ -0,0 +1,271 @@
+//! Follows a log that Bazel is still writing and refreshes a short summary, then prints the
+//! full report on Ctrl-C.
+//!
+//! Only complete records are decoded; a record still being written stays buffered until the
+//! rest of it arrives. Compact logs are decompressed as a stream, so the zstd frame does not
+//! have to be finished. A log that shrinks or whose first bytes change was replaced, e.g. by
+//! the next build, and is read again from the start.
+
+use crate::analysis::LogTotals;
+use crate::classify::is_failed;
+use crate::cli::Cli;
+use crate::commands::analyze::analyze_logs;
+use crate::execlog::{is_truncated, CompactReader, Detail, ZSTD_MAGIC};
+use crate::format::{
+    format_bytes, format_duration, format_duration_short, format_timestamp, Align, Table,
+};
+use crate::metrics::total_time;
+use crate::proto::{ExecLogEntry, SpawnExec};
+use crate::report::highest_first;
+use crate::reports::grouping::NO_LABEL;
+use crate::style::{paint, Style};
+use crate::warnings::{self, Warning, WarningCode};
+use crate::{AppError, AppResult};
+use prost::Message;
+use signal_hook::consts::SIGINT;
+use std::fs::File;
+use std::io::{self, IsTerminal, Read, Seek, SeekFrom};
+use std::path::PathBuf;
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::Arc;
+use std::thread;
+use std::time::{Duration, Instant, SystemTime, UNIX_EPOCH};
+use zstd::stream::raw::{Decoder, Operation};
+
+/// Bytes at the start of the log compared on every refresh to notice a replaced file.
+const PREFIX_LEN: usize = 4096;
+
+/// How often a sleep between refreshes checks for Ctrl-C.
+const INTERRUPT_POLL: Duration = Duration::from_millis(100);
+
+enum Format {
+    Verbose,
+    Compact(Decoder<'static>, CompactReader),
+}
+
+/// The part of a growing log read so far.
+struct LogTail {
+    path: PathBuf,
+    keep_inputs: bool,
+    /// Bytes of the file read so far.
+    offset: u64,
+    prefix: Vec<u8>,
+    /// Detected from the first bytes, once there are enough of them.
+    format: Option<Format>,
+    /// Decoded (for compact logs, decompressed) bytes that do not form a complete record yet.
+    pending: Vec<u8>,
+    spawns: Vec<SpawnExec>,
+}
+
+impl LogTail {
+    fn new(path: PathBuf, keep_inputs: bool) -> Self {
+        LogTail {
+            path,
+            keep_inputs,
+            offset: 0,
+            prefix: Vec::new(),
+            format: None,
+            pending: Vec::new(),
+            spawns: Vec::new(),
+        }
+    }
+
+    fn reset(&mut self) {
+        *self = LogTail::new(std::mem::take(&mut self.path), self.keep_inputs);
+    }
+
+    /// Whether the file still starts with the bytes read from it earlier.
+    fn same_prefix(&self, file: &mut File) -> io::Result<bool> {
+        let mut start = vec![0; self.prefix.len()];
+        file.seek(SeekFrom::Start(0))?;
+        file.read_exact(&mut start)?;
+        Ok(start == self.prefix)
+    }
+
+    /// Reads what was appended to the log since the last call.
+    fn poll(&mut self) -> AppResult<()> {
+        let mut file = match File::open(&self.path) {
+            Ok(file) => file,
+            // Bazel may not have created the log yet.
+            Err(err) if err.kind() == io::ErrorKind::NotFound && self.offset == 0 => {
+                return Ok(());
+            }
+            Err(err) => return Err(err.into()),
+        };
+        let len = file.metadata()?.len();
+        if len < self.offset || !self.same_prefix(&mut file)? {
+            eprintln!(
+                "Note: {} was truncated or replaced; reading it from the start.",
+                self.path.display()
+            );
+            self.reset();
+        }
+        // The format is told apart by the first four bytes.
+        if self.format.is_none() && len < ZSTD_MAGIC.len() as u64 {
+            return Ok(());
+        }
+
+        file.seek(SeekFrom::Start(self.offset))?;
+        let mut appended = Vec::new();
+        file.read_to_end(&mut appended)?;
+        self.offset += appended.len() as u64;
+        let missing = PREFIX_LEN.saturating_sub(self.prefix.len());
+        self.prefix
+            .extend_from_slice(&appended[..missing.min(appended.len())]);
+        self.decode(&appended)
+    }
+
+    fn decode(&mut self, appended: &[u8]) -> AppResult<()> {
+        if self.format.is_none() {
+            self.format = Some(if appended.starts_with(&ZSTD_MAGIC) {
+                Format::Compact(Decoder::new()?, CompactReader::new(self.keep_inputs))
+            } else {
+                Format::Verbose
+            });
+        }
+        let Some(format) = self.format.as_mut() else {
+            return Ok(());
+        };
+        match format {
+            Format::Verbose => self.pending.extend_from_slice(appended),
+            Format::Compact(decoder, _) => {
+                let mut input = appended;
+                let mut chunk = vec![0; 1 << 16];
+                loop {
+                    let status = decoder.run_on_buffers(input, &mut chunk)?;
+                    self.pending
+                        .extend_from_slice(&chunk[..status.bytes_written]);
+                    input = &input[status.bytes_read..];
+                    if input.is_empty() && status.bytes_written < chunk.len() {
+                        break;
+                    }
+                }
+            }
+        }
+
+        let mut cursor = self.pending.as_slice();
+        while !cursor.is_empty() && !is_truncated(cursor) {
+            match format {
+                Format::Verbose => self
+                    .spawns
+                    .push(SpawnExec::decode_length_delimited(&mut cursor)?),
+                Format::Compact(_, reader) => {
+                    let entry = ExecLogEntry::decode_length_delimited(&mut cursor)?;
+                    self.spawns.extend(reader.next_spawn(entry));
+                }
+            }
+        }
+        let consumed = self.pending.len() - cursor.len();
+        self.pending.drain(..consumed);
+        Ok(())
+    }
+
+    /// Reports what reading the whole log would have warned about.
+    fn report_warnings(&self) {
+        let mut found = warnings::check_spawns(&self.spawns);
+        if let Some(Format::Compact(_, reader)) = &self.format {
+            found.extend(reader.unknown_entries_warning());
+        }
+        if !self.pending.is_empty() {
+            found.push(Warning::new(
+                WarningCode::TruncatedRecord,
+                format!(
+                    "the log ends in the middle of a record; its last {} bytes were ignored",
+                    self.pending.len()
+                ),
+            ));
+        }
+        for warning in found {
+            warnings::report(warning.path(&self.path));
+        }
+    }
+}
+
+fn print_progress(tail: &LogTail, args: &Cli) {
+    if io::stdout().is_terminal() {
+        // Clears the screen, so the summary refreshes in place.
+        print!("\x1b[2J\x1b[H");
+    }
+    let now = SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .unwrap_or_default();
+    println!("{}", paint(Style::Dim, "========================================"));
+    println!(" Watching {}", tail.path.display());
+    println!("{}", paint(Style::Dim, "========================================"));
+    println!(
+        "Refreshed at {} UTC, every {}; press Ctrl-C for the full report.",
+        &format_timestamp(now)[11..19],
+        format_duration_short(args.interval)
+    );
+    let totals = LogTotals::from_spawns(&tail.spawns);
+    let failed = tail.spawns.iter().filter(|s| is_failed(s)).count();
+    println!("Actions so far: {} ({} failed)", totals.actions, failed);
+    println!(
+        "Cache hit rate: {:.2}% ({} hits)",
+        totals.hit_rate(),
+        totals.cache_hits
+    );
+    println!("Spawn time: {}", format_duration(totals.total_time, 2));
+    println!("Downloaded: {}", format_bytes(totals.downloaded_bytes));
+    println!();
+
+    println!("--- Slowest Actions So Far ---");
+    if tail.spawns.is_empty() {
+        println!("No spawns in the log yet.");
+        println!();
+        return;
+    }
+    let mut slowest: Vec<&SpawnExec> = tail.spawns.iter().collect();
+    slowest.sort_by(|a, b| highest_first(a, b, total_time));
+    let mut table = Table::new(vec![
+        ("Duration".to_string(), Align::Right),
+        ("Mnemonic".to_string(), Align::Left),
+        ("Label".to_string(), Align::Left),
+    ]);
+    for spawn in slowest.iter().take(args.top_n) {
+        table.add_row(vec![
+            format_duration(total_time(spawn), 2),
+            spawn.mnemonic.clone(),
+            if spawn.target_label.is_empty() {
+                NO_LABEL.to_string()
+            } else {
+                spawn.target_label.clone()
+            },
+        ]);
+    }
+    table.print();
+    println!();
+}
+
+pub fn run_watch(args: Cli) -> AppResult<()> {
+    let [path] = args.log_files() else {
+        return Err(AppError::Analysis(
+            "--watch follows a single log".to_string(),
+        ));
+    };
+    let mut tail = LogTail::new(path.clone(), args.needs_full_decode());
+
+    // A second Ctrl-C exits at once, e.g. while the final report is slow.
+    let interrupted = Arc::new(AtomicBool::new(false));
+    signal_hook::flag::register_conditional_shutdown(SIGINT, 1, Arc::clone(&interrupted))?;
+    signal_hook::flag::register(SIGINT, Arc::clone(&interrupted))?;
+
+    while !interrupted.load(Ordering::Relaxed) {
+        tail.poll()?;
+        print_progress(&tail, &args);
+        let deadline = Instant::now() + args.interval;
+        while !interrupted.load(Ordering::Relaxed) {
+            let left = deadline.saturating_duration_since(Instant::now());
+            if left.is_zero() {
+                break;
+            }
+            thread::sleep(left.min(INTERRUPT_POLL));
+        }
+    }
+
+    tail.poll()?;
+    tail.report_warnings();
+    println!();
+    // The tail decodes every field the report reads.
+    analyze_logs(args, vec![tail.spawns], Detail::Standard)
+}
@@ -0,0 +1,261 @@
+//! Default flags from a TOML config file, so a team's usual options live in one place instead
+//! of every wrapper script.
+//!
+//! Each key is a flag's long name without the leading dashes and holds its value: `top-n = 25`,
+//! `exclude-failed = true`, or an array for a flag that can be repeated. Values are turned back
+//! into arguments in front of those given on the command line, skipping every flag the command
+//! line sets itself, so the order of precedence is built-in default < config file < flag. A
+//! flag given on the command line replaces all of the file's values for it, including lists,
+//! and of the flags it cannot be combined with: `--uncacheable-only` on the command line drops
+//! `cacheable-only = true` from the file.
+
+use crate::cli::Cli;
+use clap::error::ErrorKind;
+use clap::parser::ValueSource;
+use clap::{ArgMatches, CommandFactory};
+use std::ffi::OsString;
+use std::fs;
+use std::path::{Path, PathBuf};
+
+/// Looked for in the current directory unless --config or --no-config is given.
+pub const CONFIG_FILE: &str = ".bzl-exec-log-parser.toml";
+
+/// Flags that make no sense as defaults.
+const NOT_CONFIGURABLE: [&str; 4] = ["config", "no-config", "help", "version"];
+
+/// A config file and the arguments it contributed.
+pub struct LoadedConfig {
+    pub path: PathBuf,
+    pub args: Vec<OsString>,
+}
+
+fn config_error(path: &Path, message: String) -> clap::Error {
+    Cli::command().error(
+        ErrorKind::InvalidValue,
+        format!("{}: {}", path.display(), message),
+    )
+}
+
+/// The file to read: --config, else the file in the current directory if there is one.
+fn config_path(matches: &ArgMatches) -> Option<PathBuf> {
+    if matches.get_flag("no_config") {
+        return None;
+    }
+    if let Some(path) = matches.get_one::<PathBuf>("config") {
+        return Some(path.clone());
+    }
+    let default = PathBuf::from(CONFIG_FILE);
+    default.is_file().then_some(default)
+}
+
+/// The arguments one key stands for, e.g. `--top-n=25`, one per value of an array.
+fn key_args(
+    path: &Path,
+    flag: &clap::Arg,
+    key: &str,
+    value: &toml::Value,
+) -> Result<Vec<OsString>, clap::Error> {
+    let name = format!("--{}", key);
+    if !flag.get_action().takes_values() {
+        return match value {
+            toml::Value::Boolean(true) => Ok(vec![name.into()]),
+            toml::Value::Boolean(false) => Ok(Vec::new()),
+            _ => Err(config_error(
+                path,
+                format!("'{}' is a switch and takes true or false", key),
+            )),
+        };
+    }
+    let values = match value {
+        toml::Value::Array(values) => values.as_slice(),
+        value => std::slice::from_ref(value),
+    };
+    let mut args = Vec::new();
+    for value in values {
+        let text = match value {
+            toml::Value::String(text) => text.clone(),
+            toml::Value::Integer(number) => number.to_string(),
+            toml::Value::Float(number) => number.to_string(),
+            toml::Value::Boolean(switch) => switch.to_string(),
+            _ => {
+                return Err(config_error(
+                    path,
+                    format!("'{}' takes a string, a number or an array of them", key),
+                ))
+            }
+        };
+        args.push(format!("{}={}", name, text).into());
+    }
+    Ok(args)
+}
+
+/// Whether clap rejects `a` and `b` together, whichever of them declares the conflict.
+fn conflicting(command: &clap::Command, a: &clap::Arg, b: &clap::Arg) -> bool {
+    let declares = |arg: &clap::Arg, other: &clap::Arg| {
+        command
+            .get_arg_conflicts_with(arg)
+            .iter()
+            .any(|conflict| conflict.get_id() == other.get_id())
+    };
+    declares(a, b) || declares(b, a)
+}
+
+/// Turns the config file's keys into arguments, leaving out the flags the command line sets
+/// and those that conflict with them.
+fn config_args(path: &Path, matches: &ArgMatches) -> Result<Vec<OsString>, clap::Error> {
+    let content = fs::read_to_string(path).map_err(|err| {
+        Cli::command().error(
+            ErrorKind::Io,
+            format!("cannot read {}: {}", path.display(), err),
+        )
+    })?;
+    let table: toml::Table = content
+        .parse()
+        .map_err(|err: toml::de::Error| config_error(path, err.message().to_string()))?;
+
+    let mut cli = Cli::command();
+    cli.build();
+    // `analyze` takes every flag as its own, the other subcommands only the shared ones from
+    // the parent.
+    let analyze = matches.subcommand_matches("analyze");
+    let subcommand = analyze.is_none() && matches.subcommand_name().is_some();
+    let command = match analyze {
+        Some(_) => cli.find_subcommand("analyze").expect("analyze is a subcommand"),
+        None => &cli,
+    };
+    let matches = analyze.unwrap_or(matches);
+    let given: Vec<&clap::Arg> = command
+        .get_arguments()
+        .filter(|arg| matches.value_source(arg.get_id().as_str()) == Some(ValueSource::CommandLine))
+        .collect();
+    let mut args = Vec::new();
+    for (key, value) in &table {
+        let flag = command
+            .get_arguments()
+            .find(|arg| arg.get_long() == Some(key.as_str()))
+            .filter(|_| !NOT_CONFIGURABLE.contains(&key.as_str()));
+        let Some(flag) = flag else {
+            let hint = if key.contains('_') {
+                format!(" (flag names use dashes: '{}')", key.replace('_', "-"))
+            } else {
+                String::new()
+            };
+            return Err(config_error(path, format!("unknown key '{}'{}", key, hint)));
+        };
+        if subcommand && !flag.is_global_set() {
+            continue;
+        }
+        // Checked before use, since a bad value is an error even when the flag overrides it.
+        let flag_args = key_args(path, flag, key, value)?;
+        let overridden = given
+            .iter()
+            .any(|&arg| arg.get_id() == flag.get_id() || conflicting(command, flag, arg));
+        if !overridden {
+            args.extend(flag_args);
+        }
+    }
+    Ok(args)
+}
+
+/// Puts the config file's defaults in front of the command-line arguments `args` (program
+/// name first) or right after their subcommand, returning the file that was read, if any.
+pub fn apply_config(args: &mut Vec<OsString>) -> Option<LoadedConfig> {
+    // Errors and --help are left to the real parse.
+    let matches = Cli::command().try_get_matches_from(args.iter()).ok()?;
+    let path = config_path(&matches)?;
+    match config_args(&path, &matches) {
+        Ok(defaults) => {
+            // After a subcommand, since a flag in front of it would make it a log path.
+            let at = if matches.subcommand_name().is_some() { 2 } else { 1 };
+            args.splice(at..at, defaults.iter().cloned());
+            // The command line parsed alone, so a value clap rejects came from the file.
+            if let Err(err) = Cli::command().try_get_matches_from(args.iter()) {
+                let rendered = err.to_string();
+                let message = rendered.lines().next().unwrap_or_default();
+                let message = message.strip_prefix("error: ").unwrap_or(message);
+                config_error(&path, message.to_string()).exit();
+            }
+            Some(LoadedConfig {
+                path,
+                args: defaults,
+            })
+        }
+        Err(err) => err.exit(),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::cli::Command;
+    use clap::Parser;
+
+    /// Parses `args` after the defaults of a config file holding `config`.
+    fn parse(test: &str, config: &str, args: &[&str]) -> Cli {
+        let path = std::env::temp_dir().join(format!("{}-{}.toml", test, std::process::id()));
+        fs::write(&path, config).unwrap();
+        let mut argv: Vec<OsString> = vec!["bzl-exec-log-analyzer".into(), "--config".into()];
+        argv.push(path.clone().into());
+        argv.extend(args.iter().map(OsString::from));
+        argv.push("build.log".into());
+        apply_config(&mut argv);
+        let _ = fs::remove_file(&path);
+        Cli::try_parse_from(argv).unwrap()
+    }
+
+    #[test]
+    fn the_config_file_overrides_defaults_and_flags_override_it() {
+        assert_eq!(parse("default", "", &[]).top_n, 10);
+        assert_eq!(parse("config", "top-n = 25", &[]).top_n, 25);
+        assert_eq!(parse("flag", "top-n = 25", &["--top-n", "5"]).top_n, 5);
+    }
+
+    #[test]
+    fn a_flag_replaces_every_value_of_a_list() {
+        let cli = parse(
+            "list",
+            "ignore-mnemonic = [\"Genrule\", \"Javac\"]",
+            &["--ignore-mnemonic", "CppLink"],
+        );
+        assert_eq!(cli.ignore_mnemonic, ["CppLink"]);
+    }
+
+    #[test]
+    fn a_flag_drops_the_config_flags_it_conflicts_with() {
+        let cli = parse("conflict", "cacheable-only = true", &["--uncacheable-only"]);
+        assert!(cli.uncacheable_only);
+        assert!(!cli.cacheable_only);
+
+        let cli = parse("no_conflict", "cacheable-only = true", &["--exclude-failed"]);
+        assert!(cli.cacheable_only && cli.exclude_failed);
+    }
+
+    #[test]
+    fn subcommands_take_the_config_file_behind_them() {
+        let path = std::env::temp_dir().join(format!("subcommand-{}.toml", std::process::id()));
+        fs::write(&path, "top-n = 25\nexclude-failed = true").unwrap();
+        let parse = |args: &[&str]| {
+            let mut argv: Vec<OsString> = vec!["bzl-exec-log-analyzer".into()];
+            argv.extend(args.iter().map(OsString::from));
+            argv.extend(["--config".into(), path.clone().into_os_string()]);
+            apply_config(&mut argv);
+            Cli::try_parse_from(argv).unwrap()
+        };
+        let cli = parse(&["analyze", "--top-n", "5", "build.log"]);
+        let Some(Command::Analyze(analyze)) = &cli.command else {
+            panic!("not parsed as analyze");
+        };
+        assert_eq!(analyze.options.top_n, 5);
+        assert!(cli.exclude_failed);
+        let cli = parse(&["analyze", "build.log"]);
+        let Some(Command::Analyze(analyze)) = &cli.command else {
+            panic!("not parsed as analyze");
+        };
+        assert_eq!(analyze.options.top_n, 25);
+
+        // diff takes the shared flag only.
+        let cli = parse(&["diff", "old.log", "new.log"]);
+        assert!(matches!(cli.command, Some(Command::Diff(_))) && cli.exclude_failed);
+        let _ = fs::remove_file(&path);
+    }
+}
@@ -0,0 +1,342 @@
+//! Detection of spawns that re-executed the same action, and collapsing them.
+//!
+//! Flaky-test reruns and retried remote executions leave several records for one logical
+//! action. Two spawns are the same action when they share the label, the mnemonic and the
+//! exact set of listed outputs; test shards of one target list different outputs and so stay
+//! apart. Spawns without listed outputs cannot be told apart and are never merged.
+//!
+//! The logs of a build split across several invocations record shared actions once per log;
+//! `merge_logs` keeps them once across logs.
+
+use crate::classify::{is_cache_hit, is_failed};
+use crate::cli::DedupeMode;
+use crate::metrics::total_time;
+use crate::proto::SpawnExec;
+use std::collections::hash_map::Entry;
+use std::collections::{HashMap, HashSet};
+use std::time::Duration;
+
+/// One logical action that was executed more than once.
+pub struct DuplicateGroup {
+    pub label: String,
+    pub mnemonic: String,
+    pub executions: u64,
+    pub failed: u64,
+    pub total_time: Duration,
+    /// Time of all executions but the last one.
+    pub extra_time: Duration,
+}
+
+/// Returns the indices of the spawns of each action that appears more than once, in log
+/// order.
+fn repeated_actions(spawns: &[SpawnExec]) -> Vec<Vec<usize>> {
+    let mut actions: HashMap<(&str, &str, Vec<&str>), Vec<usize>> = HashMap::new();
+    for (index, spawn) in spawns.iter().enumerate() {
+        // Cache hits re-use a previous execution rather than repeat it.
+        if spawn.listed_outputs.is_empty() || is_cache_hit(spawn) {
+            continue;
+        }
+        let mut outputs: Vec<&str> = spawn.listed_outputs.iter().map(String::as_str).collect();
+        outputs.sort_unstable();
+        actions
+            .entry((spawn.target_label.as_str(), spawn.mnemonic.as_str(), outputs))
+            .or_default()
+            .push(index);
+    }
+    let mut repeated: Vec<Vec<usize>> = actions.into_values().filter(|i| i.len() > 1).collect();
+    repeated.sort_by_key(|indices| indices[0]);
+    repeated
+}
+
+/// Finds the actions executed more than once, sorted by the time lost to repeats.
+pub fn find_duplicates(spawns: &[SpawnExec]) -> Vec<DuplicateGroup> {
+    let mut groups: Vec<DuplicateGroup> = repeated_actions(spawns)
+        .into_iter()
+        .map(|indices| {
+            let first = &spawns[indices[0]];
+            let last = &spawns[*indices.last().unwrap()];
+            let total: Duration = indices.iter().map(|&i| total_time(&spawns[i])).sum();
+            DuplicateGroup {
+                label: first.target_label.clone(),
+                mnemonic: first.mnemonic.clone(),
+                executions: indices.len() as u64,
+                failed: indices.iter().filter(|&&i| is_failed(&spawns[i])).count() as u64,
+                total_time: total,
+                extra_time: total.saturating_sub(total_time(last)),
+            }
+        })
+        .collect();
+    groups.sort_by(|a, b| {
+        b.extra_time
+            .cmp(&a.extra_time)
+            .then_with(|| a.label.cmp(&b.label))
+            .then_with(|| a.mnemonic.cmp(&b.mnemonic))
+    });
+    groups
+}
+
+/// Keeps one spawn per repeated action as selected by `mode`, and returns the number of
+/// spawns dropped.
+pub fn dedupe(spawns: &mut Vec<SpawnExec>, mode: DedupeMode) -> usize {
+    let mut dropped = vec![false; spawns.len()];
+    for indices in repeated_actions(spawns) {
+        let kept = match mode {
+            DedupeMode::None => return 0,
+            DedupeMode::First => indices[0],
+            DedupeMode::Last => *indices.last().unwrap(),
+        };
+        for index in indices.into_iter().filter(|&i| i != kept) {
+            dropped[index] = true;
+        }
+    }
+    let mut index = 0;
+    spawns.retain(|_| {
+        index += 1;
+        !dropped[index - 1]
+    });
+    dropped.iter().filter(|d| **d).count()
+}
+
+/// How the outputs of one action compare across the logs that recorded it.
+#[derive(Clone, Copy, PartialEq, Eq, PartialOrd, Ord)]
+enum OutputComparison {
+    Identical,
+    /// At least one log recorded no output digests.
+    Unknown,
+    Different,
+}
+
+/// An action whose outputs differ between two of the merged logs.
+pub struct OutputMismatch {
+    pub label: String,
+    pub mnemonic: String,
+    /// The first output whose digest differs, or is missing from one of the logs.
+    pub path: String,
+    /// Positions of the two logs on the command line, counted from 1.
+    pub logs: (usize, usize),
+}
+
+/// What merging the logs of a sharded build found.
+pub struct MergeSummary {
+    pub logs: usize,
+    /// Actions recorded by more than one log.
+    pub overlapping: usize,
+    /// Overlapping actions whose outputs are identical in every log.
+    pub identical_outputs: usize,
+    /// Overlapping actions without output digests in some log to compare.
+    pub uncompared: usize,
+    /// Spawns dropped because an earlier log recorded the same action.
+    pub dropped: usize,
+    pub mismatches: Vec<OutputMismatch>,
+}
+
+fn output_digests(spawn: &SpawnExec) -> Vec<(&str, &str)> {
+    let mut digests: Vec<(&str, &str)> = spawn
+        .actual_outputs
+        .iter()
+        .filter_map(|f| Some((f.path.as_str(), f.digest.as_ref()?.hash.as_str())))
+        .filter(|(_, hash)| !hash.is_empty())
+        .collect();
+    digests.sort_unstable();
+    digests
+}
+
+/// The first output path whose digest differs between two executions, if any.
+fn differing_output<'a>(a: &[(&'a str, &str)], b: &[(&'a str, &str)]) -> Option<&'a str> {
+    let b: HashMap<&str, &str> = b.iter().copied().collect();
+    let a_paths: Vec<&str> = a.iter().map(|(path, _)| *path).collect();
+    a.iter()
+        .find(|(path, hash)| b.get(path) != Some(hash))
+        .map(|(path, _)| *path)
+        .or_else(|| b.keys().copied().filter(|p| !a_paths.contains(p)).min())
+}
+
+fn action_digest(spawn: &SpawnExec) -> Option<&str> {
+    spawn
+        .digest
+        .as_ref()
+        .map(|d| d.hash.as_str())
+        .filter(|hash| !hash.is_empty())
+}
+
+/// Concatenates the logs of one build split across several invocations, keeping each action
+/// recorded by more than one log only from the first log that has it.
+///
+/// An action is identified as by --dedupe, and additionally by its action digest when both
+/// spawns record one. Repeats within a single log are kept, as --dedupe handles them.
+pub fn merge_logs(logs: Vec<Vec<SpawnExec>>) -> (Vec<SpawnExec>, MergeSummary) {
+    let log_count = logs.len();
+    // The log and index of the first spawn of each action. The keys borrow from the spawns,
+    // which are only moved into the merged list once every repeat is known.
+    let mut first_seen: HashMap<(&str, &str, Vec<&str>), (usize, usize)> = HashMap::new();
+    let mut overlaps: HashMap<(usize, usize), OutputComparison> = HashMap::new();
+    let mut mismatches = Vec::new();
+    let mut dropped: HashSet<(usize, usize)> = HashSet::new();
+
+    for (log, spawns) in logs.iter().enumerate() {
+        for (position, spawn) in spawns.iter().enumerate() {
+            if spawn.listed_outputs.is_empty() {
+                continue;
+            }
+            let mut outputs: Vec<&str> = spawn.listed_outputs.iter().map(String::as_str).collect();
+            outputs.sort_unstable();
+            let key = (spawn.target_label.as_str(), spawn.mnemonic.as_str(), outputs);
+            let (first_log, index) = match first_seen.entry(key) {
+                Entry::Occupied(first) if first.get().0 != log => *first.get(),
+                Entry::Occupied(_) => continue,
+                Entry::Vacant(first) => {
+                    first.insert((log, position));
+                    continue;
+                }
+            };
+            let kept = &logs[first_log][index];
+            if let (Some(a), Some(b)) = (action_digest(kept), action_digest(spawn)) {
+                if a != b {
+                    continue;
+                }
+            }
+
+            dropped.insert((log, position));
+            let (kept_outputs, outputs) = (output_digests(kept), output_digests(spawn));
+            let comparison = if kept_outputs.is_empty() || outputs.is_empty() {
+                OutputComparison::Unknown
+            } else if let Some(path) = differing_output(&kept_outputs, &outputs) {
+                if overlaps.get(&(first_log, index)) != Some(&OutputComparison::Different) {
+                    mismatches.push(OutputMismatch {
+                        label: kept.target_label.clone(),
+                        mnemonic: kept.mnemonic.clone(),
+                        path: path.to_string(),
+                        logs: (first_log + 1, log + 1),
+                    });
+                }
+                OutputComparison::Different
+            } else {
+                OutputComparison::Identical
+            };
+            let overlap = overlaps.entry((first_log, index)).or_insert(comparison);
+            *overlap = (*overlap).max(comparison);
+        }
+    }
+
+    let merged = logs
+        .into_iter()
+        .enumerate()
+        .flat_map(|(log, spawns)| {
+            let dropped = &dropped;
+            spawns
+                .into_iter()
+                .enumerate()
+                .filter(move |(position, _)| !dropped.contains(&(log, *position)))
+                .map(|(_, spawn)| spawn)
+        })
+        .collect();
+    let summary = MergeSummary {
+        logs: log_count,
+        overlapping: overlaps.len(),
+        identical_outputs: overlaps
+            .values()
+            .filter(|c| **c == OutputComparison::Identical)
+            .count(),
+        uncompared: overlaps
+            .values()
+            .filter(|c| **c == OutputComparison::Unknown)
+            .count(),
+        dropped: dropped.len(),
+        mismatches,
+    };
+    (merged, summary)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::testing::spawn;
+
+    /// An execution of `label` listing `outputs` that took `millis`.
+    fn execution(label: &str, outputs: &[&str], millis: u64) -> SpawnExec {
+        SpawnExec {
+            target_label: label.to_string(),
+            listed_outputs: outputs.iter().map(|output| output.to_string()).collect(),
+            ..spawn("TestRunner", "linux-sandbox", millis)
+        }
+    }
+
+    /// A flaky test that failed twice before passing, and another target run once.
+    fn reruns() -> Vec<SpawnExec> {
+        let mut flaky = execution("//app:test", &["test.log", "test.xml"], 3_000);
+        flaky.exit_code = 1;
+        vec![
+            flaky.clone(),
+            execution("//app:other_test", &["other.log"], 500),
+            flaky,
+            // Listed in another order, which does not make it another action.
+            execution("//app:test", &["test.xml", "test.log"], 2_000),
+        ]
+    }
+
+    #[test]
+    fn executions_of_one_action_form_one_group() {
+        let groups = find_duplicates(&reruns());
+        assert_eq!(groups.len(), 1);
+        let group = &groups[0];
+        assert_eq!((group.label.as_str(), group.mnemonic.as_str()), ("//app:test", "TestRunner"));
+        assert_eq!(group.executions, 3);
+        assert_eq!(group.failed, 2);
+        assert_eq!(group.total_time, Duration::from_secs(8));
+        assert_eq!(group.extra_time, Duration::from_secs(6), "all but the last execution");
+    }
+
+    #[test]
+    fn test_shards_with_different_outputs_are_not_duplicates() {
+        let shards: Vec<SpawnExec> = (1..=4)
+            .map(|shard| format!("shard_{}_of_4/test.log", shard))
+            .map(|output| execution("//app:test", &[&output], 1_000))
+            .collect();
+        assert!(find_duplicates(&shards).is_empty());
+    }
+
+    #[test]
+    fn spawns_without_outputs_and_cache_hits_are_never_duplicates() {
+        let mut hit = execution("//app:test", &["test.log"], 10);
+        hit.runner = "remote cache hit".to_string();
+        let log = [
+            execution("//app:gen", &[], 1_000),
+            execution("//app:gen", &[], 1_000),
+            execution("//app:test", &["test.log"], 1_000),
+            hit,
+        ];
+        assert!(find_duplicates(&log).is_empty());
+    }
+
+    #[test]
+    fn dedupe_keeps_the_first_or_last_execution_or_every_one() {
+        let mut first = reruns();
+        assert_eq!(dedupe(&mut first, DedupeMode::First), 2);
+        let kept: Vec<(&str, i32, Duration)> = first
+            .iter()
+            .map(|s| (s.target_label.as_str(), s.exit_code, total_time(s)))
+            .collect();
+        assert_eq!(
+            kept,
+            [
+                ("//app:test", 1, Duration::from_secs(3)),
+                ("//app:other_test", 0, Duration::from_millis(500)),
+            ]
+        );
+
+        let mut last = reruns();
+        assert_eq!(dedupe(&mut last, DedupeMode::Last), 2);
+        assert_eq!(last[1].target_label, "//app:test");
+        assert_eq!((last[1].exit_code, total_time(&last[1])), (0, Duration::from_secs(2)));
+
+        // The dropped executions' time leaves the totals: 6s keeping the last, 5s the first.
+        let total = |spawns: &[SpawnExec]| spawns.iter().map(total_time).sum::<Duration>();
+        assert_eq!(total(&reruns()) - total(&last), Duration::from_secs(6));
+        assert_eq!(total(&reruns()) - total(&first), Duration::from_secs(5));
+
+        let mut every = reruns();
+        assert_eq!(dedupe(&mut every, DedupeMode::None), 0);
+        assert_eq!(every.len(), 4);
+        assert!(find_duplicates(&first).is_empty() && find_duplicates(&last).is_empty());
+    }
+}
@@ -0,0 +1,98 @@
+//! Compact sets of content digests, for deduplicating files across a log.
+
+use crate::proto::Digest;
+use std::collections::HashSet;
+use std::hash::{DefaultHasher, Hash, Hasher};
+
+/// A digest packed into a fixed-size key instead of its hex string.
+///
+/// Hex hashes of up to 64 characters (SHA-256, BLAKE3, SHA-1, ...) are stored exactly;
+/// longer ones are truncated to their first 32 bytes, and non-hex hashes are hashed.
+#[derive(Clone, Copy, PartialEq, Eq, Hash)]
+pub struct DigestKey {
+    hash: [u8; 32],
+    size_bytes: i64,
+}
+
+impl DigestKey {
+    pub fn new(digest: &Digest) -> Self {
+        let mut hash = [0u8; 32];
+        let hex = digest.hash.as_bytes();
+        let decoded = hex.len() % 2 == 0
+            && hex
+                .chunks(2)
+                .zip(hash.iter_mut())
+                .all(|(pair, byte)| match (hex_value(pair[0]), hex_value(pair[1])) {
+                    (Some(high), Some(low)) => {
+                        *byte = high << 4 | low;
+                        true
+                    }
+                    _ => false,
+                });
+        if !decoded {
+            hash = [0u8; 32];
+            let mut hasher = DefaultHasher::new();
+            digest.hash.hash(&mut hasher);
+            hash[..8].copy_from_slice(&hasher.finish().to_le_bytes());
+            // Keeps hashed keys apart from decoded ones that happen to share a prefix.
+            hash[31] = 0xff;
+        }
+        DigestKey {
+            hash,
+            size_bytes: digest.size_bytes,
+        }
+    }
+}
+
+fn hex_value(c: u8) -> Option<u8> {
+    (c as char).to_digit(16).map(|d| d as u8)
+}
+
+/// A set of unique digests and the sum of their sizes.
+#[derive(Default)]
+pub struct DigestSet {
+    keys: HashSet<DigestKey>,
+    bytes: i64,
+}
+
+impl DigestSet {
+    /// Adds a digest, returning true if it had not been seen before.
+    pub fn insert(&mut self, digest: &Digest) -> bool {
+        let inserted = self.keys.insert(DigestKey::new(digest));
+        if inserted {
+            self.bytes += digest.size_bytes;
+        }
+        inserted
+    }
+
+    pub fn contains(&self, digest: &Digest) -> bool {
+        self.keys.contains(&DigestKey::new(digest))
+    }
+
+    pub fn len(&self) -> usize {
+        self.keys.len()
+    }
+
+    pub fn is_empty(&self) -> bool {
+        self.keys.is_empty()
+    }
+
+    /// Total size of the unique digests.
+    pub fn bytes(&self) -> i64 {
+        self.bytes
+    }
+
+    /// Number and total size of the digests present in both sets.
+    pub fn overlap(&self, other: &DigestSet) -> (usize, i64) {
+        let (small, large) = if self.len() <= other.len() {
+            (self, other)
+        } else {
+            (other, self)
+        };
+        small
+            .keys
+            .iter()
+            .filter(|key| large.keys.contains(key))
+            .fold((0, 0), |(count, bytes), key| (count + 1, bytes + key.size_bytes))
+    }
+}
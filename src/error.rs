@@ -1,3 +1,4 @@
+use std::path::{Path, PathBuf};
 use thiserror::Error;
 
 /// Define a convenient Result type
@@ -11,11 +12,96 @@ pub enum AppError {
     #[error("Protobuf decode error: {0}")]
     ProtobufDecode(#[from] prost::DecodeError),
 
+    #[error("JSON error: {0}")]
+    Json(#[from] serde_json::Error),
+
     #[error("Log parsing error: {0}")]
     LogParsing(String),
 
     #[error("Analysis error: {0}")]
     Analysis(String),
+
+    /// The log holds no spawns and --strict is set.
+    #[error("{0}")]
+    EmptyLog(String),
+
+    /// Reading or parsing one of the logs failed; the status and category are those of the
+    /// cause.
+    #[error("{}: {}", .0.display(), .1)]
+    InLog(PathBuf, Box<AppError>),
+
+    /// A CI threshold was not met, after the report was printed in full.
+    #[error("{1}")]
+    Gate(Gate, String),
+}
+
+/// Thresholds with a dedicated exit status, so that a pipeline can tell which one failed.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Gate {
+    /// --min-hit-rate or --min-hit-rate-weighted
+    HitRate,
+    /// --max-action-duration
+    ActionDuration,
+    /// --fail-on-action-failure
+    ActionFailure,
+    /// Any other check: the check and policy subcommands, --fail-if-uncacheable-time,
+    /// --verify-outputs and the --strict validations
+    Threshold,
+}
+
+impl Gate {
+    pub fn exit_code(self) -> u8 {
+        match self {
+            Gate::HitRate => 3,
+            Gate::ActionDuration => 4,
+            Gate::ActionFailure => 5,
+            Gate::Threshold => 6,
+        }
+    }
+
+    fn category(self) -> &'static str {
+        match self {
+            Gate::HitRate => "hit-rate",
+            Gate::ActionDuration => "action-duration",
+            Gate::ActionFailure => "action-failure",
+            Gate::Threshold => "check-failed",
+        }
+    }
+}
+
+impl AppError {
+    /// The process exit status for this error; 2 is taken by usage errors. The README lists
+    /// every status, so scripts may depend on them.
+    pub fn exit_code(&self) -> u8 {
+        match self {
+            AppError::Gate(gate, _) => gate.exit_code(),
+            AppError::InLog(_, cause) => cause.exit_code(),
+            AppError::Io(_) => 7,
+            AppError::ProtobufDecode(_) | AppError::Json(_) | AppError::LogParsing(_) => 8,
+            AppError::EmptyLog(_) => 9,
+            AppError::Analysis(_) => 1,
+        }
+    }
+
+    /// Short name of the failure class, printed with the message.
+    pub fn category(&self) -> &'static str {
+        match self {
+            AppError::Gate(gate, _) => gate.category(),
+            AppError::InLog(_, cause) => cause.category(),
+            AppError::Io(_) => "io",
+            AppError::ProtobufDecode(_) | AppError::Json(_) | AppError::LogParsing(_) => "parse",
+            AppError::EmptyLog(_) => "empty-log",
+            AppError::Analysis(_) => "analysis",
+        }
+    }
+
+    /// Names the log the error came from, unless it names one already.
+    pub fn in_log(self, path: &Path) -> Self {
+        match self {
+            AppError::InLog(..) => self,
+            cause => AppError::InLog(path.to_path_buf(), Box::new(cause)),
+        }
+    }
 }
 
 impl From<anyhow::Error> for AppError {
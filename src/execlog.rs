@@ -0,0 +1,1062 @@
+//! Reading Bazel execution logs: the compact format (`--execution_log_compact_file`) and the
+//! verbose binary one (`--execution_log_binary_file`), told apart by content rather than by
+//! file name.
+//!
+//! [`parse`] and [`read`] are for programs using the crate as a library: they return the
+//! spawns with the detected format and any warnings, and print nothing. [`Records`] yields the
+//! spawns one at a time instead, for logs too large to keep in memory; both of the others are
+//! built on it. The CLI reads files
+//! with [`parse_log_file`], which reports the format on stderr and the warnings through
+//! [`crate::warnings`].
+
+use crate::artifact::{self, HEAD_BYTES};
+use crate::parallel::map_in_order;
+use crate::parse_stats::{self, timed, LogStats, Stage};
+use crate::progress::Progress;
+use crate::proto::exec_log_entry::{self as compact, Type as CompactEntryType};
+use crate::proto::{ExecLogEntry, SpawnExec};
+use crate::unknown_fields::{self, UnknownFields};
+use crate::warnings::{self, Warning, WarningCode};
+use crate::{AppError, AppResult};
+use prost::Message;
+use std::collections::{HashMap, HashSet};
+use std::fs::File;
+use std::io::{self, BufReader, Read};
+use std::path::{Path, PathBuf};
+
+/// An enum to hold different types of compact log entries for reconstruction.
+enum StoredEntry {
+    File(compact::File),
+    Directory(compact::Directory),
+    UnresolvedSymlink(compact::UnresolvedSymlink),
+    InputSet(compact::InputSet),
+    RunfilesTree(compact::RunfilesTree),
+}
+
+/// Parses the log file, auto-detecting the format (compact or verbose).
+///
+/// Compact logs only get their spawn inputs reconstructed when `full_decode` is set, since
+/// expanding the input sets of every spawn is expensive on large logs.
+pub fn parse_log_file(path: &Path, full_decode: bool) -> AppResult<Vec<SpawnExec>> {
+    parse_log(
+        path,
+        Decoding::All {
+            inputs: full_decode,
+        },
+    )
+}
+
+/// Parses the log file again, keeping only the spawns `wanted` selects, with their inputs.
+///
+/// Input sets of a compact log are only expanded for the selected spawns, which keeps a
+/// second pass over a huge log cheap when few spawns are needed.
+pub fn parse_log_inputs(
+    path: &Path,
+    wanted: &dyn Fn(&SpawnExec) -> bool,
+) -> AppResult<Vec<SpawnExec>> {
+    parse_log(path, Decoding::Only(wanted))
+}
+
+/// The format a log was written in.
+#[derive(Clone, Copy, PartialEq, Eq, Debug)]
+pub enum LogFormat {
+    /// `--execution_log_compact_file`: zstd-compressed entries that refer to earlier ones.
+    Compact,
+    /// `--execution_log_binary_file`: one length-delimited `SpawnExec` per spawn.
+    Verbose,
+}
+
+/// The spawns of a log, in log order, and the problems that did not stop the parse, such as
+/// a truncated last record.
+pub struct ParsedLog {
+    pub format: LogFormat,
+    pub spawns: Vec<SpawnExec>,
+    pub warnings: Vec<Warning>,
+}
+
+/// Which spawns a parse returns, and whether their inputs are reconstructed.
+#[derive(Clone, Copy)]
+enum Decoding<'a> {
+    All { inputs: bool },
+    /// Every spawn, reduced as [`Detail::Slim`] describes.
+    Slim,
+    Only(&'a dyn Fn(&SpawnExec) -> bool),
+}
+
+/// Parses a log held in memory, detecting its format. Nothing is printed or reported; the
+/// warnings are left to the caller.
+///
+/// Compact logs only get their spawn inputs reconstructed when `full_decode` is set.
+pub fn parse(raw: &[u8], full_decode: bool) -> AppResult<ParsedLog> {
+    read(raw, full_decode)
+}
+
+/// Reads a whole log from `reader`, e.g. stdin or a socket, and parses it like [`parse`]. Use
+/// [`Records`] to go through the spawns without keeping them all.
+pub fn read(reader: impl Read, full_decode: bool) -> AppResult<ParsedLog> {
+    decode(
+        reader,
+        Decoding::All {
+            inputs: full_decode,
+        },
+        None,
+    )
+}
+
+fn decode(
+    reader: impl Read,
+    decoding: Decoding,
+    mut progress: Option<&mut Progress>,
+) -> AppResult<ParsedLog> {
+    let mut records = Records::with_decoding(reader, decoding)?;
+    let spawns = (&mut records)
+        .inspect(|_| {
+            if let Some(progress) = progress.as_deref_mut() {
+                progress.record();
+            }
+        })
+        .collect::<AppResult<Vec<_>>>()?;
+    // Another kind of file can decode into a few spawns of nonsense rather than fail. A
+    // second pass may well select none.
+    if !matches!(decoding, Decoding::Only(_))
+        && !spawns
+            .iter()
+            .any(|s| !s.mnemonic.is_empty() && !s.runner.is_empty())
+    {
+        if let Some(artifact) = artifact::detect(&records.head) {
+            return Err(AppError::LogParsing(artifact.message()));
+        }
+    }
+    let mut found = std::mem::take(&mut records.warnings);
+
+    // A second pass over the same log would repeat the warnings of the first.
+    if !matches!(decoding, Decoding::Only(_)) {
+        found.extend(warnings::check_spawns(&spawns));
+    } else {
+        found.clear();
+    }
+    Ok(ParsedLog {
+        format: records.format,
+        spawns,
+        warnings: found,
+    })
+}
+
+fn parse_log(path: &Path, decoding: Decoding) -> AppResult<Vec<SpawnExec>> {
+    parse_stats::time_parse(|| read_log(path, decoding)).map(|log| report_log(path, log))
+}
+
+/// Parses the file at `path` quietly, apart from the progress line.
+fn read_log(path: &Path, decoding: Decoding) -> AppResult<ParsedLog> {
+    let read = || {
+        let file = File::open(path)?;
+        let metadata = file.metadata()?;
+        let mut progress = Progress::new(path, metadata.is_file().then(|| metadata.len()));
+        decode(progress.reader(file), decoding, Some(&mut progress))
+    };
+    read().map_err(|err| err.in_log(path))
+}
+
+/// Prints the detected format and reports the warnings of a log read by [`read_log`].
+fn report_log(path: &Path, log: ParsedLog) -> Vec<SpawnExec> {
+    match log.format {
+        LogFormat::Compact => eprintln!("Detected zstd-compressed compact log format."),
+        LogFormat::Verbose => {
+            eprintln!("Could not parse as compact log. Falling back to verbose log format.")
+        }
+    }
+    for warning in log.warnings {
+        warnings::report(warning.path(path));
+    }
+    log.spawns
+}
+
+/// How much of each spawn [`parse_log_files`] keeps.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Detail {
+    /// Everything but the inputs of compact-log spawns, which are expensive to reconstruct.
+    Standard,
+    /// Everything, with the inputs of compact-log spawns reconstructed.
+    Full,
+    /// No inputs, command lines or environments, which take most of the memory of a parsed
+    /// log, for logs too large to hold otherwise (--max-memory).
+    Slim,
+}
+
+/// Parses each of `paths` like [`parse_log_file`] on up to `workers` threads. The results come
+/// back in the order of `paths`, and the formats and warnings are reported in that order too,
+/// once every log has been read.
+pub fn parse_log_files(
+    paths: &[PathBuf],
+    detail: Detail,
+    workers: usize,
+) -> Vec<AppResult<Vec<SpawnExec>>> {
+    let decoding = || match detail {
+        Detail::Standard => Decoding::All { inputs: false },
+        Detail::Full => Decoding::All { inputs: true },
+        Detail::Slim => Decoding::Slim,
+    };
+    parse_stats::time_parse(|| map_in_order(paths, workers, |path| read_log(path, decoding())))
+        .into_iter()
+        .zip(paths)
+        .map(|(log, path)| log.map(|log| report_log(path, log)))
+        .collect()
+}
+
+/// Roughly how much memory the spawns of the log at `path` take once parsed in full, from
+/// the size of the file: about 4 times that of a verbose log and 20 times that of a compact
+/// one, which is compressed. Only the first bytes are read, to tell the formats apart.
+pub fn estimated_memory(path: &Path) -> io::Result<u64> {
+    let mut file = File::open(path)?;
+    let size = file.metadata()?.len();
+    let mut magic = [0; 4];
+    let compact = file.read_exact(&mut magic).is_ok() && magic == ZSTD_MAGIC;
+    Ok(size.saturating_mul(if compact { 20 } else { 4 }))
+}
+
+/// The logs of `results` that were parsed, with their paths. A failure is the error, unless
+/// `skip_errors` (--skip-errors) is set: then the log is left out with a warning, and only
+/// the first failure is returned, when every log failed.
+pub fn keep_parsed<'p, T>(
+    results: impl IntoIterator<Item = (&'p Path, AppResult<T>)>,
+    skip_errors: bool,
+) -> AppResult<Vec<(&'p Path, T)>> {
+    let mut parsed = Vec::new();
+    let mut first_error = None;
+    for (path, result) in results {
+        match result {
+            Ok(log) => parsed.push((path, log)),
+            Err(err) if skip_errors => {
+                let cause = match &err {
+                    AppError::InLog(_, cause) => cause.to_string(),
+                    err => err.to_string(),
+                };
+                warnings::report(
+                    Warning::new(
+                        WarningCode::SkippedLog,
+                        format!("left out because of --skip-errors: {}", cause),
+                    )
+                    .path(path),
+                );
+                first_error.get_or_insert(err);
+            }
+            Err(err) => return Err(err),
+        }
+    }
+    match first_error {
+        Some(err) if parsed.is_empty() => Err(err),
+        _ => Ok(parsed),
+    }
+}
+
+/// Whether the length-delimited record at the start of `cursor` extends past its end, as the
+/// last record of a log that is still being written does.
+pub fn is_truncated(cursor: &[u8]) -> bool {
+    let mut rest = cursor;
+    prost::encoding::decode_varint(&mut rest).map_or(true, |len| len > rest.len() as u64)
+}
+
+/// Magic number that starts every zstd frame, and so every compact log.
+pub const ZSTD_MAGIC: [u8; 4] = [0x28, 0xb5, 0x2f, 0xfd];
+
+/// How far [`Records`] got with the next length-delimited record.
+enum Next {
+    /// The record is in the buffer.
+    Record,
+    End,
+    /// The log ended this many bytes into the record.
+    Truncated(u64),
+}
+
+/// The spawns of a log, decoded one at a time as it is read, so that the log is never held in
+/// memory as a whole. Compact logs are decompressed as a stream.
+///
+/// A record that does not decode yields an error and the iteration goes on with the next one,
+/// leaving it to the caller to skip it or stop; a read error ends the iteration. Warnings are
+/// collected along the way and are complete once the iterator has returned `None`.
+///
+/// ```no_run
+/// use bzl_exec_log_parser::execlog::Records;
+/// use std::fs::File;
+///
+/// let mut records = Records::new(File::open("exec.log")?, false)?;
+/// let (mut spawns, mut hits) = (0, 0);
+/// for spawn in &mut records {
+///     spawns += 1;
+///     hits += spawn?.cache_hit as usize;
+/// }
+/// let rate = hits as f64 / spawns.max(1) as f64 * 100.0;
+/// println!("{:?} log: {} spawns, {:.2}% cache hits", records.format(), spawns, rate);
+/// # Ok::<(), bzl_exec_log_parser::AppError>(())
+/// ```
+pub struct Records<'a> {
+    input: BufReader<Box<dyn Read + 'a>>,
+    format: LogFormat,
+    decoding: Decoding<'a>,
+    compact: CompactReader,
+    /// Decompressed bytes of the log consumed so far, which can be more than a `usize` holds
+    /// on 32-bit platforms.
+    offset: u64,
+    /// Records read, spawns or not.
+    records: usize,
+    buffer: Vec<u8>,
+    /// Only scanned for with --print-unknown-fields.
+    unknown_fields: Option<UnknownFields>,
+    /// Only collected with --parse-stats.
+    stats: Option<LogStats>,
+    /// The first bytes of a verbose log, for naming the file when it turns out to be no log.
+    head: Vec<u8>,
+    found_spawn: bool,
+    warnings: Vec<Warning>,
+    done: bool,
+}
+
+impl<'a> Records<'a> {
+    /// Detects the format from the first bytes of `reader` and prepares to decode it. Compact
+    /// logs only get their spawn inputs reconstructed when `full_decode` is set.
+    pub fn new(reader: impl Read + 'a, full_decode: bool) -> AppResult<Self> {
+        Records::with_decoding(
+            reader,
+            Decoding::All {
+                inputs: full_decode,
+            },
+        )
+    }
+
+    fn with_decoding(reader: impl Read + 'a, decoding: Decoding<'a>) -> AppResult<Self> {
+        let mut stats = parse_stats::enabled().then(LogStats::new);
+        let mut reader: Box<dyn Read + 'a> = match &stats {
+            Some(stats) => Box::new(stats.file_reader(reader)),
+            None => Box::new(reader),
+        };
+        let mut head = Vec::with_capacity(HEAD_BYTES);
+        (&mut reader)
+            .take(HEAD_BYTES as u64)
+            .read_to_end(&mut head)?;
+        let format = if head.starts_with(&ZSTD_MAGIC) {
+            LogFormat::Compact
+        } else {
+            LogFormat::Verbose
+        };
+        let kept_head = match format {
+            LogFormat::Compact => Vec::new(),
+            LogFormat::Verbose => head.clone(),
+        };
+        let whole = io::Cursor::new(head).chain(reader);
+        let input: Box<dyn Read + 'a> = match (format, &mut stats) {
+            (LogFormat::Compact, Some(stats)) => Box::new(
+                stats.decompressed_reader(zstd::stream::read::Decoder::new(whole)?),
+            ),
+            (LogFormat::Compact, None) => Box::new(zstd::stream::read::Decoder::new(whole)?),
+            (LogFormat::Verbose, _) => Box::new(whole),
+        };
+        Ok(Records {
+            input: BufReader::new(input),
+            format,
+            decoding,
+            compact: CompactReader::new(!matches!(
+                decoding,
+                Decoding::All { inputs: false } | Decoding::Slim
+            )),
+            offset: 0,
+            records: 0,
+            buffer: Vec::new(),
+            unknown_fields: unknown_fields::enabled().then(UnknownFields::default),
+            stats,
+            head: kept_head,
+            found_spawn: false,
+            warnings: Vec::new(),
+            done: false,
+        })
+    }
+
+    pub fn format(&self) -> LogFormat {
+        self.format
+    }
+
+    /// Problems found so far that did not stop the decoding, such as a truncated last record.
+    pub fn warnings(&self) -> &[Warning] {
+        &self.warnings
+    }
+
+    /// Reads the next length-delimited record into the buffer.
+    fn next_record(&mut self) -> io::Result<Next> {
+        let mut len = 0u64;
+        let mut header = 0u64;
+        loop {
+            let mut byte = [0];
+            match self.input.read(&mut byte) {
+                Ok(0) if header == 0 => return Ok(Next::End),
+                Ok(0) => return Ok(Next::Truncated(header)),
+                Ok(_) => {}
+                Err(err) if err.kind() == io::ErrorKind::Interrupted => continue,
+                Err(err) => return Err(err),
+            }
+            len |= u64::from(byte[0] & 0x7f) << (7 * header);
+            header += 1;
+            if byte[0] & 0x80 == 0 {
+                break;
+            }
+            if header == 10 {
+                return Err(io::Error::new(
+                    io::ErrorKind::InvalidData,
+                    "invalid record length",
+                ));
+            }
+        }
+        // Only possible on 32-bit platforms, where no record that long could be held anyway.
+        if usize::try_from(len).is_err() {
+            return Err(io::Error::new(
+                io::ErrorKind::InvalidData,
+                format!("record length {} does not fit in memory", len),
+            ));
+        }
+        self.buffer.clear();
+        (&mut self.input).take(len).read_to_end(&mut self.buffer)?;
+        let read = header + self.buffer.len() as u64;
+        if (self.buffer.len() as u64) < len {
+            return Ok(Next::Truncated(read));
+        }
+        self.offset += read;
+        Ok(Next::Record)
+    }
+
+    /// The spawn in the buffer, if it is one the decoding asks for.
+    fn decode_record(&mut self) -> Option<AppResult<SpawnExec>> {
+        let decoding = self.decoding;
+        let record = match self.format {
+            LogFormat::Verbose => match timed(&mut self.stats, Stage::Decode, || {
+                SpawnExec::decode(self.buffer.as_slice())
+            }) {
+                Ok(spawn) => match decoding {
+                    Decoding::Only(wanted) if !wanted(&spawn) => None,
+                    _ => Some(Ok(spawn)),
+                },
+                Err(e) => Some(Err(verbose_error(e))),
+            },
+            LogFormat::Compact => match timed(&mut self.stats, Stage::Decode, || {
+                ExecLogEntry::decode(self.buffer.as_slice())
+            }) {
+                Ok(entry) => timed(&mut self.stats, Stage::Reconstruct, || {
+                    self.compact.read(entry, |spawn| match decoding {
+                        Decoding::All { inputs } => Some(inputs),
+                        Decoding::Slim => Some(false),
+                        Decoding::Only(wanted) => wanted(spawn).then_some(true),
+                    })
+                })
+                .map(Ok),
+                Err(e) => Some(Err(e.into())),
+            },
+        };
+        match decoding {
+            Decoding::Slim => record.map(|spawn| spawn.map(slim)),
+            _ => record,
+        }
+    }
+
+    /// `err`, or when no spawn was read yet and the file is a known other kind, an error that
+    /// says which.
+    fn first_error(&self, err: AppError) -> AppError {
+        if self.found_spawn {
+            return err;
+        }
+        match artifact::detect(&self.head) {
+            Some(artifact) => AppError::LogParsing(artifact.message()),
+            None => err,
+        }
+    }
+
+    fn finish(&mut self) {
+        self.done = true;
+        if self.format == LogFormat::Compact {
+            self.warnings.extend(self.compact.unknown_entries_warning());
+        }
+        if let Some(unknown) = &self.unknown_fields {
+            self.warnings.extend(unknown.warnings());
+        }
+    }
+}
+
+/// Drops what [`Detail::Slim`] leaves out.
+fn slim(mut spawn: SpawnExec) -> SpawnExec {
+    spawn.inputs = Vec::new();
+    spawn.command_args = Vec::new();
+    spawn.environment_variables = Vec::new();
+    spawn
+}
+
+fn verbose_error(cause: impl std::fmt::Display) -> AppError {
+    AppError::LogParsing(format!("Failed to parse verbose protobuf message: {}. The log file might be corrupt or in the wrong format.", cause))
+}
+
+impl Iterator for Records<'_> {
+    type Item = AppResult<SpawnExec>;
+
+    fn next(&mut self) -> Option<Self::Item> {
+        while !self.done {
+            let start = self.offset;
+            match self.next_record() {
+                Ok(Next::Record) => {
+                    self.records += 1;
+                    if let Some(stats) = &mut self.stats {
+                        stats.records += 1;
+                    }
+                    if let Some(unknown) = &mut self.unknown_fields {
+                        match self.format {
+                            LogFormat::Verbose => unknown.scan_spawn(&self.buffer),
+                            LogFormat::Compact => unknown.scan_entry(&self.buffer),
+                        }
+                    }
+                    match self.decode_record() {
+                        Some(Ok(spawn)) => {
+                            self.found_spawn = true;
+                            if let Some(stats) = &mut self.stats {
+                                stats.spawns += 1;
+                            }
+                            return Some(Ok(spawn));
+                        }
+                        Some(Err(err)) => return Some(Err(self.first_error(err))),
+                        None => {}
+                    }
+                }
+                Ok(Next::End) => self.finish(),
+                // A log cut short in its first record is more likely not a log at all.
+                Ok(Next::Truncated(_)) if self.records == 0 => {
+                    self.finish();
+                    return Some(Err(self.first_error(match self.format {
+                        LogFormat::Verbose => verbose_error("the log ends within its first record"),
+                        LogFormat::Compact => AppError::LogParsing(
+                            "the compact log ends within its first entry".to_string(),
+                        ),
+                    })));
+                }
+                Ok(Next::Truncated(bytes)) => {
+                    self.finish();
+                    self.warnings.push(
+                        Warning::new(
+                            WarningCode::TruncatedRecord,
+                            format!(
+                                "the log ends in the middle of a record; its last {} bytes were ignored",
+                                bytes
+                            ),
+                        )
+                        .offset(start),
+                    );
+                }
+                // zstd stops at the end of what was written of a frame that was never finished.
+                Err(err)
+                    if err.kind() == io::ErrorKind::UnexpectedEof
+                        && self.format == LogFormat::Compact
+                        && self.records > 0 =>
+                {
+                    self.finish();
+                    self.warnings.push(
+                        Warning::new(
+                            WarningCode::TruncatedRecord,
+                            "the compressed log ends in the middle of a frame; the entries after \
+                             its last complete record were ignored"
+                                .to_string(),
+                        )
+                        .offset(start),
+                    );
+                }
+                // A record length that cannot be one, or corrupt compressed data, is a
+                // malformed log rather than a failed read.
+                Err(err) if err.kind() == io::ErrorKind::InvalidData => {
+                    self.done = true;
+                    return Some(Err(self.first_error(match self.format {
+                        LogFormat::Verbose => verbose_error(err),
+                        LogFormat::Compact => AppError::LogParsing(format!(
+                            "Failed to read compact log entry: {}",
+                            err
+                        )),
+                    })));
+                }
+                Err(err) => {
+                    self.done = true;
+                    return Some(Err(self.first_error(err.into())));
+                }
+            }
+        }
+        None
+    }
+}
+
+/// Reconstructs spawns from compact log entries read in log order, keeping the entries that
+/// later spawns refer to.
+pub struct CompactReader {
+    stored_entries: HashMap<u32, StoredEntry>,
+    // Compact digests omit the hash function; it is recorded once in the Invocation entry.
+    hash_function_name: String,
+    /// Input sets are only kept when some spawn's inputs are reconstructed.
+    keep_input_sets: bool,
+    unknown_entries: u64,
+}
+
+impl CompactReader {
+    pub fn new(keep_input_sets: bool) -> Self {
+        CompactReader {
+            stored_entries: HashMap::new(),
+            hash_function_name: String::new(),
+            keep_input_sets,
+            unknown_entries: 0,
+        }
+    }
+
+    /// Records one entry and returns the spawn it describes, if it is one. `expand` decides
+    /// from the spawn whether its inputs are reconstructed, or drops it with `None`.
+    fn read(
+        &mut self,
+        entry: ExecLogEntry,
+        expand: impl Fn(&SpawnExec) -> Option<bool>,
+    ) -> Option<SpawnExec> {
+        let id = entry.id;
+        let stored = match entry.r#type {
+            Some(CompactEntryType::Invocation(invocation)) => {
+                self.hash_function_name = invocation.hash_function_name;
+                return None;
+            }
+            Some(CompactEntryType::Spawn(s)) => {
+                let (input_set_id, tool_set_id) = (s.input_set_id, s.tool_set_id);
+                let mut spawn_exec = reconstruct_spawn_exec(s, &self.stored_entries);
+                if expand(&spawn_exec)? {
+                    spawn_exec.inputs =
+                        expand_input_set(input_set_id, tool_set_id, &self.stored_entries);
+                }
+                fill_hash_function_name(&mut spawn_exec, &self.hash_function_name);
+                return Some(spawn_exec);
+            }
+            Some(CompactEntryType::File(f)) if id != 0 => StoredEntry::File(f),
+            Some(CompactEntryType::Directory(d)) if id != 0 => StoredEntry::Directory(d),
+            Some(CompactEntryType::UnresolvedSymlink(l)) if id != 0 => {
+                StoredEntry::UnresolvedSymlink(l)
+            }
+            Some(CompactEntryType::InputSet(i)) if id != 0 && self.keep_input_sets => {
+                StoredEntry::InputSet(i)
+            }
+            Some(CompactEntryType::RunfilesTree(r)) if id != 0 && self.keep_input_sets => {
+                StoredEntry::RunfilesTree(r)
+            }
+            None => {
+                self.unknown_entries += 1;
+                return None;
+            }
+            // Ignore other entry types for now as they are not needed for the analysis.
+            _ => return None,
+        };
+        self.stored_entries.insert(id, stored);
+        None
+    }
+
+    /// Records one entry and returns the spawn it describes, with its inputs when input sets
+    /// are kept.
+    pub fn next_spawn(&mut self, entry: ExecLogEntry) -> Option<SpawnExec> {
+        let inputs = self.keep_input_sets;
+        self.read(entry, |_| Some(inputs))
+    }
+
+    /// A warning about the entries of unknown types read so far, if there were any.
+    pub fn unknown_entries_warning(&self) -> Option<Warning> {
+        (self.unknown_entries > 0).then(|| {
+            Warning::new(
+                WarningCode::UnknownEntries,
+                format!(
+                    "skipped {} entries of an unknown type, perhaps written by a newer Bazel",
+                    self.unknown_entries
+                ),
+            )
+            .count(self.unknown_entries)
+        })
+    }
+}
+
+/// Sets the hash function name on every digest of a reconstructed spawn that lacks one.
+fn fill_hash_function_name(spawn: &mut SpawnExec, name: &str) {
+    if name.is_empty() {
+        return;
+    }
+    let files = spawn
+        .inputs
+        .iter_mut()
+        .chain(spawn.actual_outputs.iter_mut());
+    let digests = files
+        .filter_map(|f| f.digest.as_mut())
+        .chain(spawn.digest.as_mut());
+    for digest in digests.filter(|d| d.hash_function_name.is_empty()) {
+        digest.hash_function_name = name.to_string();
+    }
+}
+
+/// Returns the IDs of the entries contained in an input set, transitive sets first.
+///
+/// Each entry is returned once even if it is reachable through several sets.
+fn input_set_entries(set_id: u32, stored_entries: &HashMap<u32, StoredEntry>) -> Vec<u32> {
+    let mut entries = Vec::new();
+    let mut seen_entries = HashSet::new();
+    let mut seen_sets = HashSet::new();
+    // Iterative postorder traversal, as input sets can be nested very deeply.
+    let mut stack = vec![(set_id, false)];
+    while let Some((id, expanded)) = stack.pop() {
+        let Some(StoredEntry::InputSet(set)) = stored_entries.get(&id) else {
+            continue;
+        };
+        if expanded {
+            entries.extend(set.input_ids.iter().filter(|i| seen_entries.insert(**i)));
+        } else if seen_sets.insert(id) {
+            stack.push((id, true));
+            stack.extend(set.transitive_set_ids.iter().rev().map(|t| (*t, false)));
+        }
+    }
+    entries
+}
+
+/// Expands an input set into verbose `File` entries, one per file.
+///
+/// Directories contribute each contained file, and runfiles trees contribute the artifacts
+/// of their input set at their exec paths (custom symlinks are not resolved).
+fn expand_input_set(
+    set_id: u32,
+    tool_set_id: u32,
+    stored_entries: &HashMap<u32, StoredEntry>,
+) -> Vec<crate::proto::File> {
+    let tools: HashSet<u32> = input_set_entries(tool_set_id, stored_entries)
+        .into_iter()
+        .collect();
+    let mut inputs = Vec::new();
+    let mut pending = input_set_entries(set_id, stored_entries);
+    pending.reverse();
+    while let Some(id) = pending.pop() {
+        let is_tool = tools.contains(&id);
+        let file = |path: String, digest| crate::proto::File {
+            path,
+            digest,
+            symlink_target_path: String::new(),
+            is_tool,
+        };
+        match stored_entries.get(&id) {
+            Some(StoredEntry::File(f)) => inputs.push(file(f.path.clone(), f.digest.clone())),
+            Some(StoredEntry::Directory(d)) => {
+                for f in &d.files {
+                    inputs.push(file(format!("{}/{}", d.path, f.path), f.digest.clone()));
+                }
+            }
+            Some(StoredEntry::UnresolvedSymlink(l)) => inputs.push(crate::proto::File {
+                symlink_target_path: l.target_path.clone(),
+                ..file(l.path.clone(), None)
+            }),
+            Some(StoredEntry::RunfilesTree(r)) => {
+                let mut artifacts = input_set_entries(r.input_set_id, stored_entries);
+                artifacts.reverse();
+                pending.extend(artifacts);
+            }
+            Some(StoredEntry::InputSet(_)) | None => {}
+        }
+    }
+    inputs
+}
+
+/// Converts a compact `Spawn` entry into a verbose `SpawnExec` using stored file/dir info.
+///
+/// Inputs are left empty; `expand_input_set` reconstructs them when needed.
+fn reconstruct_spawn_exec(
+    spawn: compact::Spawn,
+    stored_entries: &HashMap<u32, StoredEntry>,
+) -> SpawnExec {
+    let mut actual_outputs = Vec::new();
+    let mut listed_outputs = Vec::new();
+    for output in spawn.outputs {
+        if let Some(compact::output::Type::InvalidOutputPath(path)) = &output.r#type {
+            listed_outputs.push(path.clone());
+        }
+        if let Some(compact::output::Type::OutputId(id)) = output.r#type {
+            if let Some(entry) = stored_entries.get(&id) {
+                match entry {
+                    StoredEntry::File(f) => {
+                        listed_outputs.push(f.path.clone());
+                        actual_outputs.push(crate::proto::File {
+                            path: f.path.clone(),
+                            digest: f.digest.clone(),
+                            symlink_target_path: String::new(),
+                            is_tool: false,
+                        });
+                    }
+                    StoredEntry::Directory(d) => {
+                        // The directory itself is kept as a digest-less entry followed by its
+                        // contents, which is how `metrics::directory_outputs` recognizes it.
+                        listed_outputs.push(d.path.clone());
+                        actual_outputs.push(crate::proto::File {
+                            path: d.path.clone(),
+                            digest: None,
+                            symlink_target_path: String::new(),
+                            is_tool: false,
+                        });
+                        for f in &d.files {
+                            actual_outputs.push(crate::proto::File {
+                                path: format!("{}/{}", d.path, f.path),
+                                digest: f.digest.clone(),
+                                symlink_target_path: String::new(),
+                                is_tool: false,
+                            });
+                        }
+                    }
+                    StoredEntry::UnresolvedSymlink(l) => {
+                        listed_outputs.push(l.path.clone());
+                        actual_outputs.push(crate::proto::File {
+                            path: l.path.clone(),
+                            digest: None,
+                            symlink_target_path: l.target_path.clone(),
+                            is_tool: false,
+                        });
+                    }
+                    _ => {}
+                }
+            }
+        }
+    }
+    SpawnExec {
+        command_args: spawn.args,
+        environment_variables: spawn.env_vars,
+        platform: spawn.platform,
+        inputs: vec![],
+        listed_outputs,
+        remotable: spawn.remotable,
+        cacheable: spawn.cacheable,
+        timeout_millis: spawn.timeout_millis,
+        mnemonic: spawn.mnemonic,
+        actual_outputs,
+        runner: spawn.runner,
+        cache_hit: spawn.cache_hit,
+        status: spawn.status,
+        exit_code: spawn.exit_code,
+        remote_cacheable: spawn.remote_cacheable,
+        target_label: spawn.target_label,
+        digest: spawn.digest,
+        metrics: spawn.metrics,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::testing::spawn;
+
+    /// A few spawns with labels and outputs, as both log formats can hold them.
+    fn build() -> Vec<SpawnExec> {
+        [
+            ("Javac", "//app:lib", "linux-sandbox", 4_200),
+            ("Javac", "//app:util", "remote cache hit", 300),
+            ("CppCompile", "//native:codec", "remote", 9_500),
+            ("CppLink", "//native:bin", "local", 2_300),
+        ]
+        .into_iter()
+        .map(|(mnemonic, label, runner, millis)| SpawnExec {
+            target_label: label.to_string(),
+            command_args: vec![mnemonic.to_lowercase(), format!("{}.src", label)],
+            listed_outputs: vec![format!("bazel-out/bin/{}.out", label)],
+            actual_outputs: vec![crate::proto::File {
+                path: format!("bazel-out/bin/{}.out", label),
+                ..Default::default()
+            }],
+            ..spawn(mnemonic, runner, millis)
+        })
+        .collect()
+    }
+
+    fn verbose_log(spawns: &[SpawnExec]) -> Vec<u8> {
+        spawns
+            .iter()
+            .flat_map(|spawn| spawn.encode_length_delimited_to_vec())
+            .collect()
+    }
+
+    /// The invocation, then for each spawn its output file and the spawn that refers to it.
+    fn compact_log(spawns: &[SpawnExec]) -> Vec<u8> {
+        let mut entries = vec![ExecLogEntry {
+            id: 0,
+            r#type: Some(CompactEntryType::Invocation(compact::Invocation {
+                hash_function_name: "SHA-256".to_string(),
+                ..Default::default()
+            })),
+        }];
+        for (i, spawn) in spawns.iter().enumerate() {
+            let output_id = i as u32 + 1;
+            entries.push(ExecLogEntry {
+                id: output_id,
+                r#type: Some(CompactEntryType::File(compact::File {
+                    path: spawn.listed_outputs[0].clone(),
+                    ..Default::default()
+                })),
+            });
+            entries.push(ExecLogEntry {
+                id: 0,
+                r#type: Some(CompactEntryType::Spawn(compact::Spawn {
+                    args: spawn.command_args.clone(),
+                    outputs: vec![compact::Output {
+                        r#type: Some(compact::output::Type::OutputId(output_id)),
+                    }],
+                    target_label: spawn.target_label.clone(),
+                    mnemonic: spawn.mnemonic.clone(),
+                    runner: spawn.runner.clone(),
+                    cache_hit: spawn.cache_hit,
+                    metrics: spawn.metrics.clone(),
+                    ..Default::default()
+                })),
+            });
+        }
+        let raw: Vec<u8> = entries
+            .iter()
+            .flat_map(|entry| entry.encode_length_delimited_to_vec())
+            .collect();
+        zstd::encode_all(raw.as_slice(), 0).unwrap()
+    }
+
+    fn messages(warnings: &[Warning]) -> Vec<(&str, Option<u64>)> {
+        warnings
+            .iter()
+            .map(|warning| (warning.message.as_str(), warning.offset))
+            .collect()
+    }
+
+    /// Parses `log` in memory and streams it through [`Records`], checking that both give the
+    /// same spawns and warnings, and returns the spawns and warnings of the parse.
+    fn parse_both_ways(log: &[u8], full_decode: bool) -> ParsedLog {
+        let parsed = parse(log, full_decode).unwrap();
+        let mut records = Records::new(io::Cursor::new(log.to_vec()), full_decode).unwrap();
+        let streamed: Vec<SpawnExec> = (&mut records).collect::<AppResult<_>>().unwrap();
+        assert_eq!(records.format(), parsed.format);
+        assert!(streamed == parsed.spawns, "the streamed spawns differ from the parsed ones");
+        // The in-memory parse adds the checks of the spawns as a whole.
+        let checks = warnings::check_spawns(&streamed);
+        let mut expected = messages(records.warnings());
+        expected.extend(messages(&checks));
+        assert_eq!(messages(&parsed.warnings), expected);
+        parsed
+    }
+
+    #[test]
+    fn verbose_logs_parse_the_same_in_memory_and_streamed() {
+        let log = parse_both_ways(&verbose_log(&build()), false);
+        assert_eq!(log.format, LogFormat::Verbose);
+        assert!(log.spawns == build());
+        assert!(log.warnings.is_empty());
+    }
+
+    #[test]
+    fn compact_logs_parse_the_same_in_memory_and_streamed() {
+        for full_decode in [false, true] {
+            let log = parse_both_ways(&compact_log(&build()), full_decode);
+            assert_eq!(log.format, LogFormat::Compact);
+            let labels: Vec<&str> = log.spawns.iter().map(|s| s.target_label.as_str()).collect();
+            assert_eq!(labels, ["//app:lib", "//app:util", "//native:codec", "//native:bin"]);
+            assert_eq!(log.spawns[2].listed_outputs, ["bazel-out/bin///native:codec.out"]);
+            assert!(log.spawns[1].cache_hit);
+        }
+    }
+
+    #[test]
+    fn truncated_logs_parse_the_same_in_memory_and_streamed() {
+        let verbose = verbose_log(&build());
+        let log = parse_both_ways(&verbose[..verbose.len() - 5], false);
+        assert_eq!(log.spawns.len(), 3);
+        assert_eq!(log.warnings.len(), 1);
+        assert_eq!(log.warnings[0].code, WarningCode::TruncatedRecord);
+
+        // Large enough for several zstd blocks, so that those before the cut decompress.
+        let many: Vec<SpawnExec> = (0..2_000).flat_map(|_| build()).collect();
+        let compact = compact_log(&many);
+        let log = parse_both_ways(&compact[..compact.len() - 8], false);
+        assert!(!log.spawns.is_empty() && log.spawns.len() < many.len());
+        assert!(log.warnings.iter().any(|w| w.code == WarningCode::TruncatedRecord));
+    }
+
+    /// A spawn that reads `inputs` files, each with a long path, to make a record of megabytes.
+    fn large_spawn(inputs: usize) -> SpawnExec {
+        SpawnExec {
+            inputs: (0..inputs)
+                .map(|i| crate::proto::File {
+                    path: format!("external/maven/v1/https/repo1.maven.org/artifact_{:06}.jar", i),
+                    ..Default::default()
+                })
+                .collect(),
+            ..build().remove(0)
+        }
+    }
+
+    #[test]
+    fn a_record_over_a_megabyte_parses_in_full() {
+        let large = large_spawn(30_000);
+        assert!(large.encoded_len() > 1 << 20);
+        let spawns = [build(), vec![large.clone()], build()].concat();
+        let log = parse_both_ways(&verbose_log(&spawns), false);
+        assert_eq!(log.spawns.len(), 9);
+        assert_eq!(log.spawns[4].inputs.len(), 30_000);
+        assert!(log.spawns[4] == large);
+        assert!(log.warnings.is_empty());
+
+        let mut args_spawn = build().remove(0);
+        args_spawn.command_args = vec!["-Xarg".repeat(1 << 18)];
+        let log = parse_both_ways(&compact_log(&[args_spawn.clone()]), false);
+        assert_eq!(log.spawns[0].command_args, args_spawn.command_args);
+    }
+
+    #[test]
+    fn a_last_record_ending_the_log_is_kept() {
+        // The log ends right after the last record, with nothing after it.
+        let spawns = [build(), vec![large_spawn(100)]].concat();
+        let verbose = verbose_log(&spawns);
+        let log = parse_both_ways(&verbose, false);
+        assert_eq!(log.spawns.len(), 5);
+        assert_eq!(log.spawns[4].inputs.len(), 100);
+        assert!(log.warnings.is_empty());
+
+        // Cut a byte short, the last record is reported, not dropped silently.
+        let log = parse_both_ways(&verbose[..verbose.len() - 1], false);
+        assert_eq!(log.spawns.len(), 4);
+        assert_eq!(log.warnings[0].code, WarningCode::TruncatedRecord);
+        // The warning points at the start of the record, before its two-byte length.
+        let last = spawns[4].encoded_len() as u64;
+        assert_eq!(log.warnings[0].offset, Some(verbose.len() as u64 - last - 2));
+    }
+
+    /// A record length prefix as a varint.
+    fn varint(value: u64) -> Vec<u8> {
+        let mut bytes = Vec::new();
+        prost::encoding::encode_varint(value, &mut bytes);
+        bytes
+    }
+
+    #[test]
+    fn offsets_past_two_and_four_gigabytes_are_reported_exactly() {
+        let verbose = verbose_log(&build());
+        let complete = verbose_log(&build()[..3]).len() as u64;
+        for already_read in [3 << 31, 5 << 32] {
+            let mut records = Records::new(&verbose[..verbose.len() - 5], false).unwrap();
+            // As if that much of the log had been read before these records.
+            records.offset = already_read;
+            assert_eq!((&mut records).filter_map(Result::ok).count(), 3);
+            assert_eq!(records.warnings()[0].offset, Some(already_read + complete));
+        }
+    }
+
+    #[test]
+    fn a_length_past_the_end_of_the_log_is_a_truncated_record() {
+        let mut log = verbose_log(&build());
+        let complete = log.len();
+        // A length no log could hold, with a few bytes of the record.
+        log.extend(varint(1 << 62));
+        log.extend([0x0a, 0x01, 0x41]);
+        assert!(is_truncated(&log[complete..]));
+        let log = parse_both_ways(&log, false);
+        assert_eq!(log.spawns.len(), 4);
+        assert_eq!(log.warnings[0].offset, Some(complete as u64));
+        assert!(log.warnings[0].message.contains("its last 12 bytes were ignored"));
+    }
+
+    #[test]
+    fn a_length_prefix_longer_than_a_varint_is_an_error() {
+        let mut log = verbose_log(&build());
+        log.extend([0xff; 11]);
+        let err = parse(&log, false).err().unwrap();
+        assert!(err.to_string().contains("invalid record length"), "{}", err);
+    }
+}
@@ -0,0 +1,534 @@
+//! Spawn filtering: [`Predicate`]s that test one spawn each and compose with `and`, `or` and
+//! `!`, and the [`SpawnFilter`] the command-line flags build from them.
+
+use crate::classify::{is_cache_hit, is_failed};
+use crate::cli::Cli;
+use crate::metrics::total_time;
+use crate::proto::SpawnExec;
+use std::ops::Not;
+use std::time::Duration;
+
+/// A test on one spawn, combined with others by `and`, `or` and `!`. Patterns are matched
+/// with [`wildcard_match`].
+///
+/// The aggregations of [`crate::analysis`] take any iterator of spawns, so a predicate is
+/// applied before the metrics are computed:
+///
+/// ```no_run
+/// use bzl_exec_log_parser::analysis::summarize;
+/// use bzl_exec_log_parser::filter::Predicate;
+/// use std::time::Duration;
+///
+/// let log = bzl_exec_log_parser::execlog::read(std::io::stdin(), false)?;
+/// let slow_compiles = Predicate::mnemonic("CppCompile")
+///     .and(Predicate::min_duration(Duration::from_secs(10)))
+///     .and(!Predicate::cache_hit());
+/// let summary = summarize(log.spawns.iter().filter(|s| slow_compiles.matches(s)));
+/// println!("{} slow compiles missed the cache", summary.actions);
+/// # Ok::<(), bzl_exec_log_parser::AppError>(())
+/// ```
+pub struct Predicate(Box<dyn Fn(&SpawnExec) -> bool + Send + Sync>);
+
+impl Predicate {
+    pub fn new(test: impl Fn(&SpawnExec) -> bool + Send + Sync + 'static) -> Self {
+        Predicate(Box::new(test))
+    }
+
+    /// Matches every spawn.
+    pub fn any() -> Self {
+        Predicate::new(|_| true)
+    }
+
+    pub fn mnemonic(pattern: impl Into<String>) -> Self {
+        let pattern = pattern.into();
+        Predicate::new(move |spawn| wildcard_match(&pattern, &spawn.mnemonic))
+    }
+
+    /// Spawns whose target label matches `pattern`, a wildcard such as `//app/*:*_test`
+    /// rather than a regular expression.
+    pub fn label(pattern: impl Into<String>) -> Self {
+        let pattern = pattern.into();
+        Predicate::new(move |spawn| wildcard_match(&pattern, &spawn.target_label))
+    }
+
+    pub fn runner(pattern: impl Into<String>) -> Self {
+        let pattern = pattern.into();
+        Predicate::new(move |spawn| wildcard_match(&pattern, &spawn.runner))
+    }
+
+    /// Spawns whose environment sets `name` to a value matching `pattern`.
+    pub fn env(name: impl Into<String>, pattern: impl Into<String>) -> Self {
+        let (name, pattern) = (name.into(), pattern.into());
+        Predicate::new(move |spawn| {
+            spawn
+                .environment_variables
+                .iter()
+                .any(|var| var.name == name && wildcard_match(&pattern, &var.value))
+        })
+    }
+
+    /// Spawns whose total time is at least `min`; those without one count as zero.
+    pub fn min_duration(min: Duration) -> Self {
+        Predicate::new(move |spawn| total_time(spawn) >= min)
+    }
+
+    pub fn cache_hit() -> Self {
+        Predicate::new(is_cache_hit)
+    }
+
+    pub fn failed() -> Self {
+        Predicate::new(is_failed)
+    }
+
+    pub fn cacheable() -> Self {
+        Predicate::new(|spawn| spawn.cacheable)
+    }
+
+    pub fn remotable() -> Self {
+        Predicate::new(|spawn| spawn.remotable)
+    }
+
+    pub fn and(self, other: Predicate) -> Self {
+        Predicate::new(move |spawn| self.matches(spawn) && other.matches(spawn))
+    }
+
+    pub fn or(self, other: Predicate) -> Self {
+        Predicate::new(move |spawn| self.matches(spawn) || other.matches(spawn))
+    }
+
+    pub fn matches(&self, spawn: &SpawnExec) -> bool {
+        (self.0)(spawn)
+    }
+}
+
+impl Not for Predicate {
+    type Output = Predicate;
+
+    fn not(self) -> Predicate {
+        Predicate::new(move |spawn| !self.matches(spawn))
+    }
+}
+
+impl Default for Predicate {
+    fn default() -> Self {
+        Predicate::any()
+    }
+}
+
+/// Selects the subset of spawns that the reports are computed over.
+#[derive(Default)]
+pub struct SpawnFilter {
+    cacheable: Option<bool>,
+    remotable: Option<bool>,
+    exclude_failed: bool,
+    /// The cacheable and remotable conditions.
+    fields: Predicate,
+}
+
+/// Whole-log context for a filtered report.
+pub struct FilterSummary {
+    /// Human-readable description of the active filters.
+    pub description: String,
+    /// The active filters, one phrase each, as in the description.
+    pub predicates: Vec<&'static str>,
+    pub total_actions: usize,
+    pub total_duration: Duration,
+    pub total_cache_hits: usize,
+    /// Matching spawns whose match rests on a filtered field that no spawn of this log sets,
+    /// which older Bazel versions do not record: all of them when there is such a field.
+    pub guessed_actions: usize,
+    /// The fields that were filtered on but never set in this log.
+    pub unrecorded_fields: Vec<&'static str>,
+}
+
+impl SpawnFilter {
+    pub fn from_cli(args: &Cli) -> Self {
+        SpawnFilter::from_flags(
+            (args.cacheable_only, args.uncacheable_only),
+            (args.remotable_only, args.unremotable_only),
+            args.exclude_failed,
+        )
+    }
+
+    /// Builds a filter from the flag values, each pair as (only, not).
+    pub fn from_flags(
+        cacheable: (bool, bool),
+        remotable: (bool, bool),
+        exclude_failed: bool,
+    ) -> Self {
+        let flag = |(only, not): (bool, bool)| match (only, not) {
+            (true, _) => Some(true),
+            (_, true) => Some(false),
+            _ => None,
+        };
+        let (cacheable, remotable) = (flag(cacheable), flag(remotable));
+        let field = |want: Option<bool>, predicate: fn() -> Predicate| match want {
+            Some(true) => predicate(),
+            Some(false) => !predicate(),
+            None => Predicate::any(),
+        };
+        SpawnFilter {
+            cacheable,
+            remotable,
+            exclude_failed,
+            fields: field(cacheable, Predicate::cacheable)
+                .and(field(remotable, Predicate::remotable)),
+        }
+    }
+
+    pub fn is_active(&self) -> bool {
+        self.cacheable.is_some() || self.remotable.is_some() || self.exclude_failed
+    }
+
+    fn predicates(&self) -> Vec<&'static str> {
+        let mut parts = Vec::new();
+        match self.cacheable {
+            Some(true) => parts.push("cacheable only"),
+            Some(false) => parts.push("uncacheable only"),
+            None => {}
+        }
+        match self.remotable {
+            Some(true) => parts.push("remotable only"),
+            Some(false) => parts.push("unremotable only"),
+            None => {}
+        }
+        if self.exclude_failed {
+            parts.push("failed spawns excluded");
+        }
+        parts
+    }
+
+    /// Applies the filter, returning the matching spawns and whole-log totals, which are
+    /// counted in the same pass.
+    ///
+    /// Protobuf booleans carry no presence information, so a field that is never set to true
+    /// anywhere in the log may be unrecorded (older Bazel versions) rather than false for
+    /// every spawn. The filter still tests it as false, and the summary counts the matching
+    /// spawns as guessed.
+    pub fn apply(&self, spawns: Vec<SpawnExec>) -> (Vec<SpawnExec>, FilterSummary) {
+        let unrecorded_fields = self.unrecorded_fields(&spawns);
+        let total_actions = spawns.len();
+        let total_duration = spawns.iter().map(total_time).sum();
+        let total_cache_hits = spawns.iter().filter(|s| is_cache_hit(s)).count();
+        let mut matching = Vec::new();
+        for spawn in spawns {
+            if self.exclude_failed && is_failed(&spawn) {
+                continue;
+            }
+            if self.fields.matches(&spawn) {
+                matching.push(spawn);
+            }
+        }
+        let guessed_actions = if unrecorded_fields.is_empty() {
+            0
+        } else {
+            matching.len()
+        };
+
+        let predicates = self.predicates();
+        let summary = FilterSummary {
+            description: predicates.join(", "),
+            predicates,
+            total_actions,
+            total_duration,
+            total_cache_hits,
+            guessed_actions,
+            unrecorded_fields,
+        };
+        (matching, summary)
+    }
+
+    /// Like `apply`, but borrowing the matching spawns and without the totals.
+    pub fn select<'a>(&self, spawns: &'a [SpawnExec]) -> Vec<&'a SpawnExec> {
+        spawns
+            .iter()
+            .filter(|s| !(self.exclude_failed && is_failed(s)))
+            .filter(|s| self.fields.matches(s))
+            .collect()
+    }
+
+    /// The filtered fields that no spawn of the log sets.
+    fn unrecorded_fields(&self, spawns: &[SpawnExec]) -> Vec<&'static str> {
+        let mut unrecorded = Vec::new();
+        if self.cacheable.is_some() && !spawns.iter().any(|s| s.cacheable) {
+            unrecorded.push("cacheable");
+        }
+        if self.remotable.is_some() && !spawns.iter().any(|s| s.remotable) {
+            unrecorded.push("remotable");
+        }
+        unrecorded
+    }
+}
+
+/// Whether `name` matches `pattern`, where `*` matches any run of characters and `?` any
+/// single one.
+///
+/// Matches in one pass with backtracking to the last `*` only, in O(pattern × name) time at
+/// worst and without recursion, so patterns like `*a*a*a*b` stay fast on long labels.
+pub fn wildcard_match(pattern: &str, name: &str) -> bool {
+    let pattern: Vec<char> = pattern.chars().collect();
+    let name: Vec<char> = name.chars().collect();
+    let (mut p, mut n) = (0, 0);
+    // The position after the last `*` seen, and the name position it is currently matched up to.
+    let mut star: Option<(usize, usize)> = None;
+    while n < name.len() {
+        match pattern.get(p) {
+            Some('*') => {
+                star = Some((p + 1, n));
+                p += 1;
+            }
+            Some(&c) if c == '?' || c == name[n] => {
+                p += 1;
+                n += 1;
+            }
+            _ => match star {
+                // Let the last `*` take one more character and retry from there.
+                Some((after_star, matched)) => {
+                    star = Some((after_star, matched + 1));
+                    p = after_star;
+                    n = matched + 1;
+                }
+                None => return false,
+            },
+        }
+    }
+    pattern[p..].iter().all(|&c| c == '*')
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::analysis::summarize;
+    use crate::proto::EnvironmentVariable;
+    use crate::testing;
+
+    fn spawn(cacheable: bool) -> SpawnExec {
+        SpawnExec {
+            cacheable,
+            ..Default::default()
+        }
+    }
+
+    /// Which of `spawns` `predicate` matches.
+    fn matching(predicate: &Predicate, spawns: &[SpawnExec]) -> Vec<bool> {
+        spawns.iter().map(|spawn| predicate.matches(spawn)).collect()
+    }
+
+    #[test]
+    fn mnemonic_matches_a_wildcard() {
+        let spawns = [
+            testing::spawn("CppCompile", "local", 1),
+            testing::spawn("ObjcCompile", "local", 1),
+            testing::spawn("CppLink", "local", 1),
+        ];
+        assert_eq!(matching(&Predicate::mnemonic("*Compile"), &spawns), [true, true, false]);
+        assert_eq!(matching(&Predicate::mnemonic("CppLink"), &spawns), [false, false, true]);
+    }
+
+    #[test]
+    fn label_matches_a_wildcard_not_a_regular_expression() {
+        let spawns = ["//app:lib", "//app:lib_test", "//core:base"].map(|label| SpawnExec {
+            target_label: label.to_string(),
+            ..Default::default()
+        });
+        let tests = Predicate::label("//app:*_test");
+        assert_eq!(matching(&tests, &spawns), [false, true, false]);
+        assert_eq!(matching(&Predicate::label("//app:.*"), &spawns), [false, false, false]);
+    }
+
+    #[test]
+    fn runner_matches_a_wildcard() {
+        let spawns = [
+            testing::spawn("Javac", "remote cache hit", 1),
+            testing::spawn("Javac", "disk cache hit", 1),
+            testing::spawn("Javac", "remote", 1),
+        ];
+        let hits = Predicate::runner("* cache hit");
+        assert_eq!(matching(&hits, &spawns), [true, true, false]);
+        assert_eq!(matching(&Predicate::runner("remote*"), &spawns), [true, false, true]);
+    }
+
+    #[test]
+    fn env_matches_the_value_of_the_named_variable() {
+        let with_env = |name: &str, value: &str| SpawnExec {
+            environment_variables: vec![EnvironmentVariable {
+                name: name.to_string(),
+                value: value.to_string(),
+            }],
+            ..Default::default()
+        };
+        let spawns = [
+            with_env("COMPILATION_MODE", "opt"),
+            with_env("COMPILATION_MODE", "fastbuild"),
+            with_env("MODE", "opt"),
+            SpawnExec::default(),
+        ];
+        let opt = Predicate::env("COMPILATION_MODE", "opt");
+        assert_eq!(matching(&opt, &spawns), [true, false, false, false]);
+        let any = Predicate::env("COMPILATION_MODE", "*");
+        assert_eq!(matching(&any, &spawns), [true, true, false, false]);
+    }
+
+    #[test]
+    fn min_duration_includes_the_bound_and_counts_no_time_as_zero() {
+        let spawns = [
+            testing::spawn("Javac", "local", 999),
+            testing::spawn("Javac", "local", 1_000),
+            SpawnExec::default(),
+        ];
+        let slow = Predicate::min_duration(Duration::from_secs(1));
+        assert_eq!(matching(&slow, &spawns), [false, true, false]);
+        assert_eq!(matching(&Predicate::min_duration(Duration::ZERO), &spawns), [true; 3]);
+    }
+
+    #[test]
+    fn cache_hit_takes_the_flag_or_a_cache_hit_runner() {
+        let flagged = SpawnExec {
+            cache_hit: true,
+            ..testing::spawn("Javac", "local", 1)
+        };
+        let spawns = [
+            flagged,
+            testing::spawn("Javac", "disk cache hit", 1),
+            testing::spawn("Javac", "local", 1),
+        ];
+        assert_eq!(matching(&Predicate::cache_hit(), &spawns), [true, true, false]);
+    }
+
+    #[test]
+    fn failed_takes_a_non_zero_exit_code_or_a_status() {
+        let spawns = [
+            SpawnExec {
+                exit_code: 1,
+                ..Default::default()
+            },
+            SpawnExec {
+                status: "TIMEOUT".to_string(),
+                ..Default::default()
+            },
+            SpawnExec::default(),
+        ];
+        assert_eq!(matching(&Predicate::failed(), &spawns), [true, true, false]);
+    }
+
+    #[test]
+    fn and_or_and_not_combine_predicates() {
+        let spawns = [
+            testing::spawn("CppCompile", "remote", 20_000),
+            testing::spawn("CppCompile", "remote cache hit", 20_000),
+            testing::spawn("CppCompile", "remote", 10),
+            testing::spawn("Javac", "local", 20_000),
+        ];
+        let compile = || Predicate::mnemonic("CppCompile");
+        let slow = || Predicate::min_duration(Duration::from_secs(10));
+        assert_eq!(matching(&compile().and(slow()), &spawns), [true, true, false, false]);
+        assert_eq!(matching(&compile().or(slow()), &spawns), [true, true, true, true]);
+        assert_eq!(matching(&!compile(), &spawns), [false, false, false, true]);
+        let slow_misses = compile().and(slow()).and(!Predicate::cache_hit());
+        assert_eq!(matching(&slow_misses, &spawns), [true, false, false, false]);
+        assert_eq!(matching(&Predicate::default(), &spawns), [true; 4]);
+    }
+
+    #[test]
+    fn a_predicate_selects_the_spawns_before_they_are_summarized() {
+        let log = [
+            testing::spawns(3, "CppCompile", "remote", 20_000),
+            testing::spawns(2, "CppCompile", "remote cache hit", 20_000),
+            testing::spawns(4, "Javac", "local", 1_000),
+        ]
+        .concat();
+        let compiles = Predicate::mnemonic("CppCompile");
+        let summary = summarize(log.iter().filter(|spawn| compiles.matches(spawn)));
+        assert_eq!(summary.actions, 5);
+        assert_eq!(summary.cache_hits, 2);
+        assert_eq!(summary.total_time, Duration::from_secs(100));
+        let misses = compiles.and(!Predicate::cache_hit());
+        assert_eq!(summarize(log.iter().filter(|spawn| misses.matches(spawn))).actions, 3);
+    }
+
+    #[test]
+    fn uncacheable_only_matches_a_log_without_cacheable_spawns() {
+        let filter = SpawnFilter::from_flags((false, true), (false, false), false);
+        let (matching, summary) = filter.apply(vec![spawn(false), spawn(false)]);
+        assert_eq!(matching.len(), 2);
+        assert_eq!(summary.unrecorded_fields, ["cacheable"]);
+        assert_eq!(summary.guessed_actions, 2);
+        assert_eq!(filter.select(&[spawn(false)]).len(), 1);
+    }
+
+    #[test]
+    fn recorded_fields_are_not_guessed() {
+        let filter = SpawnFilter::from_flags((true, false), (false, false), false);
+        let (matching, summary) = filter.apply(vec![spawn(true), spawn(false)]);
+        assert_eq!(matching.len(), 1);
+        assert!(summary.unrecorded_fields.is_empty());
+        assert_eq!(summary.guessed_actions, 0);
+    }
+
+    #[test]
+    fn wildcards_match_runs_and_single_characters() {
+        assert!(wildcard_match("Javac", "Javac"));
+        assert!(!wildcard_match("Javac", "JavacTurbine"));
+        assert!(wildcard_match("Javac*", "JavacTurbine"));
+        assert!(wildcard_match("*Compile", "CppCompile"));
+        assert!(wildcard_match("//app/*:*_test", "//app/server:api_test"));
+        assert!(!wildcard_match("//app/*:*_test", "//app/server:api"));
+        assert!(wildcard_match("Go?ompile", "GoCompile"));
+        assert!(!wildcard_match("Go?ompile", "Gompile"));
+        assert!(wildcard_match("**", ""));
+        assert!(!wildcard_match("?", ""));
+        assert!(wildcard_match("", ""));
+        assert!(!wildcard_match("", "a"));
+        assert!(wildcard_match("//é?/*", "//éü/x"));
+    }
+
+    /// All patterns over `ab*?` and names over `ab` up to four characters, against a
+    /// straightforward table of which prefixes match.
+    #[test]
+    fn wildcards_agree_with_a_table_built_match() {
+        fn table_match(pattern: &[char], name: &[char]) -> bool {
+            let mut prefixes = vec![vec![false; name.len() + 1]; pattern.len() + 1];
+            prefixes[0][0] = true;
+            for p in 1..=pattern.len() {
+                for n in 0..=name.len() {
+                    prefixes[p][n] = match pattern[p - 1] {
+                        '*' => prefixes[p - 1][n] || (n > 0 && prefixes[p][n - 1]),
+                        c => n > 0 && (c == '?' || c == name[n - 1]) && prefixes[p - 1][n - 1],
+                    };
+                }
+            }
+            prefixes[pattern.len()][name.len()]
+        }
+        fn strings(alphabet: &[char], max_len: usize) -> Vec<String> {
+            let mut all = vec![String::new()];
+            let mut last = vec![String::new()];
+            for _ in 0..max_len {
+                last = last
+                    .iter()
+                    .flat_map(|s| alphabet.iter().map(move |&c| format!("{}{}", s, c)))
+                    .collect();
+                all.extend(last.iter().cloned());
+            }
+            all
+        }
+        for pattern in strings(&['a', 'b', '*', '?'], 4) {
+            for name in strings(&['a', 'b'], 4) {
+                let chars = |s: &str| s.chars().collect::<Vec<char>>();
+                assert_eq!(
+                    wildcard_match(&pattern, &name),
+                    table_match(&chars(&pattern), &chars(&name)),
+                    "{:?} against {:?}",
+                    pattern,
+                    name
+                );
+            }
+        }
+    }
+
+    #[test]
+    fn many_stars_on_a_long_name_do_not_backtrack_exponentially() {
+        let name = "a".repeat(20_000);
+        assert!(!wildcard_match("*a*a*a*a*a*a*a*a*b", &name));
+        assert!(wildcard_match("*a*a*a*a*a*a*a*a*", &name));
+    }
+}
@@ -0,0 +1,482 @@
+//! Text formatting helpers shared by the report printers.
+
+use crate::cli::DurationFormat;
+use crate::paths;
+use crate::style::{paint, visible_width, Style};
+use std::fmt::Display;
+use std::io::{self, Write};
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::Mutex;
+use std::time::Duration;
+
+/// Arguments longer than this are truncated when printing command lines.
+const MAX_ARG_DISPLAY_LEN: usize = 160;
+
+/// Quotes an argument so it can be pasted into a POSIX shell.
+pub fn shell_quote(arg: &str) -> String {
+    if arg.is_empty() {
+        return "''".to_string();
+    }
+    let is_safe = arg
+        .chars()
+        .all(|c| c.is_ascii_alphanumeric() || "-_./=:,+@%^".contains(c));
+    if is_safe {
+        arg.to_string()
+    } else {
+        format!("'{}'", arg.replace('\'', r"'\''"))
+    }
+}
+
+/// Shortens very long single arguments (e.g. classpaths) and notes the original length.
+fn truncate_arg(arg: &str) -> String {
+    let char_count = arg.chars().count();
+    if char_count <= MAX_ARG_DISPLAY_LEN {
+        return shell_quote(arg);
+    }
+    let prefix: String = arg.chars().take(MAX_ARG_DISPLAY_LEN).collect();
+    format!("{}...[{} chars total]", shell_quote(&prefix), char_count)
+}
+
+/// Returns true if the argument references a Bazel param file (e.g. `@bazel-out/.../foo.params`).
+pub fn is_param_file_arg(arg: &str) -> bool {
+    arg.len() > 1 && arg.starts_with('@') && !arg.starts_with("@@")
+}
+
+/// Formats a command line as a single shell-quoted string, its paths relative to the execroot
+/// with --relative-paths.
+///
+/// At most `limit` arguments are included (`None` means all of them), and long
+/// arguments are truncated unless the full command line was requested.
+pub fn format_command_line(args: &[String], limit: Option<usize>) -> String {
+    let shown = limit.unwrap_or(args.len()).min(args.len());
+    let mut parts: Vec<String> = args[..shown]
+        .iter()
+        .map(|arg| {
+            let arg = paths::relative(arg);
+            if limit.is_some() {
+                truncate_arg(&arg)
+            } else {
+                shell_quote(&arg)
+            }
+        })
+        .collect();
+    if shown < args.len() {
+        parts.push(format!("... (+{} more arguments)", args.len() - shown));
+    }
+    parts.join(" ")
+}
+
+/// Formats a duration compactly for labels, e.g. `10ms`, `1.5s`, `2m`, `1h`.
+pub fn format_duration_short(duration: Duration) -> String {
+    let seconds = duration.as_secs_f64();
+    let (value, unit) = if seconds >= 3600.0 {
+        (seconds / 3600.0, "h")
+    } else if seconds >= 60.0 {
+        (seconds / 60.0, "m")
+    } else if seconds >= 1.0 {
+        (seconds, "s")
+    } else if seconds >= 1e-3 {
+        (seconds * 1e3, "ms")
+    } else {
+        (seconds * 1e6, "us")
+    };
+    let text = format!("{:.1}", value);
+    format!("{}{}", text.trim_end_matches(".0"), unit)
+}
+
+/// Decimal (kilo = 1000) or binary (kibi = 1024) multiples for byte counts.
+#[derive(Clone, Copy, PartialEq, Eq, Debug)]
+pub enum ByteUnits {
+    Si,
+    Iec,
+}
+
+#[derive(Clone, Copy)]
+struct Units {
+    durations: DurationFormat,
+    bytes: ByteUnits,
+}
+
+/// Set once from --duration-format and --si/--iec, before any report runs.
+static UNITS: Mutex<Units> = Mutex::new(Units {
+    durations: DurationFormat::Seconds,
+    bytes: ByteUnits::Iec,
+});
+
+fn units() -> Units {
+    *UNITS.lock().unwrap_or_else(|poisoned| poisoned.into_inner())
+}
+
+/// Sets the units `format_duration`, `format_bytes` and `format_rate` print in.
+pub fn configure_units(durations: DurationFormat, bytes: ByteUnits) {
+    *UNITS.lock().unwrap_or_else(|poisoned| poisoned.into_inner()) = Units { durations, bytes };
+}
+
+/// Set when the filter flags leave out part of the log, so that every section says so.
+static FILTERED: AtomicBool = AtomicBool::new(false);
+
+/// Marks the headings of the sections printed from now on as covering a filtered subset.
+pub fn mark_filtered(filtered: bool) {
+    FILTERED.store(filtered, Ordering::Relaxed);
+}
+
+/// The `--- title ---` heading of a report section, with a `(filtered)` marker while a filter
+/// is active.
+pub fn section(title: impl Display) -> String {
+    if FILTERED.load(Ordering::Relaxed) {
+        format!("--- {} (filtered) ---", title)
+    } else {
+        format!("--- {} ---", title)
+    }
+}
+
+/// Renders a duration in the reports' human-readable text, in the --duration-format unit: seconds
+/// with `decimals` decimals (the default), whole milliseconds, or adaptive units such as
+/// `1h20m3s`, `4.2s` and `812ms`. JSON and CSV output keep their raw numbers instead.
+pub fn format_duration(duration: Duration, decimals: usize) -> String {
+    format_seconds(duration.as_secs_f64(), decimals)
+}
+
+/// `format_duration` for a value already in seconds, such as an average or a difference.
+pub fn format_seconds(seconds: f64, decimals: usize) -> String {
+    if seconds < 0.0 {
+        return format!("-{}", format_seconds(-seconds, decimals));
+    }
+    match units().durations {
+        DurationFormat::Seconds => format!("{:.*}s", decimals, seconds),
+        DurationFormat::Millis => format!("{:.0}ms", seconds * 1e3),
+        DurationFormat::Human if seconds >= 59.5 => {
+            let total = seconds.round() as u64;
+            let (hours, minutes, seconds) = (total / 3600, total / 60 % 60, total % 60);
+            if hours > 0 {
+                format!("{}h{}m{}s", hours, minutes, seconds)
+            } else {
+                format!("{}m{}s", minutes, seconds)
+            }
+        }
+        DurationFormat::Human if seconds >= 0.9995 => format!("{:.1}s", seconds),
+        DurationFormat::Human if seconds >= 1e-3 => format!("{:.0}ms", seconds * 1e3),
+        DurationFormat::Human => format!("{:.0}us", seconds * 1e6),
+    }
+}
+
+/// `format_seconds` with a sign, for changes: `+1.25s`, `-300ms`.
+pub fn format_seconds_change(seconds: f64, decimals: usize) -> String {
+    if seconds < 0.0 {
+        format_seconds(seconds, decimals)
+    } else {
+        format!("+{}", format_seconds(seconds, decimals))
+    }
+}
+
+/// Formats an offset since the Unix epoch as an RFC 3339 UTC timestamp with milliseconds,
+/// e.g. `2023-11-14T22:13:20.000Z`.
+pub fn format_timestamp(since_epoch: Duration) -> String {
+    let seconds = since_epoch.as_secs();
+    let days = (seconds / 86_400) as i64;
+    let time = seconds % 86_400;
+    // Civil date from days since 1970-01-01 (Howard Hinnant's algorithm).
+    let z = days + 719_468;
+    let era = z.div_euclid(146_097);
+    let doe = z.rem_euclid(146_097);
+    let yoe = (doe - doe / 1460 + doe / 36_524 - doe / 146_096) / 365;
+    let doy = doe - (365 * yoe + yoe / 4 - yoe / 100);
+    let mp = (5 * doy + 2) / 153;
+    let day = doy - (153 * mp + 2) / 5 + 1;
+    let month = if mp < 10 { mp + 3 } else { mp - 9 };
+    let year = yoe + era * 400 + if month <= 2 { 1 } else { 0 };
+    format!(
+        "{:04}-{:02}-{:02}T{:02}:{:02}:{:02}.{:03}Z",
+        year,
+        month,
+        day,
+        time / 3600,
+        time % 3600 / 60,
+        time % 60,
+        since_epoch.subsec_millis()
+    )
+}
+
+/// Quotes a CSV field if it contains a separator, quote or line break.
+pub fn csv_field(value: &str) -> String {
+    if value.contains([',', '"', '\n', '\r']) {
+        format!("\"{}\"", value.replace('"', "\"\""))
+    } else {
+        value.to_string()
+    }
+}
+
+/// The largest --si or --iec unit that keeps `bytes` at one or more, and the value in it.
+fn scale_bytes(bytes: f64) -> (f64, &'static str) {
+    let (base, names) = match units().bytes {
+        ByteUnits::Si => (1000.0, ["B", "KB", "MB", "GB", "TB"]),
+        ByteUnits::Iec => (1024.0, ["B", "KiB", "MiB", "GiB", "TiB"]),
+    };
+    let mut value = bytes;
+    let mut unit = 0;
+    while value.abs() >= base && unit < names.len() - 1 {
+        value /= base;
+        unit += 1;
+    }
+    (value, names[unit])
+}
+
+/// Formats a byte count in the unit that fits, e.g. `1.50 GiB`, or `843 B` for small counts.
+pub fn format_bytes(bytes: i64) -> String {
+    match scale_bytes(bytes as f64) {
+        (_, "B") => format!("{} B", bytes),
+        (value, unit) => format!("{:.2} {}", value, unit),
+    }
+}
+
+/// Formats a transfer rate in bytes per second the way `format_bytes` does, e.g. `2.11 MiB/s`.
+pub fn format_rate(bytes_per_second: f64) -> String {
+    match scale_bytes(bytes_per_second) {
+        (value, "B") => format!("{:.0} B/s", value),
+        (value, unit) => format!("{:.2} {}/s", value, unit),
+    }
+}
+
+/// Renders a proportional bar of at most `width` characters.
+pub fn bar(value: f64, max_value: f64, width: usize) -> String {
+    if max_value <= 0.0 || value <= 0.0 {
+        return String::new();
+    }
+    let len = ((value / max_value) * width as f64).round() as usize;
+    "#".repeat(len.clamp(1, width))
+}
+
+/// Layout of the proportional bar charts printed by --chart.
+pub struct ChartOptions {
+    /// Total line width in characters.
+    pub width: usize,
+    /// Rows shown before the rest is collapsed into "other".
+    pub max_rows: usize,
+    /// Scale bars by the logarithm of the value, so small rows stay visible next to a
+    /// dominant one.
+    pub log_scale: bool,
+}
+
+/// Longest name shown in a chart row before it is truncated.
+const CHART_NAME_WIDTH: usize = 30;
+
+/// Writes one bar per row, scaled to the largest value, with seconds and share of the total.
+/// `rows` must be sorted by time descending.
+pub fn write_time_chart(
+    out: &mut dyn Write,
+    rows: &[(String, Duration)],
+    options: &ChartOptions,
+) -> io::Result<()> {
+    let total: f64 = rows.iter().map(|(_, d)| d.as_secs_f64()).sum();
+    let mut shown: Vec<(String, f64)> = rows
+        .iter()
+        .take(options.max_rows)
+        .map(|(name, d)| (name.clone(), d.as_secs_f64()))
+        .collect();
+    if rows.len() > options.max_rows {
+        let rest: f64 = rows[options.max_rows..]
+            .iter()
+            .map(|(_, d)| d.as_secs_f64())
+            .sum();
+        shown.push((format!("other ({})", rows.len() - options.max_rows), rest));
+    }
+    if shown.is_empty() || total <= 0.0 {
+        return Ok(());
+    }
+
+    let name_width = shown
+        .iter()
+        .map(|(name, _)| name.chars().count())
+        .max()
+        .unwrap_or(0)
+        .min(CHART_NAME_WIDTH);
+    // Name, " |", bar, "| ", "12345.67s", " ", "100.0%".
+    let bar_width = options.width.saturating_sub(name_width + 20).max(10);
+    let scale = |value: f64| {
+        if options.log_scale {
+            value.ln_1p()
+        } else {
+            value
+        }
+    };
+    let max_value = shown.iter().map(|(_, v)| scale(*v)).fold(0.0, f64::max);
+    for (name, value) in &shown {
+        let name: String = name.chars().take(name_width).collect();
+        writeln!(
+            out,
+            "{:<name_width$} |{:<bar_width$}| {:>8.2}s {:>5.1}%",
+            name,
+            bar(scale(*value), max_value, bar_width),
+            value,
+            value / total * 100.0
+        )?;
+    }
+    if options.log_scale {
+        writeln!(out, "(bars use a logarithmic scale)")?;
+    }
+    Ok(())
+}
+
+/// Prints the chart of [`write_time_chart`] to stdout.
+pub fn print_time_chart(rows: &[(String, Duration)], options: &ChartOptions) {
+    let _ = write_time_chart(&mut io::stdout().lock(), rows, options);
+}
+
+/// Horizontal alignment of a table column.
+#[derive(Clone, Copy)]
+pub enum Align {
+    Left,
+    Right,
+}
+
+/// Shortens a target label to `width` characters by cutting directories out of the middle of
+/// its package, e.g. `//services/.../foo:bar`, so the target name stays visible.
+pub fn truncate_label(label: &str, width: usize) -> String {
+    const ELLIPSIS: &str = "...";
+    if label.chars().count() <= width {
+        return label.to_string();
+    }
+    let name_start = label.rfind(':').or_else(|| label.rfind('/')).unwrap_or(0);
+    let (package, name) = label.split_at(name_start);
+    let budget = width.saturating_sub(ELLIPSIS.len());
+    if name.chars().count() > budget {
+        // Not even the name fits; keep its end.
+        let mut tail: Vec<char> = label.chars().rev().take(budget).collect();
+        tail.reverse();
+        return format!("{}{}", ELLIPSIS, tail.into_iter().collect::<String>());
+    }
+
+    // The repository and first directory, then as many of the last directories as fit.
+    let root_end = package.find("//").map_or(0, |i| i + 2);
+    let head_end = package[root_end..]
+        .find('/')
+        .map_or(package.len(), |i| root_end + i + 1);
+    let mut head = &package[..head_end];
+    if head.len() + name.len() > budget {
+        head = "";
+    }
+    let mut tail_start = package.len();
+    while let Some(i) = package[head.len()..tail_start].rfind('/') {
+        let start = head.len() + i;
+        if head.len() + label.len() - start > budget {
+            break;
+        }
+        tail_start = start;
+    }
+    format!("{}{}{}", head, ELLIPSIS, &label[tail_start..])
+}
+
+/// "Top N" in a report heading, or "All" when `--top-n all` lifted the limit.
+pub fn top_label(top_n: usize) -> String {
+    if top_n == usize::MAX {
+        "All".to_string()
+    } else {
+        format!("Top {}", top_n)
+    }
+}
+
+/// A text table whose column widths are computed from its contents.
+///
+/// Renders in the report's usual `a | b | c` style with a dashed separator under the header.
+pub struct Table {
+    columns: Vec<(String, Align)>,
+    rows: Vec<Vec<String>>,
+}
+
+impl Table {
+    pub fn new(columns: Vec<(String, Align)>) -> Self {
+        Table {
+            columns,
+            rows: Vec::new(),
+        }
+    }
+
+    pub fn add_row(&mut self, row: Vec<String>) {
+        debug_assert_eq!(row.len(), self.columns.len());
+        self.rows.push(row);
+    }
+
+    pub fn is_empty(&self) -> bool {
+        self.rows.is_empty()
+    }
+
+    pub fn print(&self) {
+        for line in self.lines() {
+            println!("{}", line);
+        }
+    }
+
+    /// The header, the separator and one line per row, as `print` writes them.
+    pub fn lines(&self) -> Vec<String> {
+        let widths: Vec<usize> = self
+            .columns
+            .iter()
+            .enumerate()
+            .map(|(i, (header, _))| {
+                self.rows
+                    .iter()
+                    .map(|row| visible_width(&row[i]))
+                    .max()
+                    .unwrap_or(0)
+                    .max(header.chars().count())
+            })
+            .collect();
+
+        let render = |cells: Vec<&str>| -> String {
+            cells
+                .iter()
+                .zip(&self.columns)
+                .zip(&widths)
+                .map(|((cell, (_, align)), &width)| {
+                    // Padded by hand, since colored cells are longer than they look.
+                    let padding = " ".repeat(width.saturating_sub(visible_width(cell)));
+                    match align {
+                        Align::Left => format!("{}{}", cell, padding),
+                        Align::Right => format!("{}{}", padding, cell),
+                    }
+                })
+                .collect::<Vec<_>>()
+                .join(" | ")
+                .trim_end()
+                .to_string()
+        };
+
+        let separator_width = widths.iter().sum::<usize>() + 3 * widths.len().saturating_sub(1);
+        let mut lines = vec![
+            render(self.columns.iter().map(|(h, _)| h.as_str()).collect()),
+            paint(Style::Dim, "-".repeat(separator_width)),
+        ];
+        for row in &self.rows {
+            lines.push(render(row.iter().map(|c| c.as_str()).collect()));
+        }
+        lines
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const LABEL: &str = "//services/payments/processing/internal:ledger_sync";
+
+    #[test]
+    fn labels_that_fit_are_kept() {
+        assert_eq!(truncate_label(LABEL, LABEL.len()), LABEL);
+        assert_eq!(truncate_label("//a:b", 5), "//a:b");
+    }
+
+    #[test]
+    fn labels_lose_directories_from_the_middle_first() {
+        assert_eq!(truncate_label(LABEL, 40), "//services/.../internal:ledger_sync");
+        assert_eq!(truncate_label(LABEL, 30), "//services/...:ledger_sync");
+        assert_eq!(truncate_label(LABEL, 20), "...:ledger_sync");
+    }
+
+    #[test]
+    fn a_name_too_long_for_the_width_keeps_its_end() {
+        assert_eq!(truncate_label(LABEL, 10), "...er_sync");
+        for width in 10..=LABEL.len() {
+            assert!(truncate_label(LABEL, width).chars().count() <= width, "{}", width);
+        }
+    }
+}
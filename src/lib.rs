@@ -1,15 +1,82 @@
+//! Parsing and analysis of Bazel execution logs, behind the `bzl-exec-log-analyzer` CLI.
+//!
+//! The parts meant for reuse are [`execlog`] to read a log, [`filter`] to select spawns,
+//! [`analysis`] to aggregate them, [`report`] for the main report as data and [`schema`] for
+//! the JSON documents; none of them print or
+//! exit. Everything else is the command line built on top.
+
 pub mod proto;
+pub mod reports;
+pub mod analysis;
+pub mod artifact;
+pub mod classify;
 pub mod cli;
 pub mod commands;
+pub mod config;
+pub mod dedupe;
+pub mod digests;
 pub mod error;
+pub mod execlog;
+pub mod filter;
+pub mod format;
+pub mod metrics;
+pub mod parallel;
+pub mod parse_stats;
+pub mod paths;
+pub mod profile;
+pub mod progress;
+pub mod reapi;
+pub mod report;
+pub mod schema;
+pub mod stats;
+pub mod style;
+#[cfg(test)]
+mod testing;
+pub mod timing;
+pub mod unknown_fields;
+pub mod warnings;
 
-pub use error::{AppError, AppResult};
-pub use cli::Cli;
-
-use clap::Parser;
+pub use error::{AppError, AppResult, Gate};
+pub use cli::{Cli, Command};
 
 /// Main library entry point
 pub fn run() -> AppResult<()> {
-    let cli = Cli::parse();
-    commands::analyze::run_analyze(cli)
+    if cli::asks_for_output_formats(&std::env::args_os().collect::<Vec<_>>()) {
+        report::write_output_writers(&mut std::io::stdout().lock())?;
+        return Ok(());
+    }
+    let cli = Cli::parse_args();
+    warnings::configure(cli.warnings_format, Some(cli.max_warnings));
+    format::configure_units(cli.duration_format, cli.byte_units());
+    style::configure(cli.color_choice());
+    progress::configure(cli.no_progress);
+    unknown_fields::configure(cli.print_unknown_fields);
+    timing::configure(cli.debug_timing);
+    // --debug-timing reports the parse stages from the --parse-stats meters.
+    parse_stats::configure(cli.parse_stats || cli.debug_timing);
+    let print_parse_stats = cli.parse_stats;
+    paths::configure(cli.relative_paths, &cli.strip_path_prefix);
+    profile::start(cli.cpuprofile.as_deref(), cli.memprofile.as_deref())?;
+    let result = match &cli.command {
+        Some(Command::Diff(diff)) => commands::diff::run_diff(&cli, diff),
+        Some(Command::Check(check)) => commands::check::run_check(&cli, check),
+        Some(Command::Policy(policy)) => commands::policy::run_policy(&cli, policy),
+        Some(Command::Trend(trend)) => commands::trend::run_trend(&cli, trend),
+        Some(Command::Compare(compare)) => commands::compare::run_compare(&cli, compare),
+        // Cli::parse_args folds it into the bare invocation.
+        Some(Command::Analyze(_)) => unreachable!("analyze is parsed as the default mode"),
+        None if cli.watch => commands::watch::run_watch(cli),
+        None if cli.tui => commands::tui::run_tui(&cli),
+        None => match &cli.serve {
+            Some(address) => commands::serve::run_serve(&cli, address),
+            None => commands::analyze::run_analyze(cli),
+        },
+    };
+    if print_parse_stats {
+        parse_stats::finish();
+    }
+    timing::finish();
+    let profiled = profile::finish();
+    warnings::finish();
+    result.and(profiled)
 }
\ No newline at end of file
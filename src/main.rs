@@ -1,5 +1,17 @@
-use bzl_exec_log_parser::{run, AppResult};
+use bzl_exec_log_parser::run;
+use bzl_exec_log_parser::timing::CountingAllocator;
+use std::process::ExitCode;
 
-fn main() -> AppResult<()> {
-    run()
+/// Counts the heap for --debug-timing and samples it for --memprofile.
+#[global_allocator]
+static ALLOCATOR: CountingAllocator = CountingAllocator;
+
+fn main() -> ExitCode {
+    match run() {
+        Ok(()) => ExitCode::SUCCESS,
+        Err(err) => {
+            eprintln!("Error [{}]: {}", err.category(), err);
+            ExitCode::from(err.exit_code())
+        }
+    }
 }
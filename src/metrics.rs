@@ -0,0 +1,382 @@
+//! Helpers for reading timing data out of spawn metrics.
+
+use crate::proto::{File, SpawnExec, SpawnMetrics};
+use std::time::Duration;
+
+/// Helper to convert prost's Duration to std's Duration
+pub fn to_std_duration(prost_duration: &prost_types::Duration) -> Duration {
+    Duration::new(
+        prost_duration.seconds.try_into().unwrap_or(0),
+        prost_duration.nanos.try_into().unwrap_or(0),
+    )
+}
+
+/// A duration in whole nanoseconds, the unit of every time in the JSON documents.
+pub fn nanos(duration: Duration) -> u64 {
+    duration.as_nanos() as u64
+}
+
+/// Returns the total wall time of a spawn, or zero if it was not recorded.
+pub fn total_time(spawn: &SpawnExec) -> Duration {
+    spawn
+        .metrics
+        .as_ref()
+        .and_then(|m| m.total_time.as_ref())
+        .map(to_std_duration)
+        .unwrap_or_default()
+}
+
+/// Returns the total wall time of a spawn, or `None` if it was not recorded.
+pub fn recorded_total_time(spawn: &SpawnExec) -> Option<Duration> {
+    spawn
+        .metrics
+        .as_ref()
+        .and_then(|m| m.total_time.as_ref())
+        .map(to_std_duration)
+}
+
+/// Returns when the spawn started, as an offset since the Unix epoch, if recorded.
+pub fn start_time(spawn: &SpawnExec) -> Option<Duration> {
+    let timestamp = spawn.metrics.as_ref()?.start_time.as_ref()?;
+    Some(Duration::new(
+        timestamp.seconds.try_into().ok()?,
+        timestamp.nanos.try_into().ok()?,
+    ))
+}
+
+/// Spans longer than this are reported as suspicious rather than printed, since they come
+/// from skewed clocks or logs concatenated across builds.
+const MAX_PLAUSIBLE_SPAN: Duration = Duration::from_secs(7 * 24 * 3600);
+
+/// The wall-clock interval covered by the spawns that recorded a start time.
+pub struct WallClockSpan {
+    /// Earliest spawn start, since the Unix epoch.
+    pub first_start: Duration,
+    /// Latest spawn end (start plus total time), since the Unix epoch.
+    pub last_end: Duration,
+    /// Spawns whose recorded total time is negative, i.e. that end before they start. They
+    /// only contribute their start time.
+    pub negative_durations: u64,
+}
+
+impl WallClockSpan {
+    pub fn span(&self) -> Duration {
+        self.last_end - self.first_start
+    }
+
+    /// False for a span of over a week.
+    pub fn is_plausible(&self) -> bool {
+        self.span() <= MAX_PLAUSIBLE_SPAN
+    }
+}
+
+/// Returns the span between the earliest spawn start and the latest spawn end, or `None`
+/// if no spawn recorded a start time.
+pub fn wall_clock_span(spawns: &[SpawnExec]) -> Option<WallClockSpan> {
+    let mut span: Option<WallClockSpan> = None;
+    for spawn in spawns {
+        let Some(start) = start_time(spawn) else {
+            continue;
+        };
+        let total = spawn.metrics.as_ref().and_then(|m| m.total_time.as_ref());
+        let negative = total.is_some_and(|t| t.seconds < 0 || t.nanos < 0);
+        let end = start + total.map(to_std_duration).unwrap_or_default();
+        let span = span.get_or_insert(WallClockSpan {
+            first_start: start,
+            last_end: end,
+            negative_durations: 0,
+        });
+        span.first_start = span.first_start.min(start);
+        span.last_end = span.last_end.max(end);
+        span.negative_durations += negative as u64;
+    }
+    span
+}
+
+/// Returns true if the file is an unresolved symlink rather than a regular file.
+pub fn is_symlink(file: &File) -> bool {
+    !file.symlink_target_path.is_empty()
+}
+
+/// Sum of the digest sizes of a spawn's actual outputs, in bytes. Symlinks count as zero.
+pub fn output_bytes(spawn: &SpawnExec) -> i64 {
+    spawn
+        .actual_outputs
+        .iter()
+        .filter(|file| !is_symlink(file))
+        .filter_map(|file| file.digest.as_ref())
+        .map(|digest| digest.size_bytes)
+        .sum()
+}
+
+/// A directory (tree artifact) among a spawn's outputs whose contents are listed.
+pub struct DirectoryOutput<'a> {
+    pub path: &'a str,
+    pub files: u64,
+    pub bytes: i64,
+}
+
+/// Classifies a spawn's outputs into directories and outputs of unknown size.
+///
+/// An output is a directory when other outputs of the same spawn live under its path; its
+/// size is the sum of those files. Outputs with neither a digest nor contents are returned
+/// separately: they are directories the log only summarized (or empty files) and their size
+/// is unknown.
+pub fn directory_outputs(spawn: &SpawnExec) -> (Vec<DirectoryOutput<'_>>, Vec<&File>) {
+    let mut sorted: Vec<&File> = spawn.actual_outputs.iter().collect();
+    sorted.sort_by(|a, b| a.path.cmp(&b.path));
+
+    let mut directories = Vec::new();
+    let mut unknown = Vec::new();
+    for (i, output) in sorted.iter().enumerate() {
+        if is_symlink(output) {
+            continue;
+        }
+        let prefix = format!("{}/", output.path);
+        // Paths sharing a prefix are contiguous once sorted, and sort after the prefix itself.
+        let children = sorted[i + 1..]
+            .iter()
+            .skip_while(|f| f.path.as_str() < prefix.as_str())
+            .take_while(|f| f.path.starts_with(&prefix));
+        let (files, bytes) = children.fold((0, 0), |(files, bytes), f| {
+            (files + 1, bytes + f.digest.as_ref().map_or(0, |d| d.size_bytes))
+        });
+        if files > 0 {
+            directories.push(DirectoryOutput {
+                path: &output.path,
+                files,
+                bytes,
+            });
+        } else if output.digest.is_none() {
+            unknown.push(*output);
+        }
+    }
+    (directories, unknown)
+}
+
+/// A phase of spawn execution recorded in `SpawnMetrics`.
+#[derive(Clone, Copy, PartialEq, Eq, Debug)]
+pub enum Phase {
+    Parse,
+    Network,
+    Queue,
+    Setup,
+    Upload,
+    Execution,
+    Fetch,
+    ProcessOutputs,
+    Retry,
+}
+
+impl Phase {
+    pub const ALL: [Phase; 9] = [
+        Phase::Parse,
+        Phase::Network,
+        Phase::Queue,
+        Phase::Setup,
+        Phase::Upload,
+        Phase::Execution,
+        Phase::Fetch,
+        Phase::ProcessOutputs,
+        Phase::Retry,
+    ];
+
+    pub fn name(self) -> &'static str {
+        match self {
+            Phase::Parse => "Parse",
+            Phase::Network => "Network",
+            Phase::Queue => "Queue",
+            Phase::Setup => "Setup",
+            Phase::Upload => "Upload",
+            Phase::Execution => "Execution",
+            Phase::Fetch => "Fetch",
+            Phase::ProcessOutputs => "Process Outputs",
+            Phase::Retry => "Retry",
+        }
+    }
+}
+
+/// Returns the recorded duration of a phase, or `None` if the phase is absent.
+pub fn phase_duration(metrics: &SpawnMetrics, phase: Phase) -> Option<Duration> {
+    let field = match phase {
+        Phase::Parse => &metrics.parse_time,
+        Phase::Network => &metrics.network_time,
+        Phase::Queue => &metrics.queue_time,
+        Phase::Setup => &metrics.setup_time,
+        Phase::Upload => &metrics.upload_time,
+        Phase::Execution => &metrics.execution_wall_time,
+        Phase::Fetch => &metrics.fetch_time,
+        Phase::ProcessOutputs => &metrics.process_outputs_time,
+        Phase::Retry => &metrics.retry_time,
+    };
+    field.as_ref().map(to_std_duration)
+}
+
+/// How much timing data a spawn recorded.
+#[derive(Clone, Copy, PartialEq, Eq, Debug)]
+pub enum MetricsCoverage {
+    /// No metrics, or metrics without a total time (which nothing can be averaged from).
+    Missing,
+    /// A total time but no individual phase.
+    TotalOnly,
+    /// A total time and at least one phase.
+    Full,
+}
+
+pub fn metrics_coverage(spawn: &SpawnExec) -> MetricsCoverage {
+    let Some(metrics) = spawn.metrics.as_ref().filter(|m| m.total_time.is_some()) else {
+        return MetricsCoverage::Missing;
+    };
+    if Phase::ALL
+        .iter()
+        .any(|&phase| phase_duration(metrics, phase).is_some())
+    {
+        MetricsCoverage::Full
+    } else {
+        MetricsCoverage::TotalOnly
+    }
+}
+
+/// Per-phase duration sums over a set of spawns.
+#[derive(Default, Clone)]
+pub struct PhaseTotals {
+    pub spawns: u64,
+    pub spawns_with_metrics: u64,
+    /// Sum of `total_time` over spawns with metrics.
+    pub total_time: Duration,
+    sums: [Duration; 9],
+    present: [u64; 9],
+}
+
+impl PhaseTotals {
+    pub fn add(&mut self, spawn: &SpawnExec) {
+        self.spawns += 1;
+        let Some(metrics) = spawn.metrics.as_ref() else {
+            return;
+        };
+        self.spawns_with_metrics += 1;
+        self.total_time += metrics.total_time.as_ref().map(to_std_duration).unwrap_or_default();
+        for (i, phase) in Phase::ALL.iter().enumerate() {
+            if let Some(duration) = phase_duration(metrics, *phase) {
+                self.sums[i] += duration;
+                self.present[i] += 1;
+            }
+        }
+    }
+
+    /// Sum of the phase over all spawns that recorded it.
+    pub fn sum(&self, phase: Phase) -> Duration {
+        self.sums[Self::index(phase)]
+    }
+
+    /// Number of spawns that recorded the phase.
+    pub fn present(&self, phase: Phase) -> u64 {
+        self.present[Self::index(phase)]
+    }
+
+    /// Phases recorded by at least one spawn, in canonical order.
+    pub fn recorded_phases(&self) -> impl Iterator<Item = Phase> + '_ {
+        Phase::ALL.into_iter().filter(|p| self.present(*p) > 0)
+    }
+
+    fn index(phase: Phase) -> usize {
+        Phase::ALL.iter().position(|p| *p == phase).unwrap()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::testing::{duration, spawn};
+
+    /// Metrics with every phase recorded, phase `i` of [`Phase::ALL`] taking `i + 1` ms.
+    fn every_phase() -> SpawnMetrics {
+        SpawnMetrics {
+            total_time: duration(100),
+            parse_time: duration(1),
+            network_time: duration(2),
+            queue_time: duration(3),
+            setup_time: duration(4),
+            upload_time: duration(5),
+            execution_wall_time: duration(6),
+            fetch_time: duration(7),
+            process_outputs_time: duration(8),
+            retry_time: duration(9),
+            ..Default::default()
+        }
+    }
+
+    #[test]
+    fn phase_duration_reads_each_phase() {
+        let metrics = every_phase();
+        for (i, phase) in Phase::ALL.into_iter().enumerate() {
+            assert_eq!(
+                phase_duration(&metrics, phase),
+                Some(Duration::from_millis(i as u64 + 1)),
+                "{}",
+                phase.name()
+            );
+        }
+        assert_eq!(phase_duration(&SpawnMetrics::default(), Phase::Queue), None);
+    }
+
+    #[test]
+    fn phase_totals_count_the_spawns_that_recorded_each_phase() {
+        let full = SpawnExec {
+            metrics: Some(every_phase()),
+            ..Default::default()
+        };
+        let total_only = spawn("Javac", "local", 50);
+        let mut totals = PhaseTotals::default();
+        for spawn in [&full, &full, &total_only, &SpawnExec::default()] {
+            totals.add(spawn);
+        }
+        assert_eq!(totals.spawns, 4);
+        assert_eq!(totals.spawns_with_metrics, 3);
+        assert_eq!(totals.total_time, Duration::from_millis(250));
+        assert_eq!(totals.recorded_phases().count(), Phase::ALL.len());
+        assert_eq!(totals.sum(Phase::Execution), Duration::from_millis(12));
+        assert_eq!(totals.present(Phase::Execution), 2);
+        assert_eq!(metrics_coverage(&full), MetricsCoverage::Full);
+        assert_eq!(metrics_coverage(&total_only), MetricsCoverage::TotalOnly);
+        assert_eq!(metrics_coverage(&SpawnExec::default()), MetricsCoverage::Missing);
+    }
+
+    fn output(path: &str, size: Option<i64>) -> File {
+        File {
+            path: path.to_string(),
+            digest: size.map(|size_bytes| crate::proto::Digest {
+                hash: format!("{:064x}", size_bytes),
+                size_bytes,
+                ..Default::default()
+            }),
+            ..Default::default()
+        }
+    }
+
+    #[test]
+    fn directory_outputs_sum_enumerated_files_and_set_aside_bare_directories() {
+        let link = File {
+            symlink_target_path: "../lib".to_string(),
+            ..output("out/link", None)
+        };
+        let spawn = SpawnExec {
+            actual_outputs: vec![
+                output("out/bundle", None),
+                output("out/bundle/a.js", Some(100)),
+                output("out/bundle/nested/b.js", Some(20)),
+                output("out/bundle.map", Some(7)),
+                output("out/summarized", None),
+                link,
+            ],
+            ..Default::default()
+        };
+        let (directories, unknown) = directory_outputs(&spawn);
+        assert_eq!(directories.len(), 1);
+        assert_eq!(directories[0].path, "out/bundle");
+        assert_eq!((directories[0].files, directories[0].bytes), (2, 120));
+        let unknown: Vec<&str> = unknown.iter().map(|file| file.path.as_str()).collect();
+        assert_eq!(unknown, ["out/summarized"]);
+        assert_eq!(output_bytes(&spawn), 127);
+    }
+}
@@ -0,0 +1,87 @@
+//! A bounded pool of worker threads for the modes that read several logs, which are
+//! independent of each other until their results are combined.
+
+use std::sync::atomic::{AtomicUsize, Ordering};
+use std::sync::mpsc;
+use std::thread;
+
+/// Threads for `jobs` independent jobs when --parallel-files is not given: one per CPU, but
+/// no more than there are jobs.
+pub fn default_workers(jobs: usize) -> usize {
+    thread::available_parallelism()
+        .map_or(4, |n| n.get())
+        .min(jobs)
+        .max(1)
+}
+
+/// Runs `job` on each of `items` on up to `workers` threads and returns the results in the
+/// order of `items`, whichever finishes first. With one worker the jobs run on the calling
+/// thread.
+pub fn map_in_order<I: Sync, T: Send>(
+    items: &[I],
+    workers: usize,
+    job: impl Fn(&I) -> T + Sync,
+) -> Vec<T> {
+    let workers = workers.min(items.len());
+    if workers <= 1 {
+        return items.iter().map(job).collect();
+    }
+    let next = AtomicUsize::new(0);
+    let (sender, receiver) = mpsc::channel();
+    let mut results: Vec<Option<T>> = (0..items.len()).map(|_| None).collect();
+    thread::scope(|scope| {
+        for _ in 0..workers {
+            let sender = sender.clone();
+            let (next, job) = (&next, &job);
+            scope.spawn(move || loop {
+                let i = next.fetch_add(1, Ordering::Relaxed);
+                let Some(item) = items.get(i) else {
+                    break;
+                };
+                if sender.send((i, job(item))).is_err() {
+                    break;
+                }
+            });
+        }
+        drop(sender);
+        for (i, result) in receiver {
+            results[i] = Some(result);
+        }
+    });
+    results
+        .into_iter()
+        .map(|result| result.expect("every job is run"))
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::time::Duration;
+
+    #[test]
+    fn results_keep_the_order_of_the_items_whichever_finishes_first() {
+        let items: Vec<u64> = (0..32).collect();
+        // Earlier items sleep longer, so they finish last.
+        let results = map_in_order(&items, 8, |&i| {
+            thread::sleep(Duration::from_millis(32 - i));
+            i * 10
+        });
+        assert_eq!(results, items.iter().map(|i| i * 10).collect::<Vec<_>>());
+    }
+
+    #[test]
+    fn one_worker_or_one_item_runs_on_the_calling_thread() {
+        let caller = thread::current().id();
+        assert_eq!(map_in_order(&[1, 2], 1, |_| thread::current().id()), [caller, caller]);
+        assert_eq!(map_in_order(&[1], 4, |_| thread::current().id()), [caller]);
+        assert!(map_in_order(&[] as &[u8], 4, |_| ()).is_empty());
+    }
+
+    #[test]
+    fn default_workers_are_bounded_by_the_jobs() {
+        assert_eq!(default_workers(1), 1);
+        assert_eq!(default_workers(0), 1);
+        assert!(default_workers(1_000) >= 1);
+    }
+}
@@ -0,0 +1,324 @@
+//! --parse-stats: where the time spent parsing the logs went. Reading the file, decompressing
+//! a compact log, decoding the protobuf records and reconstructing compact spawns are timed
+//! inside [`crate::execlog::Records`], so every mode that reads a log is counted, and the
+//! totals are printed to stderr once the command is done.
+//!
+//! The timers are only started when the flag is set.
+
+use crate::format::{format_bytes, format_duration};
+use serde::Serialize;
+use std::cell::Cell;
+use std::io::{self, Read};
+use std::rc::Rc;
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::Mutex;
+use std::time::{Duration, Instant};
+
+static ENABLED: AtomicBool = AtomicBool::new(false);
+
+/// Collects the statistics of each parse when `enabled` (--parse-stats) is set.
+pub fn configure(enabled: bool) {
+    ENABLED.store(enabled, Ordering::Relaxed);
+}
+
+pub fn enabled() -> bool {
+    ENABLED.load(Ordering::Relaxed)
+}
+
+/// The statistics of every log parsed so far.
+struct Totals {
+    logs: u64,
+    bytes_read: u64,
+    /// Of compact logs only; verbose logs are read as they are.
+    decompressed_bytes: u64,
+    records: u64,
+    spawns: u64,
+    read: Duration,
+    decompress: Duration,
+    decode: Duration,
+    reconstruct: Duration,
+    /// Summed over the logs, which --parallel-files parses at the same time.
+    parsing: Duration,
+    /// From the start of the first parse to the end of the last, parses at the same time
+    /// counted once.
+    wall: Duration,
+    /// When the last parse ended, which the analysis starts from.
+    parsed_at: Option<Instant>,
+}
+
+static TOTALS: Mutex<Totals> = Mutex::new(Totals {
+    logs: 0,
+    bytes_read: 0,
+    decompressed_bytes: 0,
+    records: 0,
+    spawns: 0,
+    read: Duration::ZERO,
+    decompress: Duration::ZERO,
+    decode: Duration::ZERO,
+    reconstruct: Duration::ZERO,
+    parsing: Duration::ZERO,
+    wall: Duration::ZERO,
+    parsed_at: None,
+});
+
+fn totals() -> std::sync::MutexGuard<'static, Totals> {
+    TOTALS
+        .lock()
+        .unwrap_or_else(|poisoned| poisoned.into_inner())
+}
+
+/// Runs `parse`, which reads one or more logs, adding its duration to the wall time.
+pub fn time_parse<T>(parse: impl FnOnce() -> T) -> T {
+    if !enabled() {
+        return parse();
+    }
+    let started = Instant::now();
+    let result = parse();
+    let mut totals = totals();
+    totals.wall += started.elapsed();
+    totals.parsed_at = Some(Instant::now());
+    result
+}
+
+/// Bytes that went through a [`MeteredReader`] and the time its reads took.
+#[derive(Default)]
+struct Meter {
+    bytes: Cell<u64>,
+    time: Cell<Duration>,
+}
+
+/// A reader that adds the bytes read through it, and the time that took, to its meter.
+pub struct MeteredReader<R> {
+    inner: R,
+    meter: Rc<Meter>,
+}
+
+impl<R: Read> Read for MeteredReader<R> {
+    fn read(&mut self, buf: &mut [u8]) -> io::Result<usize> {
+        let started = Instant::now();
+        let count = self.inner.read(buf)?;
+        self.meter
+            .time
+            .set(self.meter.time.get() + started.elapsed());
+        self.meter.bytes.set(self.meter.bytes.get() + count as u64);
+        Ok(count)
+    }
+}
+
+/// The parts of decoding a record that are timed separately.
+#[derive(Clone, Copy)]
+pub enum Stage {
+    /// Unmarshalling the protobuf message.
+    Decode,
+    /// Building a spawn from compact log entries.
+    Reconstruct,
+}
+
+/// The statistics of one parse, added to the totals when dropped.
+pub struct LogStats {
+    started: Instant,
+    file: Rc<Meter>,
+    /// The decompressed stream of a compact log, the reading of the file included.
+    decompressed: Option<Rc<Meter>>,
+    decode: Duration,
+    reconstruct: Duration,
+    pub records: u64,
+    pub spawns: u64,
+}
+
+impl LogStats {
+    pub fn new() -> Self {
+        LogStats {
+            started: Instant::now(),
+            file: Rc::default(),
+            decompressed: None,
+            decode: Duration::ZERO,
+            reconstruct: Duration::ZERO,
+            records: 0,
+            spawns: 0,
+        }
+    }
+
+    /// Wraps the log file, as it comes.
+    pub fn file_reader<R: Read>(&self, inner: R) -> MeteredReader<R> {
+        MeteredReader {
+            inner,
+            meter: Rc::clone(&self.file),
+        }
+    }
+
+    /// Wraps the decompressed stream of a compact log.
+    pub fn decompressed_reader<R: Read>(&mut self, inner: R) -> MeteredReader<R> {
+        MeteredReader {
+            inner,
+            meter: Rc::clone(self.decompressed.get_or_insert_with(Rc::default)),
+        }
+    }
+}
+
+impl Default for LogStats {
+    fn default() -> Self {
+        LogStats::new()
+    }
+}
+
+impl Drop for LogStats {
+    fn drop(&mut self) {
+        let mut totals = totals();
+        totals.logs += 1;
+        totals.bytes_read += self.file.bytes.get();
+        totals.read += self.file.time.get();
+        if let Some(decompressed) = &self.decompressed {
+            totals.decompressed_bytes += decompressed.bytes.get();
+            totals.decompress += decompressed.time.get().saturating_sub(self.file.time.get());
+        }
+        totals.records += self.records;
+        totals.spawns += self.spawns;
+        totals.decode += self.decode;
+        totals.reconstruct += self.reconstruct;
+        totals.parsing += self.started.elapsed();
+    }
+}
+
+/// Runs `step`, adding its duration to `stage` of `stats` when there are any.
+pub fn timed<T>(stats: &mut Option<LogStats>, stage: Stage, step: impl FnOnce() -> T) -> T {
+    let Some(stats) = stats else {
+        return step();
+    };
+    let started = Instant::now();
+    let result = step();
+    let elapsed = started.elapsed();
+    match stage {
+        Stage::Decode => stats.decode += elapsed,
+        Stage::Reconstruct => stats.reconstruct += elapsed,
+    }
+    result
+}
+
+/// The `diagnostics` of the JSON report. Times are integer nanoseconds; the stage times are
+/// summed over the logs, which can make them add up to more than the wall time.
+#[derive(Serialize)]
+pub struct Diagnostics {
+    pub logs: u64,
+    pub bytes_read: u64,
+    pub decompressed_bytes: u64,
+    pub records: u64,
+    pub spawns: u64,
+    pub wall_nanos: u64,
+    pub bytes_per_second: f64,
+    pub records_per_second: f64,
+    pub read_nanos: u64,
+    pub decompress_nanos: u64,
+    pub decode_nanos: u64,
+    pub reconstruct_nanos: u64,
+    /// Record framing, filtering and whatever else the parse spent time on.
+    pub other_nanos: u64,
+    /// From the end of the last parse until now.
+    pub analysis_nanos: u64,
+    /// Peak resident memory of the process, where the platform tells it.
+    pub peak_memory_bytes: Option<u64>,
+}
+
+fn nanos(duration: Duration) -> u64 {
+    u64::try_from(duration.as_nanos()).unwrap_or(u64::MAX)
+}
+
+/// The statistics collected so far, with --parse-stats.
+pub fn diagnostics() -> Option<Diagnostics> {
+    if !enabled() {
+        return None;
+    }
+    let totals = totals();
+    let stages = totals.read + totals.decompress + totals.decode + totals.reconstruct;
+    let seconds = totals.wall.as_secs_f64().max(f64::MIN_POSITIVE);
+    Some(Diagnostics {
+        logs: totals.logs,
+        bytes_read: totals.bytes_read,
+        decompressed_bytes: totals.decompressed_bytes,
+        records: totals.records,
+        spawns: totals.spawns,
+        wall_nanos: nanos(totals.wall),
+        bytes_per_second: totals.bytes_read as f64 / seconds,
+        records_per_second: totals.records as f64 / seconds,
+        read_nanos: nanos(totals.read),
+        decompress_nanos: nanos(totals.decompress),
+        decode_nanos: nanos(totals.decode),
+        reconstruct_nanos: nanos(totals.reconstruct),
+        other_nanos: nanos(totals.parsing.saturating_sub(stages)),
+        analysis_nanos: totals.parsed_at.map_or(0, |at| nanos(at.elapsed())),
+        peak_memory_bytes: peak_memory(),
+    })
+}
+
+/// The high-water mark of the resident memory, which includes the heap, from
+/// `/proc/self/status`; `None` elsewhere.
+fn peak_memory() -> Option<u64> {
+    let status = std::fs::read_to_string("/proc/self/status").ok()?;
+    let line = status
+        .lines()
+        .find_map(|line| line.strip_prefix("VmHWM:"))?;
+    let kilobytes: u64 = line.trim().strip_suffix("kB")?.trim().parse().ok()?;
+    Some(kilobytes * 1024)
+}
+
+/// Prints the statistics to stderr, with --parse-stats, once any log was parsed.
+pub fn finish() {
+    let Some(stats) = diagnostics().filter(|stats| stats.logs > 0) else {
+        return;
+    };
+    let time = |nanos: u64| format_duration(Duration::from_nanos(nanos), 3);
+    // (stage, time, of compact logs only)
+    let stages = [
+        ("Reading", stats.read_nanos, false),
+        ("Decompressing", stats.decompress_nanos, true),
+        ("Decoding protobuf", stats.decode_nanos, false),
+        ("Reconstructing spawns", stats.reconstruct_nanos, true),
+        ("Other parsing", stats.other_nanos, false),
+    ];
+    let parsing: u64 = stages.iter().map(|(_, nanos, _)| nanos).sum();
+
+    eprintln!("--- Parse Statistics ---");
+    eprint!(
+        "Read {} from {} log{}",
+        format_bytes(stats.bytes_read as i64),
+        stats.logs,
+        if stats.logs == 1 { "" } else { "s" }
+    );
+    if stats.decompressed_bytes > 0 {
+        eprint!(
+            ", {} decompressed",
+            format_bytes(stats.decompressed_bytes as i64)
+        );
+    }
+    eprintln!(
+        "; {} records decoded, {} spawns.",
+        stats.records, stats.spawns
+    );
+    eprintln!(
+        "Parsing took {} ({}/s, {:.0} records/s).",
+        time(stats.wall_nanos),
+        format_bytes(stats.bytes_per_second as i64),
+        stats.records_per_second
+    );
+    for (stage, nanos, compact) in stages {
+        if compact && stats.decompressed_bytes == 0 {
+            continue;
+        }
+        eprintln!(
+            "  {:<22} {:>10} ({:.1}%)",
+            format!("{}:", stage),
+            time(nanos),
+            nanos as f64 / parsing.max(1) as f64 * 100.0
+        );
+    }
+    if stats.logs > 1 {
+        eprintln!(
+            "  (summed over the {} logs, which can exceed the parsing time when they are parsed in parallel)",
+            stats.logs
+        );
+    }
+    eprintln!("Analysis and report: {}.", time(stats.analysis_nanos));
+    if let Some(peak) = stats.peak_memory_bytes {
+        eprintln!("Peak memory: {} resident.", format_bytes(peak as i64));
+    }
+}
@@ -0,0 +1,206 @@
+//! Machine-specific prefixes of the paths in spawn arguments and file lists, such as
+//! `/home/ci/.cache/bazel/_bazel_ci/<hash>/execroot/<workspace>/` or the directory of a
+//! sandbox. With --relative-paths, the default, reports print and group paths without them,
+//! so that a file has the same path in the logs of two machines. JSON, CSV and the digests of
+//! --compute-action-digests keep the paths as recorded.
+//!
+//! The prefixes are recognized by the layout of Bazel's output base, whatever the user, the
+//! output base hash or the workspace is called; --strip-path-prefix adds others, such as the
+//! working directory of a remote executor.
+
+use std::borrow::Cow;
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::Mutex;
+
+static ENABLED: AtomicBool = AtomicBool::new(true);
+
+/// The --strip-path-prefix prefixes, each ending in `/`.
+static EXTRA_PREFIXES: Mutex<Vec<String>> = Mutex::new(Vec::new());
+
+/// Strips the prefixes from the paths reports print when `enabled` (--relative-paths) is set,
+/// `prefixes` before the recognized ones.
+pub fn configure(enabled: bool, prefixes: &[String]) {
+    ENABLED.store(enabled, Ordering::Relaxed);
+    *extra_prefixes() = prefixes
+        .iter()
+        .map(|prefix| format!("{}/", prefix.trim_end_matches('/')))
+        .filter(|prefix| prefix != "/")
+        .collect();
+}
+
+pub fn enabled() -> bool {
+    ENABLED.load(Ordering::Relaxed)
+}
+
+fn extra_prefixes() -> std::sync::MutexGuard<'static, Vec<String>> {
+    EXTRA_PREFIXES
+        .lock()
+        .unwrap_or_else(|poisoned| poisoned.into_inner())
+}
+
+/// Directories that, with the one component after them, make up an execroot: `execroot/<name>`
+/// of the output base or of a sandbox, and the working directory and source roots of the
+/// hermetic Linux sandbox.
+const EXECROOT_DIRS: [&str; 3] = ["execroot", "bazel-working-directory", "bazel-source-roots"];
+
+/// `text`, a path or an argument with paths in it, as reports print and group it: with
+/// --relative-paths, relative to the execroot as [`strip_prefixes`] makes it.
+pub fn relative(text: &str) -> Cow<'_, str> {
+    if enabled() {
+        strip_prefixes(text)
+    } else {
+        Cow::Borrowed(text)
+    }
+}
+
+/// `text` with the execroot, sandbox and output base prefixes of the absolute paths in it
+/// removed, which leaves `bazel-out/...`, `external/...` or a workspace path. A path is any run
+/// of characters other than whitespace, quotes and `=,:;@`, as in `--out=/...`, classpaths or
+/// `@/.../x.params`, and can follow a flag such as `-I`.
+pub fn strip_prefixes(text: &str) -> Cow<'_, str> {
+    if !text.contains('/') {
+        return Cow::Borrowed(text);
+    }
+    let extra = extra_prefixes();
+    let mut stripped: Option<String> = None;
+    let mut copied = 0;
+    let mut begin = 0;
+    for (end, c) in text.char_indices().chain([(text.len(), ' ')]) {
+        if !is_separator(c) {
+            continue;
+        }
+        let token = &text[begin..end];
+        if let Some(slash) = token.find('/') {
+            let lead = &token[..slash];
+            if lead.is_empty() || lead.starts_with('-') {
+                if let Some(head) = prefix_len(&token[slash..], &extra) {
+                    let start = begin + slash;
+                    let stripped = stripped.get_or_insert_with(String::new);
+                    stripped.push_str(&text[copied..start]);
+                    copied = start + head;
+                }
+            }
+        }
+        begin = end + c.len_utf8();
+    }
+    match stripped {
+        Some(mut stripped) => {
+            stripped.push_str(&text[copied..]);
+            Cow::Owned(stripped)
+        }
+        None => Cow::Borrowed(text),
+    }
+}
+
+fn is_separator(c: char) -> bool {
+    c.is_whitespace() || "\"'=,:;@".contains(c)
+}
+
+/// The length of the machine-specific prefix of the absolute `path`, if it has one and
+/// something follows it.
+fn prefix_len(path: &str, extra: &[String]) -> Option<usize> {
+    if let Some(prefix) = extra.iter().find(|prefix| path.starts_with(prefix.as_str())) {
+        return (path.len() > prefix.len()).then_some(prefix.len());
+    }
+    let mut offset = 0;
+    let components: Vec<(usize, &str)> = path
+        .split('/')
+        .map(|component| {
+            let at = offset;
+            offset += component.len() + 1;
+            (at, component)
+        })
+        .collect();
+    // The last execroot, so that a sandbox's below the output base goes with it.
+    let head = components
+        .windows(2)
+        .rposition(|pair| EXECROOT_DIRS.contains(&pair[0].1) && !pair[1].1.is_empty())
+        .or_else(|| {
+            components
+                .windows(2)
+                .position(|pair| pair[0].1.starts_with("_bazel_") && !pair[1].1.is_empty())
+        })?;
+    let &(at, _) = components.get(head + 2)?;
+    (at < path.len()).then_some(at)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    /// The output base of a Linux CI machine and its execroot and a sandbox's.
+    const LINUX_BASE: &str = "/home/ci/.cache/bazel/_bazel_ci/0fa3b1c2d4e5f60718293a4b5c6d7e8f";
+    const LINUX_EXECROOT: &str = concat!(
+        "/home/ci/.cache/bazel/_bazel_ci/0fa3b1c2d4e5f60718293a4b5c6d7e8f",
+        "/execroot/myrepo"
+    );
+    const LINUX_SANDBOX: &str = concat!(
+        "/home/ci/.cache/bazel/_bazel_ci/0fa3b1c2d4e5f60718293a4b5c6d7e8f",
+        "/sandbox/linux-sandbox/42/execroot/myrepo"
+    );
+    /// A darwin-sandbox execroot below the output base macOS keeps in /private/var/tmp.
+    const MACOS_SANDBOX: &str = concat!(
+        "/private/var/tmp/_bazel_dev/5e8c0d1f2a3b4c5d6e7f8091a2b3c4d5",
+        "/sandbox/darwin-sandbox/7/execroot/myrepo"
+    );
+
+    #[test]
+    fn execroots_of_linux_and_macos_output_bases_are_stripped() {
+        for root in [LINUX_EXECROOT, LINUX_SANDBOX, MACOS_SANDBOX] {
+            for path in ["bazel-out/k8-opt/bin/app/lib.jar", "external/zlib/zlib.h", "app/A.java"] {
+                assert_eq!(strip_prefixes(&format!("{}/{}", root, path)), path);
+            }
+        }
+        // Outside the execroot, only the output base goes.
+        let external = format!("{}/external/zlib/BUILD.bazel", LINUX_BASE);
+        assert_eq!(strip_prefixes(&external), "external/zlib/BUILD.bazel");
+        let hermetic = "/tmp/bazel-working-directory/myrepo/bazel-out/k8-opt/bin/a.o";
+        assert_eq!(strip_prefixes(hermetic), "bazel-out/k8-opt/bin/a.o");
+        let source_root = "/tmp/bazel-source-roots/0/app/main.cc";
+        assert_eq!(strip_prefixes(source_root), "app/main.cc");
+    }
+
+    #[test]
+    fn paths_inside_arguments_are_stripped() {
+        let cases = [
+            (format!("-I{}/external/zlib", LINUX_EXECROOT), "-Iexternal/zlib".to_string()),
+            (
+                format!("--output={}/bazel-out/darwin_arm64-fastbuild/bin/a.o", MACOS_SANDBOX),
+                "--output=bazel-out/darwin_arm64-fastbuild/bin/a.o".to_string(),
+            ),
+            (format!("{0}/a.jar:{0}/b.jar", LINUX_SANDBOX), "a.jar:b.jar".to_string()),
+            (format!("@{}/app/lib.params", MACOS_SANDBOX), "@app/lib.params".to_string()),
+            // The execroot itself has nothing after its prefix to print instead.
+            (
+                format!("cd {} && ./configure", LINUX_EXECROOT),
+                format!("cd {} && ./configure", LINUX_EXECROOT),
+            ),
+        ];
+        for (arg, expected) in cases {
+            assert_eq!(strip_prefixes(&arg), expected);
+        }
+    }
+
+    #[test]
+    fn paths_without_a_known_prefix_are_kept() {
+        for path in [
+            "bazel-out/k8-fastbuild/bin/app/lib.jar",
+            "/usr/bin/gcc",
+            "/Applications/Xcode.app/Contents/Developer/usr/bin/clang",
+            "src/execroot/notes.txt",
+            "https://example.com/execroot/x",
+            "",
+        ] {
+            assert!(matches!(strip_prefixes(path), Cow::Borrowed(p) if p == path), "{}", path);
+        }
+    }
+
+    #[test]
+    fn extra_prefixes_come_before_the_layout() {
+        let extra = ["/b/f/w/".to_string()];
+        assert_eq!(prefix_len("/b/f/w/bazel-out/k8-opt/bin/a.o", &extra), Some(7));
+        assert_eq!(prefix_len("/b/f/w/", &extra), None);
+        let path = format!("{}/a.o", LINUX_EXECROOT);
+        assert_eq!(prefix_len(&path, &extra), Some(LINUX_EXECROOT.len() + 1));
+    }
+}
@@ -0,0 +1,713 @@
+//! --cpuprofile and --memprofile: pprof profiles of a whole run, for performance bug reports
+//! that need more than the stage times of --debug-timing. `go tool pprof <binary> <profile>`
+//! reads them and resolves their addresses against the binary.
+//!
+//! The CPU profile samples the stack of the running thread every 10ms of CPU time, from a
+//! SIGPROF handler. The memory profile samples the stack of one allocation per 512 KiB
+//! allocated, from [`crate::timing::CountingAllocator`], so a program using the crate gets one
+//! only if it installs that allocator, as the binary does. It shows where the run allocated,
+//! not what was live at its peak, which --debug-timing prints the size of.
+//!
+//! Stacks are taken with the C library's `backtrace` into buffers allocated when the flags are
+//! read, and are written as uncompressed `profile.proto` once the command is done. Profiles are
+//! available on Linux with glibc and on macOS.
+
+use crate::timing;
+use crate::AppResult;
+use std::cell::Cell;
+use std::collections::BTreeMap;
+use std::fs::File;
+use std::io::{self, BufWriter, Write};
+use std::path::{Path, PathBuf};
+use std::sync::atomic::{AtomicBool, AtomicU64, AtomicUsize, Ordering};
+use std::sync::{Mutex, OnceLock};
+use std::time::{Duration, Instant, SystemTime, UNIX_EPOCH};
+
+/// CPU time between two samples, the 100 per second pprof expects.
+const CPU_PERIOD: Duration = Duration::from_millis(10);
+
+/// Bytes allocated between two memory samples.
+const MEMORY_PERIOD: u64 = 512 * 1024;
+
+/// Frames kept of each stack, innermost first.
+const MAX_FRAMES: usize = 64;
+
+/// Stacks each profile holds, 164s of CPU time or 8 GiB allocated; later ones are counted as
+/// dropped. A buffer takes about 8.5 MiB.
+const MAX_SAMPLES: usize = 16_384;
+
+/// A sampled stack, written by a signal handler or the allocator and so without locks or
+/// allocation. `depth` is set last and stays 0 until the frames are in.
+struct Slot {
+    depth: AtomicUsize,
+    weight: AtomicU64,
+    frames: [AtomicUsize; MAX_FRAMES],
+}
+
+/// The stacks of one profile.
+struct Samples {
+    slots: Box<[Slot]>,
+    next: AtomicUsize,
+    dropped: AtomicU64,
+}
+
+impl Samples {
+    fn new(capacity: usize) -> Self {
+        let slots = (0..capacity)
+            .map(|_| Slot {
+                depth: AtomicUsize::new(0),
+                weight: AtomicU64::new(0),
+                frames: std::array::from_fn(|_| AtomicUsize::new(0)),
+            })
+            .collect();
+        Samples {
+            slots,
+            next: AtomicUsize::new(0),
+            dropped: AtomicU64::new(0),
+        }
+    }
+
+    /// Stores `frames` with `weight`; async-signal-safe.
+    fn record(&self, frames: &[usize], weight: u64) {
+        if frames.is_empty() {
+            return;
+        }
+        let index = self.next.fetch_add(1, Ordering::Relaxed);
+        let Some(slot) = self.slots.get(index) else {
+            self.dropped.fetch_add(1, Ordering::Relaxed);
+            return;
+        };
+        for (stored, &frame) in slot.frames.iter().zip(frames) {
+            stored.store(frame, Ordering::Relaxed);
+        }
+        slot.weight.store(weight, Ordering::Relaxed);
+        slot.depth.store(frames.len().min(MAX_FRAMES), Ordering::Release);
+    }
+
+    /// The stacks recorded so far with their weights.
+    fn stacks(&self) -> Vec<(Vec<u64>, u64)> {
+        self.slots
+            .iter()
+            .filter_map(|slot| {
+                let depth = slot.depth.load(Ordering::Acquire);
+                let frames = slot.frames[..depth]
+                    .iter()
+                    .map(|frame| frame.load(Ordering::Relaxed) as u64)
+                    .collect();
+                (depth > 0).then(|| (frames, slot.weight.load(Ordering::Relaxed)))
+            })
+            .collect()
+    }
+}
+
+static CPU_SAMPLES: OnceLock<Samples> = OnceLock::new();
+static MEMORY_SAMPLES: OnceLock<Samples> = OnceLock::new();
+static MEMORY_ENABLED: AtomicBool = AtomicBool::new(false);
+
+/// Bytes allocated since --memprofile was read, which the samples are spaced by.
+static ALLOCATED_BYTES: AtomicU64 = AtomicU64::new(0);
+
+thread_local! {
+    /// Set while this thread takes a memory sample, so that the allocations of `backtrace`
+    /// are not sampled and SIGPROF does not unwind the thread a second time.
+    static SAMPLING: Cell<bool> = const { Cell::new(false) };
+}
+
+/// A profile being taken, with the file created for it when the flags were read.
+struct Output {
+    path: PathBuf,
+    file: File,
+    started: SystemTime,
+    clock: Instant,
+}
+
+static OUTPUTS: Mutex<(Option<Output>, Option<Output>)> = Mutex::new((None, None));
+
+fn create(flag: &str, path: &Path) -> io::Result<Output> {
+    let file = File::create(path).map_err(|err| {
+        io::Error::new(err.kind(), format!("{} {}: {}", flag, path.display(), err))
+    })?;
+    Ok(Output {
+        path: path.to_path_buf(),
+        file,
+        started: SystemTime::now(),
+        clock: Instant::now(),
+    })
+}
+
+/// Starts the CPU profile written to `cpu` (--cpuprofile) and the memory profile written to
+/// `memory` (--memprofile), creating both files first so that a bad path fails the run early.
+pub fn start(cpu: Option<&Path>, memory: Option<&Path>) -> AppResult<()> {
+    if cpu.is_none() && memory.is_none() {
+        return Ok(());
+    }
+    if !sys::SUPPORTED {
+        let flag = if cpu.is_some() { "--cpuprofile" } else { "--memprofile" };
+        return Err(crate::AppError::Analysis(format!(
+            "{} is only available on Linux with glibc and on macOS",
+            flag
+        )));
+    }
+    let cpu = cpu.map(|path| create("--cpuprofile", path)).transpose()?;
+    let memory = memory.map(|path| create("--memprofile", path)).transpose()?;
+    // The first call loads the unwinder, which must not happen in the signal handler.
+    sys::backtrace(&mut [0; 4]);
+    if memory.is_some() {
+        let _ = MEMORY_SAMPLES.set(Samples::new(MAX_SAMPLES));
+        MEMORY_ENABLED.store(true, Ordering::Relaxed);
+        timing::count_heap();
+    }
+    if cpu.is_some() {
+        let _ = CPU_SAMPLES.set(Samples::new(MAX_SAMPLES));
+        sys::start_cpu_timer()?;
+    }
+    *outputs() = (cpu, memory);
+    Ok(())
+}
+
+fn outputs() -> std::sync::MutexGuard<'static, (Option<Output>, Option<Output>)> {
+    OUTPUTS
+        .lock()
+        .unwrap_or_else(|poisoned| poisoned.into_inner())
+}
+
+/// Stops the profiles and writes them, once the command is done.
+pub fn finish() -> AppResult<()> {
+    let (cpu, memory) = std::mem::take(&mut *outputs());
+    if let Some(output) = cpu {
+        sys::stop_cpu_timer();
+        let samples = CPU_SAMPLES.get().expect("set when the profile started");
+        let kind = Kind {
+            sample_types: [("samples", "count"), ("cpu", "nanoseconds")],
+            period_type: ("cpu", "nanoseconds"),
+            period: CPU_PERIOD.as_nanos() as i64,
+            values: |count, _| [count, count * CPU_PERIOD.as_nanos() as i64],
+        };
+        write(output, samples, &kind, "CPU")?;
+    }
+    if let Some(output) = memory {
+        MEMORY_ENABLED.store(false, Ordering::Relaxed);
+        let samples = MEMORY_SAMPLES.get().expect("set when the profile started");
+        let kind = Kind {
+            sample_types: [("samples", "count"), ("alloc_space", "bytes")],
+            period_type: ("space", "bytes"),
+            period: MEMORY_PERIOD as i64,
+            values: |count, weight| [count, weight],
+        };
+        write(output, samples, &kind, "memory")?;
+    }
+    Ok(())
+}
+
+fn write(output: Output, samples: &Samples, kind: &Kind, name: &str) -> AppResult<()> {
+    let profile = encode(
+        &samples.stacks(),
+        kind,
+        &sys::mappings(),
+        output.started,
+        output.clock.elapsed(),
+    );
+    let mut out = BufWriter::new(output.file);
+    out.write_all(&profile)?;
+    out.flush()?;
+    let dropped = samples.dropped.load(Ordering::Relaxed);
+    if dropped > 0 {
+        eprintln!(
+            "Note: The {} profile leaves out {} samples past the first {}.",
+            name, dropped, MAX_SAMPLES
+        );
+    }
+    eprintln!("Wrote the {} profile to {}", name, output.path.display());
+    Ok(())
+}
+
+/// Takes a memory sample each time the bytes allocated pass another multiple of
+/// [`MEMORY_PERIOD`], weighted with the bytes since the last one. Called by
+/// [`crate::timing::CountingAllocator`] for every allocation while it counts.
+pub(crate) fn sample_allocation(size: usize) {
+    if !MEMORY_ENABLED.load(Ordering::Relaxed) {
+        return;
+    }
+    let size = size as u64;
+    let total = ALLOCATED_BYTES.fetch_add(size, Ordering::Relaxed) + size;
+    let periods = total / MEMORY_PERIOD - (total - size) / MEMORY_PERIOD;
+    if periods == 0 {
+        return;
+    }
+    // Not sampled while the thread is torn down and its flag is gone.
+    let _ = SAMPLING.try_with(|sampling| {
+        if sampling.replace(true) {
+            return;
+        }
+        let mut frames = [0; MAX_FRAMES + 1];
+        let depth = sys::backtrace(&mut frames);
+        if let Some(samples) = MEMORY_SAMPLES.get() {
+            // The first frame is this function's.
+            samples.record(&frames[depth.min(1)..depth], periods * MEMORY_PERIOD);
+        }
+        sampling.set(false);
+    });
+}
+
+/// The sample values and period of one kind of profile.
+struct Kind {
+    sample_types: [(&'static str, &'static str); 2],
+    period_type: (&'static str, &'static str),
+    period: i64,
+    /// The values of a stack sampled `count` times with a summed weight.
+    values: fn(i64, i64) -> [i64; 2],
+}
+
+/// A mapped executable file, which pprof resolves the addresses inside it against.
+#[derive(Clone, Debug, PartialEq)]
+struct Mapping {
+    start: u64,
+    limit: u64,
+    offset: u64,
+    path: String,
+}
+
+/// The few `profile.proto` fields a profile needs, written by hand.
+#[derive(Default)]
+struct Encoder {
+    bytes: Vec<u8>,
+}
+
+impl Encoder {
+    fn varint(&mut self, mut value: u64) {
+        while value >= 0x80 {
+            self.bytes.push(value as u8 | 0x80);
+            value >>= 7;
+        }
+        self.bytes.push(value as u8);
+    }
+
+    fn key(&mut self, field: u32, wire_type: u8) {
+        self.varint(u64::from(field) << 3 | u64::from(wire_type));
+    }
+
+    fn uint(&mut self, field: u32, value: u64) {
+        if value != 0 {
+            self.key(field, 0);
+            self.varint(value);
+        }
+    }
+
+    fn bytes(&mut self, field: u32, data: &[u8]) {
+        self.key(field, 2);
+        self.varint(data.len() as u64);
+        self.bytes.extend_from_slice(data);
+    }
+
+    fn message(&mut self, field: u32, build: impl FnOnce(&mut Encoder)) {
+        let mut message = Encoder::default();
+        build(&mut message);
+        self.bytes(field, &message.bytes);
+    }
+
+    fn packed(&mut self, field: u32, values: impl IntoIterator<Item = u64>) {
+        let mut packed = Encoder::default();
+        for value in values {
+            packed.varint(value);
+        }
+        self.bytes(field, &packed.bytes);
+    }
+}
+
+/// The string table of a profile, whose first entry is the empty string.
+struct Strings {
+    indexes: BTreeMap<String, u64>,
+    table: Vec<String>,
+}
+
+impl Strings {
+    fn new() -> Self {
+        let mut strings = Strings {
+            indexes: BTreeMap::new(),
+            table: Vec::new(),
+        };
+        strings.index("");
+        strings
+    }
+
+    fn index(&mut self, text: &str) -> u64 {
+        if let Some(&index) = self.indexes.get(text) {
+            return index;
+        }
+        let index = self.table.len() as u64;
+        self.indexes.insert(text.to_string(), index);
+        self.table.push(text.to_string());
+        index
+    }
+}
+
+/// Encodes `stacks` as a `profile.proto` Profile of `kind`, identical stacks merged. Every
+/// frame but the innermost is a return address and is moved back into its call.
+fn encode(
+    stacks: &[(Vec<u64>, u64)],
+    kind: &Kind,
+    mappings: &[Mapping],
+    started: SystemTime,
+    duration: Duration,
+) -> Vec<u8> {
+    let mut merged: BTreeMap<Vec<u64>, (i64, i64)> = BTreeMap::new();
+    for (frames, weight) in stacks {
+        let addresses = frames
+            .iter()
+            .enumerate()
+            .map(|(index, &frame)| if index == 0 { frame } else { frame.saturating_sub(1) })
+            .collect();
+        let entry = merged.entry(addresses).or_default();
+        entry.0 += 1;
+        entry.1 += *weight as i64;
+    }
+    let mut locations: BTreeMap<u64, u64> = BTreeMap::new();
+    for address in merged.keys().flatten() {
+        let next = locations.len() as u64 + 1;
+        locations.entry(*address).or_insert(next);
+    }
+
+    let mut strings = Strings::new();
+    let mut profile = Encoder::default();
+    for (kind_name, unit) in kind.sample_types {
+        let (kind_name, unit) = (strings.index(kind_name), strings.index(unit));
+        profile.message(1, |value_type| {
+            value_type.uint(1, kind_name);
+            value_type.uint(2, unit);
+        });
+    }
+    for (addresses, &(count, weight)) in &merged {
+        profile.message(2, |sample| {
+            sample.packed(1, addresses.iter().map(|address| locations[address]));
+            sample.packed(2, (kind.values)(count, weight).map(|value| value as u64));
+        });
+    }
+    for (index, mapping) in mappings.iter().enumerate() {
+        let path = strings.index(&mapping.path);
+        profile.message(3, |message| {
+            message.uint(1, index as u64 + 1);
+            message.uint(2, mapping.start);
+            message.uint(3, mapping.limit);
+            message.uint(4, mapping.offset);
+            message.uint(5, path);
+        });
+    }
+    for (&address, &id) in &locations {
+        let mapping = mappings
+            .iter()
+            .position(|m| (m.start..m.limit).contains(&address));
+        profile.message(4, |location| {
+            location.uint(1, id);
+            location.uint(2, mapping.map_or(0, |index| index as u64 + 1));
+            location.uint(3, address);
+        });
+    }
+    let (period_kind, period_unit) = (
+        strings.index(kind.period_type.0),
+        strings.index(kind.period_type.1),
+    );
+    for text in &strings.table {
+        profile.bytes(6, text.as_bytes());
+    }
+    let started = started.duration_since(UNIX_EPOCH).unwrap_or_default();
+    profile.uint(9, started.as_nanos() as u64);
+    profile.uint(10, duration.as_nanos() as u64);
+    profile.message(11, |value_type| {
+        value_type.uint(1, period_kind);
+        value_type.uint(2, period_unit);
+    });
+    profile.uint(12, kind.period as u64);
+    profile.bytes
+}
+
+/// The executable file mappings of `/proc/self/maps`.
+#[cfg(all(target_os = "linux", target_env = "gnu"))]
+fn parse_mappings(maps: &str) -> Vec<Mapping> {
+    maps.lines()
+        .filter_map(|line| {
+            let mut fields = line.split_whitespace();
+            let (start, limit) = fields.next()?.split_once('-')?;
+            let permissions = fields.next()?;
+            let offset = fields.next()?;
+            let path = fields.nth(2)?;
+            (permissions.contains('x') && path.starts_with('/')).then_some(())?;
+            Some(Mapping {
+                start: u64::from_str_radix(start, 16).ok()?,
+                limit: u64::from_str_radix(limit, 16).ok()?,
+                offset: u64::from_str_radix(offset, 16).ok()?,
+                path: path.to_string(),
+            })
+        })
+        .collect()
+}
+
+#[cfg(any(all(target_os = "linux", target_env = "gnu"), target_os = "macos"))]
+mod sys {
+    use super::{Mapping, CPU_PERIOD, CPU_SAMPLES, MAX_FRAMES, SAMPLING};
+    use std::io;
+    use std::ptr;
+
+    pub const SUPPORTED: bool = true;
+
+    /// Fills `frames` with the return addresses of the calling thread, innermost first, and
+    /// returns how many there are. Async-signal-safe once it has been called before.
+    pub fn backtrace(frames: &mut [usize]) -> usize {
+        // SAFETY: the buffer holds `frames.len()` pointer-sized entries.
+        let depth = unsafe { libc::backtrace(frames.as_mut_ptr().cast(), frames.len() as _) };
+        depth.max(0) as usize
+    }
+
+    #[cfg(target_os = "linux")]
+    unsafe extern "C" {
+        // Which the libc crate binds on macOS but not on Linux.
+        fn setitimer(
+            which: libc::c_int,
+            value: *const libc::itimerval,
+            old: *mut libc::itimerval,
+        ) -> libc::c_int;
+    }
+
+    #[cfg(target_os = "macos")]
+    use libc::setitimer;
+
+    #[cfg(target_os = "linux")]
+    fn errno() -> *mut libc::c_int {
+        // SAFETY: returns the calling thread's errno.
+        unsafe { libc::__errno_location() }
+    }
+
+    #[cfg(target_os = "macos")]
+    fn errno() -> *mut libc::c_int {
+        // SAFETY: returns the calling thread's errno.
+        unsafe { libc::__error() }
+    }
+
+    extern "C" fn on_sigprof(_: libc::c_int) {
+        // A thread taking a memory sample is already unwinding.
+        if SAMPLING.try_with(|sampling| sampling.get()).unwrap_or(true) {
+            return;
+        }
+        // SAFETY: errno is only read and restored, around calls that may set it.
+        let saved = unsafe { *errno() };
+        let mut frames = [0; MAX_FRAMES + 1];
+        let depth = backtrace(&mut frames);
+        if let Some(samples) = CPU_SAMPLES.get() {
+            // The first frame is the handler's.
+            samples.record(&frames[depth.min(1)..depth], 1);
+        }
+        unsafe { *errno() = saved };
+    }
+
+    fn timer(period: libc::suseconds_t) -> io::Result<()> {
+        let interval = libc::timeval {
+            tv_sec: 0,
+            tv_usec: period,
+        };
+        let timer = libc::itimerval {
+            it_interval: interval,
+            it_value: interval,
+        };
+        // SAFETY: a valid timer value, and no old value asked for.
+        if unsafe { setitimer(libc::ITIMER_PROF, &timer, ptr::null_mut()) } != 0 {
+            return Err(io::Error::last_os_error());
+        }
+        Ok(())
+    }
+
+    /// Installs the SIGPROF handler and starts the timer that sends it every [`CPU_PERIOD`]
+    /// of CPU time used by the process.
+    pub fn start_cpu_timer() -> io::Result<()> {
+        // SAFETY: the handler only calls async-signal-safe functions and stores atomics.
+        unsafe {
+            let mut action: libc::sigaction = std::mem::zeroed();
+            action.sa_sigaction = on_sigprof as usize;
+            action.sa_flags = libc::SA_RESTART;
+            libc::sigemptyset(&mut action.sa_mask);
+            if libc::sigaction(libc::SIGPROF, &action, ptr::null_mut()) != 0 {
+                return Err(io::Error::last_os_error());
+            }
+        }
+        timer(CPU_PERIOD.as_micros() as libc::suseconds_t)
+    }
+
+    /// Stops the timer; a SIGPROF still on its way is ignored rather than ending the process.
+    pub fn stop_cpu_timer() {
+        let _ = timer(0);
+        // SAFETY: ignoring a signal is always valid.
+        unsafe { libc::signal(libc::SIGPROF, libc::SIG_IGN) };
+    }
+
+    #[cfg(target_os = "linux")]
+    pub fn mappings() -> Vec<Mapping> {
+        std::fs::read_to_string("/proc/self/maps")
+            .map(|maps| super::parse_mappings(&maps))
+            .unwrap_or_default()
+    }
+
+    /// pprof finds the binary by the path given on its command line.
+    #[cfg(target_os = "macos")]
+    pub fn mappings() -> Vec<Mapping> {
+        Vec::new()
+    }
+}
+
+#[cfg(not(any(all(target_os = "linux", target_env = "gnu"), target_os = "macos")))]
+mod sys {
+    use super::Mapping;
+    use std::io;
+
+    pub const SUPPORTED: bool = false;
+
+    pub fn backtrace(_: &mut [usize]) -> usize {
+        0
+    }
+
+    pub fn start_cpu_timer() -> io::Result<()> {
+        Ok(())
+    }
+
+    pub fn stop_cpu_timer() {}
+
+    pub fn mappings() -> Vec<Mapping> {
+        Vec::new()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const KIND: Kind = Kind {
+        sample_types: [("samples", "count"), ("alloc_space", "bytes")],
+        period_type: ("space", "bytes"),
+        period: 4096,
+        values: |count, weight| [count, weight],
+    };
+
+    #[test]
+    fn varints_take_seven_bits_a_byte() {
+        let mut encoder = Encoder::default();
+        encoder.varint(1);
+        encoder.varint(300);
+        encoder.varint(u64::MAX);
+        assert_eq!(&encoder.bytes[..3], [0x01, 0xac, 0x02]);
+        assert_eq!(encoder.bytes.len(), 3 + 10);
+    }
+
+    #[test]
+    fn zero_integers_are_left_out_as_protobuf_defaults() {
+        let mut encoder = Encoder::default();
+        encoder.uint(1, 0);
+        encoder.uint(2, 5);
+        encoder.message(3, |message| message.packed(1, [1, 2]));
+        assert_eq!(encoder.bytes, [0x10, 0x05, 0x1a, 0x04, 0x0a, 0x02, 0x01, 0x02]);
+    }
+
+    #[test]
+    fn strings_are_interned_after_the_empty_string() {
+        let mut strings = Strings::new();
+        assert_eq!(strings.index("cpu"), 1);
+        assert_eq!(strings.index("count"), 2);
+        assert_eq!(strings.index("cpu"), 1);
+        assert_eq!(strings.index(""), 0);
+        assert_eq!(strings.table, ["", "cpu", "count"]);
+    }
+
+    #[test]
+    fn samples_past_the_capacity_are_counted_as_dropped() {
+        let samples = Samples::new(2);
+        samples.record(&[0x30, 0x20], 1);
+        samples.record(&[0x10; MAX_FRAMES + 8], 2);
+        samples.record(&[0x40], 3);
+        let stacks = samples.stacks();
+        assert_eq!(stacks, [(vec![0x30, 0x20], 1), (vec![0x10; MAX_FRAMES], 2)]);
+        assert_eq!(samples.dropped.load(Ordering::Relaxed), 1);
+    }
+
+    #[test]
+    fn identical_stacks_are_merged_into_one_sample() {
+        let stacks = [
+            (vec![0x1010, 0x2021], 100),
+            (vec![0x1010, 0x2021], 50),
+            (vec![0x3000], 7),
+        ];
+        let mappings = [Mapping {
+            start: 0x1000,
+            limit: 0x3000,
+            offset: 0,
+            path: "/bin/analyzer".to_string(),
+        }];
+        let profile = encode(&stacks, &KIND, &mappings, UNIX_EPOCH, Duration::from_secs(1));
+
+        let mut expected = Encoder::default();
+        expected.message(1, |value_type| {
+            value_type.uint(1, 1);
+            value_type.uint(2, 2);
+        });
+        expected.message(1, |value_type| {
+            value_type.uint(1, 3);
+            value_type.uint(2, 4);
+        });
+        // The return address 0x2021 becomes 0x2020, inside the call.
+        expected.message(2, |sample| {
+            sample.packed(1, [1, 2]);
+            sample.packed(2, [2, 150]);
+        });
+        expected.message(2, |sample| {
+            sample.packed(1, [3]);
+            sample.packed(2, [1, 7]);
+        });
+        expected.message(3, |mapping| {
+            mapping.uint(1, 1);
+            mapping.uint(2, 0x1000);
+            mapping.uint(3, 0x3000);
+            mapping.uint(5, 5);
+        });
+        for (id, address, mapping) in [(1, 0x1010, 1), (2, 0x2020, 1), (3, 0x3000, 0)] {
+            expected.message(4, |location| {
+                location.uint(1, id);
+                location.uint(2, mapping);
+                location.uint(3, address);
+            });
+        }
+        for text in ["", "samples", "count", "alloc_space", "bytes", "/bin/analyzer", "space"] {
+            expected.bytes(6, text.as_bytes());
+        }
+        expected.uint(10, 1_000_000_000);
+        expected.message(11, |value_type| {
+            value_type.uint(1, 6);
+            value_type.uint(2, 4);
+        });
+        expected.uint(12, 4096);
+        assert_eq!(profile, expected.bytes);
+    }
+
+    #[cfg(all(target_os = "linux", target_env = "gnu"))]
+    #[test]
+    fn only_executable_file_mappings_are_kept() {
+        let maps = "\
+55d0c0a00000-55d0c0a20000 r--p 00000000 08:01 1234 /usr/bin/analyzer
+55d0c0a20000-55d0c0c00000 r-xp 00020000 08:01 1234 /usr/bin/analyzer
+55d0c2000000-55d0c2100000 rw-p 00000000 00:00 0    [heap]
+7f0000000000-7f0000001000 r-xp 00000000 00:00 0    [vdso]
+7f0000100000-7f0000180000 r-xp 00028000 08:01 99   /usr/lib/libc.so.6
+";
+        assert_eq!(
+            parse_mappings(maps),
+            [
+                Mapping {
+                    start: 0x55d0c0a20000,
+                    limit: 0x55d0c0c00000,
+                    offset: 0x20000,
+                    path: "/usr/bin/analyzer".to_string(),
+                },
+                Mapping {
+                    start: 0x7f0000100000,
+                    limit: 0x7f0000180000,
+                    offset: 0x28000,
+                    path: "/usr/lib/libc.so.6".to_string(),
+                },
+            ]
+        );
+    }
+}
@@ -0,0 +1,161 @@
+//! The progress line on stderr while a log is parsed: bytes read of the file's size, spawns,
+//! elapsed and estimated remaining time, redrawn in place and cleared before anything else is
+//! printed. Without a known size (pipes, `/dev/stdin`) it shows spawns per second instead.
+//!
+//! Only drawn when stderr is a terminal and --no-progress is not set, and only while a single
+//! log is being parsed, so parallel parses do not overwrite each other's line.
+
+use crate::format::{format_bytes, format_duration_short};
+use std::cell::Cell;
+use std::io::{self, IsTerminal, Read, Write};
+use std::path::Path;
+use std::rc::Rc;
+use std::sync::atomic::{AtomicBool, AtomicUsize, Ordering};
+use std::time::{Duration, Instant};
+
+static ENABLED: AtomicBool = AtomicBool::new(false);
+
+/// Parses in progress, drawn or not.
+static ACTIVE: AtomicUsize = AtomicUsize::new(0);
+
+/// Parses faster than this never show a line.
+const DELAY: Duration = Duration::from_secs(1);
+
+const REDRAW_INTERVAL: Duration = Duration::from_millis(200);
+
+/// Spawns between checks of the clock.
+const RECORDS_PER_CHECK: u64 = 256;
+
+const SPINNER: [char; 4] = ['|', '/', '-', '\\'];
+
+/// Shows progress when stderr is a terminal, unless `disabled` (--no-progress) is set.
+pub fn configure(disabled: bool) {
+    ENABLED.store(!disabled && io::stderr().is_terminal(), Ordering::Relaxed);
+}
+
+/// Progress of one parse, cleared when dropped.
+pub struct Progress {
+    name: String,
+    /// Size of the file, when it is a regular file.
+    total: Option<u64>,
+    /// Bytes read from the file, counted by the [`CountingReader`].
+    read: Rc<Cell<u64>>,
+    records: u64,
+    started: Instant,
+    last_draw: Option<Instant>,
+    frame: usize,
+}
+
+impl Progress {
+    pub fn new(path: &Path, total: Option<u64>) -> Self {
+        ACTIVE.fetch_add(1, Ordering::Relaxed);
+        Progress {
+            name: path.file_name().map_or_else(
+                || path.display().to_string(),
+                |name| name.to_string_lossy().into_owned(),
+            ),
+            total,
+            read: Rc::new(Cell::new(0)),
+            records: 0,
+            started: Instant::now(),
+            last_draw: None,
+            frame: 0,
+        }
+    }
+
+    /// Wraps `inner` so that the bytes read from it are counted.
+    pub fn reader<R: Read>(&self, inner: R) -> CountingReader<R> {
+        CountingReader {
+            inner,
+            read: Rc::clone(&self.read),
+        }
+    }
+
+    /// Counts one record, redrawing the line now and then.
+    pub fn record(&mut self) {
+        self.records += 1;
+        if self.records % RECORDS_PER_CHECK != 0 || !ENABLED.load(Ordering::Relaxed) {
+            return;
+        }
+        if ACTIVE.load(Ordering::Relaxed) > 1 {
+            self.clear();
+            return;
+        }
+        let elapsed = self.started.elapsed();
+        if elapsed < DELAY
+            || self
+                .last_draw
+                .is_some_and(|last| last.elapsed() < REDRAW_INTERVAL)
+        {
+            return;
+        }
+        self.last_draw = Some(Instant::now());
+        let line = match self.total.filter(|&total| total > 0) {
+            Some(total) => {
+                let read = self.read.get().min(total);
+                let fraction = read as f64 / total as f64;
+                let remaining = if fraction > 0.0 {
+                    format!(
+                        ", ~{} left",
+                        format_duration_short(elapsed.mul_f64((1.0 - fraction) / fraction))
+                    )
+                } else {
+                    String::new()
+                };
+                format!(
+                    "Parsing {}: {} of {} ({:.0}%), {} spawns, {} elapsed{}",
+                    self.name,
+                    format_bytes(read as i64),
+                    format_bytes(total as i64),
+                    fraction * 100.0,
+                    self.records,
+                    format_duration_short(elapsed),
+                    remaining
+                )
+            }
+            None => {
+                self.frame = (self.frame + 1) % SPINNER.len();
+                format!(
+                    "Parsing {}: {} {} spawns ({:.0}/s), {} elapsed",
+                    self.name,
+                    SPINNER[self.frame],
+                    self.records,
+                    self.records as f64 / elapsed.as_secs_f64(),
+                    format_duration_short(elapsed)
+                )
+            }
+        };
+        let mut err = io::stderr().lock();
+        let _ = write!(err, "\r\x1b[2K{}", line);
+        let _ = err.flush();
+    }
+
+    fn clear(&mut self) {
+        if self.last_draw.take().is_some() {
+            let mut err = io::stderr().lock();
+            let _ = write!(err, "\r\x1b[2K");
+            let _ = err.flush();
+        }
+    }
+}
+
+impl Drop for Progress {
+    fn drop(&mut self) {
+        self.clear();
+        ACTIVE.fetch_sub(1, Ordering::Relaxed);
+    }
+}
+
+/// A reader that adds the bytes read through it to its [`Progress`].
+pub struct CountingReader<R> {
+    inner: R,
+    read: Rc<Cell<u64>>,
+}
+
+impl<R: Read> Read for CountingReader<R> {
+    fn read(&mut self, buf: &mut [u8]) -> io::Result<usize> {
+        let count = self.inner.read(buf)?;
+        self.read.set(self.read.get() + count as u64);
+        Ok(count)
+    }
+}
@@ -1,5 +1,5 @@
 // Auto-generated protobuf bindings
-// This module contains the generated Rust structs from spawn.proto
+// This module contains the generated Rust structs from spawn.proto and remote_execution.proto
 
 pub mod tools {
     pub mod protos {
@@ -7,5 +7,10 @@ pub mod tools {
     }
 }
 
+/// Remote Execution API messages, used to recompute action digests.
+pub mod reapi {
+    include!(concat!(env!("OUT_DIR"), "/build.bazel.remote.execution.v2.rs"));
+}
+
 // Re-export commonly used types for convenience
 pub use tools::protos::*;
@@ -0,0 +1,262 @@
+//! Recomputes Remote Execution API (REAPI) digests for spawns.
+//!
+//! The Command, input root and Action messages are rebuilt from the spawn record the way
+//! Bazel builds them for remote execution, then hashed with SHA-256. Known caveats:
+//!
+//! - Every input file is marked executable, matching Bazel's input Merkle trees; node
+//!   properties (mtime, permissions) are never set.
+//! - Runfiles trees and tool inputs are taken as recorded in the log. Inputs that Bazel
+//!   adds at execution time (e.g. the `.runfiles` marker) are not recreated.
+//! - Output directories are only known for tree artifacts the log lists contents for; all
+//!   other declared outputs go into `output_files`. `output_paths` (REAPI v2.1) is not used.
+//! - Digests computed with other hash functions or salted by the executor will not match.
+
+use crate::metrics::{directory_outputs, is_symlink};
+use crate::proto::reapi;
+use crate::proto::{File, SpawnExec};
+use prost::Message;
+use sha2::{Digest as _, Sha256};
+use std::collections::{BTreeMap, HashSet};
+
+/// The REAPI digests of a spawn.
+pub struct ActionDigests {
+    pub action: reapi::Digest,
+    pub command: reapi::Digest,
+    pub input_root: reapi::Digest,
+}
+
+/// Formats a digest as `hash/size`, the usual notation in REAPI tooling.
+pub fn format_digest(digest: &reapi::Digest) -> String {
+    format!("{}/{}", digest.hash, digest.size_bytes)
+}
+
+fn digest_of(bytes: &[u8]) -> reapi::Digest {
+    let hash = Sha256::digest(bytes)
+        .iter()
+        .map(|b| format!("{:02x}", b))
+        .collect();
+    reapi::Digest {
+        hash,
+        size_bytes: bytes.len() as i64,
+    }
+}
+
+fn sorted_platform(platform: Option<&crate::proto::Platform>) -> Option<reapi::Platform> {
+    let platform = platform.filter(|p| !p.properties.is_empty())?;
+    let mut properties: Vec<reapi::platform::Property> = platform
+        .properties
+        .iter()
+        .map(|p| reapi::platform::Property {
+            name: p.name.clone(),
+            value: p.value.clone(),
+        })
+        .collect();
+    properties.sort_by(|a, b| a.name.cmp(&b.name).then(a.value.cmp(&b.value)));
+    Some(reapi::Platform { properties })
+}
+
+/// A directory of the input root under construction.
+#[derive(Default)]
+struct TreeNode {
+    files: BTreeMap<String, reapi::Digest>,
+    symlinks: BTreeMap<String, String>,
+    directories: BTreeMap<String, TreeNode>,
+}
+
+impl TreeNode {
+    fn insert(&mut self, file: &File) {
+        let mut components: Vec<&str> = file.path.split('/').filter(|c| !c.is_empty()).collect();
+        let Some(name) = components.pop() else {
+            return;
+        };
+        let mut node = self;
+        for component in components {
+            node = node.directories.entry(component.to_string()).or_default();
+        }
+        if is_symlink(file) {
+            node.symlinks
+                .insert(name.to_string(), file.symlink_target_path.clone());
+        } else {
+            // Logs may omit the digest of empty files.
+            let digest = file.digest.as_ref().map_or_else(
+                || digest_of(&[]),
+                |d| reapi::Digest {
+                    hash: d.hash.clone(),
+                    size_bytes: d.size_bytes,
+                },
+            );
+            node.files.insert(name.to_string(), digest);
+        }
+    }
+
+    /// Serializes the directory (children first) and returns its digest.
+    fn digest(&self) -> reapi::Digest {
+        let directory = reapi::Directory {
+            files: self
+                .files
+                .iter()
+                .map(|(name, digest)| reapi::FileNode {
+                    name: name.clone(),
+                    digest: Some(digest.clone()),
+                    is_executable: true,
+                })
+                .collect(),
+            directories: self
+                .directories
+                .iter()
+                .map(|(name, node)| reapi::DirectoryNode {
+                    name: name.clone(),
+                    digest: Some(node.digest()),
+                })
+                .collect(),
+            symlinks: self
+                .symlinks
+                .iter()
+                .map(|(name, target)| reapi::SymlinkNode {
+                    name: name.clone(),
+                    target: target.clone(),
+                })
+                .collect(),
+        };
+        digest_of(&directory.encode_to_vec())
+    }
+}
+
+/// Rebuilds the REAPI Command message of a spawn.
+fn command(spawn: &SpawnExec) -> reapi::Command {
+    let mut environment_variables: Vec<reapi::command::EnvironmentVariable> = spawn
+        .environment_variables
+        .iter()
+        .map(|e| reapi::command::EnvironmentVariable {
+            name: e.name.clone(),
+            value: e.value.clone(),
+        })
+        .collect();
+    environment_variables.sort_by(|a, b| a.name.cmp(&b.name));
+
+    let directories: HashSet<&str> = directory_outputs(spawn).0.iter().map(|d| d.path).collect();
+    let mut output_files = Vec::new();
+    let mut output_directories = Vec::new();
+    for path in &spawn.listed_outputs {
+        if directories.contains(path.as_str()) {
+            output_directories.push(path.clone());
+        } else {
+            output_files.push(path.clone());
+        }
+    }
+    output_files.sort();
+    output_directories.sort();
+
+    reapi::Command {
+        arguments: spawn.command_args.clone(),
+        environment_variables,
+        output_files,
+        output_directories,
+        platform: sorted_platform(spawn.platform.as_ref()),
+        working_directory: String::new(),
+        output_paths: Vec::new(),
+    }
+}
+
+/// Computes the Action, Command and input root digests of a spawn.
+pub fn compute(spawn: &SpawnExec) -> ActionDigests {
+    let command_digest = digest_of(&command(spawn).encode_to_vec());
+
+    let mut root = TreeNode::default();
+    for input in &spawn.inputs {
+        root.insert(input);
+    }
+    let input_root_digest = root.digest();
+
+    let timeout = (spawn.timeout_millis > 0).then(|| prost_types::Duration {
+        seconds: spawn.timeout_millis / 1000,
+        nanos: (spawn.timeout_millis % 1000 * 1_000_000) as i32,
+    });
+    let action = reapi::Action {
+        command_digest: Some(command_digest.clone()),
+        input_root_digest: Some(input_root_digest.clone()),
+        timeout,
+        do_not_cache: !spawn.remote_cacheable,
+        platform: sorted_platform(spawn.platform.as_ref()),
+    };
+
+    ActionDigests {
+        action: digest_of(&action.encode_to_vec()),
+        command: command_digest,
+        input_root: input_root_digest,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::proto::{Digest, EnvironmentVariable};
+
+    fn env(name: &str, value: &str) -> EnvironmentVariable {
+        EnvironmentVariable {
+            name: name.to_string(),
+            value: value.to_string(),
+        }
+    }
+
+    /// `/bin/echo hello` with one input file. The expected digests are the SHA-256 of the
+    /// REAPI messages encoded by hand from the wire format.
+    fn echo() -> SpawnExec {
+        SpawnExec {
+            command_args: vec!["/bin/echo".to_string(), "hello".to_string()],
+            environment_variables: vec![env("B", "2"), env("A", "1")],
+            inputs: vec![File {
+                path: "pkg/a.txt".to_string(),
+                digest: Some(Digest {
+                    hash: "a".repeat(64),
+                    size_bytes: 5,
+                    ..Default::default()
+                }),
+                ..Default::default()
+            }],
+            remote_cacheable: true,
+            ..Default::default()
+        }
+    }
+
+    #[test]
+    fn empty_input_root_has_the_well_known_digest() {
+        let digests = compute(&SpawnExec::default());
+        assert_eq!(
+            format_digest(&digests.input_root),
+            "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855/0"
+        );
+    }
+
+    #[test]
+    fn digests_match_the_hand_encoded_messages() {
+        let digests = compute(&echo());
+        assert_eq!(
+            format_digest(&digests.command),
+            "4839c96c3776158ef2ac3d130174bc4b537142967d431779d8703bf37a0c5167/34"
+        );
+        assert_eq!(
+            format_digest(&digests.input_root),
+            "30700ab9a44ac22149b90bfb7c7c6544b2a4028f25482a3cf36ab4f481f873c4/77"
+        );
+        assert_eq!(
+            format_digest(&digests.action),
+            "ee5c2b93f5e0a9d301e621d0fed8b2b0208708c0b4c1045b341a544f74bf51bd/140"
+        );
+    }
+
+    #[test]
+    fn timeout_and_do_not_cache_change_only_the_action() {
+        let spawn = SpawnExec {
+            timeout_millis: 1_500,
+            remote_cacheable: false,
+            ..echo()
+        };
+        let digests = compute(&spawn);
+        assert_eq!(digests.command, compute(&echo()).command);
+        assert_eq!(
+            format_digest(&digests.action),
+            "88c3513c14ef74e449fb0fdb4b23ae6ab43d3108a33e83ae934ed5d941e8a0ea/152"
+        );
+    }
+}
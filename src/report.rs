@@ -0,0 +1,375 @@
+//! The main report as data: the overall summary, the slowest actions and the mnemonic table,
+//! computed once from the spawns and written by a [`Renderer`], e.g. the text report of
+//! `reports::text` or the `--output-format json` document of `reports::json`. The formats
+//! --output-format takes are looked up by name in a registry that programs using the crate
+//! can add to.
+
+use crate::analysis::{action_key, mnemonic_metrics, summarize, MnemonicMetrics, Summary};
+use crate::classify::is_timeout;
+use crate::cli::{ActionSort, Cli, MnemonicSort};
+use crate::filter::FilterSummary;
+use crate::metrics::{
+    output_bytes, phase_duration, start_time, to_std_duration, total_time, wall_clock_span, Phase,
+    WallClockSpan,
+};
+use crate::proto::SpawnExec;
+use crate::reports::grouping::RepositorySplit;
+use crate::reports::json::JsonRenderer;
+use crate::reports::phases::CoverageCounts;
+use crate::reports::text::TextRenderer;
+use crate::stats::DurationPercentiles;
+use std::cmp::{Ordering, Reverse};
+use std::collections::{BinaryHeap, HashMap, HashSet};
+use std::io::{self, Write};
+use std::sync::Mutex;
+use std::time::Duration;
+
+/// Mnemonics of numerous, near-instant actions that are never worth a row of their own. The
+/// mnemonic table folds them into one "infrastructure actions" row, whose time still counts
+/// in the totals; --no-default-ignores, --ignore-mnemonic and --unignore-mnemonic change the
+/// set, see [`Cli::ignored_mnemonics`].
+pub const DEFAULT_IGNORED_MNEMONICS: [&str; 4] =
+    ["Symlink", "SourceSymlinkManifest", "FileWrite", "TemplateExpand"];
+
+/// Writes a [`Report`] in one output format.
+pub trait Renderer {
+    fn render(&self, report: &Report, out: &mut dyn Write) -> io::Result<()>;
+}
+
+/// A format --output-format can name: the built-in `text` and `json`, or one that a program
+/// using the crate adds with [`register`] before calling [`crate::run`].
+///
+/// ```no_run
+/// use bzl_exec_log_parser::report::{self, OutputWriter, Renderer, Report};
+/// use bzl_exec_log_parser::Cli;
+/// use std::io::{self, Write};
+///
+/// struct Count;
+///
+/// impl Renderer for Count {
+///     fn render(&self, report: &Report, out: &mut dyn Write) -> io::Result<()> {
+///         writeln!(out, "{}", report.summary.actions)
+///     }
+/// }
+///
+/// fn count(_: &Cli) -> Box<dyn Renderer + '_> {
+///     Box::new(Count)
+/// }
+///
+/// report::register(OutputWriter {
+///     name: "count",
+///     extension: "txt",
+///     description: "The number of spawns",
+///     renderer: count,
+/// });
+/// bzl_exec_log_parser::run()?;
+/// # Ok::<(), bzl_exec_log_parser::AppError>(())
+/// ```
+#[derive(Clone, Copy)]
+pub struct OutputWriter {
+    /// What --output-format takes.
+    pub name: &'static str,
+    /// Extension of files in the format, without the dot.
+    pub extension: &'static str,
+    /// One line for --output-format help.
+    pub description: &'static str,
+    pub renderer: for<'a> fn(&'a Cli) -> Box<dyn Renderer + 'a>,
+}
+
+const BUILT_IN: [OutputWriter; 2] = [
+    OutputWriter {
+        name: "text",
+        extension: "txt",
+        description: "Tables for reading in a terminal",
+        renderer: |args| Box::new(TextRenderer { args }),
+    },
+    OutputWriter {
+        name: "json",
+        extension: "json",
+        description: "A JSON document on stdout, with status messages on stderr",
+        renderer: |args| Box::new(JsonRenderer { args }),
+    },
+];
+
+/// Formats added by [`register`], in the order they were added.
+static REGISTERED: Mutex<Vec<OutputWriter>> = Mutex::new(Vec::new());
+
+/// Adds a format, replacing any built-in or registered one of the same name.
+pub fn register(writer: OutputWriter) {
+    let mut registered = REGISTERED.lock().unwrap();
+    registered.retain(|w| w.name != writer.name);
+    registered.push(writer);
+}
+
+/// Every format --output-format takes: the built-in ones, then the registered ones.
+pub fn output_writers() -> Vec<OutputWriter> {
+    let registered = REGISTERED.lock().unwrap();
+    let mut writers: Vec<OutputWriter> = BUILT_IN
+        .into_iter()
+        .filter(|built_in| registered.iter().all(|w| w.name != built_in.name))
+        .collect();
+    writers.extend(registered.iter().copied());
+    writers
+}
+
+pub fn output_writer(name: &str) -> Option<OutputWriter> {
+    output_writers().into_iter().find(|w| w.name == name)
+}
+
+/// Writes the formats for --output-format help.
+pub fn write_output_writers(out: &mut dyn Write) -> io::Result<()> {
+    let writers = output_writers();
+    let width = writers.iter().map(|w| w.name.len()).max().unwrap_or(0);
+    writeln!(out, "Formats of --output-format:")?;
+    for writer in writers {
+        writeln!(
+            out,
+            "  {:<width$}  {} (.{})",
+            writer.name, writer.description, writer.extension
+        )?;
+    }
+    Ok(())
+}
+
+/// A row of the mnemonic table.
+pub struct MnemonicRow {
+    pub mnemonic: String,
+    pub metrics: MnemonicMetrics,
+}
+
+pub struct Report<'a> {
+    /// The log files, as given on the command line, or by base name with --deterministic.
+    pub logs: Vec<String>,
+    /// The spawns reported on, after the filter flags and --dedupe.
+    pub spawns: &'a [SpawnExec],
+    pub summary: Summary,
+    /// Of the recorded total times; `None` when no spawn recorded one.
+    pub durations: Option<DurationPercentiles>,
+    pub wall_clock: Option<WallClockSpan>,
+    /// Summed total time of the spawns that recorded a start time, which the wall-clock span
+    /// covers.
+    pub started_time: Duration,
+    pub coverage: CoverageCounts,
+    /// Timed out spawns by mnemonic, most first.
+    pub timeouts: Vec<(&'a str, u64)>,
+    pub repositories: RepositorySplit,
+    /// The whole log, when the filter flags selected part of it.
+    pub filter: Option<&'a FilterSummary>,
+    pub sort: ActionSort,
+    /// The first --top-n spawns in `sort` order, or the first --include-spawns if that is more.
+    pub ranked: Vec<&'a SpawnExec>,
+    /// In --mnemonic-sort order, cut at --top-mnemonics.
+    pub mnemonics: Vec<MnemonicRow>,
+    /// The mnemonics past --top-mnemonics combined into one row, with how many there were.
+    pub other_mnemonics: Option<(usize, MnemonicMetrics)>,
+    /// The ignored mnemonics found, by name, combined into one row that follows the others.
+    pub infrastructure: Option<(Vec<String>, MnemonicMetrics)>,
+    /// Total time per mnemonic, longest first, including those past --top-mnemonics.
+    pub mnemonic_times: Vec<(String, Duration)>,
+    /// Summed total time and cache miss time of all mnemonics.
+    pub mnemonic_total: Duration,
+    pub miss_time: Duration,
+}
+
+impl<'a> Report<'a> {
+    /// Computes the report over `spawns`; `filter` describes the whole log they were selected
+    /// from, if they were.
+    pub fn new(spawns: &'a [SpawnExec], args: &Cli, filter: Option<&'a FilterSummary>) -> Self {
+        let mut recorded_durations: Vec<Duration> = spawns
+            .iter()
+            .filter_map(|s| s.metrics.as_ref().and_then(|m| m.total_time.as_ref()))
+            .map(to_std_duration)
+            .collect();
+        let mut coverage = CoverageCounts::default();
+        for spawn in spawns {
+            coverage.add(spawn);
+        }
+        let mut timeouts: HashMap<&str, u64> = HashMap::new();
+        for spawn in spawns.iter().filter(|s| is_timeout(s)) {
+            *timeouts.entry(spawn.mnemonic.as_str()).or_default() += 1;
+        }
+        let mut timeouts: Vec<_> = timeouts.into_iter().collect();
+        timeouts.sort_by(|a, b| b.1.cmp(&a.1).then(a.0.cmp(b.0)));
+
+        let metrics = mnemonic_metrics(spawns);
+        let mut mnemonic_times: Vec<(String, Duration)> = metrics
+            .iter()
+            .map(|(name, metrics)| (name.clone(), metrics.total_duration))
+            .collect();
+        mnemonic_times.sort_by(|a, b| b.1.cmp(&a.1).then_with(|| a.0.cmp(&b.0)));
+        let mnemonic_total = metrics.values().map(|m| m.total_duration).sum();
+        let miss_time = metrics.values().map(|m| m.miss_duration).sum();
+        let (metrics, infrastructure) = fold_ignored(metrics, &args.ignored_mnemonics());
+        let (mnemonics, other_mnemonics) = cut_at_top_mnemonics(mnemonic_rows(metrics, args), args);
+
+        Report {
+            logs: args
+                .log_files()
+                .iter()
+                .map(|path| match path.file_name() {
+                    Some(name) if args.deterministic => name.to_string_lossy().into_owned(),
+                    _ => path.display().to_string(),
+                })
+                .collect(),
+            spawns,
+            summary: summarize(spawns),
+            durations: DurationPercentiles::compute(&mut recorded_durations),
+            wall_clock: wall_clock_span(spawns),
+            started_time: spawns
+                .iter()
+                .filter(|s| start_time(s).is_some())
+                .map(total_time)
+                .sum(),
+            coverage,
+            timeouts,
+            repositories: RepositorySplit::from_spawns(spawns),
+            filter,
+            sort: args.sort_by,
+            ranked: top_actions(
+                spawns,
+                args.sort_by,
+                args.top_n.max(args.include_spawns.unwrap_or(0)),
+            ),
+            mnemonics,
+            other_mnemonics,
+            infrastructure,
+            mnemonic_times,
+            mnemonic_total,
+            miss_time,
+        }
+    }
+}
+
+/// Takes the `ignored` mnemonics out of `metrics`, combined, with their names in order.
+fn fold_ignored(
+    mut metrics: HashMap<String, MnemonicMetrics>,
+    ignored: &HashSet<&str>,
+) -> (
+    HashMap<String, MnemonicMetrics>,
+    Option<(Vec<String>, MnemonicMetrics)>,
+) {
+    let mut names: Vec<String> = metrics
+        .keys()
+        .filter(|mnemonic| ignored.contains(mnemonic.as_str()))
+        .cloned()
+        .collect();
+    if names.is_empty() {
+        return (metrics, None);
+    }
+    names.sort();
+    let mut folded = MnemonicMetrics::default();
+    for name in &names {
+        if let Some(row) = metrics.remove(name) {
+            folded.absorb(&row);
+        }
+    }
+    (metrics, Some((names, folded)))
+}
+
+/// The rows of the mnemonic table in --mnemonic-sort order, before --top-mnemonics cuts them.
+/// The JSON document lists all of them in this order.
+pub fn mnemonic_rows(metrics: HashMap<String, MnemonicMetrics>, args: &Cli) -> Vec<MnemonicRow> {
+    let mut rows: Vec<MnemonicRow> = metrics
+        .into_iter()
+        .map(|(mnemonic, metrics)| MnemonicRow { mnemonic, metrics })
+        .collect();
+    rows.sort_by(|a, b| {
+        let (a_metrics, b_metrics) = (&a.metrics, &b.metrics);
+        let order = match args.mnemonic_sort {
+            MnemonicSort::Total => a_metrics.total_duration.cmp(&b_metrics.total_duration),
+            MnemonicSort::MissTime => a_metrics.miss_duration.cmp(&b_metrics.miss_duration),
+            MnemonicSort::Count => a_metrics.count.cmp(&b_metrics.count),
+            MnemonicSort::HitRate => a_metrics.hit_rate().total_cmp(&b_metrics.hit_rate()),
+            MnemonicSort::Avg => a_metrics.average().cmp(&b_metrics.average()),
+        };
+        let order = if args.asc { order } else { order.reverse() };
+        order.then_with(|| a.mnemonic.cmp(&b.mnemonic))
+    });
+    rows
+}
+
+fn cut_at_top_mnemonics(
+    mut rows: Vec<MnemonicRow>,
+    args: &Cli,
+) -> (Vec<MnemonicRow>, Option<(usize, MnemonicMetrics)>) {
+    // The mnemonics past --top-mnemonics share one row, so the columns still add up.
+    let other = args
+        .top_mnemonics
+        .filter(|&limit| limit < rows.len())
+        .map(|limit| {
+            let mut rest = MnemonicMetrics::default();
+            let count = rows.len() - limit;
+            for row in rows.drain(limit..) {
+                rest.absorb(&row.metrics);
+            }
+            (count, rest)
+        });
+    (rows, other)
+}
+
+/// `spawns` in --sort-by order, highest first.
+pub fn sorted_actions<'a>(
+    spawns: impl IntoIterator<Item = &'a SpawnExec>,
+    sort: ActionSort,
+) -> Vec<&'a SpawnExec> {
+    let mut sorted: Vec<&SpawnExec> = spawns.into_iter().collect();
+    sorted.sort_by(|a, b| highest_first(a, b, |s| action_rank(s, sort)));
+    sorted
+}
+
+/// Orders spawns by `key`, highest first. Ties are broken by label, mnemonic and output path,
+/// so that repeated runs list the same actions in the same order.
+pub fn highest_first<K: Ord>(
+    a: &SpawnExec,
+    b: &SpawnExec,
+    key: impl Fn(&SpawnExec) -> K,
+) -> Ordering {
+    key(b)
+        .cmp(&key(a))
+        .then_with(|| action_key(a).cmp(&action_key(b)))
+}
+
+/// The first `limit` of `spawns` in --sort-by order, as [`sorted_actions`] would list them,
+/// without sorting the rest: a heap keeps the `limit` highest seen so far.
+pub fn top_actions(spawns: &[SpawnExec], sort: ActionSort, limit: usize) -> Vec<&SpawnExec> {
+    if limit >= spawns.len() {
+        return sorted_actions(spawns, sort);
+    }
+    // Larger sorts first; the index stands in for the stable sort's original order.
+    let order = |i: usize| {
+        let spawn = &spawns[i];
+        (action_rank(spawn, sort), Reverse(action_key(spawn)), Reverse(i))
+    };
+    let mut heap = BinaryHeap::with_capacity(limit + 1);
+    for i in 0..spawns.len() {
+        heap.push(Reverse(order(i)));
+        if heap.len() > limit {
+            heap.pop();
+        }
+    }
+    let mut top: Vec<usize> = heap
+        .into_iter()
+        .map(|Reverse((_, _, Reverse(i)))| i)
+        .collect();
+    top.sort_by_key(|&i| Reverse(order(i)));
+    top.into_iter().map(|i| &spawns[i]).collect()
+}
+
+/// What the top actions table ranks `spawn` by: nanoseconds, bytes or a count.
+pub fn action_rank(spawn: &SpawnExec, sort: ActionSort) -> i128 {
+    let phase = |phase| {
+        spawn
+            .metrics
+            .as_ref()
+            .and_then(|m| phase_duration(m, phase))
+            .unwrap_or_default()
+            .as_nanos() as i128
+    };
+    match sort {
+        ActionSort::Duration => total_time(spawn).as_nanos() as i128,
+        ActionSort::Fetch => phase(Phase::Fetch),
+        ActionSort::Queue => phase(Phase::Queue),
+        ActionSort::OutputBytes => output_bytes(spawn) as i128,
+        ActionSort::Inputs => spawn.inputs.len() as i128,
+    }
+}
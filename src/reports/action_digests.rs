@@ -0,0 +1,54 @@
+//! Recomputed REAPI action digests, for correlating spawns with remote execution logs.
+
+use crate::format::{section, Align, Table};
+use crate::proto::SpawnExec;
+use crate::reapi::{compute, format_digest};
+
+pub fn print_action_digests_report(spawns: &[SpawnExec]) {
+    println!("{}", section("Remote Execution Action Digests"));
+    println!("Note: Digests are recomputed from the log; see src/reapi.rs for known caveats.");
+    let mut table = Table::new(vec![
+        ("Action Digest".to_string(), Align::Left),
+        ("Mnemonic".to_string(), Align::Left),
+        ("Target".to_string(), Align::Left),
+    ]);
+    for spawn in spawns {
+        table.add_row(vec![
+            format_digest(&compute(spawn).action),
+            spawn.mnemonic.clone(),
+            spawn.target_label.clone(),
+        ]);
+    }
+    table.print();
+    println!();
+}
+
+/// Prints the spawns whose recomputed action digest matches `query` (`hash` or `hash/size`).
+pub fn print_action_digest_lookup(spawns: &[SpawnExec], query: &str) {
+    println!("{}", section(format_args!("Spawns Matching Action Digest {}", query)));
+    let hash = query.split('/').next().unwrap_or(query).to_ascii_lowercase();
+
+    let mut found = 0;
+    for spawn in spawns {
+        let digests = compute(spawn);
+        let matches = if query.contains('/') {
+            format_digest(&digests.action) == query.to_ascii_lowercase()
+        } else {
+            digests.action.hash == hash
+        };
+        if !matches {
+            continue;
+        }
+        found += 1;
+        println!("{} | {} | {}", spawn.mnemonic, spawn.runner, spawn.target_label);
+        println!("    └ Command digest: {}", format_digest(&digests.command));
+        println!("    └ Input root digest: {}", format_digest(&digests.input_root));
+        if let Some(output) = spawn.listed_outputs.first() {
+            println!("    └ First output: {}", output);
+        }
+    }
+    if found == 0 {
+        println!("No spawn in the log has this action digest.");
+    }
+    println!();
+}
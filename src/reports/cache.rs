@@ -0,0 +1,1404 @@
+//! Network cost of talking to the remote cache and remote executors.
+
+use crate::analysis::action_key;
+use crate::cli::Cli;
+use crate::classify::{classify_runner, is_cache_hit, is_failed, RunnerKind};
+use crate::digests::DigestSet;
+use crate::format::{
+    format_bytes, format_duration, format_rate, format_seconds, section, top_label, Align, Table,
+};
+use crate::metrics::{
+    directory_outputs, output_bytes, phase_duration, recorded_total_time, to_std_duration,
+    total_time, Phase,
+};
+use crate::proto::SpawnExec;
+use crate::report::highest_first;
+use crate::reports::inputs::Volume;
+use crate::stats::percentile;
+use std::collections::{BTreeMap, HashMap};
+use std::time::Duration;
+
+/// Share of total fetch time below which a mnemonic is folded into the "(other)" row.
+const MIN_FETCH_SHARE: f64 = 0.01;
+
+pub fn print_cache_performance_report(spawns: &[SpawnExec], args: &Cli) {
+    print_download_report(spawns);
+    print_slowest_fetches(spawns, args.top_n);
+    print_top_downloads(spawns, args.top_n);
+    print_slow_fetch_outliers(spawns, args.slow_fetch_percentile, args.slow_fetch_min_bytes);
+    print_cache_lookup_report(spawns, args.top_n, args.lookup_warn_fraction);
+    print_upload_report(spawns, args.top_n);
+    print_savings_estimate(spawns);
+}
+
+/// The remote cache hits of one mnemonic, or of the mnemonics folded into "(other)".
+#[derive(Default)]
+pub struct FetchStats {
+    pub hits: u64,
+    pub bytes: i64,
+    pub fetch_time: Duration,
+}
+
+/// The remote cache hits of each mnemonic, most fetch time first, ties by name.
+pub fn fetches_by_mnemonic(spawns: &[SpawnExec]) -> Vec<(&str, FetchStats)> {
+    let mut by_mnemonic: HashMap<&str, FetchStats> = HashMap::new();
+    for spawn in spawns.iter().filter(|s| s.runner == "remote cache hit") {
+        let stats = by_mnemonic.entry(spawn.mnemonic.as_str()).or_default();
+        stats.hits += 1;
+        stats.bytes += output_bytes(spawn);
+        stats.fetch_time += fetch_time(spawn);
+    }
+    let mut mnemonics: Vec<_> = by_mnemonic.into_iter().collect();
+    mnemonics.sort_by(|a, b| b.1.fetch_time.cmp(&a.1.fetch_time).then(a.0.cmp(b.0)));
+    mnemonics
+}
+
+/// Formats a transfer rate, or N/A when the time is too small to be meaningful.
+fn rate(bytes: i64, time: Duration) -> String {
+    let seconds = time.as_secs_f64();
+    if seconds > 0.001 {
+        format_rate(bytes as f64 / seconds)
+    } else {
+        "N/A".to_string()
+    }
+}
+
+/// Bytes of outputs downloaded by remote cache hits; directories of unknown size count as 0.
+pub fn downloaded_bytes<'a>(spawns: impl IntoIterator<Item = &'a SpawnExec>) -> i64 {
+    spawns
+        .into_iter()
+        .filter(|s| s.runner == "remote cache hit")
+        .map(output_bytes)
+        .sum()
+}
+
+/// Share of actions that hit the cache, in percent.
+pub fn hit_rate(spawns: &[SpawnExec]) -> f64 {
+    if spawns.is_empty() {
+        return 0.0;
+    }
+    let hits = spawns.iter().filter(|s| is_cache_hit(s)).count();
+    hits as f64 / spawns.len() as f64 * 100.0
+}
+
+/// Share of the work the cache avoided, in percent: each hit counts for the mean time a miss
+/// of its mnemonic takes (its own time if the mnemonic never missed), each miss for its own
+/// time. `None` when no spawn recorded any time.
+pub fn time_weighted_hit_rate<'a>(
+    spawns: impl IntoIterator<Item = &'a SpawnExec> + Clone,
+) -> Option<f64> {
+    // Ordered, so that the miss times are summed in the same order on every run.
+    let mut misses: BTreeMap<&str, (u64, Duration)> = BTreeMap::new();
+    for spawn in spawns.clone().into_iter().filter(|s| !is_cache_hit(s)) {
+        let entry = misses.entry(spawn.mnemonic.as_str()).or_default();
+        entry.0 += 1;
+        entry.1 += total_time(spawn);
+    }
+    let miss_time: f64 = misses.values().map(|(_, time)| time.as_secs_f64()).sum();
+    let hit_weight: f64 = spawns
+        .into_iter()
+        .filter(|s| is_cache_hit(s))
+        .map(|s| match misses.get(s.mnemonic.as_str()) {
+            Some((count, time)) => time.as_secs_f64() / *count as f64,
+            None => total_time(s).as_secs_f64(),
+        })
+        .sum();
+    let total = hit_weight + miss_time;
+    (total > 0.0).then(|| hit_weight / total * 100.0)
+}
+
+fn print_download_report(spawns: &[SpawnExec]) {
+    let mut total_bytes_downloaded: i64 = 0;
+    let mut total_fetch_time = Duration::ZERO;
+    let mut remote_cache_hit_count = 0;
+    let mut unknown_size_outputs = 0;
+
+    for spawn in spawns {
+        if spawn.runner == "remote cache hit" {
+            remote_cache_hit_count += 1;
+            unknown_size_outputs += directory_outputs(spawn).1.len();
+            total_bytes_downloaded += output_bytes(spawn);
+            total_fetch_time += fetch_time(spawn);
+        }
+    }
+
+    println!("{}", section("Remote Cache Performance"));
+    if remote_cache_hit_count == 0 {
+        println!("No remote cache hits found in the log (--remote-cache-benefit estimates what a cache would save).");
+        println!();
+        return;
+    }
+    let total_fetch_seconds = total_fetch_time.as_secs_f64();
+    println!("Remote Cache Hits Count: {}", remote_cache_hit_count);
+    println!("Total Data Downloaded: {}", format_bytes(total_bytes_downloaded));
+    if unknown_size_outputs > 0 {
+        println!(
+            "  Not included: {} outputs of unknown size (summarized directories or empty files)",
+            unknown_size_outputs
+        );
+    }
+    println!(
+        "Total Time Fetching from Cache: {}",
+        format_seconds(total_fetch_seconds, 2));
+    if total_fetch_seconds > 0.001 {
+        println!(
+            "Average Download Rate: {}",
+            format_rate(total_bytes_downloaded as f64 / total_fetch_seconds)
+        );
+    } else {
+        println!("Average Download Rate: N/A (total fetch time is negligible)");
+    }
+
+    let mnemonics = fetches_by_mnemonic(spawns);
+    let mut other = FetchStats::default();
+    let mut other_mnemonics = 0;
+    println!();
+    println!("Fetches by Mnemonic:");
+    let mut table = Table::new(vec![
+        ("Mnemonic".to_string(), Align::Left),
+        ("Hits".to_string(), Align::Right),
+        ("Downloaded".to_string(), Align::Right),
+        ("Fetch Time".to_string(), Align::Right),
+        ("Rate".to_string(), Align::Right),
+    ]);
+    let add_row = |table: &mut Table, name: String, stats: &FetchStats| {
+        table.add_row(vec![
+            name,
+            stats.hits.to_string(),
+            format_bytes(stats.bytes),
+            format_duration(stats.fetch_time, 2),
+            rate(stats.bytes, stats.fetch_time),
+        ]);
+    };
+    for (mnemonic, stats) in &mnemonics {
+        let share = if total_fetch_seconds > 0.0 {
+            stats.fetch_time.as_secs_f64() / total_fetch_seconds
+        } else {
+            0.0
+        };
+        if share < MIN_FETCH_SHARE {
+            other.hits += stats.hits;
+            other.bytes += stats.bytes;
+            other.fetch_time += stats.fetch_time;
+            other_mnemonics += 1;
+        } else {
+            add_row(&mut table, mnemonic.to_string(), stats);
+        }
+    }
+    if other_mnemonics > 0 {
+        add_row(&mut table, format!("(other: {} mnemonics)", other_mnemonics), &other);
+    }
+    table.print();
+    println!();
+}
+
+fn fetch_time(spawn: &SpawnExec) -> Duration {
+    spawn
+        .metrics
+        .as_ref()
+        .and_then(|m| m.fetch_time.as_ref())
+        .map(to_std_duration)
+        .unwrap_or_default()
+}
+
+fn print_slowest_fetches(spawns: &[SpawnExec], top_n: usize) {
+    println!("{}", section(format_args!("{} Slowest Cache Fetches", top_label(top_n))));
+
+    let hits: Vec<&SpawnExec> = spawns.iter().filter(|s| is_cache_hit(s)).collect();
+    let mut fetches: Vec<(Duration, &SpawnExec)> = hits
+        .iter()
+        .map(|s| (fetch_time(s), *s))
+        .filter(|(fetch, _)| !fetch.is_zero())
+        .collect();
+    let without_fetch = hits.len() - fetches.len();
+
+    if fetches.is_empty() {
+        println!("No cache hits with a recorded fetch time found in the log.");
+    } else {
+        fetches.sort_by(|a, b| highest_first(a.1, b.1, fetch_time));
+        let mut table = Table::new(vec![
+            ("Fetch Time".to_string(), Align::Right),
+            ("Downloaded".to_string(), Align::Right),
+            ("Rate".to_string(), Align::Right),
+            ("Mnemonic".to_string(), Align::Left),
+            ("Target".to_string(), Align::Left),
+        ]);
+        for (fetch, spawn) in fetches.into_iter().take(top_n) {
+            let bytes = output_bytes(spawn);
+            table.add_row(vec![
+                format_duration(fetch, 3),
+                format_bytes(bytes),
+                rate(bytes, fetch),
+                spawn.mnemonic.clone(),
+                spawn.target_label.clone(),
+            ]);
+        }
+        table.print();
+    }
+    if without_fetch > 0 {
+        println!(
+            "Note: {} cache hits with zero fetch time (served from local layers) are excluded.",
+            without_fetch
+        );
+    }
+    println!();
+}
+
+/// A remote cache hit and the bytes of outputs it downloaded.
+pub struct Download<'a> {
+    pub spawn: &'a SpawnExec,
+    pub bytes: i64,
+    pub fetch_time: Duration,
+}
+
+/// The bytes downloaded from the remote cache of one mnemonic.
+pub struct MnemonicDownloads<'a> {
+    pub mnemonic: &'a str,
+    pub hits: u64,
+    pub bytes: i64,
+    pub fetch_time: Duration,
+}
+
+/// Remote cache hits ranked by the bytes they downloaded rather than the time they took, the
+/// way egress is billed.
+pub struct TopDownloads<'a> {
+    /// Of every remote cache hit, as in "Total Data Downloaded".
+    pub total_bytes: i64,
+    pub hits: usize,
+    /// The `top_n` hits that downloaded the most bytes.
+    pub top: Vec<Download<'a>>,
+    /// Every mnemonic with a remote cache hit, by bytes downloaded.
+    pub mnemonics: Vec<MnemonicDownloads<'a>>,
+}
+
+impl TopDownloads<'_> {
+    /// `bytes` as a share of all downloaded bytes, in percent.
+    pub fn share(&self, bytes: i64) -> f64 {
+        if self.total_bytes > 0 {
+            bytes as f64 / self.total_bytes as f64 * 100.0
+        } else {
+            0.0
+        }
+    }
+
+    /// The share of all downloaded bytes the top hits account for, in percent.
+    pub fn top_share(&self) -> f64 {
+        self.share(self.top.iter().map(|d| d.bytes).sum())
+    }
+}
+
+pub fn top_downloads(spawns: &[SpawnExec], top_n: usize) -> TopDownloads<'_> {
+    let mut hits: Vec<&SpawnExec> = spawns
+        .iter()
+        .filter(|s| s.runner == "remote cache hit")
+        .collect();
+    let mut by_mnemonic: HashMap<&str, MnemonicDownloads> = HashMap::new();
+    for spawn in &hits {
+        let stats = by_mnemonic
+            .entry(spawn.mnemonic.as_str())
+            .or_insert_with(|| MnemonicDownloads {
+                mnemonic: &spawn.mnemonic,
+                hits: 0,
+                bytes: 0,
+                fetch_time: Duration::ZERO,
+            });
+        stats.hits += 1;
+        stats.bytes += output_bytes(spawn);
+        stats.fetch_time += fetch_time(spawn);
+    }
+    let mut mnemonics: Vec<MnemonicDownloads> = by_mnemonic.into_values().collect();
+    mnemonics.sort_by(|a, b| b.bytes.cmp(&a.bytes).then(a.mnemonic.cmp(b.mnemonic)));
+    hits.sort_by(|a, b| highest_first(a, b, output_bytes));
+    TopDownloads {
+        total_bytes: mnemonics.iter().map(|m| m.bytes).sum(),
+        hits: hits.len(),
+        top: hits
+            .into_iter()
+            .take(top_n)
+            .map(|spawn| Download {
+                spawn,
+                bytes: output_bytes(spawn),
+                fetch_time: fetch_time(spawn),
+            })
+            .collect(),
+        mnemonics,
+    }
+}
+
+fn print_top_downloads(spawns: &[SpawnExec], top_n: usize) {
+    println!(
+        "{}",
+        section(format_args!("{} Cache Hits by Downloaded Bytes", top_label(top_n)))
+    );
+
+    let downloads = top_downloads(spawns, top_n);
+    if downloads.hits == 0 {
+        println!("No remote cache hits found in the log.");
+        println!();
+        return;
+    }
+    let mut table = Table::new(vec![
+        ("Downloaded".to_string(), Align::Right),
+        ("Fetch Time".to_string(), Align::Right),
+        ("Rate".to_string(), Align::Right),
+        ("Mnemonic".to_string(), Align::Left),
+        ("Target".to_string(), Align::Left),
+    ]);
+    for download in &downloads.top {
+        table.add_row(vec![
+            format_bytes(download.bytes),
+            format_duration(download.fetch_time, 3),
+            rate(download.bytes, download.fetch_time),
+            download.spawn.mnemonic.clone(),
+            download.spawn.target_label.clone(),
+        ]);
+    }
+    table.print();
+    println!(
+        "The top {} of {} hits account for {:.1}% of the {} downloaded.",
+        downloads.top.len(),
+        downloads.hits,
+        downloads.top_share(),
+        format_bytes(downloads.total_bytes)
+    );
+
+    println!();
+    println!("Downloaded Bytes by Mnemonic:");
+    let mut table = Table::new(vec![
+        ("Mnemonic".to_string(), Align::Left),
+        ("Hits".to_string(), Align::Right),
+        ("Downloaded".to_string(), Align::Right),
+        ("% of Bytes".to_string(), Align::Right),
+        ("Cum %".to_string(), Align::Right),
+        ("Fetch Time".to_string(), Align::Right),
+        ("Rate".to_string(), Align::Right),
+    ]);
+    let mut cumulative = 0;
+    for stats in downloads.mnemonics.iter().take(top_n) {
+        cumulative += stats.bytes;
+        table.add_row(vec![
+            stats.mnemonic.to_string(),
+            stats.hits.to_string(),
+            format_bytes(stats.bytes),
+            format!("{:.1}%", downloads.share(stats.bytes)),
+            format!("{:.1}%", downloads.share(cumulative)),
+            format_duration(stats.fetch_time, 2),
+            rate(stats.bytes, stats.fetch_time),
+        ]);
+    }
+    table.print();
+    if downloads.mnemonics.len() > top_n {
+        println!("... (+{} more mnemonics)", downloads.mnemonics.len() - top_n);
+    }
+    println!();
+}
+
+/// Flags cache hits whose download rate falls below the given percentile of all fetches.
+///
+/// Fetches smaller than `min_bytes` are ignored, since their rate is dominated by latency.
+fn print_slow_fetch_outliers(spawns: &[SpawnExec], rate_percentile: f64, min_bytes: u64) {
+    println!("{}", section("Slow Cache Fetch Outliers"));
+
+    // (bytes per second, bytes, fetch time, spawn) for every hit that downloaded something.
+    let fetches: Vec<(f64, i64, Duration, &SpawnExec)> = spawns
+        .iter()
+        .filter(|s| is_cache_hit(s))
+        .filter_map(|s| {
+            let fetch = fetch_time(s);
+            let bytes = output_bytes(s);
+            (!fetch.is_zero() && bytes > 0)
+                .then(|| (bytes as f64 / fetch.as_secs_f64(), bytes, fetch, s))
+        })
+        .collect();
+    if fetches.is_empty() {
+        println!("No cache fetches with recorded size and time found in the log.");
+        println!();
+        return;
+    }
+
+    let mut rates: Vec<f64> = fetches.iter().map(|f| f.0).collect();
+    rates.sort_by(f64::total_cmp);
+    let threshold = percentile(&rates, rate_percentile);
+
+    let mut outliers: Vec<_> = fetches
+        .iter()
+        .filter(|(rate, bytes, _, _)| *rate < threshold && *bytes as u64 >= min_bytes)
+        .collect();
+    println!(
+        "Threshold: below {} (p{} of {} fetches), at least {} moved",
+        format_rate(threshold),
+        rate_percentile,
+        fetches.len(),
+        format_bytes(min_bytes as i64)
+    );
+    if outliers.is_empty() {
+        println!("No slow fetch outliers found.");
+        println!();
+        return;
+    }
+
+    outliers.sort_by(|a, b| a.0.total_cmp(&b.0));
+    let outlier_time: Duration = outliers.iter().map(|f| f.2).sum();
+    let total_time: Duration = fetches.iter().map(|f| f.2).sum();
+    let mut table = Table::new(vec![
+        ("Rate".to_string(), Align::Right),
+        ("Downloaded".to_string(), Align::Right),
+        ("Fetch Time".to_string(), Align::Right),
+        ("Target".to_string(), Align::Left),
+    ]);
+    for (_, bytes, fetch, spawn) in &outliers {
+        table.add_row(vec![
+            rate(*bytes, *fetch),
+            format_bytes(*bytes),
+            format_duration(*fetch, 3),
+            spawn.target_label.clone(),
+        ]);
+    }
+    table.print();
+    println!(
+        "{} outliers account for {} of {} total fetch time ({:.1}%).",
+        outliers.len(),
+        format_duration(outlier_time, 2),
+        format_duration(total_time, 2),
+        outlier_time.as_secs_f64() / total_time.as_secs_f64() * 100.0
+    );
+    println!();
+}
+
+/// Percentiles of the observed fetch rates that bound the savings estimate (slow, fast).
+const SAVINGS_RATE_PERCENTILES: (f64, f64) = (25.0, 75.0);
+
+/// The time a perfect cache would have saved: every successful cacheable miss instead pays
+/// only the download of its outputs, at the rates this log's hits achieved.
+pub struct SavingsEstimate {
+    pub misses: usize,
+    pub miss_time: Duration,
+    pub miss_bytes: i64,
+    /// Of all spawns, which the savings are a share of.
+    pub spawn_time: Duration,
+    /// The slow and fast download rates, in bytes per second; `None` when no cache hit recorded
+    /// one, and the savings are the whole miss time.
+    pub rates: Option<(f64, f64)>,
+    /// The savings at the slow and at the fast rate, in seconds.
+    pub low: f64,
+    pub high: f64,
+}
+
+impl SavingsEstimate {
+    /// `seconds` as a percentage of the total spawn time.
+    pub fn share(&self, seconds: f64) -> f64 {
+        if self.spawn_time.is_zero() {
+            0.0
+        } else {
+            seconds / self.spawn_time.as_secs_f64() * 100.0
+        }
+    }
+}
+
+/// `None` when no successful cacheable spawn missed the cache.
+pub fn savings_estimate(spawns: &[SpawnExec]) -> Option<SavingsEstimate> {
+    let misses: Vec<&SpawnExec> = spawns
+        .iter()
+        .filter(|s| s.cacheable && !is_cache_hit(s) && !is_failed(s))
+        .collect();
+    if misses.is_empty() {
+        return None;
+    }
+    let miss_time: Duration = misses.iter().map(|s| total_time(s)).sum();
+    let mut rates: Vec<f64> = spawns
+        .iter()
+        .filter(|s| is_cache_hit(s))
+        .filter_map(|s| {
+            let fetch = fetch_time(s);
+            let bytes = output_bytes(s);
+            (!fetch.is_zero() && bytes > 0).then(|| bytes as f64 / fetch.as_secs_f64())
+        })
+        .collect();
+    rates.sort_by(f64::total_cmp);
+    let rates = (!rates.is_empty()).then(|| {
+        let (slow_percentile, fast_percentile) = SAVINGS_RATE_PERCENTILES;
+        (percentile(&rates, slow_percentile), percentile(&rates, fast_percentile))
+    });
+    let savings = |bytes_per_second: f64| -> f64 {
+        misses
+            .iter()
+            .map(|s| {
+                let fetch = output_bytes(s) as f64 / bytes_per_second;
+                (total_time(s).as_secs_f64() - fetch).max(0.0)
+            })
+            .sum()
+    };
+    let (low, high) = match rates {
+        Some((slow, fast)) => (savings(slow), savings(fast)),
+        None => (miss_time.as_secs_f64(), miss_time.as_secs_f64()),
+    };
+    Some(SavingsEstimate {
+        misses: misses.len(),
+        miss_time,
+        miss_bytes: misses.iter().map(|s| output_bytes(s)).sum(),
+        spawn_time: spawns.iter().map(total_time).sum(),
+        rates,
+        low,
+        high,
+    })
+}
+
+fn print_savings_estimate(spawns: &[SpawnExec]) {
+    println!("{}", section("Potential Savings With a Perfect Cache (Estimate)"));
+
+    let Some(estimate) = savings_estimate(spawns) else {
+        println!("No cache misses of successful cacheable spawns found in the log.");
+        println!();
+        return;
+    };
+    println!(
+        "Cacheable misses: {} spawns, {} of spawn time, {} of outputs",
+        estimate.misses,
+        format_duration(estimate.miss_time, 2),
+        format_bytes(estimate.miss_bytes)
+    );
+
+    let Some((slow, fast)) = estimate.rates else {
+        println!(
+            "Estimated savings: at most {} ({:.1}% of total spawn time)",
+            format_duration(estimate.miss_time, 2),
+            estimate.share(estimate.miss_time.as_secs_f64())
+        );
+        println!("Note: No cache hit recorded a download rate, so the cost of fetching outputs is not subtracted.");
+        println!();
+        return;
+    };
+    let (slow_percentile, fast_percentile) = SAVINGS_RATE_PERCENTILES;
+    println!(
+        "Estimated savings: {} to {} ({:.1}% to {:.1}% of total spawn time)",
+        format_seconds(estimate.low, 2),
+        format_seconds(estimate.high, 2),
+        estimate.share(estimate.low),
+        estimate.share(estimate.high)
+    );
+    println!(
+        "Assumptions: each miss would have downloaded its outputs at {} to {} (p{}-p{} of this log's cache fetches) and taken no other time; non-cacheable and failed spawns are excluded.",
+        format_rate(slow),
+        format_rate(fast),
+        slow_percentile,
+        fast_percentile
+    );
+    println!();
+}
+
+#[derive(Default)]
+struct LookupStats {
+    hits: u64,
+    hit_time: Duration,
+    misses: u64,
+    miss_time: Duration,
+}
+
+impl LookupStats {
+    fn add(&mut self, hit: bool, time: Duration) {
+        if hit {
+            self.hits += 1;
+            self.hit_time += time;
+        } else {
+            self.misses += 1;
+            self.miss_time += time;
+        }
+    }
+
+    fn total(&self) -> Duration {
+        self.hit_time + self.miss_time
+    }
+}
+
+fn average(total: Duration, count: u64) -> String {
+    if count > 0 {
+        format_seconds(total.as_secs_f64() / count as f64, 3)
+    } else {
+        "N/A".to_string()
+    }
+}
+
+/// Cache lookups, approximated by the network time recorded for cacheable spawns.
+fn print_cache_lookup_report(spawns: &[SpawnExec], top_n: usize, warn_fraction: f64) {
+    println!("{}", section("Remote Cache Lookups"));
+
+    let mut overall = LookupStats::default();
+    let mut by_mnemonic: HashMap<&str, LookupStats> = HashMap::new();
+    let mut total_spawn_time = Duration::ZERO;
+    for spawn in spawns {
+        let Some(metrics) = spawn.metrics.as_ref() else {
+            continue;
+        };
+        total_spawn_time += metrics.total_time.as_ref().map(to_std_duration).unwrap_or_default();
+        if !spawn.cacheable {
+            continue;
+        }
+        let Some(network) = metrics.network_time.as_ref().map(to_std_duration) else {
+            continue;
+        };
+        let hit = is_cache_hit(spawn);
+        overall.add(hit, network);
+        by_mnemonic
+            .entry(spawn.mnemonic.as_str())
+            .or_default()
+            .add(hit, network);
+    }
+
+    if overall.hits + overall.misses == 0 {
+        println!("No cache lookup (network) times recorded for cacheable spawns.");
+        println!();
+        return;
+    }
+
+    let lookup_seconds = overall.total().as_secs_f64();
+    let total_seconds = total_spawn_time.as_secs_f64();
+    println!("Note: Lookup time is the network time recorded for cacheable spawns.");
+    println!(
+        "Total Lookup Time: {} over {} lookups (avg {})",
+        format_seconds(lookup_seconds, 2),
+        overall.hits + overall.misses,
+        average(overall.total(), overall.hits + overall.misses)
+    );
+    println!(
+        "  Hits:   {} lookups, {} (avg {})",
+        overall.hits,
+        format_duration(overall.hit_time, 2),
+        average(overall.hit_time, overall.hits)
+    );
+    println!(
+        "  Misses: {} lookups, {} (avg {})",
+        overall.misses,
+        format_duration(overall.miss_time, 2),
+        average(overall.miss_time, overall.misses)
+    );
+    if total_seconds > 0.0 {
+        let fraction = lookup_seconds / total_seconds;
+        println!("Share of Total Spawn Time: {:.1}%", fraction * 100.0);
+        if fraction > warn_fraction {
+            println!(
+                "Warning: Cache lookups exceed {:.0}% of total spawn time; a distant cache may cost more than it saves for small actions.",
+                warn_fraction * 100.0
+            );
+        }
+    }
+
+    let mut mnemonics: Vec<_> = by_mnemonic.into_iter().collect();
+    mnemonics.sort_by(|a, b| b.1.total().cmp(&a.1.total()).then(a.0.cmp(b.0)));
+    println!();
+    println!("{} Mnemonics by Lookup Time:", top_label(top_n));
+    let mut table = Table::new(vec![
+        ("Mnemonic".to_string(), Align::Left),
+        ("Lookups".to_string(), Align::Right),
+        ("Total".to_string(), Align::Right),
+        ("Avg Hit".to_string(), Align::Right),
+        ("Avg Miss".to_string(), Align::Right),
+    ]);
+    for (mnemonic, stats) in mnemonics.into_iter().take(top_n) {
+        table.add_row(vec![
+            mnemonic.to_string(),
+            (stats.hits + stats.misses).to_string(),
+            format_duration(stats.total(), 2),
+            average(stats.hit_time, stats.hits),
+            average(stats.miss_time, stats.misses),
+        ]);
+    }
+    table.print();
+    println!();
+}
+
+/// The spawns with the most output bytes, which drive the storage the remote cache bills for.
+/// Outputs without a digest count as zero, and spawns with any are marked `~` as approximate.
+pub fn print_output_size_report(spawns: &[SpawnExec], top_n: usize) {
+    println!("{}", section(format_args!("{} Actions by Total Output Size", top_label(top_n))));
+
+    let mut by_size: Vec<(i64, &SpawnExec)> = spawns
+        .iter()
+        .filter(|s| !s.actual_outputs.is_empty())
+        .map(|s| (output_bytes(s), s))
+        .collect();
+    if by_size.is_empty() {
+        println!("No actual outputs found in the log.");
+        println!();
+        return;
+    }
+    by_size.sort_by(|a, b| highest_first(a.1, b.1, output_bytes));
+
+    let mut table = Table::new(vec![
+        ("Output Size".to_string(), Align::Right),
+        ("Outputs".to_string(), Align::Right),
+        ("Cache".to_string(), Align::Left),
+        ("Mnemonic".to_string(), Align::Left),
+        ("Target".to_string(), Align::Left),
+    ]);
+    let mut approximate = 0;
+    for (size, spawn) in by_size.into_iter().take(top_n) {
+        let size = format_bytes(size);
+        let missing_digest = spawn.actual_outputs.iter().any(|f| f.digest.is_none());
+        if missing_digest {
+            approximate += 1;
+        }
+        table.add_row(vec![
+            if missing_digest { format!("~{}", size) } else { size },
+            spawn.actual_outputs.len().to_string(),
+            if is_cache_hit(spawn) { "hit" } else { "miss" }.to_string(),
+            spawn.mnemonic.clone(),
+            spawn.target_label.clone(),
+        ]);
+    }
+    table.print();
+    if approximate > 0 {
+        println!(
+            "Note: ~ marks {} action(s) with outputs without a digest, which count as zero bytes.",
+            approximate
+        );
+    }
+    println!();
+}
+
+/// Uploads of outputs produced by remote executions that missed the cache.
+fn print_upload_report(spawns: &[SpawnExec], top_n: usize) {
+    println!("{}", section("Remote Execution Uploads"));
+
+    let remote_executions: Vec<&SpawnExec> = spawns
+        .iter()
+        .filter(|s| !is_cache_hit(s) && classify_runner(&s.runner) == RunnerKind::Remote)
+        .collect();
+    if remote_executions.is_empty() {
+        println!("No remote executions found in the log.");
+        println!();
+        return;
+    }
+
+    let mut total_output_bytes: i64 = 0;
+    let mut total_upload_time = Duration::ZERO;
+    let mut upload_time_count = 0;
+    for spawn in &remote_executions {
+        total_output_bytes += output_bytes(spawn);
+        if let Some(upload) = spawn.metrics.as_ref().and_then(|m| m.upload_time.as_ref()) {
+            upload_time_count += 1;
+            total_upload_time += to_std_duration(upload);
+        }
+    }
+
+    let total_upload_seconds = total_upload_time.as_secs_f64();
+    println!("Remote Executions Count: {}", remote_executions.len());
+    println!(
+        "Total Upload Time (recorded, {} of {} actions): {}",
+        upload_time_count,
+        remote_executions.len(),
+        format_seconds(total_upload_seconds, 2));
+    println!(
+        "Total Output Size (estimated from output digests): {}",
+        format_bytes(total_output_bytes)
+    );
+    if total_upload_seconds > 0.001 {
+        println!(
+            "Average Upload Rate (estimated): {}",
+            format_rate(total_output_bytes as f64 / total_upload_seconds)
+        );
+    } else {
+        println!("Average Upload Rate: N/A (no upload time recorded)");
+    }
+    let inputs = UploadEstimate::from_spawns(spawns);
+    if !inputs.executions.is_empty() {
+        println!(
+            "Input Upload Estimate (upper bound, see --input-upload-estimate): {} of inputs, {} not seen earlier in the log",
+            format_bytes(inputs.bytes),
+            format_bytes(inputs.unseen_bytes)
+        );
+    }
+
+    let mut by_size: Vec<(i64, &SpawnExec)> = remote_executions
+        .iter()
+        .map(|s| (output_bytes(s), *s))
+        .filter(|(size, _)| *size > 0)
+        .collect();
+    if !by_size.is_empty() {
+        by_size.sort_by(|a, b| highest_first(a.1, b.1, output_bytes));
+        println!();
+        println!("{} Remote Executions by Output Size:", top_label(top_n));
+        let mut table = Table::new(vec![
+            ("Output Size".to_string(), Align::Right),
+            ("Upload Time".to_string(), Align::Right),
+            ("Mnemonic".to_string(), Align::Left),
+            ("Target".to_string(), Align::Left),
+        ]);
+        for (size, spawn) in by_size.into_iter().take(top_n) {
+            let upload = spawn
+                .metrics
+                .as_ref()
+                .and_then(|m| m.upload_time.as_ref())
+                .map(|d| format_duration(to_std_duration(d), 3))
+                .unwrap_or_else(|| "N/A".to_string());
+            table.add_row(vec![
+                format_bytes(size),
+                upload,
+                spawn.mnemonic.clone(),
+                spawn.target_label.clone(),
+            ]);
+        }
+        table.print();
+    }
+    println!();
+}
+
+/// What one remote execution may have uploaded of its input tree.
+struct InputUpload<'a> {
+    spawn: &'a SpawnExec,
+    inputs: usize,
+    /// Summed size of its input digests.
+    bytes: i64,
+    /// Of those, the digests no earlier spawn of the log had put in the CAS.
+    unseen_bytes: i64,
+}
+
+/// The upper-bound model of --input-upload-estimate: every input of a remote execution
+/// uploaded, less the digests seen earlier in the log, as the inputs of an earlier remote
+/// execution or the outputs of a spawn that put them in the CAS. The real CAS holds much more
+/// from earlier builds.
+struct UploadEstimate<'a> {
+    executions: Vec<InputUpload<'a>>,
+    bytes: i64,
+    unseen_bytes: i64,
+}
+
+impl<'a> UploadEstimate<'a> {
+    fn from_spawns(spawns: &'a [SpawnExec]) -> Self {
+        let mut seen = DigestSet::default();
+        let mut estimate = UploadEstimate {
+            executions: Vec::new(),
+            bytes: 0,
+            unseen_bytes: 0,
+        };
+        for spawn in spawns {
+            let remote =
+                !is_cache_hit(spawn) && classify_runner(&spawn.runner) == RunnerKind::Remote;
+            if remote && !spawn.inputs.is_empty() {
+                let mut upload = InputUpload {
+                    spawn,
+                    inputs: spawn.inputs.len(),
+                    bytes: 0,
+                    unseen_bytes: 0,
+                };
+                for digest in spawn.inputs.iter().filter_map(|f| f.digest.as_ref()) {
+                    upload.bytes += digest.size_bytes;
+                    if seen.insert(digest) {
+                        upload.unseen_bytes += digest.size_bytes;
+                    }
+                }
+                estimate.bytes += upload.bytes;
+                estimate.unseen_bytes += upload.unseen_bytes;
+                estimate.executions.push(upload);
+            }
+            if remote || is_cache_hit(spawn) || uploads_outputs(spawn) {
+                for digest in spawn.actual_outputs.iter().filter_map(|f| f.digest.as_ref()) {
+                    seen.insert(digest);
+                }
+            }
+        }
+        estimate
+    }
+}
+
+/// Ranks remote executions and their mnemonics by the input bytes they may have uploaded,
+/// crediting digests seen earlier in the log; see [`UploadEstimate`].
+pub fn print_input_upload_report(spawns: &[SpawnExec], top_n: usize) {
+    println!("{}", section("Input Upload Estimate (Upper Bound)"));
+    let mut estimate = UploadEstimate::from_spawns(spawns);
+    if estimate.executions.is_empty() {
+        println!("No remote executions with recorded inputs found in the log.");
+        println!();
+        return;
+    }
+    println!(
+        "Note: An upper bound: every input counts as uploaded unless an earlier spawn of this log put its digest in the CAS, which in practice holds much of it from earlier builds."
+    );
+    println!("Remote Executions With Inputs: {}", estimate.executions.len());
+    println!("Input Bytes (summed per execution): {}", format_bytes(estimate.bytes));
+    println!(
+        "Not Seen Earlier in the Log: {} ({:.1}%)",
+        format_bytes(estimate.unseen_bytes),
+        estimate.unseen_bytes as f64 / estimate.bytes.max(1) as f64 * 100.0
+    );
+
+    let mut by_mnemonic: HashMap<&str, (u64, i64, i64)> = HashMap::new();
+    for upload in &estimate.executions {
+        let entry = by_mnemonic.entry(&upload.spawn.mnemonic).or_default();
+        entry.0 += 1;
+        entry.1 += upload.bytes;
+        entry.2 += upload.unseen_bytes;
+    }
+
+    estimate.executions.sort_by(|a, b| {
+        b.unseen_bytes
+            .cmp(&a.unseen_bytes)
+            .then_with(|| b.bytes.cmp(&a.bytes))
+            .then_with(|| action_key(a.spawn).cmp(&action_key(b.spawn)))
+    });
+    println!();
+    println!("{} Remote Executions by Unseen Input Bytes:", top_label(top_n));
+    let mut table = Table::new(vec![
+        ("Unseen".to_string(), Align::Right),
+        ("Input Bytes".to_string(), Align::Right),
+        ("Inputs".to_string(), Align::Right),
+        ("Mnemonic".to_string(), Align::Left),
+        ("Target".to_string(), Align::Left),
+    ]);
+    for upload in estimate.executions.iter().take(top_n) {
+        table.add_row(vec![
+            format_bytes(upload.unseen_bytes),
+            format_bytes(upload.bytes),
+            upload.inputs.to_string(),
+            upload.spawn.mnemonic.clone(),
+            upload.spawn.target_label.clone(),
+        ]);
+    }
+    table.print();
+
+    let mut mnemonics: Vec<_> = by_mnemonic.into_iter().collect();
+    mnemonics.sort_by(|a, b| b.1.2.cmp(&a.1.2).then(a.0.cmp(b.0)));
+    println!();
+    println!("{} Mnemonics by Unseen Input Bytes:", top_label(top_n));
+    let total = estimate.unseen_bytes.max(1) as f64;
+    let mut table = Table::new(vec![
+        ("Mnemonic".to_string(), Align::Left),
+        ("Executions".to_string(), Align::Right),
+        ("Input Bytes".to_string(), Align::Right),
+        ("Unseen".to_string(), Align::Right),
+        ("% of Unseen".to_string(), Align::Right),
+    ]);
+    for (mnemonic, (count, bytes, unseen)) in mnemonics.into_iter().take(top_n) {
+        table.add_row(vec![
+            mnemonic.to_string(),
+            count.to_string(),
+            format_bytes(bytes),
+            format_bytes(unseen),
+            format!("{:.1}%", unseen as f64 / total * 100.0),
+        ]);
+    }
+    table.print();
+    println!();
+}
+
+/// Content-addressable storage implied by one build: unique output and input digests.
+pub fn print_cas_footprint_report(spawns: &[SpawnExec], top_n: usize) {
+    println!("{}", section("CAS Footprint Estimate"));
+
+    let mut outputs = Volume::default();
+    let mut inputs = Volume::default();
+    // Unique output bytes attributed to the mnemonic that first produced each digest.
+    let mut by_mnemonic: HashMap<&str, (u64, i64)> = HashMap::new();
+    for spawn in spawns {
+        for file in &spawn.actual_outputs {
+            if outputs.add(file) {
+                let entry = by_mnemonic.entry(spawn.mnemonic.as_str()).or_default();
+                entry.0 += 1;
+                entry.1 += file.digest.as_ref().map_or(0, |d| d.size_bytes);
+            }
+        }
+        for file in &spawn.inputs {
+            inputs.add(file);
+        }
+    }
+
+    if outputs.unique.is_empty() && inputs.unique.is_empty() {
+        println!("No file digests found in the log.");
+        println!();
+        return;
+    }
+
+    let mut table = Table::new(vec![
+        ("Set".to_string(), Align::Left),
+        ("Files".to_string(), Align::Right),
+        ("Naive Bytes".to_string(), Align::Right),
+        ("Unique Digests".to_string(), Align::Right),
+        ("Unique Bytes".to_string(), Align::Right),
+        ("Dedup Ratio".to_string(), Align::Right),
+    ]);
+    for (name, volume) in [("Outputs", &outputs), ("Inputs", &inputs)] {
+        table.add_row(vec![
+            name.to_string(),
+            volume.files.to_string(),
+            format_bytes(volume.bytes),
+            volume.unique.len().to_string(),
+            format_bytes(volume.unique.bytes()),
+            volume
+                .dedup_ratio()
+                .map_or_else(|| "N/A".to_string(), |r| format!("{:.2}x", r)),
+        ]);
+    }
+    table.print();
+
+    if !by_mnemonic.is_empty() {
+        let mut mnemonics: Vec<_> = by_mnemonic.into_iter().collect();
+        mnemonics.sort_by(|a, b| b.1.1.cmp(&a.1.1).then(a.0.cmp(b.0)));
+        println!();
+        println!("{} Mnemonics by Unique Output Bytes:", top_label(top_n));
+        let total = outputs.unique.bytes().max(1) as f64;
+        let mut table = Table::new(vec![
+            ("Mnemonic".to_string(), Align::Left),
+            ("Unique Outputs".to_string(), Align::Right),
+            ("Unique Bytes".to_string(), Align::Right),
+            ("% of Unique".to_string(), Align::Right),
+        ]);
+        for (mnemonic, (count, bytes)) in mnemonics.into_iter().take(top_n) {
+            table.add_row(vec![
+                mnemonic.to_string(),
+                count.to_string(),
+                format_bytes(bytes),
+                format!("{:.1}%", bytes as f64 / total * 100.0),
+            ]);
+        }
+        table.print();
+    }
+    println!();
+}
+
+/// Assumptions used to project cache storage growth from a single build.
+pub struct CacheCostOptions {
+    pub builds_per_day: f64,
+    /// Fraction of a build's output bytes assumed to be new content when no previous log is given.
+    pub change_rate: f64,
+}
+
+/// Estimates the new blobs a build writes to the cache, and the resulting storage growth.
+///
+/// With `previous` spawns, the new-digest delta is measured against that build; otherwise
+/// it is extrapolated from the configured change rate.
+/// Whether the spawn's outputs were uploaded to the cache: it was executed, cacheable and
+/// succeeded, since outputs of failing spawns are never cached.
+fn uploads_outputs(spawn: &SpawnExec) -> bool {
+    spawn.cacheable && !is_cache_hit(spawn) && !is_failed(spawn)
+}
+
+pub fn print_cache_cost_report(
+    spawns: &[SpawnExec],
+    previous: Option<&[SpawnExec]>,
+    options: &CacheCostOptions,
+) {
+    println!("{}", section("Remote Cache Storage Cost Estimate"));
+
+    // Blobs the build may upload.
+    let mut written = DigestSet::default();
+    for spawn in spawns.iter().filter(|s| uploads_outputs(s)) {
+        for digest in spawn.actual_outputs.iter().filter_map(|f| f.digest.as_ref()) {
+            written.insert(digest);
+        }
+    }
+    if written.is_empty() {
+        println!("No outputs of successful executed cacheable spawns found in the log.");
+        println!();
+        return;
+    }
+
+    println!(
+        "[measured] Outputs written by successful executed cacheable spawns: {} unique blobs, {}",
+        written.len(),
+        format_bytes(written.bytes())
+    );
+
+    let new_bytes = match previous {
+        Some(previous) => {
+            let mut before = DigestSet::default();
+            for digest in previous
+                .iter()
+                .flat_map(|s| &s.actual_outputs)
+                .filter_map(|f| f.digest.as_ref())
+            {
+                before.insert(digest);
+            }
+            let mut delta = DigestSet::default();
+            for spawn in spawns.iter().filter(|s| uploads_outputs(s)) {
+                for digest in spawn.actual_outputs.iter().filter_map(|f| f.digest.as_ref()) {
+                    if !before.contains(digest) {
+                        delta.insert(digest);
+                    }
+                }
+            }
+            println!(
+                "[measured] New blobs versus the previous build: {} blobs, {}",
+                delta.len(),
+                format_bytes(delta.bytes())
+            );
+            delta.bytes() as f64
+        }
+        None => {
+            let estimate = written.bytes() as f64 * options.change_rate;
+            println!(
+                "[extrapolated] New blobs per build at a {:.0}% change rate: {}",
+                options.change_rate * 100.0,
+                format_bytes(estimate as i64)
+            );
+            estimate
+        }
+    };
+
+    let daily = new_bytes * options.builds_per_day;
+    println!(
+        "[extrapolated] Storage growth at {} builds/day: {} per day, {} per 30 days",
+        options.builds_per_day,
+        format_bytes(daily as i64),
+        format_bytes((daily * 30.0) as i64)
+    );
+    println!("Note: Growth ignores eviction and assumes every build changes content like this one.");
+    println!();
+}
+
+/// Assumptions used to estimate the benefit of a remote cache for a build that ran without one.
+pub struct RemoteCacheBenefitOptions {
+    /// Expected share of eligible spawns that would hit, if given.
+    pub hit_rate: Option<f64>,
+    /// Expected download rate in bytes per second.
+    pub download_rate: f64,
+}
+
+/// Estimates what a remote cache would save for spawns that ran locally and could be cached.
+///
+/// Aimed at logs from builds without any cache, where the other cache reports have no hits
+/// to learn from.
+pub fn print_remote_cache_benefit_report(spawns: &[SpawnExec], options: &RemoteCacheBenefitOptions) {
+    println!("{}", section("Estimated Benefit of a Remote Cache"));
+
+    let eligible: Vec<&SpawnExec> = spawns
+        .iter()
+        .filter(|s| {
+            matches!(
+                classify_runner(&s.runner),
+                RunnerKind::Sandboxed | RunnerKind::Local | RunnerKind::Worker
+            ) && s.remote_cacheable
+                && !is_cache_hit(s)
+                && !is_failed(s)
+        })
+        .collect();
+    if eligible.is_empty() {
+        println!("No successful, remotely cacheable spawns executed locally found in the log.");
+        println!();
+        return;
+    }
+
+    let hits = spawns.iter().filter(|s| is_cache_hit(s)).count();
+    if hits > 0 {
+        println!(
+            "Note: The log already records {} cache hits; the perfect-cache estimate in the cache report may fit better.",
+            hits
+        );
+    }
+    let spawn_time: Duration = spawns.iter().map(total_time).sum();
+    let share = |time: f64| {
+        if spawn_time.is_zero() {
+            0.0
+        } else {
+            time / spawn_time.as_secs_f64() * 100.0
+        }
+    };
+    let eligible_time: Duration = eligible.iter().map(|s| total_time(s)).sum();
+    println!(
+        "Upper bound: {} locally executed cacheable spawns took {} ({:.1}% of total spawn time)",
+        eligible.len(),
+        format_duration(eligible_time, 2),
+        share(eligible_time.as_secs_f64())
+    );
+
+    let Some(hit_rate) = options.hit_rate else {
+        println!("Note: Pass --assumed-hit-rate for an estimate that accounts for misses and download time.");
+        println!();
+        return;
+    };
+    let bytes: i64 = eligible.iter().map(|s| output_bytes(s)).sum();
+    let net_savings: f64 = eligible
+        .iter()
+        .map(|s| {
+            let fetch = output_bytes(s) as f64 / options.download_rate;
+            (total_time(s).as_secs_f64() - fetch).max(0.0)
+        })
+        .sum();
+    let estimate = net_savings * hit_rate;
+    println!(
+        "Estimate: {} saved ({:.1}% of total spawn time)",
+        format_seconds(estimate, 2),
+        share(estimate)
+    );
+    println!(
+        "Assumptions: {:.0}% of these spawns hit; a hit costs the download of its outputs ({} in total) at {}; lookups and uploads are free.",
+        hit_rate * 100.0,
+        format_bytes(bytes),
+        format_rate(options.download_rate)
+    );
+    println!();
+}
+
+/// One mnemonic of the break-even table: what its hits paid to fetch and what its misses paid
+/// to rebuild.
+#[derive(Default)]
+struct BreakEven {
+    hits: u64,
+    /// Hits that recorded a fetch or network time, with their summed fetch and lookup time.
+    hit_samples: u64,
+    hit_cost: Duration,
+    misses: u64,
+    /// Misses that recorded an execution or total time, with their summed time.
+    miss_samples: u64,
+    rebuild_cost: Duration,
+    /// Network time the misses spent asking the cache, which disabling reads saves too.
+    miss_lookup: Duration,
+}
+
+impl BreakEven {
+    fn add(&mut self, spawn: &SpawnExec) {
+        let phase = |phase| spawn.metrics.as_ref().and_then(|m| phase_duration(m, phase));
+        let network = phase(Phase::Network);
+        if is_cache_hit(spawn) {
+            self.hits += 1;
+            let fetch = phase(Phase::Fetch);
+            if fetch.is_some() || network.is_some() {
+                self.hit_samples += 1;
+                self.hit_cost += fetch.unwrap_or_default() + network.unwrap_or_default();
+            }
+        } else {
+            self.misses += 1;
+            let rebuild = phase(Phase::Execution).or_else(|| recorded_total_time(spawn));
+            if let Some(rebuild) = rebuild {
+                self.miss_samples += 1;
+                self.rebuild_cost += rebuild;
+                self.miss_lookup += network.unwrap_or_default();
+            }
+        }
+    }
+
+    /// Average fetch and average rebuild time, when both sides have samples.
+    fn averages(&self) -> Option<(f64, f64)> {
+        (self.hit_samples > 0 && self.miss_samples > 0).then(|| {
+            (
+                self.hit_cost.as_secs_f64() / self.hit_samples as f64,
+                self.rebuild_cost.as_secs_f64() / self.miss_samples as f64,
+            )
+        })
+    }
+
+    /// Seconds saved by disabling cache reads: each hit rebuilds at the average rebuild time
+    /// instead of fetching, and no miss looks the cache up first. Negative when reads pay off.
+    fn saving(&self, fetch: f64, rebuild: f64) -> f64 {
+        self.hits as f64 * (fetch - rebuild) + self.miss_lookup.as_secs_f64()
+    }
+}
+
+/// Compares, per mnemonic, what a cache hit costs (fetch plus lookup time) with what a miss
+/// costs to execute, and flags the mnemonics whose fetches cost more than `ratio` of a
+/// rebuild, candidates for a `no-remote-cache` execution requirement.
+pub fn print_cache_break_even_report(spawns: &[SpawnExec], ratio: f64) {
+    println!("{}", section("Cache Break-Even (Fetch vs. Rebuild)"));
+    let mut by_mnemonic: HashMap<&str, BreakEven> = HashMap::new();
+    for spawn in spawns.iter().filter(|s| !is_failed(s)) {
+        by_mnemonic.entry(&spawn.mnemonic).or_default().add(spawn);
+    }
+    let (mut compared, mut insufficient): (Vec<_>, Vec<_>) = by_mnemonic
+        .into_iter()
+        .partition(|(_, stats)| stats.averages().is_some());
+    if compared.is_empty() && insufficient.is_empty() {
+        println!("No successful spawns found in the log.");
+        println!();
+        return;
+    }
+
+    // By fetch-to-rebuild ratio, the most expensive fetches first.
+    let ratio_of = |stats: &BreakEven| {
+        stats
+            .averages()
+            .map_or(0.0, |(fetch, rebuild)| fetch / rebuild.max(f64::MIN_POSITIVE))
+    };
+    compared.sort_by(|(a_name, a), (b_name, b)| {
+        ratio_of(b)
+            .total_cmp(&ratio_of(a))
+            .then_with(|| a_name.cmp(b_name))
+    });
+    let mut table = Table::new(vec![
+        ("Mnemonic".to_string(), Align::Left),
+        ("Hits".to_string(), Align::Right),
+        ("Avg Fetch".to_string(), Align::Right),
+        ("Misses".to_string(), Align::Right),
+        ("Avg Rebuild".to_string(), Align::Right),
+        ("Fetch/Rebuild".to_string(), Align::Right),
+        ("Est. Saving".to_string(), Align::Right),
+        ("Verdict".to_string(), Align::Left),
+    ]);
+    let mut flagged = Vec::new();
+    let mut total_saving = 0.0;
+    for (mnemonic, stats) in &compared {
+        let (fetch, rebuild) = stats.averages().expect("compared mnemonics have both sides");
+        let share = ratio_of(stats);
+        let disable = share > ratio;
+        let saving = stats.saving(fetch, rebuild);
+        if disable {
+            flagged.push(*mnemonic);
+            total_saving += saving;
+        }
+        table.add_row(vec![
+            mnemonic.to_string(),
+            format!("{} ({} timed)", stats.hits, stats.hit_samples),
+            format_seconds(fetch, 3),
+            format!("{} ({} timed)", stats.misses, stats.miss_samples),
+            format_seconds(rebuild, 3),
+            format!("{:.0}%", share * 100.0),
+            format_seconds(saving, 2),
+            if disable { "disable cache reads" } else { "keep" }.to_string(),
+        ]);
+    }
+    if !compared.is_empty() {
+        println!(
+            "Note: A fetch is the fetch and network time of a hit; a rebuild is the execution (else total) time of a miss."
+        );
+        table.print();
+    }
+
+    if flagged.is_empty() && !compared.is_empty() {
+        println!(
+            "No mnemonic fetches for more than {:.0}% of its rebuild time.",
+            ratio * 100.0
+        );
+    } else if !flagged.is_empty() {
+        println!(
+            "{} mnemonics fetch for more than {:.0}% of their rebuild time: {}.",
+            flagged.len(),
+            ratio * 100.0,
+            flagged.join(", ")
+        );
+        if total_saving >= 0.0 {
+            println!(
+                "Estimated time saved by disabling cache reads for them: {}",
+                format_seconds(total_saving, 2)
+            );
+        } else {
+            println!(
+                "Estimated time lost by disabling cache reads for them: {}; their hits are still cheaper than rebuilding, if barely.",
+                format_seconds(-total_saving, 2)
+            );
+        }
+        println!(
+            "Note: --modify_execution_info={} turns the cache off for them (writes included).",
+            flagged
+                .iter()
+                .map(|mnemonic| format!("{}=+no-remote-cache", mnemonic))
+                .collect::<Vec<_>>()
+                .join(",")
+        );
+    }
+
+    if !insufficient.is_empty() {
+        insufficient.sort_by(|(a, _), (b, _)| a.cmp(b));
+        let listed: Vec<String> = insufficient
+            .iter()
+            .map(|(mnemonic, stats)| {
+                format!(
+                    "{} ({} timed hits, {} timed misses)",
+                    mnemonic, stats.hit_samples, stats.miss_samples
+                )
+            })
+            .collect();
+        println!(
+            "Insufficient data for {} mnemonics, which need a timed hit and a timed miss: {}",
+            insufficient.len(),
+            listed.join(", ")
+        );
+    }
+    println!();
+}
@@ -0,0 +1,198 @@
+//! The single line --ci-summary prints last, for CI systems that scrape job output for a
+//! marker instead of parsing the full report, and the row --append-summary adds to a CSV file
+//! that collects one row per run.
+
+use crate::classify::{is_cache_hit, is_failed};
+use crate::cli::SummaryLabel;
+use crate::format::{csv_field, format_timestamp};
+use crate::metrics::{recorded_total_time, total_time};
+use crate::proto::SpawnExec;
+use crate::reports::cache::{downloaded_bytes, time_weighted_hit_rate};
+use crate::schema::SCHEMA_VERSION;
+use crate::stats::DurationPercentiles;
+use crate::{AppError, AppResult};
+use serde::Serialize;
+use std::collections::HashSet;
+use std::fs::{self, File, OpenOptions};
+use std::io::{self, BufRead, BufReader, Write};
+use std::path::Path;
+use std::time::{Duration, SystemTime, UNIX_EPOCH};
+
+/// Starts the line, followed by a space and the JSON object.
+pub const MARKER: &str = "BZL_EXECLOG_SUMMARY";
+
+/// The fields of the line: raw numbers without units. Fields are only ever added, never
+/// renamed or removed, so scrapers keep working across releases.
+#[derive(Serialize)]
+pub struct CiSummary {
+    /// Spawns analyzed, after the filter flags and --dedupe.
+    pub actions: u64,
+    /// Share of actions that hit the cache, from 0 to 1.
+    pub hit_rate: f64,
+    /// The time-weighted hit rate of --min-hit-rate-weighted, from 0 to 1; null when no
+    /// action recorded a time.
+    pub hit_rate_weighted: Option<f64>,
+    /// Summed total time of all spawns.
+    pub spawn_seconds: f64,
+    /// Bytes downloaded by remote cache hits.
+    pub downloaded_bytes: i64,
+    /// Spawns with a non-zero exit code or an error status.
+    pub failed: u64,
+}
+
+/// The JSON object of the line: the fields behind the schema version the other documents carry.
+#[derive(Serialize)]
+pub struct CiSummaryLine {
+    pub schema_version: u32,
+    #[serde(flatten)]
+    pub summary: CiSummary,
+}
+
+impl CiSummary {
+    pub fn from_spawns(spawns: &[SpawnExec]) -> Self {
+        let hits = spawns.iter().filter(|s| is_cache_hit(s)).count();
+        CiSummary {
+            actions: spawns.len() as u64,
+            hit_rate: if spawns.is_empty() {
+                0.0
+            } else {
+                hits as f64 / spawns.len() as f64
+            },
+            hit_rate_weighted: time_weighted_hit_rate(spawns).map(|rate| rate / 100.0),
+            spawn_seconds: spawns.iter().map(total_time).sum::<Duration>().as_secs_f64(),
+            downloaded_bytes: downloaded_bytes(spawns),
+            failed: spawns.iter().filter(|s| is_failed(s)).count() as u64,
+        }
+    }
+}
+
+/// Prints the marker line for `spawns` to stdout.
+pub fn print_ci_summary(spawns: &[SpawnExec]) -> AppResult<()> {
+    let line = CiSummaryLine {
+        schema_version: SCHEMA_VERSION,
+        summary: CiSummary::from_spawns(spawns),
+    };
+    println!("{} {}", MARKER, serde_json::to_string(&line)?);
+    Ok(())
+}
+
+/// The columns of an --append-summary row after the timestamp and the --summary-label keys.
+/// Files written with other columns are refused, so a change here needs a note in the README.
+const SUMMARY_COLUMNS: [&str; 6] = [
+    "actions",
+    "hit_rate",
+    "hit_rate_weighted",
+    "spawn_seconds",
+    "downloaded_bytes",
+    "p95_seconds",
+];
+
+fn summary_header(labels: &[SummaryLabel]) -> String {
+    let mut columns = vec!["timestamp"];
+    columns.extend(labels.iter().map(|label| label.key.as_str()));
+    columns.extend(SUMMARY_COLUMNS);
+    columns.join(",")
+}
+
+fn summary_row(labels: &[SummaryLabel], spawns: &[SpawnExec]) -> String {
+    let summary = CiSummary::from_spawns(spawns);
+    let mut durations: Vec<Duration> = spawns.iter().filter_map(recorded_total_time).collect();
+    let p95 = DurationPercentiles::compute(&mut durations).map(|p| p.p95);
+    let now = SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .unwrap_or_default();
+    let mut fields = vec![format_timestamp(now)];
+    fields.extend(labels.iter().map(|label| csv_field(&label.value)));
+    fields.extend([
+        summary.actions.to_string(),
+        format!("{:.4}", summary.hit_rate),
+        summary
+            .hit_rate_weighted
+            .map_or_else(String::new, |rate| format!("{:.4}", rate)),
+        format!("{:.3}", summary.spawn_seconds),
+        summary.downloaded_bytes.to_string(),
+        p95.map_or_else(String::new, |p95| format!("{:.3}", p95.as_secs_f64())),
+    ]);
+    fields.join(",") + "\n"
+}
+
+/// Creates `path` holding only `header` unless it exists. The header is written to a
+/// temporary file that is then linked into place, so a job that finds the file always finds
+/// its header, even while another job is creating it.
+fn create_with_header(path: &Path, header: &str) -> AppResult<()> {
+    if path.exists() {
+        return Ok(());
+    }
+    let name = path.file_name().map_or_else(
+        || "summary.csv".into(),
+        |name| name.to_string_lossy().into_owned(),
+    );
+    let temporary = path.with_file_name(format!(".{}.{}.tmp", name, std::process::id()));
+    fs::write(&temporary, format!("{}\n", header))?;
+    let linked = fs::hard_link(&temporary, path);
+    fs::remove_file(&temporary)?;
+    match linked {
+        Err(err) if err.kind() != io::ErrorKind::AlreadyExists => Err(err.into()),
+        _ => Ok(()),
+    }
+}
+
+/// Refuses a file whose header is not the one this run writes, naming what differs.
+fn check_header(path: &Path, header: &str, labels: &[SummaryLabel]) -> AppResult<()> {
+    let mut found = String::new();
+    BufReader::new(File::open(path)?).read_line(&mut found)?;
+    let found = found.trim_end_matches(['\r', '\n']);
+    if found == header {
+        return Ok(());
+    }
+    let columns: Vec<&str> = found.split(',').collect();
+    let message = if columns.first() != Some(&"timestamp") {
+        format!(
+            "{} does not start with an --append-summary header; pass a new file or one an \
+             earlier run created",
+            path.display()
+        )
+    } else if columns.ends_with(&SUMMARY_COLUMNS) {
+        let keys = &columns[1..columns.len() - SUMMARY_COLUMNS.len()];
+        let given: Vec<&str> = labels.iter().map(|label| label.key.as_str()).collect();
+        format!(
+            "{} has the label columns [{}], but --summary-label gives [{}]; pass the same keys \
+             in the same order as the earlier runs, or a new file",
+            path.display(),
+            keys.join(","),
+            given.join(",")
+        )
+    } else {
+        format!(
+            "the columns of {} ({}) are not the ones this version of the tool writes ({}); move \
+             the file aside to start a new one, or rewrite its header and rows to the new columns",
+            path.display(),
+            found,
+            header
+        )
+    };
+    Err(AppError::Analysis(message))
+}
+
+/// Appends the --append-summary row for `spawns` to `path`, creating the file with a header
+/// first. The row goes out in one write to a file opened for appending, so rows of jobs that
+/// append at the same time do not interleave.
+pub fn append_summary(
+    path: &Path,
+    labels: &[SummaryLabel],
+    spawns: &[SpawnExec],
+) -> AppResult<()> {
+    let header = summary_header(labels);
+    let mut columns = HashSet::new();
+    if let Some(key) = header.split(',').find(|&key| !columns.insert(key)) {
+        return Err(AppError::Analysis(format!(
+            "--summary-label {} names a column that is already in the --append-summary row",
+            key
+        )));
+    }
+    create_with_header(path, &header)?;
+    check_header(path, &header, labels)?;
+    let mut file = OpenOptions::new().append(true).open(path)?;
+    file.write_all(summary_row(labels, spawns).as_bytes())?;
+    Ok(())
+}
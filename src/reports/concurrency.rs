@@ -0,0 +1,405 @@
+//! How many spawns were running at once over the build, from spawn timestamps.
+
+use crate::analysis::action_key;
+use crate::format::{format_duration, format_seconds, section, Align, Table};
+use crate::metrics::{recorded_total_time, start_time, wall_clock_span};
+use crate::proto::SpawnExec;
+use std::time::Duration;
+
+/// Upper bound on the number of buckets; wider buckets are used for longer builds.
+const MAX_BUCKETS: u64 = 100_000;
+
+/// Width of the sparkline, in characters.
+const SPARKLINE_WIDTH: usize = 80;
+
+const SPARK_LEVELS: [char; 9] = [' ', '▁', '▂', '▃', '▄', '▅', '▆', '▇', '█'];
+
+/// Average number of running spawns in consecutive buckets of equal width.
+pub struct ConcurrencySeries {
+    pub bucket: Duration,
+    pub values: Vec<f64>,
+    /// Highest number of spawns running at the same instant.
+    pub peak: usize,
+}
+
+impl ConcurrencySeries {
+    pub fn average(&self) -> f64 {
+        self.values.iter().sum::<f64>() / self.values.len() as f64
+    }
+
+    /// The highest bucket average.
+    pub fn busiest(&self) -> f64 {
+        self.values.iter().cloned().fold(0.0, f64::max)
+    }
+
+    /// Of the buckets, from 0 to 1, those averaging fewer than `threshold` running spawns.
+    pub fn share_below(&self, threshold: usize) -> f64 {
+        let low = self.values.iter().filter(|v| **v < threshold as f64).count();
+        low as f64 / self.values.len() as f64
+    }
+}
+
+/// Spawns without a start or total time, which the series leaves out.
+pub fn untimed_spawns(spawns: &[SpawnExec]) -> usize {
+    spawns
+        .iter()
+        .filter(|s| start_time(s).is_none() || recorded_total_time(s).is_none())
+        .count()
+}
+
+/// Computes the concurrency series from the spawns that recorded a start and total time.
+/// Returns `None` when no spawn did.
+pub fn concurrency_series(spawns: &[SpawnExec], bucket: Duration) -> Option<ConcurrencySeries> {
+    let intervals: Vec<(Duration, Duration)> = spawns
+        .iter()
+        .filter_map(|s| {
+            let start = start_time(s)?;
+            Some((start, start + recorded_total_time(s)?))
+        })
+        .collect();
+    let build_start = intervals.iter().map(|i| i.0).min()?;
+    let build_end = intervals.iter().map(|i| i.1).max()?;
+    let wall = (build_end - build_start).as_secs_f64();
+
+    let mut width = bucket.as_secs_f64().max(1e-3);
+    if wall / width > MAX_BUCKETS as f64 {
+        width = wall / MAX_BUCKETS as f64;
+    }
+    let count = ((wall / width).ceil() as usize).max(1);
+
+    // Each spawn adds the fraction of every bucket it overlaps.
+    let mut values = vec![0.0; count];
+    for (start, end) in &intervals {
+        let start = (*start - build_start).as_secs_f64();
+        let end = (*end - build_start).as_secs_f64();
+        let first = ((start / width) as usize).min(count - 1);
+        let last = ((end / width) as usize).min(count - 1);
+        for (index, value) in values.iter_mut().enumerate().take(last + 1).skip(first) {
+            let lo = start.max(index as f64 * width);
+            let hi = end.min((index + 1) as f64 * width);
+            *value += (hi - lo).max(0.0) / width;
+        }
+    }
+
+    // The instantaneous peak comes from a sweep over start and end events; ends sort first
+    // so that back-to-back spawns do not count as overlapping.
+    let mut events: Vec<(Duration, i32)> = intervals
+        .iter()
+        .flat_map(|(start, end)| [(*start, 1), (*end, -1)])
+        .collect();
+    events.sort();
+    let mut running = 0i64;
+    let mut peak = 0i64;
+    for (_, delta) in events {
+        running += delta as i64;
+        peak = peak.max(running);
+    }
+
+    Some(ConcurrencySeries {
+        bucket: Duration::from_secs_f64(width),
+        values,
+        peak: peak as usize,
+    })
+}
+
+/// Renders the series as one line of block characters, averaging buckets to fit the width.
+fn sparkline(values: &[f64], max: f64) -> String {
+    let per_column = values.len().div_ceil(SPARKLINE_WIDTH);
+    values
+        .chunks(per_column)
+        .map(|chunk| {
+            let average = chunk.iter().sum::<f64>() / chunk.len() as f64;
+            let level = if max > 0.0 {
+                (average / max * (SPARK_LEVELS.len() - 1) as f64).round() as usize
+            } else {
+                0
+            };
+            SPARK_LEVELS[level.min(SPARK_LEVELS.len() - 1)]
+        })
+        .collect()
+}
+
+/// Prints average and peak concurrency, and how much of the build ran below `threshold`
+/// concurrent spawns.
+pub fn print_concurrency_report(
+    spawns: &[SpawnExec],
+    bucket: Duration,
+    threshold: usize,
+    show_sparkline: bool,
+) {
+    println!("{}", section("Concurrency Over Time"));
+
+    let Some(series) = concurrency_series(spawns, bucket) else {
+        println!("No spawn start times found in the log, so concurrency cannot be estimated.");
+        println!();
+        return;
+    };
+    let missing = untimed_spawns(spawns);
+    let buckets = series.values.len();
+    let busiest = series.busiest();
+
+    println!(
+        "Wall time: {} in {} buckets of {}",
+        format_seconds(buckets as f64 * series.bucket.as_secs_f64(), 1),
+        buckets,
+        format_duration(series.bucket, 3));
+    println!("Average concurrency: {:.1}", series.average());
+    println!(
+        "Peak concurrency: {} running at once ({:.1} average in the busiest bucket)",
+        series.peak, busiest
+    );
+    println!(
+        "Below {} concurrent actions: {:.1}% of wall time",
+        threshold,
+        series.share_below(threshold) * 100.0
+    );
+    if show_sparkline {
+        println!("  |{}| peak {:.1}", sparkline(&series.values, busiest), busiest);
+    }
+    if missing > 0 {
+        println!(
+            "Note: {} spawns without a start or total time are not counted.",
+            missing
+        );
+    }
+    println!();
+}
+
+/// Wall-clock time during which at least one spawn was running, and the number of spawns
+/// that recorded both a start and a total time.
+fn covered_time(spawns: &[SpawnExec]) -> (Duration, usize) {
+    let mut intervals: Vec<(Duration, Duration)> = spawns
+        .iter()
+        .filter_map(|s| {
+            let start = start_time(s)?;
+            Some((start, start + recorded_total_time(s)?))
+        })
+        .collect();
+    intervals.sort();
+    let mut covered = Duration::ZERO;
+    let mut current: Option<(Duration, Duration)> = None;
+    for (start, end) in &intervals {
+        match &mut current {
+            Some((_, current_end)) if *start <= *current_end => {
+                *current_end = (*current_end).max(*end);
+            }
+            _ => {
+                if let Some((s, e)) = current {
+                    covered += e - s;
+                }
+                current = Some((*start, *end));
+            }
+        }
+    }
+    if let Some((s, e)) = current {
+        covered += e - s;
+    }
+    (covered, intervals.len())
+}
+
+/// Compares the summed spawn time to the build's wall time: the effective parallelism and,
+/// when the whole build's duration is known, how much of it no spawn was running.
+///
+/// `build_wall_time` is the duration of the whole `bazel build` as supplied by the user;
+/// without it the span between the first and last spawn is used.
+pub fn print_parallelism_report(spawns: &[SpawnExec], build_wall_time: Option<Duration>) {
+    println!("{}", section("Parallelism and Overhead"));
+
+    let spawn_time: Duration = spawns.iter().filter_map(recorded_total_time).sum();
+    let span = wall_clock_span(spawns).map(|s| s.span());
+    let (covered, timestamped) = covered_time(spawns);
+
+    let Some(wall) = build_wall_time.or(span).filter(|w| !w.is_zero()) else {
+        println!("No spawn start times found in the log; pass --wall-time with the duration of the build to compare against it.");
+        println!();
+        return;
+    };
+
+    println!("Sum of spawn time: {}", format_duration(spawn_time, 1));
+    if let Some(span) = span {
+        println!(
+            "First spawn start to last spawn end: {}",
+            format_duration(span, 1));
+    }
+    if let Some(build) = build_wall_time {
+        println!("Build wall time (--wall-time): {}", format_duration(build, 1));
+    }
+    println!(
+        "Effective parallelism: {:.1} (spawn seconds per wall second)",
+        spawn_time.as_secs_f64() / wall.as_secs_f64()
+    );
+    if let Some(build) = build_wall_time {
+        if timestamped > 0 {
+            let idle = build.saturating_sub(covered);
+            println!(
+                "No spawn running: {} ({:.1}% of the build wall time)",
+                format_duration(idle, 1),
+                idle.as_secs_f64() / build.as_secs_f64() * 100.0
+            );
+        } else {
+            println!("No spawn running: unknown (the log has no spawn start times)");
+        }
+    }
+
+    println!("Assumptions:");
+    println!("    └ Spawn time is each spawn's recorded total time; local, remote and cache-hit spawns count alike, and spawns without metrics are left out.");
+    if build_wall_time.is_some() {
+        println!("    └ Wall time not covered by any spawn is loading, analysis, scheduling and other Bazel overhead (or spawns missing from the log).");
+    } else {
+        println!("    └ Without --wall-time only the span of the spawns is known; loading and analysis before the first spawn are not included.");
+    }
+    let untimed = spawns.len() - timestamped;
+    if untimed > 0 && timestamped > 0 {
+        println!(
+            "Note: {} spawns without a start or total time are not counted as running.",
+            untimed
+        );
+    }
+    println!();
+}
+
+/// A stretch of wall-clock time during which no spawn was running.
+pub struct IdleGap<'a> {
+    /// From the first spawn start.
+    pub offset: Duration,
+    pub duration: Duration,
+    /// The spawn that ended last before the gap, the likely culprit along with `after`.
+    pub before: &'a SpawnExec,
+    /// The first spawn that started after it.
+    pub after: &'a SpawnExec,
+}
+
+/// The idle gaps of a build, from the spawns that recorded a start and a total time.
+pub struct IdleGaps<'a> {
+    /// The gaps of at least the minimum length, in order.
+    pub gaps: Vec<IdleGap<'a>>,
+    /// From the first spawn start to the last spawn end.
+    pub span: Duration,
+    /// Summed over `gaps`.
+    pub idle: Duration,
+    /// Summed over every gap, however short.
+    pub total_idle: Duration,
+    /// Spawns without a start or total time, which are not counted as running.
+    pub untimed: usize,
+}
+
+impl IdleGaps<'_> {
+    /// `idle` as a share of `span`, in percent.
+    pub fn idle_percent(&self) -> f64 {
+        if self.span.is_zero() {
+            0.0
+        } else {
+            self.idle.as_secs_f64() / self.span.as_secs_f64() * 100.0
+        }
+    }
+}
+
+/// Finds the gaps of at least `min_gap` between running spawns. Returns `None` when no spawn
+/// recorded a start and a total time.
+pub fn idle_gaps(spawns: &[SpawnExec], min_gap: Duration) -> Option<IdleGaps<'_>> {
+    let mut intervals: Vec<(Duration, Duration, &SpawnExec)> = spawns
+        .iter()
+        .filter_map(|s| {
+            let start = start_time(s)?;
+            Some((start, start + recorded_total_time(s)?, s))
+        })
+        .collect();
+    intervals.sort_by(|a, b| a.0.cmp(&b.0).then_with(|| action_key(a.2).cmp(&action_key(b.2))));
+    let &(build_start, _, first) = intervals.first()?;
+    let (mut end, mut last) = (build_start, first);
+    let mut gaps = Vec::new();
+    let mut total_idle = Duration::ZERO;
+    for &(start, stop, spawn) in &intervals {
+        if start > end {
+            let duration = start - end;
+            total_idle += duration;
+            if duration >= min_gap {
+                gaps.push(IdleGap {
+                    offset: end - build_start,
+                    duration,
+                    before: last,
+                    after: spawn,
+                });
+            }
+        }
+        if stop > end {
+            (end, last) = (stop, spawn);
+        }
+    }
+    Some(IdleGaps {
+        idle: gaps.iter().map(|gap| gap.duration).sum(),
+        gaps,
+        span: end - build_start,
+        total_idle,
+        untimed: spawns.len() - intervals.len(),
+    })
+}
+
+/// Lists the `top_n` longest gaps of at least `min_gap` during which no spawn was running,
+/// in the order they occurred, with the spawns on either side of each.
+pub fn print_idle_gaps_report(spawns: &[SpawnExec], min_gap: Duration, top_n: usize) {
+    println!(
+        "{}",
+        section(format_args!(
+            "Idle Gaps (no spawn running for at least {})",
+            format_duration(min_gap, 3)
+        ))
+    );
+
+    let Some(idle) = idle_gaps(spawns, min_gap) else {
+        println!("No spawn start times found in the log, so idle gaps cannot be found.");
+        println!();
+        return;
+    };
+    println!(
+        "Idle time: {} in {} gap{} ({:.1}% of the {} from the first spawn start to the last spawn end)",
+        format_duration(idle.idle, 3),
+        idle.gaps.len(),
+        if idle.gaps.len() == 1 { "" } else { "s" },
+        idle.idle_percent(),
+        format_duration(idle.span, 1)
+    );
+    println!(
+        "Idle time in gaps of any length: {}",
+        format_duration(idle.total_idle, 3)
+    );
+    if !idle.gaps.is_empty() {
+        let mut shown: Vec<&IdleGap> = idle.gaps.iter().collect();
+        shown.sort_by(|a, b| b.duration.cmp(&a.duration).then(a.offset.cmp(&b.offset)));
+        shown.truncate(top_n);
+        shown.sort_by_key(|gap| gap.offset);
+        let describe = |spawn: &SpawnExec| format!("{} {}", spawn.mnemonic, spawn.target_label);
+        let mut table = Table::new(vec![
+            ("Start".to_string(), Align::Right),
+            ("Idle".to_string(), Align::Right),
+            ("Last to End Before".to_string(), Align::Left),
+            ("First to Start After".to_string(), Align::Left),
+        ]);
+        for gap in &shown {
+            table.add_row(vec![
+                format!("+{}", format_duration(gap.offset, 3)),
+                format_duration(gap.duration, 3),
+                describe(gap.before),
+                describe(gap.after),
+            ]);
+        }
+        println!();
+        table.print();
+        let hidden = idle.gaps.len() - shown.len();
+        if hidden > 0 {
+            println!(
+                "... (+{} shorter gap{}; --top-n lists more)",
+                hidden,
+                if hidden == 1 { "" } else { "s" }
+            );
+        }
+        println!("Note: Gaps are loading and analysis pauses, critical-path stalls or spawns waiting on something outside the log; the spawns on either side are the likely culprits.");
+    }
+    if idle.untimed > 0 {
+        println!(
+            "Note: {} spawns without a start or total time are not counted as running.",
+            idle.untimed
+        );
+    }
+    println!();
+}
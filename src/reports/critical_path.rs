@@ -0,0 +1,350 @@
+//! Critical path estimates.
+
+use crate::classify::is_cache_hit;
+use crate::digests::DigestKey;
+use crate::format::{format_duration, format_seconds_change, section};
+use crate::metrics::{start_time, total_time};
+use crate::proto::SpawnExec;
+use std::collections::hash_map::Entry;
+use std::collections::HashMap;
+use std::time::Duration;
+
+/// A spawn's wall-clock interval, as offsets since the Unix epoch.
+struct Interval<'a> {
+    start: Duration,
+    end: Duration,
+    spawn: &'a SpawnExec,
+}
+
+/// Walks back from the spawn that finished last, each time stepping to the spawn that ended
+/// closest to (but not after) the current one's start, preferring longer spawns on ties.
+///
+/// `intervals` must be sorted by end time.
+fn covering_chain<'a, 'b>(intervals: &'b [Interval<'a>]) -> Vec<&'b Interval<'a>> {
+    let mut chain = Vec::new();
+    let Some(mut current) = intervals.iter().max_by_key(|i| (i.end, i.end - i.start)) else {
+        return chain;
+    };
+    loop {
+        chain.push(current);
+        // Intervals ending at or before the current start form a prefix of the sorted list.
+        let count = intervals.partition_point(|i| i.end <= current.start);
+        let Some(latest_end) = intervals[..count].last().map(|i| i.end) else {
+            break;
+        };
+        let first = intervals[..count].partition_point(|i| i.end < latest_end);
+        current = intervals[first..count]
+            .iter()
+            .max_by_key(|i| i.end - i.start)
+            .expect("non-empty range");
+    }
+    chain.reverse();
+    chain
+}
+
+/// Prints the chain of non-overlapping spawns that spans the build's wall-clock time.
+///
+/// This is an estimate from timestamps alone: the chain is the sequence that was running
+/// when the build was busiest toward its end, not a proof of dependencies between them.
+pub fn print_critical_path_report(spawns: &[SpawnExec]) {
+    println!("{}", section("Critical Path (Estimated From Timestamps)"));
+
+    let mut intervals: Vec<Interval> = spawns
+        .iter()
+        .filter_map(|spawn| {
+            let start = start_time(spawn)?;
+            Some(Interval {
+                start,
+                end: start + total_time(spawn),
+                spawn,
+            })
+        })
+        .collect();
+    if intervals.is_empty() {
+        println!("No spawn start times found in the log. Start times are recorded by recent Bazel versions in logs written with --execution_log_compact_file or --execution_log_binary_file; older versions omit them.");
+        println!();
+        return;
+    }
+    intervals.sort_by_key(|i| i.end);
+
+    let chain = covering_chain(&intervals);
+    let build_start = intervals.iter().map(|i| i.start).min().unwrap_or_default();
+    let build_end = chain.last().map_or(build_start, |i| i.end);
+    let busy: Duration = chain.iter().map(|i| i.end - i.start).sum();
+    let wall = build_end - build_start;
+    println!(
+        "{} actions, {} of spawn time over {} of wall-clock time ({} in gaps)",
+        chain.len(),
+        format_duration(busy, 2),
+        format_duration(wall, 2),
+        format_duration(wall.saturating_sub(busy), 2));
+    let missing = spawns.len() - intervals.len();
+    if missing > 0 {
+        println!("Note: {} spawns without a start time are not considered.", missing);
+    }
+
+    let mut previous_end = build_start;
+    for (index, interval) in chain.iter().enumerate() {
+        let gap = interval.start.saturating_sub(previous_end);
+        if !gap.is_zero() {
+            println!("      └ gap {}", format_duration(gap, 3));
+        }
+        println!(
+            "  {:>2}. {:>10} {:>10} | {} | {}",
+            index + 1,
+            format_seconds_change((interval.start - build_start).as_secs_f64(), 3),
+            format_duration(interval.end - interval.start, 3),
+            interval.spawn.mnemonic,
+            interval.spawn.target_label
+        );
+        previous_end = interval.end;
+    }
+    println!();
+}
+
+/// Builds, for every spawn, the spawns that produced one of its inputs.
+///
+/// Producers are indexed by output digest (`DigestKey` to spawn index, roughly 50 bytes per
+/// unique output); inputs are only streamed, so memory stays proportional to the outputs of
+/// the log rather than its much larger input lists. Empty files are skipped, as their digest
+/// is shared by unrelated files. Returns the edges and the number of digests with several
+/// producers, of which the first one in the log is used.
+fn producer_edges(spawns: &[SpawnExec]) -> (Vec<Vec<u32>>, usize) {
+    let mut producers: HashMap<DigestKey, u32> = HashMap::new();
+    let mut ambiguous = 0;
+    for (index, spawn) in spawns.iter().enumerate() {
+        for digest in spawn.actual_outputs.iter().filter_map(|f| f.digest.as_ref()) {
+            if digest.size_bytes == 0 {
+                continue;
+            }
+            match producers.entry(DigestKey::new(digest)) {
+                Entry::Vacant(entry) => {
+                    entry.insert(index as u32);
+                }
+                Entry::Occupied(entry) => {
+                    if *entry.get() != index as u32 {
+                        ambiguous += 1;
+                    }
+                }
+            }
+        }
+    }
+
+    let edges = spawns
+        .iter()
+        .enumerate()
+        .map(|(index, spawn)| {
+            let mut preds: Vec<u32> = spawn
+                .inputs
+                .iter()
+                .filter_map(|f| f.digest.as_ref())
+                .filter_map(|d| producers.get(&DigestKey::new(d)).copied())
+                .filter(|&p| p != index as u32)
+                .collect();
+            preds.sort_unstable();
+            preds.dedup();
+            preds
+        })
+        .collect();
+    (edges, ambiguous)
+}
+
+/// Longest path by spawn duration over the producer edges.
+///
+/// Returns the path from its first to its last spawn, and how many edges were dropped to
+/// break cycles (which overlapping outputs can create).
+fn longest_path(spawns: &[SpawnExec], preds: &[Vec<u32>]) -> (Vec<usize>, usize) {
+    const UNVISITED: u8 = 0;
+    const IN_PROGRESS: u8 = 1;
+    const DONE: u8 = 2;
+
+    let mut state = vec![UNVISITED; spawns.len()];
+    let mut dist = vec![Duration::ZERO; spawns.len()];
+    let mut best_pred: Vec<Option<usize>> = vec![None; spawns.len()];
+    let mut broken_edges = 0;
+
+    // Iterative post-order DFS over predecessors, so deep chains cannot overflow the stack.
+    for root in 0..spawns.len() {
+        if state[root] != UNVISITED {
+            continue;
+        }
+        let mut stack: Vec<(usize, usize)> = vec![(root, 0)];
+        state[root] = IN_PROGRESS;
+        while let Some(&mut (node, ref mut next)) = stack.last_mut() {
+            if let Some(&pred) = preds[node].get(*next) {
+                *next += 1;
+                let pred = pred as usize;
+                match state[pred] {
+                    UNVISITED => {
+                        state[pred] = IN_PROGRESS;
+                        stack.push((pred, 0));
+                    }
+                    IN_PROGRESS => broken_edges += 1,
+                    _ => {}
+                }
+                continue;
+            }
+            stack.pop();
+            let mut best = Duration::ZERO;
+            for &pred in &preds[node] {
+                let pred = pred as usize;
+                // Edges into a node still in progress closed a cycle and were dropped.
+                if state[pred] == DONE && (best_pred[node].is_none() || dist[pred] > best) {
+                    best = dist[pred];
+                    best_pred[node] = Some(pred);
+                }
+            }
+            dist[node] = best + total_time(&spawns[node]);
+            state[node] = DONE;
+        }
+    }
+
+    let Some(end) = (0..spawns.len()).max_by_key(|&i| dist[i]) else {
+        return (Vec::new(), broken_edges);
+    };
+    let mut path = vec![end];
+    while let Some(pred) = best_pred[*path.last().unwrap()] {
+        path.push(pred);
+    }
+    path.reverse();
+    (path, broken_edges)
+}
+
+/// Prints the longest chain of spawns connected by outputs consumed as inputs, weighted by
+/// duration: the part of the build that remains even with unlimited parallelism.
+pub fn print_dependency_critical_path_report(spawns: &[SpawnExec]) {
+    println!("{}", section("Critical Path (From Input/Output Dependencies)"));
+
+    if spawns.iter().all(|s| s.inputs.is_empty()) {
+        println!("No spawn inputs found in the log, so no dependencies can be reconstructed.");
+        println!();
+        return;
+    }
+    let (preds, ambiguous) = producer_edges(spawns);
+    let edges: usize = preds.iter().map(|p| p.len()).sum();
+    let (path, broken_edges) = longest_path(spawns, &preds);
+
+    let total: Duration = path.iter().map(|&i| total_time(&spawns[i])).sum();
+    println!(
+        "{} actions, {} of spawn time ({} dependency edges between {} spawns)",
+        path.len(),
+        format_duration(total, 2),
+        edges,
+        spawns.len()
+    );
+    if broken_edges > 0 {
+        println!(
+            "WARNING: {} edges were dropped to break dependency cycles (usually overlapping outputs).",
+            broken_edges
+        );
+    }
+    if ambiguous > 0 {
+        println!(
+            "Note: {} output digests are produced by more than one spawn; the first producer is used.",
+            ambiguous
+        );
+    }
+
+    let mut cumulative = Duration::ZERO;
+    for (index, &i) in path.iter().enumerate() {
+        let spawn = &spawns[i];
+        let duration = total_time(spawn);
+        cumulative += duration;
+        let hit = if is_cache_hit(spawn) { " [cache hit]" } else { "" };
+        println!(
+            "  {:>2}. {:>10} (cumulative {:>10}) | {} | {}{}",
+            index + 1,
+            format_duration(duration, 3),
+            format_duration(cumulative, 3),
+            spawn.mnemonic,
+            spawn.target_label,
+            hit
+        );
+    }
+    if path.iter().any(|&i| is_cache_hit(&spawns[i])) {
+        println!("Note: Cache hits on the path are shortened by faster fetches, not faster execution.");
+    }
+    println!();
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::proto::{Digest, File};
+    use crate::testing::spawn;
+
+    fn file(name: &str, size_bytes: i64) -> File {
+        File {
+            path: name.to_string(),
+            digest: Some(Digest {
+                hash: format!("{:0>64}", name),
+                size_bytes,
+                ..Default::default()
+            }),
+            ..Default::default()
+        }
+    }
+
+    /// A spawn taking `millis` that reads `inputs` and writes `outputs`, each a 1-byte file.
+    fn step(millis: u64, inputs: &[&str], outputs: &[&str]) -> SpawnExec {
+        SpawnExec {
+            inputs: inputs.iter().map(|name| file(name, 1)).collect(),
+            actual_outputs: outputs.iter().map(|name| file(name, 1)).collect(),
+            ..spawn("Genrule", "linux-sandbox", millis)
+        }
+    }
+
+    fn path(spawns: &[SpawnExec]) -> (Vec<usize>, usize) {
+        let (preds, _) = producer_edges(spawns);
+        longest_path(spawns, &preds)
+    }
+
+    #[test]
+    fn the_longer_branch_of_a_diamond_is_the_path() {
+        let log = [
+            step(100, &[], &["a"]),
+            step(500, &["a"], &["b"]),
+            step(200, &["a"], &["c"]),
+            step(100, &["b", "c"], &["d"]),
+        ];
+        assert_eq!(path(&log), (vec![0, 1, 3], 0));
+    }
+
+    #[test]
+    fn cycles_are_broken_and_counted() {
+        let log = [
+            step(100, &["b"], &["a"]),
+            step(200, &["a"], &["b"]),
+        ];
+        let (path, broken_edges) = path(&log);
+        assert_eq!(broken_edges, 1);
+        assert_eq!(path.len(), 2);
+    }
+
+    #[test]
+    fn empty_files_and_duplicate_producers_add_no_edges() {
+        let mut log = vec![step(100, &[], &["a"]), step(100, &[], &["a"])];
+        log.push(SpawnExec {
+            inputs: vec![file("a", 1), file("e", 0)],
+            actual_outputs: vec![file("e", 0)],
+            ..spawn("Genrule", "local", 100)
+        });
+        let (preds, ambiguous) = producer_edges(&log);
+        assert_eq!(preds, [vec![], vec![], vec![0]]);
+        assert_eq!(ambiguous, 1);
+    }
+
+    #[test]
+    fn a_long_generated_chain_does_not_overflow_the_stack() {
+        let names: Vec<String> = (0..200_000).map(|i| format!("{:x}", i)).collect();
+        let log: Vec<SpawnExec> = (0..names.len())
+            .map(|i| match i {
+                0 => step(1, &[], &[&names[0]]),
+                _ => step(1, &[&names[i - 1]], &[&names[i]]),
+            })
+            .collect();
+        let (path, broken_edges) = path(&log);
+        assert_eq!(path.len(), log.len());
+        assert_eq!(broken_edges, 0);
+    }
+}
@@ -0,0 +1,286 @@
+//! Spawn environments: the variables that differ between spawns of the same mnemonic, and
+//! how large the environments spawns carry are.
+
+use crate::format::{format_bytes, section, top_label, Align, Table};
+use crate::proto::SpawnExec;
+use std::collections::hash_map::DefaultHasher;
+use std::collections::{HashMap, HashSet};
+use std::hash::{Hash, Hasher};
+
+/// Example values longer than this are truncated.
+const MAX_EXAMPLE_LEN: usize = 60;
+
+/// What is known about one variable across a mnemonic's spawns. Values are only kept as
+/// hashes, apart from the first two distinct ones which serve as the example.
+#[derive(Default)]
+struct VariableValues<'a> {
+    hashes: HashSet<u64>,
+    examples: Vec<&'a str>,
+    spawns: u64,
+}
+
+fn value_hash(value: &str) -> u64 {
+    let mut hasher = DefaultHasher::new();
+    value.hash(&mut hasher);
+    hasher.finish()
+}
+
+fn example(value: &str) -> String {
+    if value.chars().count() > MAX_EXAMPLE_LEN {
+        let prefix: String = value.chars().take(MAX_EXAMPLE_LEN).collect();
+        format!("{:?}...", prefix)
+    } else {
+        format!("{:?}", value)
+    }
+}
+
+/// A variable whose value is not constant across a mnemonic's spawns.
+struct Variance<'a> {
+    mnemonic: &'a str,
+    name: &'a str,
+    distinct: usize,
+    /// Spawns of the mnemonic that do not set the variable at all.
+    unset: u64,
+    examples: Vec<&'a str>,
+}
+
+fn env_variance<'a>(spawns: &'a [SpawnExec], ignore: &[String]) -> Vec<Variance<'a>> {
+    let mut counts: HashMap<&str, u64> = HashMap::new();
+    let mut variables: HashMap<(&str, &str), VariableValues> = HashMap::new();
+    for spawn in spawns {
+        *counts.entry(spawn.mnemonic.as_str()).or_default() += 1;
+        for variable in &spawn.environment_variables {
+            if ignore.iter().any(|name| *name == variable.name) {
+                continue;
+            }
+            let values = variables
+                .entry((spawn.mnemonic.as_str(), variable.name.as_str()))
+                .or_default();
+            values.spawns += 1;
+            if values.hashes.insert(value_hash(&variable.value)) && values.examples.len() < 2 {
+                values.examples.push(&variable.value);
+            }
+        }
+    }
+
+    let mut variances: Vec<Variance> = variables
+        .into_iter()
+        .filter_map(|((mnemonic, name), values)| {
+            let unset = counts[mnemonic] - values.spawns;
+            (values.hashes.len() > 1 || unset > 0).then(|| Variance {
+                mnemonic,
+                name,
+                distinct: values.hashes.len(),
+                unset,
+                examples: values.examples,
+            })
+        })
+        .collect();
+    variances.sort_by(|a, b| {
+        b.distinct
+            .cmp(&a.distinct)
+            .then(b.unset.cmp(&a.unset))
+            .then(a.mnemonic.cmp(b.mnemonic))
+            .then(a.name.cmp(b.name))
+    });
+    variances
+}
+
+/// Prints, per mnemonic, the environment variables whose values differ between its spawns,
+/// a common reason for cache misses.
+pub fn print_env_variance_report(spawns: &[SpawnExec], ignore: &[String], top_n: usize) {
+    println!("{}", section("Environment Variance by Mnemonic"));
+
+    let variances = env_variance(spawns, ignore);
+    if variances.is_empty() {
+        println!("Every mnemonic runs with the same environment in all of its spawns.");
+        println!();
+        return;
+    }
+
+    let shown = variances.len().min(top_n);
+    let mut table = Table::new(vec![
+        ("Mnemonic".to_string(), Align::Left),
+        ("Variable".to_string(), Align::Left),
+        ("Distinct Values".to_string(), Align::Right),
+        ("Unset In".to_string(), Align::Right),
+        ("Example".to_string(), Align::Left),
+    ]);
+    for variance in &variances[..shown] {
+        let pair = match variance.examples.as_slice() {
+            [first, second, ..] => format!("{} vs {}", example(first), example(second)),
+            [only] => format!("{} vs unset", example(only)),
+            [] => String::new(),
+        };
+        table.add_row(vec![
+            variance.mnemonic.to_string(),
+            variance.name.to_string(),
+            variance.distinct.to_string(),
+            variance.unset.to_string(),
+            pair,
+        ]);
+    }
+    table.print();
+    if variances.len() > shown {
+        println!("... (+{} more variables)", variances.len() - shown);
+    }
+    println!("Note: Variables expected to vary can be left out with --env-variance-ignore.");
+    println!();
+}
+
+/// How large the environments of one mnemonic's spawns are, in bytes and variables.
+#[derive(Default)]
+struct EnvSize {
+    spawns: u64,
+    bytes: u64,
+    max_bytes: u64,
+    variables: u64,
+    max_variables: u64,
+}
+
+impl EnvSize {
+    fn average_bytes(&self) -> u64 {
+        self.bytes / self.spawns.max(1)
+    }
+}
+
+/// One variable name across all spawns. Entries are keyed by the hash of the name, and the
+/// name is borrowed from the first spawn that sets it, so the totals take the same memory
+/// however many spawns there are.
+struct VariableSize<'a> {
+    name: &'a str,
+    spawns: u64,
+    bytes: u64,
+}
+
+/// Bytes a variable takes in a process environment: `NAME=VALUE` and its terminating NUL.
+fn variable_bytes(name: &str, value: &str) -> u64 {
+    (name.len() + value.len() + 2) as u64
+}
+
+pub fn print_env_size_report(spawns: &[SpawnExec], threshold: u64, top_n: usize) {
+    println!("{}", section("Environment Size by Mnemonic"));
+
+    let mut overall = EnvSize::default();
+    let mut by_mnemonic: HashMap<&str, EnvSize> = HashMap::new();
+    let mut variables: HashMap<u64, VariableSize> = HashMap::new();
+    for spawn in spawns {
+        let bytes: u64 = spawn
+            .environment_variables
+            .iter()
+            .map(|variable| variable_bytes(&variable.name, &variable.value))
+            .sum();
+        let count = spawn.environment_variables.len() as u64;
+        for size in [
+            &mut overall,
+            by_mnemonic.entry(spawn.mnemonic.as_str()).or_default(),
+        ] {
+            size.spawns += 1;
+            size.bytes += bytes;
+            size.max_bytes = size.max_bytes.max(bytes);
+            size.variables += count;
+            size.max_variables = size.max_variables.max(count);
+        }
+        for variable in &spawn.environment_variables {
+            let totals = variables
+                .entry(value_hash(&variable.name))
+                .or_insert(VariableSize {
+                    name: &variable.name,
+                    spawns: 0,
+                    bytes: 0,
+                });
+            totals.spawns += 1;
+            totals.bytes += variable_bytes(&variable.name, &variable.value);
+        }
+    }
+    if overall.variables == 0 {
+        println!("No spawn in the log records environment variables.");
+        println!();
+        return;
+    }
+    println!(
+        "Spawns carry {} of environment on average ({:.1} variables), {} in all; the largest is {}.",
+        format_bytes(overall.average_bytes() as i64),
+        overall.variables as f64 / overall.spawns as f64,
+        format_bytes(overall.bytes as i64),
+        format_bytes(overall.max_bytes as i64)
+    );
+
+    let mut rows: Vec<(&str, EnvSize)> = by_mnemonic.into_iter().collect();
+    rows.sort_by(|a, b| {
+        b.1.average_bytes()
+            .cmp(&a.1.average_bytes())
+            .then(a.0.cmp(b.0))
+    });
+    let over: Vec<&str> = rows
+        .iter()
+        .filter(|(_, size)| size.average_bytes() > threshold)
+        .map(|(mnemonic, _)| *mnemonic)
+        .collect();
+    let shown = rows.len().min(top_n);
+    let mut table = Table::new(vec![
+        ("Mnemonic".to_string(), Align::Left),
+        ("Spawns".to_string(), Align::Right),
+        ("Avg Size".to_string(), Align::Right),
+        ("Max Size".to_string(), Align::Right),
+        ("Avg Vars".to_string(), Align::Right),
+        ("Max Vars".to_string(), Align::Right),
+        ("Total Size".to_string(), Align::Right),
+    ]);
+    for (mnemonic, size) in &rows[..shown] {
+        table.add_row(vec![
+            mnemonic.to_string(),
+            size.spawns.to_string(),
+            format_bytes(size.average_bytes() as i64),
+            format_bytes(size.max_bytes as i64),
+            format!("{:.1}", size.variables as f64 / size.spawns as f64),
+            size.max_variables.to_string(),
+            format_bytes(size.bytes as i64),
+        ]);
+    }
+    println!();
+    table.print();
+    if rows.len() > shown {
+        println!("... (+{} more mnemonics)", rows.len() - shown);
+    }
+    if over.is_empty() {
+        println!(
+            "No mnemonic passes more than {} of environment per spawn on average (--env-size-threshold).",
+            format_bytes(threshold as i64)
+        );
+    } else {
+        println!(
+            "Environments above {} per spawn on average (--env-size-threshold): {}",
+            format_bytes(threshold as i64),
+            over.join(", ")
+        );
+    }
+
+    let mut variables: Vec<VariableSize> = variables.into_values().collect();
+    variables.sort_by(|a, b| b.bytes.cmp(&a.bytes).then(a.name.cmp(b.name)));
+    let shown = variables.len().min(top_n);
+    println!();
+    println!("{} Variables by Total Size:", top_label(top_n));
+    let mut table = Table::new(vec![
+        ("Variable".to_string(), Align::Left),
+        ("Spawns".to_string(), Align::Right),
+        ("Avg Size".to_string(), Align::Right),
+        ("Total Size".to_string(), Align::Right),
+        ("% of Total".to_string(), Align::Right),
+    ]);
+    for variable in &variables[..shown] {
+        table.add_row(vec![
+            variable.name.to_string(),
+            variable.spawns.to_string(),
+            format_bytes((variable.bytes / variable.spawns) as i64),
+            format_bytes(variable.bytes as i64),
+            format!("{:.1}%", variable.bytes as f64 / overall.bytes as f64 * 100.0),
+        ]);
+    }
+    table.print();
+    if variables.len() > shown {
+        println!("... (+{} more variables)", variables.len() - shown);
+    }
+    println!("Note: Sizes count each variable as NAME=VALUE plus its terminator. Large environments are repeated in every action key and remote execution request.");
+    println!();
+}
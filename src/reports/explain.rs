@@ -0,0 +1,262 @@
+//! --explain: every spawn of one target in the order they ran, with where the time of each went
+//! and a summary of the target as a whole.
+
+use crate::classify::{classify_runner, is_cache_hit, is_failed, runner_label, RunnerKind};
+use crate::cli::LabelPattern;
+use crate::format::{format_bytes, format_duration, section, Align, Table};
+use crate::metrics::{
+    output_bytes, phase_duration, recorded_total_time, start_time, Phase, PhaseTotals,
+};
+use crate::proto::SpawnExec;
+use crate::reports::grouping::label_pattern_matches;
+use std::collections::BTreeSet;
+use std::time::Duration;
+
+/// The spawns of the targets an --explain pattern matches.
+pub struct Explanation<'a> {
+    /// The labels the pattern matched, sorted.
+    pub targets: Vec<&'a str>,
+    /// In start order; spawns without a start time come last, in log order.
+    pub spawns: Vec<&'a SpawnExec>,
+    /// TestRunner spawns of the targets, left out without --explain-shards.
+    pub tests_left_out: usize,
+    /// The earliest start time among `spawns`.
+    pub first_start: Option<Duration>,
+    pub phases: PhaseTotals,
+    /// Summed total time of `spawns`.
+    pub total: Duration,
+    /// The part of `total` spent on cache hits.
+    pub cache_fetched: Duration,
+}
+
+impl Explanation<'_> {
+    /// The part of the total time spent on spawns that were not cache hits.
+    pub fn executed(&self) -> Duration {
+        self.total.saturating_sub(self.cache_fetched)
+    }
+
+    /// The phase with the most summed time, if any spawn recorded one.
+    pub fn slowest_phase(&self) -> Option<(Phase, Duration)> {
+        self.phases
+            .recorded_phases()
+            .map(|phase| (phase, self.phases.sum(phase)))
+            .max_by_key(|&(_, duration)| duration)
+    }
+
+    /// Whether the pattern matched more than one target.
+    pub fn is_many(&self) -> bool {
+        self.targets.len() > 1
+    }
+}
+
+/// Collects the spawns whose label `pattern` matches. TestRunner spawns, one per test shard and
+/// run, are only included with `shards`.
+pub fn explain<'a>(
+    spawns: &'a [SpawnExec],
+    pattern: &LabelPattern,
+    shards: bool,
+) -> Explanation<'a> {
+    let mut targets = BTreeSet::new();
+    let mut explained = Vec::new();
+    let mut tests_left_out = 0;
+    for spawn in spawns {
+        if !label_pattern_matches(pattern, &spawn.target_label) {
+            continue;
+        }
+        targets.insert(spawn.target_label.as_str());
+        if spawn.mnemonic == "TestRunner" && !shards {
+            tests_left_out += 1;
+        } else {
+            explained.push(spawn);
+        }
+    }
+    explained.sort_by_key(|&spawn| (start_time(spawn).is_none(), start_time(spawn)));
+
+    let mut phases = PhaseTotals::default();
+    let (mut total, mut cache_fetched) = (Duration::ZERO, Duration::ZERO);
+    for &spawn in &explained {
+        phases.add(spawn);
+        let time = recorded_total_time(spawn).unwrap_or_default();
+        total += time;
+        if is_cache_hit(spawn) {
+            cache_fetched += time;
+        }
+    }
+    Explanation {
+        targets: targets.into_iter().collect(),
+        first_start: explained.iter().filter_map(|&spawn| start_time(spawn)).min(),
+        spawns: explained,
+        tests_left_out,
+        phases,
+        total,
+        cache_fetched,
+    }
+}
+
+/// How the spawn's outputs were produced: the kind of cache hit, or whether it could have been
+/// one.
+pub fn cache_status(spawn: &SpawnExec) -> &'static str {
+    if is_cache_hit(spawn) {
+        match classify_runner(&spawn.runner) {
+            kind @ (RunnerKind::RemoteCacheHit | RunnerKind::DiskCacheHit) => kind.name(),
+            _ => "cache hit",
+        }
+    } else if spawn.cacheable {
+        "miss"
+    } else {
+        "not cacheable"
+    }
+}
+
+/// The test shard and run of a TestRunner spawn, e.g. `shard 2/4`, from the `shard_2_of_4` and
+/// `run_1_of_3` directories of its test log.
+pub fn test_shard(spawn: &SpawnExec) -> Option<String> {
+    let component = |prefix: &str| {
+        spawn.listed_outputs.iter().find_map(|path| {
+            path.split('/').find_map(|dir| {
+                let (index, count) = dir.strip_prefix(prefix)?.split_once("_of_")?;
+                index.parse::<u32>().ok()?;
+                count.parse::<u32>().ok()?;
+                Some(format!("{}/{}", index, count))
+            })
+        })
+    };
+    let parts: Vec<String> = [("shard", component("shard_")), ("run", component("run_"))]
+        .into_iter()
+        .filter_map(|(name, value)| Some(format!("{} {}", name, value?)))
+        .collect();
+    (!parts.is_empty()).then(|| parts.join(", "))
+}
+
+fn percent(part: Duration, whole: Duration) -> f64 {
+    if whole.is_zero() {
+        0.0
+    } else {
+        100.0 * part.as_secs_f64() / whole.as_secs_f64()
+    }
+}
+
+pub fn print_explain_report(spawns: &[SpawnExec], pattern: &LabelPattern, shards: bool) {
+    let explanation = explain(spawns, pattern, shards);
+    println!("{}", section(format_args!("Explain {}", pattern.text)));
+    if explanation.spawns.is_empty() {
+        println!(
+            "Only TestRunner spawns match {}; --explain-shards lists them.",
+            pattern.text
+        );
+        println!();
+        return;
+    }
+
+    let shard_names: Vec<Option<String>> =
+        explanation.spawns.iter().map(|&spawn| test_shard(spawn)).collect();
+    let with_shards = shard_names.iter().any(Option::is_some);
+    let mut columns = vec![
+        ("#".to_string(), Align::Right),
+        ("Start".to_string(), Align::Right),
+    ];
+    if explanation.is_many() {
+        columns.push(("Target".to_string(), Align::Left));
+    }
+    columns.extend([
+        ("Mnemonic".to_string(), Align::Left),
+        ("Runner".to_string(), Align::Left),
+        ("Cache".to_string(), Align::Left),
+        ("Duration".to_string(), Align::Right),
+        ("Inputs".to_string(), Align::Right),
+        ("Output Bytes".to_string(), Align::Right),
+    ]);
+    if with_shards {
+        columns.push(("Shard".to_string(), Align::Left));
+    }
+    let mut table = Table::new(columns);
+    for (index, (&spawn, shard)) in explanation.spawns.iter().zip(&shard_names).enumerate() {
+        let start = match (start_time(spawn), explanation.first_start) {
+            (Some(start), Some(first)) => format!("+{}", format_duration(start - first, 3)),
+            _ => "-".to_string(),
+        };
+        let mut row = vec![(index + 1).to_string(), start];
+        if explanation.is_many() {
+            row.push(spawn.target_label.clone());
+        }
+        row.extend([
+            spawn.mnemonic.clone(),
+            runner_label(spawn).to_string(),
+            if is_failed(spawn) {
+                format!("{} (failed)", cache_status(spawn))
+            } else {
+                cache_status(spawn).to_string()
+            },
+            recorded_total_time(spawn).map_or("-".to_string(), |time| format_duration(time, 3)),
+            spawn.inputs.len().to_string(),
+            format_bytes(output_bytes(spawn)),
+        ]);
+        if with_shards {
+            row.push(shard.clone().unwrap_or_default());
+        }
+        table.add_row(row);
+    }
+    table.print();
+
+    let recorded: Vec<Phase> = explanation.phases.recorded_phases().collect();
+    if !recorded.is_empty() {
+        println!();
+        println!("Phases:");
+        let mut columns = vec![("#".to_string(), Align::Right)];
+        columns.extend(recorded.iter().map(|phase| (phase.name().to_string(), Align::Right)));
+        let mut table = Table::new(columns);
+        for (index, &spawn) in explanation.spawns.iter().enumerate() {
+            let mut row = vec![(index + 1).to_string()];
+            row.extend(recorded.iter().map(|&phase| {
+                spawn
+                    .metrics
+                    .as_ref()
+                    .and_then(|metrics| phase_duration(metrics, phase))
+                    .map_or("-".to_string(), |time| format_duration(time, 3))
+            }));
+            table.add_row(row);
+        }
+        table.print();
+    }
+
+    println!();
+    let hits = explanation.spawns.iter().filter(|&&spawn| is_cache_hit(spawn)).count();
+    let failed = explanation.spawns.iter().filter(|&&spawn| is_failed(spawn)).count();
+    println!(
+        "Spawns: {} ({} cache hit{}, {} executed){}",
+        explanation.spawns.len(),
+        hits,
+        if hits == 1 { "" } else { "s" },
+        explanation.spawns.len() - hits,
+        if failed > 0 {
+            format!(", {} failed", failed)
+        } else {
+            String::new()
+        }
+    );
+    println!(
+        "Total time: {}; cache-fetched {} ({:.1}%), executed {} ({:.1}%)",
+        format_duration(explanation.total, 3),
+        format_duration(explanation.cache_fetched, 3),
+        percent(explanation.cache_fetched, explanation.total),
+        format_duration(explanation.executed(), 3),
+        percent(explanation.executed(), explanation.total)
+    );
+    if let Some((phase, time)) = explanation.slowest_phase() {
+        let phase_time: Duration = recorded.iter().map(|&p| explanation.phases.sum(p)).sum();
+        println!(
+            "Slowest phase: {}, {} ({:.1}% of the recorded phase time)",
+            phase.name(),
+            format_duration(time, 3),
+            percent(time, phase_time)
+        );
+    }
+    if explanation.tests_left_out > 0 {
+        println!(
+            "Note: {} TestRunner spawn{} (test shards and runs) left out; --explain-shards lists them.",
+            explanation.tests_left_out,
+            if explanation.tests_left_out == 1 { "" } else { "s" }
+        );
+    }
+    println!();
+}
@@ -0,0 +1,410 @@
+//! Failed spawns and their exit codes.
+
+use crate::classify::{
+    classify_runner, has_unknown_status, is_failed, is_timeout, runner_label, RunnerKind,
+};
+use crate::cli::{AllowanceField, FailureAllowance};
+use crate::dedupe::DuplicateGroup;
+use crate::filter::wildcard_match;
+use crate::format::{format_duration, section, Align, Table};
+use crate::metrics::{phase_duration, to_std_duration, total_time, Phase};
+use crate::proto::SpawnExec;
+use crate::report::highest_first;
+use std::collections::{BTreeMap, HashMap};
+use std::time::Duration;
+
+/// Mnemonics named per exit code before the rest are summarized.
+const MAX_MNEMONICS_PER_CODE: usize = 3;
+
+/// Conventional meaning of common exit codes, if there is one.
+pub fn exit_code_meaning(code: i32) -> Option<&'static str> {
+    match code {
+        0 => Some("error status without exit code"),
+        124 => Some("timeout (coreutils convention)"),
+        126 => Some("command not executable"),
+        127 => Some("command not found"),
+        134 => Some("SIGABRT"),
+        137 => Some("SIGKILL, often the OOM killer"),
+        139 => Some("SIGSEGV"),
+        142 => Some("SIGALRM, Bazel's local timeout"),
+        143 => Some("SIGTERM"),
+        _ => None,
+    }
+}
+
+#[derive(Default)]
+struct ExitCodeStats<'a> {
+    spawns: u64,
+    time: Duration,
+    mnemonics: HashMap<&'a str, u64>,
+}
+
+pub fn print_exit_code_report(spawns: &[SpawnExec]) {
+    let mut codes: BTreeMap<i32, ExitCodeStats> = BTreeMap::new();
+    for spawn in spawns.iter().filter(|s| is_failed(s)) {
+        let stats = codes.entry(spawn.exit_code).or_default();
+        stats.spawns += 1;
+        stats.time += total_time(spawn);
+        *stats.mnemonics.entry(spawn.mnemonic.as_str()).or_default() += 1;
+    }
+
+    // Keep the default report short for successful builds.
+    if codes.is_empty() {
+        println!("All actions succeeded (every exit code is 0).");
+        println!();
+        return;
+    }
+
+    println!("{}", section("Exit Codes"));
+    let failed: u64 = codes.values().map(|s| s.spawns).sum();
+    let failed_time: Duration = codes.values().map(|s| s.time).sum();
+    let total: Duration = spawns.iter().map(total_time).sum();
+    let share = if total.is_zero() {
+        0.0
+    } else {
+        failed_time.as_secs_f64() / total.as_secs_f64() * 100.0
+    };
+    println!(
+        "{} failing spawns spent {} ({:.1}% of total time)",
+        failed,
+        format_duration(failed_time, 2),
+        share
+    );
+
+    let mut table = Table::new(vec![
+        ("Exit Code".to_string(), Align::Right),
+        ("Spawns".to_string(), Align::Right),
+        ("Time".to_string(), Align::Right),
+        ("Meaning".to_string(), Align::Left),
+        ("Mnemonics".to_string(), Align::Left),
+    ]);
+    for (code, stats) in &codes {
+        let mut mnemonics: Vec<_> = stats.mnemonics.iter().collect();
+        mnemonics.sort_by(|a, b| b.1.cmp(a.1).then(a.0.cmp(b.0)));
+        let mut names: Vec<String> = mnemonics
+            .iter()
+            .take(MAX_MNEMONICS_PER_CODE)
+            .map(|(name, count)| format!("{} ({})", name, count))
+            .collect();
+        if mnemonics.len() > MAX_MNEMONICS_PER_CODE {
+            names.push(format!("+{} more", mnemonics.len() - MAX_MNEMONICS_PER_CODE));
+        }
+        table.add_row(vec![
+            code.to_string(),
+            stats.spawns.to_string(),
+            format_duration(stats.time, 2),
+            exit_code_meaning(*code).unwrap_or("").to_string(),
+            names.join(", "),
+        ]);
+    }
+    table.print();
+    let unknown = spawns.iter().filter(|s| has_unknown_status(s)).count();
+    if unknown > 0 {
+        println!(
+            "Note: {} of them record a status this version does not know; they are counted under their exit codes.",
+            unknown
+        );
+    }
+    println!();
+}
+
+/// Timeout accounting for one mnemonic.
+#[derive(Default)]
+struct TimeoutStats {
+    timed_out: u64,
+    /// Spawns that recorded their configured timeout.
+    with_timeout: u64,
+    /// Largest configured timeout seen.
+    timeout: Duration,
+    /// The spawn that came closest to its timeout without exceeding it, as (elapsed, limit).
+    closest: Option<(Duration, Duration)>,
+}
+
+impl TimeoutStats {
+    fn closest_fraction(&self) -> Option<f64> {
+        self.closest
+            .map(|(elapsed, limit)| elapsed.as_secs_f64() / limit.as_secs_f64())
+    }
+}
+
+/// Time that counts against a spawn's timeout: execution time where recorded, else total time.
+fn timed_duration(spawn: &SpawnExec) -> Duration {
+    spawn
+        .metrics
+        .as_ref()
+        .and_then(|m| m.execution_wall_time.as_ref())
+        .map(to_std_duration)
+        .unwrap_or_else(|| total_time(spawn))
+}
+
+/// Timed-out spawns per mnemonic and how close the others get to their timeout, for the
+/// mnemonics with a timeout or a timed-out spawn.
+fn timeout_stats(spawns: &[SpawnExec]) -> HashMap<&str, TimeoutStats> {
+    let mut by_mnemonic: HashMap<&str, TimeoutStats> = HashMap::new();
+    for spawn in spawns {
+        let timed_out = is_timeout(spawn);
+        if !timed_out && spawn.timeout_millis <= 0 {
+            continue;
+        }
+        let stats = by_mnemonic.entry(spawn.mnemonic.as_str()).or_default();
+        if timed_out {
+            stats.timed_out += 1;
+        }
+        if spawn.timeout_millis <= 0 {
+            continue;
+        }
+        let limit = Duration::from_millis(spawn.timeout_millis as u64);
+        stats.with_timeout += 1;
+        stats.timeout = stats.timeout.max(limit);
+        if !timed_out {
+            let elapsed = timed_duration(spawn);
+            let closer = stats.closest.is_none_or(|(e, l)| {
+                elapsed.as_secs_f64() / limit.as_secs_f64() > e.as_secs_f64() / l.as_secs_f64()
+            });
+            if closer {
+                stats.closest = Some((elapsed, limit));
+            }
+        }
+    }
+    by_mnemonic
+}
+
+/// Prints timed-out spawns per mnemonic and how close the others get to their timeout.
+///
+/// Printed when `always` is set or when the log contains timeouts.
+pub fn print_timeout_report(spawns: &[SpawnExec], always: bool) {
+    let by_mnemonic = timeout_stats(spawns);
+    let timed_out: u64 = by_mnemonic.values().map(|s| s.timed_out).sum();
+    if !always && timed_out == 0 {
+        return;
+    }
+    println!("{}", section("Timeouts"));
+    if by_mnemonic.is_empty() {
+        println!("No timed-out spawns and no configured timeouts found in the log.");
+        println!();
+        return;
+    }
+    println!("{} spawns timed out", timed_out);
+
+    let mut rows: Vec<_> = by_mnemonic.into_iter().collect();
+    rows.sort_by(|(a_name, a), (b_name, b)| {
+        b.timed_out
+            .cmp(&a.timed_out)
+            .then_with(|| {
+                b.closest_fraction()
+                    .unwrap_or(0.0)
+                    .total_cmp(&a.closest_fraction().unwrap_or(0.0))
+            })
+            .then_with(|| a_name.cmp(b_name))
+    });
+    let mut table = Table::new(vec![
+        ("Mnemonic".to_string(), Align::Left),
+        ("Timed Out".to_string(), Align::Right),
+        ("With Timeout".to_string(), Align::Right),
+        ("Timeout".to_string(), Align::Right),
+        ("Closest".to_string(), Align::Right),
+        ("% of Timeout".to_string(), Align::Right),
+    ]);
+    for (mnemonic, stats) in rows {
+        let (closest, fraction) = match (stats.closest, stats.closest_fraction()) {
+            (Some((elapsed, _)), Some(fraction)) => (
+                format_duration(elapsed, 2),
+                format!("{:.1}%", fraction * 100.0),
+            ),
+            _ => ("n/a".to_string(), "n/a".to_string()),
+        };
+        let timeout = if stats.with_timeout > 0 {
+            format_duration(stats.timeout, 0)
+        } else {
+            "n/a".to_string()
+        };
+        table.add_row(vec![
+            mnemonic.to_string(),
+            stats.timed_out.to_string(),
+            stats.with_timeout.to_string(),
+            timeout,
+            closest,
+            fraction,
+        ]);
+    }
+    table.print();
+    println!("Note: Closest is the longest run of a spawn that did not time out, relative to its own timeout (execution time where recorded).");
+    println!();
+}
+
+/// Phases shown for each failed spawn, enough to tell a queueing failure from a fast crash.
+pub const FAILED_ACTION_PHASES: [Phase; 4] =
+    [Phase::Queue, Phase::Setup, Phase::Execution, Phase::Fetch];
+
+/// The failed spawns, longest first.
+pub fn failed_actions(spawns: &[SpawnExec]) -> Vec<&SpawnExec> {
+    let mut failed: Vec<&SpawnExec> = spawns.iter().filter(|s| is_failed(s)).collect();
+    failed.sort_by(|a, b| highest_first(a, b, total_time));
+    failed
+}
+
+/// Lists failed spawns, longest first, with their runner and where their time went.
+///
+/// Prints nothing when no spawn failed.
+pub fn print_failed_actions_report(spawns: &[SpawnExec], max: usize) {
+    let failed = failed_actions(spawns);
+    if failed.is_empty() {
+        return;
+    }
+
+    println!("{}", section("Failed Actions"));
+    let mut columns = vec![
+        ("Target".to_string(), Align::Left),
+        ("Mnemonic".to_string(), Align::Left),
+        ("Exit".to_string(), Align::Right),
+        ("Runner".to_string(), Align::Left),
+        ("Remote".to_string(), Align::Left),
+        ("Total".to_string(), Align::Right),
+    ];
+    for phase in FAILED_ACTION_PHASES {
+        columns.push((phase.name().to_string(), Align::Right));
+    }
+    let mut table = Table::new(columns);
+    for spawn in failed.iter().take(max) {
+        let label = if spawn.target_label.is_empty() {
+            "(no label)".to_string()
+        } else {
+            spawn.target_label.clone()
+        };
+        let exit = if is_timeout(spawn) {
+            format!("{} (timeout)", spawn.exit_code)
+        } else {
+            spawn.exit_code.to_string()
+        };
+        let remote = classify_runner(&spawn.runner) == RunnerKind::Remote;
+        let mut row = vec![
+            label,
+            spawn.mnemonic.clone(),
+            exit,
+            runner_label(spawn).to_string(),
+            if remote { "yes" } else { "no" }.to_string(),
+            format_duration(total_time(spawn), 3),
+        ];
+        for phase in FAILED_ACTION_PHASES {
+            let duration = spawn
+                .metrics
+                .as_ref()
+                .and_then(|m| phase_duration(m, phase));
+            row.push(duration.map_or("-".to_string(), |d| format_duration(d, 3)));
+        }
+        table.add_row(row);
+    }
+    table.print();
+    if failed.len() > max {
+        println!(
+            "... {} more failed actions not shown (raise --max-failed to see them)",
+            failed.len() - max
+        );
+    }
+    println!();
+}
+
+/// Counts the failed spawns that no --allow-failures entry matches.
+pub fn unexpected_failures(spawns: &[SpawnExec], allowed: &[FailureAllowance]) -> usize {
+    spawns
+        .iter()
+        .filter(|s| is_failed(s))
+        .filter(|s| {
+            !allowed.iter().any(|allowance| {
+                let value = match allowance.field {
+                    AllowanceField::Mnemonic => &s.mnemonic,
+                    AllowanceField::Label => &s.target_label,
+                };
+                wildcard_match(&allowance.pattern, value)
+            })
+        })
+        .count()
+}
+
+/// Prints the actions that were executed more than once and the time the repeats cost.
+pub fn print_duplicates_report(groups: &[DuplicateGroup], top_n: usize) {
+    println!("{}", section("Repeated Executions"));
+
+    if groups.is_empty() {
+        println!("No action was executed more than once.");
+        println!();
+        return;
+    }
+    let repeats: u64 = groups.iter().map(|g| g.executions - 1).sum();
+    let extra: Duration = groups.iter().map(|g| g.extra_time).sum();
+    println!(
+        "{} actions were executed more than once ({} extra executions, {} spent on repeats)",
+        groups.len(),
+        repeats,
+        format_duration(extra, 2));
+
+    let mut table = Table::new(vec![
+        ("Target".to_string(), Align::Left),
+        ("Mnemonic".to_string(), Align::Left),
+        ("Executions".to_string(), Align::Right),
+        ("Failed".to_string(), Align::Right),
+        ("Total Time".to_string(), Align::Right),
+        ("Extra Time".to_string(), Align::Right),
+    ]);
+    for group in groups.iter().take(top_n) {
+        table.add_row(vec![
+            if group.label.is_empty() {
+                "(no label)".to_string()
+            } else {
+                group.label.clone()
+            },
+            group.mnemonic.clone(),
+            group.executions.to_string(),
+            group.failed.to_string(),
+            format_duration(group.total_time, 3),
+            format_duration(group.extra_time, 3),
+        ]);
+    }
+    table.print();
+    if groups.len() > top_n {
+        println!("... (+{} more repeated actions)", groups.len() - top_n);
+    }
+    println!("Note: Extra time counts every execution but the last; use --dedupe to count each action once in the other tables.");
+    println!();
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::testing::spawn;
+
+    fn test(status: &str, timeout_secs: i64, secs: u64) -> SpawnExec {
+        SpawnExec {
+            status: status.to_string(),
+            exit_code: if status.is_empty() { 0 } else { 142 },
+            timeout_millis: timeout_secs * 1000,
+            ..spawn("TestRunner", "linux-sandbox", secs * 1000)
+        }
+    }
+
+    #[test]
+    fn timeouts_are_told_apart_from_slow_spawns() {
+        assert!(is_timeout(&test("TIMEOUT", 300, 300)));
+        assert!(is_timeout(&test("DEADLINE_EXCEEDED", 300, 300)));
+        assert!(!is_timeout(&test("NON_ZERO_EXIT", 300, 300)));
+        assert!(!is_timeout(&test("", 300, 299)));
+    }
+
+    #[test]
+    fn timeout_stats_count_timeouts_and_the_closest_other_spawn() {
+        let log = [
+            test("TIMEOUT", 300, 300),
+            test("", 300, 240),
+            test("", 60, 30),
+            test("", 0, 900),
+            spawn("Javac", "local", 1_000),
+        ];
+        let stats = timeout_stats(&log);
+        assert_eq!(stats.len(), 1);
+        let tests = &stats["TestRunner"];
+        assert_eq!(tests.timed_out, 1);
+        assert_eq!(tests.with_timeout, 3);
+        assert_eq!(tests.timeout, Duration::from_secs(300));
+        assert_eq!(tests.closest_fraction(), Some(0.8));
+    }
+}
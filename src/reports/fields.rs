@@ -0,0 +1,203 @@
+//! How many spawns recorded each field the reports read, for telling a log that lacks the
+//! data from a report that gets it wrong.
+
+use crate::execlog::Detail;
+use crate::format::{section, Align, Table};
+use crate::metrics::{metrics_coverage, start_time, MetricsCoverage};
+use crate::proto::SpawnExec;
+
+/// Share of spawns below which a key field is pointed out unasked.
+const SPARSE_FIELD_FRACTION: f64 = 0.05;
+
+/// A field of the spawns that the reports read.
+struct Field {
+    name: &'static str,
+    recorded: fn(&SpawnExec) -> bool,
+    /// Whether the spawn can have the field at all.
+    applies: fn(&SpawnExec) -> bool,
+    /// Fields most reports are empty without, pointed out when hardly any spawn has them.
+    key: bool,
+    /// Left out by [`Detail::Slim`].
+    slim_skips: bool,
+    /// What makes Bazel record the field.
+    hint: &'static str,
+}
+
+const METRICS_HINT: &str =
+    "Bazel 6 and 7 only record spawn metrics with --experimental_execution_log_spawn_metrics";
+
+const FIELDS: [Field; 8] = [
+    Field {
+        name: "Metrics",
+        recorded: |s| s.metrics.is_some(),
+        applies: |_| true,
+        key: true,
+        slim_skips: false,
+        hint: METRICS_HINT,
+    },
+    Field {
+        name: "Total time",
+        recorded: |s| metrics_coverage(s) != MetricsCoverage::Missing,
+        applies: |_| true,
+        key: true,
+        slim_skips: false,
+        hint: METRICS_HINT,
+    },
+    Field {
+        name: "Phase timings",
+        recorded: |s| metrics_coverage(s) == MetricsCoverage::Full,
+        applies: |_| true,
+        key: false,
+        slim_skips: false,
+        hint: METRICS_HINT,
+    },
+    Field {
+        name: "Start time",
+        recorded: |s| start_time(s).is_some(),
+        applies: |_| true,
+        key: true,
+        slim_skips: false,
+        hint: "start times are part of the spawn metrics, and are only recorded by Bazel 7 and later",
+    },
+    Field {
+        name: "Output digests",
+        recorded: |s| {
+            s.actual_outputs
+                .iter()
+                .any(|f| f.digest.as_ref().is_some_and(|d| !d.hash.is_empty()))
+        },
+        applies: |s| !s.actual_outputs.is_empty(),
+        key: true,
+        slim_skips: false,
+        hint: "Bazel records no digest for outputs it did not hash, usually those of failed spawns",
+    },
+    Field {
+        name: "Command args",
+        recorded: |s| !s.command_args.is_empty(),
+        applies: |_| true,
+        key: false,
+        slim_skips: true,
+        hint: "Bazel records the command line of every spawn, so one without is unusual",
+    },
+    Field {
+        name: "Environment",
+        recorded: |s| !s.environment_variables.is_empty(),
+        applies: |_| true,
+        key: false,
+        slim_skips: true,
+        hint: "only spawns run with environment variables set, e.g. by --action_env, record them",
+    },
+    Field {
+        name: "Platform",
+        recorded: |s| s.platform.as_ref().is_some_and(|p| !p.properties.is_empty()),
+        applies: |_| true,
+        key: false,
+        slim_skips: false,
+        hint: "only spawns with execution properties, e.g. from --remote_default_exec_properties or a platform's exec_properties, record a platform",
+    },
+];
+
+/// How many of the spawns a field applies to recorded it.
+struct FieldCount {
+    field: &'static Field,
+    present: u64,
+    applicable: u64,
+    /// Left out by the decoding, so not counted.
+    skipped: bool,
+}
+
+impl FieldCount {
+    fn fraction(&self) -> f64 {
+        self.present as f64 / self.applicable.max(1) as f64
+    }
+
+    fn is_sparse(&self) -> bool {
+        self.field.key
+            && !self.skipped
+            && self.applicable > 0
+            && self.fraction() < SPARSE_FIELD_FRACTION
+    }
+}
+
+fn count_fields(spawns: &[SpawnExec], detail: Detail) -> Vec<FieldCount> {
+    FIELDS
+        .iter()
+        .map(|field| {
+            let applicable = spawns.iter().filter(|s| (field.applies)(s));
+            FieldCount {
+                field,
+                present: applicable.clone().filter(|s| (field.recorded)(s)).count() as u64,
+                applicable: applicable.count() as u64,
+                skipped: field.slim_skips && detail == Detail::Slim,
+            }
+        })
+        .collect()
+}
+
+/// Reports what share of the spawns recorded each field, with what makes Bazel record the
+/// ones that are missing. Fields that `detail` leaves out are shown as not read.
+pub fn print_field_coverage_report(spawns: &[SpawnExec], detail: Detail) {
+    println!("{}", section("Field Coverage"));
+    let fields = count_fields(spawns, detail);
+    let mut table = Table::new(vec![
+        ("Field".to_string(), Align::Left),
+        ("Spawns".to_string(), Align::Right),
+        ("Coverage".to_string(), Align::Right),
+    ]);
+    for field in &fields {
+        let (recorded, coverage) = if field.skipped {
+            ("-".to_string(), "not read (--max-memory)".to_string())
+        } else {
+            (
+                format!("{} of {}", field.present, field.applicable),
+                format!("{:.1}%", field.fraction() * 100.0),
+            )
+        };
+        table.add_row(vec![field.field.name.to_string(), recorded, coverage]);
+    }
+    table.print();
+
+    // Fields sharing a hint are named together.
+    let mut hints: Vec<(Vec<&str>, &str)> = Vec::new();
+    for count in fields
+        .iter()
+        .filter(|f| !f.skipped && f.present < f.applicable)
+    {
+        match hints.last_mut() {
+            Some((names, hint)) if *hint == count.field.hint => names.push(count.field.name),
+            _ => hints.push((vec![count.field.name], count.field.hint)),
+        }
+    }
+    for (names, hint) in hints {
+        println!("Note: {}: {}.", names.join(", "), hint);
+    }
+    println!("Note: Output digests are counted over the spawns that recorded outputs.");
+    println!();
+}
+
+/// Points out the key fields recorded by fewer than `SPARSE_FIELD_FRACTION` of the spawns,
+/// which leave most reports empty, unless --field-coverage prints the whole table anyway.
+pub fn print_sparse_fields_note(spawns: &[SpawnExec], detail: Detail) {
+    let fields = count_fields(spawns, detail);
+    let sparse: Vec<&FieldCount> = fields.iter().filter(|f| f.is_sparse()).collect();
+    if sparse.is_empty() {
+        return;
+    }
+    println!("{}", section("Missing Fields"));
+    let mut last_hint = "";
+    for field in sparse {
+        print!(
+            "{} is recorded by {:.1}% of spawns",
+            field.field.name,
+            field.fraction() * 100.0
+        );
+        if field.field.hint == last_hint {
+            println!(".");
+        } else {
+            println!(": {}.", field.field.hint);
+        }
+        last_hint = field.field.hint;
+    }
+    println!("Note: Reports built on these fields show zeros; --field-coverage shows every field.");
+    println!();
+}
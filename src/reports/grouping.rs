@@ -0,0 +1,735 @@
+//! Count / cache hit / time tables keyed by an arbitrary spawn attribute. --group-by looks
+//! its dimensions up in [`DIMENSIONS`], and every table here prints the same columns.
+
+use crate::analysis::{group_metrics, MnemonicMetrics};
+use crate::classify::{is_cache_hit, is_exec_configuration, runner_label, spawn_configuration};
+use crate::cli::{Cli, GroupKey, LabelPattern, PatternRepository, PatternScope};
+use crate::format::{
+    format_bytes, format_duration, print_time_chart, section, top_label, Align, ChartOptions, Table,
+};
+use crate::metrics::total_time;
+use crate::proto::SpawnExec;
+use crate::reports::tools::{command_tool, NO_COMMAND};
+use std::borrow::Cow;
+use std::collections::BTreeMap;
+use std::hash::Hash;
+use std::time::Duration;
+
+/// A --group-by dimension: the name it is selected by and the key it reads off each spawn.
+/// Adding one is an entry in [`DIMENSIONS`].
+pub struct Dimension {
+    pub name: &'static str,
+    /// Heads its column of the table and names it in the section title.
+    pub title: &'static str,
+    /// What the rows count, for the line about those past --top-n.
+    pub plural: &'static str,
+    key: for<'a> fn(&'a SpawnExec, &KeyOptions) -> Cow<'a, str>,
+}
+
+/// The flags some dimensions read their key with.
+pub struct KeyOptions {
+    pub package_depth: Option<usize>,
+    pub tool_full_path: bool,
+}
+
+impl KeyOptions {
+    pub fn from_cli(args: &Cli) -> Self {
+        KeyOptions {
+            package_depth: args.package_depth,
+            tool_full_path: args.tool_full_path,
+        }
+    }
+}
+
+/// Label bucket for spawns without a target, e.g. workspace status or coverage actions.
+pub const NO_LABEL: &str = "(no label)";
+
+/// Repository bucket for the spawns of the main repository and those without a label.
+const MAIN_REPOSITORY: &str = "(main repository)";
+
+/// Spawns without a `bazel-out/<config>/` output.
+const UNKNOWN_CONFIG: &str = "(unknown config)";
+
+/// Extension bucket for spawns whose primary output has none, or that list no output.
+const NO_EXTENSION: &str = "(none)";
+
+/// Platform bucket for spawns that recorded no platform properties.
+const NO_PLATFORM: &str = "none";
+
+/// The platform property remote execution services pick a worker pool by.
+const POOL_PROPERTY: &str = "Pool";
+
+/// Pool bucket for spawns that set no pool.
+const NO_POOL: &str = "(no Pool property)";
+
+/// The dimensions --group-by accepts, in the order --help lists them.
+pub static DIMENSIONS: &[Dimension] = &[
+    Dimension {
+        name: "mnemonic",
+        title: "Mnemonic",
+        plural: "mnemonics",
+        key: |s, _| Cow::Borrowed(&s.mnemonic),
+    },
+    Dimension {
+        name: "runner",
+        title: "Runner",
+        plural: "runners",
+        key: |s, _| Cow::Borrowed(runner_label(s)),
+    },
+    Dimension {
+        name: "target",
+        title: "Target",
+        plural: "targets",
+        key: |s, _| Cow::Borrowed(label_or(s, NO_LABEL)),
+    },
+    Dimension {
+        name: "package",
+        title: "Package",
+        plural: "packages",
+        key: |s, options| Cow::Owned(label_package(&s.target_label, options.package_depth)),
+    },
+    Dimension {
+        name: "repository",
+        title: "Repository",
+        plural: "repositories",
+        // The label prefix, which shows whether the name is apparent or canonical.
+        key: |s, _| match label_repository(&s.target_label) {
+            Some(_) => Cow::Borrowed(s.target_label.split("//").next().unwrap_or("")),
+            None => Cow::Borrowed(MAIN_REPOSITORY),
+        },
+    },
+    Dimension {
+        name: "config",
+        title: "Configuration",
+        plural: "configurations",
+        key: |s, _| Cow::Borrowed(spawn_configuration(s).unwrap_or(UNKNOWN_CONFIG)),
+    },
+    Dimension {
+        name: "platform",
+        title: "Platform Properties",
+        plural: "platforms",
+        key: |s, _| platform_key(s).map_or(Cow::Borrowed(NO_PLATFORM), Cow::Owned),
+    },
+    Dimension {
+        name: "pool",
+        title: "Pool",
+        plural: "pools",
+        key: |s, _| Cow::Borrowed(platform_property(s, POOL_PROPERTY).unwrap_or(NO_POOL)),
+    },
+    Dimension {
+        name: "tool",
+        title: "Tool",
+        plural: "tools",
+        key: |s, options| {
+            let tool = command_tool(&s.command_args, options.tool_full_path);
+            Cow::Borrowed(tool.unwrap_or(NO_COMMAND))
+        },
+    },
+    Dimension {
+        name: "extension",
+        title: "Output Extension",
+        plural: "extensions",
+        // The primary output, as `diff` matches actions by.
+        key: |s, _| {
+            let output = s
+                .listed_outputs
+                .first()
+                .or_else(|| s.actual_outputs.first().map(|f| &f.path));
+            Cow::Borrowed(output.and_then(|path| output_extension(path)).unwrap_or(NO_EXTENSION))
+        },
+    },
+];
+
+/// The dimension --group-by calls `name`.
+pub fn dimension(name: &str) -> Option<&'static Dimension> {
+    DIMENSIONS.iter().find(|dimension| dimension.name == name)
+}
+
+fn label_or<'a>(spawn: &'a SpawnExec, placeholder: &'a str) -> &'a str {
+    if spawn.target_label.is_empty() {
+        placeholder
+    } else {
+        &spawn.target_label
+    }
+}
+
+/// Aggregates spawns by the key returned from `key_fn`, sorted by total time descending and
+/// then by key.
+fn aggregate<'a, K: Ord + Hash>(
+    spawns: &'a [SpawnExec],
+    key_fn: impl FnMut(&'a SpawnExec) -> K,
+) -> Vec<(K, MnemonicMetrics)> {
+    let mut sorted: Vec<_> = group_metrics(spawns, key_fn).into_iter().collect();
+    sorted.sort_by(|(a_key, a), (b_key, b)| {
+        b.total_duration
+            .cmp(&a.total_duration)
+            .then_with(|| a_key.cmp(b_key))
+    });
+    sorted
+}
+
+/// The rows of a --group-by table, one for each value of the key's dimensions, sorted as
+/// [`aggregate`] sorts them.
+pub fn group_rows<'a>(
+    spawns: &'a [SpawnExec],
+    key: &GroupKey,
+    options: &KeyOptions,
+) -> Vec<(Vec<Cow<'a, str>>, MnemonicMetrics)> {
+    aggregate(spawns, |spawn| {
+        key.dimensions
+            .iter()
+            .map(|dimension| (dimension.key)(spawn, options))
+            .collect::<Vec<_>>()
+    })
+}
+
+/// Prints the standard columns for `rows`, with one key column for each of `key_headers`.
+fn print_table<'a>(
+    key_headers: &[&str],
+    rows: impl IntoIterator<Item = (Vec<&'a str>, &'a MnemonicMetrics)>,
+) {
+    let mut columns: Vec<(String, Align)> = key_headers
+        .iter()
+        .map(|header| (header.to_string(), Align::Left))
+        .collect();
+    for header in [
+        "Count",
+        "Cache Hits",
+        "Hit Rate",
+        "Total Time",
+        "Avg Time",
+        "Miss Time",
+        "Output Bytes",
+    ] {
+        columns.push((header.to_string(), Align::Right));
+    }
+    let mut table = Table::new(columns);
+    for (key, metrics) in rows {
+        let hit_rate = if metrics.count > 0 {
+            format!("{:.1}%", metrics.hit_rate())
+        } else {
+            "N/A".to_string()
+        };
+        let mut row: Vec<String> = key.iter().map(|part| part.to_string()).collect();
+        row.extend([
+            metrics.count.to_string(),
+            metrics.cache_hits.to_string(),
+            hit_rate,
+            format_duration(metrics.total_duration, 2),
+            metrics
+                .average()
+                .map_or("N/A".to_string(), |average| format_duration(average, 3)),
+            format_duration(metrics.miss_duration, 2),
+            format_bytes(metrics.output_bytes),
+        ]);
+        table.add_row(row);
+    }
+    table.print();
+}
+
+fn print_group_table(key_header: &str, groups: &[(impl AsRef<str>, MnemonicMetrics)]) {
+    print_table(
+        &[key_header],
+        groups
+            .iter()
+            .map(|(name, metrics)| (vec![name.as_ref()], metrics)),
+    );
+}
+
+/// Prints the --group-by table of `key`: the `top_n` rows by total time, and with --chart the
+/// time of each row as a bar, the rows past --chart-rows combined.
+pub fn print_group_by_report(
+    spawns: &[SpawnExec],
+    key: &GroupKey,
+    options: &KeyOptions,
+    top_n: usize,
+    chart: Option<&ChartOptions>,
+) {
+    let rows = group_rows(spawns, key, options);
+    let titles: Vec<&str> = key.dimensions.iter().map(|d| d.title).collect();
+    let title = titles.join(" and ");
+    if rows.len() > top_n {
+        println!(
+            "{}",
+            section(format_args!(
+                "Analysis by {} (Top {} of {})",
+                title,
+                top_n,
+                rows.len()
+            ))
+        );
+    } else {
+        println!("{}", section(format_args!("Analysis by {}", title)));
+    }
+    let shown = &rows[..rows.len().min(top_n)];
+    print_table(
+        &titles,
+        shown
+            .iter()
+            .map(|(key, metrics)| (key.iter().map(|part| part.as_ref()).collect(), metrics)),
+    );
+    if rows.len() > shown.len() {
+        let plural = match key.dimensions.as_slice() {
+            [dimension] => dimension.plural,
+            _ => "groups",
+        };
+        println!("... (+{} more {})", rows.len() - shown.len(), plural);
+    }
+    if let Some(options) = chart {
+        let bars: Vec<(String, Duration)> = rows
+            .iter()
+            .map(|(key, metrics)| (key.join(" / "), metrics.total_duration))
+            .collect();
+        println!();
+        print_time_chart(&bars, options);
+    }
+    println!();
+}
+
+/// Returns the package of a label (the part before the colon), keeping at most `depth`
+/// directories below the `//` root so that deep trees roll up.
+///
+/// External labels keep their `@repo//` root, so each repository groups separately.
+pub fn label_package(label: &str, depth: Option<usize>) -> String {
+    if label.is_empty() {
+        return NO_LABEL.to_string();
+    }
+    let package = label.split_once(':').map_or(label, |(package, _)| package);
+    let Some(depth) = depth else {
+        return package.to_string();
+    };
+    let (root, path) = match package.find("//") {
+        Some(index) => package.split_at(index + 2),
+        None => ("", package),
+    };
+    let kept: Vec<&str> = path.split('/').filter(|c| !c.is_empty()).take(depth).collect();
+    format!("{}{}", root, kept.join("/"))
+}
+
+/// Returns the extension of an output path with its dot, e.g. `.o`, or `None` if its file name
+/// has none. A short alphabetic component before the last one is kept with it, so that
+/// `.pb.go`, `.tar.gz` and `.so.1` keep their kind while `lib-1.2.jar` is a `.jar`.
+pub fn output_extension(path: &str) -> Option<&str> {
+    let name = path.rsplit('/').next().unwrap_or(path);
+    let stem = name.trim_start_matches('.');
+    let last = stem.rfind('.')?;
+    let start = match stem[..last].rfind('.') {
+        Some(dot)
+            if last - dot - 1 <= 3
+                && stem[dot + 1..last].bytes().all(|b| b.is_ascii_alphabetic()) =>
+        {
+            dot
+        }
+        _ => last,
+    };
+    let extension = &stem[start..];
+    (extension.len() > 1).then_some(extension)
+}
+
+/// Returns the external repository of a label, or `None` for the main repository.
+///
+/// Both apparent (`@repo//pkg`) and canonical Bzlmod (`@@repo~//pkg`) names are recognized;
+/// `@//` and `@@//` refer to the main repository. Unlabeled spawns count as the main repository.
+pub fn label_repository(label: &str) -> Option<&str> {
+    let rest = label.strip_prefix("@@").or_else(|| label.strip_prefix('@'))?;
+    let repository = rest.split_once("//").map_or(rest, |(repository, _)| repository);
+    (!repository.is_empty()).then_some(repository)
+}
+
+/// Whether a --group-prefix pattern covers a label. A named repository also matches its
+/// canonical Bzlmod names (`@@rules_go~` or `@@rules_go+` for `@rules_go`).
+pub fn label_pattern_matches(pattern: &LabelPattern, label: &str) -> bool {
+    if label.is_empty() {
+        return false;
+    }
+    let repository = label_repository(label);
+    let in_repository = match (&pattern.repository, repository) {
+        (PatternRepository::Main, None) => true,
+        (PatternRepository::External, Some(_)) => true,
+        (PatternRepository::Named(name), Some(repository)) => repository
+            .strip_prefix(name.as_str())
+            .is_some_and(|rest| rest.is_empty() || rest.starts_with(['~', '+'])),
+        _ => false,
+    };
+    if !in_repository {
+        return false;
+    }
+    let path = label.split_once("//").map_or(label, |(_, path)| path);
+    let (package, target) = match path.split_once(':') {
+        Some((package, target)) => (package, target),
+        None => (path, path.rsplit('/').next().unwrap_or(path)),
+    };
+    match &pattern.scope {
+        PatternScope::Recursive => {
+            pattern.package.is_empty()
+                || package
+                    .strip_prefix(pattern.package.as_str())
+                    .is_some_and(|rest| rest.is_empty() || rest.starts_with('/'))
+        }
+        PatternScope::Package => package == pattern.package,
+        PatternScope::Target(name) => package == pattern.package && target == name,
+    }
+}
+
+/// Row for spawns that no --group-prefix pattern matches.
+const OTHER_PATTERN: &str = "other";
+
+/// Reports spawns by --group-prefix pattern, one row per pattern in the order given and the
+/// rest under "other". A spawn counts under the first pattern it matches; spawns that later
+/// patterns would also have matched are pointed out, since those rows come up short.
+pub fn print_label_pattern_report(spawns: &[SpawnExec], patterns: &[LabelPattern]) {
+    println!("{}", section("Analysis by Label Pattern"));
+    let mut rows: Vec<MnemonicMetrics> = (0..=patterns.len())
+        .map(|_| MnemonicMetrics::default())
+        .collect();
+    // (later pattern, earlier pattern) -> spawns the earlier one took.
+    let mut shadowed: BTreeMap<(usize, usize), u64> = BTreeMap::new();
+    for spawn in spawns {
+        let mut matching = patterns
+            .iter()
+            .enumerate()
+            .filter(|(_, pattern)| label_pattern_matches(pattern, &spawn.target_label))
+            .map(|(index, _)| index);
+        let first = matching.next();
+        for later in matching {
+            *shadowed.entry((later, first.unwrap_or_default())).or_default() += 1;
+        }
+        rows[first.unwrap_or(patterns.len())].add(spawn);
+    }
+
+    let names = patterns.iter().map(|p| p.text.as_str()).chain([OTHER_PATTERN]);
+    print_table(
+        &["Pattern"],
+        names
+            .zip(&rows)
+            .enumerate()
+            .filter(|(index, (_, row))| *index < patterns.len() || row.count > 0)
+            .map(|(_, (name, row))| (vec![name], row)),
+    );
+    for ((later, earlier), count) in shadowed {
+        println!(
+            "Note: {} spawns matching {} are counted under the earlier {}.",
+            count, patterns[later].text, patterns[earlier].text
+        );
+    }
+    println!();
+}
+
+/// Totals for spawns of the main repository and of external repositories.
+#[derive(Default)]
+pub struct RepositorySplit {
+    pub main: GroupTotals,
+    pub external: GroupTotals,
+}
+
+#[derive(Default)]
+pub struct GroupTotals {
+    pub spawns: u64,
+    pub cache_hits: u64,
+    pub time: Duration,
+}
+
+impl GroupTotals {
+    pub fn hit_rate(&self) -> f64 {
+        if self.spawns == 0 {
+            0.0
+        } else {
+            self.cache_hits as f64 / self.spawns as f64 * 100.0
+        }
+    }
+}
+
+impl RepositorySplit {
+    pub fn from_spawns(spawns: &[SpawnExec]) -> Self {
+        let mut split = RepositorySplit::default();
+        for spawn in spawns {
+            let totals = if label_repository(&spawn.target_label).is_some() {
+                &mut split.external
+            } else {
+                &mut split.main
+            };
+            totals.spawns += 1;
+            if is_cache_hit(spawn) {
+                totals.cache_hits += 1;
+            }
+            totals.time += total_time(spawn);
+        }
+        split
+    }
+
+    /// Share of the summed spawn time spent on external repositories.
+    pub fn external_time_percent(&self) -> f64 {
+        let total = self.main.time + self.external.time;
+        if total.is_zero() {
+            0.0
+        } else {
+            self.external.time.as_secs_f64() / total.as_secs_f64() * 100.0
+        }
+    }
+}
+
+pub fn print_repository_report(spawns: &[SpawnExec], top_n: usize) {
+    println!("{}", section("Main vs. External Repositories"));
+    let split = RepositorySplit::from_spawns(spawns);
+    let total = split.main.time + split.external.time;
+    let mut table = Table::new(vec![
+        ("Repository".to_string(), Align::Left),
+        ("Spawns".to_string(), Align::Right),
+        ("Cache Hits".to_string(), Align::Right),
+        ("Total Time".to_string(), Align::Right),
+        ("% Time".to_string(), Align::Right),
+    ]);
+    for (name, totals) in [("main", &split.main), ("external", &split.external)] {
+        let share = if total.is_zero() {
+            0.0
+        } else {
+            totals.time.as_secs_f64() / total.as_secs_f64() * 100.0
+        };
+        table.add_row(vec![
+            name.to_string(),
+            totals.spawns.to_string(),
+            format!("{:.1}%", totals.hit_rate()),
+            format_duration(totals.time, 2),
+            format!("{:.1}%", share),
+        ]);
+    }
+    table.print();
+
+    // Keyed by the label prefix, which shows whether the name is apparent or canonical.
+    let groups = aggregate(spawns, |s| match label_repository(&s.target_label) {
+        Some(_) => s.target_label.split("//").next().unwrap_or(""),
+        None => "",
+    });
+    let groups: Vec<_> = groups.into_iter().filter(|(name, _)| !name.is_empty()).collect();
+    if groups.is_empty() {
+        println!("No spawns of external repositories found in the log.");
+        println!();
+        return;
+    }
+    let shown = groups.len().min(top_n);
+    println!();
+    println!("{} External Repositories by Total Time:", top_label(top_n));
+    print_group_table("Repository", &groups[..shown]);
+    if groups.len() > shown {
+        println!("... (+{} more repositories)", groups.len() - shown);
+    }
+    println!();
+}
+
+pub fn print_configuration_report(spawns: &[SpawnExec]) {
+    println!("{}", section("Exec vs. Target Configuration"));
+    let kinds = aggregate(spawns, |s| match spawn_configuration(s) {
+        Some(config) if is_exec_configuration(config) => "exec",
+        Some(_) => "target",
+        None => UNKNOWN_CONFIG,
+    });
+    print_group_table("Configuration", &kinds);
+
+    let configs = aggregate(spawns, |s| spawn_configuration(s).unwrap_or(UNKNOWN_CONFIG));
+    let target_configs = configs
+        .iter()
+        .filter(|(name, _)| *name != UNKNOWN_CONFIG && !is_exec_configuration(name))
+        .count();
+    if target_configs > 1 {
+        println!();
+        println!("By Configuration ({} target configurations):", target_configs);
+        print_group_table("Configuration", &configs);
+    }
+    println!("Note: The configuration is read from the bazel-out/<config>/ segment of each spawn's first output.");
+    println!();
+}
+
+/// The platform properties of a spawn as one `name=value,...` string sorted by name, so
+/// that equal property sets group together regardless of their recorded order.
+pub fn platform_key(spawn: &SpawnExec) -> Option<String> {
+    let platform = spawn.platform.as_ref().filter(|p| !p.properties.is_empty())?;
+    let mut properties: Vec<String> = platform
+        .properties
+        .iter()
+        .map(|p| format!("{}={}", p.name, p.value))
+        .collect();
+    properties.sort_unstable();
+    Some(properties.join(","))
+}
+
+/// Returns the value of the platform property `name`, if the spawn sets it.
+pub fn platform_property<'a>(spawn: &'a SpawnExec, name: &str) -> Option<&'a str> {
+    spawn
+        .platform
+        .as_ref()?
+        .properties
+        .iter()
+        .find(|p| p.name == name)
+        .map(|p| p.value.as_str())
+}
+
+/// Prints spawn counts, time and cache hits per distinct platform property set, or per
+/// value of the property `key` when one is given.
+pub fn print_platform_report(spawns: &[SpawnExec], key: Option<&str>) {
+    println!("{}", section("Platform Summary"));
+    let groups = aggregate(spawns, |s| match (platform_key(s), key) {
+        (None, _) => NO_PLATFORM.to_string(),
+        (Some(properties), None) => properties,
+        (Some(_), Some(key)) => platform_property(s, key)
+            .map_or_else(|| format!("(no {} property)", key), str::to_string),
+    });
+    match key {
+        Some(key) => print_group_table(key, &groups),
+        None => print_group_table("Platform Properties", &groups),
+    }
+    let distinct = groups.iter().filter(|(name, _)| name != NO_PLATFORM).count();
+    if let Some((_, none)) = groups.iter().find(|(name, _)| name == NO_PLATFORM) {
+        println!(
+            "{} of {} spawns recorded no platform properties.",
+            none.count,
+            spawns.len()
+        );
+    }
+    if key.is_none() && distinct > 1 {
+        println!("Note: Use --platform-key to group by the value of a single property, e.g. container-image.");
+    }
+    println!();
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::cli::parse_group_key;
+    use crate::proto::platform::Property;
+    use crate::proto::{File, Platform};
+    use crate::testing::spawn;
+
+    const OPTIONS: KeyOptions = KeyOptions {
+        package_depth: None,
+        tool_full_path: false,
+    };
+
+    /// A spawn that sets everything the dimensions read.
+    fn labeled() -> SpawnExec {
+        SpawnExec {
+            target_label: "@@rules_go~//go/tools/builders:builder".to_string(),
+            command_args: ["/usr/bin/env", "PATH=/bin", "bin/gcc"].map(String::from).to_vec(),
+            listed_outputs: vec!["bazel-out/k8-opt-exec-ST-1/bin/go/api.pb.go".to_string()],
+            platform: Some(Platform {
+                properties: vec![
+                    Property {
+                        name: "Pool".to_string(),
+                        value: "large".to_string(),
+                    },
+                    Property {
+                        name: "OSFamily".to_string(),
+                        value: "Linux".to_string(),
+                    },
+                ],
+            }),
+            ..spawn("GoCompilePkg", "remote", 1_000)
+        }
+    }
+
+    fn key<'a>(name: &str, spawn: &'a SpawnExec, options: &KeyOptions) -> Cow<'a, str> {
+        (dimension(name).unwrap().key)(spawn, options)
+    }
+
+    #[test]
+    fn each_dimension_reads_its_key() {
+        let labeled = labeled();
+        let bare = spawn("", "", 1_000);
+        let cases = [
+            ("mnemonic", "GoCompilePkg", ""),
+            ("runner", "remote", "(unknown)"),
+            ("target", "@@rules_go~//go/tools/builders:builder", NO_LABEL),
+            ("package", "@@rules_go~//go/tools/builders", NO_LABEL),
+            ("repository", "@@rules_go~", MAIN_REPOSITORY),
+            ("config", "k8-opt-exec-ST-1", UNKNOWN_CONFIG),
+            ("platform", "OSFamily=Linux,Pool=large", NO_PLATFORM),
+            ("pool", "large", NO_POOL),
+            ("tool", "gcc", NO_COMMAND),
+            ("extension", ".pb.go", NO_EXTENSION),
+        ];
+        assert_eq!(
+            cases.iter().map(|(name, _, _)| *name).collect::<Vec<_>>(),
+            DIMENSIONS.iter().map(|d| d.name).collect::<Vec<_>>()
+        );
+        for (name, expected, placeholder) in cases {
+            assert_eq!(key(name, &labeled, &OPTIONS), expected, "{}", name);
+            assert_eq!(key(name, &bare, &OPTIONS), placeholder, "{} without it", name);
+        }
+    }
+
+    #[test]
+    fn keys_follow_their_options() {
+        let mut spawn = labeled();
+        let options = KeyOptions {
+            package_depth: Some(2),
+            tool_full_path: true,
+        };
+        assert_eq!(key("package", &spawn, &options), "@@rules_go~//go/tools");
+        assert_eq!(key("tool", &spawn, &options), "bin/gcc");
+
+        spawn.target_label = "//app:bin".to_string();
+        assert_eq!(key("repository", &spawn, &OPTIONS), MAIN_REPOSITORY);
+        spawn.target_label = "@zlib//:zlib".to_string();
+        assert_eq!(key("repository", &spawn, &OPTIONS), "@zlib");
+
+        // Without listed outputs the extension and config come from the actual ones.
+        spawn.listed_outputs.clear();
+        spawn.actual_outputs = vec![File {
+            path: "bazel-out/darwin_arm64-fastbuild/bin/lib/libz.so.1".to_string(),
+            ..Default::default()
+        }];
+        assert_eq!(key("extension", &spawn, &OPTIONS), ".so.1");
+        assert_eq!(key("config", &spawn, &OPTIONS), "darwin_arm64-fastbuild");
+    }
+
+    #[test]
+    fn output_extensions_keep_short_compound_suffixes() {
+        assert_eq!(output_extension("out/lib.a"), Some(".a"));
+        assert_eq!(output_extension("out/site.tar.gz"), Some(".tar.gz"));
+        assert_eq!(output_extension("out/lib-1.2.jar"), Some(".jar"));
+        assert_eq!(output_extension("out/.bazelrc"), None);
+        assert_eq!(output_extension("out/BUILD"), None);
+        assert_eq!(output_extension("out/name."), None);
+    }
+
+    #[test]
+    fn compound_rows_are_sorted_by_time_then_key() {
+        let spawns = [
+            spawn("Javac", "worker", 3_000),
+            spawn("Javac", "remote cache hit", 500),
+            spawn("CppCompile", "remote", 2_000),
+            spawn("CppCompile", "remote", 1_000),
+            spawn("Javac", "worker", 1_000),
+            spawn("Genrule", "local", 500),
+        ];
+        let key = parse_group_key("mnemonic,runner").unwrap();
+        let rows: Vec<(String, u64, u64, Duration)> = group_rows(&spawns, &key, &OPTIONS)
+            .into_iter()
+            .map(|(key, m)| (key.join(" / "), m.count, m.cache_hits, m.total_duration))
+            .collect();
+        let millis = Duration::from_millis;
+        assert_eq!(
+            rows,
+            [
+                ("Javac / worker".to_string(), 2, 0, millis(4_000)),
+                ("CppCompile / remote".to_string(), 2, 0, millis(3_000)),
+                ("Genrule / local".to_string(), 1, 0, millis(500)),
+                ("Javac / remote cache hit".to_string(), 1, 1, millis(500)),
+            ]
+        );
+    }
+
+    #[test]
+    fn group_keys_name_one_or_two_distinct_dimensions() {
+        let names = |value: &str| -> Result<Vec<&str>, String> {
+            parse_group_key(value).map(|key| key.dimensions.iter().map(|d| d.name).collect())
+        };
+        assert_eq!(names("pool"), Ok(vec!["pool"]));
+        assert_eq!(names("mnemonic, runner"), Ok(vec!["mnemonic", "runner"]));
+        let error = names("colour").unwrap_err();
+        assert!(error.starts_with("'colour' is not a dimension; use one of mnemonic, runner,"));
+        let error = names("runner,runner").unwrap_err();
+        assert!(error.contains("names the same dimension twice"), "{}", error);
+        let error = names("mnemonic,runner,pool").unwrap_err();
+        assert!(error.contains("combines 3 dimensions"), "{}", error);
+    }
+}
@@ -0,0 +1,139 @@
+//! Digest hash functions used across the log, and digests that look malformed.
+
+use crate::format::section;
+use crate::proto::{Digest, SpawnExec};
+use std::collections::BTreeMap;
+
+/// Example files listed per hash function and for malformed digests.
+const MAX_EXAMPLES: usize = 3;
+
+/// Hex length of the hashes produced by a digest function, if known.
+fn expected_hash_len(function: &str) -> Option<usize> {
+    match function.to_ascii_uppercase().replace(['-', '_'], "").as_str() {
+        "MD5" => Some(32),
+        "SHA1" => Some(40),
+        "SHA256" | "BLAKE3" => Some(64),
+        "SHA384" => Some(96),
+        "SHA512" => Some(128),
+        _ => None,
+    }
+}
+
+/// Describes what is wrong with a digest, or `None` if it looks well-formed.
+fn digest_problem(digest: &Digest) -> Option<String> {
+    if digest.size_bytes < 0 {
+        return Some(format!("negative size {}", digest.size_bytes));
+    }
+    if !digest.hash.chars().all(|c| c.is_ascii_hexdigit()) {
+        return Some("hash is not hexadecimal".to_string());
+    }
+    match expected_hash_len(&digest.hash_function_name) {
+        Some(len) if digest.hash.len() != len => Some(format!(
+            "{} hash has {} hex characters, expected {}",
+            digest.hash_function_name,
+            digest.hash.len(),
+            len
+        )),
+        None if ![32, 40, 64, 96, 128].contains(&digest.hash.len()) => Some(format!(
+            "hash length {} matches no known digest function",
+            digest.hash.len()
+        )),
+        _ => None,
+    }
+}
+
+#[derive(Default)]
+pub struct FunctionUsage<'a> {
+    pub count: u64,
+    /// The paths of the first few files, as recorded.
+    pub examples: Vec<&'a str>,
+}
+
+/// The digest functions of the file digests, by name, and the digests that look malformed.
+pub struct DigestUsage<'a> {
+    pub functions: BTreeMap<&'a str, FunctionUsage<'a>>,
+    /// The first few, with what is wrong with them.
+    pub malformed: Vec<(&'a str, String)>,
+    pub malformed_count: u64,
+}
+
+pub fn digest_usage(spawns: &[SpawnExec]) -> DigestUsage<'_> {
+    let mut usage = DigestUsage {
+        functions: BTreeMap::new(),
+        malformed: Vec::new(),
+        malformed_count: 0,
+    };
+    let files = spawns
+        .iter()
+        .flat_map(|s| s.inputs.iter().chain(&s.actual_outputs));
+    for file in files {
+        let Some(digest) = file.digest.as_ref() else {
+            continue;
+        };
+        let function = usage
+            .functions
+            .entry(digest.hash_function_name.as_str())
+            .or_default();
+        function.count += 1;
+        if function.examples.len() < MAX_EXAMPLES {
+            function.examples.push(&file.path);
+        }
+        if let Some(problem) = digest_problem(digest) {
+            usage.malformed_count += 1;
+            if usage.malformed.len() < MAX_EXAMPLES {
+                usage.malformed.push((&file.path, problem));
+            }
+        }
+    }
+    usage
+}
+
+/// Prints the digest functions seen in file digests.
+///
+/// The section is only printed when more than one function is used or some digests look
+/// malformed, unless `always` is set.
+pub fn print_digest_functions_report(spawns: &[SpawnExec], always: bool) {
+    let DigestUsage {
+        functions,
+        malformed,
+        malformed_count,
+    } = digest_usage(spawns);
+
+    let mixed = functions.len() > 1;
+    if !always && !mixed && malformed_count == 0 {
+        return;
+    }
+
+    println!("{}", section("Digest Functions"));
+    if functions.is_empty() {
+        println!("No file digests found in the log.");
+        println!();
+        return;
+    }
+    if mixed {
+        println!(
+            "WARNING: {} different digest functions appear in this log. Cache keys computed with different functions never match.",
+            functions.len()
+        );
+    }
+    for (function, usage) in &functions {
+        let name = if function.is_empty() {
+            "(unspecified)"
+        } else {
+            function
+        };
+        println!("{}: {} digests", name, usage.count);
+        if mixed {
+            for example in &usage.examples {
+                println!("  └ e.g. {}", example);
+            }
+        }
+    }
+    if malformed_count > 0 {
+        println!("WARNING: {} digests look malformed:", malformed_count);
+        for (path, problem) in &malformed {
+            println!("  └ {}: {}", path, problem);
+        }
+    }
+    println!();
+}
@@ -0,0 +1,180 @@
+//! Red flags for non-hermetic spawns in their environment and command lines.
+
+use crate::format::{section, Align, Table};
+use crate::proto::SpawnExec;
+use crate::reports::grouping::NO_LABEL;
+use std::collections::HashMap;
+
+/// Environment variables that describe the machine or shell rather than the action.
+const HOST_VARIABLES: [&str; 6] = ["PWD", "OLDPWD", "HOSTNAME", "HOME", "USER", "LOGNAME"];
+
+/// Prefixes of user home directories, which have no business in an action's command.
+const HOME_PREFIXES: [&str; 2] = ["/home/", "/Users/"];
+
+/// Example values longer than this are truncated.
+const MAX_EXAMPLE_LEN: usize = 60;
+
+/// Kinds of hermeticity problem, in the order they are reported.
+#[derive(Clone, Copy, PartialEq, Eq, Hash, PartialOrd, Ord)]
+enum Check {
+    HomePath,
+    WorkspacePath,
+    PathVariance,
+    HostVariable,
+}
+
+impl Check {
+    fn name(self) -> &'static str {
+        match self {
+            Check::HomePath => "home directory path",
+            Check::WorkspacePath => "absolute workspace path",
+            Check::PathVariance => "PATH differs",
+            Check::HostVariable => "host variable set",
+        }
+    }
+}
+
+/// Spawns of one mnemonic affected by one check, with the first offending value.
+struct Finding<'a> {
+    spawns: u64,
+    example_label: &'a str,
+    example: String,
+}
+
+fn truncated(value: &str) -> String {
+    if value.chars().count() > MAX_EXAMPLE_LEN {
+        let prefix: String = value.chars().take(MAX_EXAMPLE_LEN).collect();
+        format!("{}...", prefix)
+    } else {
+        value.to_string()
+    }
+}
+
+/// Returns the first problem of each kind found in one spawn.
+///
+/// `workspace` is the absolute workspace or execution root, if known; otherwise absolute
+/// paths into an `execroot` are flagged. `majority_path` is the most common PATH value.
+fn spawn_problems(
+    spawn: &SpawnExec,
+    workspace: Option<&str>,
+    majority_path: Option<&str>,
+    allow: &[String],
+) -> Vec<(Check, String)> {
+    let allowed = |value: &str| allow.iter().any(|pattern| value.contains(pattern.as_str()));
+    let leaks_workspace = |value: &str| match workspace {
+        Some(root) => value.contains(root),
+        None => value.contains("/execroot/") && (value.starts_with('/') || value.contains("=/")),
+    };
+
+    let mut problems: Vec<(Check, String)> = Vec::new();
+    let mut flag = |check: Check, value: String| {
+        if !allowed(&value) && !problems.iter().any(|(c, _)| *c == check) {
+            problems.push((check, value));
+        }
+    };
+
+    let env_strings = spawn
+        .environment_variables
+        .iter()
+        .map(|v| format!("{}={}", v.name, v.value));
+    for value in spawn.command_args.iter().cloned().chain(env_strings) {
+        if HOME_PREFIXES.iter().any(|prefix| value.contains(prefix)) {
+            flag(Check::HomePath, value.clone());
+        }
+        if leaks_workspace(&value) {
+            flag(Check::WorkspacePath, value);
+        }
+    }
+    for variable in &spawn.environment_variables {
+        if HOST_VARIABLES.contains(&variable.name.as_str()) {
+            flag(Check::HostVariable, format!("{}={}", variable.name, variable.value));
+        }
+        if variable.name == "PATH" && majority_path.is_some_and(|path| path != variable.value) {
+            flag(Check::PathVariance, format!("PATH={}", variable.value));
+        }
+    }
+    problems
+}
+
+/// Prints the spawns whose environment or arguments depend on the machine they ran on, and
+/// returns how many spawns are affected.
+pub fn print_hermeticity_report(
+    spawns: &[SpawnExec],
+    workspace: Option<&str>,
+    allow: &[String],
+) -> usize {
+    println!("{}", section("Hermeticity Checks"));
+
+    let mut path_counts: HashMap<&str, u64> = HashMap::new();
+    for spawn in spawns {
+        for variable in spawn.environment_variables.iter().filter(|v| v.name == "PATH") {
+            *path_counts.entry(variable.value.as_str()).or_default() += 1;
+        }
+    }
+    let majority_path = path_counts
+        .iter()
+        .max_by(|a, b| a.1.cmp(b.1).then(b.0.cmp(a.0)))
+        .map(|(path, _)| *path);
+
+    let mut findings: HashMap<(Check, &str), Finding> = HashMap::new();
+    let mut affected = 0;
+    for spawn in spawns {
+        let problems = spawn_problems(spawn, workspace, majority_path, allow);
+        affected += !problems.is_empty() as usize;
+        for (check, value) in problems {
+            findings
+                .entry((check, spawn.mnemonic.as_str()))
+                .or_insert_with(|| Finding {
+                    spawns: 0,
+                    example_label: if spawn.target_label.is_empty() {
+                        NO_LABEL
+                    } else {
+                        &spawn.target_label
+                    },
+                    example: truncated(&value),
+                })
+                .spawns += 1;
+        }
+    }
+
+    if findings.is_empty() {
+        println!("No hermeticity problems found in spawn environments or command lines.");
+        println!();
+        return 0;
+    }
+
+    let mut sorted: Vec<_> = findings.into_iter().collect();
+    sorted.sort_by(|((a_check, a_name), a), ((b_check, b_name), b)| {
+        a_check
+            .cmp(b_check)
+            .then(b.spawns.cmp(&a.spawns))
+            .then(a_name.cmp(b_name))
+    });
+    let mut table = Table::new(vec![
+        ("Check".to_string(), Align::Left),
+        ("Mnemonic".to_string(), Align::Left),
+        ("Spawns".to_string(), Align::Right),
+        ("Example Target".to_string(), Align::Left),
+        ("Example Value".to_string(), Align::Left),
+    ]);
+    for ((check, mnemonic), finding) in &sorted {
+        table.add_row(vec![
+            check.name().to_string(),
+            mnemonic.to_string(),
+            finding.spawns.to_string(),
+            finding.example_label.to_string(),
+            finding.example.clone(),
+        ]);
+    }
+    table.print();
+    println!(
+        "{} of {} spawns have at least one problem; values containing a --hermeticity-allow pattern are skipped.",
+        affected,
+        spawns.len()
+    );
+    if workspace.is_none() {
+        println!("Note: Without --workspace, only absolute paths into an execroot count as workspace paths.");
+    }
+    println!();
+    affected
+}
@@ -0,0 +1,555 @@
+//! Reports over the input files of spawns.
+//!
+//! Compact logs only carry inputs when the parser was asked to reconstruct them, see
+//! `Cli::needs_full_decode`.
+
+use crate::classify::is_cache_hit;
+use crate::digests::DigestSet;
+use crate::format::{format_bytes, format_duration, section, top_label, Align, Table};
+use crate::metrics::{is_symlink, output_bytes, total_time};
+use crate::paths;
+use crate::proto::{File, SpawnExec};
+use crate::report::highest_first;
+use crate::reports::outputs::symlink_outputs;
+use crate::stats::percentile;
+use std::borrow::Cow;
+use std::collections::HashMap;
+use std::time::Duration;
+
+/// Sum of the digest sizes of a spawn's inputs, in bytes.
+fn input_bytes(spawn: &SpawnExec) -> i64 {
+    spawn
+        .inputs
+        .iter()
+        .filter_map(|file| file.digest.as_ref())
+        .map(|digest| digest.size_bytes)
+        .sum()
+}
+
+pub fn print_input_count_report(spawns: &[SpawnExec], top_n: usize) {
+    println!("{}", section(format_args!("{} Actions by Input Count", top_label(top_n))));
+
+    let mut counts: Vec<usize> = spawns.iter().map(|s| s.inputs.len()).collect();
+    if counts.iter().all(|c| *c == 0) {
+        println!("No input files found in the log.");
+        println!();
+        return;
+    }
+    counts.sort_unstable();
+    println!(
+        "Input Count Distribution: median {}, p99 {}, max {}",
+        percentile(&counts, 50.0),
+        percentile(&counts, 99.0),
+        counts[counts.len() - 1]
+    );
+
+    let mut by_count: Vec<&SpawnExec> = spawns.iter().filter(|s| !s.inputs.is_empty()).collect();
+    by_count.sort_by(|a, b| highest_first(a, b, |s| s.inputs.len()));
+    let mut table = Table::new(vec![
+        ("Inputs".to_string(), Align::Right),
+        ("Input Size".to_string(), Align::Right),
+        ("Duration".to_string(), Align::Right),
+        ("Mnemonic".to_string(), Align::Left),
+        ("Target".to_string(), Align::Left),
+    ]);
+    for spawn in by_count.into_iter().take(top_n) {
+        table.add_row(vec![
+            spawn.inputs.len().to_string(),
+            format!("{:.2}MB", input_bytes(spawn) as f64 / 1_048_576.0),
+            format_duration(total_time(spawn), 3),
+            spawn.mnemonic.clone(),
+            spawn.target_label.clone(),
+        ]);
+    }
+    table.print();
+    println!();
+}
+
+/// Naive and digest-deduplicated byte totals over a stream of files.
+#[derive(Default)]
+pub struct Volume {
+    pub files: u64,
+    pub bytes: i64,
+    pub unique: DigestSet,
+}
+
+impl Volume {
+    /// Adds a file, returning true if its digest had not been seen before.
+    pub fn add(&mut self, file: &File) -> bool {
+        self.files += 1;
+        let Some(digest) = file.digest.as_ref() else {
+            return false;
+        };
+        self.bytes += digest.size_bytes;
+        self.unique.insert(digest)
+    }
+
+    /// Naive bytes divided by deduplicated bytes.
+    pub fn dedup_ratio(&self) -> Option<f64> {
+        (self.unique.bytes() > 0).then(|| self.bytes as f64 / self.unique.bytes() as f64)
+    }
+}
+
+/// The figures of the Data Volume Summary.
+pub struct DataVolume<'a> {
+    pub inputs: Volume,
+    /// Symlinks left out.
+    pub outputs: Volume,
+    /// The largest input or output, with its size.
+    pub largest: Option<(&'a File, i64)>,
+    pub median_input_bytes: i64,
+    pub median_output_bytes: i64,
+    /// Symlink outputs, and the actions that have any.
+    pub symlink_outputs: usize,
+    pub symlink_actions: usize,
+}
+
+pub fn data_volume(spawns: &[SpawnExec]) -> DataVolume<'_> {
+    let mut inputs = Volume::default();
+    let mut outputs = Volume::default();
+    let mut largest: Option<(&File, i64)> = None;
+    let mut per_spawn_inputs = Vec::with_capacity(spawns.len());
+    let mut per_spawn_outputs = Vec::with_capacity(spawns.len());
+    for spawn in spawns {
+        for file in &spawn.inputs {
+            inputs.add(file);
+        }
+        for file in spawn.actual_outputs.iter().filter(|f| !is_symlink(f)) {
+            outputs.add(file);
+        }
+        for file in spawn.inputs.iter().chain(&spawn.actual_outputs) {
+            let size = file.digest.as_ref().map_or(0, |d| d.size_bytes);
+            if largest.is_none_or(|(_, max)| size > max) {
+                largest = Some((file, size));
+            }
+        }
+        per_spawn_inputs.push(input_bytes(spawn));
+        per_spawn_outputs.push(output_bytes(spawn));
+    }
+    per_spawn_inputs.sort_unstable();
+    per_spawn_outputs.sort_unstable();
+    let symlinks: Vec<usize> = spawns
+        .iter()
+        .map(symlink_outputs)
+        .filter(|count| *count > 0)
+        .collect();
+    DataVolume {
+        inputs,
+        outputs,
+        largest,
+        median_input_bytes: percentile(&per_spawn_inputs, 50.0),
+        median_output_bytes: percentile(&per_spawn_outputs, 50.0),
+        symlink_outputs: symlinks.iter().sum(),
+        symlink_actions: symlinks.len(),
+    }
+}
+
+pub fn print_data_volume_report(spawns: &[SpawnExec]) {
+    println!("{}", section("Data Volume Summary"));
+
+    let volume = data_volume(spawns);
+    let (inputs, outputs) = (&volume.inputs, &volume.outputs);
+    if inputs.files + outputs.files == 0 {
+        println!("No input or output files found in the log.");
+        println!();
+        return;
+    }
+
+    println!(
+        "Input Bytes Referenced: {} across {} file references ({} deduplicated by digest, {} unique files)",
+        format_bytes(inputs.bytes),
+        inputs.files,
+        format_bytes(inputs.unique.bytes()),
+        inputs.unique.len()
+    );
+    println!(
+        "Output Bytes Produced: {} across {} files ({} deduplicated by digest)",
+        format_bytes(outputs.bytes),
+        outputs.files,
+        format_bytes(outputs.unique.bytes())
+    );
+    println!(
+        "Median per Spawn: {} of inputs, {} of outputs",
+        format_bytes(volume.median_input_bytes),
+        format_bytes(volume.median_output_bytes)
+    );
+    if let Some((file, size)) = volume.largest {
+        println!(
+            "Largest Single File: {} ({})",
+            paths::relative(&file.path),
+            format_bytes(size)
+        );
+    }
+    if volume.symlink_actions > 0 {
+        println!(
+            "Symlink Outputs: {} across {} actions (not counted in byte totals)",
+            volume.symlink_outputs, volume.symlink_actions
+        );
+    }
+    println!();
+}
+
+/// Groups an input path by its first `depth` components.
+///
+/// Files of external repositories are grouped under the repository root regardless of depth.
+fn input_dir(path: &str, depth: usize) -> String {
+    let components: Vec<&str> = path.split('/').filter(|c| !c.is_empty()).collect();
+    let depth = match components.first() {
+        Some(&"external") | Some(&"..") if components.len() > 2 => 2,
+        _ => depth.max(1),
+    };
+    if components.len() <= depth {
+        // The file itself sits at this depth; group it by its parent directory.
+        let parent = &components[..components.len().saturating_sub(1)];
+        return if parent.is_empty() {
+            ".".to_string()
+        } else {
+            parent.join("/")
+        };
+    }
+    components[..depth].join("/")
+}
+
+#[derive(Default)]
+struct DirStats {
+    unique: DigestSet,
+    references: u64,
+    reference_bytes: i64,
+}
+
+pub fn print_input_dirs_report(spawns: &[SpawnExec], depth: usize, top_n: usize) {
+    println!("{}", section(format_args!("Input Bytes by Directory (depth {})", depth)));
+
+    let mut dirs: HashMap<String, DirStats> = HashMap::new();
+    for file in spawns.iter().flat_map(|s| &s.inputs) {
+        let stats = dirs.entry(input_dir(&paths::relative(&file.path), depth)).or_default();
+        stats.references += 1;
+        if let Some(digest) = file.digest.as_ref() {
+            stats.unique.insert(digest);
+            stats.reference_bytes += digest.size_bytes;
+        }
+    }
+
+    if dirs.is_empty() {
+        println!("No input files found in the log.");
+        println!();
+        return;
+    }
+
+    let mut dirs: Vec<_> = dirs.into_iter().collect();
+    let print_table = |dirs: &[(String, DirStats)]| {
+        let mut table = Table::new(vec![
+            ("Directory".to_string(), Align::Left),
+            ("Unique Files".to_string(), Align::Right),
+            ("Unique Bytes".to_string(), Align::Right),
+            ("References".to_string(), Align::Right),
+            ("Referenced Bytes".to_string(), Align::Right),
+        ]);
+        for (dir, stats) in dirs.iter().take(top_n) {
+            table.add_row(vec![
+                dir.clone(),
+                stats.unique.len().to_string(),
+                format_bytes(stats.unique.bytes()),
+                stats.references.to_string(),
+                format_bytes(stats.reference_bytes),
+            ]);
+        }
+        table.print();
+    };
+
+    dirs.sort_by(|a, b| b.1.unique.bytes().cmp(&a.1.unique.bytes()).then(a.0.cmp(&b.0)));
+    println!("{} by Unique (Deduplicated) Bytes:", top_label(top_n));
+    print_table(&dirs);
+    println!();
+
+    dirs.sort_by(|a, b| b.1.reference_bytes.cmp(&a.1.reference_bytes).then(a.0.cmp(&b.0)));
+    println!("{} by Referenced Bytes (bytes x consuming spawns):", top_label(top_n));
+    print_table(&dirs);
+    println!();
+}
+
+/// Consumers of one distinct input path.
+struct HotInput {
+    spawns: u32,
+    /// Index of the last spawn counted, so a path listed twice by one spawn counts once.
+    last_spawn: u32,
+    size_bytes: i64,
+    /// Summed total time of the consuming spawns.
+    time: Duration,
+    /// Interned mnemonic indices with the time of their consuming spawns, sorted by index.
+    mnemonics: Vec<(u16, Duration)>,
+}
+
+/// The distinct input paths of the spawns with their consumers, which both hot input reports
+/// rank.
+///
+/// Memory grows with the number of distinct input paths: each one costs a borrowed `&str`
+/// into the parsed spawns, or a copy when --relative-paths strips a prefix from it, plus a
+/// small counter record with 24 bytes per consuming mnemonic, and mnemonic names are interned
+/// into 16-bit indices rather than stored per path.
+struct HotInputIndex<'a> {
+    mnemonic_names: Vec<&'a str>,
+    inputs: HashMap<Cow<'a, str>, HotInput>,
+}
+
+impl<'a> HotInputIndex<'a> {
+    /// Indexes the inputs of the spawns `consumer` accepts, leaving out paths under
+    /// `ignore_prefixes`.
+    fn new(
+        spawns: &'a [SpawnExec],
+        ignore_prefixes: &[String],
+        consumer: impl Fn(&SpawnExec) -> bool,
+    ) -> Self {
+        let mut mnemonic_names: Vec<&str> = Vec::new();
+        let mut mnemonic_ids: HashMap<&str, u16> = HashMap::new();
+        let mut inputs: HashMap<Cow<str>, HotInput> = HashMap::new();
+        for (index, spawn) in spawns.iter().enumerate() {
+            if !consumer(spawn) {
+                continue;
+            }
+            let index = index as u32;
+            let mnemonic = *mnemonic_ids.entry(spawn.mnemonic.as_str()).or_insert_with(|| {
+                mnemonic_names.push(spawn.mnemonic.as_str());
+                (mnemonic_names.len() - 1) as u16
+            });
+            let time = total_time(spawn);
+            for file in &spawn.inputs {
+                let path = paths::relative(&file.path);
+                if ignore_prefixes.iter().any(|p| path.starts_with(p.as_str())) {
+                    continue;
+                }
+                let entry = inputs.entry(path).or_insert_with(|| HotInput {
+                    spawns: 0,
+                    last_spawn: u32::MAX,
+                    size_bytes: file.digest.as_ref().map_or(0, |d| d.size_bytes),
+                    time: Duration::ZERO,
+                    mnemonics: Vec::new(),
+                });
+                if entry.last_spawn == index {
+                    continue;
+                }
+                entry.last_spawn = index;
+                entry.spawns += 1;
+                entry.time += time;
+                match entry.mnemonics.binary_search_by_key(&mnemonic, |&(id, _)| id) {
+                    Ok(pos) => entry.mnemonics[pos].1 += time,
+                    Err(pos) => entry.mnemonics.insert(pos, (mnemonic, time)),
+                }
+            }
+        }
+        HotInputIndex {
+            mnemonic_names,
+            inputs,
+        }
+    }
+}
+
+/// Lists the input paths consumed by the most distinct spawns.
+pub fn print_hot_inputs_report(spawns: &[SpawnExec], ignore_prefixes: &[String], top_n: usize) {
+    println!(
+        "{}",
+        section(format_args!(
+            "{} Hot Inputs (Most Consuming Spawns)",
+            top_label(top_n)
+        ))
+    );
+
+    let HotInputIndex {
+        mnemonic_names,
+        inputs,
+    } = HotInputIndex::new(spawns, ignore_prefixes, |_| true);
+    if inputs.is_empty() {
+        println!("No input files found in the log.");
+        println!();
+        return;
+    }
+
+    let mut inputs: Vec<_> = inputs.into_iter().collect();
+    inputs.sort_by(|a, b| b.1.spawns.cmp(&a.1.spawns).then(a.0.cmp(&b.0)));
+    let mut table = Table::new(vec![
+        ("Spawns".to_string(), Align::Right),
+        ("% of Spawns".to_string(), Align::Right),
+        ("Size".to_string(), Align::Right),
+        ("Path".to_string(), Align::Left),
+    ]);
+    let mut consumers = Vec::new();
+    for (path, input) in inputs.into_iter().take(top_n) {
+        table.add_row(vec![
+            input.spawns.to_string(),
+            format!("{:.1}%", input.spawns as f64 / spawns.len() as f64 * 100.0),
+            format_bytes(input.size_bytes),
+            path.into_owned(),
+        ]);
+        let mut names: Vec<&str> = input
+            .mnemonics
+            .iter()
+            .map(|&(m, _)| mnemonic_names[m as usize])
+            .collect();
+        names.sort_unstable();
+        consumers.push(names.join(", "));
+    }
+    table.print();
+    println!("Consuming Mnemonics:");
+    for (i, names) in consumers.iter().enumerate() {
+        println!("  {:>2}. {}", i + 1, names);
+    }
+    println!();
+}
+
+/// Lists the input paths by the summed time of the spawns consuming them, which approximates
+/// what changing the file costs to rebuild. With `misses_only` cache hits are not counted as
+/// consumers, as they would not have executed either.
+///
+/// Memory is that of [`print_hot_inputs_report`], from the same index.
+pub fn print_weighted_hot_inputs_report(
+    spawns: &[SpawnExec],
+    ignore_prefixes: &[String],
+    misses_only: bool,
+    top_n: usize,
+) {
+    println!(
+        "{}",
+        section(format_args!(
+            "{} Hot Inputs by Consuming Spawn Time{}",
+            top_label(top_n),
+            if misses_only { " (Cache Misses)" } else { "" }
+        ))
+    );
+
+    let consumer = |spawn: &SpawnExec| !misses_only || !is_cache_hit(spawn);
+    let HotInputIndex {
+        mnemonic_names,
+        inputs,
+    } = HotInputIndex::new(spawns, ignore_prefixes, consumer);
+    if inputs.is_empty() {
+        if misses_only {
+            println!("No input files of cache misses found in the log.");
+        } else {
+            println!("No input files found in the log.");
+        }
+        println!();
+        return;
+    }
+
+    let consumer_time: Duration = spawns.iter().filter(|s| consumer(s)).map(total_time).sum();
+    let mut inputs: Vec<_> = inputs.into_iter().collect();
+    inputs.sort_by(|a, b| b.1.time.cmp(&a.1.time).then(a.0.cmp(&b.0)));
+    let mut table = Table::new(vec![
+        ("Consumer Time".to_string(), Align::Right),
+        ("% of Spawn Time".to_string(), Align::Right),
+        ("Consumers".to_string(), Align::Right),
+        ("Size".to_string(), Align::Right),
+        ("Dominant Mnemonic".to_string(), Align::Left),
+        ("Path".to_string(), Align::Left),
+    ]);
+    for (path, input) in inputs.into_iter().take(top_n) {
+        let dominant = input
+            .mnemonics
+            .iter()
+            .max_by(|a, b| {
+                a.1.cmp(&b.1)
+                    .then_with(|| mnemonic_names[b.0 as usize].cmp(mnemonic_names[a.0 as usize]))
+            })
+            .map_or(String::new(), |&(m, time)| {
+                format!(
+                    "{} ({:.0}%)",
+                    mnemonic_names[m as usize],
+                    percent(time, input.time)
+                )
+            });
+        table.add_row(vec![
+            format_duration(input.time, 1),
+            format!("{:.1}%", percent(input.time, consumer_time)),
+            input.spawns.to_string(),
+            format_bytes(input.size_bytes),
+            dominant,
+            path.into_owned(),
+        ]);
+    }
+    table.print();
+    println!("Note: Consumer time is the summed total time of the spawns that read the input, roughly what a change to it costs to rebuild; spawns reading many inputs count toward each.");
+    println!();
+}
+
+fn percent(part: Duration, whole: Duration) -> f64 {
+    if whole.is_zero() {
+        0.0
+    } else {
+        part.as_secs_f64() / whole.as_secs_f64() * 100.0
+    }
+}
+
+/// Pairwise overlap of the deduplicated input digests of the mnemonics with the most input bytes.
+pub fn print_input_overlap_report(spawns: &[SpawnExec], max_mnemonics: usize) {
+    println!(
+        "{}",
+        section(format_args!(
+            "Input Overlap Between Mnemonics (Top {} by Input Bytes)",
+            max_mnemonics
+        ))
+    );
+
+    let mut by_mnemonic: HashMap<&str, DigestSet> = HashMap::new();
+    for spawn in spawns {
+        let set = by_mnemonic.entry(spawn.mnemonic.as_str()).or_default();
+        for digest in spawn.inputs.iter().filter_map(|f| f.digest.as_ref()) {
+            set.insert(digest);
+        }
+    }
+    let mut mnemonics: Vec<_> = by_mnemonic
+        .into_iter()
+        .filter(|(_, set)| !set.is_empty())
+        .collect();
+    if mnemonics.len() < 2 {
+        println!("Fewer than two mnemonics have input digests; nothing to compare.");
+        println!();
+        return;
+    }
+    mnemonics.sort_by(|a, b| b.1.bytes().cmp(&a.1.bytes()).then(a.0.cmp(b.0)));
+    mnemonics.truncate(max_mnemonics);
+
+    let mut pairs = Vec::new();
+    for (i, (a, a_set)) in mnemonics.iter().enumerate() {
+        for (b, b_set) in &mnemonics[i + 1..] {
+            let (files, bytes) = a_set.overlap(b_set);
+            if files > 0 {
+                pairs.push((*a, a_set.bytes(), *b, b_set.bytes(), files, bytes));
+            }
+        }
+    }
+    if pairs.is_empty() {
+        println!("No shared input digests between the compared mnemonics.");
+        println!();
+        return;
+    }
+    pairs.sort_by(|x, y| y.5.cmp(&x.5).then(x.0.cmp(y.0)).then(x.2.cmp(y.2)));
+
+    let mut table = Table::new(vec![
+        ("Mnemonic A".to_string(), Align::Left),
+        ("Mnemonic B".to_string(), Align::Left),
+        ("Shared Files".to_string(), Align::Right),
+        ("Shared Bytes".to_string(), Align::Right),
+        ("% of A".to_string(), Align::Right),
+        ("% of B".to_string(), Align::Right),
+    ]);
+    for (a, a_bytes, b, b_bytes, files, bytes) in pairs {
+        let share = |total: i64| {
+            if total > 0 {
+                format!("{:.1}%", bytes as f64 / total as f64 * 100.0)
+            } else {
+                "N/A".to_string()
+            }
+        };
+        table.add_row(vec![
+            a.to_string(),
+            b.to_string(),
+            files.to_string(),
+            format_bytes(bytes),
+            share(a_bytes),
+            share(b_bytes),
+        ]);
+    }
+    table.print();
+    println!("Note: Overlap is computed on content digests, so renamed copies of a file still match.");
+    println!();
+}
@@ -0,0 +1,353 @@
+//! Heuristic findings drawn from the aggregated metrics.
+//!
+//! Each rule looks at one pattern that usually explains a slow build and returns a short
+//! finding with its supporting numbers, or nothing when the pattern is absent.
+
+use crate::classify::{is_cache_hit, is_failed};
+use crate::format::{format_duration, format_rate, section};
+use crate::metrics::{output_bytes, phase_duration, recorded_total_time, Phase};
+use crate::proto::SpawnExec;
+use std::collections::HashMap;
+use std::time::Duration;
+
+/// A mnemonic is flagged for poor caching below this hit rate...
+const LOW_HIT_RATE: f64 = 0.25;
+/// ...when it accounts for at least this share of the recorded time.
+const LOW_HIT_MIN_SHARE: f64 = 0.20;
+
+/// Remote cache downloads slower than this (in bytes per second, 20 MB/s) are flagged.
+const SLOW_FETCH_RATE: f64 = 20e6;
+/// Fetch rates over less total fetch time than this are too noisy to judge.
+const MIN_FETCH_TIME: Duration = Duration::from_secs(10);
+
+/// Queue time above this share of the recorded time points at a saturated executor pool.
+const HIGH_QUEUE_SHARE: f64 = 0.25;
+
+/// Non-cacheable spawns are flagged when they take at least this share of the time.
+const UNCACHEABLE_MIN_SHARE: f64 = 0.05;
+
+/// Failed spawns are flagged when they take at least this share of the time.
+const FAILED_MIN_SHARE: f64 = 0.05;
+
+/// Below this share of spawns with timing data, every time-based number is suspect.
+const MIN_TIMED_SHARE: f64 = 0.80;
+
+#[derive(Default)]
+struct MnemonicTotals {
+    count: u64,
+    hits: u64,
+    time: Duration,
+}
+
+/// The aggregates the rules draw from, computed in one pass over the spawns.
+#[derive(Default)]
+struct Aggregates<'a> {
+    spawns: u64,
+    timed: u64,
+    total_time: Duration,
+    mnemonics: HashMap<&'a str, MnemonicTotals>,
+    remote_hits: u64,
+    fetch_bytes: i64,
+    fetch_time: Duration,
+    queue_time: Duration,
+    uncacheable: u64,
+    uncacheable_time: Duration,
+    any_cacheable: bool,
+    failed: u64,
+    failed_time: Duration,
+}
+
+impl<'a> Aggregates<'a> {
+    fn from_spawns(spawns: &'a [SpawnExec]) -> Self {
+        let mut totals = Aggregates::default();
+        for spawn in spawns {
+            let time = recorded_total_time(spawn);
+            let duration = time.unwrap_or_default();
+            totals.spawns += 1;
+            totals.timed += time.is_some() as u64;
+            totals.total_time += duration;
+
+            let mnemonic = totals.mnemonics.entry(spawn.mnemonic.as_str()).or_default();
+            mnemonic.count += 1;
+            mnemonic.hits += is_cache_hit(spawn) as u64;
+            mnemonic.time += duration;
+
+            if let Some(metrics) = &spawn.metrics {
+                if spawn.runner == "remote cache hit" {
+                    totals.remote_hits += 1;
+                    totals.fetch_bytes += output_bytes(spawn);
+                    totals.fetch_time += phase_duration(metrics, Phase::Fetch).unwrap_or_default();
+                }
+                totals.queue_time += phase_duration(metrics, Phase::Queue).unwrap_or_default();
+            }
+            totals.any_cacheable |= spawn.cacheable;
+            if !spawn.cacheable {
+                totals.uncacheable += 1;
+                totals.uncacheable_time += duration;
+            }
+            if is_failed(spawn) {
+                totals.failed += 1;
+                totals.failed_time += duration;
+            }
+        }
+        totals
+    }
+
+    fn share(&self, time: Duration) -> f64 {
+        if self.total_time.is_zero() {
+            0.0
+        } else {
+            time.as_secs_f64() / self.total_time.as_secs_f64()
+        }
+    }
+}
+
+fn poorly_cached_mnemonics(totals: &Aggregates) -> Option<String> {
+    let mut flagged: Vec<(&str, f64, f64)> = totals
+        .mnemonics
+        .iter()
+        .map(|(name, m)| (*name, m.hits as f64 / m.count as f64, totals.share(m.time)))
+        .filter(|(_, hit_rate, share)| *hit_rate < LOW_HIT_RATE && *share >= LOW_HIT_MIN_SHARE)
+        .collect();
+    flagged.sort_by(|a, b| b.2.total_cmp(&a.2).then(a.0.cmp(b.0)));
+    let findings: Vec<String> = flagged
+        .iter()
+        .map(|(name, hit_rate, share)| {
+            format!(
+                "{} has a {:.0}% cache hit rate but accounts for {:.0}% of recorded time",
+                name,
+                hit_rate * 100.0,
+                share * 100.0
+            )
+        })
+        .collect();
+    (!findings.is_empty()).then(|| findings.join("; "))
+}
+
+fn slow_cache_fetches(totals: &Aggregates) -> Option<String> {
+    if totals.fetch_time < MIN_FETCH_TIME {
+        return None;
+    }
+    let rate = totals.fetch_bytes as f64 / totals.fetch_time.as_secs_f64();
+    (rate < SLOW_FETCH_RATE).then(|| {
+        format!(
+            "Remote cache hits download at {} on average ({} hits, {} fetching), below the {} a healthy cache connection sustains",
+            format_rate(rate),
+            totals.remote_hits,
+            format_duration(totals.fetch_time, 1),
+            format_rate(SLOW_FETCH_RATE)
+        )
+    })
+}
+
+fn high_queue_time(totals: &Aggregates) -> Option<String> {
+    let share = totals.share(totals.queue_time);
+    (share >= HIGH_QUEUE_SHARE).then(|| {
+        format!(
+            "{:.0}% of recorded time ({}) is queue time; the remote executor pool is likely under-provisioned",
+            share * 100.0,
+            format_duration(totals.queue_time, 1))
+    })
+}
+
+fn costly_uncacheable(totals: &Aggregates) -> Option<String> {
+    // A log where nothing is cacheable most likely predates the field.
+    if !totals.any_cacheable {
+        return None;
+    }
+    let share = totals.share(totals.uncacheable_time);
+    (share >= UNCACHEABLE_MIN_SHARE).then(|| {
+        format!(
+            "{} actions are not cacheable (e.g. tagged no-cache) and cost {:.1} minutes ({:.0}% of recorded time); see --uncacheable",
+            totals.uncacheable,
+            totals.uncacheable_time.as_secs_f64() / 60.0,
+            share * 100.0
+        )
+    })
+}
+
+fn costly_failures(totals: &Aggregates) -> Option<String> {
+    let share = totals.share(totals.failed_time);
+    (share >= FAILED_MIN_SHARE).then(|| {
+        format!(
+            "{} failed actions took {} ({:.0}% of recorded time) that produced nothing",
+            totals.failed,
+            format_duration(totals.failed_time, 1),
+            share * 100.0
+        )
+    })
+}
+
+fn sparse_timing_data(totals: &Aggregates) -> Option<String> {
+    let share = totals.timed as f64 / totals.spawns as f64;
+    (share < MIN_TIMED_SHARE).then(|| {
+        format!(
+            "Only {:.0}% of spawns recorded a total time, so every time-based figure understates the build; see --metrics-coverage",
+            share * 100.0
+        )
+    })
+}
+
+/// The rules with the stable ids their findings carry, in the order the findings are printed.
+const RULES: [(&str, fn(&Aggregates) -> Option<String>); 6] = [
+    ("sparse-timing-data", sparse_timing_data),
+    ("poorly-cached-mnemonics", poorly_cached_mnemonics),
+    ("high-queue-time", high_queue_time),
+    ("slow-cache-fetches", slow_cache_fetches),
+    ("costly-uncacheable", costly_uncacheable),
+    ("costly-failures", costly_failures),
+];
+
+/// A finding of one rule.
+pub struct Insight {
+    /// Names the rule, e.g. `high-queue-time`, so that CI can track a finding across builds.
+    pub id: &'static str,
+    pub message: String,
+}
+
+/// Returns the findings of every rule that fired.
+pub fn insights(spawns: &[SpawnExec]) -> Vec<Insight> {
+    let totals = Aggregates::from_spawns(spawns);
+    if totals.spawns == 0 {
+        return Vec::new();
+    }
+    RULES
+        .iter()
+        .filter_map(|(id, rule)| Some(Insight { id, message: rule(&totals)? }))
+        .collect()
+}
+
+pub fn print_insights_report(spawns: &[SpawnExec]) {
+    println!("{}", section("Insights (Heuristic)"));
+    let findings = insights(spawns);
+    if findings.is_empty() {
+        println!("No common problem pattern found.");
+    }
+    for finding in &findings {
+        println!("  - {}", finding.message);
+    }
+    println!("Note: These findings come from fixed thresholds over the aggregated metrics; treat them as leads, not conclusions.");
+    println!();
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::proto::{Digest, File};
+    use crate::testing::{duration, spawn, spawns};
+
+    fn cacheable(mnemonic: &str, runner: &str, millis: u64) -> SpawnExec {
+        SpawnExec {
+            cacheable: true,
+            ..spawn(mnemonic, runner, millis)
+        }
+    }
+
+    fn fire(rule: fn(&Aggregates) -> Option<String>, spawns: &[SpawnExec]) -> Option<String> {
+        rule(&Aggregates::from_spawns(spawns))
+    }
+
+    /// A remote cache hit downloading `bytes` in `fetch_millis`.
+    fn fetched(bytes: i64, fetch_millis: u64) -> SpawnExec {
+        let mut hit = cacheable("Javac", "remote cache hit", fetch_millis);
+        hit.metrics.as_mut().unwrap().fetch_time = duration(fetch_millis);
+        hit.actual_outputs = vec![File {
+            path: "out.jar".to_string(),
+            digest: Some(Digest {
+                size_bytes: bytes,
+                ..Default::default()
+            }),
+            ..Default::default()
+        }];
+        hit
+    }
+
+    #[test]
+    fn mnemonics_with_few_hits_and_much_time_are_flagged() {
+        let log = [
+            spawns(10, "Javac", "linux-sandbox", 1_000),
+            spawns(10, "Genrule", "disk cache hit", 1_000),
+            spawns(10, "GoLink", "local", 10),
+        ]
+        .concat();
+        assert_eq!(
+            fire(poorly_cached_mnemonics, &log).as_deref(),
+            Some("Javac has a 0% cache hit rate but accounts for 50% of recorded time")
+        );
+    }
+
+    #[test]
+    fn slow_fetches_are_flagged_over_enough_fetch_time() {
+        let slow = fire(slow_cache_fetches, &[fetched(1_000_000, 20_000)]).unwrap();
+        assert!(slow.contains("(1 hits, 20.0s fetching)"), "{}", slow);
+        assert_eq!(fire(slow_cache_fetches, &[fetched(1_000_000, 5_000)]), None);
+        assert_eq!(fire(slow_cache_fetches, &[fetched(1_000_000_000, 20_000)]), None);
+    }
+
+    #[test]
+    fn queue_time_over_a_quarter_is_flagged() {
+        let mut queued = spawn("CppCompile", "remote", 10_000);
+        queued.metrics.as_mut().unwrap().queue_time = duration(5_000);
+        let finding = fire(high_queue_time, &[queued.clone()]).unwrap();
+        assert!(finding.starts_with("50% of recorded time"), "{}", finding);
+        queued.metrics.as_mut().unwrap().queue_time = duration(2_000);
+        assert_eq!(fire(high_queue_time, &[queued]), None);
+    }
+
+    #[test]
+    fn uncacheable_time_is_flagged_unless_the_log_predates_the_field() {
+        let log = [
+            cacheable("Javac", "linux-sandbox", 9_000),
+            spawn("Genrule", "local", 60_000),
+        ];
+        let finding = fire(costly_uncacheable, &log).unwrap();
+        assert!(finding.starts_with("1 actions are not cacheable"), "{}", finding);
+        assert!(finding.contains("cost 1.0 minutes (87% of recorded time)"), "{}", finding);
+        assert_eq!(fire(costly_uncacheable, &spawns(2, "Genrule", "local", 60_000)), None);
+    }
+
+    #[test]
+    fn failed_time_is_flagged() {
+        let failed = SpawnExec {
+            exit_code: 1,
+            ..spawn("Javac", "local", 1_000)
+        };
+        let log = [failed, spawn("Javac", "local", 9_000)];
+        let finding = fire(costly_failures, &log).unwrap();
+        assert!(finding.starts_with("1 failed actions took"), "{}", finding);
+        assert_eq!(fire(costly_failures, &log[1..]), None);
+    }
+
+    #[test]
+    fn sparse_timing_data_is_flagged() {
+        let mut log = spawns(4, "Javac", "local", 1_000);
+        log.push(SpawnExec {
+            metrics: None,
+            ..spawn("Javac", "local", 0)
+        });
+        assert_eq!(fire(sparse_timing_data, &log), None);
+        log[0].metrics = None;
+        assert_eq!(
+            fire(sparse_timing_data, &log).as_deref(),
+            Some("Only 60% of spawns recorded a total time, so every time-based figure understates the build; see --metrics-coverage")
+        );
+    }
+
+    #[test]
+    fn findings_carry_the_id_of_their_rule() {
+        let failed = SpawnExec {
+            exit_code: 1,
+            ..spawn("Javac", "local", 1_000)
+        };
+        let ids: Vec<&str> = insights(&[failed, spawn("Javac", "disk cache hit", 9_000)])
+            .iter()
+            .map(|finding| finding.id)
+            .collect();
+        assert_eq!(ids, ["costly-failures"]);
+    }
+
+    #[test]
+    fn an_empty_log_has_no_findings() {
+        assert!(insights(&[]).is_empty());
+    }
+}
@@ -0,0 +1,1051 @@
+//! The --output-format json document: the headline numbers and mnemonics of the text report,
+//! and with --include-spawns the spawns themselves, for dashboards and scripts.
+
+use crate::classify::{
+    classify_runner, is_cache_hit, is_failed, is_timeout, runner_label, RunnerKind,
+};
+use crate::cli::{Cli, GroupKey, LabelPattern, SpawnColumn};
+use crate::analysis::{mnemonic_metrics, LogTotals};
+use crate::filter::FilterSummary;
+use crate::format::format_timestamp;
+use crate::metrics::{
+    nanos, output_bytes, phase_duration, recorded_total_time, start_time, total_time, Phase,
+    PhaseTotals, WallClockSpan,
+};
+use crate::parse_stats::{self, Diagnostics};
+use crate::proto::SpawnExec;
+use crate::report::{mnemonic_rows, MnemonicRow, Renderer, Report};
+use crate::reports::cache::{
+    fetches_by_mnemonic, savings_estimate, top_downloads, SavingsEstimate,
+};
+use crate::reports::ci_summary::CiSummary;
+use crate::reports::concurrency::{concurrency_series, idle_gaps, untimed_spawns};
+use crate::reports::explain::{cache_status, explain, test_shard};
+use crate::reports::failures::{failed_actions, FAILED_ACTION_PHASES};
+use crate::reports::grouping::{group_rows, KeyOptions};
+use crate::reports::hashing::{digest_usage, DigestUsage};
+use crate::reports::inputs::{data_volume, DataVolume};
+use crate::reports::insights::insights;
+use crate::reports::listing::SpawnRecord;
+use crate::reports::slowest::{top_per_mnemonic, SlowestOfMnemonic};
+use crate::schema::SCHEMA_VERSION;
+use crate::stats::{histogram, DurationPercentiles};
+use serde::ser::{SerializeMap, SerializeSeq, Serializer};
+use serde::Serialize;
+use std::borrow::Cow;
+use std::collections::HashMap;
+use std::io::{self, Write};
+use std::time::Duration;
+
+/// Headline numbers of one log or mnemonic, shared by the report, `diff`, `compare`, `trend`
+/// and the --serve endpoints.
+#[derive(Serialize)]
+pub struct TotalsJson {
+    pub actions: u64,
+    pub cache_hits: u64,
+    pub cache_hit_rate_percent: f64,
+    pub total_time_nanos: u64,
+    pub downloaded_bytes: i64,
+}
+
+impl From<&LogTotals> for TotalsJson {
+    fn from(totals: &LogTotals) -> Self {
+        TotalsJson {
+            actions: totals.actions,
+            cache_hits: totals.cache_hits,
+            cache_hit_rate_percent: totals.hit_rate(),
+            total_time_nanos: nanos(totals.total_time),
+            downloaded_bytes: totals.downloaded_bytes,
+        }
+    }
+}
+
+#[derive(Serialize)]
+pub struct MnemonicJson<'a> {
+    pub mnemonic: &'a str,
+    #[serde(flatten)]
+    pub totals: TotalsJson,
+    /// Nearest-rank percentiles of the recorded total times, as --percentiles shows them, with
+    /// or without the flag; left out when no spawn of the mnemonic recorded one. Unlike the
+    /// text column, p99 is not marked when there are few samples.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub p50_nanos: Option<u64>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub p90_nanos: Option<u64>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub p99_nanos: Option<u64>,
+    /// The Min, Med and Max columns, whatever --columns shows; left out like the percentiles.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub min_nanos: Option<u64>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub median_nanos: Option<u64>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub max_nanos: Option<u64>,
+    /// The % Total and Cum % columns as unrounded fractions from 0 to 1 of the summed total
+    /// time of all spawns, cumulative over the mnemonics listed up to this one.
+    pub time_share: f64,
+    pub cumulative_time_share: f64,
+    /// The Exec % column as a fraction, whatever --columns shows: execution wall time over total
+    /// time. `null` where the column says n/a, when fewer than half of the spawns recorded both.
+    pub exec_time_fraction: Option<f64>,
+}
+
+fn fraction(part: Duration, whole: Duration) -> f64 {
+    if whole.is_zero() {
+        0.0
+    } else {
+        part.as_secs_f64() / whole.as_secs_f64()
+    }
+}
+
+impl<'a> MnemonicJson<'a> {
+    /// `cumulative` is the total time of this and the mnemonics before it.
+    fn new(
+        row: &'a MnemonicRow,
+        totals: &LogTotals,
+        grand_total: Duration,
+        cumulative: Duration,
+    ) -> Self {
+        let mut durations = row.metrics.durations.clone();
+        let distribution = DurationPercentiles::compute(&mut durations);
+        let pick = |pick: fn(&DurationPercentiles) -> Duration| {
+            distribution.as_ref().map(|p| nanos(pick(p)))
+        };
+        MnemonicJson {
+            mnemonic: &row.mnemonic,
+            totals: TotalsJson::from(totals),
+            p50_nanos: pick(|p| p.p50),
+            p90_nanos: pick(|p| p.p90),
+            p99_nanos: pick(|p| p.p99),
+            min_nanos: pick(|p| p.min),
+            median_nanos: pick(|p| p.p50),
+            max_nanos: pick(|p| p.max),
+            time_share: fraction(row.metrics.total_duration, grand_total),
+            cumulative_time_share: fraction(cumulative, grand_total),
+            exec_time_fraction: row.metrics.exec_fraction(),
+        }
+    }
+}
+
+/// The spawns as --all --listing-format jsonl writes them, serialized one by one as the
+/// document is written rather than collected first.
+pub struct SpawnsJson<'a> {
+    pub spawns: &'a [&'a SpawnExec],
+    pub columns: &'a [SpawnColumn],
+}
+
+impl Serialize for SpawnsJson<'_> {
+    fn serialize<S: Serializer>(&self, serializer: S) -> Result<S::Ok, S::Error> {
+        let mut seq = serializer.serialize_seq(Some(self.spawns.len()))?;
+        for &spawn in self.spawns {
+            seq.serialize_element(&SpawnRecord {
+                spawn,
+                columns: self.columns,
+            })?;
+        }
+        seq.end()
+    }
+}
+
+#[derive(Serialize)]
+pub struct ReportJson<'a> {
+    pub schema_version: u32,
+    pub logs: Vec<String>,
+    #[serde(flatten)]
+    pub summary: CiSummary,
+    /// Of the recorded total times; left out when no spawn recorded one.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub durations: Option<DurationsJson>,
+    /// The Wall-Clock Span line; left out when no spawn recorded a start time.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub wall_clock: Option<WallClockJson>,
+    /// Every mnemonic, in --mnemonic-sort order as in the text report, which --top-mnemonics
+    /// cuts short.
+    pub mnemonics: Vec<MnemonicJson<'a>>,
+    /// The Time by Phase section: the phases recorded by any spawn.
+    pub time_by_phase: TimeByPhaseJson,
+    /// The Digest Functions section, which the text report prints only when something is amiss.
+    pub digest_functions: DigestFunctionsJson<'a>,
+    /// With --histogram, one bucket per --histogram-buckets bound and a last unbounded one.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub histogram: Option<Vec<HistogramBucketJson>>,
+    /// With --show-args, the top actions with their whole command lines, which --args-limit
+    /// and the truncation of long arguments only shorten in the text report.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub top_actions: Option<Vec<TopActionJson<'a>>>,
+    /// One for each --group-by.
+    #[serde(skip_serializing_if = "Vec::is_empty")]
+    pub groups: Vec<GroupTableJson<'a>>,
+    /// The remote cache hits that downloaded the most bytes, with --cache-metrics.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub downloads: Option<DownloadsJson<'a>>,
+    /// Every failed spawn, longest first, as the Failed Actions section lists them up to
+    /// --max-failed; empty when none failed.
+    pub failed_actions: Vec<FailedActionJson<'a>>,
+    /// The Fetches by Mnemonic table of --cache-metrics (on by default), with the mnemonics it
+    /// folds into "(other)" listed too; left out when no spawn hit the remote cache.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub fetches: Option<Vec<MnemonicFetchesJson<'a>>>,
+    /// The estimate of what a perfect cache would have saved, with --cache-metrics (on by
+    /// default); left out when no successful cacheable spawn missed the cache.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub estimated_savings: Option<SavingsJson>,
+    /// With --data-volume.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub data_volume: Option<DataVolumeJson<'a>>,
+    /// With --top-per-mnemonic.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub top_per_mnemonic: Option<TopPerMnemonicJson<'a>>,
+    /// With --concurrency; `null` when no spawn recorded a start and total time.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub concurrency: Option<Option<ConcurrencyJson>>,
+    /// With --insights, the heuristic findings in the order the section prints them.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub insights: Option<Vec<InsightJson>>,
+    /// With --idle-gaps.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub idle_gaps: Option<IdleGapsJson<'a>>,
+    /// With --explain.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub explain: Option<ExplainJson<'a>>,
+    /// The filter flags and the whole log the report's spawns were selected from.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub filter: Option<FilterJson<'a>>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub spawns: Option<SpawnsJson<'a>>,
+    /// With --parse-stats.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub diagnostics: Option<Diagnostics>,
+}
+
+/// Each phase summed over the spawns, in raw nanoseconds against the summed total time. Phases
+/// overlap and are not always recorded, so they need not add up to it.
+#[derive(Serialize)]
+pub struct TimeByPhaseJson {
+    pub total_nanos: u64,
+    /// In phase order, those no spawn recorded left out.
+    pub phases: Vec<PhaseSumJson>,
+}
+
+#[derive(Serialize)]
+pub struct PhaseSumJson {
+    pub phase: &'static str,
+    pub nanos: u64,
+    /// Spawns that recorded the phase.
+    pub spawns: u64,
+}
+
+impl TimeByPhaseJson {
+    fn new(spawns: &[SpawnExec]) -> Self {
+        let mut totals = PhaseTotals::default();
+        for spawn in spawns {
+            totals.add(spawn);
+        }
+        TimeByPhaseJson {
+            total_nanos: nanos(totals.total_time),
+            phases: totals
+                .recorded_phases()
+                .map(|phase| PhaseSumJson {
+                    phase: phase.name(),
+                    nanos: nanos(totals.sum(phase)),
+                    spawns: totals.present(phase),
+                })
+                .collect(),
+        }
+    }
+}
+
+#[derive(Serialize)]
+pub struct DigestFunctionsJson<'a> {
+    /// By name; an unspecified function has the empty name.
+    pub functions: Vec<DigestFunctionJson<'a>>,
+    /// More than one function appears, so some cache keys can never match.
+    pub mixed: bool,
+    pub malformed_digests: u64,
+    /// The first few malformed digests.
+    pub malformed: Vec<MalformedDigestJson<'a>>,
+}
+
+#[derive(Serialize)]
+pub struct DigestFunctionJson<'a> {
+    pub function: &'a str,
+    pub digests: u64,
+    /// The paths of the first few files, as recorded.
+    pub examples: &'a [&'a str],
+}
+
+#[derive(Serialize)]
+pub struct MalformedDigestJson<'a> {
+    pub path: &'a str,
+    pub problem: &'a str,
+}
+
+impl<'a> DigestFunctionsJson<'a> {
+    fn new(usage: &'a DigestUsage<'a>) -> Self {
+        DigestFunctionsJson {
+            functions: usage
+                .functions
+                .iter()
+                .map(|(function, usage)| DigestFunctionJson {
+                    function,
+                    digests: usage.count,
+                    examples: &usage.examples,
+                })
+                .collect(),
+            mixed: usage.functions.len() > 1,
+            malformed_digests: usage.malformed_count,
+            malformed: usage
+                .malformed
+                .iter()
+                .map(|(path, problem)| MalformedDigestJson { path, problem })
+                .collect(),
+        }
+    }
+}
+
+/// The nearest-rank percentiles of the Action Durations line, in nanoseconds.
+#[derive(Serialize)]
+pub struct DurationsJson {
+    pub min_nanos: u64,
+    pub p50_nanos: u64,
+    pub p90_nanos: u64,
+    pub p95_nanos: u64,
+    pub p99_nanos: u64,
+    pub max_nanos: u64,
+}
+
+impl From<&DurationPercentiles> for DurationsJson {
+    fn from(durations: &DurationPercentiles) -> Self {
+        DurationsJson {
+            min_nanos: nanos(durations.min),
+            p50_nanos: nanos(durations.p50),
+            p90_nanos: nanos(durations.p90),
+            p95_nanos: nanos(durations.p95),
+            p99_nanos: nanos(durations.p99),
+            max_nanos: nanos(durations.max),
+        }
+    }
+}
+
+/// From the earliest spawn start to the latest spawn end.
+#[derive(Serialize)]
+pub struct WallClockJson {
+    /// RFC 3339 UTC timestamps with milliseconds, left out with --deterministic.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub first_start: Option<String>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub last_end: Option<String>,
+    pub span_nanos: u64,
+    /// False for a span of over a week, which the text report flags instead of printing.
+    pub plausible: bool,
+    /// The summed time of the spawns with a start time over the span; `null` for an empty or
+    /// implausible span.
+    pub average_concurrency: Option<f64>,
+    /// Spawns that end before they start, which only count with their start time.
+    pub negative_durations: u64,
+}
+
+impl WallClockJson {
+    fn new(span: &WallClockSpan, started_time: Duration, timestamps: bool) -> Self {
+        let wall = span.span();
+        WallClockJson {
+            first_start: timestamps.then(|| format_timestamp(span.first_start)),
+            last_end: timestamps.then(|| format_timestamp(span.last_end)),
+            span_nanos: nanos(wall),
+            plausible: span.is_plausible(),
+            average_concurrency: (span.is_plausible() && !wall.is_zero())
+                .then(|| started_time.as_secs_f64() / wall.as_secs_f64()),
+            negative_durations: span.negative_durations,
+        }
+    }
+}
+
+/// A --histogram bucket: the spawns whose total time is at most `le` seconds and above the
+/// bound of the bucket before, and their summed time.
+#[derive(Serialize)]
+pub struct HistogramBucketJson {
+    /// `null` for the last bucket, which has no upper bound.
+    pub le: Option<f64>,
+    pub count: u64,
+    pub seconds: f64,
+}
+
+fn histogram_json(spawns: &[SpawnExec], bucket_bounds: &[Duration]) -> Vec<HistogramBucketJson> {
+    let durations: Vec<Duration> = spawns.iter().filter_map(recorded_total_time).collect();
+    let mut bounds = bucket_bounds.to_vec();
+    bounds.sort();
+    bounds.dedup();
+    histogram(&durations, &bounds)
+        .into_iter()
+        .map(|bucket| HistogramBucketJson {
+            le: bucket.upper_bound.map(|bound| bound.as_secs_f64()),
+            count: bucket.count,
+            seconds: bucket.total.as_secs_f64(),
+        })
+        .collect()
+}
+
+/// A row of the top actions table with the spawn's command line.
+#[derive(Serialize)]
+pub struct TopActionJson<'a> {
+    pub label: &'a str,
+    pub mnemonic: &'a str,
+    pub total_nanos: Option<u64>,
+    /// Every argument, untruncated; param files (`@path`) are listed as given.
+    pub command_args: &'a [String],
+}
+
+impl<'a> TopActionJson<'a> {
+    fn new(spawn: &'a SpawnExec) -> Self {
+        TopActionJson {
+            label: &spawn.target_label,
+            mnemonic: &spawn.mnemonic,
+            total_nanos: recorded_total_time(spawn).map(nanos),
+            command_args: &spawn.command_args,
+        }
+    }
+}
+
+/// A --group-by table, with every row rather than the --top-n the text report shows.
+#[derive(Serialize)]
+pub struct GroupTableJson<'a> {
+    pub dimensions: Vec<&'static str>,
+    /// By total time, as in the text report.
+    pub rows: Vec<GroupRowJson<'a>>,
+}
+
+#[derive(Serialize)]
+pub struct GroupRowJson<'a> {
+    /// The value of each dimension, in the order given.
+    pub key: Vec<Cow<'a, str>>,
+    pub actions: u64,
+    pub cache_hits: u64,
+    pub cache_hit_rate_percent: f64,
+    pub total_time_nanos: u64,
+    pub miss_time_nanos: u64,
+    pub output_bytes: i64,
+}
+
+impl<'a> GroupTableJson<'a> {
+    fn new(spawns: &'a [SpawnExec], key: &GroupKey, options: &KeyOptions) -> Self {
+        GroupTableJson {
+            dimensions: key.dimensions.iter().map(|d| d.name).collect(),
+            rows: group_rows(spawns, key, options)
+                .into_iter()
+                .map(|(key, metrics)| GroupRowJson {
+                    key,
+                    actions: metrics.count,
+                    cache_hits: metrics.cache_hits,
+                    cache_hit_rate_percent: metrics.hit_rate(),
+                    total_time_nanos: nanos(metrics.total_duration),
+                    miss_time_nanos: nanos(metrics.miss_duration),
+                    output_bytes: metrics.output_bytes,
+                })
+                .collect(),
+        }
+    }
+}
+
+/// The Cache Hits by Downloaded Bytes tables: the --top-n hits, and every mnemonic.
+#[derive(Serialize)]
+pub struct DownloadsJson<'a> {
+    pub remote_cache_hits: u64,
+    pub total_bytes: i64,
+    /// Of `total_bytes`, downloaded by the hits in `top`.
+    pub top_share_percent: f64,
+    pub top: Vec<DownloadJson<'a>>,
+    /// By bytes downloaded.
+    pub mnemonics: Vec<MnemonicDownloadsJson<'a>>,
+}
+
+#[derive(Serialize)]
+pub struct DownloadJson<'a> {
+    pub label: &'a str,
+    pub mnemonic: &'a str,
+    pub bytes: i64,
+    pub fetch_time_nanos: u64,
+}
+
+#[derive(Serialize)]
+pub struct MnemonicDownloadsJson<'a> {
+    pub mnemonic: &'a str,
+    pub hits: u64,
+    pub bytes: i64,
+    pub share_percent: f64,
+    pub fetch_time_nanos: u64,
+}
+
+/// An estimate, as a range: each successful cacheable miss would have taken only the download
+/// of its outputs, at the slow or the fast download rate of this log's cache hits.
+#[derive(Serialize)]
+pub struct SavingsJson {
+    pub cacheable_misses: usize,
+    pub miss_time_nanos: u64,
+    pub miss_output_bytes: i64,
+    pub low_seconds: f64,
+    pub high_seconds: f64,
+    /// Of the total spawn time.
+    pub low_percent: f64,
+    pub high_percent: f64,
+    /// The p25 and p75 download rates of the cache hits, in bytes per second. `null` when no
+    /// hit recorded one, and both savings are the whole miss time.
+    pub slow_bytes_per_second: Option<f64>,
+    pub fast_bytes_per_second: Option<f64>,
+}
+
+impl From<SavingsEstimate> for SavingsJson {
+    fn from(estimate: SavingsEstimate) -> Self {
+        SavingsJson {
+            cacheable_misses: estimate.misses,
+            miss_time_nanos: nanos(estimate.miss_time),
+            miss_output_bytes: estimate.miss_bytes,
+            low_seconds: estimate.low,
+            high_seconds: estimate.high,
+            low_percent: estimate.share(estimate.low),
+            high_percent: estimate.share(estimate.high),
+            slow_bytes_per_second: estimate.rates.map(|(slow, _)| slow),
+            fast_bytes_per_second: estimate.rates.map(|(_, fast)| fast),
+        }
+    }
+}
+
+/// The Data Volume Summary in raw bytes. Unique figures count each (hash, size) digest once.
+#[derive(Serialize)]
+pub struct DataVolumeJson<'a> {
+    pub input_files: u64,
+    pub input_bytes: i64,
+    pub unique_input_files: usize,
+    pub unique_input_bytes: i64,
+    /// Symlinks left out.
+    pub output_files: u64,
+    pub output_bytes: i64,
+    pub unique_output_files: usize,
+    pub unique_output_bytes: i64,
+    pub median_input_bytes: i64,
+    pub median_output_bytes: i64,
+    /// `null` when no spawn lists a file.
+    pub largest_file: Option<LargestFileJson<'a>>,
+    pub symlink_outputs: usize,
+}
+
+#[derive(Serialize)]
+pub struct LargestFileJson<'a> {
+    /// As recorded.
+    pub path: &'a str,
+    pub bytes: i64,
+}
+
+impl<'a> From<DataVolume<'a>> for DataVolumeJson<'a> {
+    fn from(volume: DataVolume<'a>) -> Self {
+        DataVolumeJson {
+            input_files: volume.inputs.files,
+            input_bytes: volume.inputs.bytes,
+            unique_input_files: volume.inputs.unique.len(),
+            unique_input_bytes: volume.inputs.unique.bytes(),
+            output_files: volume.outputs.files,
+            output_bytes: volume.outputs.bytes,
+            unique_output_files: volume.outputs.unique.len(),
+            unique_output_bytes: volume.outputs.unique.bytes(),
+            median_input_bytes: volume.median_input_bytes,
+            median_output_bytes: volume.median_output_bytes,
+            largest_file: volume
+                .largest
+                .map(|(file, bytes)| LargestFileJson { path: &file.path, bytes }),
+            symlink_outputs: volume.symlink_outputs,
+        }
+    }
+}
+
+/// The remote cache hits of a mnemonic, by fetch time.
+#[derive(Serialize)]
+pub struct MnemonicFetchesJson<'a> {
+    pub mnemonic: &'a str,
+    pub hits: u64,
+    pub bytes: i64,
+    pub fetch_time_nanos: u64,
+    /// `null` for a fetch time of a millisecond or less, where the text table says N/A.
+    pub bytes_per_second: Option<f64>,
+}
+
+fn fetches_json(spawns: &[SpawnExec]) -> Option<Vec<MnemonicFetchesJson<'_>>> {
+    let fetches = fetches_by_mnemonic(spawns);
+    (!fetches.is_empty()).then(|| {
+        fetches
+            .into_iter()
+            .map(|(mnemonic, stats)| MnemonicFetchesJson {
+                mnemonic,
+                hits: stats.hits,
+                bytes: stats.bytes,
+                fetch_time_nanos: nanos(stats.fetch_time),
+                bytes_per_second: (stats.fetch_time > Duration::from_millis(1))
+                    .then(|| stats.bytes as f64 / stats.fetch_time.as_secs_f64()),
+            })
+            .collect()
+    })
+}
+
+/// The Slowest Actions per Mnemonic section: an object from each mnemonic shown to its
+/// slowest spawns, with the mnemonics in the section's order, most total time first.
+pub struct TopPerMnemonicJson<'a>(pub Vec<SlowestOfMnemonic<'a>>);
+
+#[derive(Serialize)]
+pub struct SlowActionJson<'a> {
+    pub label: &'a str,
+    pub total_nanos: u64,
+    pub cache_hit: bool,
+    /// `(unknown)` for an empty runner, as in the text report.
+    pub runner: &'a str,
+}
+
+impl Serialize for TopPerMnemonicJson<'_> {
+    fn serialize<S: Serializer>(&self, serializer: S) -> Result<S::Ok, S::Error> {
+        let mut map = serializer.serialize_map(Some(self.0.len()))?;
+        for group in &self.0 {
+            let actions: Vec<SlowActionJson> = group
+                .slowest
+                .iter()
+                .map(|&spawn| SlowActionJson {
+                    label: &spawn.target_label,
+                    total_nanos: nanos(total_time(spawn)),
+                    cache_hit: is_cache_hit(spawn),
+                    runner: runner_label(spawn),
+                })
+                .collect();
+            map.serialize_entry(group.mnemonic, &actions)?;
+        }
+        map.end()
+    }
+}
+
+impl<'a> DownloadsJson<'a> {
+    /// `None` when no spawn hit the remote cache.
+    fn new(spawns: &'a [SpawnExec], top_n: usize) -> Option<Self> {
+        let downloads = top_downloads(spawns, top_n);
+        if downloads.hits == 0 {
+            return None;
+        }
+        Some(DownloadsJson {
+            remote_cache_hits: downloads.hits as u64,
+            total_bytes: downloads.total_bytes,
+            top_share_percent: downloads.top_share(),
+            top: downloads
+                .top
+                .iter()
+                .map(|download| DownloadJson {
+                    label: &download.spawn.target_label,
+                    mnemonic: &download.spawn.mnemonic,
+                    bytes: download.bytes,
+                    fetch_time_nanos: nanos(download.fetch_time),
+                })
+                .collect(),
+            mnemonics: downloads
+                .mnemonics
+                .iter()
+                .map(|stats| MnemonicDownloadsJson {
+                    mnemonic: stats.mnemonic,
+                    hits: stats.hits,
+                    bytes: stats.bytes,
+                    share_percent: downloads.share(stats.bytes),
+                    fetch_time_nanos: nanos(stats.fetch_time),
+                })
+                .collect(),
+        })
+    }
+}
+
+/// Every gap of at least --idle-gap-min in which no spawn was running, in order.
+#[derive(Serialize)]
+pub struct InsightJson {
+    /// The rule that found it, e.g. `high-queue-time`; stable across releases.
+    pub id: &'static str,
+    pub message: String,
+}
+
+/// The Concurrency Over Time section, with the whole series it summarizes for plotting.
+#[derive(Serialize)]
+pub struct ConcurrencyJson {
+    /// --concurrency-bucket, or wider for a build too long for that many buckets.
+    pub bucket_nanos: u64,
+    /// The average number of running spawns in each bucket, from the first spawn start.
+    pub buckets: Vec<f64>,
+    pub average: f64,
+    /// Spawns running at the same instant.
+    pub peak: usize,
+    /// --concurrency-below.
+    pub below: usize,
+    /// Of the buckets, those averaging fewer than `below` running spawns.
+    pub below_percent: f64,
+    /// Spawns without a start or total time, which are not counted.
+    pub untimed_spawns: usize,
+}
+
+impl ConcurrencyJson {
+    fn new(spawns: &[SpawnExec], bucket: Duration, below: usize) -> Option<Self> {
+        let series = concurrency_series(spawns, bucket)?;
+        Some(ConcurrencyJson {
+            bucket_nanos: nanos(series.bucket),
+            average: series.average(),
+            peak: series.peak,
+            below,
+            below_percent: series.share_below(below) * 100.0,
+            untimed_spawns: untimed_spawns(spawns),
+            buckets: series.values,
+        })
+    }
+}
+
+#[derive(Serialize)]
+pub struct IdleGapsJson<'a> {
+    pub min_gap_nanos: u64,
+    /// From the first spawn start to the last spawn end.
+    pub span_nanos: u64,
+    /// Summed over `gaps`.
+    pub idle_nanos: u64,
+    pub idle_percent: f64,
+    /// Summed over every gap, however short.
+    pub total_idle_nanos: u64,
+    pub gaps: Vec<IdleGapJson<'a>>,
+}
+
+#[derive(Serialize)]
+pub struct IdleGapJson<'a> {
+    /// From the first spawn start.
+    pub offset_nanos: u64,
+    pub duration_nanos: u64,
+    pub before: GapSpawnJson<'a>,
+    pub after: GapSpawnJson<'a>,
+}
+
+/// The spawn that ended last before a gap, or the first that started after it.
+#[derive(Serialize)]
+pub struct GapSpawnJson<'a> {
+    pub label: &'a str,
+    pub mnemonic: &'a str,
+}
+
+impl<'a> GapSpawnJson<'a> {
+    fn new(spawn: &'a SpawnExec) -> Self {
+        GapSpawnJson {
+            label: &spawn.target_label,
+            mnemonic: &spawn.mnemonic,
+        }
+    }
+}
+
+/// The spawns of the targets --explain matched, in the order they ran.
+#[derive(Serialize)]
+pub struct ExplainJson<'a> {
+    pub pattern: &'a str,
+    pub targets: Vec<&'a str>,
+    /// Summed over `spawns`.
+    pub total_nanos: u64,
+    /// The part of `total_nanos` spent on cache hits.
+    pub cache_fetched_nanos: u64,
+    pub executed_nanos: u64,
+    /// The phase with the most summed time.
+    pub slowest_phase: Option<PhaseTimeJson>,
+    /// TestRunner spawns left out without --explain-shards.
+    pub test_spawns_left_out: usize,
+    pub spawns: Vec<ExplainSpawnJson<'a>>,
+}
+
+#[derive(Serialize)]
+pub struct ExplainSpawnJson<'a> {
+    pub label: &'a str,
+    pub mnemonic: &'a str,
+    pub runner: &'a str,
+    /// `remote cache hit`, `disk cache hit`, `cache hit`, `miss` or `not cacheable`.
+    pub cache: &'static str,
+    pub failed: bool,
+    /// From the first start among `spawns`.
+    pub start_offset_nanos: Option<u64>,
+    pub total_nanos: Option<u64>,
+    /// The recorded phases, in phase order.
+    pub phases: Vec<PhaseTimeJson>,
+    pub input_count: usize,
+    pub output_bytes: i64,
+    /// E.g. `shard 2/4`, for TestRunner spawns.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub shard: Option<String>,
+}
+
+#[derive(Serialize)]
+pub struct PhaseTimeJson {
+    /// As the text report names it, e.g. `Process Outputs`.
+    pub phase: &'static str,
+    pub nanos: u64,
+}
+
+impl<'a> ExplainJson<'a> {
+    fn new(spawns: &'a [SpawnExec], pattern: &'a LabelPattern, shards: bool) -> Self {
+        let explanation = explain(spawns, pattern, shards);
+        ExplainJson {
+            pattern: &pattern.text,
+            total_nanos: nanos(explanation.total),
+            cache_fetched_nanos: nanos(explanation.cache_fetched),
+            executed_nanos: nanos(explanation.executed()),
+            slowest_phase: explanation.slowest_phase().map(|(phase, time)| PhaseTimeJson {
+                phase: phase.name(),
+                nanos: nanos(time),
+            }),
+            test_spawns_left_out: explanation.tests_left_out,
+            spawns: explanation
+                .spawns
+                .iter()
+                .map(|&spawn| ExplainSpawnJson {
+                    label: &spawn.target_label,
+                    mnemonic: &spawn.mnemonic,
+                    runner: &spawn.runner,
+                    cache: cache_status(spawn),
+                    failed: is_failed(spawn),
+                    start_offset_nanos: start_time(spawn)
+                        .zip(explanation.first_start)
+                        .map(|(start, first)| nanos(start - first)),
+                    total_nanos: recorded_total_time(spawn).map(nanos),
+                    phases: spawn
+                        .metrics
+                        .iter()
+                        .flat_map(|metrics| {
+                            Phase::ALL.into_iter().filter_map(|phase| {
+                                Some(PhaseTimeJson {
+                                    phase: phase.name(),
+                                    nanos: nanos(phase_duration(metrics, phase)?),
+                                })
+                            })
+                        })
+                        .collect(),
+                    input_count: spawn.inputs.len(),
+                    output_bytes: output_bytes(spawn),
+                    shard: test_shard(spawn),
+                })
+                .collect(),
+            targets: explanation.targets,
+        }
+    }
+}
+
+impl<'a> IdleGapsJson<'a> {
+    fn new(spawns: &'a [SpawnExec], min_gap: Duration) -> Option<Self> {
+        let idle = idle_gaps(spawns, min_gap)?;
+        Some(IdleGapsJson {
+            min_gap_nanos: nanos(min_gap),
+            span_nanos: nanos(idle.span),
+            idle_nanos: nanos(idle.idle),
+            idle_percent: idle.idle_percent(),
+            total_idle_nanos: nanos(idle.total_idle),
+            gaps: idle
+                .gaps
+                .iter()
+                .map(|gap| IdleGapJson {
+                    offset_nanos: nanos(gap.offset),
+                    duration_nanos: nanos(gap.duration),
+                    before: GapSpawnJson::new(gap.before),
+                    after: GapSpawnJson::new(gap.after),
+                })
+                .collect(),
+        })
+    }
+}
+
+#[derive(Serialize)]
+pub struct FailedActionJson<'a> {
+    pub label: &'a str,
+    pub mnemonic: &'a str,
+    pub exit_code: i32,
+    /// As recorded, e.g. `NON_ZERO_EXIT` or `TIMEOUT`; empty for a non-zero exit code alone.
+    pub status: &'a str,
+    pub timeout: bool,
+    pub runner: &'a str,
+    pub remote: bool,
+    pub total_nanos: u64,
+    /// The queue, setup, execution and fetch times the section shows, those recorded.
+    pub phases: Vec<PhaseTimeJson>,
+}
+
+impl<'a> FailedActionJson<'a> {
+    fn new(spawn: &'a SpawnExec) -> Self {
+        FailedActionJson {
+            label: &spawn.target_label,
+            mnemonic: &spawn.mnemonic,
+            exit_code: spawn.exit_code,
+            status: &spawn.status,
+            timeout: is_timeout(spawn),
+            runner: &spawn.runner,
+            remote: classify_runner(&spawn.runner) == RunnerKind::Remote,
+            total_nanos: nanos(total_time(spawn)),
+            phases: spawn
+                .metrics
+                .iter()
+                .flat_map(|metrics| {
+                    FAILED_ACTION_PHASES.into_iter().filter_map(|phase| {
+                        Some(PhaseTimeJson {
+                            phase: phase.name(),
+                            nanos: nanos(phase_duration(metrics, phase)?),
+                        })
+                    })
+                })
+                .collect(),
+        }
+    }
+}
+
+/// The filtered subset beside the whole log, with hit rates from 0 to 1 as in the summary.
+#[derive(Serialize)]
+pub struct FilterJson<'a> {
+    pub predicates: &'a [&'static str],
+    pub actions: u64,
+    pub total_actions: u64,
+    pub spawn_seconds: f64,
+    pub total_spawn_seconds: f64,
+    pub hit_rate: f64,
+    pub total_hit_rate: f64,
+    /// Deprecated, always 0: spawns are no longer left out for an unrecorded field. See
+    /// `guessed_actions`.
+    pub unknown_actions: u64,
+    /// Matching spawns whose match assumes an unrecorded field is false.
+    pub guessed_actions: u64,
+    /// The filtered fields no spawn of the log sets.
+    pub unrecorded_fields: &'a [&'static str],
+}
+
+impl<'a> FilterJson<'a> {
+    fn new(filter: &'a FilterSummary, summary: &CiSummary) -> Self {
+        let total_actions = filter.total_actions as u64;
+        FilterJson {
+            predicates: &filter.predicates,
+            actions: summary.actions,
+            total_actions,
+            spawn_seconds: summary.spawn_seconds,
+            total_spawn_seconds: filter.total_duration.as_secs_f64(),
+            hit_rate: summary.hit_rate,
+            total_hit_rate: if total_actions == 0 {
+                0.0
+            } else {
+                filter.total_cache_hits as f64 / total_actions as f64
+            },
+            unknown_actions: 0,
+            guessed_actions: filter.guessed_actions as u64,
+            unrecorded_fields: &filter.unrecorded_fields,
+        }
+    }
+}
+
+/// Renders the report as the document, with the spawns --include-spawns asks for, in --sort-by
+/// order.
+pub struct JsonRenderer<'a> {
+    pub args: &'a Cli,
+}
+
+impl Renderer for JsonRenderer<'_> {
+    fn render(&self, report: &Report, out: &mut dyn Write) -> io::Result<()> {
+        let mut by_mnemonic: HashMap<&str, LogTotals> = HashMap::new();
+        for spawn in report.spawns {
+            by_mnemonic.entry(&spawn.mnemonic).or_default().add(spawn);
+        }
+        let rows = mnemonic_rows(mnemonic_metrics(report.spawns), self.args);
+        let included = self
+            .args
+            .include_spawns
+            .map(|count| &report.ranked[..count.min(report.ranked.len())]);
+        let summary = CiSummary::from_spawns(report.spawns);
+        let digests = digest_usage(report.spawns);
+        let key_options = KeyOptions::from_cli(self.args);
+        let document = ReportJson {
+            schema_version: SCHEMA_VERSION,
+            logs: report.logs.clone(),
+            downloads: self
+                .args
+                .cache_metrics
+                .then(|| DownloadsJson::new(report.spawns, self.args.top_n))
+                .flatten(),
+            failed_actions: failed_actions(report.spawns)
+                .into_iter()
+                .map(FailedActionJson::new)
+                .collect(),
+            fetches: self
+                .args
+                .cache_metrics
+                .then(|| fetches_json(report.spawns))
+                .flatten(),
+            estimated_savings: self
+                .args
+                .cache_metrics
+                .then(|| savings_estimate(report.spawns).map(SavingsJson::from))
+                .flatten(),
+            data_volume: self
+                .args
+                .data_volume
+                .then(|| DataVolumeJson::from(data_volume(report.spawns))),
+            top_per_mnemonic: self.args.top_per_mnemonic.map(|per_mnemonic| {
+                TopPerMnemonicJson(top_per_mnemonic(report.spawns, per_mnemonic, self.args.top_n).0)
+            }),
+            concurrency: self.args.concurrency.then(|| {
+                ConcurrencyJson::new(
+                    report.spawns,
+                    self.args.concurrency_bucket,
+                    self.args.concurrency_below,
+                )
+            }),
+            insights: self.args.insights.then(|| {
+                insights(report.spawns)
+                    .into_iter()
+                    .map(|finding| InsightJson { id: finding.id, message: finding.message })
+                    .collect()
+            }),
+            idle_gaps: self
+                .args
+                .idle_gaps
+                .then(|| IdleGapsJson::new(report.spawns, self.args.idle_gap_min))
+                .flatten(),
+            explain: self
+                .args
+                .explain
+                .as_ref()
+                .map(|pattern| ExplainJson::new(report.spawns, pattern, self.args.explain_shards)),
+            filter: report
+                .filter
+                .map(|filter| FilterJson::new(filter, &summary)),
+            summary,
+            durations: report.durations.as_ref().map(DurationsJson::from),
+            wall_clock: report.wall_clock.as_ref().map(|span| {
+                WallClockJson::new(span, report.started_time, !self.args.deterministic)
+            }),
+            time_by_phase: TimeByPhaseJson::new(report.spawns),
+            digest_functions: DigestFunctionsJson::new(&digests),
+            histogram: self
+                .args
+                .histogram
+                .then(|| histogram_json(report.spawns, &self.args.histogram_buckets)),
+            top_actions: self.args.show_args.then(|| {
+                report.ranked[..self.args.top_n.min(report.ranked.len())]
+                    .iter()
+                    .map(|&spawn| TopActionJson::new(spawn))
+                    .collect()
+            }),
+            mnemonics: rows
+                .iter()
+                .scan(Duration::ZERO, |cumulative, row| {
+                    *cumulative += row.metrics.total_duration;
+                    let totals = &by_mnemonic[row.mnemonic.as_str()];
+                    Some(MnemonicJson::new(row, totals, report.mnemonic_total, *cumulative))
+                })
+                .collect(),
+            groups: self
+                .args
+                .group_by
+                .iter()
+                .map(|key| GroupTableJson::new(report.spawns, key, &key_options))
+                .collect(),
+            spawns: included.map(|spawns| SpawnsJson {
+                spawns,
+                columns: &self.args.spawn_columns,
+            }),
+            diagnostics: (self.args.parse_stats && !self.args.deterministic)
+                .then(parse_stats::diagnostics)
+                .flatten(),
+        };
+        serde_json::to_writer_pretty(&mut *out, &document)?;
+        writeln!(out)?;
+        out.flush()
+    }
+}
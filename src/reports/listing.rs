@@ -0,0 +1,244 @@
+//! The --all listing: one line per spawn, for reading every record in a pager or with grep, or
+//! loading it elsewhere as CSV, TSV or JSON Lines.
+
+use crate::classify::{classify_runner, is_cache_hit, runner_label, RunnerKind};
+use crate::cli::{ListingFormat, SpawnColumn};
+use crate::format::{csv_field, format_bytes, format_duration};
+use crate::metrics::{output_bytes, phase_duration, total_time, Phase};
+use crate::proto::SpawnExec;
+use crate::schema::SCHEMA_VERSION;
+use serde::ser::{Serialize, SerializeMap, Serializer};
+use serde_json::Value;
+use std::io::{self, Write};
+use std::time::Duration;
+
+impl SpawnColumn {
+    /// Header of the text listing.
+    fn header(self) -> &'static str {
+        match self {
+            SpawnColumn::Label => "Label",
+            SpawnColumn::Mnemonic => "Mnemonic",
+            SpawnColumn::Runner => "Runner",
+            SpawnColumn::CacheHit => "Cache",
+            SpawnColumn::Remote => "Remote",
+            SpawnColumn::ExitCode => "Exit",
+            SpawnColumn::Duration => "Duration",
+            SpawnColumn::Queue => "Queue",
+            SpawnColumn::Fetch => "Fetch",
+            SpawnColumn::Setup => "Setup",
+            SpawnColumn::InputCount => "Inputs",
+            SpawnColumn::OutputBytes => "Output Size",
+            SpawnColumn::Digest => "Digest",
+        }
+    }
+
+    /// Field name in CSV and TSV headers and JSON Lines records.
+    pub fn key(self) -> &'static str {
+        match self {
+            SpawnColumn::Label => "label",
+            SpawnColumn::Mnemonic => "mnemonic",
+            SpawnColumn::Runner => "runner",
+            SpawnColumn::CacheHit => "cache_hit",
+            SpawnColumn::Remote => "remote",
+            SpawnColumn::ExitCode => "exit_code",
+            SpawnColumn::Duration => "total_time_nanos",
+            SpawnColumn::Queue => "queue_nanos",
+            SpawnColumn::Fetch => "fetch_nanos",
+            SpawnColumn::Setup => "setup_nanos",
+            SpawnColumn::InputCount => "input_count",
+            SpawnColumn::OutputBytes => "output_bytes",
+            SpawnColumn::Digest => "digest",
+        }
+    }
+
+    fn is_numeric(self) -> bool {
+        matches!(
+            self,
+            SpawnColumn::ExitCode
+                | SpawnColumn::Duration
+                | SpawnColumn::Queue
+                | SpawnColumn::Fetch
+                | SpawnColumn::Setup
+                | SpawnColumn::InputCount
+                | SpawnColumn::OutputBytes
+        )
+    }
+}
+
+fn phase(spawn: &SpawnExec, phase: Phase) -> Option<Duration> {
+    spawn
+        .metrics
+        .as_ref()
+        .and_then(|m| phase_duration(m, phase))
+}
+
+fn is_remote(spawn: &SpawnExec) -> bool {
+    matches!(
+        classify_runner(&spawn.runner),
+        RunnerKind::Remote | RunnerKind::RemoteCacheHit
+    )
+}
+
+fn digest(spawn: &SpawnExec) -> Option<String> {
+    spawn
+        .digest
+        .as_ref()
+        .map(|d| format!("{}/{}", d.hash, d.size_bytes))
+}
+
+/// The column's text for the aligned listing; `-` when the log did not record it.
+fn text(spawn: &SpawnExec, column: SpawnColumn) -> String {
+    let time =
+        |time: Option<Duration>| time.map_or_else(|| "-".to_string(), |t| format_duration(t, 3));
+    let flag = |set: bool, yes: &str, no: &str| if set { yes } else { no }.to_string();
+    match column {
+        SpawnColumn::Label => spawn.target_label.clone(),
+        SpawnColumn::Mnemonic => spawn.mnemonic.clone(),
+        SpawnColumn::Runner => runner_label(spawn).to_string(),
+        SpawnColumn::CacheHit => flag(is_cache_hit(spawn), "hit", "miss"),
+        SpawnColumn::Remote => flag(is_remote(spawn), "yes", "no"),
+        SpawnColumn::ExitCode => spawn.exit_code.to_string(),
+        SpawnColumn::Duration => format_duration(total_time(spawn), 3),
+        SpawnColumn::Queue => time(phase(spawn, Phase::Queue)),
+        SpawnColumn::Fetch => time(phase(spawn, Phase::Fetch)),
+        SpawnColumn::Setup => time(phase(spawn, Phase::Setup)),
+        SpawnColumn::InputCount => spawn.inputs.len().to_string(),
+        SpawnColumn::OutputBytes => format_bytes(output_bytes(spawn)),
+        SpawnColumn::Digest => digest(spawn).unwrap_or_else(|| "-".to_string()),
+    }
+}
+
+/// The column's raw value for CSV, TSV and JSON Lines; null when the log did not record it.
+fn value(spawn: &SpawnExec, column: SpawnColumn) -> Value {
+    let nanos = |time: Option<Duration>| time.map(|t| t.as_nanos() as u64).into();
+    match column {
+        SpawnColumn::Label => spawn.target_label.as_str().into(),
+        SpawnColumn::Mnemonic => spawn.mnemonic.as_str().into(),
+        SpawnColumn::Runner => spawn.runner.as_str().into(),
+        SpawnColumn::CacheHit => is_cache_hit(spawn).into(),
+        SpawnColumn::Remote => is_remote(spawn).into(),
+        SpawnColumn::ExitCode => spawn.exit_code.into(),
+        SpawnColumn::Duration => nanos(Some(total_time(spawn))),
+        SpawnColumn::Queue => nanos(phase(spawn, Phase::Queue)),
+        SpawnColumn::Fetch => nanos(phase(spawn, Phase::Fetch)),
+        SpawnColumn::Setup => nanos(phase(spawn, Phase::Setup)),
+        SpawnColumn::InputCount => spawn.inputs.len().into(),
+        SpawnColumn::OutputBytes => output_bytes(spawn).into(),
+        SpawnColumn::Digest => digest(spawn).into(),
+    }
+}
+
+/// One spawn as a JSON object: `schema_version`, then the given columns as fields, in order.
+pub struct SpawnRecord<'a> {
+    pub spawn: &'a SpawnExec,
+    pub columns: &'a [SpawnColumn],
+}
+
+impl Serialize for SpawnRecord<'_> {
+    fn serialize<S: Serializer>(&self, serializer: S) -> Result<S::Ok, S::Error> {
+        let mut map = serializer.serialize_map(Some(self.columns.len() + 1))?;
+        map.serialize_entry("schema_version", &SCHEMA_VERSION)?;
+        for &column in self.columns {
+            map.serialize_entry(column.key(), &value(self.spawn, column))?;
+        }
+        map.end()
+    }
+}
+
+/// Writes `spawns` in the given order, one line each. Only the widths of the text columns are
+/// worked out beforehand; the lines are written as they are formatted.
+pub fn write_spawn_listing(
+    out: &mut impl Write,
+    spawns: &[&SpawnExec],
+    columns: &[SpawnColumn],
+    format: ListingFormat,
+) -> io::Result<()> {
+    match format {
+        ListingFormat::Text => write_text(out, spawns, columns)?,
+        ListingFormat::Csv => write_delimited(out, spawns, columns, ',')?,
+        ListingFormat::Tsv => write_delimited(out, spawns, columns, '\t')?,
+        ListingFormat::Jsonl => {
+            for &spawn in spawns {
+                serde_json::to_writer(&mut *out, &SpawnRecord { spawn, columns })?;
+                writeln!(out)?;
+            }
+        }
+    }
+    out.flush()
+}
+
+fn write_text(
+    out: &mut impl Write,
+    spawns: &[&SpawnExec],
+    columns: &[SpawnColumn],
+) -> io::Result<()> {
+    let mut widths: Vec<usize> = columns.iter().map(|c| c.header().len()).collect();
+    for &spawn in spawns {
+        for (width, &column) in widths.iter_mut().zip(columns) {
+            *width = (*width).max(text(spawn, column).chars().count());
+        }
+    }
+    let line = |cells: Vec<String>| {
+        let last = cells.len().saturating_sub(1);
+        let mut line = String::new();
+        for (i, (cell, &column)) in cells.iter().zip(columns).enumerate() {
+            if i > 0 {
+                line.push_str("  ");
+            }
+            let pad = " ".repeat(widths[i] - cell.chars().count());
+            if column.is_numeric() {
+                line.push_str(&pad);
+                line.push_str(cell);
+            } else {
+                line.push_str(cell);
+                // The last column is left ragged, so long labels do not pad every line.
+                if i < last {
+                    line.push_str(&pad);
+                }
+            }
+        }
+        line
+    };
+    writeln!(
+        out,
+        "{}",
+        line(columns.iter().map(|c| c.header().to_string()).collect())
+    )?;
+    for &spawn in spawns {
+        writeln!(
+            out,
+            "{}",
+            line(columns.iter().map(|&c| text(spawn, c)).collect())
+        )?;
+    }
+    Ok(())
+}
+
+fn write_delimited(
+    out: &mut impl Write,
+    spawns: &[&SpawnExec],
+    columns: &[SpawnColumn],
+    separator: char,
+) -> io::Result<()> {
+    let field = |value: Value| {
+        let text = match value {
+            Value::Null => String::new(),
+            Value::String(text) => text,
+            value => value.to_string(),
+        };
+        if separator == ',' {
+            csv_field(&text)
+        } else {
+            // TSV has no quoting, so separators inside a value become spaces.
+            text.replace(['\t', '\n', '\r'], " ")
+        }
+    };
+    let separator = separator.to_string();
+    let keys: Vec<&str> = columns.iter().map(|c| c.key()).collect();
+    writeln!(out, "{}", keys.join(&separator))?;
+    for &spawn in spawns {
+        let fields: Vec<String> = columns.iter().map(|&c| field(value(spawn, c))).collect();
+        writeln!(out, "{}", fields.join(&separator))?;
+    }
+    Ok(())
+}
@@ -0,0 +1,28 @@
+//! The renderers of the main report, and the optional sections printed after it.
+
+pub mod action_digests;
+pub mod cache;
+pub mod ci_summary;
+pub mod concurrency;
+pub mod critical_path;
+pub mod environment;
+pub mod explain;
+pub mod failures;
+pub mod fields;
+pub mod grouping;
+pub mod hashing;
+pub mod hermeticity;
+pub mod inputs;
+pub mod insights;
+pub mod json;
+pub mod listing;
+pub mod outputs;
+pub mod phases;
+pub mod remote;
+pub mod restrictions;
+pub mod runners;
+pub mod slowest;
+pub mod text;
+pub mod timeline;
+pub mod tools;
+pub mod why_miss;
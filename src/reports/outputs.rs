@@ -0,0 +1,624 @@
+//! Reports over individual output files.
+
+use crate::analysis::action_key;
+use crate::classify::{is_cache_hit, is_failed};
+use crate::filter::wildcard_match;
+use crate::format::{format_bytes, format_duration, section, top_label, Align, Table};
+use crate::metrics::{directory_outputs, is_symlink, total_time};
+use crate::paths;
+use crate::proto::{Digest, SpawnExec};
+use crate::report::highest_first;
+use crate::reports::grouping::NO_LABEL;
+use std::borrow::Cow;
+use std::collections::{HashMap, HashSet};
+
+pub fn print_largest_outputs_report(spawns: &[SpawnExec], top_n: usize) {
+    println!("{}", section(format_args!("{} Largest Output Files", top_label(top_n))));
+
+    // Keyed by (path, hash, size); the first spawn seen is reported as the producer.
+    let mut files: HashMap<(Cow<str>, &str, i64), (&SpawnExec, u64)> = HashMap::new();
+    for spawn in spawns {
+        for file in &spawn.actual_outputs {
+            let Some(digest) = file.digest.as_ref() else {
+                continue;
+            };
+            files
+                .entry((paths::relative(&file.path), digest.hash.as_str(), digest.size_bytes))
+                .or_insert((spawn, 0))
+                .1 += 1;
+        }
+    }
+
+    if files.is_empty() {
+        println!("No output files with digests found in the log.");
+        println!();
+        return;
+    }
+
+    let mut files: Vec<_> = files.into_iter().collect();
+    files.sort_by(|a, b| {
+        b.0.2
+            .cmp(&a.0.2)
+            .then(a.0.0.cmp(&b.0.0))
+            .then(a.0.1.cmp(b.0.1))
+    });
+    let mut table = Table::new(vec![
+        ("Size".to_string(), Align::Right),
+        ("Source".to_string(), Align::Left),
+        ("Spawns".to_string(), Align::Right),
+        ("Mnemonic".to_string(), Align::Left),
+        ("Target".to_string(), Align::Left),
+        ("Path".to_string(), Align::Left),
+    ]);
+    let mut digests = Vec::new();
+    for ((path, hash, size), (spawn, count)) in files.into_iter().take(top_n) {
+        let source = if is_cache_hit(spawn) {
+            "downloaded"
+        } else {
+            "produced"
+        };
+        table.add_row(vec![
+            format_bytes(size),
+            source.to_string(),
+            count.to_string(),
+            spawn.mnemonic.clone(),
+            spawn.target_label.clone(),
+            path.into_owned(),
+        ]);
+        digests.push(format!("{}/{}", hash, size));
+    }
+    table.print();
+    println!("Digests:");
+    for (i, digest) in digests.iter().enumerate() {
+        println!("  {:>2}. {}", i + 1, digest);
+    }
+    println!();
+}
+
+#[derive(Default)]
+struct TreeArtifactStats {
+    actions: u64,
+    directories: u64,
+    files: u64,
+    bytes: i64,
+    unknown: u64,
+}
+
+pub fn print_tree_artifacts_report(spawns: &[SpawnExec]) {
+    println!("{}", section("Tree Artifact (Directory Output) Accounting"));
+
+    let mut total = TreeArtifactStats::default();
+    let mut by_mnemonic: HashMap<&str, TreeArtifactStats> = HashMap::new();
+    for spawn in spawns {
+        let (directories, unknown) = directory_outputs(spawn);
+        if directories.is_empty() && unknown.is_empty() {
+            continue;
+        }
+        let stats = by_mnemonic.entry(spawn.mnemonic.as_str()).or_default();
+        for stats in [&mut total, stats] {
+            stats.actions += 1;
+            stats.directories += directories.len() as u64;
+            stats.files += directories.iter().map(|d| d.files).sum::<u64>();
+            stats.bytes += directories.iter().map(|d| d.bytes).sum::<i64>();
+            stats.unknown += unknown.len() as u64;
+        }
+    }
+
+    if total.actions == 0 {
+        println!("No directory outputs found in the log.");
+        println!();
+        return;
+    }
+
+    println!(
+        "Directories with Listed Contents: {} ({} files, {})",
+        total.directories,
+        total.files,
+        format_bytes(total.bytes)
+    );
+    println!(
+        "Directories (size unknown/summarized): {} outputs without a digest or listed contents",
+        total.unknown
+    );
+
+    let mut mnemonics: Vec<_> = by_mnemonic.into_iter().collect();
+    mnemonics.sort_by(|a, b| b.1.bytes.cmp(&a.1.bytes).then(a.0.cmp(b.0)));
+    let mut table = Table::new(vec![
+        ("Mnemonic".to_string(), Align::Left),
+        ("Actions".to_string(), Align::Right),
+        ("Directories".to_string(), Align::Right),
+        ("Files".to_string(), Align::Right),
+        ("Bytes".to_string(), Align::Right),
+        ("Size Unknown".to_string(), Align::Right),
+    ]);
+    for (mnemonic, stats) in mnemonics {
+        table.add_row(vec![
+            mnemonic.to_string(),
+            stats.actions.to_string(),
+            stats.directories.to_string(),
+            stats.files.to_string(),
+            format_bytes(stats.bytes),
+            stats.unknown.to_string(),
+        ]);
+    }
+    println!();
+    table.print();
+    println!("Note: Outputs without a digest may also be empty files, which logs are allowed to record without one.");
+    println!();
+}
+
+/// Number of symlink outputs of a spawn.
+pub fn symlink_outputs(spawn: &SpawnExec) -> usize {
+    spawn.actual_outputs.iter().filter(|f| is_symlink(f)).count()
+}
+
+/// Targets listed per action in the --symlinks report.
+const MAX_SYMLINK_TARGETS: usize = 3;
+
+pub fn print_symlinks_report(spawns: &[SpawnExec], top_n: usize) {
+    println!("{}", section("Unresolved Symlink Outputs"));
+
+    let mut by_mnemonic: HashMap<&str, (u64, usize)> = HashMap::new();
+    let mut producers: Vec<(usize, &SpawnExec)> = Vec::new();
+    for spawn in spawns {
+        let count = symlink_outputs(spawn);
+        if count == 0 {
+            continue;
+        }
+        let entry = by_mnemonic.entry(spawn.mnemonic.as_str()).or_default();
+        entry.0 += 1;
+        entry.1 += count;
+        producers.push((count, spawn));
+    }
+
+    if producers.is_empty() {
+        println!("No unresolved symlink outputs found in the log.");
+        println!();
+        return;
+    }
+
+    let total: usize = producers.iter().map(|p| p.0).sum();
+    println!("{} symlink outputs across {} actions", total, producers.len());
+    let mut mnemonics: Vec<_> = by_mnemonic.into_iter().collect();
+    mnemonics.sort_by(|a, b| b.1.1.cmp(&a.1.1).then(a.0.cmp(b.0)));
+    let mut table = Table::new(vec![
+        ("Mnemonic".to_string(), Align::Left),
+        ("Actions".to_string(), Align::Right),
+        ("Symlinks".to_string(), Align::Right),
+    ]);
+    for (mnemonic, (actions, symlinks)) in mnemonics {
+        table.add_row(vec![
+            mnemonic.to_string(),
+            actions.to_string(),
+            symlinks.to_string(),
+        ]);
+    }
+    println!();
+    table.print();
+
+    producers.sort_by(|a, b| b.0.cmp(&a.0).then_with(|| action_key(a.1).cmp(&action_key(b.1))));
+    println!();
+    println!("{} Actions by Symlink Outputs:", top_label(top_n));
+    for (count, spawn) in producers.into_iter().take(top_n) {
+        println!("{:>6} | {} | {}", count, spawn.mnemonic, spawn.target_label);
+        let symlinks = spawn.actual_outputs.iter().filter(|f| is_symlink(f));
+        for file in symlinks.clone().take(MAX_SYMLINK_TARGETS) {
+            println!(
+                "    └ {} -> {}",
+                paths::relative(&file.path),
+                paths::relative(&file.symlink_target_path)
+            );
+        }
+        if count > MAX_SYMLINK_TARGETS {
+            println!("    └ ... (+{} more)", count - MAX_SYMLINK_TARGETS);
+        }
+    }
+    println!();
+}
+
+/// Share of spawns without outputs above which that section is printed unasked.
+const ZERO_OUTPUT_WARN_FRACTION: f64 = 0.05;
+
+#[derive(Default)]
+struct ZeroOutputCounts {
+    /// Spawns that declared outputs but recorded none of them.
+    listed: u64,
+    /// Spawns that declared no outputs either.
+    unlisted: u64,
+}
+
+/// Reports spawns that recorded no outputs, which the data volume numbers silently omit.
+///
+/// Printed when `always` is set or when such spawns exceed `ZERO_OUTPUT_WARN_FRACTION` of
+/// the log.
+pub fn print_zero_outputs_report(spawns: &[SpawnExec], top_n: usize, always: bool) {
+    let zero: Vec<&SpawnExec> = spawns
+        .iter()
+        .filter(|s| s.actual_outputs.is_empty())
+        .collect();
+    let fraction = zero.len() as f64 / spawns.len().max(1) as f64;
+    if !always && fraction <= ZERO_OUTPUT_WARN_FRACTION {
+        return;
+    }
+
+    println!("{}", section("Spawns Without Outputs"));
+    if zero.is_empty() {
+        println!("Every spawn recorded at least one output.");
+        println!();
+        return;
+    }
+
+    let mut total = ZeroOutputCounts::default();
+    let mut groups: HashMap<(&str, i32), ZeroOutputCounts> = HashMap::new();
+    for spawn in &zero {
+        let group = groups
+            .entry((spawn.mnemonic.as_str(), spawn.exit_code))
+            .or_default();
+        for counts in [&mut total, group] {
+            if spawn.listed_outputs.is_empty() {
+                counts.unlisted += 1;
+            } else {
+                counts.listed += 1;
+            }
+        }
+    }
+    println!(
+        "{} of {} spawns ({:.1}%) recorded no outputs: {} declared outputs they did not produce, {} declared none",
+        zero.len(),
+        spawns.len(),
+        fraction * 100.0,
+        total.listed,
+        total.unlisted
+    );
+
+    let mut groups: Vec<_> = groups.into_iter().collect();
+    groups.sort_by(|(a_key, a), (b_key, b)| {
+        (b.listed + b.unlisted)
+            .cmp(&(a.listed + a.unlisted))
+            .then_with(|| a_key.cmp(b_key))
+    });
+    let mut table = Table::new(vec![
+        ("Mnemonic".to_string(), Align::Left),
+        ("Exit Code".to_string(), Align::Right),
+        ("Declared Outputs".to_string(), Align::Right),
+        ("None Declared".to_string(), Align::Right),
+    ]);
+    for ((mnemonic, exit_code), counts) in groups {
+        table.add_row(vec![
+            mnemonic.to_string(),
+            exit_code.to_string(),
+            counts.listed.to_string(),
+            counts.unlisted.to_string(),
+        ]);
+    }
+    println!();
+    table.print();
+
+    let mut slowest = zero;
+    slowest.sort_by(|a, b| highest_first(a, b, total_time));
+    println!();
+    println!("{} Slowest Spawns Without Outputs:", top_label(top_n));
+    for spawn in slowest.into_iter().take(top_n) {
+        let declared = if spawn.listed_outputs.is_empty() {
+            String::new()
+        } else {
+            format!(" [{} declared]", spawn.listed_outputs.len())
+        };
+        println!(
+            "{:<11} | {} | exit {} | {}{}",
+            format_duration(total_time(spawn), 3),
+            spawn.mnemonic,
+            spawn.exit_code,
+            spawn.target_label,
+            declared
+        );
+    }
+    println!("Note: Declared outputs that were never produced usually mean a failed action or a logging problem; spawns declaring none are often tests with only undeclared outputs.");
+    println!();
+}
+
+/// Conflicting paths listed individually by the duplicate-output warning.
+const MAX_CONFLICTS_SHOWN: usize = 50;
+
+/// An output path recorded by more than one spawn.
+pub struct OutputConflict<'a> {
+    pub path: &'a str,
+    /// The spawns that recorded the path, with the digest each one produced.
+    pub writers: Vec<(&'a SpawnExec, Option<&'a Digest>)>,
+}
+
+impl OutputConflict<'_> {
+    /// Whether the writers produced different contents, which makes the build order-dependent.
+    pub fn digests_differ(&self) -> bool {
+        let key = |d: Option<&Digest>| d.map(|d| (d.hash.clone(), d.size_bytes));
+        let first = key(self.writers[0].1);
+        self.writers.iter().any(|(_, d)| key(*d) != first)
+    }
+
+    /// Whether all writers ran the same action, i.e. with identical inputs, or `None` when
+    /// the log does not tell. Identical actions with differing outputs are non-deterministic.
+    pub fn same_action(&self) -> Option<bool> {
+        let first = self.writers[0].0;
+        let mut same = true;
+        for (spawn, _) in &self.writers[1..] {
+            same &= same_inputs(first, spawn)?;
+        }
+        Some(same)
+    }
+}
+
+/// Compares the action digests of two spawns when both have one (they cover the command,
+/// inputs and platform), and otherwise their input files when both recorded them.
+fn same_inputs(a: &SpawnExec, b: &SpawnExec) -> Option<bool> {
+    let action = |s: &SpawnExec| {
+        s.digest
+            .as_ref()
+            .filter(|d| !d.hash.is_empty())
+            .map(|d| d.hash.clone())
+    };
+    if let (Some(a), Some(b)) = (action(a), action(b)) {
+        return Some(a == b);
+    }
+    if a.inputs.is_empty() || b.inputs.is_empty() {
+        return None;
+    }
+    fn inputs(spawn: &SpawnExec) -> Vec<(&str, Option<&str>)> {
+        let mut files: Vec<(&str, Option<&str>)> = spawn
+            .inputs
+            .iter()
+            .map(|f| (f.path.as_str(), f.digest.as_ref().map(|d| d.hash.as_str())))
+            .collect();
+        files.sort_unstable();
+        files
+    }
+    Some(inputs(a) == inputs(b))
+}
+
+/// Finds the output paths of successful spawns that more than one spawn claims.
+///
+/// Paths are compared exactly, so the same file built in two configurations (and thus
+/// under two `bazel-out/<config>` directories) is not a conflict.
+pub fn output_conflicts(spawns: &[SpawnExec]) -> Vec<OutputConflict<'_>> {
+    let mut writers: HashMap<&str, Vec<(usize, Option<&Digest>)>> = HashMap::new();
+    for (index, spawn) in spawns.iter().enumerate() {
+        // Failed attempts are usually retried, and their outputs are discarded.
+        if is_failed(spawn) {
+            continue;
+        }
+        for file in &spawn.actual_outputs {
+            let entry = writers.entry(file.path.as_str()).or_default();
+            if entry.last().is_none_or(|(last, _)| *last != index) {
+                entry.push((index, file.digest.as_ref()));
+            }
+        }
+    }
+
+    let mut conflicts: Vec<OutputConflict> = writers
+        .into_iter()
+        .filter(|(_, w)| w.len() > 1)
+        .map(|(path, w)| OutputConflict {
+            path,
+            writers: w.into_iter().map(|(i, d)| (&spawns[i], d)).collect(),
+        })
+        .collect();
+    // Non-deterministic actions first, then other differing digests, then harmless repeats.
+    let rank = |c: &OutputConflict| {
+        let differ = c.digests_differ();
+        (differ, differ && c.same_action() == Some(true))
+    };
+    conflicts.sort_by(|a, b| rank(b).cmp(&rank(a)).then(a.path.cmp(b.path)));
+    conflicts
+}
+
+/// Prints a warning section for output paths written by several spawns, if there are any,
+/// and returns how many paths conflict.
+pub fn print_output_conflicts_report(spawns: &[SpawnExec]) -> usize {
+    let conflicts = output_conflicts(spawns);
+    if conflicts.is_empty() {
+        return 0;
+    }
+
+    println!("{}", section("WARNING: Outputs Written by Multiple Spawns"));
+    let differing = conflicts.iter().filter(|c| c.digests_differ()).count();
+    let non_deterministic = conflicts
+        .iter()
+        .filter(|c| c.digests_differ() && c.same_action() == Some(true))
+        .count();
+    println!(
+        "{} output paths are written by more than one spawn ({} with differing digests, {} of them by identical actions).",
+        conflicts.len(),
+        differing,
+        non_deterministic
+    );
+    for conflict in conflicts.iter().take(MAX_CONFLICTS_SHOWN) {
+        if conflict.digests_differ() {
+            let cause = match conflict.same_action() {
+                Some(true) => ", IDENTICAL INPUTS: NON-DETERMINISTIC",
+                Some(false) => ", inputs differ",
+                None => "",
+            };
+            println!("{} [DIGESTS DIFFER{}]", paths::relative(conflict.path), cause);
+        } else {
+            println!("{}", paths::relative(conflict.path));
+        }
+        for (spawn, digest) in &conflict.writers {
+            let digest = digest.map_or("(no digest)".to_string(), |d| {
+                format!("{}/{}", d.hash, d.size_bytes)
+            });
+            println!(
+                "    └ {} | {} | {}",
+                spawn.mnemonic, spawn.target_label, digest
+            );
+        }
+    }
+    if conflicts.len() > MAX_CONFLICTS_SHOWN {
+        println!("... (+{} more paths)", conflicts.len() - MAX_CONFLICTS_SHOWN);
+    }
+    println!();
+    conflicts.len()
+}
+
+/// Spawns listed individually by the output mismatch warning, and paths listed per spawn.
+const MAX_MISMATCHES_SHOWN: usize = 10;
+const MAX_MISMATCH_PATHS_SHOWN: usize = 5;
+
+/// The outputs one spawn's declared and actual output lists disagree on.
+pub struct OutputMismatch<'a> {
+    pub spawn: &'a SpawnExec,
+    /// Declared outputs the spawn did not produce.
+    pub missing: Vec<&'a str>,
+    /// Produced outputs that are neither declared nor below a declared directory.
+    pub extra: Vec<&'a str>,
+}
+
+/// Compares the listed outputs of each successful spawn with its actual outputs, leaving out
+/// spawns whose mnemonic matches an `exempt` pattern. A declared directory is produced by any
+/// file below it, and covers those files. Spawns that declare nothing, and logs that record
+/// no actual outputs at all, are not compared.
+pub fn output_mismatches<'a>(
+    spawns: &'a [SpawnExec],
+    exempt: &[String],
+) -> Vec<OutputMismatch<'a>> {
+    if spawns.iter().all(|s| s.actual_outputs.is_empty()) {
+        return Vec::new();
+    }
+    let mut mismatches = Vec::new();
+    for spawn in spawns {
+        if is_failed(spawn)
+            || spawn.listed_outputs.is_empty()
+            || exempt.iter().any(|pattern| wildcard_match(pattern, &spawn.mnemonic))
+        {
+            continue;
+        }
+        // Compared without machine-specific prefixes, which one list may have and the other not.
+        let normalize = |path: &'a String| (path.as_str(), paths::strip_prefixes(path));
+        let listed: Vec<(&str, Cow<str>)> = spawn.listed_outputs.iter().map(normalize).collect();
+        let actual: Vec<(&str, Cow<str>)> =
+            spawn.actual_outputs.iter().map(|file| normalize(&file.path)).collect();
+        let declared: HashSet<&str> = listed.iter().map(|(_, path)| path.as_ref()).collect();
+        // Every produced path and the directories above it.
+        let mut produced: HashSet<&str> = HashSet::new();
+        for (_, path) in &actual {
+            let mut path = path.as_ref();
+            while produced.insert(path) {
+                match path.rfind('/') {
+                    Some(slash) => path = &path[..slash],
+                    None => break,
+                }
+            }
+        }
+        let missing: Vec<&str> = listed
+            .iter()
+            .filter(|(_, path)| !produced.contains(path.as_ref()))
+            .map(|&(original, _)| original)
+            .collect();
+        let extra: Vec<&str> = actual
+            .iter()
+            .filter(|(_, path)| {
+                let mut path = path.as_ref();
+                loop {
+                    if declared.contains(path) {
+                        return false;
+                    }
+                    match path.rfind('/') {
+                        Some(slash) => path = &path[..slash],
+                        None => return true,
+                    }
+                }
+            })
+            .map(|&(original, _)| original)
+            .collect();
+        if !missing.is_empty() || !extra.is_empty() {
+            mismatches.push(OutputMismatch {
+                spawn,
+                missing,
+                extra,
+            });
+        }
+    }
+    mismatches.sort_by(|a, b| {
+        (b.missing.len() + b.extra.len())
+            .cmp(&(a.missing.len() + a.extra.len()))
+            .then_with(|| action_key(a.spawn).cmp(&action_key(b.spawn)))
+    });
+    mismatches
+}
+
+/// Prints a warning section for spawns whose actual outputs differ from their declared ones,
+/// if there are any, and returns how many spawns differ.
+pub fn print_output_mismatches_report(spawns: &[SpawnExec], exempt: &[String]) -> usize {
+    let mismatches = output_mismatches(spawns, exempt);
+    if mismatches.is_empty() {
+        return 0;
+    }
+
+    println!("{}", section("WARNING: Declared and Actual Outputs Differ"));
+    let missing: usize = mismatches.iter().map(|m| m.missing.len()).sum();
+    let extra: usize = mismatches.iter().map(|m| m.extra.len()).sum();
+    println!(
+        "{} spawns did not produce exactly the outputs they declared: {} declared outputs were not produced, {} produced outputs were not declared.",
+        mismatches.len(),
+        missing,
+        extra
+    );
+
+    // (spawns, missing, extra)
+    let mut by_mnemonic: HashMap<&str, (usize, usize, usize)> = HashMap::new();
+    for mismatch in &mismatches {
+        let counts = by_mnemonic.entry(&mismatch.spawn.mnemonic).or_default();
+        counts.0 += 1;
+        counts.1 += mismatch.missing.len();
+        counts.2 += mismatch.extra.len();
+    }
+    let mut by_mnemonic: Vec<_> = by_mnemonic.into_iter().collect();
+    by_mnemonic.sort_by(|a, b| b.1.0.cmp(&a.1.0).then(a.0.cmp(b.0)));
+    let mut table = Table::new(vec![
+        ("Mnemonic".to_string(), Align::Left),
+        ("Spawns".to_string(), Align::Right),
+        ("Not Produced".to_string(), Align::Right),
+        ("Not Declared".to_string(), Align::Right),
+    ]);
+    for (mnemonic, (count, missing, extra)) in by_mnemonic {
+        table.add_row(vec![
+            mnemonic.to_string(),
+            count.to_string(),
+            missing.to_string(),
+            extra.to_string(),
+        ]);
+    }
+    println!();
+    table.print();
+
+    println!();
+    for mismatch in mismatches.iter().take(MAX_MISMATCHES_SHOWN) {
+        let label = match mismatch.spawn.target_label.as_str() {
+            "" => NO_LABEL,
+            label => label,
+        };
+        println!("{} | {}", mismatch.spawn.mnemonic, label);
+        let lists = [("not produced", &mismatch.missing), ("not declared", &mismatch.extra)];
+        for (what, files) in lists {
+            for path in files.iter().take(MAX_MISMATCH_PATHS_SHOWN) {
+                println!("    └ {}: {}", what, paths::relative(path));
+            }
+            if files.len() > MAX_MISMATCH_PATHS_SHOWN {
+                println!(
+                    "    └ ... (+{} more {})",
+                    files.len() - MAX_MISMATCH_PATHS_SHOWN,
+                    what
+                );
+            }
+        }
+    }
+    if mismatches.len() > MAX_MISMATCHES_SHOWN {
+        println!("... (+{} more spawns)", mismatches.len() - MAX_MISMATCHES_SHOWN);
+    }
+    if !exempt.is_empty() {
+        println!(
+            "Note: Spawns of {} are not compared (--output-mismatch-exempt); tests often declare outputs they only write on failure or with coverage.",
+            exempt.join(", ")
+        );
+    }
+    println!();
+    mismatches.len()
+}
@@ -0,0 +1,203 @@
+//! Time spent in each execution phase.
+
+use crate::classify::runner_label;
+use crate::format::{format_duration, format_seconds, section, Align, Table};
+use crate::metrics::{metrics_coverage, MetricsCoverage, PhaseTotals};
+use crate::proto::SpawnExec;
+use std::collections::{BTreeMap, HashMap};
+
+pub fn print_time_by_phase_report(spawns: &[SpawnExec]) {
+    println!("{}", section("Time by Phase"));
+
+    let mut totals = PhaseTotals::default();
+    for spawn in spawns {
+        totals.add(spawn);
+    }
+
+    if totals.recorded_phases().next().is_none() {
+        println!("No phase timings found in the log.");
+        println!();
+        return;
+    }
+
+    let total_seconds = totals.total_time.as_secs_f64();
+    println!("Total Recorded Spawn Time: {}", format_seconds(total_seconds, 2));
+    let mut table = Table::new(vec![
+        ("Phase".to_string(), Align::Left),
+        ("Time".to_string(), Align::Right),
+        ("% of Total".to_string(), Align::Right),
+        ("Spawns".to_string(), Align::Right),
+    ]);
+    for phase in totals.recorded_phases() {
+        let seconds = totals.sum(phase).as_secs_f64();
+        let percentage = if total_seconds > 0.0 {
+            (seconds / total_seconds) * 100.0
+        } else {
+            0.0
+        };
+        table.add_row(vec![
+            phase.name().to_string(),
+            format_seconds(seconds, 2),
+            format!("{:.1}%", percentage),
+            totals.present(phase).to_string(),
+        ]);
+    }
+    table.print();
+    println!("Note: Phases can overlap and are not always recorded, so percentages need not sum to 100%.");
+    println!();
+}
+
+pub fn print_mnemonic_phases_report(spawns: &[SpawnExec], top: usize) {
+    println!("{}", section(format_args!("Time by Phase per Mnemonic (Top {})", top)));
+
+    let mut by_mnemonic: BTreeMap<&str, PhaseTotals> = BTreeMap::new();
+    for spawn in spawns {
+        by_mnemonic
+            .entry(spawn.mnemonic.as_str())
+            .or_default()
+            .add(spawn);
+    }
+
+    let mut mnemonics: Vec<_> = by_mnemonic
+        .into_iter()
+        .filter(|(_, totals)| totals.recorded_phases().next().is_some())
+        .collect();
+    if mnemonics.is_empty() {
+        println!("No phase timings found in the log.");
+        println!();
+        return;
+    }
+    mnemonics.sort_by(|a, b| b.1.total_time.cmp(&a.1.total_time).then(a.0.cmp(b.0)));
+
+    for (mnemonic, totals) in mnemonics.into_iter().take(top) {
+        println!(
+            "{} ({} spawns, {} total)",
+            mnemonic,
+            totals.spawns,
+            format_duration(totals.total_time, 2));
+        if totals.spawns_with_metrics < totals.spawns {
+            println!(
+                "  Note: only {} of {} spawns recorded metrics; averages cover spawns with data.",
+                totals.spawns_with_metrics, totals.spawns
+            );
+        }
+        let mut table = Table::new(vec![
+            ("Phase".to_string(), Align::Left),
+            ("Total".to_string(), Align::Right),
+            ("Avg".to_string(), Align::Right),
+            ("Spawns".to_string(), Align::Right),
+        ]);
+        for phase in totals.recorded_phases() {
+            let present = totals.present(phase);
+            let sum = totals.sum(phase).as_secs_f64();
+            table.add_row(vec![
+                phase.name().to_string(),
+                format_seconds(sum, 2),
+                format_seconds(sum / present as f64, 3),
+                format!("{}/{}", present, totals.spawns),
+            ]);
+        }
+        table.print();
+        println!();
+    }
+}
+
+/// Spawn counts per timing coverage level.
+#[derive(Default)]
+pub struct CoverageCounts {
+    pub spawns: u64,
+    pub missing: u64,
+    pub total_only: u64,
+}
+
+impl CoverageCounts {
+    pub fn add(&mut self, spawn: &SpawnExec) {
+        self.spawns += 1;
+        match metrics_coverage(spawn) {
+            MetricsCoverage::Missing => self.missing += 1,
+            MetricsCoverage::TotalOnly => self.total_only += 1,
+            MetricsCoverage::Full => {}
+        }
+    }
+
+    /// Percentage of spawns with a recorded total time.
+    pub fn timed_percent(&self) -> f64 {
+        if self.spawns == 0 {
+            return 0.0;
+        }
+        (self.spawns - self.missing) as f64 / self.spawns as f64 * 100.0
+    }
+
+    fn is_complete(&self) -> bool {
+        self.missing == 0 && self.total_only == 0
+    }
+}
+
+/// Prints the coverage table for spawns keyed by `key_fn`, worst offenders first.
+fn print_coverage_table<'a>(
+    header: &str,
+    spawns: &'a [SpawnExec],
+    key_fn: impl Fn(&'a SpawnExec) -> &'a str,
+    top_n: usize,
+) {
+    let mut groups: HashMap<&str, CoverageCounts> = HashMap::new();
+    for spawn in spawns {
+        groups.entry(key_fn(spawn)).or_default().add(spawn);
+    }
+    let mut groups: Vec<_> = groups.into_iter().filter(|(_, c)| !c.is_complete()).collect();
+    if groups.is_empty() {
+        return;
+    }
+    groups.sort_by(|(a_name, a), (b_name, b)| {
+        (b.missing + b.total_only)
+            .cmp(&(a.missing + a.total_only))
+            .then_with(|| a_name.cmp(b_name))
+    });
+
+    let mut table = Table::new(vec![
+        (header.to_string(), Align::Left),
+        ("Spawns".to_string(), Align::Right),
+        ("No Metrics".to_string(), Align::Right),
+        ("No Phases".to_string(), Align::Right),
+        ("Timed".to_string(), Align::Right),
+    ]);
+    for (name, counts) in groups.iter().take(top_n) {
+        table.add_row(vec![
+            name.to_string(),
+            counts.spawns.to_string(),
+            counts.missing.to_string(),
+            counts.total_only.to_string(),
+            format!("{:.1}%", counts.timed_percent()),
+        ]);
+    }
+    println!();
+    table.print();
+    if groups.len() > top_n {
+        println!("... (+{} more)", groups.len() - top_n);
+    }
+}
+
+pub fn print_metrics_coverage_report(spawns: &[SpawnExec], top_n: usize) {
+    println!("{}", section("Timing Data Coverage"));
+
+    let mut overall = CoverageCounts::default();
+    for spawn in spawns {
+        overall.add(spawn);
+    }
+    if overall.is_complete() {
+        println!("Every spawn recorded a total time and at least one phase.");
+        println!();
+        return;
+    }
+
+    println!(
+        "No metrics: {} spawns | Total time but no phases: {} spawns | Timed: {:.1}%",
+        overall.missing,
+        overall.total_only,
+        overall.timed_percent()
+    );
+    print_coverage_table("Mnemonic", spawns, |s| s.mnemonic.as_str(), top_n);
+    print_coverage_table("Runner", spawns, runner_label, top_n);
+    println!("Note: Spawns without a total time are left out of averages and percentiles.");
+    println!();
+}
@@ -0,0 +1,306 @@
+//! Whether remote execution pays off per mnemonic.
+
+use crate::classify::{classify_runner, is_cache_hit, is_failed, RunnerKind};
+use crate::cli::OverheadSort;
+use crate::format::{format_duration, section, Align, Table};
+use crate::metrics::{phase_duration, total_time, Phase};
+use crate::proto::SpawnExec;
+use crate::reports::grouping::platform_property;
+use std::collections::HashMap;
+use std::time::Duration;
+
+/// Phases counted as the cost of shipping an action to a remote executor.
+const OVERHEAD_PHASES: [Phase; 4] = [Phase::Queue, Phase::Upload, Phase::Fetch, Phase::Network];
+
+#[derive(Default)]
+struct OverheadStats {
+    /// Remote executions seen, and those that recorded every phase needed for the ratio.
+    count: u64,
+    complete: u64,
+    overhead: Duration,
+    execution: Duration,
+}
+
+impl OverheadStats {
+    fn ratio(&self) -> Option<f64> {
+        let execution = self.execution.as_secs_f64();
+        (self.complete > 0 && execution > 0.0).then(|| self.overhead.as_secs_f64() / execution)
+    }
+}
+
+pub fn print_remote_overhead_report(spawns: &[SpawnExec], sort: OverheadSort) {
+    println!("{}", section("Remote Execution Overhead by Mnemonic"));
+    println!("Note: Overhead is queue + upload + fetch + network time; cache hits are excluded.");
+
+    let mut by_mnemonic: HashMap<&str, OverheadStats> = HashMap::new();
+    for spawn in spawns {
+        if is_cache_hit(spawn) || classify_runner(&spawn.runner) != RunnerKind::Remote {
+            continue;
+        }
+        let stats = by_mnemonic.entry(spawn.mnemonic.as_str()).or_default();
+        stats.count += 1;
+        let Some(metrics) = spawn.metrics.as_ref() else {
+            continue;
+        };
+        let Some(execution) = phase_duration(metrics, Phase::Execution) else {
+            continue;
+        };
+        let overhead: Option<Duration> = OVERHEAD_PHASES
+            .iter()
+            .map(|phase| phase_duration(metrics, *phase))
+            .sum();
+        if let Some(overhead) = overhead {
+            stats.complete += 1;
+            stats.overhead += overhead;
+            stats.execution += execution;
+        }
+    }
+
+    if by_mnemonic.is_empty() {
+        println!("No remote executions found in the log.");
+        println!();
+        return;
+    }
+
+    let mut rows: Vec<_> = by_mnemonic.into_iter().collect();
+    rows.sort_by(|a, b| {
+        let order = match sort {
+            OverheadSort::Ratio => {
+                let ratio = |stats: &OverheadStats| stats.ratio().unwrap_or(-1.0);
+                ratio(&b.1).total_cmp(&ratio(&a.1))
+            }
+            OverheadSort::Overhead => b.1.overhead.cmp(&a.1.overhead),
+            OverheadSort::Execution => b.1.execution.cmp(&a.1.execution),
+            OverheadSort::Count => b.1.count.cmp(&a.1.count),
+        };
+        order.then(a.0.cmp(b.0))
+    });
+
+    let mut table = Table::new(vec![
+        ("Mnemonic".to_string(), Align::Left),
+        ("Remote Execs".to_string(), Align::Right),
+        ("Coverage".to_string(), Align::Right),
+        ("Overhead".to_string(), Align::Right),
+        ("Execution".to_string(), Align::Right),
+        ("Ratio".to_string(), Align::Right),
+    ]);
+    let mut candidates = Vec::new();
+    for (mnemonic, stats) in &rows {
+        let ratio = stats.ratio();
+        if ratio.is_some_and(|r| r > 1.0) {
+            candidates.push(*mnemonic);
+        }
+        table.add_row(vec![
+            mnemonic.to_string(),
+            stats.count.to_string(),
+            format!("{}/{}", stats.complete, stats.count),
+            format_duration(stats.overhead, 2),
+            format_duration(stats.execution, 2),
+            ratio.map_or_else(|| "n/a".to_string(), |r| format!("{:.2}", r)),
+        ]);
+    }
+    table.print();
+    println!("Coverage counts remote executions that recorded execution time and every overhead phase; only those are summed.");
+    if candidates.is_empty() {
+        println!("No mnemonics spend more time on overhead than on execution.");
+    } else {
+        println!(
+            "Overhead exceeds execution (candidates to run locally): {}",
+            candidates.join(", ")
+        );
+    }
+    println!();
+}
+
+#[derive(Default)]
+struct PoolStats {
+    count: u64,
+    queue: Duration,
+    execution: Duration,
+}
+
+impl PoolStats {
+    fn ratio(&self) -> Option<f64> {
+        let execution = self.execution.as_secs_f64();
+        (execution > 0.0).then(|| self.queue.as_secs_f64() / execution)
+    }
+}
+
+/// Prints queue and execution time of remote executions per value of the platform
+/// property `key`, the pool an action was routed to.
+pub fn print_pool_report(spawns: &[SpawnExec], key: &str) {
+    println!("{}", section(format_args!("Remote Executions by {}", key)));
+
+    let unset = format!("(no {} property)", key);
+    let mut by_pool: HashMap<&str, PoolStats> = HashMap::new();
+    for spawn in spawns {
+        if is_cache_hit(spawn) || classify_runner(&spawn.runner) != RunnerKind::Remote {
+            continue;
+        }
+        let pool = platform_property(spawn, key).unwrap_or(&unset);
+        let stats = by_pool.entry(pool).or_default();
+        stats.count += 1;
+        if let Some(metrics) = spawn.metrics.as_ref() {
+            stats.queue += phase_duration(metrics, Phase::Queue).unwrap_or_default();
+            stats.execution += phase_duration(metrics, Phase::Execution).unwrap_or_default();
+        }
+    }
+
+    if by_pool.is_empty() {
+        println!("No remote executions found in the log.");
+        println!();
+        return;
+    }
+
+    let mut rows: Vec<_> = by_pool.into_iter().collect();
+    rows.sort_by(|a, b| b.1.queue.cmp(&a.1.queue).then(a.0.cmp(b.0)));
+    let mut table = Table::new(vec![
+        (key.to_string(), Align::Left),
+        ("Remote Execs".to_string(), Align::Right),
+        ("Execution".to_string(), Align::Right),
+        ("Queue".to_string(), Align::Right),
+        ("Queue/Exec".to_string(), Align::Right),
+    ]);
+    for (pool, stats) in &rows {
+        table.add_row(vec![
+            pool.to_string(),
+            stats.count.to_string(),
+            format_duration(stats.execution, 2),
+            format_duration(stats.queue, 2),
+            stats
+                .ratio()
+                .map_or_else(|| "n/a".to_string(), |r| format!("{:.2}", r)),
+        ]);
+    }
+    table.print();
+
+    let ratios: Vec<(&str, f64)> = rows
+        .iter()
+        .filter(|(pool, _)| *pool != unset)
+        .filter_map(|(pool, stats)| stats.ratio().map(|r| (*pool, r)))
+        .collect();
+    let busiest = ratios.iter().max_by(|a, b| a.1.total_cmp(&b.1));
+    let idlest = ratios.iter().min_by(|a, b| a.1.total_cmp(&b.1));
+    if let (Some(busiest), Some(idlest)) = (busiest, idlest) {
+        if ratios.len() > 1 {
+            println!(
+                "Queue/execution ratio ranges from {:.2} ({}) to {:.2} ({}).",
+                idlest.1, idlest.0, busiest.1, busiest.0
+            );
+        }
+    }
+    println!("Note: Cache hits are excluded; spawns without queue or execution phases count as zero.");
+    println!();
+}
+
+#[derive(Default)]
+struct FallbackStats {
+    /// Remotable spawns that ran locally in a build that otherwise executed remotely.
+    local_runs: u64,
+    local_time: Duration,
+    /// Remote failures followed by a local success of the same action, and the time of the
+    /// failed remote attempts.
+    retried: u64,
+    wasted_time: Duration,
+}
+
+fn is_local(spawn: &SpawnExec) -> bool {
+    matches!(
+        classify_runner(&spawn.runner),
+        RunnerKind::Sandboxed | RunnerKind::Local | RunnerKind::Worker
+    )
+}
+
+/// Reports evidence of `--remote_local_fallback`: remotable spawns that ran locally, and
+/// actions that failed remotely and then succeeded locally.
+///
+/// Prints nothing unless there is such evidence. Local runs of remotable spawns only count
+/// when the log also contains remote executions, as they are normal in local builds.
+pub fn print_remote_fallback_report(spawns: &[SpawnExec]) {
+    let remote_build = spawns
+        .iter()
+        .any(|s| classify_runner(&s.runner) == RunnerKind::Remote);
+    if !remote_build {
+        return;
+    }
+
+    let mut by_mnemonic: HashMap<&str, FallbackStats> = HashMap::new();
+    // Failed remote attempts by action, keyed by label, mnemonic and first declared output
+    // so that different actions of one target are not paired up.
+    let action_key = |s: &SpawnExec| {
+        (
+            s.target_label.clone(),
+            s.mnemonic.clone(),
+            s.listed_outputs.first().cloned().unwrap_or_default(),
+        )
+    };
+    let mut remote_failures: HashMap<(String, String, String), Vec<Duration>> = HashMap::new();
+    for spawn in spawns {
+        if classify_runner(&spawn.runner) == RunnerKind::Remote && is_failed(spawn) {
+            remote_failures
+                .entry(action_key(spawn))
+                .or_default()
+                .push(total_time(spawn));
+        }
+    }
+
+    for spawn in spawns.iter().filter(|s| is_local(s)) {
+        if spawn.remotable {
+            let stats = by_mnemonic.entry(spawn.mnemonic.as_str()).or_default();
+            stats.local_runs += 1;
+            stats.local_time += total_time(spawn);
+        }
+        if is_failed(spawn) {
+            continue;
+        }
+        if let Some(attempts) = remote_failures.remove(&action_key(spawn)) {
+            let stats = by_mnemonic.entry(spawn.mnemonic.as_str()).or_default();
+            stats.retried += 1;
+            stats.wasted_time += attempts.iter().sum::<Duration>();
+        }
+    }
+    if by_mnemonic.is_empty() {
+        return;
+    }
+
+    println!("{}", section("Remote Execution Fallbacks"));
+    let local_runs: u64 = by_mnemonic.values().map(|s| s.local_runs).sum();
+    let local_time: Duration = by_mnemonic.values().map(|s| s.local_time).sum();
+    let retried: u64 = by_mnemonic.values().map(|s| s.retried).sum();
+    let wasted: Duration = by_mnemonic.values().map(|s| s.wasted_time).sum();
+    println!(
+        "Remotable spawns executed locally: {} ({})",
+        local_runs,
+        format_duration(local_time, 2));
+    println!(
+        "Remote failures retried locally: {} (extra {} spent on the failed remote attempts)",
+        retried,
+        format_duration(wasted, 2));
+
+    let mut rows: Vec<_> = by_mnemonic.into_iter().collect();
+    rows.sort_by(|(a_name, a), (b_name, b)| {
+        (b.wasted_time + b.local_time)
+            .cmp(&(a.wasted_time + a.local_time))
+            .then_with(|| a_name.cmp(b_name))
+    });
+    let mut table = Table::new(vec![
+        ("Mnemonic".to_string(), Align::Left),
+        ("Local Runs".to_string(), Align::Right),
+        ("Local Time".to_string(), Align::Right),
+        ("Retried".to_string(), Align::Right),
+        ("Extra Time".to_string(), Align::Right),
+    ]);
+    for (mnemonic, stats) in rows {
+        table.add_row(vec![
+            mnemonic.to_string(),
+            stats.local_runs.to_string(),
+            format_duration(stats.local_time, 2),
+            stats.retried.to_string(),
+            format_duration(stats.wasted_time, 2),
+        ]);
+    }
+    println!();
+    table.print();
+    println!("Note: Local runs of remotable spawns may also come from strategy flags such as --strategy=local.");
+    println!();
+}
@@ -0,0 +1,219 @@
+//! Spawns that Bazel keeps out of the cache or away from remote executors.
+
+use crate::format::{format_duration, section, top_label, Align, Table};
+use crate::metrics::total_time;
+use crate::proto::SpawnExec;
+use crate::report::highest_first;
+use crate::reports::grouping::label_package;
+use std::collections::{BTreeSet, HashMap};
+use std::time::Duration;
+
+/// Count and time of a group of spawns.
+#[derive(Default)]
+struct GroupTime<'a> {
+    spawns: u64,
+    time: Duration,
+    mnemonics: BTreeSet<&'a str>,
+}
+
+impl<'a> GroupTime<'a> {
+    fn add(&mut self, spawn: &'a SpawnExec) {
+        self.spawns += 1;
+        self.time += total_time(spawn);
+        self.mnemonics.insert(spawn.mnemonic.as_str());
+    }
+}
+
+/// Groups spawns by `key_fn`, sorted by time descending.
+fn group_by_time<'a>(
+    spawns: &[&'a SpawnExec],
+    key_fn: impl Fn(&'a SpawnExec) -> String,
+) -> Vec<(String, GroupTime<'a>)> {
+    let mut groups: HashMap<String, GroupTime> = HashMap::new();
+    for spawn in spawns {
+        groups.entry(key_fn(spawn)).or_default().add(spawn);
+    }
+    let mut groups: Vec<_> = groups.into_iter().collect();
+    groups.sort_by(|(a_name, a), (b_name, b)| b.time.cmp(&a.time).then_with(|| a_name.cmp(b_name)));
+    groups
+}
+
+fn label_or_placeholder(spawn: &SpawnExec) -> &str {
+    if spawn.target_label.is_empty() {
+        "(no label)"
+    } else {
+        &spawn.target_label
+    }
+}
+
+fn percent_of(part: Duration, whole: Duration) -> f64 {
+    if whole.is_zero() {
+        0.0
+    } else {
+        part.as_secs_f64() / whole.as_secs_f64() * 100.0
+    }
+}
+
+/// Total time of the spawns Bazel marked as not cacheable.
+pub fn uncacheable_time(spawns: &[SpawnExec]) -> Duration {
+    spawns.iter().filter(|s| !s.cacheable).map(total_time).sum()
+}
+
+pub fn print_uncacheable_report(spawns: &[SpawnExec], top_n: usize) {
+    println!("{}", section("Non-Cacheable Actions"));
+
+    // Proto3 cannot tell an unset field from false, but a log where nothing is cacheable
+    // almost certainly predates the field.
+    if !spawns.iter().any(|s| s.cacheable) {
+        println!("No spawn is marked cacheable, so this log probably does not record cacheability.");
+        println!();
+        return;
+    }
+
+    let uncacheable: Vec<&SpawnExec> = spawns.iter().filter(|s| !s.cacheable).collect();
+    let local_only = spawns
+        .iter()
+        .filter(|s| s.cacheable && !s.remote_cacheable)
+        .count();
+    if uncacheable.is_empty() {
+        println!("Every spawn is cacheable.");
+    } else {
+        let time: Duration = uncacheable.iter().map(|s| total_time(s)).sum();
+        let total: Duration = spawns.iter().map(total_time).sum();
+        println!(
+            "{} of {} spawns are not cacheable, taking {} ({:.1}% of total spawn time)",
+            uncacheable.len(),
+            spawns.len(),
+            format_duration(time, 2),
+            percent_of(time, total)
+        );
+
+        println!();
+        print_time_table(
+            "Mnemonic",
+            group_by_time(&uncacheable, |s| s.mnemonic.clone()),
+            total,
+            usize::MAX,
+        );
+
+        let mut table = Table::new(vec![
+            ("Target".to_string(), Align::Left),
+            ("Mnemonics".to_string(), Align::Left),
+            ("Spawns".to_string(), Align::Right),
+            ("Time".to_string(), Align::Right),
+        ]);
+        for (label, group) in group_by_time(&uncacheable, |s| label_or_placeholder(s).to_string())
+            .into_iter()
+            .take(top_n)
+        {
+            table.add_row(vec![
+                label.to_string(),
+                group.mnemonics.into_iter().collect::<Vec<_>>().join(", "),
+                group.spawns.to_string(),
+                format_duration(group.time, 2),
+            ]);
+        }
+        println!();
+        println!("{} Targets by Non-Cacheable Time:", top_label(top_n));
+        table.print();
+    }
+    if local_only > 0 {
+        println!(
+            "Note: {} further spawns are cacheable only locally (e.g. tagged no-remote-cache).",
+            local_only
+        );
+    }
+    println!();
+}
+
+fn print_time_table(header: &str, groups: Vec<(String, GroupTime)>, total: Duration, top_n: usize) {
+    let mut table = Table::new(vec![
+        (header.to_string(), Align::Left),
+        ("Spawns".to_string(), Align::Right),
+        ("Time".to_string(), Align::Right),
+        ("% Total".to_string(), Align::Right),
+    ]);
+    let shown = groups.len().min(top_n);
+    for (name, group) in groups.iter().take(top_n) {
+        table.add_row(vec![
+            name.to_string(),
+            group.spawns.to_string(),
+            format_duration(group.time, 2),
+            format!("{:.1}%", percent_of(group.time, total)),
+        ]);
+    }
+    table.print();
+    if groups.len() > shown {
+        println!("... (+{} more)", groups.len() - shown);
+    }
+}
+
+pub fn print_remotability_report(spawns: &[SpawnExec], top_n: usize) {
+    println!("{}", section("Remotability"));
+
+    let total: Duration = spawns.iter().map(total_time).sum();
+    // As with cacheability, a log without a single remotable spawn most likely does not
+    // record the field at all.
+    if !spawns.iter().any(|s| s.remotable) {
+        println!(
+            "Unknown: {} spawns, {} (the log does not appear to record remotability)",
+            spawns.len(),
+            format_duration(total, 2));
+        println!();
+        return;
+    }
+
+    let (remotable, blocked): (Vec<&SpawnExec>, Vec<&SpawnExec>) =
+        spawns.iter().partition(|s| s.remotable);
+    let remotable_time: Duration = remotable.iter().map(|s| total_time(s)).sum();
+    let blocked_time: Duration = blocked.iter().map(|s| total_time(s)).sum();
+    println!(
+        "Remotable: {} spawns, {} ({:.1}% of total spawn time)",
+        remotable.len(),
+        format_duration(remotable_time, 2),
+        percent_of(remotable_time, total)
+    );
+    println!(
+        "Not remotable: {} spawns, {} ({:.1}% of total spawn time)",
+        blocked.len(),
+        format_duration(blocked_time, 2),
+        percent_of(blocked_time, total)
+    );
+    if blocked.is_empty() {
+        println!();
+        return;
+    }
+    println!(
+        "Note: Remote execution can shorten at most the {:.1}% of spawn time that is remotable.",
+        percent_of(remotable_time, total)
+    );
+
+    println!();
+    print_time_table(
+        "Mnemonic",
+        group_by_time(&blocked, |s| s.mnemonic.clone()),
+        total,
+        top_n,
+    );
+    println!();
+    print_time_table(
+        "Package",
+        group_by_time(&blocked, |s| label_package(&s.target_label, None)),
+        total,
+        top_n,
+    );
+
+    let mut slowest = blocked;
+    slowest.sort_by(|a, b| highest_first(a, b, total_time));
+    println!();
+    println!("{} Non-Remotable Actions:", top_label(top_n));
+    for spawn in slowest.into_iter().take(top_n) {
+        println!(
+            "{:>10} | {} | {}",
+            format_duration(total_time(spawn), 3),
+            spawn.mnemonic,
+            label_or_placeholder(spawn)
+        );
+    }
+    println!();
+}
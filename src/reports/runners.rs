@@ -0,0 +1,331 @@
+//! Comparisons between execution strategies for the same mnemonic.
+
+use crate::classify::{classify_runner, is_cache_hit, RunnerKind};
+use crate::format::{format_duration, format_seconds, format_seconds_change, section, Align, Table};
+use crate::metrics::{recorded_total_time, to_std_duration};
+use crate::proto::SpawnExec;
+use std::collections::BTreeMap;
+use std::time::Duration;
+
+#[derive(Default)]
+struct SideStats {
+    count: u64,
+    /// Spawns that recorded a total time, which the average is taken over.
+    timed: u64,
+    total_duration: Duration,
+    /// Spawns that recorded a setup time, and the sum of those times.
+    setup_count: u64,
+    setup_duration: Duration,
+}
+
+impl SideStats {
+    fn add(&mut self, spawn: &SpawnExec) {
+        self.count += 1;
+        if let Some(duration) = recorded_total_time(spawn) {
+            self.timed += 1;
+            self.total_duration += duration;
+        }
+        if let Some(setup) = spawn.metrics.as_ref().and_then(|m| m.setup_time.as_ref()) {
+            self.setup_count += 1;
+            self.setup_duration += to_std_duration(setup);
+        }
+    }
+
+    fn avg_seconds(&self) -> f64 {
+        if self.timed > 0 {
+            self.total_duration.as_secs_f64() / self.timed as f64
+        } else {
+            0.0
+        }
+    }
+}
+
+pub fn print_sandbox_overhead_report(spawns: &[SpawnExec], min_samples: u64) {
+    println!("{}", section("Sandbox Overhead (Sandboxed vs. Local Runners)"));
+
+    let mut stats: BTreeMap<&str, (SideStats, SideStats)> = BTreeMap::new();
+    for spawn in spawns.iter().filter(|s| !is_cache_hit(s)) {
+        match classify_runner(&spawn.runner) {
+            RunnerKind::Sandboxed => stats.entry(&spawn.mnemonic).or_default().0.add(spawn),
+            RunnerKind::Local => stats.entry(&spawn.mnemonic).or_default().1.add(spawn),
+            _ => {}
+        }
+    }
+
+    let mut skipped = 0;
+    let mut table = Table::new(vec![
+        ("Mnemonic".to_string(), Align::Left),
+        ("Sandboxed".to_string(), Align::Right),
+        ("Avg Time".to_string(), Align::Right),
+        ("Avg Setup".to_string(), Align::Right),
+        ("Local".to_string(), Align::Right),
+        ("Avg Time".to_string(), Align::Right),
+        ("Delta".to_string(), Align::Right),
+    ]);
+    for (mnemonic, (sandboxed, local)) in &stats {
+        if sandboxed.count == 0 || local.count == 0 {
+            continue;
+        }
+        // Only spawns with a recorded total time count as samples.
+        let min_samples = min_samples.max(1);
+        if sandboxed.timed < min_samples || local.timed < min_samples {
+            skipped += 1;
+            continue;
+        }
+        let delta = sandboxed.avg_seconds() - local.avg_seconds();
+        let delta_pct = if local.avg_seconds() > 0.0 {
+            format!(" ({:+.0}%)", delta / local.avg_seconds() * 100.0)
+        } else {
+            String::new()
+        };
+        let avg_setup = if sandboxed.setup_count > 0 {
+            let setup = sandboxed.setup_duration.as_secs_f64() / sandboxed.setup_count as f64;
+            let share = if sandboxed.avg_seconds() > 0.0 {
+                setup / sandboxed.avg_seconds() * 100.0
+            } else {
+                0.0
+            };
+            format!("{} ({:.0}%)", format_seconds(setup, 3), share)
+        } else {
+            "N/A".to_string()
+        };
+        table.add_row(vec![
+            mnemonic.to_string(),
+            sandboxed.count.to_string(),
+            format_seconds(sandboxed.avg_seconds(), 3),
+            avg_setup,
+            local.count.to_string(),
+            format_seconds(local.avg_seconds(), 3),
+            format!("{}{}", format_seconds_change(delta, 3), delta_pct),
+        ]);
+    }
+
+    if table.is_empty() {
+        println!("No mnemonics found with enough executions under both sandboxed and local runners.");
+    } else {
+        table.print();
+    }
+    if skipped > 0 {
+        println!(
+            "{} mnemonic(s) skipped with fewer than {} samples on either side.",
+            skipped, min_samples
+        );
+    }
+    println!();
+}
+
+/// Splits executed local spawns per mnemonic into (worker, non-worker) sides.
+fn split_worker_executions(spawns: &[SpawnExec]) -> BTreeMap<&str, (SideStats, SideStats)> {
+    let mut stats: BTreeMap<&str, (SideStats, SideStats)> = BTreeMap::new();
+    for spawn in spawns.iter().filter(|s| !is_cache_hit(s)) {
+        match classify_runner(&spawn.runner) {
+            RunnerKind::Worker => stats.entry(&spawn.mnemonic).or_default().0.add(spawn),
+            RunnerKind::Sandboxed | RunnerKind::Local => {
+                stats.entry(&spawn.mnemonic).or_default().1.add(spawn)
+            }
+            _ => {}
+        }
+    }
+    stats
+}
+
+pub fn print_worker_analysis_report(spawns: &[SpawnExec]) {
+    println!("{}", section("Persistent Worker vs. Non-Worker Execution"));
+
+    let stats = split_worker_executions(spawns);
+
+    if stats.is_empty() {
+        println!("No locally executed actions found.");
+        println!();
+        return;
+    }
+
+    let mut table = Table::new(vec![
+        ("Mnemonic".to_string(), Align::Left),
+        ("Worker".to_string(), Align::Right),
+        ("Avg Time".to_string(), Align::Right),
+        ("Non-Worker".to_string(), Align::Right),
+        ("Avg Time".to_string(), Align::Right),
+        ("Speedup".to_string(), Align::Right),
+    ]);
+    let side_cells = |side: &SideStats| {
+        if side.timed > 0 {
+            (side.count.to_string(), format_seconds(side.avg_seconds(), 3))
+        } else if side.count > 0 {
+            (side.count.to_string(), "N/A".to_string())
+        } else {
+            ("-".to_string(), "-".to_string())
+        }
+    };
+    for (mnemonic, (worker, non_worker)) in &stats {
+        let (worker_count, worker_avg) = side_cells(worker);
+        let (non_worker_count, non_worker_avg) = side_cells(non_worker);
+        let speedup = if non_worker.timed > 0 && worker.avg_seconds() > 0.0 {
+            format!("{:.1}x", non_worker.avg_seconds() / worker.avg_seconds())
+        } else {
+            "N/A".to_string()
+        };
+        table.add_row(vec![
+            mnemonic.to_string(),
+            worker_count,
+            worker_avg,
+            non_worker_count,
+            non_worker_avg,
+            speedup,
+        ]);
+    }
+    table.print();
+    println!("Note: Speedup is the non-worker average divided by the worker average; cache hits are excluded.");
+    println!();
+}
+
+/// A mnemonic that looks like it would benefit from a persistent worker.
+pub struct WorkerCandidate<'a> {
+    pub mnemonic: &'a str,
+    /// Non-worker local executions of the mnemonic.
+    pub local_count: u64,
+    /// Of those, the executions that recorded a total time, and the sum of those times.
+    pub local_timed: u64,
+    pub local_total: Duration,
+    /// Executions that already ran in a worker.
+    pub worker_count: u64,
+}
+
+impl WorkerCandidate<'_> {
+    pub fn avg_seconds(&self) -> f64 {
+        self.local_total.as_secs_f64() / self.local_timed as f64
+    }
+}
+
+/// Finds mnemonics with many short non-worker local executions, where process
+/// startup (JVM, Node, ...) is likely to dominate.
+///
+/// Candidates are sorted by their total local execution time, largest first.
+pub fn find_worker_candidates(
+    spawns: &[SpawnExec],
+    min_count: u64,
+    max_avg: Duration,
+) -> Vec<WorkerCandidate<'_>> {
+    let stats = split_worker_executions(spawns);
+
+    let mut candidates: Vec<WorkerCandidate> = stats
+        .into_iter()
+        .filter(|(_, (_, local))| {
+            local.timed > 0
+                && local.count >= min_count
+                && local.avg_seconds() < max_avg.as_secs_f64()
+        })
+        .map(|(mnemonic, (worker, local))| WorkerCandidate {
+            mnemonic,
+            local_count: local.count,
+            local_timed: local.timed,
+            local_total: local.total_duration,
+            worker_count: worker.count,
+        })
+        .collect();
+    candidates.sort_by(|a, b| {
+        b.local_total
+            .cmp(&a.local_total)
+            .then_with(|| a.mnemonic.cmp(b.mnemonic))
+    });
+    candidates
+}
+
+pub fn print_worker_suggestions_report(spawns: &[SpawnExec], min_count: u64, max_avg: Duration) {
+    println!("{}", section("Suggested Persistent Worker Candidates (Heuristic)"));
+    println!(
+        "Criteria: at least {} non-worker local executions averaging under {}.",
+        min_count,
+        format_duration(max_avg, 2));
+
+    let candidates = find_worker_candidates(spawns, min_count, max_avg);
+    if candidates.is_empty() {
+        println!("No mnemonics match the worker candidate criteria.");
+        println!();
+        return;
+    }
+
+    let mut table = Table::new(vec![
+        ("Mnemonic".to_string(), Align::Left),
+        ("Local Spawns".to_string(), Align::Right),
+        ("Avg Time".to_string(), Align::Right),
+        ("Total Time".to_string(), Align::Right),
+        ("Already Worker".to_string(), Align::Right),
+    ]);
+    for candidate in &candidates {
+        table.add_row(vec![
+            candidate.mnemonic.to_string(),
+            candidate.local_count.to_string(),
+            format_seconds(candidate.avg_seconds(), 3),
+            format_duration(candidate.local_total, 2),
+            candidate.worker_count.to_string(),
+        ]);
+    }
+    table.print();
+    println!("Note: These are suggestions only. Total Time is the local execution time a worker could shorten, not a guaranteed saving.");
+    println!();
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::testing::spawns;
+
+    const MIN_COUNT: u64 = 500;
+    const MAX_AVG: Duration = Duration::from_secs(3);
+
+    fn candidates(spawns: &[SpawnExec]) -> Vec<(&str, u64)> {
+        find_worker_candidates(spawns, MIN_COUNT, MAX_AVG)
+            .into_iter()
+            .map(|candidate| (candidate.mnemonic, candidate.local_count))
+            .collect()
+    }
+
+    #[test]
+    fn many_short_local_spawns_are_candidates() {
+        let log = [
+            spawns(600, "Javac", "linux-sandbox", 1_000),
+            spawns(10, "Javac", "worker", 400),
+        ]
+        .concat();
+        let found = find_worker_candidates(&log, MIN_COUNT, MAX_AVG);
+        assert_eq!(found.len(), 1);
+        assert_eq!(found[0].mnemonic, "Javac");
+        assert_eq!(found[0].local_count, 600);
+        assert_eq!(found[0].worker_count, 10);
+        assert_eq!(found[0].local_total, Duration::from_secs(600));
+        assert_eq!(found[0].avg_seconds(), 1.0);
+    }
+
+    #[test]
+    fn few_or_slow_spawns_are_not_candidates() {
+        let log = [
+            spawns(499, "Javac", "local", 1_000),
+            spawns(800, "CppCompile", "linux-sandbox", 3_000),
+        ]
+        .concat();
+        assert!(candidates(&log).is_empty());
+    }
+
+    #[test]
+    fn cache_hits_and_remote_spawns_do_not_count() {
+        let log = [
+            spawns(400, "TsProject", "local", 500),
+            spawns(400, "TsProject", "remote cache hit", 500),
+            spawns(400, "TsProject", "remote", 500),
+        ]
+        .concat();
+        assert!(candidates(&log).is_empty());
+    }
+
+    #[test]
+    fn candidates_are_sorted_by_local_time() {
+        let log = [
+            spawns(1_000, "GoCompile", "local", 100),
+            spawns(600, "Javac", "linux-sandbox", 2_000),
+            spawns(500, "TsProject", "processwrapper-sandbox", 200),
+        ]
+        .concat();
+        assert_eq!(candidates(&log), [("Javac", 600), ("GoCompile", 1_000), ("TsProject", 500)]);
+    }
+}
@@ -0,0 +1,222 @@
+//! Slowest spawns within each mnemonic or runner.
+
+use crate::classify::{classify_runner, is_cache_hit, runner_label};
+use crate::filter::wildcard_match;
+use crate::format::{format_duration, format_duration_short, section, Align, Table};
+use crate::metrics::{phase_duration, total_time, Phase};
+use crate::proto::SpawnExec;
+use crate::report::highest_first;
+use crate::reports::grouping::NO_LABEL;
+use std::collections::HashMap;
+use std::time::Duration;
+
+/// Mnemonics below this share of total spawn time are left out of the per-mnemonic lists.
+const MIN_MNEMONIC_SHARE: f64 = 0.01;
+
+fn cache_status(spawn: &SpawnExec) -> &'static str {
+    if is_cache_hit(spawn) { "hit" } else { "miss" }
+}
+
+/// Groups spawns by `key_fn`, each group sorted slowest first, with the group's total time.
+fn slowest_by<'a, K: std::hash::Hash + Ord>(
+    spawns: &'a [SpawnExec],
+    key_fn: impl Fn(&'a SpawnExec) -> K,
+) -> Vec<(K, Duration, Vec<&'a SpawnExec>)> {
+    let mut groups: HashMap<K, (Duration, Vec<&SpawnExec>)> = HashMap::new();
+    for spawn in spawns {
+        let group = groups.entry(key_fn(spawn)).or_default();
+        group.0 += total_time(spawn);
+        group.1.push(spawn);
+    }
+    let mut groups: Vec<_> = groups
+        .into_iter()
+        .map(|(key, (time, mut members))| {
+            members.sort_by(|a, b| highest_first(a, b, total_time));
+            (key, time, members)
+        })
+        .collect();
+    groups.sort_by(|a, b| b.1.cmp(&a.1).then_with(|| a.0.cmp(&b.0)));
+    groups
+}
+
+/// The slowest spawns of one of the mnemonics with the most total time.
+pub struct SlowestOfMnemonic<'a> {
+    pub mnemonic: &'a str,
+    pub time: Duration,
+    /// Of the total time of all spawns, from 0 to 1.
+    pub share: f64,
+    pub slowest: Vec<&'a SpawnExec>,
+}
+
+/// The `per_mnemonic` slowest spawns of each of the `max_mnemonics` mnemonics with the most
+/// total time, leaving out those under 1% of it, and how many mnemonics were left out.
+pub fn top_per_mnemonic(
+    spawns: &[SpawnExec],
+    per_mnemonic: usize,
+    max_mnemonics: usize,
+) -> (Vec<SlowestOfMnemonic<'_>>, usize) {
+    let total: Duration = spawns.iter().map(total_time).sum();
+    let groups = slowest_by(spawns, |s| s.mnemonic.as_str());
+    let mut shown = Vec::new();
+    let mut skipped = 0;
+    for (index, (mnemonic, time, mut members)) in groups.into_iter().enumerate() {
+        let share = if total.is_zero() {
+            0.0
+        } else {
+            time.as_secs_f64() / total.as_secs_f64()
+        };
+        if index >= max_mnemonics || share < MIN_MNEMONIC_SHARE {
+            skipped += 1;
+            continue;
+        }
+        members.truncate(per_mnemonic);
+        shown.push(SlowestOfMnemonic {
+            mnemonic,
+            time,
+            share,
+            slowest: members,
+        });
+    }
+    (shown, skipped)
+}
+
+/// Prints the `per_mnemonic` slowest spawns of each of the `max_mnemonics` mnemonics with
+/// the most total time.
+pub fn print_top_per_mnemonic_report(spawns: &[SpawnExec], per_mnemonic: usize, max_mnemonics: usize) {
+    println!("{}", section(format_args!("Slowest {} Actions per Mnemonic", per_mnemonic)));
+    let (groups, skipped) = top_per_mnemonic(spawns, per_mnemonic, max_mnemonics);
+    for group in &groups {
+        println!(
+            "{} ({}, {:.1}% of total time):",
+            group.mnemonic,
+            format_duration(group.time, 2),
+            group.share * 100.0
+        );
+        for spawn in &group.slowest {
+            println!(
+                "  {:>10} | {} | {} | {}",
+                format_duration(total_time(spawn), 3),
+                cache_status(spawn),
+                runner_label(spawn),
+                spawn.target_label
+            );
+        }
+    }
+    if skipped > 0 {
+        println!(
+            "Note: {} mnemonics outside the top {} or under {:.0}% of total time are not shown.",
+            skipped,
+            max_mnemonics,
+            MIN_MNEMONIC_SHARE * 100.0
+        );
+    }
+    println!();
+}
+
+/// Runner classes with fewer spawns than this are left out of the per-runner lists.
+const MIN_RUNNER_SPAWNS: usize = 5;
+
+/// Returns the phase that took the longest in a spawn, with its duration.
+fn dominant_phase(spawn: &SpawnExec) -> Option<(Phase, Duration)> {
+    let metrics = spawn.metrics.as_ref()?;
+    Phase::ALL
+        .iter()
+        .filter_map(|&phase| phase_duration(metrics, phase).map(|d| (phase, d)))
+        .filter(|(_, d)| !d.is_zero())
+        .max_by_key(|(_, d)| *d)
+}
+
+/// Prints the `per_runner` slowest spawns of each runner class, with their dominant phase.
+pub fn print_top_per_runner_report(spawns: &[SpawnExec], per_runner: usize) {
+    println!("{}", section(format_args!("Slowest {} Actions per Runner", per_runner)));
+    let groups = slowest_by(spawns, |s| classify_runner(&s.runner));
+
+    let mut skipped = 0;
+    for (kind, time, members) in &groups {
+        if members.len() < MIN_RUNNER_SPAWNS {
+            skipped += 1;
+            continue;
+        }
+        println!(
+            "{} ({} spawns, {}):",
+            kind.name(),
+            members.len(),
+            format_duration(*time, 2));
+        for spawn in members.iter().take(per_runner) {
+            let dominant = dominant_phase(spawn).map_or("no phases".to_string(), |(phase, d)| {
+                format!("{} {}", phase.name().to_lowercase(), format_duration(d, 3))
+            });
+            println!(
+                "  {:>10} | {} | {} | {}",
+                format_duration(total_time(spawn), 3),
+                dominant,
+                spawn.mnemonic,
+                spawn.target_label
+            );
+        }
+    }
+    if skipped > 0 {
+        println!(
+            "Note: {} runner classes with fewer than {} spawns are not shown.",
+            skipped, MIN_RUNNER_SPAWNS
+        );
+    }
+    println!();
+}
+
+/// Prints every executed spawn that took longer than `budget`, slowest first, and returns
+/// how many there are. Cache hits and mnemonics matching an `exclude` pattern are skipped.
+pub fn print_duration_budget_report(
+    spawns: &[SpawnExec],
+    budget: Duration,
+    exclude: &[String],
+) -> usize {
+    println!(
+        "{}",
+        section(format_args!(
+            "Actions Over the {} Duration Budget",
+            format_duration_short(budget)
+        ))
+    );
+    let mut over: Vec<&SpawnExec> = spawns
+        .iter()
+        .filter(|s| !is_cache_hit(s) && total_time(s) > budget)
+        .filter(|s| !exclude.iter().any(|p| wildcard_match(p, &s.mnemonic)))
+        .collect();
+    if over.is_empty() {
+        println!(
+            "No executed action took longer than {}.",
+            format_duration_short(budget)
+        );
+        println!();
+        return 0;
+    }
+    over.sort_by(|a, b| highest_first(a, b, total_time));
+    let mut table = Table::new(vec![
+        ("Label".to_string(), Align::Left),
+        ("Mnemonic".to_string(), Align::Left),
+        ("Duration".to_string(), Align::Right),
+        ("Runner".to_string(), Align::Left),
+    ]);
+    for spawn in &over {
+        table.add_row(vec![
+            if spawn.target_label.is_empty() {
+                NO_LABEL.to_string()
+            } else {
+                spawn.target_label.clone()
+            },
+            spawn.mnemonic.clone(),
+            format_duration(total_time(spawn), 2),
+            runner_label(spawn).to_string(),
+        ]);
+    }
+    table.print();
+    if !exclude.is_empty() {
+        println!(
+            "Mnemonics matching {} are exempt (--budget-exclude).",
+            exclude.join(", ")
+        );
+    }
+    println!();
+    over.len()
+}
@@ -0,0 +1,544 @@
+//! The text report: the overall summary, the top actions table and the mnemonic table,
+//! written from the [`Report`] model.
+
+use crate::classify::{is_cache_hit, is_timeout};
+use crate::cli::{ActionSort, Cli, MnemonicColumn};
+use crate::filter::FilterSummary;
+use crate::format::{
+    format_bytes, format_command_line, format_duration, format_seconds, format_timestamp,
+    is_param_file_arg, section, truncate_label, write_time_chart, Align, Table,
+};
+use crate::metrics::{output_bytes, total_time};
+use crate::paths;
+use crate::proto::SpawnExec;
+use crate::report::{action_rank, Renderer, Report};
+use crate::stats::DurationPercentiles;
+use crate::style::{paint, Style};
+use std::io::{self, IsTerminal, Write};
+use std::time::Duration;
+
+/// Mnemonics with fewer timed spawns than this show their max instead of p99.
+const MIN_PERCENTILE_SAMPLES: usize = 5;
+
+/// Hit rates below this percentage are shown in red, unless --min-hit-rate sets the bar.
+const LOW_HIT_RATE: f64 = 50.0;
+
+/// Target labels are never shortened below this, however narrow the terminal.
+const MIN_LABEL_WIDTH: usize = 24;
+
+/// Renders the report as the sections at the top of the text output, laid out for --top-n,
+/// --columns, --show-args and the other display flags.
+pub struct TextRenderer<'a> {
+    pub args: &'a Cli,
+}
+
+impl Renderer for TextRenderer<'_> {
+    fn render(&self, report: &Report, out: &mut dyn Write) -> io::Result<()> {
+        let args = self.args;
+        writeln!(
+            out,
+            "{}",
+            paint(Style::Dim, "========================================")
+        )?;
+        writeln!(out, " Bazel Execution Log Analysis Report")?;
+        writeln!(
+            out,
+            "{}",
+            paint(Style::Dim, "========================================")
+        )?;
+        match report.logs.as_slice() {
+            [file] => writeln!(out, "Log file: {}\n", file)?,
+            files => writeln!(out, "Log files: {}\n", files.join(", "))?,
+        }
+        write_summary(out, report, args)?;
+        if let Some(summary) = report.filter {
+            write_filter_summary(out, summary, report.spawns)?;
+        }
+        writeln!(out)?;
+        write_top_actions(out, report, args)?;
+        writeln!(out)?;
+        let mnemonic_count =
+            report.mnemonics.len() + report.other_mnemonics.as_ref().map_or(0, |o| o.0);
+        match args.top_mnemonics {
+            Some(limit) if limit < mnemonic_count => writeln!(
+                out,
+                "{}",
+                section(format_args!(
+                    "Analysis by Mnemonic (Top {} of {})",
+                    limit, mnemonic_count
+                ))
+            )?,
+            _ => writeln!(out, "{}", section("Analysis by Mnemonic"))?,
+        }
+        write_mnemonic_table(out, report, args)?;
+        out.flush()
+    }
+}
+
+/// The bar below which hit rates are shown in red.
+pub fn low_hit_rate(args: &Cli) -> f64 {
+    args.min_hit_rate.unwrap_or(LOW_HIT_RATE)
+}
+
+fn write_summary(out: &mut dyn Write, report: &Report, args: &Cli) -> io::Result<()> {
+    let summary = &report.summary;
+    writeln!(out, "{}", section("Overall Summary"))?;
+    writeln!(out, "Total Actions: {}", summary.actions)?;
+    let hit_rate = (summary.cache_hits as f64 / summary.actions as f64) * 100.0;
+    writeln!(
+        out,
+        "Cache Hits: {} ({})",
+        summary.cache_hits,
+        paint(
+            (hit_rate < low_hit_rate(args)).then_some(Style::Bad),
+            format!("{:.2}%", hit_rate)
+        )
+    )?;
+    match &report.durations {
+        Some(p) => writeln!(
+            out,
+            "Action Durations: p50 {} | p90 {} | p95 {} | p99 {} | max {}",
+            format_duration(p.p50, 3),
+            format_duration(p.p90, 3),
+            format_duration(p.p95, 3),
+            format_duration(p.p99, 3),
+            format_duration(p.max, 3)
+        )?,
+        None => writeln!(out, "Action Durations: N/A (no timing data recorded)")?,
+    }
+    write_wall_clock_span(out, report, !args.deterministic)?;
+    let coverage = &report.coverage;
+    if coverage.missing == 0 && coverage.total_only == 0 {
+        writeln!(out, "Timing data present for 100.0% of actions")?;
+    } else {
+        writeln!(
+            out,
+            "Timing data present for {:.1}% of actions ({} without metrics, {} without phases; see --metrics-coverage)",
+            coverage.timed_percent(),
+            coverage.missing,
+            coverage.total_only
+        )?;
+    }
+    if !report.timeouts.is_empty() {
+        let total: u64 = report.timeouts.iter().map(|(_, count)| count).sum();
+        let breakdown: Vec<String> = report
+            .timeouts
+            .iter()
+            .map(|(mnemonic, count)| format!("{}: {}", mnemonic, count))
+            .collect();
+        writeln!(
+            out,
+            "Timed Out Actions: {} ({})",
+            total,
+            breakdown.join(", ")
+        )?;
+    }
+    let repositories = &report.repositories;
+    if repositories.external.spawns > 0 {
+        writeln!(
+            out,
+            "External Repositories: {:.1}% of spawn time, {:.1}% cache hits",
+            repositories.external_time_percent(),
+            repositories.external.hit_rate()
+        )?;
+    }
+    if let Some((names, metrics)) = &report.infrastructure {
+        writeln!(
+            out,
+            "Infrastructure Actions: {} ({}), shown as one row of the mnemonic table (see --no-default-ignores)",
+            metrics.count,
+            names.join(", ")
+        )?;
+    }
+    Ok(())
+}
+
+/// Writes the wall-clock span of the log and the average concurrency it implies, with the
+/// times of its first start and last end when `timestamps` is set.
+fn write_wall_clock_span(out: &mut dyn Write, report: &Report, timestamps: bool) -> io::Result<()> {
+    let Some(span) = &report.wall_clock else {
+        return Ok(());
+    };
+    let wall = span.span();
+    let range = || {
+        format!(
+            "{} to {}",
+            format_timestamp(span.first_start),
+            format_timestamp(span.last_end)
+        )
+    };
+    if !span.is_plausible() {
+        writeln!(
+            out,
+            "Wall-Clock Span: N/A (timestamps {}span {:.1} days; clocks may be skewed)",
+            if timestamps {
+                format!("from {} ", range())
+            } else {
+                String::new()
+            },
+            wall.as_secs_f64() / 86_400.0
+        )?;
+    } else {
+        let concurrency = if wall.is_zero() {
+            String::new()
+        } else {
+            format!(
+                ", {} of spawn time = average concurrency {:.1}",
+                format_duration(report.started_time, 1),
+                report.started_time.as_secs_f64() / wall.as_secs_f64()
+            )
+        };
+        writeln!(
+            out,
+            "Wall-Clock Span: {}{}{}",
+            format_duration(wall, 1),
+            if timestamps {
+                format!(" ({})", range())
+            } else {
+                String::new()
+            },
+            concurrency
+        )?;
+    }
+    if span.negative_durations > 0 {
+        writeln!(
+            out,
+            "    └ WARNING: {} spawns end before they start (negative total time); only their start times are used",
+            span.negative_durations
+        )?;
+    }
+    Ok(())
+}
+
+/// Writes how the filtered subset relates to the whole log.
+pub fn write_filter_summary(
+    out: &mut dyn Write,
+    summary: &FilterSummary,
+    spawns: &[SpawnExec],
+) -> io::Result<()> {
+    let subset_duration: Duration = spawns.iter().map(total_time).sum();
+    let whole_seconds = summary.total_duration.as_secs_f64();
+    writeln!(out, "Filter: {}", summary.description)?;
+    writeln!(
+        out,
+        "Matching Actions: {} of {} ({:.2}%)",
+        spawns.len(),
+        summary.total_actions,
+        (spawns.len() as f64 / summary.total_actions as f64) * 100.0
+    )?;
+    if whole_seconds > 0.0 {
+        writeln!(
+            out,
+            "Share of Build Time: {} of {} ({:.2}%)",
+            format_duration(subset_duration, 2),
+            format_seconds(whole_seconds, 2),
+            (subset_duration.as_secs_f64() / whole_seconds) * 100.0
+        )?;
+    } else {
+        writeln!(out, "Share of Build Time: N/A (no timing data recorded)")?;
+    }
+    if !spawns.is_empty() {
+        let hits = spawns.iter().filter(|s| is_cache_hit(s)).count();
+        writeln!(
+            out,
+            "Cache Hit Rate: {:.2}% of matching actions (whole log: {:.2}%)",
+            hits as f64 / spawns.len() as f64 * 100.0,
+            summary.total_cache_hits as f64 / summary.total_actions as f64 * 100.0
+        )?;
+    }
+    if !summary.unrecorded_fields.is_empty() {
+        writeln!(
+            out,
+            "Guessed: no spawn of this log sets the {} field, which older Bazel versions do not record; the {} matching actions assume it is false",
+            summary.unrecorded_fields.join("/"),
+            summary.guessed_actions
+        )?;
+    }
+    Ok(())
+}
+
+/// Width to fit the top actions table to: --max-width, else the terminal's unless
+/// --deterministic is set, unless --full-labels turns shortening off.
+fn table_width(args: &Cli) -> Option<usize> {
+    if args.full_labels {
+        return None;
+    }
+    args.max_width.or_else(|| {
+        (io::stdout().is_terminal() && !args.deterministic)
+            .then(crossterm::terminal::size)
+            .and_then(Result::ok)
+            .map(|(columns, _)| columns as usize)
+    })
+}
+
+fn write_top_actions(out: &mut dyn Write, report: &Report, args: &Cli) -> io::Result<()> {
+    if args.top_n == usize::MAX {
+        writeln!(
+            out,
+            "{}",
+            section(format_args!(
+                "All {} {}",
+                report.summary.actions,
+                report.sort.ranking()
+            ))
+        )?;
+    } else {
+        writeln!(
+            out,
+            "{}",
+            section(format_args!("Top {} {}", args.top_n, report.sort.title()))
+        )?;
+    }
+    let header = match report.sort {
+        ActionSort::Duration => "Time",
+        ActionSort::Fetch => "Fetch",
+        ActionSort::Queue => "Queue",
+        ActionSort::OutputBytes => "Outputs",
+        ActionSort::Inputs => "Inputs",
+    };
+    let top_actions = &report.ranked[..args.top_n.min(report.ranked.len())];
+    let values: Vec<String> = top_actions
+        .iter()
+        .map(|spawn| match report.sort {
+            ActionSort::Duration => format_duration(total_time(spawn), 3),
+            ActionSort::Fetch | ActionSort::Queue => {
+                format_seconds(action_rank(spawn, report.sort) as f64 / 1e9, 3)
+            }
+            ActionSort::OutputBytes => format_bytes(output_bytes(spawn)),
+            ActionSort::Inputs => spawn.inputs.len().to_string(),
+        })
+        .collect();
+    // Labels get what is left of the width once the other columns fit their contents.
+    let label_width = table_width(args).map(|width| {
+        let widest = |cells: Vec<usize>, header: &str| {
+            cells.into_iter().max().unwrap_or(0).max(header.len())
+        };
+        let value_width = widest(values.iter().map(|v| v.chars().count()).collect(), header);
+        let mnemonic_width = widest(
+            top_actions
+                .iter()
+                .map(|s| s.mnemonic.chars().count())
+                .collect(),
+            "Mnemonic",
+        );
+        width
+            .saturating_sub(value_width + mnemonic_width + 6)
+            .max(MIN_LABEL_WIDTH)
+    });
+    let mut table = Table::new(vec![
+        (header.to_string(), Align::Right),
+        ("Mnemonic".to_string(), Align::Left),
+        ("Target".to_string(), Align::Left),
+    ]);
+    for (spawn, value) in top_actions.iter().zip(values) {
+        let tag = if is_timeout(spawn) { " [TIMEOUT]" } else { "" };
+        let label = match label_width {
+            Some(width) => truncate_label(&spawn.target_label, width.saturating_sub(tag.len())),
+            None => spawn.target_label.clone(),
+        };
+        let tag = paint((!tag.is_empty()).then_some(Style::Bad), tag);
+        table.add_row(vec![
+            value,
+            spawn.mnemonic.clone(),
+            format!("{}{}", label, tag),
+        ]);
+    }
+
+    let lines = table.lines();
+    for line in &lines[..2] {
+        writeln!(out, "{}", line)?;
+    }
+    for (spawn, line) in top_actions.iter().zip(&lines[2..]) {
+        writeln!(out, "{}", line)?;
+        if args.show_args {
+            write_command_line(out, spawn, args.args_limit)?;
+        }
+    }
+    Ok(())
+}
+
+/// Writes the (possibly truncated) command line of a spawn, noting any param files it
+/// references.
+fn write_command_line(out: &mut dyn Write, spawn: &SpawnExec, args_limit: usize) -> io::Result<()> {
+    if spawn.command_args.is_empty() {
+        return writeln!(out, "    (no command line recorded)");
+    }
+    let limit = if args_limit == 0 {
+        None
+    } else {
+        Some(args_limit)
+    };
+    writeln!(
+        out,
+        "    $ {}",
+        format_command_line(&spawn.command_args, limit)
+    )?;
+    for param_file in spawn.command_args.iter().filter(|a| is_param_file_arg(a)) {
+        writeln!(
+            out,
+            "    └ Param file: {} (flags inside are not shown)",
+            paths::relative(&param_file[1..])
+        )?;
+    }
+    Ok(())
+}
+
+fn write_mnemonic_table(out: &mut dyn Write, report: &Report, args: &Cli) -> io::Result<()> {
+    let show = |column: MnemonicColumn| args.columns.contains(&column);
+    let mut columns = vec![("Mnemonic".to_string(), Align::Left)];
+    for (column, header) in [
+        (MnemonicColumn::Count, "Count"),
+        (MnemonicColumn::Hits, "Hits"),
+        (MnemonicColumn::Total, "Total"),
+        (MnemonicColumn::Miss, "Miss Time"),
+        (MnemonicColumn::Share, "% Total"),
+        (MnemonicColumn::Cumulative, "Cum %"),
+        (MnemonicColumn::Avg, "Avg"),
+        (MnemonicColumn::Min, "Min"),
+        (MnemonicColumn::Median, "Med"),
+        (MnemonicColumn::Max, "Max"),
+        (MnemonicColumn::Exec, "Exec %"),
+    ] {
+        if show(column) {
+            columns.push((header.to_string(), Align::Right));
+        }
+    }
+    if args.percentiles {
+        columns.push(("p50".to_string(), Align::Right));
+        columns.push(("p90".to_string(), Align::Right));
+        columns.push(("p99".to_string(), Align::Right));
+    }
+    let mut table = Table::new(columns);
+    let mut max_substituted = false;
+    let mut exec_unavailable = 0;
+
+    // Shares are computed from unrounded durations against the (filtered) sum of all spawns.
+    let grand_total = report.mnemonic_total.as_secs_f64();
+    let share_of_total = |duration: Duration| {
+        if grand_total > 0.0 {
+            duration.as_secs_f64() / grand_total * 100.0
+        } else {
+            0.0
+        }
+    };
+    let mut cumulative = Duration::ZERO;
+
+    let other = report
+        .other_mnemonics
+        .as_ref()
+        .map(|(count, metrics)| (format!("other ({} mnemonics)", count), metrics));
+    let infrastructure = report
+        .infrastructure
+        .as_ref()
+        .map(|(_, metrics)| ("infrastructure actions".to_string(), metrics));
+    let rows = report
+        .mnemonics
+        .iter()
+        .map(|row| (row.mnemonic.clone(), &row.metrics))
+        .chain(other)
+        .chain(infrastructure);
+    for (mnemonic, metrics) in rows {
+        // Spawns without a recorded total time are left out rather than averaged in as zero.
+        let avg_time = if metrics.durations.is_empty() {
+            "N/A".to_string()
+        } else {
+            format_seconds(
+                metrics.total_duration.as_secs_f64() / metrics.durations.len() as f64,
+                3,
+            )
+        };
+        let mut durations = metrics.durations.clone();
+        let distribution = DurationPercentiles::compute(&mut durations);
+        let seconds = |pick: fn(&DurationPercentiles) -> Duration| {
+            distribution
+                .as_ref()
+                .map_or("N/A".to_string(), |p| format_duration(pick(p), 3))
+        };
+
+        let mut row = vec![mnemonic];
+        if show(MnemonicColumn::Count) {
+            row.push(metrics.count.to_string());
+        }
+        if show(MnemonicColumn::Hits) {
+            let hit_rate = metrics.hit_rate();
+            row.push(paint(
+                (hit_rate < low_hit_rate(args)).then_some(Style::Bad),
+                format!("{:.1}%", hit_rate),
+            ));
+        }
+        if show(MnemonicColumn::Total) {
+            row.push(format_duration(metrics.total_duration, 2));
+        }
+        if show(MnemonicColumn::Miss) {
+            row.push(format_duration(metrics.miss_duration, 2));
+        }
+        cumulative += metrics.total_duration;
+        if show(MnemonicColumn::Share) {
+            row.push(format!("{:.1}%", share_of_total(metrics.total_duration)));
+        }
+        if show(MnemonicColumn::Cumulative) {
+            row.push(format!("{:.1}%", share_of_total(cumulative)));
+        }
+        if show(MnemonicColumn::Avg) {
+            row.push(avg_time);
+        }
+        if show(MnemonicColumn::Min) {
+            row.push(seconds(|p| p.min));
+        }
+        if show(MnemonicColumn::Median) {
+            row.push(seconds(|p| p.p50));
+        }
+        if show(MnemonicColumn::Max) {
+            row.push(seconds(|p| p.max));
+        }
+        if show(MnemonicColumn::Exec) {
+            match metrics.exec_fraction() {
+                Some(fraction) => row.push(format!("{:.1}%", fraction * 100.0)),
+                None => {
+                    exec_unavailable += 1;
+                    row.push("n/a".to_string());
+                }
+            }
+        }
+        if args.percentiles {
+            row.push(seconds(|p| p.p50));
+            row.push(seconds(|p| p.p90));
+            if distribution.is_some() && durations.len() < MIN_PERCENTILE_SAMPLES {
+                // A p99 over a handful of samples is meaningless; show the raw max instead.
+                max_substituted = true;
+                row.push(format!("{}*", seconds(|p| p.max)));
+            } else {
+                row.push(seconds(|p| p.p99));
+            }
+        }
+        table.add_row(row);
+    }
+    for line in table.lines() {
+        writeln!(out, "{}", line)?;
+    }
+    if max_substituted {
+        writeln!(
+            out,
+            "* fewer than {} timed samples: the maximum is shown instead of p99",
+            MIN_PERCENTILE_SAMPLES
+        )?;
+    }
+    if exec_unavailable > 0 {
+        writeln!(
+            out,
+            "n/a: {} mnemonics record execution wall time for fewer than half of their spawns",
+            exec_unavailable
+        )?;
+    }
+    writeln!(
+        out,
+        "Cache misses account for {} ({:.1}% of recorded spawn time)",
+        format_duration(report.miss_time, 2),
+        share_of_total(report.miss_time)
+    )?;
+    if let Some(options) = args.chart_options() {
+        writeln!(out)?;
+        write_time_chart(&mut *out, &report.mnemonic_times, &options)?;
+    }
+    writeln!(out)
+}
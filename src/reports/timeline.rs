@@ -0,0 +1,218 @@
+//! Standalone HTML/SVG timeline of spawns, positioned by their start timestamps.
+
+use crate::classify::is_cache_hit;
+use crate::cli::TimelineRows;
+use crate::error::{AppError, AppResult};
+use crate::format::format_duration;
+use crate::metrics::{recorded_total_time, start_time};
+use crate::proto::SpawnExec;
+use std::collections::HashMap;
+use std::fmt::Write as _;
+use std::path::Path;
+use std::time::Duration;
+
+const CHART_WIDTH: f64 = 1400.0;
+const LABEL_WIDTH: f64 = 220.0;
+const ROW_HEIGHT: f64 = 18.0;
+const AXIS_HEIGHT: f64 = 24.0;
+const TICKS: usize = 10;
+
+const HIT_COLOR: &str = "#4caf50";
+const EXECUTED_COLOR: &str = "#4a7fd4";
+const FAILED_COLOR: &str = "#d9534f";
+
+struct Bar<'a> {
+    start: Duration,
+    duration: Duration,
+    row: usize,
+    spawn: &'a SpawnExec,
+}
+
+/// What the timeline left out of one row to stay under the bar limit.
+#[derive(Default)]
+struct Omitted {
+    spawns: u64,
+    time: Duration,
+}
+
+/// Assigns each spawn to the first lane that is free at its start, like a scheduler with
+/// as many slots as the peak concurrency. `bars` must be sorted by start time.
+fn assign_slots(bars: &mut [Bar<'_>]) -> usize {
+    let mut lane_ends: Vec<Duration> = Vec::new();
+    for bar in bars.iter_mut() {
+        let lane = match lane_ends.iter().position(|end| *end <= bar.start) {
+            Some(lane) => lane,
+            None => {
+                lane_ends.push(Duration::ZERO);
+                lane_ends.len() - 1
+            }
+        };
+        lane_ends[lane] = bar.start + bar.duration;
+        bar.row = lane;
+    }
+    lane_ends.len()
+}
+
+/// Assigns each spawn to its mnemonic's row, with the mnemonics taking the most time first.
+fn assign_mnemonics(bars: &mut [Bar<'_>]) -> Vec<String> {
+    let mut totals: HashMap<&str, Duration> = HashMap::new();
+    for bar in bars.iter() {
+        *totals.entry(bar.spawn.mnemonic.as_str()).or_default() += bar.duration;
+    }
+    let mut names: Vec<(&str, Duration)> = totals.into_iter().collect();
+    names.sort_by(|a, b| b.1.cmp(&a.1).then(a.0.cmp(b.0)));
+    let rows: HashMap<&str, usize> = names
+        .iter()
+        .enumerate()
+        .map(|(row, (name, _))| (*name, row))
+        .collect();
+    for bar in bars.iter_mut() {
+        bar.row = rows[bar.spawn.mnemonic.as_str()];
+    }
+    names.into_iter().map(|(name, _)| name.to_string()).collect()
+}
+
+fn escape(text: &str) -> String {
+    text.replace('&', "&amp;")
+        .replace('<', "&lt;")
+        .replace('>', "&gt;")
+        .replace('"', "&quot;")
+}
+
+/// Writes the timeline to `path` and returns the number of bars drawn.
+///
+/// At most `max_bars` spawns are drawn, the longest ones; the others are summed up per row
+/// in the row's label. Fails when no spawn recorded a start time.
+pub fn write_timeline(
+    spawns: &[SpawnExec],
+    path: &Path,
+    rows: TimelineRows,
+    max_bars: usize,
+) -> AppResult<usize> {
+    let mut bars: Vec<Bar> = spawns
+        .iter()
+        .filter_map(|spawn| {
+            Some(Bar {
+                start: start_time(spawn)?,
+                duration: recorded_total_time(spawn)?,
+                row: 0,
+                spawn,
+            })
+        })
+        .collect();
+    if bars.is_empty() {
+        return Err(AppError::Analysis(
+            "no spawn start times found in the log, so no timeline can be exported".to_string(),
+        ));
+    }
+    bars.sort_by_key(|b| b.start);
+
+    let row_names: Vec<String> = match rows {
+        TimelineRows::Mnemonic => assign_mnemonics(&mut bars),
+        TimelineRows::Slot => (1..=assign_slots(&mut bars))
+            .map(|slot| format!("slot {}", slot))
+            .collect(),
+    };
+
+    let build_start = bars[0].start;
+    let build_end = bars.iter().map(|b| b.start + b.duration).max().unwrap();
+    let wall = (build_end - build_start).as_secs_f64().max(1e-3);
+
+    let mut omitted: Vec<Omitted> = (0..row_names.len()).map(|_| Omitted::default()).collect();
+    if bars.len() > max_bars {
+        bars.sort_by(|a, b| b.duration.cmp(&a.duration).then(a.start.cmp(&b.start)));
+        for bar in bars.drain(max_bars..) {
+            omitted[bar.row].spawns += 1;
+            omitted[bar.row].time += bar.duration;
+        }
+    }
+
+    let scale = CHART_WIDTH / wall;
+    let width = LABEL_WIDTH + CHART_WIDTH + 30.0;
+    let height = AXIS_HEIGHT + ROW_HEIGHT * row_names.len() as f64 + 10.0;
+
+    let mut svg = String::new();
+    let _ = writeln!(
+        svg,
+        r#"<svg xmlns="http://www.w3.org/2000/svg" width="{:.0}" height="{:.0}" font-family="sans-serif" font-size="11">"#,
+        width, height
+    );
+    for tick in 0..=TICKS {
+        let x = LABEL_WIDTH + CHART_WIDTH * tick as f64 / TICKS as f64;
+        let _ = writeln!(
+            svg,
+            r##"<line x1="{x:.1}" y1="{}" x2="{x:.1}" y2="{height:.0}" stroke="#ddd"/><text x="{x:.1}" y="14" text-anchor="middle">{:.1}s</text>"##,
+            AXIS_HEIGHT - 4.0,
+            wall * tick as f64 / TICKS as f64
+        );
+    }
+    for (row, name) in row_names.iter().enumerate() {
+        let y = AXIS_HEIGHT + ROW_HEIGHT * row as f64;
+        let title = match &omitted[row] {
+            o if o.spawns > 0 => format!(
+                "{}: {} shorter spawns ({}) not drawn",
+                name,
+                o.spawns,
+                format_duration(o.time, 2)),
+            _ => name.clone(),
+        };
+        let _ = writeln!(
+            svg,
+            r#"<text x="4" y="{:.1}"><title>{}</title>{}{}</text>"#,
+            y + ROW_HEIGHT * 0.7,
+            escape(&title),
+            escape(name),
+            if omitted[row].spawns > 0 { " (+)" } else { "" }
+        );
+    }
+    for bar in &bars {
+        let color = if bar.spawn.exit_code != 0 {
+            FAILED_COLOR
+        } else if is_cache_hit(bar.spawn) {
+            HIT_COLOR
+        } else {
+            EXECUTED_COLOR
+        };
+        let x = LABEL_WIDTH + (bar.start - build_start).as_secs_f64() * scale;
+        let y = AXIS_HEIGHT + ROW_HEIGHT * bar.row as f64 + 2.0;
+        let title = format!(
+            "{} {}\n{} on {}",
+            bar.spawn.mnemonic,
+            bar.spawn.target_label,
+            format_duration(bar.duration, 3),
+            bar.spawn.runner
+        );
+        let _ = writeln!(
+            svg,
+            r#"<rect x="{:.2}" y="{:.1}" width="{:.2}" height="{:.1}" fill="{}"><title>{}</title></rect>"#,
+            x,
+            y,
+            (bar.duration.as_secs_f64() * scale).max(0.5),
+            ROW_HEIGHT - 4.0,
+            color,
+            escape(&title)
+        );
+    }
+    svg.push_str("</svg>\n");
+
+    let html = format!(
+        r#"<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Spawn timeline</title>
+</head>
+<body style="font-family: sans-serif">
+<h3>Spawn timeline ({} spawns drawn, {:.1}s wall time)</h3>
+<p><span style="color: {HIT_COLOR}">&#9632;</span> cache hit
+<span style="color: {EXECUTED_COLOR}">&#9632;</span> executed
+<span style="color: {FAILED_COLOR}">&#9632;</span> failed</p>
+{svg}</body>
+</html>
+"#,
+        bars.len(),
+        wall
+    );
+    std::fs::write(path, html)?;
+    Ok(bars.len())
+}
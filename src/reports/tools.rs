@@ -0,0 +1,169 @@
+//! Time per tool binary, taken from the first argument of each spawn's command line.
+//!
+//! Several mnemonics often shell out to the same wrapper script or compiler, which a
+//! per-mnemonic breakdown hides. Command lines are part of both log formats, so this works on
+//! compact logs without reconstructing inputs.
+
+use crate::format::{format_duration, format_seconds, section, top_label, Align, Table};
+use crate::metrics::recorded_total_time;
+use crate::proto::SpawnExec;
+use std::collections::HashMap;
+use std::time::Duration;
+
+/// Launchers that run another program given later on their command line.
+const WRAPPERS: [&str; 6] = [
+    "process-wrapper",
+    "linux-sandbox",
+    "darwin-sandbox",
+    "test-setup.sh",
+    "env",
+    "xcrun",
+];
+
+/// Shells whose `-c` script names the real tool.
+const SHELLS: [&str; 3] = ["bash", "sh", "zsh"];
+
+/// Shell commands that only prepare the environment for the tool that follows.
+const SETUP_COMMANDS: [&str; 5] = ["source", ".", "set", "export", "cd"];
+
+/// Bucket for spawns that recorded no command line.
+pub const NO_COMMAND: &str = "(no command line)";
+
+/// Mnemonics named per tool before the rest are summarized.
+const MAX_MNEMONICS_SHOWN: usize = 3;
+
+/// Tool names longer than this are truncated.
+const MAX_TOOL_LEN: usize = 60;
+
+fn truncated(value: &str) -> String {
+    if value.chars().count() > MAX_TOOL_LEN {
+        let prefix: String = value.chars().take(MAX_TOOL_LEN).collect();
+        format!("{}...", prefix)
+    } else {
+        value.to_string()
+    }
+}
+
+fn basename(path: &str) -> &str {
+    path.rsplit('/').next().unwrap_or(path)
+}
+
+/// The first command of a shell script that is not a setup command, e.g. `python3` for
+/// `source genrule-setup.sh; python3 gen.py`.
+fn script_command(script: &str) -> Option<&str> {
+    script
+        .split(|c| c == ';' || c == '\n' || c == '&' || c == '|')
+        .filter_map(|command| command.split_whitespace().next())
+        .find(|word| !SETUP_COMMANDS.contains(word))
+}
+
+/// Returns the program a command line runs, looking through known wrappers and `sh -c`.
+/// The full path is kept when `full_path` is set, otherwise only its basename.
+pub fn command_tool(args: &[String], full_path: bool) -> Option<&str> {
+    let mut rest = args;
+    loop {
+        let (program, tail) = rest.split_first()?;
+        let name = basename(program);
+        if SHELLS.contains(&name) {
+            if let Some(index) = tail.iter().position(|a| a == "-c") {
+                if let Some(tool) = tail.get(index + 1).and_then(|s| script_command(s)) {
+                    return Some(if full_path { tool } else { basename(tool) });
+                }
+            }
+        } else if WRAPPERS.contains(&name) {
+            // Wrappers either separate their own options with `--` or take the first argument
+            // that is neither an option nor (for env) a variable assignment.
+            let start = match tail.iter().position(|a| a == "--") {
+                Some(separator) => separator + 1,
+                None => tail
+                    .iter()
+                    .position(|a| !a.starts_with('-') && !a.contains('='))
+                    .unwrap_or(tail.len()),
+            };
+            if start < tail.len() {
+                rest = &tail[start..];
+                continue;
+            }
+        }
+        return Some(if full_path { program } else { name });
+    }
+}
+
+#[derive(Default)]
+struct ToolMetrics<'a> {
+    count: u64,
+    /// Spawns that recorded a total time, which the average is taken over.
+    timed: u64,
+    total_duration: Duration,
+    mnemonics: HashMap<&'a str, u64>,
+}
+
+/// Prints the `top_n` tools by total time with the mnemonics that invoke them.
+pub fn print_tool_report(spawns: &[SpawnExec], full_path: bool, top_n: usize) {
+    println!("{}", section(format_args!("{} Tools by Total Time", top_label(top_n))));
+
+    let mut tools: HashMap<&str, ToolMetrics> = HashMap::new();
+    for spawn in spawns {
+        let tool = command_tool(&spawn.command_args, full_path).unwrap_or(NO_COMMAND);
+        let metrics = tools.entry(tool).or_default();
+        metrics.count += 1;
+        if let Some(duration) = recorded_total_time(spawn) {
+            metrics.timed += 1;
+            metrics.total_duration += duration;
+        }
+        *metrics
+            .mnemonics
+            .entry(spawn.mnemonic.as_str())
+            .or_default() += 1;
+    }
+    if tools.is_empty() {
+        println!("No spawns found in the log.");
+        println!();
+        return;
+    }
+
+    let mut sorted: Vec<_> = tools.into_iter().collect();
+    sorted.sort_by(|(a_name, a), (b_name, b)| {
+        b.total_duration
+            .cmp(&a.total_duration)
+            .then_with(|| a_name.cmp(b_name))
+    });
+    let mut table = Table::new(vec![
+        ("Tool".to_string(), Align::Left),
+        ("Count".to_string(), Align::Right),
+        ("Total Time".to_string(), Align::Right),
+        ("Avg Time".to_string(), Align::Right),
+        ("Mnemonics".to_string(), Align::Left),
+    ]);
+    for (tool, metrics) in sorted.iter().take(top_n) {
+        let avg_time = if metrics.timed > 0 {
+            format_seconds(metrics.total_duration.as_secs_f64() / metrics.timed as f64, 3)
+        } else {
+            "N/A".to_string()
+        };
+        let mut mnemonics: Vec<(&str, u64)> =
+            metrics.mnemonics.iter().map(|(m, c)| (*m, *c)).collect();
+        mnemonics.sort_by(|a, b| b.1.cmp(&a.1).then(a.0.cmp(b.0)));
+        let mut names: Vec<String> = mnemonics
+            .iter()
+            .take(MAX_MNEMONICS_SHOWN)
+            .map(|(mnemonic, count)| format!("{} ({})", mnemonic, count))
+            .collect();
+        if mnemonics.len() > MAX_MNEMONICS_SHOWN {
+            names.push(format!("+{} more", mnemonics.len() - MAX_MNEMONICS_SHOWN));
+        }
+        table.add_row(vec![
+            truncated(tool),
+            metrics.count.to_string(),
+            format_duration(metrics.total_duration, 2),
+            avg_time,
+            names.join(", "),
+        ]);
+    }
+    table.print();
+    if sorted.len() > top_n {
+        println!("... (+{} more tools)", sorted.len() - top_n);
+    }
+    println!("Note: The tool is the first argument of each command line, looking through process-wrapper, sandboxes, env and `sh -c` scripts.");
+    println!();
+}
@@ -0,0 +1,342 @@
+//! Explains expensive cache misses by diffing them against a comparable cache hit.
+
+use crate::classify::{is_cache_hit, runner_label};
+use crate::format::{format_duration, section};
+use crate::metrics::total_time;
+use crate::proto::SpawnExec;
+use crate::report::highest_first;
+use crate::reports::grouping::NO_LABEL;
+use std::collections::{BTreeSet, HashMap};
+
+/// Hits of the same mnemonic compared against each miss when none has the same target;
+/// the most similar one is used.
+const MAX_CANDIDATES: usize = 200;
+
+/// Keys named in one explanation before the rest is summarized.
+const MAX_KEYS_SHOWN: usize = 5;
+
+/// Positional arguments quoted in one explanation.
+const MAX_POSITIONAL_SHOWN: usize = 2;
+
+/// Quoted arguments longer than this are truncated.
+const MAX_ARG_LEN: usize = 60;
+
+/// A flag's name without its value, or `None` for positional arguments.
+fn flag_name(arg: &str) -> Option<&str> {
+    arg.starts_with('-')
+        .then(|| arg.split_once('=').map_or(arg, |(name, _)| name))
+}
+
+fn label(spawn: &SpawnExec) -> &str {
+    if spawn.target_label.is_empty() {
+        NO_LABEL
+    } else {
+        &spawn.target_label
+    }
+}
+
+fn quote_some(args: &[String]) -> String {
+    let mut quoted: Vec<String> = args
+        .iter()
+        .take(MAX_POSITIONAL_SHOWN)
+        .map(|arg| {
+            if arg.chars().count() > MAX_ARG_LEN {
+                let prefix: String = arg.chars().take(MAX_ARG_LEN).collect();
+                format!("{:?}...", prefix)
+            } else {
+                format!("{:?}", arg)
+            }
+        })
+        .collect();
+    if args.len() > MAX_POSITIONAL_SHOWN {
+        quoted.push("...".to_string());
+    }
+    quoted.join(" ")
+}
+
+/// The keys whose values differ between two spawns, in a stable order.
+#[derive(Default)]
+struct Differences {
+    env: BTreeSet<String>,
+    flags: BTreeSet<String>,
+    /// Positional arguments only the miss has, and only the hit has.
+    positional: (Vec<String>, Vec<String>),
+    platform: BTreeSet<String>,
+}
+
+impl Differences {
+    fn between(miss: &SpawnExec, hit: &SpawnExec) -> Self {
+        let mut differences = Differences::default();
+
+        let env = |spawn: &SpawnExec| -> HashMap<String, String> {
+            spawn
+                .environment_variables
+                .iter()
+                .map(|v| (v.name.clone(), v.value.clone()))
+                .collect()
+        };
+        let (miss_env, hit_env) = (env(miss), env(hit));
+        for (name, value) in &miss_env {
+            if hit_env.get(name) != Some(value) {
+                differences.env.insert(name.clone());
+            }
+        }
+        for name in hit_env.keys().filter(|name| !miss_env.contains_key(*name)) {
+            differences.env.insert(name.clone());
+        }
+
+        // Arguments are compared as sets, so reordering alone does not count as a difference.
+        let miss_args: BTreeSet<&str> = miss.command_args.iter().map(String::as_str).collect();
+        let hit_args: BTreeSet<&str> = hit.command_args.iter().map(String::as_str).collect();
+        for arg in miss_args.difference(&hit_args) {
+            match flag_name(arg) {
+                Some(name) => drop(differences.flags.insert(name.to_string())),
+                None => differences.positional.0.push(arg.to_string()),
+            }
+        }
+        for arg in hit_args.difference(&miss_args) {
+            match flag_name(arg) {
+                Some(name) => drop(differences.flags.insert(name.to_string())),
+                None => differences.positional.1.push(arg.to_string()),
+            }
+        }
+
+        let properties = |spawn: &SpawnExec| -> BTreeSet<(String, String)> {
+            spawn
+                .platform
+                .iter()
+                .flat_map(|p| &p.properties)
+                .map(|p| (p.name.clone(), p.value.clone()))
+                .collect()
+        };
+        for (name, _) in properties(miss).symmetric_difference(&properties(hit)) {
+            differences.platform.insert(name.clone());
+        }
+        differences
+    }
+
+    fn count(&self) -> usize {
+        let positional = !self.positional.0.is_empty() || !self.positional.1.is_empty();
+        self.env.len() + self.flags.len() + positional as usize + self.platform.len()
+    }
+
+    /// E.g. `env PATH differs; arg -fdebug-prefix-map differs`.
+    fn explain(&self) -> String {
+        let mut parts: Vec<String> = self
+            .env
+            .iter()
+            .map(|name| format!("env {} differs", name))
+            .chain(self.flags.iter().map(|name| format!("arg {} differs", name)))
+            .chain(
+                self.platform
+                    .iter()
+                    .map(|name| format!("platform property {} differs", name)),
+            )
+            .collect();
+        let (miss_only, hit_only) = &self.positional;
+        if !miss_only.is_empty() || !hit_only.is_empty() {
+            parts.push(format!(
+                "positional args differ ({} vs {})",
+                quote_some(miss_only),
+                quote_some(hit_only)
+            ));
+        }
+        if parts.len() > MAX_KEYS_SHOWN {
+            let more = parts.len() - MAX_KEYS_SHOWN;
+            parts.truncate(MAX_KEYS_SHOWN);
+            parts.push(format!("{} more differences", more));
+        }
+        parts.join("; ")
+    }
+}
+
+/// Picks the hit to compare a miss against: a hit of the same target if there is one,
+/// otherwise the most similar hit of the same mnemonic. Returns the hit, whether it is of
+/// the same target, and the differences.
+fn comparable_hit<'a>(
+    miss: &SpawnExec,
+    hits: &[&'a SpawnExec],
+) -> Option<(&'a SpawnExec, bool, Differences)> {
+    let same_target: Vec<&SpawnExec> = hits
+        .iter()
+        .copied()
+        .filter(|h| !miss.target_label.is_empty() && h.target_label == miss.target_label)
+        .collect();
+    let (candidates, same) = if same_target.is_empty() {
+        (hits.iter().copied().take(MAX_CANDIDATES).collect(), false)
+    } else {
+        (same_target, true)
+    };
+    candidates
+        .into_iter()
+        .map(|hit| (hit, Differences::between(miss, hit)))
+        .min_by_key(|(_, differences)| differences.count())
+        .map(|(hit, differences)| (hit, same, differences))
+}
+
+/// For the `top_n` most expensive cache misses, prints which environment variables,
+/// arguments and platform properties differ from a comparable cache hit in the same log.
+pub fn print_why_miss_report(spawns: &[SpawnExec], top_n: usize) {
+    println!("{}", section("Why Did These Actions Miss the Cache?"));
+
+    let mut hits_by_mnemonic: HashMap<&str, Vec<&SpawnExec>> = HashMap::new();
+    for spawn in spawns.iter().filter(|s| is_cache_hit(s)) {
+        hits_by_mnemonic
+            .entry(spawn.mnemonic.as_str())
+            .or_default()
+            .push(spawn);
+    }
+    let mut misses: Vec<&SpawnExec> = spawns.iter().filter(|s| !is_cache_hit(s)).collect();
+    if misses.is_empty() {
+        println!("No cache misses found in the log.");
+        println!();
+        return;
+    }
+    misses.sort_by(|a, b| highest_first(a, b, total_time));
+
+    for (index, miss) in misses.iter().take(top_n).enumerate() {
+        println!(
+            "  {:>2}. {} | {} | {} ({})",
+            index + 1,
+            format_duration(total_time(miss), 3),
+            miss.mnemonic,
+            label(miss),
+            runner_label(miss)
+        );
+        let hits = hits_by_mnemonic
+            .get(miss.mnemonic.as_str())
+            .map_or(&[][..], Vec::as_slice);
+        match comparable_hit(miss, hits) {
+            None => println!("      └ no cache hit of {} in this log to compare with", miss.mnemonic),
+            Some((_, true, differences)) if differences.count() == 0 => println!(
+                "      └ same environment, arguments and platform as a hit of the same target; the inputs must differ"
+            ),
+            Some((_, true, differences)) => {
+                println!("      └ vs. a hit of the same target: {}", differences.explain())
+            }
+            Some((hit, false, differences)) => println!(
+                "      └ vs. the most similar hit ({}): {}",
+                label(hit),
+                if differences.count() == 0 {
+                    "no difference in environment, arguments or platform".to_string()
+                } else {
+                    differences.explain()
+                }
+            ),
+        }
+    }
+    println!("Note: Inputs are not compared; a miss with no other difference usually has changed inputs.");
+    println!();
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::proto::platform::Property;
+    use crate::proto::{EnvironmentVariable, Platform};
+    use crate::testing::spawn;
+
+    /// A CppCompile spawn of `target` with `env` (`NAME=value`) and `args`.
+    fn compile(target: &str, runner: &str, env: &[&str], args: &[&str]) -> SpawnExec {
+        SpawnExec {
+            target_label: target.to_string(),
+            environment_variables: env
+                .iter()
+                .map(|pair| {
+                    let (name, value) = pair.split_once('=').unwrap();
+                    EnvironmentVariable {
+                        name: name.to_string(),
+                        value: value.to_string(),
+                    }
+                })
+                .collect(),
+            command_args: args.iter().map(|arg| arg.to_string()).collect(),
+            ..spawn("CppCompile", runner, 1_000)
+        }
+    }
+
+    fn explain(miss: &SpawnExec, hits: &[SpawnExec]) -> Option<(String, bool, String)> {
+        let hits: Vec<&SpawnExec> = hits.iter().collect();
+        comparable_hit(miss, &hits)
+            .map(|(hit, same, differences)| (label(hit).to_string(), same, differences.explain()))
+    }
+
+    #[test]
+    fn env_and_flag_differences_are_named() {
+        let miss = compile(
+            "//a",
+            "linux-sandbox",
+            &["PATH=/usr/bin:/home/me/bin", "LANG=C"],
+            &["gcc", "-fdebug-prefix-map=/home/me=.", "-c", "a.cc"],
+        );
+        let hit = compile(
+            "//a",
+            "remote cache hit",
+            &["PATH=/usr/bin", "LANG=C"],
+            &["gcc", "-c", "a.cc"],
+        );
+        assert_eq!(
+            explain(&miss, &[hit]),
+            Some((
+                "//a".to_string(),
+                true,
+                "env PATH differs; arg -fdebug-prefix-map differs".to_string()
+            ))
+        );
+    }
+
+    #[test]
+    fn a_hit_of_the_same_target_is_preferred_over_a_closer_one() {
+        let miss = compile("//a", "local", &["CC=clang"], &["cc", "a.cc"]);
+        let hits = [
+            compile("//b", "disk cache hit", &["CC=clang"], &["cc", "a.cc"]),
+            compile("//a", "disk cache hit", &["CC=gcc"], &["cc", "a.cc"]),
+        ];
+        let (target, same, explanation) = explain(&miss, &hits).unwrap();
+        assert_eq!((target.as_str(), same), ("//a", true));
+        assert_eq!(explanation, "env CC differs");
+    }
+
+    #[test]
+    fn without_a_hit_of_the_target_the_most_similar_one_is_used() {
+        let miss = compile("//a", "local", &["CC=clang"], &["cc", "-O2", "a.cc"]);
+        let hits = [
+            compile("//b", "disk cache hit", &["CC=gcc"], &["cc", "-O0", "b.cc"]),
+            compile("//c", "disk cache hit", &["CC=clang"], &["cc", "-O2", "c.cc"]),
+        ];
+        assert_eq!(
+            explain(&miss, &hits),
+            Some((
+                "//c".to_string(),
+                false,
+                "positional args differ (\"a.cc\" vs \"c.cc\")".to_string()
+            ))
+        );
+        assert_eq!(explain(&miss, &[]), None);
+    }
+
+    #[test]
+    fn reordered_args_are_not_a_difference() {
+        let miss = compile("//a", "local", &[], &["cc", "-c", "-O2", "a.cc"]);
+        let hit = compile("//a", "remote cache hit", &[], &["cc", "-O2", "-c", "a.cc"]);
+        assert_eq!(Differences::between(&miss, &hit).count(), 0);
+    }
+
+    #[test]
+    fn platform_properties_are_compared_and_long_lists_summarized() {
+        let mut miss = compile("//a", "remote", &["A=1", "B=1", "C=1", "D=1", "E=1"], &[]);
+        let hit = compile("//a", "remote cache hit", &[], &[]);
+        miss.platform = Some(Platform {
+            properties: vec![Property {
+                name: "container-image".to_string(),
+                value: "docker://new".to_string(),
+            }],
+        });
+        let differences = Differences::between(&miss, &hit);
+        assert_eq!(differences.count(), 6);
+        assert_eq!(
+            differences.explain(),
+            "env A differs; env B differs; env C differs; env D differs; env E differs; 1 more differences"
+        );
+    }
+}
@@ -0,0 +1,50 @@
+//! The machine-readable output, in one place for tools built on it.
+//!
+//! Every JSON document carries `schema_version` at its top level: the `--output-format json`
+//! documents of the report, `diff`, `compare` and `trend`, the `--serve` endpoints, each
+//! `--listing-format jsonl` record (and the same records embedded by `--include-spawns`), and
+//! the `--ci-summary` line. The types below are what each of them is serialized from, so a Rust
+//! consumer can read the field names and types here rather than from sample output.
+//!
+//! Compatibility policy:
+//!
+//! - Adding a field does not change the version. Consumers must ignore fields they do not know.
+//! - Removing or renaming a field, changing its type or unit, or changing what it means raises
+//!   [`SCHEMA_VERSION`] for every document at once, so one check covers all of them.
+//! - A field due to go away is first documented as deprecated here, next to its type, and kept
+//!   for at least one release that says so in its notes before the version is raised.
+//! - CSV and TSV output follow the same rules for their columns but carry no version.
+//!
+//! With --deterministic the report document depends on nothing but the logs: `logs` holds the
+//! base names of the files and `diagnostics`, with its timings and memory, is left out even
+//! with --parse-stats. They are the only fields of the document that vary with the machine or
+//! the run.
+//!
+//! The `check --write-baseline` file is read back by this tool, not by consumers, and keeps
+//! its own version.
+
+/// Version of every JSON document; see the policy above for when it is raised.
+pub const SCHEMA_VERSION: u32 = 1;
+
+pub use crate::commands::compare::{CellJson, CompareJson, LogJson, RowJson};
+pub use crate::commands::diff::{
+    ActionJson, ActionsJson, ChangeJson, DiffJson, InputChange, MissCauseJson, MissCausesJson,
+    MnemonicJson as DiffMnemonicJson, OverallJson, Presence, PresenceJson, ThresholdsJson,
+    TransitionsJson,
+};
+pub use crate::commands::serve::{
+    MnemonicJson as ServeMnemonicJson, MnemonicsJson, SpawnJson, SpawnsJson as ServeSpawnsJson,
+    SummaryJson,
+};
+pub use crate::commands::trend::{PointJson, TrendJson};
+pub use crate::reports::ci_summary::{CiSummary, CiSummaryLine};
+pub use crate::reports::json::{
+    ConcurrencyJson, DataVolumeJson, DigestFunctionJson, DigestFunctionsJson, DownloadJson,
+    DownloadsJson, DurationsJson, ExplainJson, ExplainSpawnJson, FailedActionJson, FilterJson,
+    GapSpawnJson, GroupRowJson, GroupTableJson, HistogramBucketJson, IdleGapJson, IdleGapsJson,
+    InsightJson, LargestFileJson, MalformedDigestJson, MnemonicDownloadsJson, MnemonicFetchesJson,
+    MnemonicJson as ReportMnemonicJson, PhaseSumJson, PhaseTimeJson, ReportJson, SavingsJson,
+    SlowActionJson, SpawnsJson, TimeByPhaseJson, TopActionJson, TopPerMnemonicJson, TotalsJson,
+    WallClockJson,
+};
+pub use crate::reports::listing::SpawnRecord;
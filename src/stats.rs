@@ -0,0 +1,181 @@
+//! Small statistics helpers over spawn durations.
+
+use std::time::Duration;
+
+/// Returns the `p`-th percentile (0-100) of an ascending slice using the nearest-rank method.
+pub fn percentile<T: Copy + Default>(sorted: &[T], p: f64) -> T {
+    if sorted.is_empty() {
+        return T::default();
+    }
+    let rank = ((p / 100.0) * sorted.len() as f64).ceil() as usize;
+    sorted[rank.clamp(1, sorted.len()) - 1]
+}
+
+/// Exact duration percentiles of a set of spawns.
+pub struct DurationPercentiles {
+    pub min: Duration,
+    pub p50: Duration,
+    pub p90: Duration,
+    pub p95: Duration,
+    pub p99: Duration,
+    pub max: Duration,
+}
+
+impl DurationPercentiles {
+    /// Computes the percentiles, sorting `durations` in place. Returns `None` for an empty set.
+    pub fn compute(durations: &mut [Duration]) -> Option<Self> {
+        if durations.is_empty() {
+            return None;
+        }
+        durations.sort_unstable();
+        Some(DurationPercentiles {
+            min: durations[0],
+            p50: percentile(durations, 50.0),
+            p90: percentile(durations, 90.0),
+            p95: percentile(durations, 95.0),
+            p99: percentile(durations, 99.0),
+            max: durations[durations.len() - 1],
+        })
+    }
+}
+
+/// A histogram bucket holding durations up to and including `upper_bound` (`None` is unbounded).
+pub struct HistogramBucket {
+    pub lower_bound: Duration,
+    pub upper_bound: Option<Duration>,
+    pub count: u64,
+    pub total: Duration,
+}
+
+/// Sorts durations into buckets delimited by the given ascending upper bounds.
+///
+/// A final unbounded bucket catches everything above the last bound.
+pub fn histogram(durations: &[Duration], bounds: &[Duration]) -> Vec<HistogramBucket> {
+    let mut buckets: Vec<HistogramBucket> = Vec::with_capacity(bounds.len() + 1);
+    let mut lower_bound = Duration::ZERO;
+    for &bound in bounds {
+        buckets.push(HistogramBucket {
+            lower_bound,
+            upper_bound: Some(bound),
+            count: 0,
+            total: Duration::ZERO,
+        });
+        lower_bound = bound;
+    }
+    buckets.push(HistogramBucket {
+        lower_bound,
+        upper_bound: None,
+        count: 0,
+        total: Duration::ZERO,
+    });
+
+    for &duration in durations {
+        let index = bounds.partition_point(|&bound| bound < duration);
+        buckets[index].count += 1;
+        buckets[index].total += duration;
+    }
+    buckets
+}
+
+/// Most buckets `log_bucket_bounds` produces before switching to one bucket per decade.
+const MAX_LOG_BUCKETS: usize = 12;
+
+/// Picks log-scaled bucket bounds (1-2-5 steps, or whole decades for wide ranges) that
+/// cover the durations from their minimum to their maximum.
+///
+/// Durations spanning orders of magnitude (cache hits next to cold compiles) are common in
+/// spawn data, where linear buckets would put nearly everything in the first one.
+pub fn log_bucket_bounds(durations: &[Duration]) -> Vec<Duration> {
+    let (Some(min), Some(max)) = (durations.iter().min(), durations.iter().max()) else {
+        return Vec::new();
+    };
+    // Bounds in milliseconds; durations below a millisecond fall into the first bucket.
+    let min_ms = min.as_secs_f64() * 1e3;
+    let max_ms = max.as_secs_f64() * 1e3;
+    for steps in [&[1.0, 2.0, 5.0][..], &[1.0][..]] {
+        let mut bounds = Vec::new();
+        let mut decade = 1.0;
+        while decade * 10.0 <= min_ms {
+            decade *= 10.0;
+        }
+        'outer: loop {
+            for step in steps {
+                let bound = decade * step;
+                if bound >= min_ms {
+                    bounds.push(bound);
+                }
+                if bound >= max_ms {
+                    break 'outer;
+                }
+            }
+            decade *= 10.0;
+        }
+        if bounds.len() <= MAX_LOG_BUCKETS || steps.len() == 1 {
+            return bounds
+                .into_iter()
+                .map(|ms| Duration::from_secs_f64(ms / 1e3))
+                .collect();
+        }
+    }
+    unreachable!("the decade steps always return")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn millis(values: &[u64]) -> Vec<Duration> {
+        values.iter().map(|&ms| Duration::from_millis(ms)).collect()
+    }
+
+    fn counts(buckets: &[HistogramBucket]) -> Vec<u64> {
+        buckets.iter().map(|bucket| bucket.count).collect()
+    }
+
+    #[test]
+    fn buckets_include_their_upper_bound() {
+        let buckets = histogram(&millis(&[1_000, 1_500, 2_000, 3_000]), &millis(&[1_000, 2_000]));
+        assert_eq!(counts(&buckets), [1, 2, 1]);
+        assert_eq!(buckets[1].lower_bound, Duration::from_secs(1));
+        assert_eq!(buckets[1].total, Duration::from_millis(3_500));
+        assert_eq!(buckets[2].upper_bound, None);
+    }
+
+    #[test]
+    fn a_bimodal_distribution_lands_at_both_ends() {
+        let durations = [vec![Duration::from_millis(200); 100], vec![Duration::from_secs(30); 10]]
+            .concat();
+        let bounds = log_bucket_bounds(&durations);
+        assert_eq!(
+            bounds,
+            millis(&[200, 500, 1_000, 2_000, 5_000, 10_000, 20_000, 50_000])
+        );
+        assert_eq!(counts(&histogram(&durations, &bounds)), [100, 0, 0, 0, 0, 0, 0, 10, 0]);
+    }
+
+    #[test]
+    fn a_tight_cluster_gets_one_bucket() {
+        let durations = millis(&[4_000, 4_100, 4_200]);
+        let bounds = log_bucket_bounds(&durations);
+        assert_eq!(bounds, millis(&[5_000]));
+        assert_eq!(counts(&histogram(&durations, &bounds)), [3, 0]);
+    }
+
+    #[test]
+    fn wide_ranges_switch_to_decades() {
+        let bounds = log_bucket_bounds(&millis(&[1, 1_000_000]));
+        assert_eq!(bounds, millis(&[1, 10, 100, 1_000, 10_000, 100_000, 1_000_000]));
+        assert!(log_bucket_bounds(&[]).is_empty());
+    }
+
+    #[test]
+    fn percentiles_use_the_nearest_rank() {
+        let mut durations = millis(&[5, 1, 4, 2, 3, 6, 7, 8, 9, 10]);
+        let percentiles = DurationPercentiles::compute(&mut durations).unwrap();
+        assert_eq!(percentiles.min, Duration::from_millis(1));
+        assert_eq!(percentiles.p50, Duration::from_millis(5));
+        assert_eq!(percentiles.p90, Duration::from_millis(9));
+        assert_eq!(percentiles.p99, Duration::from_millis(10));
+        assert!(DurationPercentiles::compute(&mut []).is_none());
+    }
+}
@@ -0,0 +1,89 @@
+//! ANSI colors for the text reports.
+//!
+//! Report code names what a value means (`Style::Bad`) instead of writing escape codes. Colors
+//! are on when stdout is a terminal and `NO_COLOR` is not set, or with `--color always`; JSON,
+//! CSV and the files written by exports never pass through here.
+
+use crate::cli::ColorChoice;
+use std::env;
+use std::fmt::Display;
+use std::io::{self, IsTerminal};
+use std::sync::atomic::{AtomicBool, Ordering};
+
+/// Set once from --color, before any report runs.
+static ENABLED: AtomicBool = AtomicBool::new(false);
+
+#[derive(Clone, Copy)]
+pub enum Style {
+    /// Regressions, low hit rates, timeouts.
+    Bad,
+    /// Improvements.
+    Good,
+    /// Rules and other decoration.
+    Dim,
+}
+
+impl Style {
+    fn code(self) -> &'static str {
+        match self {
+            Style::Bad => "31",
+            Style::Good => "32",
+            Style::Dim => "2",
+        }
+    }
+}
+
+/// Decides whether to color, following --color and the `NO_COLOR` convention.
+pub fn configure(choice: ColorChoice) {
+    let enabled = match choice {
+        ColorChoice::Always => true,
+        ColorChoice::Never => false,
+        ColorChoice::Auto => {
+            io::stdout().is_terminal() && env::var_os("NO_COLOR").is_none_or(|v| v.is_empty())
+        }
+    };
+    ENABLED.store(enabled, Ordering::Relaxed);
+}
+
+/// Turns colors off, e.g. for the terminal browser, which draws its own screen.
+pub fn disable() {
+    ENABLED.store(false, Ordering::Relaxed);
+}
+
+/// `text` in `style`, or unchanged when colors are off or there is no style, e.g. for
+/// `(rate < threshold).then_some(Style::Bad)`.
+pub fn paint(style: impl Into<Option<Style>>, text: impl Display) -> String {
+    match style.into() {
+        Some(style) if ENABLED.load(Ordering::Relaxed) => {
+            format!("\x1b[{}m{}\x1b[0m", style.code(), text)
+        }
+        _ => text.to_string(),
+    }
+}
+
+/// The number of characters `text` takes on screen, leaving out escape sequences.
+pub fn visible_width(text: &str) -> usize {
+    let mut width = 0;
+    let mut chars = text.chars();
+    while let Some(c) = chars.next() {
+        if c == '\x1b' {
+            // A CSI sequence ends with its first letter.
+            chars.by_ref().find(|c| c.is_ascii_alphabetic());
+        } else {
+            width += 1;
+        }
+    }
+    width
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn escape_sequences_take_no_width() {
+        assert_eq!(visible_width("\x1b[31m28.57%\x1b[0m"), 6);
+        assert_eq!(visible_width("plain"), 5);
+        assert_eq!(visible_width(""), 0);
+    }
+}
@@ -0,0 +1,31 @@
+//! Spawns built in code for the unit tests.
+
+use crate::proto::{SpawnExec, SpawnMetrics};
+use std::time::Duration;
+
+pub fn duration(millis: u64) -> Option<prost_types::Duration> {
+    let time = Duration::from_millis(millis);
+    Some(prost_types::Duration {
+        seconds: time.as_secs() as i64,
+        nanos: time.subsec_nanos() as i32,
+    })
+}
+
+/// A spawn of `mnemonic` on `runner` with a total time of `millis`.
+pub fn spawn(mnemonic: &str, runner: &str, millis: u64) -> SpawnExec {
+    SpawnExec {
+        mnemonic: mnemonic.to_string(),
+        runner: runner.to_string(),
+        cache_hit: runner.ends_with("cache hit"),
+        metrics: Some(SpawnMetrics {
+            total_time: duration(millis),
+            ..Default::default()
+        }),
+        ..Default::default()
+    }
+}
+
+/// `count` copies of [`spawn`].
+pub fn spawns(count: usize, mnemonic: &str, runner: &str, millis: u64) -> Vec<SpawnExec> {
+    (0..count).map(|_| spawn(mnemonic, runner, millis)).collect()
+}
@@ -0,0 +1,310 @@
+//! Fields of log records that the vendored spawn.proto does not define, as a newer Bazel
+//! writes them. prost skips such fields while decoding; with --print-unknown-fields the raw
+//! records are scanned for them too, so that it is known when to update the proto.
+
+use crate::warnings::{Warning, WarningCode};
+use prost::encoding::{decode_key, decode_varint, skip_field, DecodeContext, WireType};
+use std::collections::BTreeMap;
+use std::sync::atomic::{AtomicBool, Ordering};
+
+static ENABLED: AtomicBool = AtomicBool::new(false);
+
+/// Scans every record for unknown fields when `enabled` (--print-unknown-fields) is set.
+pub fn configure(enabled: bool) {
+    ENABLED.store(enabled, Ordering::Relaxed);
+}
+
+pub fn enabled() -> bool {
+    ENABLED.load(Ordering::Relaxed)
+}
+
+/// The fields spawn.proto defines for a message, including reserved ones, which older logs
+/// may still carry, and the messages of its message fields that are scanned as well.
+struct Schema {
+    name: &'static str,
+    fields: &'static [u32],
+    nested: &'static [(u32, &'static Schema)],
+}
+
+impl Schema {
+    fn nested(&self, tag: u32) -> Option<&'static Schema> {
+        self.nested
+            .iter()
+            .find_map(|&(field, schema)| (field == tag).then_some(schema))
+    }
+}
+
+static DIGEST: Schema = Schema {
+    name: "Digest",
+    fields: &[1, 2, 3],
+    nested: &[],
+};
+
+static PLATFORM: Schema = Schema {
+    name: "Platform",
+    fields: &[1],
+    nested: &[],
+};
+
+static SPAWN_METRICS: Schema = Schema {
+    name: "SpawnMetrics",
+    fields: &[
+        1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20,
+    ],
+    nested: &[],
+};
+
+static FILE: Schema = Schema {
+    name: "File",
+    fields: &[1, 2, 3, 4],
+    nested: &[(2, &DIGEST)],
+};
+
+static SPAWN_EXEC: Schema = Schema {
+    name: "SpawnExec",
+    fields: &[
+        1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20,
+    ],
+    nested: &[
+        (3, &PLATFORM),
+        (4, &FILE),
+        (11, &FILE),
+        (19, &DIGEST),
+        (20, &SPAWN_METRICS),
+    ],
+};
+
+static ENTRY_FILE: Schema = Schema {
+    name: "ExecLogEntry.File",
+    fields: &[1, 2],
+    nested: &[(2, &DIGEST)],
+};
+
+static ENTRY_SPAWN: Schema = Schema {
+    name: "ExecLogEntry.Spawn",
+    fields: &[
+        1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18,
+    ],
+    nested: &[
+        (3, &PLATFORM),
+        (6, &ENTRY_OUTPUT),
+        (16, &DIGEST),
+        (18, &SPAWN_METRICS),
+    ],
+};
+
+static ENTRY_OUTPUT: Schema = Schema {
+    name: "ExecLogEntry.Output",
+    fields: &[1, 2, 3, 4, 5],
+    nested: &[],
+};
+
+static INVOCATION: Schema = Schema {
+    name: "ExecLogEntry.Invocation",
+    fields: &[1, 2, 3, 4],
+    nested: &[],
+};
+
+static DIRECTORY: Schema = Schema {
+    name: "ExecLogEntry.Directory",
+    fields: &[1, 2],
+    nested: &[(2, &ENTRY_FILE)],
+};
+
+static UNRESOLVED_SYMLINK: Schema = Schema {
+    name: "ExecLogEntry.UnresolvedSymlink",
+    fields: &[1, 2],
+    nested: &[],
+};
+
+static INPUT_SET: Schema = Schema {
+    name: "ExecLogEntry.InputSet",
+    fields: &[1, 2, 3, 4, 5],
+    nested: &[],
+};
+
+static SYMLINK_ACTION: Schema = Schema {
+    name: "ExecLogEntry.SymlinkAction",
+    fields: &[1, 2, 3, 4],
+    nested: &[],
+};
+
+static SYMLINK_ENTRY_SET: Schema = Schema {
+    name: "ExecLogEntry.SymlinkEntrySet",
+    fields: &[1, 2],
+    nested: &[],
+};
+
+static RUNFILES_TREE: Schema = Schema {
+    name: "ExecLogEntry.RunfilesTree",
+    fields: &[1, 2, 3, 4, 5, 6, 7],
+    nested: &[(6, &ENTRY_FILE)],
+};
+
+static EXEC_LOG_ENTRY: Schema = Schema {
+    name: "ExecLogEntry",
+    fields: &[1, 2, 3, 4, 5, 6, 7, 8, 9, 10],
+    nested: &[
+        (2, &INVOCATION),
+        (3, &ENTRY_FILE),
+        (4, &DIRECTORY),
+        (5, &UNRESOLVED_SYMLINK),
+        (6, &INPUT_SET),
+        (7, &ENTRY_SPAWN),
+        (8, &SYMLINK_ACTION),
+        (9, &SYMLINK_ENTRY_SET),
+        (10, &RUNFILES_TREE),
+    ],
+};
+
+/// Unknown fields seen in the records of one log, by message and field number.
+#[derive(Default)]
+pub struct UnknownFields {
+    seen: BTreeMap<(&'static str, u32), u64>,
+}
+
+impl UnknownFields {
+    /// Counts the unknown fields of a record of a verbose log.
+    pub fn scan_spawn(&mut self, record: &[u8]) {
+        self.scan(&SPAWN_EXEC, record);
+    }
+
+    /// Counts the unknown fields of an entry of a compact log.
+    pub fn scan_entry(&mut self, record: &[u8]) {
+        self.scan(&EXEC_LOG_ENTRY, record);
+    }
+
+    /// Counts the unknown fields of one `schema` message, and of the messages in it. A
+    /// record that does not parse is left to the decoder to report.
+    fn scan(&mut self, schema: &'static Schema, mut record: &[u8]) {
+        while !record.is_empty() {
+            let Ok((tag, wire_type)) = decode_key(&mut record) else {
+                return;
+            };
+            if wire_type == WireType::LengthDelimited {
+                let Some(len) = decode_varint(&mut record)
+                    .ok()
+                    .and_then(|len| usize::try_from(len).ok())
+                    .filter(|&len| len <= record.len())
+                else {
+                    return;
+                };
+                let (field, rest) = record.split_at(len);
+                record = rest;
+                if let Some(nested) = schema.nested(tag) {
+                    self.scan(nested, field);
+                }
+            } else if skip_field(wire_type, tag, &mut record, DecodeContext::default()).is_err() {
+                return;
+            }
+            if !schema.fields.contains(&tag) {
+                *self.seen.entry((schema.name, tag)).or_default() += 1;
+            }
+        }
+    }
+
+    /// One warning for each unknown field seen.
+    pub fn warnings(&self) -> Vec<Warning> {
+        self.seen
+            .iter()
+            .map(|(&(message, field), &count)| {
+                Warning::new(
+                    WarningCode::UnknownFields,
+                    format!(
+                        "{} field {}, which spawn.proto does not define, was ignored ({} occurrences)",
+                        message, field, count
+                    ),
+                )
+                .count(count)
+            })
+            .collect()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::proto::{ExecLogEntry, SpawnExec};
+    use crate::testing::spawn;
+    use prost::encoding::encode_varint;
+    use prost::Message;
+
+    /// Appends field `tag` to `record` as a varint, or with `payload` as a length-delimited one.
+    fn append_field(record: &mut Vec<u8>, tag: u32, payload: Option<&[u8]>) {
+        // The key: the field number and the wire type in its low three bits.
+        let key = |wire_type: WireType| u64::from(tag) << 3 | wire_type as u64;
+        match payload {
+            Some(payload) => {
+                encode_varint(key(WireType::LengthDelimited), record);
+                encode_varint(payload.len() as u64, record);
+                record.extend_from_slice(payload);
+            }
+            None => {
+                encode_varint(key(WireType::Varint), record);
+                encode_varint(7, record);
+            }
+        }
+    }
+
+    /// A SpawnExec as a newer Bazel might write it: field 40 on the spawn, and field 30 on its
+    /// metrics (field 20 of SpawnExec), next to the known ones.
+    fn newer_record() -> (SpawnExec, Vec<u8>) {
+        let known = spawn("Javac", "linux-sandbox", 1_500);
+        let mut metrics = known.metrics.clone().unwrap().encode_to_vec();
+        append_field(&mut metrics, 30, None);
+        let mut record = SpawnExec {
+            metrics: None,
+            ..known.clone()
+        }
+        .encode_to_vec();
+        append_field(&mut record, 20, Some(&metrics));
+        append_field(&mut record, 40, Some(b"new"));
+        append_field(&mut record, 40, None);
+        (known, record)
+    }
+
+    fn messages(unknown: &UnknownFields) -> Vec<String> {
+        unknown.warnings().into_iter().map(|w| w.message).collect()
+    }
+
+    #[test]
+    fn unknown_fields_of_a_spawn_and_its_metrics_are_counted() {
+        let (_, record) = newer_record();
+        let mut unknown = UnknownFields::default();
+        unknown.scan_spawn(&record);
+        unknown.scan_spawn(&record);
+        assert_eq!(
+            messages(&unknown),
+            [
+                "SpawnExec field 40, which spawn.proto does not define, was ignored (4 occurrences)",
+                "SpawnMetrics field 30, which spawn.proto does not define, was ignored (2 occurrences)",
+            ]
+        );
+    }
+
+    #[test]
+    fn a_record_with_unknown_fields_decodes_its_known_ones() {
+        let (known, record) = newer_record();
+        let decoded = SpawnExec::decode(record.as_slice()).unwrap();
+        assert!(decoded == known, "the known fields differ after skipping the unknown ones");
+    }
+
+    #[test]
+    fn known_and_reserved_fields_are_not_reported() {
+        let mut unknown = UnknownFields::default();
+        unknown.scan_spawn(&spawn("Javac", "remote cache hit", 100).encode_to_vec());
+        unknown.scan_entry(&ExecLogEntry::default().encode_to_vec());
+        assert!(unknown.warnings().is_empty());
+    }
+
+    #[test]
+    fn unknown_fields_of_compact_entries_are_counted() {
+        let mut entry = ExecLogEntry { id: 3, r#type: None }.encode_to_vec();
+        append_field(&mut entry, 15, Some(b"{}"));
+        let mut unknown = UnknownFields::default();
+        unknown.scan_entry(&entry);
+        assert_eq!(unknown.warnings().len(), 1);
+        assert_eq!(unknown.warnings()[0].count, Some(1));
+        assert!(messages(&unknown)[0].starts_with("ExecLogEntry field 15,"));
+    }
+}
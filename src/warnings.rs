@@ -0,0 +1,405 @@
+//! Problems found while reading a log that do not stop the analysis, such as a truncated
+//! final record.
+//!
+//! Every warning goes through `report`, which prints it to stderr as text or, with
+//! --warnings-format json, as one JSON object per line that CI can collect. The codes are
+//! listed in `WarningCode` and stay stable across releases.
+
+use crate::classify::{classify_runner, has_unknown_status, RunnerKind};
+use crate::cli::WarningsFormat;
+use crate::proto::SpawnExec;
+use serde::Serialize;
+use std::collections::{BTreeMap, BTreeSet};
+use std::io::Write;
+use std::path::Path;
+use std::sync::Mutex;
+
+/// Every kind of warning, serialized as its snake_case name.
+#[derive(Clone, Copy, Debug, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum WarningCode {
+    /// The log ends in the middle of a record, e.g. because Bazel is still writing it.
+    TruncatedRecord,
+    /// Compact log entries of a type this build does not know, e.g. from a newer Bazel.
+    UnknownEntries,
+    /// Spawn metrics with a negative or out-of-range duration.
+    InvalidDurations,
+    /// Digests computed with more than one hash function.
+    MixedDigestFunctions,
+    /// Spawn statuses this build does not know, e.g. from a newer Bazel.
+    UnknownStatuses,
+    /// Runners that fit none of the runner kinds, so the breakdowns by kind leave them out.
+    UnknownRunners,
+    /// Record fields that spawn.proto does not define (--print-unknown-fields).
+    UnknownFields,
+    /// A log that could not be read or parsed, left out because of --skip-errors.
+    SkippedLog,
+    /// Warnings left out because of --max-warnings.
+    WarningsSuppressed,
+}
+
+/// One warning with whatever context applies to it.
+#[derive(Serialize)]
+pub struct Warning {
+    pub code: WarningCode,
+    pub message: String,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub path: Option<String>,
+    /// Byte offset into the (decompressed) log.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub offset: Option<u64>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub count: Option<u64>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub mnemonic: Option<String>,
+}
+
+impl Warning {
+    pub fn new(code: WarningCode, message: String) -> Self {
+        Warning {
+            code,
+            message,
+            path: None,
+            offset: None,
+            count: None,
+            mnemonic: None,
+        }
+    }
+
+    pub fn path(mut self, path: &Path) -> Self {
+        self.path = Some(path.display().to_string());
+        self
+    }
+
+    pub fn offset(mut self, offset: u64) -> Self {
+        self.offset = Some(offset);
+        self
+    }
+
+    pub fn count(mut self, count: u64) -> Self {
+        self.count = Some(count);
+        self
+    }
+
+    pub fn mnemonic(mut self, mnemonic: &str) -> Self {
+        self.mnemonic = Some(mnemonic.to_string());
+        self
+    }
+}
+
+/// Prints warnings in one format, up to a maximum, and counts those left out.
+struct Collector {
+    format: WarningsFormat,
+    max: Option<usize>,
+    emitted: usize,
+    suppressed: u64,
+}
+
+impl Collector {
+    const fn new(format: WarningsFormat, max: Option<usize>) -> Self {
+        Collector {
+            format,
+            max,
+            emitted: 0,
+            suppressed: 0,
+        }
+    }
+
+    fn report(&mut self, warning: &Warning, out: &mut dyn Write) {
+        if self.max.is_some_and(|max| self.emitted >= max) {
+            self.suppressed += 1;
+            return;
+        }
+        self.emitted += 1;
+        emit(self.format, warning, out);
+    }
+
+    fn finish(&self, out: &mut dyn Write) {
+        if self.suppressed == 0 {
+            return;
+        }
+        let suppressed = Warning::new(
+            WarningCode::WarningsSuppressed,
+            format!(
+                "{} more warnings not shown (raise --max-warnings to see them)",
+                self.suppressed
+            ),
+        )
+        .count(self.suppressed);
+        emit(self.format, &suppressed, out);
+    }
+}
+
+/// The one collector of the process. Warnings come from deep inside the parsers, on worker
+/// threads too, where no handle reaches, and --max-warnings counts them across every log of
+/// the run; `crate::run` configures it once from the flags before the first log is read and
+/// calls `finish` after the last report.
+static COLLECTOR: Mutex<Collector> = Mutex::new(Collector::new(WarningsFormat::Text, None));
+
+fn collector() -> std::sync::MutexGuard<'static, Collector> {
+    COLLECTOR.lock().unwrap_or_else(|poisoned| poisoned.into_inner())
+}
+
+/// Sets how warnings are printed and how many at most, before any log is read.
+pub fn configure(format: WarningsFormat, max: Option<usize>) {
+    let mut collector = collector();
+    collector.format = format;
+    collector.max = max;
+}
+
+/// Writes one warning as a line; a warning that cannot be written to stderr is lost.
+fn emit(format: WarningsFormat, warning: &Warning, out: &mut dyn Write) {
+    let _ = match format {
+        WarningsFormat::Text => match &warning.path {
+            Some(path) => writeln!(out, "Warning: {}: {}", path, warning.message),
+            None => writeln!(out, "Warning: {}", warning.message),
+        },
+        WarningsFormat::Json => match serde_json::to_string(warning) {
+            Ok(line) => writeln!(out, "{}", line),
+            Err(err) => writeln!(out, "Warning: {} (not serializable: {})", warning.message, err),
+        },
+    };
+}
+
+/// Prints a warning, unless --max-warnings were printed already.
+pub fn report(warning: Warning) {
+    collector().report(&warning, &mut std::io::stderr());
+}
+
+/// Reports how many warnings --max-warnings left out, once the run is over.
+pub fn finish() {
+    collector().finish(&mut std::io::stderr());
+}
+
+/// Checks a parsed log for recorded values the reports cannot trust.
+pub fn check_spawns(spawns: &[SpawnExec]) -> Vec<Warning> {
+    let mut warnings = Vec::new();
+
+    let mut invalid: BTreeMap<&str, u64> = BTreeMap::new();
+    for spawn in spawns {
+        let Some(metrics) = &spawn.metrics else {
+            continue;
+        };
+        let durations = [
+            &metrics.total_time,
+            &metrics.parse_time,
+            &metrics.network_time,
+            &metrics.fetch_time,
+            &metrics.queue_time,
+            &metrics.setup_time,
+            &metrics.upload_time,
+            &metrics.execution_wall_time,
+            &metrics.process_outputs_time,
+            &metrics.retry_time,
+        ];
+        let invalid_duration = durations
+            .into_iter()
+            .flatten()
+            .any(|d| d.seconds < 0 || !(0..1_000_000_000).contains(&d.nanos));
+        if invalid_duration {
+            *invalid.entry(spawn.mnemonic.as_str()).or_default() += 1;
+        }
+    }
+    for (mnemonic, count) in invalid {
+        warnings.push(
+            Warning::new(
+                WarningCode::InvalidDurations,
+                format!(
+                    "{} {} spawns record a negative or out-of-range duration, so their times are unreliable",
+                    count, mnemonic
+                ),
+            )
+            .count(count)
+            .mnemonic(mnemonic),
+        );
+    }
+
+    let functions: BTreeSet<&str> = spawns
+        .iter()
+        .flat_map(|s| {
+            s.actual_outputs
+                .iter()
+                .filter_map(|f| f.digest.as_ref())
+                .chain(s.digest.as_ref())
+        })
+        .map(|d| d.hash_function_name.as_str())
+        .filter(|name| !name.is_empty())
+        .collect();
+    if functions.len() > 1 {
+        let count = functions.len() as u64;
+        warnings.push(
+            Warning::new(
+                WarningCode::MixedDigestFunctions,
+                format!(
+                    "digests use {} hash functions ({}), so equal outputs may not compare equal",
+                    count,
+                    functions.into_iter().collect::<Vec<_>>().join(", ")
+                ),
+            )
+            .count(count),
+        );
+    }
+
+    let mut statuses: BTreeMap<&str, u64> = BTreeMap::new();
+    let mut runners: BTreeMap<&str, u64> = BTreeMap::new();
+    for spawn in spawns {
+        if has_unknown_status(spawn) {
+            *statuses.entry(spawn.status.as_str()).or_default() += 1;
+        }
+        if !spawn.runner.is_empty() && classify_runner(&spawn.runner) == RunnerKind::Unknown {
+            *runners.entry(spawn.runner.as_str()).or_default() += 1;
+        }
+    }
+    if !statuses.is_empty() {
+        let count = statuses.values().sum();
+        warnings.push(
+            Warning::new(
+                WarningCode::UnknownStatuses,
+                format!(
+                    "{} spawns record a status this version does not know, so they count as failed: {}",
+                    count,
+                    counted_values(&statuses)
+                ),
+            )
+            .count(count),
+        );
+    }
+    if !runners.is_empty() {
+        let count = runners.values().sum();
+        warnings.push(
+            Warning::new(
+                WarningCode::UnknownRunners,
+                format!(
+                    "{} spawns ran on runners that are neither local, sandboxed, worker, remote nor a cache, so the breakdowns by runner kind leave them out: {}",
+                    count,
+                    counted_values(&runners)
+                ),
+            )
+            .count(count),
+        );
+    }
+    warnings
+}
+
+/// `value (count)` for each value, most frequent first.
+fn counted_values(counts: &BTreeMap<&str, u64>) -> String {
+    let mut values: Vec<(&str, u64)> = counts
+        .iter()
+        .map(|(&value, &count)| (value, count))
+        .collect();
+    values.sort_by(|a, b| b.1.cmp(&a.1).then(a.0.cmp(b.0)));
+    values
+        .iter()
+        .map(|(value, count)| format!("{} ({})", value, count))
+        .collect::<Vec<_>>()
+        .join(", ")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::{json, Value};
+
+    /// The lines `collector` writes for `warnings`, then for `finish`.
+    fn written(mut collector: Collector, warnings: &[Warning]) -> Vec<String> {
+        let mut out = Vec::new();
+        for warning in warnings {
+            collector.report(warning, &mut out);
+        }
+        collector.finish(&mut out);
+        String::from_utf8(out).unwrap().lines().map(str::to_string).collect()
+    }
+
+    fn truncated(offset: u64) -> Warning {
+        Warning::new(WarningCode::TruncatedRecord, "the log ends within a record".to_string())
+            .path(Path::new("build.log"))
+            .offset(offset)
+    }
+
+    #[test]
+    fn json_warnings_are_one_object_per_line() {
+        let warnings = [
+            truncated(4096),
+            Warning::new(WarningCode::InvalidDurations, "2 Javac spawns".to_string())
+                .count(2)
+                .mnemonic("Javac"),
+        ];
+        let lines = written(Collector::new(WarningsFormat::Json, None), &warnings);
+        let objects: Vec<Value> = lines
+            .iter()
+            .map(|line| serde_json::from_str(line).unwrap())
+            .collect();
+        assert_eq!(
+            objects,
+            [
+                json!({
+                    "code": "truncated_record",
+                    "message": "the log ends within a record",
+                    "path": "build.log",
+                    "offset": 4096,
+                }),
+                json!({
+                    "code": "invalid_durations",
+                    "message": "2 Javac spawns",
+                    "count": 2,
+                    "mnemonic": "Javac",
+                }),
+            ]
+        );
+    }
+
+    #[test]
+    fn text_warnings_name_the_log() {
+        let lines = written(Collector::new(WarningsFormat::Text, None), &[truncated(0)]);
+        assert_eq!(lines, ["Warning: build.log: the log ends within a record"]);
+    }
+
+    #[test]
+    fn max_warnings_caps_the_output_and_finish_counts_the_rest() {
+        let warnings: Vec<Warning> = (0..5).map(truncated).collect();
+        let lines = written(Collector::new(WarningsFormat::Json, Some(2)), &warnings);
+        assert_eq!(lines.len(), 3);
+        let offsets: Vec<Value> = lines[..2]
+            .iter()
+            .map(|line| serde_json::from_str::<Value>(line).unwrap()["offset"].clone())
+            .collect();
+        assert_eq!(offsets, [0, 1]);
+        let suppressed: Value = serde_json::from_str(&lines[2]).unwrap();
+        assert_eq!(suppressed["code"], "warnings_suppressed");
+        assert_eq!(suppressed["count"], 3);
+
+        let lines = written(Collector::new(WarningsFormat::Text, Some(5)), &warnings);
+        assert_eq!(lines.len(), 5, "finish adds nothing when none were left out");
+    }
+
+    #[test]
+    fn every_code_serializes_to_its_stable_name() {
+        use WarningCode::*;
+        // Exhaustive, so that a new code does not build until its name is listed here.
+        let name = |code: WarningCode| match code {
+            TruncatedRecord => "truncated_record",
+            UnknownEntries => "unknown_entries",
+            InvalidDurations => "invalid_durations",
+            MixedDigestFunctions => "mixed_digest_functions",
+            UnknownStatuses => "unknown_statuses",
+            UnknownRunners => "unknown_runners",
+            UnknownFields => "unknown_fields",
+            SkippedLog => "skipped_log",
+            WarningsSuppressed => "warnings_suppressed",
+        };
+        let codes = [
+            TruncatedRecord,
+            UnknownEntries,
+            InvalidDurations,
+            MixedDigestFunctions,
+            UnknownStatuses,
+            UnknownRunners,
+            UnknownFields,
+            SkippedLog,
+            WarningsSuppressed,
+        ];
+        for code in codes {
+            assert_eq!(serde_json::to_value(code).unwrap(), name(code));
+        }
+    }
+}
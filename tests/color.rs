@@ -0,0 +1,52 @@
+//! The summary section with and without colors: escape codes only with --color always, and
+//! never in a pipe or with NO_COLOR.
+
+mod common;
+
+use common::{build, scratch_dir, spawn, write_log};
+use std::process::Command;
+
+const DIM_RULE: &str = "\x1b[2m========================================\x1b[0m";
+
+/// The report's lines up to the end of the summary section.
+fn summary(dir: &std::path::Path, args: &[&str], no_color: bool) -> String {
+    let mut command = Command::new(env!("CARGO_BIN_EXE_bzl-exec-log-analyzer"));
+    command.args(args).current_dir(dir).env_remove("NO_COLOR");
+    if no_color {
+        command.env("NO_COLOR", "1");
+    }
+    let output = command.output().unwrap();
+    assert!(output.status.success(), "{}", String::from_utf8_lossy(&output.stderr));
+    let report = String::from_utf8(output.stdout).unwrap();
+    let end = report.find("Timing data present").expect("no summary section");
+    report[..end].to_string()
+}
+
+#[test]
+fn the_summary_is_colored_only_when_asked() {
+    let dir = scratch_dir("color");
+    write_log(&dir, "build.log", &build());
+
+    let colored = summary(&dir, &["build.log", "--color", "always"], false);
+    assert!(colored.contains(DIM_RULE), "{:?}", colored);
+    // A 28.57% hit rate is below the threshold, so it is marked bad.
+    assert!(colored.contains("Cache Hits: 2 (\x1b[31m28.57%\x1b[0m)"), "{:?}", colored);
+
+    let plain = summary(&dir, &["build.log", "--color", "never"], false);
+    assert!(!plain.contains('\x1b'), "{:?}", plain);
+    assert!(plain.contains("Cache Hits: 2 (28.57%)"));
+    // Without the escape codes, the colored and plain summaries are the same text.
+    assert_eq!(colored.replace("\x1b[2m", "").replace("\x1b[31m", "").replace("\x1b[0m", ""), plain);
+
+    for (args, no_color) in [(&["build.log"][..], false), (&["build.log"][..], true)] {
+        assert_eq!(summary(&dir, args, no_color), plain, "NO_COLOR: {}", no_color);
+    }
+}
+
+#[test]
+fn a_good_hit_rate_is_not_marked() {
+    let dir = scratch_dir("color_good");
+    write_log(&dir, "build.log", &[spawn("Javac", "//a", "remote cache hit", 100)]);
+    let colored = summary(&dir, &["build.log", "--color", "always"], false);
+    assert!(colored.contains("Cache Hits: 1 (100.00%)"), "{:?}", colored);
+}
@@ -0,0 +1,175 @@
+//! Fixture logs and golden files for the integration tests, which run the built binary.
+
+#![allow(dead_code)]
+
+pub use bzl_exec_log_parser::proto::SpawnExec;
+use bzl_exec_log_parser::proto::{Digest, File, SpawnMetrics};
+use prost::Message;
+use sha2::{Digest as _, Sha256};
+use std::path::{Path, PathBuf};
+use std::process::{Command, Output};
+use std::time::Duration;
+
+/// Set to rewrite the golden files from the current output instead of comparing against them.
+const UPDATE_GOLDEN: &str = "UPDATE_GOLDEN";
+
+/// A fresh directory for the fixtures of one test.
+pub fn scratch_dir(test: &str) -> PathBuf {
+    let dir = Path::new(env!("CARGO_TARGET_TMPDIR")).join(test);
+    let _ = std::fs::remove_dir_all(&dir);
+    std::fs::create_dir_all(&dir).unwrap();
+    dir
+}
+
+pub fn duration(millis: u64) -> Option<prost_types::Duration> {
+    let time = Duration::from_millis(millis);
+    Some(prost_types::Duration {
+        seconds: time.as_secs() as i64,
+        nanos: time.subsec_nanos() as i32,
+    })
+}
+
+/// A file a spawn reads or writes, with a digest derived from its name.
+pub fn file(path: &str, size_bytes: i64) -> File {
+    File {
+        path: path.to_string(),
+        digest: Some(Digest {
+            hash: Sha256::digest(path.as_bytes())
+                .iter()
+                .map(|byte| format!("{:02x}", byte))
+                .collect(),
+            size_bytes,
+            hash_function_name: "SHA-256".to_string(),
+        }),
+        ..Default::default()
+    }
+}
+
+/// A cacheable spawn of `mnemonic` for `target` on `runner`, taking `millis`.
+pub fn spawn(mnemonic: &str, target: &str, runner: &str, millis: u64) -> SpawnExec {
+    SpawnExec {
+        command_args: vec![mnemonic.to_lowercase(), format!("{}.src", target)],
+        mnemonic: mnemonic.to_string(),
+        target_label: target.to_string(),
+        runner: runner.to_string(),
+        cache_hit: runner.ends_with("cache hit"),
+        cacheable: true,
+        remotable: true,
+        listed_outputs: vec![format!("bazel-out/k8-fastbuild/bin/{}.out", target)],
+        actual_outputs: vec![file(&format!("bazel-out/k8-fastbuild/bin/{}.out", target), 1_024)],
+        metrics: Some(SpawnMetrics {
+            total_time: duration(millis),
+            execution_wall_time: duration(millis * 3 / 4),
+            ..Default::default()
+        }),
+        ..Default::default()
+    }
+}
+
+/// Writes `spawns` as a verbose (length-delimited SpawnExec) log named `name` in `dir`.
+pub fn write_log(dir: &Path, name: &str, spawns: &[SpawnExec]) -> PathBuf {
+    let bytes: Vec<u8> = spawns
+        .iter()
+        .flat_map(|spawn| spawn.encode_length_delimited_to_vec())
+        .collect();
+    let path = dir.join(name);
+    std::fs::write(&path, bytes).unwrap();
+    path
+}
+
+/// A small build: a few compiles, some of them cache hits, a link and a test.
+pub fn build() -> Vec<SpawnExec> {
+    vec![
+        spawn("Javac", "//app:lib", "linux-sandbox", 4_200),
+        spawn("Javac", "//app:util", "remote cache hit", 300),
+        spawn("Javac", "//core:base", "worker", 1_800),
+        spawn("CppCompile", "//native:codec", "remote", 9_500),
+        spawn("CppCompile", "//native:io", "disk cache hit", 120),
+        spawn("CppLink", "//native:bin", "local", 2_300),
+        spawn("TestRunner", "//app:lib_test", "linux-sandbox", 12_000),
+    ]
+}
+
+/// The same build after a change: //app:util and //native:io miss, //core:base is gone and a
+/// Genrule is new.
+pub fn changed_build() -> Vec<SpawnExec> {
+    vec![
+        spawn("Javac", "//app:lib", "remote cache hit", 250),
+        spawn("Javac", "//app:util", "linux-sandbox", 3_900),
+        spawn("CppCompile", "//native:codec", "remote cache hit", 400),
+        spawn("CppCompile", "//native:io", "remote", 7_700),
+        spawn("CppLink", "//native:bin", "local", 2_600),
+        spawn("Genrule", "//tools:gen", "local", 1_100),
+        spawn("TestRunner", "//app:lib_test", "linux-sandbox", 14_500),
+    ]
+}
+
+/// The small build, laid out in time with an idle stretch before the test and one failed
+/// spawn, so that the timeline sections and the filter have something to report.
+pub fn timed_build() -> Vec<SpawnExec> {
+    let mut spawns = build();
+    let mut start = 1_700_000_000_000u64;
+    for spawn in &mut spawns {
+        if spawn.mnemonic == "TestRunner" {
+            start += 5_000;
+        }
+        let metrics = spawn.metrics.as_mut().unwrap();
+        metrics.start_time = Some(prost_types::Timestamp {
+            seconds: (start / 1_000) as i64,
+            nanos: (start % 1_000 * 1_000_000) as i32,
+        });
+        start += 500;
+    }
+    let mut failed = spawn("Genrule", "//tools:gen", "local", 700);
+    failed.exit_code = 1;
+    spawns.push(failed);
+    spawns
+}
+
+/// Runs the binary in `dir` with `args`.
+pub fn run(dir: &Path, args: &[&str]) -> Output {
+    Command::new(env!("CARGO_BIN_EXE_bzl-exec-log-analyzer"))
+        .args(args)
+        .current_dir(dir)
+        .env("NO_COLOR", "1")
+        .output()
+        .unwrap()
+}
+
+/// Runs the binary and returns its stdout, failing the test if it does not exit with 0.
+pub fn stdout(dir: &Path, args: &[&str]) -> String {
+    let output = run(dir, args);
+    assert!(
+        output.status.success(),
+        "{:?} exited with {}: {}",
+        args,
+        output.status,
+        String::from_utf8_lossy(&output.stderr)
+    );
+    String::from_utf8(output.stdout).unwrap()
+}
+
+/// Compares `actual` with tests/golden/`name`; with UPDATE_GOLDEN set, rewrites the file.
+pub fn assert_golden(name: &str, actual: &str) {
+    let path = Path::new(env!("CARGO_MANIFEST_DIR")).join("tests/golden").join(name);
+    if std::env::var_os(UPDATE_GOLDEN).is_some() {
+        std::fs::write(&path, actual).unwrap();
+        return;
+    }
+    let expected = std::fs::read_to_string(&path)
+        .unwrap_or_else(|e| panic!("{}: {}; run with {}=1 to create it", path.display(), e, UPDATE_GOLDEN));
+    if actual != expected {
+        let line = expected
+            .lines()
+            .zip(actual.lines())
+            .position(|(expected, actual)| expected != actual)
+            .unwrap_or_else(|| expected.lines().count().min(actual.lines().count()));
+        panic!(
+            "output differs from {} at line {}; run with {}=1 to update it if the change is intended\n--- actual ---\n{}",
+            path.display(),
+            line + 1,
+            UPDATE_GOLDEN,
+            actual
+        );
+    }
+}
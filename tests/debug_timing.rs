@@ -0,0 +1,36 @@
+//! --debug-timing's stage times and heap figures on stderr.
+
+mod common;
+
+use common::{build, run, scratch_dir, stdout, write_log};
+
+#[test]
+fn each_stage_and_the_heap_are_timed_without_changing_the_report() {
+    let dir = scratch_dir("debug_timing");
+    write_log(&dir, "build.log", &build());
+    let output = run(&dir, &["build.log", "--debug-timing"]);
+    assert!(output.status.success());
+    let stderr = String::from_utf8_lossy(&output.stderr);
+    let timing = &stderr[stderr.find("--- Debug Timing ---").expect(&stderr)..];
+    let stages: Vec<&str> = timing
+        .lines()
+        .filter_map(|line| line.trim().split_once(':').map(|(stage, _)| stage))
+        .take_while(|stage| !stage.starts_with('('))
+        .collect();
+    // A verbose log has nothing to decompress.
+    assert_eq!(
+        stages,
+        ["Read", "Decode", "Aggregate", "Render", "Other", "Total"],
+        "{}",
+        timing
+    );
+    assert!(timing.contains("\nHeap: "), "{}", timing);
+    assert!(!timing.contains("not counted"), "{}", timing);
+    assert_eq!(
+        String::from_utf8(output.stdout).unwrap(),
+        stdout(&dir, &["build.log"])
+    );
+
+    let quiet = run(&dir, &["build.log"]);
+    assert!(!String::from_utf8_lossy(&quiet.stderr).contains("Debug Timing"));
+}
@@ -0,0 +1,56 @@
+//! Reports of a log with many tied durations come out byte for byte the same on every run,
+//! whatever order the hash maps of each process iterate in.
+
+mod common;
+
+use common::{scratch_dir, spawn, stdout, write_log, SpawnExec};
+
+const RUNS: usize = 50;
+
+/// Spawns of several mnemonics, runners and packages that all take exactly one second.
+fn tied_build() -> Vec<SpawnExec> {
+    let mnemonics = ["Javac", "CppCompile", "GoCompile", "Genrule", "TestRunner"];
+    let runners = ["linux-sandbox", "remote", "remote cache hit", "worker"];
+    (0..60)
+        .map(|i| {
+            let target = format!("//pkg{}:target{}", i % 7, i % 11);
+            spawn(mnemonics[i % mnemonics.len()], &target, runners[i % runners.len()], 1_000)
+        })
+        .collect()
+}
+
+fn assert_same_every_run(dir: &std::path::Path, args: &[&str]) {
+    let first = stdout(dir, args);
+    for run in 1..RUNS {
+        assert_eq!(stdout(dir, args), first, "run {} of {:?} differs from the first", run, args);
+    }
+}
+
+#[test]
+fn the_text_report_is_the_same_every_run() {
+    let dir = scratch_dir("determinism_text");
+    write_log(&dir, "build.log", &tied_build());
+    assert_same_every_run(
+        &dir,
+        &["build.log", "--top-n", "25", "--group-by", "runner", "--group-by", "package"],
+    );
+}
+
+#[test]
+fn the_machine_readable_exports_are_the_same_every_run() {
+    let dir = scratch_dir("determinism_exports");
+    write_log(&dir, "build.log", &tied_build());
+    assert_same_every_run(
+        &dir,
+        &[
+            "build.log",
+            "--output-format",
+            "json",
+            "--include-spawns=all",
+            "--group-by",
+            "mnemonic,runner",
+            "--deterministic",
+        ],
+    );
+    assert_same_every_run(&dir, &["build.log", "--all", "--listing-format", "jsonl"]);
+}
@@ -0,0 +1,73 @@
+//! --deterministic reports against golden files: the same output wherever the logs are, on
+//! whatever terminal, so wrappers can snapshot it.
+
+mod common;
+
+use common::{assert_golden, changed_build, scratch_dir, timed_build, write_log};
+use std::path::Path;
+use std::process::Command;
+
+/// The two logs of a CI run, under a directory named after `test` and the run.
+fn logs(test: &str) -> [String; 2] {
+    let dir = scratch_dir(test).join("ci-run-4711");
+    std::fs::create_dir_all(&dir).unwrap();
+    [
+        write_log(&dir, "build.log", &timed_build()),
+        write_log(&dir, "rebuild.log", &changed_build()),
+    ]
+    .map(|path| path.display().to_string())
+}
+
+/// Runs the binary with `args` after the logs, on a terminal `columns` wide.
+fn report(logs: &[String; 2], columns: &str, args: &[&str]) -> String {
+    let output = Command::new(env!("CARGO_BIN_EXE_bzl-exec-log-analyzer"))
+        .args(logs)
+        .args(args)
+        .current_dir(Path::new(&logs[0]).parent().unwrap())
+        .env("COLUMNS", columns)
+        .env_remove("NO_COLOR")
+        .output()
+        .unwrap();
+    let stderr = String::from_utf8_lossy(&output.stderr);
+    assert!(output.status.success(), "{:?} failed: {}", args, stderr);
+    String::from_utf8(output.stdout).unwrap()
+}
+
+#[test]
+fn the_text_report_matches_the_golden_file() {
+    let args = [
+        "--deterministic",
+        "--color",
+        "always",
+        "--chart",
+        "--group-by",
+        "mnemonic,runner",
+        "--cache-metrics",
+        "--idle-gaps",
+    ];
+    let text = report(&logs("deterministic_text"), "200", &args);
+    assert!(text.contains("Log files: build.log, rebuild.log\n"), "{}", text);
+    assert!(!text.contains("2023-"), "{}", text);
+    assert_golden("deterministic_report.txt", &text);
+    let elsewhere = report(&logs("deterministic_text_elsewhere"), "60", &args);
+    assert_eq!(elsewhere, text);
+}
+
+#[test]
+fn the_json_report_matches_the_golden_file() {
+    let args = [
+        "--deterministic",
+        "--output-format",
+        "json",
+        "--parse-stats",
+        "--group-by",
+        "runner",
+    ];
+    let json = report(&logs("deterministic_json"), "200", &args);
+    let document: serde_json::Value = serde_json::from_str(&json).unwrap();
+    assert_eq!(document["logs"], serde_json::json!(["build.log", "rebuild.log"]));
+    assert!(document.get("diagnostics").is_none(), "{}", json);
+    assert_golden("deterministic_report.json", &json);
+    let elsewhere = report(&logs("deterministic_json_elsewhere"), "60", &args);
+    assert_eq!(elsewhere, json);
+}
@@ -0,0 +1,24 @@
+//! The diff document and text against golden files, so shape changes are deliberate.
+
+mod common;
+
+use common::{assert_golden, build, changed_build, scratch_dir, stdout, write_log};
+
+#[test]
+fn diff_json_matches_the_golden_file() {
+    let dir = scratch_dir("diff_json");
+    write_log(&dir, "old.log", &build());
+    write_log(&dir, "new.log", &changed_build());
+    let json = stdout(&dir, &["diff", "old.log", "new.log", "--output-format", "json"]);
+    let document: serde_json::Value = serde_json::from_str(&json).unwrap();
+    assert!(document["schema_version"].is_u64());
+    assert_golden("diff.json", &json);
+}
+
+#[test]
+fn diff_text_matches_the_golden_file() {
+    let dir = scratch_dir("diff_text");
+    write_log(&dir, "old.log", &build());
+    write_log(&dir, "new.log", &changed_build());
+    assert_golden("diff.txt", &stdout(&dir, &["diff", "old.log", "new.log"]));
+}
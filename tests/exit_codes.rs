@@ -0,0 +1,51 @@
+//! The exit status of each failure class, as documented in the README, from the built binary.
+
+mod common;
+
+use common::{build, changed_build, run, scratch_dir, spawn, write_log};
+use std::path::Path;
+
+/// The status `args` exits with, and the category printed with the message.
+fn exit(dir: &Path, args: &[&str]) -> (i32, String) {
+    let output = run(dir, args);
+    let stderr = String::from_utf8_lossy(&output.stderr);
+    let category = stderr
+        .lines()
+        .find_map(|line| line.strip_prefix("Error ["))
+        .and_then(|rest| rest.split_once(']'))
+        .map_or(String::new(), |(category, _)| category.to_string());
+    (output.status.code().expect("killed by a signal"), category)
+}
+
+#[test]
+fn exit_codes_tell_the_failure_classes_apart() {
+    let dir = scratch_dir("exit_codes");
+    write_log(&dir, "build.log", &build());
+    write_log(&dir, "changed.log", &changed_build());
+    let mut failed = spawn("Javac", "//app:broken", "linux-sandbox", 900);
+    failed.exit_code = 1;
+    failed.status = "NON_ZERO_EXIT".to_string();
+    write_log(&dir, "failed.log", &[failed]);
+    std::fs::write(dir.join("empty.log"), b"").unwrap();
+    std::fs::write(dir.join("garbage.log"), [0xff; 64]).unwrap();
+
+    let cases: [(&[&str], i32, &str); 10] = [
+        (&["build.log"], 0, ""),
+        (&["build.log", "--no-such-flag"], 2, ""),
+        (&["build.log", "--min-hit-rate", "90%"], 3, "hit-rate"),
+        (&["build.log", "--max-action-duration", "5s"], 4, "action-duration"),
+        (&["failed.log", "--fail-on-action-failure"], 5, "action-failure"),
+        (
+            &["check", "--max-time-increase", "0.5%", "build.log", "changed.log"],
+            6,
+            "check-failed",
+        ),
+        (&["missing.log"], 7, "io"),
+        (&["garbage.log"], 8, "parse"),
+        (&["empty.log", "--strict"], 9, "empty-log"),
+        (&["empty.log"], 0, ""),
+    ];
+    for (args, code, category) in cases {
+        assert_eq!(exit(&dir, args), (code, category.to_string()), "{:?}", args);
+    }
+}
@@ -0,0 +1,486 @@
+//! The --output-format json report document.
+
+mod common;
+
+use common::{build, duration, file, scratch_dir, spawn, stdout, timed_build, write_log};
+use serde_json::{json, Value};
+
+fn report(dir: &std::path::Path, args: &[&str]) -> Value {
+    let output = stdout(dir, &[&["build.log", "--output-format", "json"], args].concat());
+    serde_json::from_str(&output).unwrap_or_else(|e| panic!("{}: {}", e, output))
+}
+
+#[test]
+fn a_filter_matching_nothing_still_prints_only_the_document() {
+    let dir = scratch_dir("json_report_empty_filter");
+    write_log(&dir, "build.log", &build());
+    let document = report(&dir, &["--uncacheable-only"]);
+    assert_eq!(document["filter"]["actions"], 0);
+    assert_eq!(document["filter"]["total_actions"], 7);
+}
+
+#[test]
+fn durations_are_in_raw_nanoseconds() {
+    let dir = scratch_dir("json_report_durations");
+    write_log(&dir, "build.log", &build());
+    let document = report(&dir, &[]);
+    assert_eq!(
+        document["durations"],
+        json!({
+            "min_nanos": 120_000_000u64,
+            "p50_nanos": 2_300_000_000u64,
+            "p90_nanos": 12_000_000_000u64,
+            "p95_nanos": 12_000_000_000u64,
+            "p99_nanos": 12_000_000_000u64,
+            "max_nanos": 12_000_000_000u64,
+        })
+    );
+}
+
+#[test]
+fn histogram_buckets_have_bound_count_and_seconds() {
+    let dir = scratch_dir("json_report_histogram");
+    write_log(&dir, "build.log", &build());
+    assert!(report(&dir, &[]).get("histogram").is_none());
+    let document = report(&dir, &["--histogram", "--histogram-buckets", "1s,10s"]);
+    assert_eq!(
+        document["histogram"],
+        json!([
+            {"le": 1.0, "count": 2, "seconds": 0.42},
+            {"le": 10.0, "count": 4, "seconds": 17.8},
+            {"le": null, "count": 1, "seconds": 12.0},
+        ])
+    );
+}
+
+#[test]
+fn top_actions_carry_the_whole_command_line() {
+    let dir = scratch_dir("json_report_args");
+    let mut slow = spawn("Javac", "//app:big", "linux-sandbox", 60_000);
+    let classpath = vec!["bazel-out/k8-fastbuild/bin/lib.jar"; 500].join(":");
+    slow.command_args = (0..30).map(|i| format!("-Xarg{}", i)).collect();
+    slow.command_args.push(classpath);
+    slow.command_args.push("@bazel-out/k8-fastbuild/bin/app/big.params".to_string());
+    write_log(&dir, "build.log", &[&build()[..], &[slow.clone()]].concat());
+
+    assert!(report(&dir, &[]).get("top_actions").is_none());
+    let document = report(&dir, &["--show-args", "--args-limit", "3", "--top-n", "2"]);
+    let top = document["top_actions"].as_array().unwrap();
+    assert_eq!(top.len(), 2);
+    assert_eq!(top[0]["label"], "//app:big");
+    assert_eq!(top[0]["total_nanos"], 60_000_000_000u64);
+    assert_eq!(top[0]["command_args"], json!(slow.command_args));
+    assert_eq!(top[1]["label"], "//app:lib_test");
+}
+
+#[test]
+fn mnemonics_follow_the_mnemonic_sort_and_are_not_cut_at_top_mnemonics() {
+    let dir = scratch_dir("json_report_mnemonic_sort");
+    write_log(&dir, "build.log", &build());
+    let names = |document: &Value| -> Vec<String> {
+        document["mnemonics"]
+            .as_array()
+            .unwrap()
+            .iter()
+            .map(|row| row["mnemonic"].as_str().unwrap().to_string())
+            .collect()
+    };
+    assert_eq!(
+        names(&report(&dir, &[])),
+        ["TestRunner", "CppCompile", "Javac", "CppLink"]
+    );
+    let document = report(&dir, &["--mnemonic-sort", "count", "--asc", "--top-mnemonics", "2"]);
+    assert_eq!(names(&document), ["CppLink", "TestRunner", "CppCompile", "Javac"]);
+    assert_eq!(document["mnemonics"][3]["actions"], 3);
+}
+
+/// The row of `mnemonic` in the document's `mnemonics`.
+fn mnemonic<'a>(document: &'a Value, mnemonic: &str) -> &'a Value {
+    document["mnemonics"]
+        .as_array()
+        .unwrap()
+        .iter()
+        .find(|row| row["mnemonic"] == mnemonic)
+        .unwrap_or_else(|| panic!("no {} in {}", mnemonic, document["mnemonics"]))
+}
+
+#[test]
+fn mnemonics_carry_their_percentiles_without_the_flag() {
+    let dir = scratch_dir("json_report_mnemonic_percentiles");
+    let mut untimed = spawn("Genrule", "//tools:gen", "local", 0);
+    untimed.metrics = None;
+    write_log(&dir, "build.log", &[&build()[..], &[untimed]].concat());
+    let document = report(&dir, &[]);
+    let javac = mnemonic(&document, "Javac");
+    assert_eq!(javac["p50_nanos"], 1_800_000_000u64);
+    assert_eq!(javac["p90_nanos"], 4_200_000_000u64);
+    assert_eq!(javac["p99_nanos"], 4_200_000_000u64);
+    assert_eq!(report(&dir, &["--percentiles"])["mnemonics"], document["mnemonics"]);
+
+    let genrule = mnemonic(&document, "Genrule");
+    assert_eq!(genrule["actions"], 1);
+    assert!(genrule.get("p50_nanos").is_none(), "{}", genrule);
+}
+
+#[test]
+fn mnemonics_carry_min_median_and_max_whatever_the_columns() {
+    let dir = scratch_dir("json_report_mnemonic_spread");
+    write_log(&dir, "build.log", &build());
+    let document = report(&dir, &["--columns", "count,total"]);
+    let javac = mnemonic(&document, "Javac");
+    assert_eq!(javac["min_nanos"], 300_000_000u64);
+    assert_eq!(javac["median_nanos"], 1_800_000_000u64);
+    assert_eq!(javac["max_nanos"], 4_200_000_000u64);
+    let link = mnemonic(&document, "CppLink");
+    assert_eq!(link["min_nanos"], link["max_nanos"]);
+}
+
+#[test]
+fn mnemonic_time_shares_are_unrounded_fractions_in_table_order() {
+    let dir = scratch_dir("json_report_mnemonic_shares");
+    write_log(&dir, "build.log", &build());
+    let document = report(&dir, &[]);
+    let rows = document["mnemonics"].as_array().unwrap();
+    // 30.22s in all: TestRunner 12s, CppCompile 9.62s, Javac 6.3s, CppLink 2.3s.
+    let shares: Vec<f64> = rows.iter().map(|row| row["time_share"].as_f64().unwrap()).collect();
+    for (share, seconds) in shares.iter().zip([12.0, 9.62, 6.3, 2.3]) {
+        assert!((share - seconds / 30.22).abs() < 1e-12, "{} for {}s", share, seconds);
+    }
+    assert!((rows[1]["cumulative_time_share"].as_f64().unwrap() - 21.62 / 30.22).abs() < 1e-12);
+    assert_eq!(rows[3]["cumulative_time_share"], 1.0);
+}
+
+#[test]
+fn mnemonics_carry_the_exec_fraction_whatever_the_columns() {
+    let dir = scratch_dir("json_report_mnemonic_exec");
+    let mut untimed = vec![spawn("Genrule", "//tools:a", "local", 400); 3];
+    for spawn in &mut untimed[1..] {
+        spawn.metrics.as_mut().unwrap().execution_wall_time = None;
+    }
+    write_log(&dir, "build.log", &[&build()[..], &untimed].concat());
+    let document = report(&dir, &[]);
+    assert_eq!(mnemonic(&document, "Javac")["exec_time_fraction"], 0.75);
+    assert_eq!(mnemonic(&document, "Genrule")["exec_time_fraction"], Value::Null);
+}
+
+#[test]
+fn failed_spawns_are_listed_with_their_exit_code_status_and_phases() {
+    let dir = scratch_dir("json_report_failed");
+    let mut crashed = spawn("Genrule", "//tools:gen", "local", 700);
+    crashed.exit_code = 1;
+    crashed.status = "NON_ZERO_EXIT".to_string();
+    let mut timed_out = spawn("TestRunner", "//app:slow_test", "remote", 60_000);
+    timed_out.exit_code = 142;
+    timed_out.status = "TIMEOUT".to_string();
+    timed_out.metrics.as_mut().unwrap().queue_time = duration(14_000);
+    write_log(&dir, "build.log", &[&build()[..], &[crashed, timed_out]].concat());
+
+    let document = report(&dir, &["--max-failed", "1"]);
+    assert_eq!(
+        document["failed_actions"],
+        json!([
+            {
+                "label": "//app:slow_test",
+                "mnemonic": "TestRunner",
+                "exit_code": 142,
+                "status": "TIMEOUT",
+                "timeout": true,
+                "runner": "remote",
+                "remote": true,
+                "total_nanos": 60_000_000_000u64,
+                "phases": [
+                    {"phase": "Queue", "nanos": 14_000_000_000u64},
+                    {"phase": "Execution", "nanos": 45_000_000_000u64},
+                ],
+            },
+            {
+                "label": "//tools:gen",
+                "mnemonic": "Genrule",
+                "exit_code": 1,
+                "status": "NON_ZERO_EXIT",
+                "timeout": false,
+                "runner": "local",
+                "remote": false,
+                "total_nanos": 700_000_000u64,
+                "phases": [{"phase": "Execution", "nanos": 525_000_000u64}],
+            },
+        ])
+    );
+
+    write_log(&dir, "build.log", &build());
+    assert_eq!(report(&dir, &[])["failed_actions"], json!([]));
+}
+
+#[test]
+fn time_by_phase_sums_the_recorded_phases_in_nanoseconds() {
+    let dir = scratch_dir("json_report_phases");
+    let mut spawns = build();
+    spawns[0].metrics.as_mut().unwrap().queue_time = duration(2_000);
+    write_log(&dir, "build.log", &spawns);
+    assert_eq!(
+        report(&dir, &[])["time_by_phase"],
+        json!({
+            "total_nanos": 30_220_000_000u64,
+            "phases": [
+                {"phase": "Queue", "nanos": 2_000_000_000u64, "spawns": 1},
+                {"phase": "Execution", "nanos": 22_665_000_000u64, "spawns": 7},
+            ],
+        })
+    );
+}
+
+#[test]
+fn fetches_list_every_mnemonic_by_fetch_time() {
+    let dir = scratch_dir("json_report_fetches");
+    let mut spawns = build();
+    spawns[1].metrics.as_mut().unwrap().fetch_time = duration(200);
+    let mut link = spawn("CppLink", "//native:cached", "remote cache hit", 900);
+    link.actual_outputs[0].digest.as_mut().unwrap().size_bytes = 8_000_000;
+    link.metrics.as_mut().unwrap().fetch_time = duration(800);
+    let mut tiny = spawn("Genrule", "//tools:gen", "remote cache hit", 10);
+    tiny.metrics.as_mut().unwrap().fetch_time = duration(1);
+    tiny.actual_outputs.clear();
+    spawns.extend([link, tiny]);
+    write_log(&dir, "build.log", &build()[2..]);
+    assert!(report(&dir, &[]).get("fetches").is_none(), "no remote cache hits");
+    write_log(&dir, "build.log", &spawns);
+    assert_eq!(
+        report(&dir, &[])["fetches"],
+        json!([
+            {
+                "mnemonic": "CppLink",
+                "hits": 1,
+                "bytes": 8_000_000,
+                "fetch_time_nanos": 800_000_000u64,
+                "bytes_per_second": 10_000_000.0,
+            },
+            {
+                "mnemonic": "Javac",
+                "hits": 1,
+                "bytes": 1_024,
+                "fetch_time_nanos": 200_000_000u64,
+                "bytes_per_second": 5_120.0,
+            },
+            {
+                "mnemonic": "Genrule",
+                "hits": 1,
+                "bytes": 0,
+                "fetch_time_nanos": 1_000_000u64,
+                "bytes_per_second": null,
+            },
+        ])
+    );
+}
+
+#[test]
+fn data_volume_is_in_raw_bytes_deduplicated_by_digest() {
+    let dir = scratch_dir("json_report_data_volume");
+    let mut a = spawn("Javac", "//a:a", "local", 100);
+    a.inputs = vec![file("third_party/shared.jar", 5_000), file("a/A.java", 500)];
+    let mut b = spawn("Javac", "//b:b", "local", 100);
+    b.inputs = vec![file("third_party/shared.jar", 5_000), file("b/B.java", 300)];
+    write_log(&dir, "build.log", &[a, b]);
+
+    assert!(report(&dir, &[]).get("data_volume").is_none());
+    assert_eq!(
+        report(&dir, &["--data-volume"])["data_volume"],
+        json!({
+            "input_files": 4,
+            "input_bytes": 10_800,
+            "unique_input_files": 3,
+            "unique_input_bytes": 5_800,
+            "output_files": 2,
+            "output_bytes": 2_048,
+            "unique_output_files": 2,
+            "unique_output_bytes": 2_048,
+            "median_input_bytes": 5_300,
+            "median_output_bytes": 1_024,
+            "largest_file": {"path": "third_party/shared.jar", "bytes": 5_000},
+            "symlink_outputs": 0,
+        })
+    );
+}
+
+#[test]
+fn digest_functions_are_always_listed_with_mixed_and_malformed_digests() {
+    let dir = scratch_dir("json_report_digests");
+    write_log(&dir, "build.log", &build()[..2]);
+    assert_eq!(
+        report(&dir, &[])["digest_functions"],
+        json!({
+            "functions": [{
+                "function": "SHA-256",
+                "digests": 2,
+                "examples": [
+                    "bazel-out/k8-fastbuild/bin///app:lib.out",
+                    "bazel-out/k8-fastbuild/bin///app:util.out",
+                ],
+            }],
+            "mixed": false,
+            "malformed_digests": 0,
+            "malformed": [],
+        })
+    );
+
+    let mut migrated = spawn("Javac", "//app:new", "remote", 500);
+    let digest = migrated.actual_outputs[0].digest.as_mut().unwrap();
+    digest.hash_function_name = "BLAKE3".to_string();
+    digest.hash.truncate(40);
+    write_log(&dir, "build.log", &[&build()[..1], &[migrated]].concat());
+    let document = report(&dir, &[]);
+    let digests = &document["digest_functions"];
+    assert_eq!(digests["functions"][0]["function"], "BLAKE3");
+    assert_eq!(digests["functions"][1]["function"], "SHA-256");
+    assert_eq!(digests["mixed"], true);
+    assert_eq!(digests["malformed_digests"], 1);
+    assert_eq!(
+        digests["malformed"],
+        json!([{
+            "path": "bazel-out/k8-fastbuild/bin///app:new.out",
+            "problem": "BLAKE3 hash has 40 hex characters, expected 64",
+        }])
+    );
+}
+
+#[test]
+fn estimated_savings_are_given_in_seconds_and_as_a_share_of_spawn_time() {
+    let dir = scratch_dir("json_report_savings");
+    // Five misses of 29.8s in all, out of 30.22s.
+    write_log(&dir, "build.log", &build());
+    let document = report(&dir, &[]);
+    let savings = &document["estimated_savings"];
+    assert_eq!(savings["cacheable_misses"], 5);
+    assert_eq!(savings["miss_time_nanos"], 29_800_000_000u64);
+    assert_eq!(savings["miss_output_bytes"], 5 * 1_024);
+    assert_eq!(savings["low_seconds"], 29.8);
+    assert_eq!(savings["high_seconds"], 29.8);
+    assert!((savings["low_percent"].as_f64().unwrap() - 29.8 / 30.22 * 100.0).abs() < 1e-9);
+    assert_eq!(savings["slow_bytes_per_second"], Value::Null);
+
+    // The hit of 1 KiB fetched in 1ms sets both rates; each miss pays 1ms to download.
+    let mut spawns = build();
+    spawns[1].metrics.as_mut().unwrap().fetch_time = duration(1);
+    write_log(&dir, "build.log", &spawns);
+    let document = report(&dir, &[]);
+    let savings = &document["estimated_savings"];
+    assert_eq!(savings["slow_bytes_per_second"], 1_024_000.0);
+    assert_eq!(savings["fast_bytes_per_second"], 1_024_000.0);
+    assert!((savings["low_seconds"].as_f64().unwrap() - 29.795).abs() < 1e-9, "{}", savings);
+    assert_eq!(savings["low_seconds"], savings["high_seconds"]);
+
+    write_log(&dir, "build.log", &build()[1..2]);
+    assert!(report(&dir, &[]).get("estimated_savings").is_none());
+}
+
+#[test]
+fn top_per_mnemonic_maps_each_mnemonic_to_its_slowest_actions_in_time_order() {
+    let dir = scratch_dir("json_report_top_per_mnemonic");
+    write_log(&dir, "build.log", &build());
+    let args = ["--top-per-mnemonic", "2", "--top-n", "3"];
+    let document = report(&dir, &args);
+    let top = document["top_per_mnemonic"].as_object().unwrap();
+    // CppLink is not among the three mnemonics with the most time.
+    assert_eq!(top.len(), 3);
+    assert_eq!(
+        top["Javac"],
+        json!([
+            {"label": "//app:lib", "total_nanos": 4_200_000_000u64, "cache_hit": false,
+             "runner": "linux-sandbox"},
+            {"label": "//core:base", "total_nanos": 1_800_000_000u64, "cache_hit": false,
+             "runner": "worker"},
+        ])
+    );
+    assert_eq!(top["CppCompile"][1]["cache_hit"], true);
+
+    // The object keeps the text report's order, most total time first.
+    let output = stdout(&dir, &[&["build.log", "--output-format", "json"], &args[..]].concat());
+    let section = &output[output.find("\"top_per_mnemonic\"").unwrap()..];
+    let at = |mnemonic: &str| section.find(&format!("\"{}\"", mnemonic)).unwrap();
+    assert!(at("TestRunner") < at("CppCompile") && at("CppCompile") < at("Javac"));
+
+    assert!(report(&dir, &[]).get("top_per_mnemonic").is_none());
+}
+
+#[test]
+fn concurrency_carries_the_bucket_series_or_null_without_timestamps() {
+    let dir = scratch_dir("json_report_concurrency");
+    // Two spawns start together and run for 2s and 1s; the third has no start time.
+    let mut spawns = vec![
+        spawn("Javac", "//app:lib", "local", 2_000),
+        spawn("Javac", "//app:util", "local", 1_000),
+        spawn("CppLink", "//native:bin", "local", 500),
+    ];
+    for spawn in &mut spawns[..2] {
+        spawn.metrics.as_mut().unwrap().start_time =
+            Some(prost_types::Timestamp { seconds: 1_700_000_000, nanos: 0 });
+    }
+    write_log(&dir, "build.log", &spawns);
+    let document = report(&dir, &["--concurrency", "--concurrency-below", "2"]);
+    assert_eq!(
+        document["concurrency"],
+        json!({
+            "bucket_nanos": 1_000_000_000u64,
+            "buckets": [2.0, 1.0],
+            "average": 1.5,
+            "peak": 2,
+            "below": 2,
+            "below_percent": 50.0,
+            "untimed_spawns": 1,
+        })
+    );
+
+    write_log(&dir, "build.log", &build());
+    let document = report(&dir, &["--concurrency"]);
+    assert!(document["concurrency"].is_null() && document.get("concurrency").is_some());
+    assert!(report(&dir, &[]).get("concurrency").is_none());
+}
+
+#[test]
+fn wall_clock_gives_the_first_start_and_last_end_in_rfc_3339() {
+    let dir = scratch_dir("json_report_wall_clock");
+    // From 1_700_000_000s to the end of the 12s test, which starts 8s in.
+    write_log(&dir, "build.log", &timed_build());
+    let document = report(&dir, &[]);
+    let wall_clock = &document["wall_clock"];
+    assert_eq!(wall_clock["first_start"], "2023-11-14T22:13:20.000Z");
+    assert_eq!(wall_clock["last_end"], "2023-11-14T22:13:40.000Z");
+    assert_eq!(wall_clock["span_nanos"], 20_000_000_000u64);
+    assert_eq!(wall_clock["plausible"], true);
+    let concurrency = wall_clock["average_concurrency"].as_f64().unwrap();
+    assert!((concurrency - 30.22 / 20.0).abs() < 1e-9, "{}", wall_clock);
+    assert_eq!(wall_clock["negative_durations"], 0);
+
+    let deterministic = report(&dir, &["--deterministic"]);
+    assert!(deterministic["wall_clock"].get("first_start").is_none());
+    assert_eq!(deterministic["wall_clock"]["span_nanos"], 20_000_000_000u64);
+
+    // A spawn eight days later makes the span implausible rather than the concurrency tiny.
+    let mut spawns = timed_build();
+    let start = spawns[0].metrics.as_mut().unwrap().start_time.as_mut().unwrap();
+    start.seconds += 8 * 86_400;
+    write_log(&dir, "build.log", &spawns);
+    let wall_clock = &report(&dir, &[])["wall_clock"];
+    assert_eq!(wall_clock["plausible"], false);
+    assert_eq!(wall_clock["average_concurrency"], Value::Null);
+
+    write_log(&dir, "build.log", &build());
+    assert!(report(&dir, &[]).get("wall_clock").is_none());
+}
+
+#[test]
+fn insights_are_listed_with_the_id_of_their_rule() {
+    let dir = scratch_dir("json_report_insights");
+    let mut spawns = build();
+    let mut failed = spawn("Genrule", "//tools:gen", "local", 10_000);
+    failed.exit_code = 1;
+    spawns.push(failed);
+    write_log(&dir, "build.log", &spawns);
+    let document = report(&dir, &["--insights"]);
+    let insights = document["insights"].as_array().unwrap();
+    let ids: Vec<&str> = insights.iter().map(|insight| insight["id"].as_str().unwrap()).collect();
+    assert_eq!(ids, ["poorly-cached-mnemonics", "costly-failures"]);
+    let message = insights[1]["message"].as_str().unwrap();
+    assert!(message.starts_with("1 failed actions took 10.0s"), "{}", message);
+
+    assert!(report(&dir, &[]).get("insights").is_none());
+}
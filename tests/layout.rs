@@ -0,0 +1,67 @@
+//! The top actions table against golden files, with short and long labels, so a change to how
+//! columns are sized or labels shortened shows up in review.
+
+mod common;
+
+use common::{assert_golden, build, scratch_dir, spawn, stdout, write_log};
+
+/// The lines of the report section under `heading`, up to the blank line that ends it.
+fn section(report: &str, heading: &str) -> String {
+    let lines: Vec<&str> = report
+        .lines()
+        .skip_while(|line| !line.contains(heading))
+        .take_while(|line| !line.is_empty())
+        .collect();
+    assert!(!lines.is_empty(), "no {} section in\n{}", heading, report);
+    lines.join("\n") + "\n"
+}
+
+/// Long labels deep in the tree, a long mnemonic and an action that takes hours.
+fn deep_build() -> Vec<common::SpawnExec> {
+    vec![
+        spawn(
+            "GoCompilePkg",
+            "//services/payments/processing/internal/reconciliation/adapters/legacy:ledger_sync",
+            "linux-sandbox",
+            3 * 3600 * 1000 + 25_000,
+        ),
+        spawn(
+            "TypeScriptCompileAndBundleWithSourceMaps",
+            "//frontend/checkout/components/payment_form:payment_form_bundle",
+            "remote",
+            654_321,
+        ),
+        spawn(
+            "Javac",
+            "@maven//:com_google_guava_guava_jre_with_a_very_long_artifact_name_for_testing",
+            "worker",
+            1_500,
+        ),
+        spawn("CppLink", "//a:b", "local", 20),
+    ]
+}
+
+fn top_actions(dir: &std::path::Path, args: &[&str]) -> String {
+    section(&stdout(dir, args), "Slowest Actions ---")
+}
+
+#[test]
+fn short_labels_are_left_alone() {
+    let dir = scratch_dir("layout_narrow");
+    write_log(&dir, "build.log", &build());
+    assert_golden("layout_short.txt", &top_actions(&dir, &["build.log", "--max-width", "40"]));
+}
+
+#[test]
+fn long_labels_are_shortened_to_the_width() {
+    let dir = scratch_dir("layout_wide");
+    write_log(&dir, "build.log", &deep_build());
+    let narrow = top_actions(&dir, &["build.log", "--max-width", "80"]);
+    assert!(narrow.lines().all(|line| line.chars().count() <= 80), "{}", narrow);
+    assert_golden("layout_long_80.txt", &narrow);
+    assert_golden("layout_long_120.txt", &top_actions(&dir, &["build.log", "--max-width", "120"]));
+    assert_golden(
+        "layout_long_full.txt",
+        &top_actions(&dir, &["build.log", "--max-width", "80", "--full-labels"]),
+    );
+}
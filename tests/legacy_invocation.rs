@@ -0,0 +1,44 @@
+//! `analyze` names the default mode: with or without it, and wherever the flags go after it, a
+//! log gets the same report as the bare invocation that predates the subcommands.
+
+mod common;
+
+use common::{build, run, scratch_dir, stdout, write_log};
+
+#[test]
+fn analyze_prints_the_report_of_the_bare_invocation() {
+    let dir = scratch_dir("legacy_invocation");
+    write_log(&dir, "build.log", &build());
+    let invocations: [(&[&str], &[&str]); 4] = [
+        (&["build.log"], &["analyze", "build.log"]),
+        (&["--no-color", "build.log"], &["analyze", "--no-color", "build.log"]),
+        (&["--top-n", "3", "build.log"], &["analyze", "build.log", "--top-n", "3"]),
+        (&["build.log", "--percentiles"], &["analyze", "--percentiles", "build.log"]),
+    ];
+    for (legacy, explicit) in invocations {
+        assert_eq!(stdout(&dir, legacy), stdout(&dir, explicit), "{:?}", explicit);
+    }
+}
+
+#[test]
+fn analyze_has_its_own_help_with_the_report_flags() {
+    let dir = scratch_dir("analyze_help");
+    let help = stdout(&dir, &["analyze", "--help"]);
+    assert!(help.contains("Usage: bzl-exec-log-analyzer analyze [OPTIONS] <LOGS>..."), "{}", help);
+    assert!(help.contains("--top-n <TOP_N>") && help.contains("--exclude-failed"), "{}", help);
+    assert!(!help.contains("Commands:"), "{}", help);
+}
+
+#[test]
+fn a_log_named_analyze_is_analyzed_rather_than_dropped() {
+    let dir = scratch_dir("log_named_analyze");
+    write_log(&dir, "analyze", &build());
+    // --deterministic names the log by its base name however it is given.
+    let report = stdout(&dir, &["./analyze", "--deterministic"]);
+    assert_eq!(report, stdout(&dir, &["analyze", "analyze", "--deterministic"]));
+    // Behind a flag it is a log path, as any other argument there.
+    assert_eq!(stdout(&dir, &["--deterministic", "analyze"]), report);
+    let output = run(&dir, &["analyze"]);
+    assert_eq!(output.status.code(), Some(2));
+    assert!(String::from_utf8_lossy(&output.stderr).contains("<LOGS>"));
+}
@@ -0,0 +1,29 @@
+//! --max-memory trades spawn details for memory; the default report must not change.
+
+mod common;
+
+use common::{build, changed_build, run, scratch_dir, stdout, write_log};
+
+#[test]
+fn the_default_report_is_the_same_with_slim_spawns() {
+    let dir = scratch_dir("max_memory_report");
+    write_log(&dir, "build.log", &[build(), changed_build()].concat());
+    let full = stdout(&dir, &["build.log"]);
+    let slim = run(&dir, &["build.log", "--max-memory", "1B"]);
+    assert!(slim.status.success());
+    assert!(
+        String::from_utf8_lossy(&slim.stderr).contains("keeping only what the report reads"),
+        "the log should not fit in 1B"
+    );
+    assert_eq!(String::from_utf8(slim.stdout).unwrap(), full);
+}
+
+#[test]
+fn reports_that_need_whole_spawns_are_refused() {
+    let dir = scratch_dir("max_memory_refused");
+    write_log(&dir, "build.log", &build());
+    let output = run(&dir, &["build.log", "--max-memory", "1B", "--show-args"]);
+    assert!(!output.status.success());
+    let stderr = String::from_utf8_lossy(&output.stderr);
+    assert!(stderr.contains("--show-args needs every spawn in full"), "{}", stderr);
+}
@@ -0,0 +1,70 @@
+//! An --output-format added the way a program using the crate would add one, through the
+//! public API only.
+
+mod common;
+
+use bzl_exec_log_parser::report::{self, OutputWriter, Renderer, Report};
+use bzl_exec_log_parser::Cli;
+use clap::Parser;
+use common::{build, run, stdout};
+use std::io::{self, Write};
+
+/// One line per mnemonic with its action count, as a downstream format might.
+struct MnemonicCounts;
+
+impl Renderer for MnemonicCounts {
+    fn render(&self, report: &Report, out: &mut dyn Write) -> io::Result<()> {
+        for row in &report.mnemonics {
+            writeln!(out, "{} {}", row.mnemonic, row.metrics.count)?;
+        }
+        Ok(())
+    }
+}
+
+fn mnemonic_counts(_: &Cli) -> Box<dyn Renderer + '_> {
+    Box::new(MnemonicCounts)
+}
+
+const MNEMONIC_COUNTS: OutputWriter = OutputWriter {
+    name: "mnemonic-counts",
+    extension: "txt",
+    description: "Actions per mnemonic",
+    renderer: mnemonic_counts,
+};
+
+#[test]
+fn a_registered_writer_is_listed_and_renders_the_report() {
+    let args = ["bzl-exec-log-analyzer", "build.log", "--output-format", "mnemonic-counts"];
+    assert!(Cli::try_parse_from(args).is_err());
+
+    report::register(MNEMONIC_COUNTS);
+    let mut listing = Vec::new();
+    report::write_output_writers(&mut listing).unwrap();
+    let listing = String::from_utf8(listing).unwrap();
+    assert!(listing.contains("  text "), "{}", listing);
+    assert!(listing.contains("mnemonic-counts  Actions per mnemonic (.txt)"), "{}", listing);
+
+    let cli = Cli::try_parse_from(args).unwrap();
+    let writer = report::output_writer(&cli.output_format).unwrap();
+    let spawns = build();
+    let report = Report::new(&spawns, &cli, None);
+    let mut out = Vec::new();
+    (writer.renderer)(&cli).render(&report, &mut out).unwrap();
+    assert_eq!(
+        String::from_utf8(out).unwrap(),
+        "TestRunner 1\nCppCompile 2\nJavac 3\nCppLink 1\n"
+    );
+}
+
+#[test]
+fn output_format_help_lists_the_built_in_writers_without_logs() {
+    let dir = common::scratch_dir("output_format_help");
+    for args in [&["--output-format", "help"][..], &["--output-format=help"]] {
+        let listing = stdout(&dir, args);
+        assert!(listing.starts_with("Formats of --output-format:\n"), "{}", listing);
+        assert!(listing.contains("  json  "), "{}", listing);
+    }
+    // A subcommand's --output-format is its own.
+    let diff = run(&dir, &["diff", "a.log", "b.log", "--output-format", "help"]);
+    assert_eq!(diff.status.code(), Some(2));
+}
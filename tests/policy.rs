@@ -0,0 +1,103 @@
+//! The policy subcommand on a small build: its table, its exit status and the errors of
+//! invalid policy files.
+
+mod common;
+
+use common::{build, run, scratch_dir, stdout, write_log};
+
+const POLICY: &str = r#"
+[[rule]]
+name = "Java compiles are cached"
+mnemonic = "Javac"
+min-hit-rate = "30%"
+must-be-cacheable = true
+
+[[rule]]
+label = "//native/..."
+must-be-remotable = true
+max-average-duration = "5s"
+
+[[rule]]
+mnemonic = "GoCompile*"
+min-hit-rate = 90
+"#;
+
+/// The cells of the rows of the policy table.
+fn rows(report: &str) -> Vec<Vec<&str>> {
+    report
+        .lines()
+        .filter(|line| line.contains(" | "))
+        .skip(1)
+        .map(|line| line.split('|').map(str::trim).collect())
+        .collect()
+}
+
+#[test]
+fn each_constraint_gets_a_row_and_rules_matching_nothing_are_not_exercised() {
+    let dir = scratch_dir("policy_pass");
+    write_log(&dir, "build.log", &build());
+    std::fs::write(dir.join("policy.toml"), POLICY).unwrap();
+    let report = stdout(&dir, &["policy", "policy.toml", "build.log"]);
+    assert_eq!(
+        rows(&report),
+        [
+            ["Java compiles are cached", "3", "Cache hit rate", "33.33%", ">= 30.00%", "PASS"],
+            ["", "", "Cacheable", "3 of 3", "all", "PASS"],
+            ["//native/...", "3", "Remotable", "3 of 3", "all", "PASS"],
+            ["", "", "Average duration", "3.97s", "<= 5.00s", "PASS"],
+            ["GoCompile*", "0", "Cache hit rate", "-", ">= 90.00%", "NOT EXERCISED"],
+        ],
+        "{}",
+        report
+    );
+    let summary = "0 of 5 checks failed; 1 of 3 rules matched no spawns.";
+    assert!(report.contains(summary), "{}", report);
+}
+
+#[test]
+fn a_failed_check_lists_the_offending_actions_and_exits_non_zero() {
+    let dir = scratch_dir("policy_fail");
+    let mut spawns = build();
+    spawns[3].remotable = false;
+    write_log(&dir, "build.log", &spawns);
+    std::fs::write(dir.join("policy.toml"), POLICY.replace("\"5s\"", "\"3s\"")).unwrap();
+    let output = run(&dir, &["policy", "policy.toml", "build.log"]);
+    let report = String::from_utf8_lossy(&output.stdout);
+    assert_eq!(output.status.code(), Some(6), "{}", report);
+    let native = &rows(&report)[2..4];
+    assert_eq!(native[0][3..], ["2 of 3", "all", "FAIL"], "{}", report);
+    assert_eq!(native[1][3..], ["3.97s", "<= 3.00s", "FAIL"], "{}", report);
+    let offending = "Actions of '//native/...' not remotable:\n  CppCompile | //native:codec | ";
+    assert!(report.contains(offending), "{}", report);
+    assert!(report.contains("2 of 5 checks failed"), "{}", report);
+}
+
+#[test]
+fn invalid_rules_are_reported_with_their_line() {
+    let dir = scratch_dir("policy_invalid");
+    write_log(&dir, "build.log", &build());
+    for (policy, error) in [
+        ("", "policy.toml: the policy has no rules"),
+        (
+            "[[rule]]\nmnemonic = 'Javac'\nmin-hit-rate = 120\n",
+            "policy.toml:1: invalid rule: min-hit-rate must be between 0 and 100%",
+        ),
+        (
+            "\n[[rule]]\nmnemonic = 'Javac'\nmin-hits = 90\n",
+            "policy.toml:4: unknown field `min-hits`",
+        ),
+        (
+            concat!(
+                "[[rule]]\nlabel = '//a/...'\nmust-be-cacheable = true\n\n",
+                "[[rule]]\nmust-be-remotable = true\n",
+            ),
+            "policy.toml:5: invalid rule: it selects no spawns",
+        ),
+    ] {
+        std::fs::write(dir.join("policy.toml"), policy).unwrap();
+        let output = run(&dir, &["policy", "policy.toml", "build.log"]);
+        let stderr = String::from_utf8_lossy(&output.stderr);
+        assert_eq!(output.status.code(), Some(1), "{}", stderr);
+        assert!(stderr.contains(error), "{:?}: {}", policy, stderr);
+    }
+}
@@ -0,0 +1,113 @@
+//! --cpuprofile and --memprofile write pprof profiles of the run.
+#![cfg(any(all(target_os = "linux", target_env = "gnu"), target_os = "macos"))]
+
+mod common;
+
+use common::{file, run, scratch_dir, spawn, stdout, write_log, SpawnExec};
+
+/// A log large enough for the parse to take several CPU samples, even in an optimized build.
+fn large_build() -> Vec<SpawnExec> {
+    (0..20_000)
+        .map(|index| {
+            let mut compile = spawn("Javac", &format!("//pkg{}:lib", index % 300), "worker", 900);
+            compile.inputs = (0..15)
+                .map(|input| file(&format!("pkg{}/src/F{}_{}.java", index % 300, index, input), 512))
+                .collect();
+            compile
+        })
+        .collect()
+}
+
+/// The fields of a protobuf message as (field number, varint or bytes), which is all a profile
+/// is made of.
+fn fields(mut message: &[u8]) -> Vec<(u64, Result<u64, &[u8]>)> {
+    fn varint(bytes: &mut &[u8]) -> u64 {
+        let mut value = 0;
+        for shift in (0..64).step_by(7) {
+            let byte = bytes[0];
+            *bytes = &bytes[1..];
+            value |= u64::from(byte & 0x7f) << shift;
+            if byte < 0x80 {
+                break;
+            }
+        }
+        value
+    }
+    let mut fields = Vec::new();
+    while !message.is_empty() {
+        let key = varint(&mut message);
+        let value = match key & 7 {
+            0 => Ok(varint(&mut message)),
+            2 => {
+                let length = varint(&mut message) as usize;
+                let (data, rest) = message.split_at(length);
+                message = rest;
+                Err(data)
+            }
+            wire_type => panic!("unexpected wire type {}", wire_type),
+        };
+        fields.push((key >> 3, value));
+    }
+    fields
+}
+
+/// The string table, sample types and number of samples of a profile.
+fn summary(profile: &[u8]) -> (Vec<String>, Vec<(String, String)>, usize) {
+    let fields = fields(profile);
+    let strings: Vec<String> = fields
+        .iter()
+        .filter(|(field, _)| *field == 6)
+        .map(|(_, value)| String::from_utf8(value.unwrap_err().to_vec()).unwrap())
+        .collect();
+    let sample_types = fields
+        .iter()
+        .filter(|(field, _)| *field == 1)
+        .map(|(_, value)| {
+            let value_type = self::fields(value.unwrap_err());
+            let name = |index: usize| strings[value_type[index].1.unwrap() as usize].clone();
+            (name(0), name(1))
+        })
+        .collect();
+    let samples = fields.iter().filter(|(field, _)| *field == 2).count();
+    (strings, sample_types, samples)
+}
+
+#[test]
+fn both_profiles_are_written_and_hold_samples() {
+    let dir = scratch_dir("profile");
+    write_log(&dir, "build.log", &large_build());
+    let plain = stdout(&dir, &["build.log", "--hot-inputs"]);
+    let profiled = stdout(
+        &dir,
+        &["build.log", "--hot-inputs", "--cpuprofile", "cpu.pprof", "--memprofile=mem.pprof"],
+    );
+    assert_eq!(profiled, plain, "the profiles go to their files only");
+
+    let cpu = std::fs::read(dir.join("cpu.pprof")).unwrap();
+    let (strings, sample_types, samples) = summary(&cpu);
+    assert_eq!(strings[0], "");
+    assert_eq!(
+        sample_types,
+        [("samples".into(), "count".into()), ("cpu".into(), "nanoseconds".into())]
+    );
+    assert!(samples > 0, "no CPU samples in {} bytes", cpu.len());
+
+    let memory = std::fs::read(dir.join("mem.pprof")).unwrap();
+    let (_, sample_types, samples) = summary(&memory);
+    assert_eq!(
+        sample_types,
+        [("samples".into(), "count".into()), ("alloc_space".into(), "bytes".into())]
+    );
+    assert!(samples > 0, "no memory samples in {} bytes", memory.len());
+}
+
+#[test]
+fn a_profile_that_cannot_be_created_fails_the_run_before_parsing() {
+    let dir = scratch_dir("profile_bad_path");
+    write_log(&dir, "build.log", &large_build()[..10]);
+    let output = run(&dir, &["build.log", "--cpuprofile", "missing/cpu.pprof"]);
+    let stderr = String::from_utf8_lossy(&output.stderr);
+    assert_eq!(output.status.code(), Some(7), "{}", stderr);
+    assert!(stderr.contains("--cpuprofile missing/cpu.pprof: "), "{}", stderr);
+    assert!(output.stdout.is_empty());
+}
@@ -0,0 +1,83 @@
+//! Paths recorded on a Linux and a macOS machine, which --relative-paths prints and counts
+//! as the same files.
+
+mod common;
+
+use common::{file, scratch_dir, spawn, stdout, write_log, SpawnExec};
+
+const LINUX_SANDBOX: &str = concat!(
+    "/home/ci/.cache/bazel/_bazel_ci/0fa3b1c2d4e5f60718293a4b5c6d7e8f",
+    "/sandbox/linux-sandbox/42/execroot/myrepo"
+);
+const MACOS_EXECROOT: &str = concat!(
+    "/private/var/tmp/_bazel_dev/5e8c0d1f2a3b4c5d6e7f8091a2b3c4d5",
+    "/execroot/myrepo"
+);
+
+/// A compile of //app:lib run with its execroot at `root`.
+fn compile(root: &str, runner: &str) -> SpawnExec {
+    let mut compile = spawn("Javac", "//app:lib", runner, 2_000);
+    compile.command_args = vec![
+        "javac".to_string(),
+        format!("-sourcepath={}/app", root),
+        format!("@{}/bazel-out/bin/app/lib.params", root),
+    ];
+    compile.inputs = ["app/Lib.java", "app/Util.java"]
+        .map(|path| file(&format!("{}/{}", root, path), 2_048))
+        .to_vec();
+    compile
+}
+
+fn logs(test: &str) -> std::path::PathBuf {
+    let dir = scratch_dir(test);
+    write_log(&dir, "linux.log", &[compile(LINUX_SANDBOX, "linux-sandbox")]);
+    write_log(&dir, "macos.log", &[compile(MACOS_EXECROOT, "darwin-sandbox")]);
+    dir
+}
+
+/// The spawn count --hot-inputs prints for `path`, if it has a row.
+fn hot_input(report: &str, path: &str) -> Option<String> {
+    report
+        .lines()
+        .find(|line| line.ends_with(&format!("| {}", path)))
+        .map(|line| line.split('|').next().unwrap().trim().to_string())
+}
+
+#[test]
+fn inputs_of_both_machines_are_keyed_on_the_same_path() {
+    let dir = logs("relative_paths_hot_inputs");
+    let report = stdout(&dir, &["linux.log", "macos.log", "--hot-inputs"]);
+    assert_eq!(hot_input(&report, "app/Lib.java").as_deref(), Some("2"), "{}", report);
+    assert_eq!(hot_input(&report, "app/Util.java").as_deref(), Some("2"), "{}", report);
+    assert!(!report.contains("/execroot/"), "{}", report);
+
+    let recorded = stdout(
+        &dir,
+        &["linux.log", "macos.log", "--hot-inputs", "--relative-paths=false"],
+    );
+    for root in [LINUX_SANDBOX, MACOS_EXECROOT] {
+        let path = format!("{}/app/Lib.java", root);
+        assert_eq!(hot_input(&recorded, &path).as_deref(), Some("1"), "{}", recorded);
+    }
+}
+
+#[test]
+fn command_lines_are_printed_relative_to_the_execroot() {
+    let dir = logs("relative_paths_args");
+    let report = stdout(&dir, &["linux.log", "macos.log", "--show-args"]);
+    let printed = "$ javac -sourcepath=app @bazel-out/bin/app/lib.params";
+    assert_eq!(report.matches(printed).count(), 2, "{}", report);
+
+    let recorded = stdout(&dir, &["macos.log", "--show-args", "--relative-paths=false"]);
+    assert!(recorded.contains(&format!("-sourcepath={}/app", MACOS_EXECROOT)), "{}", recorded);
+}
+
+#[test]
+fn a_prefix_the_layout_does_not_show_is_stripped_when_given() {
+    let dir = scratch_dir("relative_paths_extra_prefix");
+    write_log(&dir, "remote.log", &[compile("/b/f/w", "remote")]);
+    let report = stdout(&dir, &["remote.log", "--hot-inputs"]);
+    assert!(hot_input(&report, "/b/f/w/app/Lib.java").is_some(), "{}", report);
+    let report = stdout(&dir, &["remote.log", "--hot-inputs", "--strip-path-prefix", "/b/f/w/"]);
+    assert!(hot_input(&report, "app/Lib.java").is_some(), "{}", report);
+}
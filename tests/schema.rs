@@ -0,0 +1,74 @@
+//! Every section of the machine-readable output against golden files, so that renaming or
+//! retyping a field shows up in review and is weighed against the schema_version policy.
+
+mod common;
+
+use common::{assert_golden, scratch_dir, stdout, timed_build, write_log};
+
+#[test]
+fn a_fully_populated_report_matches_the_golden_file() {
+    let dir = scratch_dir("schema_report");
+    write_log(&dir, "build.log", &timed_build());
+    let json = stdout(
+        &dir,
+        &[
+            "build.log",
+            "--output-format",
+            "json",
+            "--deterministic",
+            "--exclude-failed",
+            "--include-spawns=all",
+            "--group-by",
+            "mnemonic,runner",
+            "--cache-metrics",
+            "--idle-gaps",
+            "--concurrency",
+            "--insights",
+            "--explain",
+            "//app:lib",
+            "--histogram",
+            "--data-volume",
+            "--show-args",
+            "--top-per-mnemonic",
+            "2",
+            "--top-n",
+            "3",
+        ],
+    );
+    let document: serde_json::Value = serde_json::from_str(&json).unwrap();
+    for section in [
+        "schema_version",
+        "durations",
+        "wall_clock",
+        "groups",
+        "downloads",
+        "idle_gaps",
+        "concurrency",
+        "insights",
+        "explain",
+        "filter",
+        "failed_actions",
+        "time_by_phase",
+        "fetches",
+        "data_volume",
+        "estimated_savings",
+        "digest_functions",
+        "histogram",
+        "top_actions",
+        "top_per_mnemonic",
+        "spawns",
+    ] {
+        assert!(!document[section].is_null(), "{} is missing", section);
+    }
+    assert_golden("schema_report.json", &json);
+}
+
+#[test]
+fn listing_records_and_the_ci_summary_line_match_the_golden_files() {
+    let dir = scratch_dir("schema_records");
+    write_log(&dir, "build.log", &timed_build());
+    let records = stdout(&dir, &["build.log", "--all", "--listing-format", "jsonl"]);
+    assert_golden("schema_records.jsonl", &records);
+    let summary = stdout(&dir, &["build.log", "--ci-summary", "--quiet"]);
+    assert_golden("schema_ci_summary.txt", &summary);
+}
@@ -0,0 +1,80 @@
+//! Other Bazel files passed for an execution log are named in the parse error, and valid logs
+//! are never mistaken for them.
+
+mod common;
+
+use common::{build, run, scratch_dir, spawn, stdout, write_log};
+use prost::Message;
+
+/// A `BuildEvent` as `--build_event_binary_file` writes it: field 1 holds its id, a message
+/// whose `started` field is a message of its own.
+fn build_event_stream() -> Vec<u8> {
+    // BuildEventId { started: BuildStartedId {} }, then BuildEvent { id, children: [id] }.
+    let id = [0x1a, 0x00];
+    let mut event = vec![0x0a, id.len() as u8];
+    event.extend(id);
+    event.extend([0x12, id.len() as u8]);
+    event.extend(id);
+    let mut stream = vec![event.len() as u8];
+    stream.extend(event);
+    stream
+}
+
+#[test]
+fn each_kind_of_wrong_input_is_named() {
+    let dir = scratch_dir("wrong_input");
+    let fixtures: [(&str, &[u8], &str); 6] = [
+        (
+            "bep.json",
+            br#"{"id":{"started":{}},"children":[{"progress":{}}],"started":{"uuid":"1"}}"#,
+            "a Build Event Protocol JSON file (--build_event_json_file)",
+        ),
+        (
+            "bep.bin",
+            &build_event_stream(),
+            "a binary Build Event Protocol file (--build_event_binary_file)",
+        ),
+        (
+            "profile.json",
+            br#"{"otherData":{"bazel_version":"7.4.0"},"traceEvents":[{"name":"x","ph":"X"}]}"#,
+            "a Bazel --profile trace",
+        ),
+        (
+            "profile.json.gz",
+            &[0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x03],
+            "a gzip-compressed file",
+        ),
+        (
+            "exec.json",
+            br#"{"commandArgs":["javac"],"listedOutputs":["out.jar"],"mnemonic":"Javac"}"#,
+            "a JSON execution log (--execution_log_json_file), which this tool does not read",
+        ),
+        ("other.json", br#"{"name":"not a log"}"#, "a JSON file, not an execution log"),
+    ];
+    for (name, contents, named) in fixtures {
+        std::fs::write(dir.join(name), contents).unwrap();
+        let output = run(&dir, &[name]);
+        let stderr = String::from_utf8_lossy(&output.stderr);
+        assert_eq!(output.status.code(), Some(8), "{}: {}", name, stderr);
+        assert!(stderr.contains(named), "{}: {}", name, stderr);
+        assert!(stderr.contains("--execution_log_binary_file"), "{}: {}", name, stderr);
+    }
+}
+
+#[test]
+fn logs_whose_spawns_look_like_json_are_still_logs() {
+    let dir = scratch_dir("wrong_input_valid");
+    let mut json_args = spawn("Genrule", "//tools:gen", "local", 800);
+    json_args.command_args = vec![
+        r#"{"id":{"started":{}},"children":[],"traceEvents":[]}"#.to_string(),
+        "--profile=trace.json.gz".to_string(),
+    ];
+    write_log(&dir, "build.log", &[vec![json_args], build()].concat());
+    let report = stdout(&dir, &["build.log"]);
+    assert!(report.contains("Total Actions: 8"), "{}", report);
+
+    // A log of one spawn, the smallest that could be taken for something else.
+    let single = spawn("Javac", "//app:lib", "linux-sandbox", 100);
+    std::fs::write(dir.join("single.log"), single.encode_length_delimited_to_vec()).unwrap();
+    assert!(stdout(&dir, &["single.log"]).contains("Total Actions: 1"));
+}